@@ -0,0 +1,26 @@
+#!/bin/bash
+
+# Nagios / Icinga notification command for the self-healing operator.
+#
+# Nagios (commands.cfg):
+#   define command {
+#     command_name  notify-service-by-selfhealing
+#     command_line  /usr/local/bin/nagios-notify-selfhealing.sh \
+#       type="$NOTIFICATIONTYPE$" host="$HOSTNAME$" service="$SERVICEDESC$" \
+#       state="$SERVICESTATE$" output="$SERVICEOUTPUT$" \
+#       namespace="$_SERVICENAMESPACE$" app="$_SERVICEAPP$" recovery_action="$_SERVICERECOVERY_ACTION$"
+#   }
+# and set _NAMESPACE, _APP and _RECOVERY_ACTION custom variables on the service.
+#
+# Icinga 2: use the same script from a NotificationCommand with
+#   arguments = { "type" = "$notification.type$", ... } passed as key=value pairs,
+# and SELFHEALING_URL=.../webhook/icinga.
+
+SELFHEALING_URL="${SELFHEALING_URL:-http://self-healing-operator.default.svc:8080/webhook/nagios}"
+
+ARGS=()
+for kv in "$@"; do
+  ARGS+=(--data-urlencode "$kv")
+done
+
+curl -sf --max-time 10 -X POST "$SELFHEALING_URL" "${ARGS[@]}" > /dev/null
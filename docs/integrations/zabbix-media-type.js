@@ -0,0 +1,25 @@
+// Zabbix webhook media type script for the self-healing operator.
+//
+// Media type parameters (Administration → Media types → Webhook):
+//   url             http://self-healing-operator.default.svc:8080/webhook/zabbix
+//   event_id        {EVENT.ID}
+//   event_value     {EVENT.VALUE}
+//   event_severity  {EVENT.SEVERITY}
+//   host            {HOST.NAME}
+//   trigger         {TRIGGER.NAME}
+//   message         {ALERT.MESSAGE}
+//   tags            {EVENT.TAGSJSON}
+//
+// Tag triggers with namespace, app and recovery_action (and pod, runbook, …)
+// to select the workload and remediation.
+var params = JSON.parse(value);
+var url = params.url;
+delete params.url;
+
+var req = new HttpRequest();
+req.addHeader('Content-Type: application/json');
+var resp = req.post(url, JSON.stringify(params));
+if (req.getStatus() !== 200) {
+    throw 'self-healing operator returned ' + req.getStatus() + ': ' + resp;
+}
+return 'OK';
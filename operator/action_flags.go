@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Action feature flags: turn an action type off cluster-wide, instantly and
+// without touching alert rules or policies — e.g. every node drain during a
+// storage migration. A name can be a recovery action (restart, scale, ...), a
+// runbook (node-pressure-drain) or a workflow step action (cordon_node).
+//
+// DISABLED_ACTIONS (comma-separated) turns names off from the environment.
+// ACTION_FLAGS_FILE, mounted from the self-healing-action-flags ConfigMap,
+// overrides it per name and is re-read every ACTION_FLAGS_RELOAD, so
+// `kubectl edit configmap` takes effect within a minute or so (the kubelet
+// syncs the mount first) without a restart:
+//
+//	actions:
+//	  node-pressure-drain: false
+//	  cordon_node: false
+//	  restart: true
+//
+// A turned-off action is skipped when the alert is decided (the "feature-flag"
+// check); so is a workflow with a turned-off step. Actions already decided
+// are checked again before they run, and a workflow step turned off mid-run
+// fails, following its onFailure branch. A file that doesn't parse keeps the
+// previous flags; an unknown name is logged, since a typo would leave the
+// action on.
+
+var (
+	actionFlagsFile   = envString("ACTION_FLAGS_FILE", "/etc/self-healing/action-flags.yaml")
+	actionFlagsReload = envDuration("ACTION_FLAGS_RELOAD", 10*time.Second)
+
+	actionFlagsMu      sync.RWMutex
+	actionFlags        = map[string]bool{} // name -> enabled, only names that are set
+	actionFlagsData    []byte              // the file as last loaded
+	actionFlagsLoaded  bool
+	actionFlagsReloads = map[string]int{} // result
+)
+
+// recoveryActionNames are the actions recoveryActions runs, sorted
+var recoveryActionNames []string
+
+type actionFlagsConfig struct {
+	Actions map[string]bool `json:"actions"`
+}
+
+func init() {
+	registerMetrics(writeActionFlagMetrics)
+}
+
+func setupActionFlags() error {
+	if _, err := reloadActionFlags(); err != nil {
+		return err
+	}
+	if actionFlagsReload <= 0 {
+		return nil
+	}
+	go func() {
+		ticker := time.NewTicker(actionFlagsReload)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-operatorCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			if _, err := reloadActionFlags(); err != nil {
+				log.Printf("Keeping the previous action flags — %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// reloadActionFlags re-reads ACTION_FLAGS_FILE if it changed
+func reloadActionFlags() (bool, error) {
+	data, err := os.ReadFile(actionFlagsFile)
+	if err != nil && !os.IsNotExist(err) {
+		countActionFlagReload("error")
+		return false, fmt.Errorf("failed to read %s: %v", actionFlagsFile, err)
+	}
+	actionFlagsMu.RLock()
+	same := actionFlagsLoaded && bytes.Equal(data, actionFlagsData)
+	actionFlagsMu.RUnlock()
+	if same {
+		return false, nil
+	}
+
+	var cfg actionFlagsConfig
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		// remember the content, so a bad file is reported once rather than every reload
+		actionFlagsMu.Lock()
+		actionFlagsData, actionFlagsLoaded = data, true
+		actionFlagsMu.Unlock()
+		countActionFlagReload("error")
+		return false, fmt.Errorf("failed to parse %s: %v", actionFlagsFile, err)
+	}
+	flags := map[string]bool{}
+	for _, name := range envList("DISABLED_ACTIONS") {
+		flags[name] = false
+	}
+	for name, enabled := range cfg.Actions {
+		flags[name] = enabled
+	}
+	for _, name := range sortedKeys(flags) {
+		if !knownActionName(name) {
+			log.Printf("Action flag %q doesn't name a recovery action, runbook or workflow step action", name)
+		}
+	}
+
+	actionFlagsMu.Lock()
+	old := actionFlags
+	actionFlags, actionFlagsData, actionFlagsLoaded = flags, data, true
+	actionFlagsMu.Unlock()
+	countActionFlagReload("loaded")
+
+	for _, name := range sortedKeys(flags) {
+		if was, ok := old[name]; !ok && !flags[name] || ok && was != flags[name] {
+			log.Printf("Action %s turned %s by feature flag", name, onOff(flags[name]))
+		}
+	}
+	for _, name := range sortedKeys(old) {
+		if _, ok := flags[name]; !ok && !old[name] {
+			log.Printf("Action %s turned on by feature flag", name)
+		}
+	}
+	return true, nil
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
+
+func knownActionName(name string) bool {
+	for _, a := range recoveryActionNames {
+		if a == name {
+			return true
+		}
+	}
+	_, runbook := runbooks[name]
+	_, step := workflowActions[name]
+	return runbook || step
+}
+
+// actionTurnedOff reports whether a feature flag turned the name off
+func actionTurnedOff(name string) bool {
+	actionFlagsMu.RLock()
+	defer actionFlagsMu.RUnlock()
+	enabled, ok := actionFlags[name]
+	return ok && !enabled
+}
+
+// actionFlagRefusal says why feature flags keep the action from running, or ""
+func actionFlagRefusal(action *RecoveryAction) string {
+	if actionTurnedOff(action.Action) {
+		return fmt.Sprintf("action %s is turned off by feature flag", action.Action)
+	}
+	if action.Action == "runbook" && actionTurnedOff(action.Runbook) {
+		return fmt.Sprintf("runbook %s is turned off by feature flag", action.Runbook)
+	}
+	if action.Action == "workflow" {
+		workflowsMu.RLock()
+		wf := workflows[action.Workflow]
+		workflowsMu.RUnlock()
+		if wf == nil {
+			return ""
+		}
+		for _, step := range wf.Steps {
+			if actionTurnedOff(step.Action) {
+				return fmt.Sprintf("workflow %s has step %s, and %s is turned off by feature flag", wf.Name, step.Name, step.Action)
+			}
+		}
+	}
+	return ""
+}
+
+func countActionFlagReload(result string) {
+	actionFlagsMu.Lock()
+	actionFlagsReloads[result]++
+	actionFlagsMu.Unlock()
+}
+
+func writeActionFlagMetrics(w io.Writer) {
+	actionFlagsMu.RLock()
+	defer actionFlagsMu.RUnlock()
+	fmt.Fprintln(w, "# HELP selfhealing_action_enabled Whether a feature flag leaves the action on, for every action with a flag set.")
+	fmt.Fprintln(w, "# TYPE selfhealing_action_enabled gauge")
+	for _, name := range sortedKeys(actionFlags) {
+		enabled := 0
+		if actionFlags[name] {
+			enabled = 1
+		}
+		fmt.Fprintf(w, "selfhealing_action_enabled{action=%q} %d\n", name, enabled)
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_action_flag_reloads_total Action flag file loads, by result (loaded, error).")
+	fmt.Fprintln(w, "# TYPE selfhealing_action_flag_reloads_total counter")
+	for _, result := range sortedKeys(actionFlagsReloads) {
+		fmt.Fprintf(w, "selfhealing_action_flag_reloads_total{result=%q} %d\n", result, actionFlagsReloads[result])
+	}
+}
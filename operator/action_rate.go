@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Action rate limit: ACTION_RATE_LIMIT caps how many recovery actions this
+// replica starts per minute (0 = no cap). Past it, actions are skipped until
+// the next minute and counted in selfhealing_actions_rate_limited_total;
+// Alertmanager re-sends alerts that are still firing. On-call can tighten or
+// loosen it mid-incident with the action-rate-limit tuning setting.
+
+var (
+	actionRateMu      sync.Mutex
+	actionRateLimit   = envInt("ACTION_RATE_LIMIT", 0)
+	actionRateWindow  time.Time // start of the current minute
+	actionRateCount   int       // actions started in it
+	actionRateLimited int
+)
+
+func init() {
+	registerMetrics(writeActionRateMetrics)
+}
+
+func currentActionRateLimit() int {
+	actionRateMu.Lock()
+	defer actionRateMu.Unlock()
+	return actionRateLimit
+}
+
+// actionRateRefusal counts an action against the limit and reports why it
+// can't run, "" if it can
+func actionRateRefusal() string {
+	actionRateMu.Lock()
+	defer actionRateMu.Unlock()
+	if actionRateLimit <= 0 {
+		return ""
+	}
+	if now := time.Now(); now.Sub(actionRateWindow) >= time.Minute {
+		actionRateWindow, actionRateCount = now, 0
+	}
+	if actionRateCount >= actionRateLimit {
+		actionRateLimited++
+		return fmt.Sprintf("ACTION_RATE_LIMIT reached (%d actions per minute)", actionRateLimit)
+	}
+	actionRateCount++
+	return ""
+}
+
+func writeActionRateMetrics(w io.Writer) {
+	actionRateMu.Lock()
+	defer actionRateMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_actions_rate_limited_total Actions skipped because ACTION_RATE_LIMIT was reached.")
+	fmt.Fprintln(w, "# TYPE selfhealing_actions_rate_limited_total counter")
+	fmt.Fprintf(w, "selfhealing_actions_rate_limited_total %d\n", actionRateLimited)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func testPod(namespace, name, app string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: map[string]string{"app": app}}}
+}
+
+func testDeployment(namespace, app string, replicas int32) *appsv1.Deployment {
+	labels := map[string]string{"app": app}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: app, Labels: labels, Generation: 1},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: labels}},
+		},
+	}
+}
+
+func TestRestartPodDeletesPod(t *testing.T) {
+	ctx := context.Background()
+	c := &Clients{Kube: fake.NewSimpleClientset(testPod("shop", "cart-1", "cart"))}
+
+	action := &RecoveryAction{Action: "restart", Namespace: "shop", App: "cart", Pod: "cart-1"}
+	if err := restartPod(ctx, c, action); err != nil {
+		t.Fatalf("restartPod: %v", err)
+	}
+	if _, err := c.Kube.CoreV1().Pods("shop").Get(ctx, "cart-1", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("pod still there after restart (err %v)", err)
+	}
+}
+
+func TestRestartPodLeavesTerminatingPod(t *testing.T) {
+	ctx := context.Background()
+	pod := testPod("shop", "cart-1", "cart")
+	pod.DeletionTimestamp = &metav1.Time{}
+	kube := fake.NewSimpleClientset(pod)
+	c := &Clients{Kube: kube}
+
+	if err := restartPod(ctx, c, &RecoveryAction{Action: "restart", Namespace: "shop", Pod: "cart-1"}); err != nil {
+		t.Fatalf("restartPod: %v", err)
+	}
+	for _, a := range kube.Actions() {
+		if a.GetVerb() == "delete" {
+			t.Errorf("terminating pod was deleted again")
+		}
+	}
+}
+
+func TestRestartPodNeedsPod(t *testing.T) {
+	c := &Clients{Kube: fake.NewSimpleClientset()}
+	if err := restartPod(context.Background(), c, &RecoveryAction{Action: "restart", Namespace: "shop"}); err == nil {
+		t.Fatal("restartPod succeeded without a pod name")
+	}
+}
+
+func TestRedeployDeploymentBumpsTemplate(t *testing.T) {
+	ctx := context.Background()
+	c := &Clients{Kube: fake.NewSimpleClientset(testDeployment("shop", "cart", 2))}
+
+	action := &RecoveryAction{Action: "redeploy", Namespace: "shop", App: "cart"}
+	if err := redeployDeployment(ctx, c, action); err != nil {
+		t.Fatalf("redeployDeployment: %v", err)
+	}
+	dep, err := c.Kube.AppsV1().Deployments("shop").Get(ctx, "cart", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dep.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] == "" {
+		t.Errorf("restartedAt annotation not set: %v", dep.Spec.Template.Annotations)
+	}
+	if len(action.Changes) != 1 || action.Changes[0].Kind != "Deployment" {
+		t.Errorf("changes = %+v, want the Deployment's", action.Changes)
+	}
+	if action.rollout == nil || action.rollout.name != "cart" {
+		t.Errorf("rollout to watch = %+v, want cart", action.rollout)
+	}
+}
+
+func TestRedeployDeploymentWithoutDeployment(t *testing.T) {
+	c := &Clients{Kube: fake.NewSimpleClientset()}
+	if err := redeployDeployment(context.Background(), c, &RecoveryAction{Action: "redeploy", Namespace: "shop", App: "cart"}); err == nil {
+		t.Fatal("redeployDeployment succeeded without a deployment")
+	}
+}
+
+func TestScaleDeploymentAddsStep(t *testing.T) {
+	ctx := context.Background()
+	kube := fake.NewSimpleClientset(testDeployment("shop", "cart", 2))
+	// the fake clientset has no scale subresource; serve it from the deployment
+	kube.PrependReactor("get", "deployments", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		if a.GetSubresource() != "scale" {
+			return false, nil, nil
+		}
+		obj, err := kube.Tracker().Get(appsv1.SchemeGroupVersion.WithResource("deployments"), a.GetNamespace(), a.(k8stesting.GetAction).GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		dep := obj.(*appsv1.Deployment)
+		return true, &autoscalingv1.Scale{ObjectMeta: dep.ObjectMeta, Spec: autoscalingv1.ScaleSpec{Replicas: *dep.Spec.Replicas}}, nil
+	})
+	kube.PrependReactor("update", "deployments", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		if a.GetSubresource() != "scale" {
+			return false, nil, nil
+		}
+		scale := a.(k8stesting.UpdateAction).GetObject().(*autoscalingv1.Scale)
+		obj, err := kube.Tracker().Get(appsv1.SchemeGroupVersion.WithResource("deployments"), scale.Namespace, scale.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		dep := obj.(*appsv1.Deployment).DeepCopy()
+		dep.Spec.Replicas = &scale.Spec.Replicas
+		return true, scale, kube.Tracker().Update(appsv1.SchemeGroupVersion.WithResource("deployments"), dep, dep.Namespace)
+	})
+	c := &Clients{Kube: kube}
+
+	action := &RecoveryAction{Action: "scale", Namespace: "shop", App: "cart"}
+	if err := scaleDeployment(ctx, c, action, Alert{}); err != nil {
+		t.Fatalf("scaleDeployment: %v", err)
+	}
+	dep, err := kube.AppsV1().Deployments("shop").Get(ctx, "cart", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int32(2 + scaleStep); *dep.Spec.Replicas != want {
+		t.Errorf("replicas = %d, want %d", *dep.Spec.Replicas, want)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Active alerts: GET /api/v1/active-alerts asks Alertmanager (v2 API, at
+// ALERTMANAGER_URL) for the alerts firing right now and puts each next to
+// the operator's remediation state for it, so a UI or CLI can show a combined
+// status like "firing, remediation in cooldown" without querying both.
+//
+//	curl 'localhost:8080/api/v1/active-alerts?namespace=shop'
+//
+// ?alertname= and ?namespace= filter in Alertmanager; ?remediation= keeps the
+// alerts in one remediation status. Silenced and inhibited alerts are left
+// out unless ?silenced=true / ?inhibited=true. The remediation status is, in
+// order of precedence:
+//   - in-progress: an action on the workload is running
+//   - pending-approval: a recommendation for it waits for approval
+//   - turned-off: a feature flag turned the action off
+//   - suppressed: a freeze (or flap suppression) holds it back
+//   - cooldown: the workload was acted on within the cooldown
+//   - remediated / failed: an action ran since the alert started, and the
+//     alert still fires
+//   - waiting: the next notification would lead to an action
+//   - no-action: no recovery action is configured for the alert
+//
+// Only the in-memory state is consulted: nothing is looked up in the cluster,
+// so per-workload annotations (a longer cooldown, enabled: false) don't show.
+
+var alertmanagerURL = strings.TrimRight(envString("ALERTMANAGER_URL", "http://alertmanager.monitoring:9093"), "/")
+
+// amAlert is an alert in Alertmanager's v2 API
+type amAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+	Fingerprint string            `json:"fingerprint"`
+	Status      struct {
+		State       string   `json:"state"` // active, suppressed, unprocessed
+		SilencedBy  []string `json:"silencedBy"`
+		InhibitedBy []string `json:"inhibitedBy"`
+	} `json:"status"`
+	Receivers []struct {
+		Name string `json:"name"`
+	} `json:"receivers"`
+}
+
+type activeAlert struct {
+	AlertName   string            `json:"alertname"`
+	Namespace   string            `json:"namespace,omitempty"`
+	App         string            `json:"app,omitempty"`
+	Severity    string            `json:"severity,omitempty"`
+	Fingerprint string            `json:"fingerprint"`
+	StartsAt    time.Time         `json:"startsAt"`
+	State       string            `json:"state"` // Alertmanager's: active, suppressed
+	SilencedBy  []string          `json:"silencedBy,omitempty"`
+	InhibitedBy []string          `json:"inhibitedBy,omitempty"`
+	Receivers   []string          `json:"receivers,omitempty"`
+	Labels      map[string]string `json:"labels"`
+	Remediation remediationState  `json:"remediation"`
+}
+
+type remediationState struct {
+	Status           string         `json:"status"`
+	Detail           string         `json:"detail,omitempty"`
+	Action           string         `json:"action,omitempty"` // what the operator would run
+	CooldownUntil    *time.Time     `json:"cooldownUntil,omitempty"`
+	SuppressionID    int            `json:"suppressionId,omitempty"`
+	RecommendationID int            `json:"recommendationId,omitempty"`
+	LastAction       *lastExecution `json:"lastAction,omitempty"`
+}
+
+// lastExecution summarizes the newest history record for the workload
+type lastExecution struct {
+	ID        int       `json:"id"`
+	Action    string    `json:"action"`
+	Outcome   string    `json:"outcome"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// fetchActiveAlerts queries Alertmanager for firing alerts
+func fetchActiveAlerts(ctx context.Context, q url.Values) ([]amAlert, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, alertmanagerURL+"/api/v2/alerts?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := egressClient("alertmanager").Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query Alertmanager: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Alertmanager returned %s", resp.Status)
+	}
+	var alerts []amAlert
+	if err := json.NewDecoder(resp.Body).Decode(&alerts); err != nil {
+		return nil, fmt.Errorf("failed to decode Alertmanager alerts: %v", err)
+	}
+	return alerts, nil
+}
+
+// remediationStateFor cross-references a firing alert with the operator's state
+func remediationStateFor(alert Alert, now time.Time) remediationState {
+	action := parseRecoveryAction(alert, nil)
+	if action == nil {
+		return remediationState{Status: "no-action", Detail: fmt.Sprintf("no recovery action for severity %q", alert.Labels["severity"])}
+	}
+	st := remediationState{Action: action.Action}
+	key := workloadKey(action)
+
+	historyMu.Lock()
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Namespace == action.Namespace && history[i].App == action.App {
+			h := history[i]
+			st.LastAction = &lastExecution{ID: h.ID, Action: h.Action, Outcome: h.Outcome, StartedAt: h.StartedAt}
+			break
+		}
+	}
+	historyMu.Unlock()
+
+	cooldownMu.Lock()
+	last, acted := lastAction[key]
+	cooldown := cooldownTime
+	cooldownMu.Unlock()
+
+	for _, held := range workloadLocks.held() {
+		if held == key {
+			st.Status, st.Detail = "in-progress", "an action on "+key+" is running"
+			return st
+		}
+	}
+	for _, rec := range listRecommendations() {
+		if rec.Status == "pending" && rec.Namespace == action.Namespace && rec.App == action.App && rec.AlertName == action.AlertName {
+			st.Status, st.RecommendationID = "pending-approval", rec.ID
+			st.Detail = fmt.Sprintf("recommendation #%d for '%s' expires %s", rec.ID, rec.Action, rec.ExpiresAt.Format(time.RFC3339))
+			return st
+		}
+	}
+	if reason := actionFlagRefusal(action); reason != "" {
+		st.Status, st.Detail = "turned-off", reason
+		return st
+	}
+	if sup := activeSuppression(action); sup != nil {
+		st.Status, st.SuppressionID = "suppressed", sup.ID
+		st.Detail = fmt.Sprintf("suppressed by #%d until %s", sup.ID, sup.ExpiresAt.Format(time.RFC3339))
+		return st
+	}
+	if acted && now.Sub(last) < cooldown {
+		until := last.Add(cooldown)
+		st.Status, st.CooldownUntil = "cooldown", &until
+		st.Detail = fmt.Sprintf("%s was acted on at %s", key, last.Format(time.RFC3339))
+		return st
+	}
+	if st.LastAction != nil && !st.LastAction.StartedAt.Before(alert.StartsAt) {
+		st.Status = "remediated"
+		if st.LastAction.Outcome != "succeeded" {
+			st.Status = "failed"
+		}
+		st.Detail = fmt.Sprintf("'%s' %s at %s, and the alert still fires", st.LastAction.Action, st.LastAction.Outcome, st.LastAction.StartedAt.Format(time.RFC3339))
+		return st
+	}
+	st.Status, st.Detail = "waiting", "'"+action.Action+"' would run on the next notification"
+	return st
+}
+
+func handleActiveAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	q := url.Values{"active": {"true"}, "silenced": {"false"}, "inhibited": {"false"}}
+	if query.Get("silenced") == "true" {
+		q.Set("silenced", "true")
+	}
+	if query.Get("inhibited") == "true" {
+		q.Set("inhibited", "true")
+	}
+	for _, label := range []string{"alertname", "namespace"} {
+		if v := query.Get(label); v != "" {
+			q.Add("filter", fmt.Sprintf("%s=%q", label, v))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	alerts, err := fetchActiveAlerts(ctx, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	now := time.Now()
+	out := []activeAlert{}
+	for _, a := range alerts {
+		alert := Alert{Labels: a.Labels, Annotations: a.Annotations, Status: "firing", StartsAt: a.StartsAt, EndsAt: a.EndsAt, Fingerprint: a.Fingerprint}
+		st := remediationStateFor(alert, now)
+		if want := query.Get("remediation"); want != "" && st.Status != want {
+			continue
+		}
+		aa := activeAlert{
+			AlertName:   a.Labels["alertname"],
+			Namespace:   a.Labels["namespace"],
+			App:         a.Labels["app"],
+			Severity:    a.Labels["severity"],
+			Fingerprint: a.Fingerprint,
+			StartsAt:    a.StartsAt,
+			State:       a.Status.State,
+			SilencedBy:  a.Status.SilencedBy,
+			InhibitedBy: a.Status.InhibitedBy,
+			Labels:      a.Labels,
+			Remediation: st,
+		}
+		for _, rcv := range a.Receivers {
+			aa.Receivers = append(aa.Receivers, rcv.Name)
+		}
+		out = append(out, aa)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].StartsAt.Before(out[j].StartsAt) })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
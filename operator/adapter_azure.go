@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Azure Monitor action group webhooks using the common alert schema, served
+// at /webhook/azure. Set AZURE_WEBHOOK_TOKEN and add it to the webhook URI
+// (…/webhook/azure?token=…).
+//
+// Labels come from, in increasing priority:
+//   - metric/log alert dimensions; Container Insights names are mapped
+//     (Kubernetes namespace, controllerName, podName, node)
+//   - the action group's or alert processing rule's custom properties
+//   - key=value pairs in the alert rule description
+
+type azureAlert struct {
+	SchemaID string `json:"schemaId"`
+	Data     struct {
+		Essentials struct {
+			AlertID           string   `json:"alertId"`
+			AlertRule         string   `json:"alertRule"`
+			Severity          string   `json:"severity"` // Sev0 … Sev4
+			SignalType        string   `json:"signalType"`
+			MonitorCondition  string   `json:"monitorCondition"` // Fired, Resolved
+			MonitoringService string   `json:"monitoringService"`
+			AlertTargetIDs    []string `json:"alertTargetIDs"`
+			FiredDateTime     string   `json:"firedDateTime"`
+			ResolvedDateTime  string   `json:"resolvedDateTime"`
+			Description       string   `json:"description"`
+		} `json:"essentials"`
+		AlertContext struct {
+			Condition struct {
+				AllOf []struct {
+					MetricName string `json:"metricName"`
+					Dimensions []struct {
+						Name  string `json:"name"`
+						Value string `json:"value"`
+					} `json:"dimensions"`
+				} `json:"allOf"`
+			} `json:"condition"`
+		} `json:"alertContext"`
+		CustomProperties map[string]string `json:"customProperties"`
+	} `json:"data"`
+}
+
+// dimension names (lowercased) -> label
+var azureDimensionLabels = map[string]string{
+	"kubernetes namespace": "namespace",
+	"namespace":            "namespace",
+	"controllername":       "app",
+	"podname":              "pod",
+	"pod":                  "pod",
+	"node":                 "node",
+	"host":                 "node",
+	"containername":        "container",
+}
+
+var azureSeverities = map[string]string{
+	"Sev0": "critical",
+	"Sev1": "critical",
+	"Sev2": "warning",
+	"Sev3": "warning",
+	"Sev4": "info",
+}
+
+var azureWebhookToken = credential("AZURE_WEBHOOK_TOKEN")
+
+func adaptAzure(r *http.Request) ([]Alert, error) {
+	if err := checkAdapterToken(r, azureWebhookToken.value()); err != nil {
+		return nil, err
+	}
+	var a azureAlert
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		return nil, err
+	}
+	if a.SchemaID != "azureMonitorCommonAlertSchema" {
+		return nil, fmt.Errorf("unsupported Azure alert schema %q (enable the common alert schema on the action group)", a.SchemaID)
+	}
+	ess := a.Data.Essentials
+
+	labels := map[string]string{
+		"alertname":          ess.AlertRule,
+		"signal_type":        ess.SignalType,
+		"monitoring_service": ess.MonitoringService,
+	}
+	if sev, ok := azureSeverities[ess.Severity]; ok {
+		labels["severity"] = sev
+	}
+	for _, cond := range a.Data.AlertContext.Condition.AllOf {
+		if cond.MetricName != "" {
+			labels["metric"] = cond.MetricName
+		}
+		for _, d := range cond.Dimensions {
+			if l, ok := azureDimensionLabels[strings.ToLower(d.Name)]; ok {
+				labels[l] = d.Value
+			}
+		}
+	}
+	for k, v := range a.Data.CustomProperties {
+		labels[k] = v
+	}
+	for k, v := range parseKeyValues(ess.Description) {
+		labels[k] = v
+	}
+
+	started, err := time.Parse(time.RFC3339, ess.FiredDateTime)
+	if err != nil {
+		started = time.Now()
+	}
+	alert := Alert{
+		Labels: labels,
+		Annotations: map[string]string{
+			"summary":     ess.AlertRule + " " + strings.ToLower(ess.MonitorCondition),
+			"description": ess.Description,
+			"alert_id":    ess.AlertID,
+		},
+		Status:   "firing",
+		StartsAt: started,
+	}
+	if len(ess.AlertTargetIDs) > 0 {
+		alert.Annotations["target"] = ess.AlertTargetIDs[0]
+	}
+	if ess.MonitorCondition == "Resolved" {
+		alert.Status = "resolved"
+		if alert.EndsAt, err = time.Parse(time.RFC3339, ess.ResolvedDateTime); err != nil {
+			alert.EndsAt = time.Now()
+		}
+	}
+	return []Alert{alert}, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Google Cloud Monitoring webhook notification channel (payload version 1.2),
+// served at /webhook/gcp. Set GCP_WEBHOOK_TOKEN and put it in the channel URL
+// (…/webhook/gcp?token=…) or as the basic auth password.
+//
+// Labels come from, in increasing priority:
+//   - the monitored resource: namespace_name, pod_name, container_name,
+//     node_name, cluster_name
+//   - metadata.user_labels — for k8s_* resources these are the pod's labels,
+//     so app usually comes for free
+//   - the alert policy's user labels (recovery_action, severity, …)
+//   - key=value pairs in the policy documentation
+
+type gcpNotification struct {
+	Version  string `json:"version"`
+	Incident struct {
+		IncidentID    string `json:"incident_id"`
+		URL           string `json:"url"`
+		State         string `json:"state"` // open, closed
+		StartedAt     int64  `json:"started_at"`
+		EndedAt       int64  `json:"ended_at"`
+		Summary       string `json:"summary"`
+		PolicyName    string `json:"policy_name"`
+		ConditionName string `json:"condition_name"`
+		Severity      string `json:"severity"`
+		Resource      struct {
+			Type   string            `json:"type"`
+			Labels map[string]string `json:"labels"`
+		} `json:"resource"`
+		Metadata struct {
+			SystemLabels map[string]string `json:"system_labels"`
+			UserLabels   map[string]string `json:"user_labels"`
+		} `json:"metadata"`
+		PolicyUserLabels map[string]string `json:"policy_user_labels"`
+		Documentation    struct {
+			Content string `json:"content"`
+		} `json:"documentation"`
+	} `json:"incident"`
+}
+
+var gcpResourceLabels = map[string]string{
+	"namespace_name": "namespace",
+	"pod_name":       "pod",
+	"container_name": "container",
+	"node_name":      "node",
+	"cluster_name":   "cluster",
+	"project_id":     "gcp_project",
+	"location":       "gcp_location",
+}
+
+var gcpSeverities = map[string]string{
+	"critical": "critical",
+	"error":    "critical",
+	"warning":  "warning",
+}
+
+var gcpWebhookToken = credential("GCP_WEBHOOK_TOKEN")
+
+func adaptGCP(r *http.Request) ([]Alert, error) {
+	if err := checkAdapterToken(r, gcpWebhookToken.value()); err != nil {
+		return nil, err
+	}
+	var n gcpNotification
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		return nil, err
+	}
+	inc := n.Incident
+	if inc.PolicyName == "" {
+		return nil, fmt.Errorf("not a Cloud Monitoring incident")
+	}
+
+	labels := map[string]string{
+		"alertname":     inc.PolicyName,
+		"resource_type": inc.Resource.Type,
+	}
+	for k, v := range inc.Resource.Labels {
+		if l, ok := gcpResourceLabels[k]; ok {
+			labels[l] = v
+		}
+	}
+	for k, v := range inc.Metadata.UserLabels {
+		labels[k] = v
+	}
+	if sev, ok := gcpSeverities[strings.ToLower(inc.Severity)]; ok {
+		labels["severity"] = sev
+	}
+	for k, v := range inc.PolicyUserLabels {
+		labels[k] = v
+	}
+	for k, v := range parseKeyValues(inc.Documentation.Content) {
+		labels[k] = v
+	}
+
+	alert := Alert{
+		Labels: labels,
+		Annotations: map[string]string{
+			"summary":      inc.Summary,
+			"condition":    inc.ConditionName,
+			"incident_url": inc.URL,
+		},
+		Status:   "firing",
+		StartsAt: time.Unix(inc.StartedAt, 0),
+	}
+	if inc.State == "closed" {
+		alert.Status = "resolved"
+		alert.EndsAt = time.Unix(inc.EndedAt, 0)
+	}
+	return []Alert{alert}, nil
+}
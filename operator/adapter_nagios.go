@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Nagios and Icinga notification commands
+// (docs/integrations/nagios-notify-selfhealing.sh) post form or JSON fields:
+//
+//	type     $NOTIFICATIONTYPE$  PROBLEM, RECOVERY, … (others are ignored)
+//	host     $HOSTNAME$
+//	service  $SERVICEDESC$       empty for host notifications
+//	state    $SERVICESTATE$ / $HOSTSTATE$
+//	output   $SERVICEOUTPUT$ / $HOSTOUTPUT$
+//
+// plus namespace, app, pod, recovery_action, … taken from custom variables
+// ($_SERVICEAPP$ etc.), which become labels. The alertname is the service
+// description, or HostDown/HostUnreachable for host notifications, unless an
+// alertname field is given.
+
+var nagiosSeverities = map[string]string{
+	"CRITICAL":    "critical",
+	"DOWN":        "critical",
+	"UNREACHABLE": "critical",
+	"WARNING":     "warning",
+	"UNKNOWN":     "warning",
+}
+
+func adaptNagios(r *http.Request) ([]Alert, error) {
+	fields, err := requestFields(r)
+	if err != nil {
+		return nil, err
+	}
+	if fields["host"] == "" {
+		return nil, fmt.Errorf("nagios notification has no host")
+	}
+
+	var status string
+	switch strings.ToUpper(fields["type"]) {
+	case "PROBLEM":
+		status = "firing"
+	case "RECOVERY":
+		status = "resolved"
+	case "":
+		return nil, fmt.Errorf("nagios notification has no type")
+	default:
+		// acknowledgements, flapping and downtime notifications don't change anything
+		return nil, nil
+	}
+
+	state := strings.ToUpper(fields["state"])
+	name := fields["alertname"]
+	if name == "" {
+		name = fields["service"]
+	}
+	if name == "" && state != "" {
+		name = "Host" + state[:1] + strings.ToLower(state[1:])
+	}
+	if name == "" {
+		return nil, fmt.Errorf("nagios notification has neither service nor state")
+	}
+
+	labels := map[string]string{
+		"alertname": name,
+		"host":      fields["host"],
+		"state":     state,
+	}
+	if fields["service"] != "" {
+		labels["service"] = fields["service"]
+	}
+	if sev, ok := nagiosSeverities[state]; ok {
+		labels["severity"] = sev
+	}
+	copyK8sLabels(labels, fields)
+
+	alert := Alert{
+		Labels: labels,
+		Annotations: map[string]string{
+			"summary":     name + " on " + fields["host"] + " is " + state,
+			"description": fields["output"],
+		},
+		Status:   status,
+		StartsAt: time.Now(),
+	}
+	if status == "resolved" {
+		alert.EndsAt = time.Now()
+	}
+	return []Alert{alert}, nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CloudWatch alarms delivered by SNS (HTTP/S subscription to /webhook/sns).
+//
+// Only topics listed in SNS_TOPIC_ARNS are accepted, and every message's
+// signature is checked against the AWS signing certificate, so nobody can
+// subscribe the operator to their own topic or forge an alarm. Subscription
+// confirmations for allowed topics are confirmed automatically.
+//
+// Alarms carry no Kubernetes labels, so they come from two places:
+//   - Container Insights dimensions: Namespace, PodName (the workload),
+//     FullPodName and NodeName map to namespace, app, pod and node
+//   - key=value pairs in the alarm description, e.g.
+//     "namespace=payments app=api recovery_action=restart severity=critical"
+//
+// ALARM fires, OK resolves, INSUFFICIENT_DATA is ignored.
+
+type snsMessage struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+	SubscribeURL     string `json:"SubscribeURL"`
+}
+
+type cloudWatchAlarm struct {
+	AlarmName        string `json:"AlarmName"`
+	AlarmDescription string `json:"AlarmDescription"`
+	AWSAccountID     string `json:"AWSAccountId"`
+	NewStateValue    string `json:"NewStateValue"`
+	NewStateReason   string `json:"NewStateReason"`
+	StateChangeTime  string `json:"StateChangeTime"`
+	Region           string `json:"Region"`
+	AlarmArn         string `json:"AlarmArn"`
+	Trigger          struct {
+		MetricName string `json:"MetricName"`
+		Namespace  string `json:"Namespace"`
+		Dimensions []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"Dimensions"`
+	} `json:"Trigger"`
+}
+
+var (
+	snsTopics           = envList("SNS_TOPIC_ARNS")
+	snsVerifySignatures = envBool("SNS_VERIFY_SIGNATURES", true)
+
+	// SNS endpoints are sns.<region>.amazonaws.com (or .amazonaws.com.cn)
+	snsHostPattern = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+	snsCertMu sync.Mutex
+	snsCerts  = map[string]*x509.Certificate{}
+)
+
+// Container Insights dimension -> label
+var cloudWatchDimensionLabels = map[string]string{
+	"Namespace":   "namespace",
+	"PodName":     "app",
+	"FullPodName": "pod",
+	"NodeName":    "node",
+	"ClusterName": "cluster",
+}
+
+func adaptSNS(r *http.Request) ([]Alert, error) {
+	// SNS posts JSON with Content-Type text/plain
+	var msg snsMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		return nil, err
+	}
+	if !containsString(snsTopics, msg.TopicArn) {
+		return nil, fmt.Errorf("SNS topic %q is not in SNS_TOPIC_ARNS", msg.TopicArn)
+	}
+	if snsVerifySignatures {
+		if err := verifySNSSignature(r.Context(), &msg); err != nil {
+			return nil, fmt.Errorf("SNS signature check failed: %v", err)
+		}
+	}
+
+	switch msg.Type {
+	case "SubscriptionConfirmation":
+		return nil, confirmSNSSubscription(r.Context(), &msg)
+	case "UnsubscribeConfirmation":
+		log.Printf("SNS subscription to %s was removed", msg.TopicArn)
+		return nil, nil
+	case "Notification":
+	default:
+		return nil, fmt.Errorf("unknown SNS message type %q", msg.Type)
+	}
+
+	var alarm cloudWatchAlarm
+	if err := json.Unmarshal([]byte(msg.Message), &alarm); err != nil || alarm.AlarmName == "" {
+		return nil, fmt.Errorf("SNS message from %s is not a CloudWatch alarm", msg.TopicArn)
+	}
+
+	var status string
+	switch alarm.NewStateValue {
+	case "ALARM":
+		status = "firing"
+	case "OK":
+		status = "resolved"
+	default:
+		return nil, nil
+	}
+
+	labels := map[string]string{
+		"alertname":   alarm.AlarmName,
+		"aws_region":  alarm.Region,
+		"aws_account": alarm.AWSAccountID,
+	}
+	if alarm.Trigger.MetricName != "" {
+		labels["metric"] = alarm.Trigger.Namespace + "/" + alarm.Trigger.MetricName
+	}
+	for _, d := range alarm.Trigger.Dimensions {
+		if l, ok := cloudWatchDimensionLabels[d.Name]; ok {
+			labels[l] = d.Value
+		}
+	}
+	for k, v := range parseKeyValues(alarm.AlarmDescription) {
+		labels[k] = v
+	}
+
+	started, err := time.Parse("2006-01-02T15:04:05.000-0700", alarm.StateChangeTime)
+	if err != nil {
+		started = time.Now()
+	}
+	alert := Alert{
+		Labels: labels,
+		Annotations: map[string]string{
+			"summary":     alarm.AlarmName + " is " + alarm.NewStateValue,
+			"description": alarm.NewStateReason,
+			"alarm_arn":   alarm.AlarmArn,
+		},
+		Status:   status,
+		StartsAt: started,
+	}
+	if status == "resolved" {
+		alert.EndsAt = started
+	}
+	return []Alert{alert}, nil
+}
+
+func confirmSNSSubscription(ctx context.Context, msg *snsMessage) error {
+	u, err := url.Parse(msg.SubscribeURL)
+	if err != nil || u.Scheme != "https" || !snsHostPattern.MatchString(u.Hostname()) {
+		return fmt.Errorf("refusing to confirm SNS subscription via %q", msg.SubscribeURL)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := egressClient("sns").Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to confirm SNS subscription: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("SNS subscription confirmation returned %s", resp.Status)
+	}
+	log.Printf("Confirmed SNS subscription to %s", msg.TopicArn)
+	return nil
+}
+
+// verifySNSSignature checks the message against the signing certificate
+// (https://docs.aws.amazon.com/sns/latest/dg/sns-verify-signature-of-message.html)
+func verifySNSSignature(ctx context.Context, msg *snsMessage) error {
+	var hash crypto.Hash
+	switch msg.SignatureVersion {
+	case "1":
+		if err := cryptoAllowed("SNS message signature", "rsa-pkcs1v15-sha1"); err != nil {
+			return err
+		}
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("unsupported signature version %q", msg.SignatureVersion)
+	}
+	cert, err := snsSigningCert(ctx, msg.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("signing certificate has no RSA key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return err
+	}
+
+	var fields []string
+	if msg.Type == "Notification" {
+		fields = []string{"Message", msg.Message, "MessageId", msg.MessageID}
+		if msg.Subject != "" {
+			fields = append(fields, "Subject", msg.Subject)
+		}
+		fields = append(fields, "Timestamp", msg.Timestamp, "TopicArn", msg.TopicArn, "Type", msg.Type)
+	} else {
+		fields = []string{"Message", msg.Message, "MessageId", msg.MessageID, "SubscribeURL", msg.SubscribeURL,
+			"Timestamp", msg.Timestamp, "Token", msg.Token, "TopicArn", msg.TopicArn, "Type", msg.Type}
+	}
+	payload := []byte(strings.Join(fields, "\n") + "\n")
+
+	var digest []byte
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum(payload)
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256(payload)
+		digest = sum[:]
+	}
+	return rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+}
+
+// snsSigningCert downloads (and caches) a signing certificate, only from SNS itself
+func snsSigningCert(ctx context.Context, certURL string) (*x509.Certificate, error) {
+	u, err := url.Parse(certURL)
+	if err != nil || u.Scheme != "https" || !snsHostPattern.MatchString(u.Hostname()) {
+		return nil, fmt.Errorf("untrusted signing certificate URL %q", certURL)
+	}
+
+	snsCertMu.Lock()
+	cert, ok := snsCerts[certURL]
+	snsCertMu.Unlock()
+	if ok {
+		return cert, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, certURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := egressClient("sns").Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("signing certificate download returned %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing certificate is not PEM encoded")
+	}
+	cert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	snsCertMu.Lock()
+	snsCerts[certURL] = cert
+	snsCertMu.Unlock()
+	return cert, nil
+}
+
+// parseKeyValues extracts key=value pairs separated by whitespace or commas
+func parseKeyValues(s string) map[string]string {
+	out := map[string]string{}
+	for _, tok := range strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' || r == '\n' || r == '\t' }) {
+		k, v, ok := strings.Cut(tok, "=")
+		if ok && k != "" && v != "" {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
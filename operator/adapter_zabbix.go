@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Zabbix webhook media type (docs/integrations/zabbix-media-type.js). The
+// media type posts its parameters as JSON:
+//
+//	event_id        {EVENT.ID}
+//	event_value     {EVENT.VALUE}     1 = problem, 0 = recovery
+//	event_severity  {EVENT.SEVERITY}  Not classified … Disaster
+//	host            {HOST.NAME}
+//	trigger         {TRIGGER.NAME}
+//	message         {ALERT.MESSAGE}
+//	tags            {EVENT.TAGSJSON}  [{"tag":"app","value":"web"}, …]
+//
+// Trigger tags become labels, so tag a trigger with namespace, app and
+// recovery_action to drive a remediation. An alertname tag overrides the
+// trigger name.
+
+type zabbixTag struct {
+	Tag   string `json:"tag"`
+	Value string `json:"value"`
+}
+
+var zabbixSeverities = map[string]string{
+	"disaster":       "critical",
+	"high":           "critical",
+	"average":        "warning",
+	"warning":        "warning",
+	"information":    "info",
+	"not classified": "info",
+}
+
+func adaptZabbix(r *http.Request) ([]Alert, error) {
+	fields, err := requestFields(r)
+	if err != nil {
+		return nil, err
+	}
+	if fields["trigger"] == "" {
+		return nil, fmt.Errorf("zabbix notification has no trigger name")
+	}
+
+	labels := map[string]string{
+		"alertname":       fields["trigger"],
+		"host":            fields["host"],
+		"zabbix_event_id": fields["event_id"],
+	}
+	if sev, ok := zabbixSeverities[strings.ToLower(fields["event_severity"])]; ok {
+		labels["severity"] = sev
+	}
+	if raw := fields["tags"]; raw != "" {
+		var tags []zabbixTag
+		if err := json.Unmarshal([]byte(raw), &tags); err != nil {
+			return nil, fmt.Errorf("invalid zabbix tags: %v", err)
+		}
+		for _, t := range tags {
+			if t.Tag != "" {
+				labels[t.Tag] = t.Value
+			}
+		}
+	}
+
+	alert := Alert{
+		Labels: labels,
+		Annotations: map[string]string{
+			"summary":     fields["trigger"] + " on " + fields["host"],
+			"description": fields["message"],
+		},
+		Status:   "firing",
+		StartsAt: time.Now(),
+	}
+	if fields["event_value"] == "0" {
+		alert.Status = "resolved"
+		alert.EndsAt = time.Now()
+	}
+	return []Alert{alert}, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Ingestion adapters accept notifications from monitoring systems other than
+// Alertmanager, translate them into Alerts and feed them through the same
+// pipeline. Each adapter is served at /webhook/<source>; the alerts it
+// produces carry a source=<source> label.
+
+// alertAdapter turns one notification request into alerts
+type alertAdapter func(r *http.Request) ([]Alert, error)
+
+var errAdapterUnauthorized = errors.New("missing or invalid token")
+
+var (
+	adapterMu       sync.Mutex
+	adapterReceived = map[string]int{} // source -> alerts received
+	adapterRejected = map[string]int{} // source -> requests that couldn't be parsed
+)
+
+func init() {
+	registerMetrics(writeAdapterMetrics)
+}
+
+// handleAdapter serves an adapter endpoint
+func handleAdapter(source string, adapt alertAdapter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+
+		alerts, err := adapt(r)
+		if err != nil {
+			adapterMu.Lock()
+			adapterRejected[source]++
+			adapterMu.Unlock()
+			log.Printf("Error decoding %s notification: %v", source, err)
+			status := http.StatusBadRequest
+			if errors.Is(err, errAdapterUnauthorized) {
+				status = http.StatusUnauthorized
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		for i := range alerts {
+			alerts[i].Labels["source"] = source
+		}
+
+		adapterMu.Lock()
+		adapterReceived[source] += len(alerts)
+		adapterMu.Unlock()
+
+		log.Printf("Received %d alert(s) from %s", len(alerts), source)
+		// adapters have no group key; the source stands in, as on the bus
+		alerts = shards.route(r, source, alerts)
+		if n := len(alerts); n > 0 {
+			if alerts = deliveries.dedupe(source, alerts); len(alerts) < n {
+				log.Printf("Dropped %d redelivered alert(s) from %s", n-len(alerts), source)
+			}
+		}
+		processAlerts(r.Context(), alerts)
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
+
+// requestFields reads a flat JSON object or a form-encoded body into a map.
+// Nested JSON values are kept as their JSON text.
+func requestFields(r *http.Request) (map[string]string, error) {
+	fields := map[string]string{}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var raw map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+			return nil, err
+		}
+		for k, v := range raw {
+			var s string
+			if err := json.Unmarshal(v, &s); err == nil {
+				fields[k] = s
+			} else {
+				fields[k] = string(v)
+			}
+		}
+		return fields, nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	for k, v := range r.PostForm {
+		if len(v) > 0 {
+			fields[k] = v[0]
+		}
+	}
+	return fields, nil
+}
+
+// checkAdapterToken accepts the shared token as ?token=, a bearer token or
+// the basic auth password; an empty token disables the check
+func checkAdapterToken(r *http.Request, token string) error {
+	if token == "" {
+		return nil
+	}
+	got := r.URL.Query().Get("token")
+	if _, pass, ok := r.BasicAuth(); ok {
+		got = pass
+	} else if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		got = strings.TrimPrefix(h, "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		return errAdapterUnauthorized
+	}
+	return nil
+}
+
+// k8sLabelFields are the fields an adapter copies straight into alert labels
+// so a legacy check can point at a workload and pick an action
+var k8sLabelFields = []string{"namespace", "app", "pod", "node", "recovery_action", "runbook", "workflow", "persistentvolumeclaim"}
+
+func copyK8sLabels(labels, fields map[string]string) {
+	for _, k := range k8sLabelFields {
+		if v := fields[k]; v != "" {
+			labels[k] = v
+		}
+	}
+}
+
+func writeAdapterMetrics(w io.Writer) {
+	adapterMu.Lock()
+	defer adapterMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_adapter_alerts_total Alerts received through ingestion adapters.")
+	fmt.Fprintln(w, "# TYPE selfhealing_adapter_alerts_total counter")
+	for _, source := range sortedKeys(adapterReceived) {
+		fmt.Fprintf(w, "selfhealing_adapter_alerts_total{source=%q} %d\n", source, adapterReceived[source])
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_adapter_rejected_total Adapter requests that couldn't be parsed.")
+	fmt.Fprintln(w, "# TYPE selfhealing_adapter_rejected_total counter")
+	for _, source := range sortedKeys(adapterRejected) {
+		fmt.Fprintf(w, "selfhealing_adapter_rejected_total{source=%q} %d\n", source, adapterRejected[source])
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdapterDropsRedeliveries(t *testing.T) {
+	saved := deliveries
+	deliveries = &deliveryDedup{ttl: time.Minute, seen: map[string]time.Time{}}
+	defer func() { deliveries = saved }()
+
+	startsAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	adapt := func(r *http.Request) ([]Alert, error) {
+		at := startsAt
+		if r.URL.Query().Get("later") != "" {
+			at = at.Add(time.Hour)
+		}
+		return []Alert{{
+			Status:   "resolved",
+			StartsAt: at,
+			Labels:   map[string]string{"alertname": "AdapterDedupeTest", "namespace": "shop", "app": "cart"},
+		}}, nil
+	}
+	handler := handleAdapter("zabbix", adapt)
+
+	tests := []struct {
+		name       string
+		query      string
+		duplicates int
+	}{
+		{"first delivery", "", 0},
+		{"redelivery", "", 1},
+		{"redelivered again", "", 2},
+		{"new occurrence", "?later=1", 2},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/webhook/zabbix"+tt.query, strings.NewReader("{}")))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", tt.name, rec.Code)
+		}
+		deliveries.mu.Lock()
+		got := deliveries.duplicates
+		deliveries.mu.Unlock()
+		if got != tt.duplicates {
+			t.Errorf("%s: %d duplicates dropped, want %d", tt.name, got, tt.duplicates)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Admin listener: internal endpoints (metrics, debug) are served on their own port
+// so exposing the webhook through an ingress doesn't expose them too.
+// When ADMIN_TOKEN is set, every admin request needs "Authorization: Bearer <token>".
+
+func newAdminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics)
+	mux.HandleFunc("/api/v1/tuning", handleTuning)
+	mux.HandleFunc("/api/v1/policy-bundle", handlePolicyBundle)
+	mux.HandleFunc("/api/v1/networkpolicy", handleNetworkPolicy)
+	mux.HandleFunc("/api/v1/observation/graduate", handleGraduate)
+	registerDebugHandlers(mux)
+	return mux
+}
+
+func startAdminServer() {
+	var handler http.Handler = newAdminMux()
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		if apiAuthz == "kubernetes" {
+			// both expect their token in the Authorization header
+			log.Fatal("ADMIN_TOKEN and API_AUTHZ=kubernetes can't be combined; protect the admin port with a NetworkPolicy instead")
+		}
+		handler = requireBearerToken(token, handler)
+		log.Printf("Admin listener requires a bearer token")
+	}
+
+	errs, err := serveAll("Admin endpoints", listenAddrs("ADMIN_LISTEN_ADDR", "ADMIN_PORT", "9090"), handler)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		log.Fatal(<-errs)
+	}()
+}
+
+// requireBearerToken rejects requests without the expected bearer token
+func requireBearerToken(token string, next http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(strings.TrimSpace(r.Header.Get("Authorization")))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="self-healing-operator"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Stale alerts: Alertmanager retries a failed webhook delivery, and a queue
+// in front of the operator can hold alerts for a while, so an alert can
+// arrive long after it described the cluster. With MAX_ALERT_AGE set the
+// operator refuses to act on a firing alert that
+//   - started more than MAX_ALERT_AGE ago (startsAt), or
+//   - had already expired when it arrived (endsAt in the past — Alertmanager
+//     would have stopped considering it firing)
+//
+// Alertmanager re-sends a still-firing alert with its original startsAt, so
+// MAX_ALERT_AGE also bounds how long the operator keeps acting on one
+// long-running alert; set it above the longest firing time you want handled.
+
+// alertClockSkew tolerates endsAt values a little behind our clock
+const alertClockSkew = time.Minute
+
+var (
+	maxAlertAge = envDuration("MAX_ALERT_AGE", 0)
+
+	staleMu     sync.Mutex
+	staleAlerts = map[string]int{} // reason -> count
+)
+
+func init() {
+	registerMetrics(writeStaleMetrics)
+}
+
+// staleReason returns a short reason and an explanation if the alert is too
+// old to act on
+func staleReason(alert Alert, now time.Time) (string, string) {
+	if maxAlertAge <= 0 {
+		return "", ""
+	}
+	if !alert.EndsAt.IsZero() && now.Sub(alert.EndsAt) > alertClockSkew {
+		return "expired", fmt.Sprintf("alert expired at %s, %s before it arrived", alert.EndsAt.Format(time.RFC3339), now.Sub(alert.EndsAt).Round(time.Second))
+	}
+	if !alert.StartsAt.IsZero() && now.Sub(alert.StartsAt) > maxAlertAge {
+		return "too-old", fmt.Sprintf("alert started %s ago (MAX_ALERT_AGE %s)", now.Sub(alert.StartsAt).Round(time.Second), maxAlertAge)
+	}
+	return "", ""
+}
+
+func countStaleAlert(reason string) {
+	staleMu.Lock()
+	staleAlerts[reason]++
+	staleMu.Unlock()
+}
+
+func writeStaleMetrics(w io.Writer) {
+	staleMu.Lock()
+	defer staleMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_stale_alerts_total Firing alerts ignored because they were too old to act on, by reason.")
+	fmt.Fprintln(w, "# TYPE selfhealing_stale_alerts_total counter")
+	for _, reason := range sortedKeys(staleAlerts) {
+		fmt.Fprintf(w, "selfhealing_stale_alerts_total{reason=%q} %d\n", reason, staleAlerts[reason])
+	}
+}
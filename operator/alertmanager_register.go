@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/yaml"
+)
+
+// Receiver registration: `self-healing-operator register-alertmanager` adds
+// the operator as a webhook receiver to an existing Alertmanager, so
+// installing it doesn't mean hand-editing the routing tree:
+//
+//	self-healing-operator register-alertmanager -secret monitoring/alertmanager-main
+//	self-healing-operator register-alertmanager -configmap monitoring/alertmanager-config
+//	self-healing-operator register-alertmanager -alertmanagerconfig payments/self-healing
+//
+// -secret and -configmap edit an alertmanager.yaml held in a Secret (the
+// Prometheus Operator's alertmanager-<name>, or a plain Alertmanager's) or a
+// ConfigMap: the receiver is added or replaced, and a route to it is put
+// first under the root route with continue: true, so the alerts still reach
+// every receiver they did before. -alertmanagerconfig creates or updates a
+// Prometheus Operator AlertmanagerConfig with the same receiver and route
+// instead; the Prometheus Operator limits its route to alerts from the CR's
+// namespace.
+//
+// It's idempotent — run it again after changing -url or -match and it
+// updates what it added — and -dry-run prints the result without changing
+// anything. The config is written back as YAML, so comments in it are lost.
+// Alertmanager (or its config reloader) picks the change up on its own.
+// It uses KUBECONFIG (or ~/.kube/config) when there's one and the in-cluster
+// config otherwise, so it runs from a laptop or as a Job.
+
+var alertmanagerConfigGVR = schema.GroupVersionResource{Group: "monitoring.coreos.com", Version: "v1alpha1", Resource: "alertmanagerconfigs"}
+
+// amRegistration is the receiver and route to register
+type amRegistration struct {
+	receiver string
+	url      string
+	matchers []amMatcher
+	cont     bool
+}
+
+// amMatcher is one Alertmanager label matcher
+type amMatcher struct {
+	name, op, value string
+}
+
+// parseAMMatcher parses name=value, name!=value, name=~regex or name!~regex
+func parseAMMatcher(s string) (amMatcher, error) {
+	i := strings.IndexAny(s, "=!")
+	if i <= 0 {
+		return amMatcher{}, fmt.Errorf("invalid matcher %q: want name=value, name!=value, name=~regex or name!~regex", s)
+	}
+	m := amMatcher{name: strings.TrimSpace(s[:i])}
+	rest := s[i:]
+	for _, op := range []string{"!=", "=~", "!~", "="} {
+		if strings.HasPrefix(rest, op) {
+			m.op, m.value = op, strings.TrimSpace(rest[len(op):])
+			break
+		}
+	}
+	if m.op == "" {
+		return amMatcher{}, fmt.Errorf("invalid matcher %q", s)
+	}
+	if v, err := strconv.Unquote(m.value); err == nil {
+		m.value = v
+	}
+	return m, nil
+}
+
+// matcherList collects repeated -match flags
+type matcherList []amMatcher
+
+func (l *matcherList) String() string { return fmt.Sprint(*l) }
+
+func (l *matcherList) Set(s string) error {
+	m, err := parseAMMatcher(s)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, m)
+	return nil
+}
+
+func runRegisterAlertmanager(args []string) int {
+	fs := flag.NewFlagSet("register-alertmanager", flag.ContinueOnError)
+	secret := fs.String("secret", "", "namespace/name of the Secret holding alertmanager.yaml")
+	configMap := fs.String("configmap", "", "namespace/name of the ConfigMap holding alertmanager.yaml")
+	amConfig := fs.String("alertmanagerconfig", "", "namespace/name of the AlertmanagerConfig to create or update")
+	key := fs.String("key", "", "key of the config in the Secret or ConfigMap (default: alertmanager.yaml, or the only .yaml/.yml key)")
+	namespace := envString("POD_NAMESPACE", "default")
+	url := fs.String("url", "http://self-healing-operator."+namespace+".svc:8080/webhook", "the operator's webhook URL")
+	receiver := fs.String("receiver", "self-healing-operator", "receiver name")
+	cont := fs.Bool("continue", true, "let alerts go on to the routes after the operator's")
+	dryRun := fs.Bool("dry-run", false, "print the result instead of writing it")
+	var matchers matcherList
+	fs.Var(&matchers, "match", "only route alerts matching this (repeatable), e.g. -match 'severity=~warning|critical'")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	targets := 0
+	for _, t := range []string{*secret, *configMap, *amConfig} {
+		if t != "" {
+			targets++
+		}
+	}
+	if targets != 1 || fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: self-healing-operator register-alertmanager -secret|-configmap|-alertmanagerconfig NAMESPACE/NAME [flags]")
+		fmt.Fprintln(os.Stderr, "run with -h for the flags")
+		return 2
+	}
+	reg := amRegistration{receiver: *receiver, url: *url, matchers: matchers, cont: *cont}
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Kubernetes config: %v\n", err)
+		return 2
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var result string
+	switch {
+	case *amConfig != "":
+		dyn, err := dynamic.NewForConfig(config)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		result, err = registerAlertmanagerConfig(ctx, dyn, *amConfig, reg, *dryRun)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "FAILED: %v\n", err)
+			return 1
+		}
+	default:
+		kube, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		store := amConfigStore{kube: kube, key: *key, secret: *secret != ""}
+		store.namespace, store.name = splitTarget(*configMap + *secret)
+		result, err = registerInStore(ctx, store, reg, *dryRun)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "FAILED: %v\n", err)
+			return 1
+		}
+	}
+	fmt.Println(result)
+	return 0
+}
+
+// splitTarget splits namespace/name; the namespace defaults to "default"
+func splitTarget(target string) (string, string) {
+	if ns, name, ok := strings.Cut(target, "/"); ok && ns != "" {
+		return ns, name
+	}
+	return "default", strings.TrimPrefix(target, "/")
+}
+
+// amConfigStore is the Secret or ConfigMap holding alertmanager.yaml
+type amConfigStore struct {
+	kube      kubernetes.Interface
+	secret    bool
+	namespace string
+	name      string
+	key       string
+}
+
+func (s amConfigStore) String() string {
+	kind := "ConfigMap"
+	if s.secret {
+		kind = "Secret"
+	}
+	return kind + " " + s.namespace + "/" + s.name
+}
+
+// registerInStore adds the registration to the config in a Secret or ConfigMap
+func registerInStore(ctx context.Context, s amConfigStore, reg amRegistration, dryRun bool) (string, error) {
+	result := ""
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		data, key, write, err := s.read(ctx)
+		if err != nil {
+			return err
+		}
+		var cfg map[string]interface{}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("%s key %s isn't valid YAML: %v", s, key, err)
+		}
+		if cfg == nil {
+			cfg = map[string]interface{}{}
+		}
+		before, err := yaml.Marshal(cfg)
+		if err != nil {
+			return err
+		}
+		if err := registerInConfig(cfg, reg); err != nil {
+			return err
+		}
+		after, err := yaml.Marshal(cfg)
+		if err != nil {
+			return err
+		}
+		switch {
+		case string(before) == string(after):
+			result = fmt.Sprintf("OK: receiver %q is already registered in %s", reg.receiver, s)
+			return nil
+		case dryRun:
+			result = string(after)
+			return nil
+		}
+		if err := write(after); err != nil {
+			return err
+		}
+		result = fmt.Sprintf("OK: receiver %q registered in %s (key %s)", reg.receiver, s, key)
+		return nil
+	})
+	return result, err
+}
+
+// read returns the config, its key and a function that writes a new one
+// with optimistic concurrency
+func (s amConfigStore) read(ctx context.Context) ([]byte, string, func([]byte) error, error) {
+	if s.secret {
+		sec, err := s.kube.CoreV1().Secrets(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+		if err != nil {
+			return nil, "", nil, err
+		}
+		data := map[string]string{}
+		for k, v := range sec.Data {
+			data[k] = string(v)
+		}
+		for k, v := range sec.StringData {
+			data[k] = v
+		}
+		key, err := configKey(s, data, s.key)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return []byte(data[key]), key, func(cfg []byte) error {
+			if sec.Data == nil {
+				sec.Data = map[string][]byte{}
+			}
+			sec.Data[key] = cfg
+			delete(sec.StringData, key)
+			_, err := s.kube.CoreV1().Secrets(s.namespace).Update(ctx, sec, metav1.UpdateOptions{})
+			return err
+		}, nil
+	}
+	cm, err := s.kube.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, "", nil, err
+	}
+	key, err := configKey(s, cm.Data, s.key)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return []byte(cm.Data[key]), key, func(cfg []byte) error {
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[key] = string(cfg)
+		_, err := s.kube.CoreV1().ConfigMaps(s.namespace).Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	}, nil
+}
+
+// configKey picks the key holding the config: the one asked for,
+// alertmanager.yaml, or the only .yaml/.yml key
+func configKey(s amConfigStore, data map[string]string, want string) (string, error) {
+	if want != "" {
+		if _, ok := data[want]; !ok {
+			return "", fmt.Errorf("%s has no key %s", s, want)
+		}
+		return want, nil
+	}
+	if _, ok := data["alertmanager.yaml"]; ok {
+		return "alertmanager.yaml", nil
+	}
+	var found []string
+	for _, k := range sortedKeys(data) {
+		if strings.HasSuffix(k, ".yaml") || strings.HasSuffix(k, ".yml") {
+			found = append(found, k)
+		}
+	}
+	if len(found) != 1 {
+		return "", fmt.Errorf("%s: can't tell which key holds the config (%s), set -key", s, strings.Join(sortedKeys(data), ", "))
+	}
+	return found[0], nil
+}
+
+// registerInConfig adds or replaces the receiver and its route in an
+// alertmanager.yaml. The route goes first under the root route, so it sees
+// every alert; routes to the receiver elsewhere are left alone.
+func registerInConfig(cfg map[string]interface{}, reg amRegistration) error {
+	receiver := map[string]interface{}{
+		"name": reg.receiver,
+		"webhook_configs": []interface{}{
+			map[string]interface{}{"url": reg.url, "send_resolved": true},
+		},
+	}
+	receivers, ok := cfg["receivers"].([]interface{})
+	if _, present := cfg["receivers"]; present && !ok {
+		return fmt.Errorf("receivers isn't a list")
+	}
+	replaced := false
+	for i, r := range receivers {
+		if m, ok := r.(map[string]interface{}); ok && m["name"] == reg.receiver {
+			receivers[i], replaced = receiver, true
+		}
+	}
+	if !replaced {
+		receivers = append(receivers, receiver)
+	}
+	cfg["receivers"] = receivers
+
+	root, ok := cfg["route"].(map[string]interface{})
+	if !ok {
+		if _, present := cfg["route"]; present {
+			return fmt.Errorf("route isn't a mapping")
+		}
+		// no routing tree yet: everything goes to the operator
+		cfg["route"] = map[string]interface{}{"receiver": reg.receiver}
+		return nil
+	}
+	route := map[string]interface{}{"receiver": reg.receiver, "continue": reg.cont}
+	if len(reg.matchers) > 0 {
+		var matchers []interface{}
+		for _, m := range reg.matchers {
+			matchers = append(matchers, m.name+m.op+strconv.Quote(m.value))
+		}
+		route["matchers"] = matchers
+	}
+	routes := []interface{}{route}
+	for _, r := range asList(root["routes"]) {
+		// the route added by an earlier run is replaced
+		if m, ok := r.(map[string]interface{}); ok && m["receiver"] == reg.receiver && isOwnRoute(m) {
+			continue
+		}
+		routes = append(routes, r)
+	}
+	root["routes"] = routes
+	return nil
+}
+
+// isOwnRoute reports whether a route to the receiver was added by registerInConfig
+func isOwnRoute(route map[string]interface{}) bool {
+	for k := range route {
+		if k != "receiver" && k != "continue" && k != "matchers" {
+			return false
+		}
+	}
+	return true
+}
+
+func asList(v interface{}) []interface{} {
+	l, _ := v.([]interface{})
+	return l
+}
+
+// registerAlertmanagerConfig creates or updates an AlertmanagerConfig with the registration
+func registerAlertmanagerConfig(ctx context.Context, dyn dynamic.Interface, target string, reg amRegistration, dryRun bool) (string, error) {
+	namespace, name := splitTarget(target)
+	route := map[string]interface{}{"receiver": reg.receiver, "continue": reg.cont}
+	if len(reg.matchers) > 0 {
+		var matchers []interface{}
+		for _, m := range reg.matchers {
+			matchers = append(matchers, map[string]interface{}{"name": m.name, "value": m.value, "matchType": m.op})
+		}
+		route["matchers"] = matchers
+	}
+	spec := map[string]interface{}{
+		"route": route,
+		"receivers": []interface{}{map[string]interface{}{
+			"name":           reg.receiver,
+			"webhookConfigs": []interface{}{map[string]interface{}{"url": reg.url, "sendResolved": true}},
+		}},
+	}
+
+	objects := dyn.Resource(alertmanagerConfigGVR).Namespace(namespace)
+	result := ""
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj, err := objects.Get(ctx, name, metav1.GetOptions{})
+		create := apierrors.IsNotFound(err)
+		switch {
+		case create:
+			obj = &unstructured.Unstructured{}
+			obj.SetAPIVersion(alertmanagerConfigGVR.GroupVersion().String())
+			obj.SetKind("AlertmanagerConfig")
+			obj.SetNamespace(namespace)
+			obj.SetName(name)
+			obj.SetLabels(map[string]string{managedByLabel: managedByValue})
+		case err != nil:
+			return err
+		}
+		if !create && reflect.DeepEqual(normalizeJSON(obj.Object["spec"]), normalizeJSON(spec)) {
+			result = fmt.Sprintf("OK: AlertmanagerConfig %s/%s is up to date", namespace, name)
+			return nil
+		}
+		obj.Object["spec"] = spec
+		if dryRun {
+			out, err := yaml.Marshal(obj.Object)
+			result = string(out)
+			return err
+		}
+		if create {
+			_, err = objects.Create(ctx, obj, metav1.CreateOptions{})
+		} else {
+			_, err = objects.Update(ctx, obj, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return err
+		}
+		result = fmt.Sprintf("OK: AlertmanagerConfig %s/%s routes to receiver %q", namespace, name, reg.receiver)
+		return nil
+	})
+	return result, err
+}
+
+// normalizeJSON round-trips v through JSON so specs built here compare with ones read back
+func normalizeJSON(v interface{}) interface{} {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out interface{}
+	if err := yaml.Unmarshal(data, &out); err != nil {
+		return v
+	}
+	return out
+}
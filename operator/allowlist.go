@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+)
+
+// Source allowlist. With WEBHOOK_ALLOWED_CIDRS set, alerts are only accepted
+// from those networks: /webhook, the adapter endpoints and test-alert answer
+// 403 to anyone else. Health checks and approval links stay open. Other
+// replicas forwarding alerts (SHARDING) are always let through, and so are
+// requests on a unix socket, which only a local sidecar can reach. Behind a
+// proxy or ingress listed in WEBHOOK_TRUSTED_PROXIES the client address is
+// taken from X-Forwarded-For instead.
+//
+// The allowlist only helps if nothing else can reach the pod on another path,
+// so the admin endpoint /api/v1/networkpolicy renders the matching
+// NetworkPolicy: the webhook port open to the allowed networks (and the other
+// replicas), the admin port open to the namespaces in ?admin-from= (default
+// monitoring, where Prometheus runs; empty closes it).
+//
+//	curl localhost:9090/api/v1/networkpolicy?admin-from=monitoring | kubectl apply -f -
+
+var (
+	webhookAllowedCIDRs   = parseCIDRs("WEBHOOK_ALLOWED_CIDRS")
+	webhookTrustedProxies = parseCIDRs("WEBHOOK_TRUSTED_PROXIES")
+
+	allowlistMu       sync.Mutex
+	allowlistRejected = map[string]int{} // path -> count
+)
+
+func init() {
+	registerMetrics(writeAllowlistMetrics)
+}
+
+// parseCIDRs reads a list of CIDRs or single addresses; a bad entry stops the operator
+func parseCIDRs(key string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, s := range envList(key) {
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			log.Fatalf("Invalid %s entry %q: %v", key, s, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the address the request came from: the peer, or for a trusted
+// proxy the last X-Forwarded-For hop that isn't one. nil for unix sockets.
+func clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	if !containsIP(webhookTrustedProxies, ip) {
+		return ip
+	}
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !containsIP(webhookTrustedProxies, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// isMember reports whether ip is the webhook address of a replica in the shard group
+func (s *shardRing) isMember(ip net.IP) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, endpoint := range s.members {
+		if u, err := url.Parse(endpoint); err == nil && ip.Equal(net.ParseIP(u.Hostname())) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowSources rejects alert deliveries from outside WEBHOOK_ALLOWED_CIDRS
+func allowSources(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(webhookAllowedCIDRs) == 0 {
+			next(w, r)
+			return
+		}
+		ip := clientIP(r)
+		if ip == nil || containsIP(webhookAllowedCIDRs, ip) || (shardingEnabled && shards.isMember(ip)) {
+			next(w, r)
+			return
+		}
+		log.Printf("Rejected %s %s from %s — not in WEBHOOK_ALLOWED_CIDRS", r.Method, r.URL.Path, ip)
+		allowlistMu.Lock()
+		allowlistRejected[r.URL.Path]++
+		allowlistMu.Unlock()
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	}
+}
+
+func writeAllowlistMetrics(w io.Writer) {
+	allowlistMu.Lock()
+	defer allowlistMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_webhook_rejected_total Requests rejected by the source allowlist, by path.")
+	fmt.Fprintln(w, "# TYPE selfhealing_webhook_rejected_total counter")
+	for _, path := range sortedKeys(allowlistRejected) {
+		fmt.Fprintf(w, "selfhealing_webhook_rejected_total{path=%q} %d\n", path, allowlistRejected[path])
+	}
+}
+
+// listenPort is the TCP port of the first host:port address, or def
+func listenPort(addrs []string, def int) int {
+	for _, addr := range addrs {
+		if _, port, err := net.SplitHostPort(addr); err == nil && !strings.HasPrefix(addr, "unix:") {
+			if p, err := strconv.Atoi(port); err == nil {
+				return p
+			}
+		}
+	}
+	return def
+}
+
+// operatorNetworkPolicy builds the NetworkPolicy that matches the allowlist
+func operatorNetworkPolicy(adminFrom []string) *networkingv1.NetworkPolicy {
+	tcp := corev1.ProtocolTCP
+	webhookPort := intstr.FromInt(listenPort(listenAddrs("LISTEN_ADDR", "PORT", "8080"), 8080))
+	adminPort := intstr.FromInt(listenPort(listenAddrs("ADMIN_LISTEN_ADDR", "ADMIN_PORT", "9090"), 9090))
+	operatorPods := metav1.LabelSelector{MatchLabels: map[string]string{"app": "self-healing-operator"}}
+
+	webhook := networkingv1.NetworkPolicyIngressRule{
+		Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &webhookPort}},
+	}
+	for _, n := range webhookAllowedCIDRs {
+		webhook.From = append(webhook.From, networkingv1.NetworkPolicyPeer{IPBlock: &networkingv1.IPBlock{CIDR: n.String()}})
+	}
+	if len(webhook.From) > 0 {
+		// other replicas forward alerts they don't own
+		webhook.From = append(webhook.From, networkingv1.NetworkPolicyPeer{PodSelector: &operatorPods})
+	}
+
+	rules := []networkingv1.NetworkPolicyIngressRule{webhook}
+	if len(adminFrom) > 0 {
+		// a rule without peers would open the admin port to everyone
+		admin := networkingv1.NetworkPolicyIngressRule{
+			Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &adminPort}},
+		}
+		for _, ns := range adminFrom {
+			admin.From = append(admin.From, networkingv1.NetworkPolicyPeer{NamespaceSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"kubernetes.io/metadata.name": ns},
+			}})
+		}
+		rules = append(rules, admin)
+	}
+
+	return &networkingv1.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{APIVersion: "networking.k8s.io/v1", Kind: "NetworkPolicy"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "self-healing-operator",
+			Namespace: envString("POD_NAMESPACE", "default"),
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: operatorPods,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress:     rules,
+		},
+	}
+}
+
+// handleNetworkPolicy renders the operator's NetworkPolicy as YAML
+func handleNetworkPolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	adminFrom := []string{"monitoring"}
+	if v, ok := r.URL.Query()["admin-from"]; ok {
+		adminFrom = nil
+		for _, ns := range strings.Split(strings.Join(v, ","), ",") {
+			if ns = strings.TrimSpace(ns); ns != "" {
+				adminFrom = append(adminFrom, ns)
+			}
+		}
+	}
+	out, err := yaml.Marshal(operatorNetworkPolicy(adminFrom))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(out)
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Remediation analytics: which workloads the operator heals most, how often,
+// and how long they take to recover — the list to fix root causes from.
+// GET /api/v1/analytics?window=168h&top=10 reports, over the window:
+//   - the most-healed workloads, ranked by remediations, each with its
+//     failures, the alert and action it needed most, its MTTR and its trend
+//   - the trend: remediations in the window against the window before it
+//     (rising, falling, flat — a change of less than 20% — or new)
+//   - remediations per day
+//
+// ?tag=team=payments only counts remediations whose policy carries that tag,
+// and ?by=cost_center adds remediations, failures and MTTR per value of a tag
+// (see tags.go).
+//
+// MTTR is the mean time from an alert starting to fire until it resolved, for
+// alerts that were remediated. Recoveries are observed from resolved
+// notifications and kept in memory (the last analyticsMaxRecoveries; a
+// restart forgets them, like the SLA timers); the rest comes from the action
+// history, so it reaches back as far as HISTORY_SIZE records do.
+//
+// The same figures are exported for dashboards: selfhealing_workload_mttr_seconds
+// (a summary per workload) and selfhealing_top_healed_remediations (the
+// top ANALYTICS_TOP workloads by rank) over ANALYTICS_WINDOW.
+
+var (
+	analyticsWindow = envDuration("ANALYTICS_WINDOW", 7*24*time.Hour)
+	analyticsTop    = envInt("ANALYTICS_TOP", 10)
+
+	analyticsMu sync.Mutex
+	recoveries  []recoverySample
+	// remediationStarts holds when the latest remediations of each
+	// alertname|namespace/app started, oldest first, so a resolved alert
+	// finds its remediation without scanning the history
+	remediationStarts = map[string][]time.Time{}
+)
+
+// analyticsMaxRecoveries is how many recoveries are kept for MTTR
+const analyticsMaxRecoveries = 5000
+
+// analyticsStartsPerTarget is how many remediation starts are kept per alert and workload
+const analyticsStartsPerTarget = 16
+
+// mttrQuantiles are the quantiles of the MTTR summary
+var mttrQuantiles = []float64{0.5, 0.9, 0.99}
+
+// recoverySample is one remediated alert that resolved
+type recoverySample struct {
+	workload string
+	resolved time.Time
+	took     time.Duration
+}
+
+// RemediationAnalytics is the report of /api/v1/analytics
+type RemediationAnalytics struct {
+	Window       string              `json:"window"`
+	Tag          string              `json:"tag,omitempty"` // the tag=value filter
+	Since        time.Time           `json:"since"`
+	Remediations int                 `json:"remediations"`
+	Previous     int                 `json:"previousWindow"` // remediations in the window before
+	MTTRSeconds  float64             `json:"mttrSeconds,omitempty"`
+	Recoveries   int                 `json:"recoveries"`
+	Workloads    []WorkloadAnalytics `json:"topWorkloads"`
+	Daily        []DailyCount        `json:"daily"`
+	By           string              `json:"by,omitempty"`
+	ByTag        []TagAnalytics      `json:"byTag,omitempty"`
+}
+
+// WorkloadAnalytics is one workload's figures over the window
+type WorkloadAnalytics struct {
+	Rank          int            `json:"rank"`
+	Workload      string         `json:"workload"`
+	Remediations  int            `json:"remediations"`
+	Failed        int            `json:"failed"`
+	Previous      int            `json:"previousWindow"`
+	Trend         string         `json:"trend"` // rising, falling, flat, new
+	ChangePercent float64        `json:"changePercent,omitempty"`
+	TopAlert      string         `json:"topAlert"`
+	Actions       map[string]int `json:"actions"`
+	MTTRSeconds   float64        `json:"mttrSeconds,omitempty"`
+	Recoveries    int            `json:"recoveries"`
+	Last          time.Time      `json:"last"`
+}
+
+// TagAnalytics is the figures of one value of the ?by tag; "" is untagged
+type TagAnalytics struct {
+	Value        string  `json:"value"`
+	Remediations int     `json:"remediations"`
+	Failed       int     `json:"failed"`
+	Workloads    int     `json:"workloads"`
+	MTTRSeconds  float64 `json:"mttrSeconds,omitempty"`
+	Recoveries   int     `json:"recoveries"`
+}
+
+// analyticsScope narrows a report to a tag and breaks it down by another
+type analyticsScope struct {
+	tagKey, tagValue string
+	by               string
+}
+
+// DailyCount is the remediations of one day (UTC)
+type DailyCount struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+// observeAnalyticsResolved records the recovery time of a resolved alert that was remediated
+func observeAnalyticsResolved(alert Alert) {
+	if alert.Status != "resolved" {
+		return
+	}
+	// keyed like the history, which defaults the namespace
+	t := alert.Target()
+	resolved := alert.EndsAt
+	if resolved.IsZero() || resolved.After(time.Now()) {
+		resolved = time.Now()
+	}
+	// the remediation of this episode: the first for the alert since it started firing
+	var first time.Time
+	analyticsMu.Lock()
+	for _, at := range remediationStarts[remediationStartsKey(t.AlertName, t.Namespace, t.App)] {
+		if alert.StartsAt.IsZero() || !at.Before(alert.StartsAt) {
+			first = at
+			break
+		}
+	}
+	analyticsMu.Unlock()
+	if first.IsZero() {
+		return
+	}
+	started := alert.StartsAt
+	if started.IsZero() {
+		started = first
+	}
+	took := resolved.Sub(started)
+	if took < 0 {
+		return
+	}
+	analyticsMu.Lock()
+	recoveries = append(recoveries, recoverySample{workload: t.Namespace + "/" + t.App, resolved: resolved, took: took})
+	if len(recoveries) > analyticsMaxRecoveries {
+		recoveries = recoveries[len(recoveries)-analyticsMaxRecoveries:]
+	}
+	analyticsMu.Unlock()
+}
+
+func remediationStartsKey(alertname, namespace, app string) string {
+	return alertname + "|" + namespace + "/" + app
+}
+
+// indexRemediation adds a finished remediation to remediationStarts
+func indexRemediation(rec ActionRecord) {
+	key := remediationStartsKey(rec.AlertName, rec.Namespace, rec.App)
+	analyticsMu.Lock()
+	defer analyticsMu.Unlock()
+	starts := remediationStarts[key]
+	// hooks run concurrently, so records can arrive a little out of order
+	i := len(starts)
+	for i > 0 && starts[i-1].After(rec.StartedAt) {
+		i--
+	}
+	starts = append(starts, time.Time{})
+	copy(starts[i+1:], starts[i:])
+	starts[i] = rec.StartedAt
+	if len(starts) > analyticsStartsPerTarget {
+		starts = starts[len(starts)-analyticsStartsPerTarget:]
+	}
+	remediationStarts[key] = starts
+}
+
+// restoreRemediationIndex indexes the history loaded from the store
+func restoreRemediationIndex(recs []ActionRecord) {
+	for _, rec := range recs {
+		indexRemediation(rec)
+	}
+}
+
+// recoveryTimesSince returns the recovery times by workload since the time
+func recoveryTimesSince(since time.Time) map[string][]float64 {
+	analyticsMu.Lock()
+	defer analyticsMu.Unlock()
+	out := map[string][]float64{}
+	for _, s := range recoveries {
+		if !s.resolved.Before(since) {
+			out[s.workload] = append(out[s.workload], s.took.Seconds())
+		}
+	}
+	return out
+}
+
+// computeAnalytics reports the window ending now, with the top workloads
+func computeAnalytics(window time.Duration, top int, scope analyticsScope) RemediationAnalytics {
+	now := time.Now()
+	since, before := now.Add(-window), now.Add(-2*window)
+	report := RemediationAnalytics{Window: window.String(), Since: since, Workloads: []WorkloadAnalytics{}, Daily: []DailyCount{}, By: scope.by}
+	if scope.tagKey != "" {
+		report.Tag = scope.tagKey + "=" + scope.tagValue
+	}
+
+	byWorkload := map[string]*WorkloadAnalytics{}
+	alerts := map[string]map[string]int{}
+	previous := map[string]int{}
+	daily := map[string]int{}
+	byTag := map[string]*TagAnalytics{}
+	workloadTag := map[string]string{} // workload -> its latest value of the ?by tag
+	for _, rec := range listHistory(0) {
+		if rec.StartedAt.Before(before) || (scope.tagKey != "" && rec.Tags[scope.tagKey] != scope.tagValue) {
+			continue
+		}
+		workload := rec.Namespace + "/" + rec.App
+		if rec.StartedAt.Before(since) {
+			previous[workload]++
+			report.Previous++
+			continue
+		}
+		wa := byWorkload[workload]
+		if wa == nil {
+			wa = &WorkloadAnalytics{Workload: workload, Actions: map[string]int{}, Last: rec.StartedAt}
+			byWorkload[workload] = wa
+			alerts[workload] = map[string]int{}
+		}
+		wa.Remediations++
+		if rec.Outcome == "failed" {
+			wa.Failed++
+		}
+		wa.Actions[rec.Action]++
+		alerts[workload][rec.AlertName]++
+		daily[rec.StartedAt.UTC().Format("2006-01-02")]++
+		report.Remediations++
+		if scope.by != "" {
+			value := rec.Tags[scope.by]
+			ta := byTag[value]
+			if ta == nil {
+				ta = &TagAnalytics{Value: value}
+				byTag[value] = ta
+			}
+			ta.Remediations++
+			if rec.Outcome == "failed" {
+				ta.Failed++
+			}
+			if _, seen := workloadTag[workload]; !seen {
+				workloadTag[workload] = value
+				ta.Workloads++
+			}
+		}
+	}
+
+	times := recoveryTimesSince(since)
+	var all []float64
+	for workload, wa := range byWorkload {
+		wa.Previous = previous[workload]
+		wa.Trend, wa.ChangePercent = trendOf(wa.Remediations, wa.Previous)
+		wa.TopAlert = topKey(alerts[workload])
+		if t := times[workload]; len(t) > 0 {
+			wa.MTTRSeconds, wa.Recoveries = mean(t), len(t)
+		}
+	}
+	tagTimes := map[string][]float64{}
+	for workload, t := range times {
+		if scope.tagKey != "" && byWorkload[workload] == nil {
+			continue
+		}
+		all = append(all, t...)
+		if value, ok := workloadTag[workload]; ok {
+			tagTimes[value] = append(tagTimes[value], t...)
+		}
+	}
+	if len(all) > 0 {
+		report.MTTRSeconds, report.Recoveries = mean(all), len(all)
+	}
+
+	ranked := make([]*WorkloadAnalytics, 0, len(byWorkload))
+	for _, wa := range byWorkload {
+		ranked = append(ranked, wa)
+	}
+	sort.Slice(ranked, func(i, k int) bool {
+		if ranked[i].Remediations != ranked[k].Remediations {
+			return ranked[i].Remediations > ranked[k].Remediations
+		}
+		return ranked[i].Workload < ranked[k].Workload
+	})
+	for i, wa := range ranked {
+		if top > 0 && i >= top {
+			break
+		}
+		wa.Rank = i + 1
+		report.Workloads = append(report.Workloads, *wa)
+	}
+	for _, day := range sortedKeys(daily) {
+		report.Daily = append(report.Daily, DailyCount{Date: day, Count: daily[day]})
+	}
+	for _, value := range sortedKeys(byTag) {
+		ta := byTag[value]
+		if t := tagTimes[value]; len(t) > 0 {
+			ta.MTTRSeconds, ta.Recoveries = mean(t), len(t)
+		}
+		report.ByTag = append(report.ByTag, *ta)
+	}
+	sort.SliceStable(report.ByTag, func(i, k int) bool { return report.ByTag[i].Remediations > report.ByTag[k].Remediations })
+	return report
+}
+
+// trendOf compares a count with the one of the window before
+func trendOf(count, previous int) (string, float64) {
+	if previous == 0 {
+		return "new", 0
+	}
+	change := math.Round(float64(count-previous)/float64(previous)*1000) / 10
+	switch {
+	case change >= 20:
+		return "rising", change
+	case change <= -20:
+		return "falling", change
+	}
+	return "flat", change
+}
+
+// topKey returns the key with the highest count, the first by name on a tie
+func topKey(counts map[string]int) string {
+	best := ""
+	for _, k := range sortedKeys(counts) {
+		if best == "" || counts[k] > counts[best] {
+			best = k
+		}
+	}
+	return best
+}
+
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return math.Round(sum/float64(len(values))*10) / 10
+}
+
+// quantile returns the q-quantile of sorted values
+func quantile(sorted []float64, q float64) float64 {
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func handleAnalytics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	window, top := analyticsWindow, analyticsTop
+	var scope analyticsScope
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "window must be a duration, e.g. 168h", http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+	if v := r.URL.Query().Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "top must be a number", http.StatusBadRequest)
+			return
+		}
+		top = n
+	}
+	if v := r.URL.Query().Get("tag"); v != "" {
+		key, value, err := parseTagFilter(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		scope.tagKey, scope.tagValue = key, value
+	}
+	if v := r.URL.Query().Get("by"); v != "" {
+		if !isTagKey(v) {
+			http.Error(w, "by must be a key from REMEDIATION_TAGS ("+strings.Join(remediationTagKeys, ", ")+")", http.StatusBadRequest)
+			return
+		}
+		scope.by = v
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(computeAnalytics(window, top, scope))
+}
+
+func init() {
+	registerMetrics(writeAnalyticsMetrics)
+	onRemediation(indexRemediation)
+}
+
+func writeAnalyticsMetrics(w io.Writer) {
+	times := recoveryTimesSince(time.Now().Add(-analyticsWindow))
+	fmt.Fprintln(w, "# HELP selfhealing_workload_mttr_seconds Time from a remediated alert firing until it resolved, by workload, over ANALYTICS_WINDOW.")
+	fmt.Fprintln(w, "# TYPE selfhealing_workload_mttr_seconds summary")
+	for _, workload := range sortedKeys(times) {
+		t := times[workload]
+		sort.Float64s(t)
+		namespace, app, _ := strings.Cut(workload, "/")
+		labels := fmt.Sprintf("namespace=%q,app=%q", namespace, app)
+		for _, q := range mttrQuantiles {
+			fmt.Fprintf(w, "selfhealing_workload_mttr_seconds{%s,quantile=\"%g\"} %g\n", labels, q, quantile(t, q))
+		}
+		sum := 0.0
+		for _, v := range t {
+			sum += v
+		}
+		fmt.Fprintf(w, "selfhealing_workload_mttr_seconds_sum{%s} %g\n", labels, sum)
+		fmt.Fprintf(w, "selfhealing_workload_mttr_seconds_count{%s} %d\n", labels, len(t))
+	}
+	report := computeAnalytics(analyticsWindow, analyticsTop, analyticsScope{})
+	fmt.Fprintln(w, "# HELP selfhealing_top_healed_remediations Remediations of the most-healed workloads over ANALYTICS_WINDOW, by rank.")
+	fmt.Fprintln(w, "# TYPE selfhealing_top_healed_remediations gauge")
+	for _, wa := range report.Workloads {
+		namespace, app, _ := strings.Cut(wa.Workload, "/")
+		fmt.Fprintf(w, "selfhealing_top_healed_remediations{rank=\"%d\",namespace=%q,app=%q,trend=%q} %d\n", wa.Rank, namespace, app, wa.Trend, wa.Remediations)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestObserveAnalyticsResolved(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	remediated := ActionRecord{AlertName: "Down", Namespace: "default", App: "cart", StartedAt: start.Add(time.Minute)}
+	tests := []struct {
+		name   string
+		labels map[string]string
+		starts time.Time
+		want   string // workload of the recovery, "" for none
+	}{
+		{"namespace label", map[string]string{"alertname": "Down", "namespace": "default", "app": "cart"}, start, "default/cart"},
+		{"no namespace label", map[string]string{"alertname": "Down", "app": "cart"}, start, "default/cart"},
+		{"other alert", map[string]string{"alertname": "Slow", "app": "cart"}, start, ""},
+		{"other workload", map[string]string{"alertname": "Down", "app": "web"}, start, ""},
+		{"remediated before it fired", map[string]string{"alertname": "Down", "app": "cart"}, start.Add(2 * time.Minute), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analyticsMu.Lock()
+			savedRecoveries, savedStarts := recoveries, remediationStarts
+			recoveries, remediationStarts = nil, map[string][]time.Time{}
+			analyticsMu.Unlock()
+			defer func() {
+				analyticsMu.Lock()
+				recoveries, remediationStarts = savedRecoveries, savedStarts
+				analyticsMu.Unlock()
+			}()
+
+			indexRemediation(remediated)
+			observeAnalyticsResolved(Alert{Status: "resolved", Labels: tt.labels, StartsAt: tt.starts, EndsAt: start.Add(10 * time.Minute)})
+
+			analyticsMu.Lock()
+			got := ""
+			if len(recoveries) > 0 {
+				got = recoveries[0].workload
+			}
+			analyticsMu.Unlock()
+			if got != tt.want {
+				t.Errorf("recovery for %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexRemediationKeepsOrder(t *testing.T) {
+	analyticsMu.Lock()
+	saved := remediationStarts
+	remediationStarts = map[string][]time.Time{}
+	analyticsMu.Unlock()
+	defer func() {
+		analyticsMu.Lock()
+		remediationStarts = saved
+		analyticsMu.Unlock()
+	}()
+
+	base := time.Now()
+	for _, offset := range []int{3, 1, 2} {
+		indexRemediation(ActionRecord{AlertName: "Down", Namespace: "shop", App: "cart", StartedAt: base.Add(time.Duration(offset) * time.Minute)})
+	}
+	key := remediationStartsKey("Down", "shop", "cart")
+	if got := remediationStarts[key]; !got[0].Before(got[1]) || !got[1].Before(got[2]) {
+		t.Errorf("starts out of order: %v", got)
+	}
+	for i := 0; i < analyticsStartsPerTarget; i++ {
+		indexRemediation(ActionRecord{AlertName: "Down", Namespace: "shop", App: "cart", StartedAt: base.Add(time.Hour)})
+	}
+	if n := len(remediationStarts[key]); n != analyticsStartsPerTarget {
+		t.Errorf("%d starts kept, want %d", n, analyticsStartsPerTarget)
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Signed audit trail: every history record is chained to the one before it
+// (prevDigest = SHA-256 of the previous record's signed payload) and signed,
+// so removing, reordering or editing a record breaks verification.
+//
+// The signer is either a local key (AUDIT_SIGNING_KEY, a PKCS#8 PEM file with
+// an ECDSA P-256 or Ed25519 key — the same key types cosign uses) or a
+// HashiCorp Vault transit key (AUDIT_VAULT_KEY, type ecdsa-p256), so the
+// private key never leaves the KMS. ECDSA signatures are ASN.1 over the
+// SHA-256 of the payload, as produced by `cosign sign-blob`.
+//
+// Verify an export offline with the public key:
+//
+//	curl -s operator:8080/api/v1/history | self-healing-operator verify-audit cosign.pub
+
+// AuditSignature is attached to every record when signing is enabled
+type AuditSignature struct {
+	KeyID     string `json:"keyId"`
+	Algorithm string `json:"algorithm"` // ecdsa-p256-sha256, ed25519
+	Value     string `json:"value"`     // base64
+}
+
+type auditSigner interface {
+	KeyID() string
+	Algorithm() string
+	Sign(ctx context.Context, payload []byte) ([]byte, error)
+}
+
+var (
+	auditSignerImpl auditSigner
+
+	// auditChainMu orders records: it guards nextHistoryID and lastAuditDigest,
+	// the digest of the newest record. It isn't held while signing.
+	auditChainMu    sync.Mutex
+	lastAuditDigest string
+
+	auditMu      sync.Mutex
+	auditResults = map[string]int{}
+)
+
+func init() {
+	registerMetrics(writeAuditMetrics)
+}
+
+func setupAuditSigning() error {
+	var err error
+	switch {
+	case os.Getenv("AUDIT_SIGNING_KEY") != "":
+		auditSignerImpl, err = newKeySigner(os.Getenv("AUDIT_SIGNING_KEY"))
+	case os.Getenv("AUDIT_VAULT_KEY") != "":
+		auditSignerImpl, err = newVaultSigner()
+	case envBool("AUDIT_REQUIRE_SIGNING", false):
+		return fmt.Errorf("AUDIT_REQUIRE_SIGNING is set but neither AUDIT_SIGNING_KEY nor AUDIT_VAULT_KEY is configured")
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Audit records signed with %s key %s", auditSignerImpl.Algorithm(), auditSignerImpl.KeyID())
+	return nil
+}
+
+// auditPayload is the exact byte string that is digested and signed: the
+// record as JSON without its signature
+func auditPayload(rec ActionRecord) ([]byte, error) {
+	rec.Signature = nil
+	return json.Marshal(rec)
+}
+
+func auditDigest(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// chainAuditRecord gives a record the next ID and, with signing enabled,
+// links it to the previous record. It returns the payload to sign.
+func chainAuditRecord(rec *ActionRecord) ([]byte, error) {
+	auditChainMu.Lock()
+	defer auditChainMu.Unlock()
+	rec.ID = nextHistoryID
+	nextHistoryID++
+	if auditSignerImpl == nil {
+		return nil, nil
+	}
+	rec.PrevDigest = lastAuditDigest
+	payload, err := auditPayload(*rec)
+	if err != nil {
+		return nil, err
+	}
+	lastAuditDigest = auditDigest(payload)
+	return payload, nil
+}
+
+// signAuditRecord signs a chained record. Signing may call out to Vault, so no
+// lock is held; err is chainAuditRecord's.
+func signAuditRecord(rec *ActionRecord, payload []byte, err error) {
+	if auditSignerImpl == nil {
+		return
+	}
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		var sig []byte
+		sig, err = auditSignerImpl.Sign(ctx, payload)
+		cancel()
+		if err == nil {
+			rec.Signature = &AuditSignature{
+				KeyID:     auditSignerImpl.KeyID(),
+				Algorithm: auditSignerImpl.Algorithm(),
+				Value:     base64.StdEncoding.EncodeToString(sig),
+			}
+		}
+	}
+	result := "signed"
+	if err != nil {
+		// keep the chain intact: the unsigned record still carries its digest
+		// link, and verification reports it as unsigned
+		log.Printf("Failed to sign audit record #%d: %v", rec.ID, err)
+		result = "failed"
+	}
+
+	auditMu.Lock()
+	auditResults[result]++
+	auditMu.Unlock()
+}
+
+// restoreAuditChain continues the IDs and the chain from the persisted records
+func restoreAuditChain(recs []ActionRecord) {
+	if len(recs) == 0 {
+		return
+	}
+	auditChainMu.Lock()
+	defer auditChainMu.Unlock()
+	for _, r := range recs {
+		if r.ID >= nextHistoryID {
+			nextHistoryID = r.ID + 1
+		}
+	}
+	if payload, err := auditPayload(recs[len(recs)-1]); err == nil {
+		lastAuditDigest = auditDigest(payload)
+	}
+}
+
+func writeAuditMetrics(w io.Writer) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_audit_signatures_total Audit records by signing result.")
+	fmt.Fprintln(w, "# TYPE selfhealing_audit_signatures_total counter")
+	for _, result := range sortedKeys(auditResults) {
+		fmt.Fprintf(w, "selfhealing_audit_signatures_total{result=%q} %d\n", result, auditResults[result])
+	}
+}
+
+// keySigner signs with a local private key
+type keySigner struct {
+	key   crypto.Signer
+	keyID string
+}
+
+func newKeySigner(path string) (*keySigner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit signing key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("audit signing key %s is not PEM encoded", path)
+	}
+	if strings.Contains(block.Type, "ENCRYPTED") {
+		return nil, fmt.Errorf("audit signing key %s is encrypted; provide an unencrypted PKCS#8 key (e.g. from a Kubernetes Secret)", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse audit signing key: %v", err)
+	}
+	var signer crypto.Signer
+	switch k := parsed.(type) {
+	case *ecdsa.PrivateKey:
+		if k.Curve.Params().Name != "P-256" {
+			return nil, fmt.Errorf("unsupported ECDSA curve %s for audit signing (want P-256)", k.Curve.Params().Name)
+		}
+		signer = k
+	case ed25519.PrivateKey:
+		if err := cryptoAllowed("audit signing key "+path, "ed25519"); err != nil {
+			return nil, err
+		}
+		signer = k
+	default:
+		return nil, fmt.Errorf("unsupported audit signing key type %T", parsed)
+	}
+	keyID, err := publicKeyID(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &keySigner{key: signer, keyID: keyID}, nil
+}
+
+func (s *keySigner) KeyID() string { return s.keyID }
+
+func (s *keySigner) Algorithm() string {
+	if _, ok := s.key.(ed25519.PrivateKey); ok {
+		return "ed25519"
+	}
+	return "ecdsa-p256-sha256"
+}
+
+func (s *keySigner) Sign(_ context.Context, payload []byte) ([]byte, error) {
+	if _, ok := s.key.(ed25519.PrivateKey); ok {
+		return s.key.Sign(rand.Reader, payload, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(payload)
+	return s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// publicKeyID is the hex SHA-256 of the DER public key, truncated like a
+// short fingerprint
+func publicKeyID(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// vaultSigner signs through the Vault transit engine; the key must be of
+// type ecdsa-p256 so signatures verify like local ECDSA ones
+type vaultSigner struct {
+	addr  string
+	token string
+	mount string
+	key   string
+}
+
+func newVaultSigner() (*vaultSigner, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is required with AUDIT_VAULT_KEY")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		return nil, fmt.Errorf("VAULT_TOKEN is required with AUDIT_VAULT_KEY")
+	}
+	return &vaultSigner{
+		addr:  strings.TrimRight(addr, "/"),
+		token: token,
+		mount: envString("AUDIT_VAULT_MOUNT", "transit"),
+		key:   os.Getenv("AUDIT_VAULT_KEY"),
+	}, nil
+}
+
+func (s *vaultSigner) KeyID() string { return "vault:" + s.mount + "/" + s.key }
+
+func (s *vaultSigner) Algorithm() string { return "ecdsa-p256-sha256" }
+
+func (s *vaultSigner) Sign(ctx context.Context, payload []byte) ([]byte, error) {
+	digest := sha256.Sum256(payload)
+	body, err := json.Marshal(map[string]interface{}{
+		"input":                base64.StdEncoding.EncodeToString(digest[:]),
+		"prehashed":            true,
+		"hash_algorithm":       "sha2-256",
+		"marshaling_algorithm": "asn1",
+	})
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("%s/v1/%s/sign/%s", s.addr, s.mount, s.key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", s.token)
+	resp, err := egressClient("vault").Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault sign returned %s", resp.Status)
+	}
+	var out struct {
+		Data struct {
+			Signature string `json:"signature"` // vault:v<version>:<base64>
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("invalid vault sign response: %v", err)
+	}
+	parts := strings.SplitN(out.Data.Signature, ":", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("unexpected vault signature format")
+	}
+	return base64.StdEncoding.DecodeString(parts[2])
+}
+
+// runVerifyAudit implements the verify-audit subcommand: it reads a history
+// export (JSON array, any order) from stdin and checks signatures and the
+// digest chain. Gaps in record IDs are reported since they mean deleted records.
+func runVerifyAudit(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: self-healing-operator verify-audit <public-key.pem> < history.json")
+		return 2
+	}
+	pub, err := readPublicKey(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	var recs []ActionRecord
+	if err := json.NewDecoder(os.Stdin).Decode(&recs); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid history export: %v\n", err)
+		return 2
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].ID < recs[j].ID })
+
+	problems := 0
+	report := func(rec ActionRecord, format string, a ...interface{}) {
+		problems++
+		fmt.Printf("record #%d: %s\n", rec.ID, fmt.Sprintf(format, a...))
+	}
+	prevDigest := ""
+	for i, rec := range recs {
+		payload, err := auditPayload(rec)
+		if err != nil {
+			report(rec, "cannot encode: %v", err)
+			continue
+		}
+		if i > 0 {
+			if rec.ID != recs[i-1].ID+1 {
+				report(rec, "records #%d-#%d are missing", recs[i-1].ID+1, rec.ID-1)
+			} else if rec.PrevDigest != prevDigest {
+				report(rec, "chain broken — previous record was modified")
+			}
+		}
+		prevDigest = auditDigest(payload)
+
+		if rec.Signature == nil {
+			report(rec, "unsigned")
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(rec.Signature.Value)
+		if err != nil || !verifySignature(pub, payload, sig) {
+			report(rec, "signature does not verify")
+		}
+	}
+
+	if problems > 0 {
+		fmt.Printf("FAILED: %d problem(s) in %d record(s)\n", problems, len(recs))
+		return 1
+	}
+	fmt.Printf("OK: %d record(s) verified\n", len(recs))
+	return 0
+}
+
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("public key %s is not PEM encoded", path)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %v", err)
+	}
+	if _, ok := pub.(ed25519.PublicKey); ok {
+		if err := cryptoAllowed("public key "+path, "ed25519"); err != nil {
+			return nil, err
+		}
+	}
+	return pub, nil
+}
+
+func verifySignature(pub crypto.PublicKey, payload, sig []byte) bool {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(payload)
+		return ecdsa.VerifyASN1(k, digest[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, payload, sig)
+	}
+	return false
+}
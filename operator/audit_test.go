@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// blockingSigner holds every signature until release is closed
+type blockingSigner struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingSigner) KeyID() string     { return "test" }
+func (s *blockingSigner) Algorithm() string { return "ed25519" }
+
+func (s *blockingSigner) Sign(ctx context.Context, payload []byte) ([]byte, error) {
+	s.started <- struct{}{}
+	<-s.release
+	return []byte("sig"), nil
+}
+
+func TestRecordHistorySignsWithoutHistoryLock(t *testing.T) {
+	signer := &blockingSigner{started: make(chan struct{}, 2), release: make(chan struct{})}
+	savedSigner, savedHooks := auditSignerImpl, remediationHooks
+	auditSignerImpl, remediationHooks = signer, nil
+	historyMu.Lock()
+	savedHistory := history
+	history = nil
+	historyMu.Unlock()
+	defer func() {
+		auditSignerImpl, remediationHooks = savedSigner, savedHooks
+		historyMu.Lock()
+		history = savedHistory
+		historyMu.Unlock()
+	}()
+
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			recordHistory(ActionRecord{Action: "restart", StartedAt: time.Now()}, nil)
+			done <- struct{}{}
+		}()
+	}
+	<-signer.started
+	<-signer.started
+
+	// both records are being signed; reading the history mustn't wait for them
+	listed := make(chan int)
+	go func() { listed <- len(listHistory(0)) }()
+	select {
+	case n := <-listed:
+		if n != 0 {
+			t.Errorf("listHistory returned %d records before they were signed", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("listHistory blocked while a record was being signed")
+	}
+
+	close(signer.release)
+	<-done
+	<-done
+	recs := listHistory(0) // newest first
+	if len(recs) != 2 {
+		t.Fatalf("got %d records, want 2", len(recs))
+	}
+	newer, older := recs[0], recs[1]
+	if newer.ID != older.ID+1 {
+		t.Errorf("records out of order: #%d before #%d", newer.ID, older.ID)
+	}
+	payload, _ := auditPayload(older)
+	if newer.PrevDigest != auditDigest(payload) {
+		t.Errorf("chain broken: #%d doesn't link to #%d", newer.ID, older.ID)
+	}
+	if newer.Signature == nil || older.Signature == nil {
+		t.Errorf("records left unsigned")
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	authnv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// API authorization. Approval links only prove that someone saw the Slack
+// message; with API_AUTHZ=kubernetes, the endpoints that change what the
+// operator does also need a Kubernetes bearer token (`kubectl create token`,
+// or a ServiceAccount token for tooling). The token is checked with a
+// TokenReview, and the user needs RBAC permission on
+// remediationpolicies.selfhealing.io in the affected namespace (the verbs
+// below are checked whether or not the RemediationPolicy CRD is installed):
+//
+//	approve  GET/POST /api/v1/recommendations/approve, /api/v1/quota-bumps/approve,
+//	         POST /api/v1/observation/graduate
+//	freeze   POST/DELETE /api/v1/suppressions
+//	replay   POST /api/v1/replay (cluster-wide: it reads every namespace's alerts)
+//	tune     PATCH /api/v1/tuning, DELETE /api/v1/effectiveness (cluster-wide)
+//
+// manifests/operator/api-access-rbac.yaml has a ClusterRole to bind per team
+// namespace. API_USER_QUOTA caps how many of these calls one user can
+// make per minute (0 = no cap); past it they get 429.
+
+const (
+	verbApprove = "approve"
+	verbFreeze  = "freeze"
+	verbReplay  = "replay"
+	verbTune    = "tune"
+
+	policyGroup    = "selfhealing.io"
+	policyResource = "remediationpolicies"
+)
+
+var (
+	apiAuthz     = envString("API_AUTHZ", "")
+	apiUserQuota = envInt("API_USER_QUOTA", 0)
+
+	authzMu      sync.Mutex
+	tokenCache   = map[[32]byte]cachedReview{}
+	userCalls    = map[string]int{} // calls in the current minute
+	userWindow   time.Time
+	authzResults = map[string]int{} // verb|result -> count
+)
+
+type cachedReview struct {
+	user    authnv1.UserInfo
+	expires time.Time
+}
+
+func init() {
+	registerMetrics(writeAuthzMetrics)
+}
+
+// authorizeRequest checks the caller may perform verb in namespace ("" for
+// cluster-wide) and writes the error response if not. It returns the caller's
+// username, "" when API_AUTHZ is off.
+func authorizeRequest(w http.ResponseWriter, r *http.Request, verb, namespace string) (string, bool) {
+	if apiAuthz != "kubernetes" {
+		return "", true
+	}
+	user, status, err := checkAccess(r.Context(), r.Header.Get("Authorization"), verb, namespace)
+	result := "allowed"
+	switch status {
+	case http.StatusUnauthorized:
+		result = "unauthenticated"
+		w.Header().Set("WWW-Authenticate", `Bearer realm="self-healing-operator"`)
+	case http.StatusForbidden:
+		result = "denied"
+	case http.StatusTooManyRequests:
+		result = "throttled"
+	case http.StatusInternalServerError:
+		result = "error"
+	}
+	authzMu.Lock()
+	authzResults[verb+"|"+result]++
+	authzMu.Unlock()
+	if err != nil {
+		log.Printf("API %s %s refused (%s, namespace %q): %v", r.Method, r.URL.Path, verb, namespace, err)
+		http.Error(w, err.Error(), status)
+		return "", false
+	}
+	return user, true
+}
+
+func checkAccess(ctx context.Context, header, verb, namespace string) (string, int, error) {
+	token, ok := strings.CutPrefix(strings.TrimSpace(header), "Bearer ")
+	if !ok || token == "" {
+		return "", http.StatusUnauthorized, fmt.Errorf("a Kubernetes bearer token is required")
+	}
+	user, err := reviewToken(ctx, token)
+	if err != nil {
+		return "", http.StatusUnauthorized, err
+	}
+	if !withinUserQuota(user.Username) {
+		return user.Username, http.StatusTooManyRequests, fmt.Errorf("%s exceeded API_USER_QUOTA (%d calls per minute)", user.Username, apiUserQuota)
+	}
+	status, err := reviewAccess(ctx, user, verb, namespace)
+	return user.Username, status, err
+}
+
+// reviewAccess checks the user's RBAC permission for verb with a SubjectAccessReview
+func reviewAccess(ctx context.Context, user authnv1.UserInfo, verb, namespace string) (int, error) {
+	extra := map[string]authzv1.ExtraValue{}
+	for k, v := range user.Extra {
+		extra[k] = authzv1.ExtraValue(v)
+	}
+	sar, err := clients.Kube.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authzv1.SubjectAccessReview{
+		Spec: authzv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authzv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      verb,
+				Group:     policyGroup,
+				Resource:  policyResource,
+			},
+			User:   user.Username,
+			Groups: user.Groups,
+			UID:    user.UID,
+			Extra:  extra,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("access review failed: %v", err)
+	}
+	if !sar.Status.Allowed {
+		scope := "namespace " + namespace
+		if namespace == "" {
+			scope = "all namespaces"
+		}
+		return http.StatusForbidden, fmt.Errorf("%s may not %s %s.%s in %s", user.Username, verb, policyResource, policyGroup, scope)
+	}
+	return http.StatusOK, nil
+}
+
+// reviewToken authenticates a bearer token, caching the answer for a minute
+func reviewToken(ctx context.Context, token string) (authnv1.UserInfo, error) {
+	key := sha256.Sum256([]byte(token))
+	authzMu.Lock()
+	cached, ok := tokenCache[key]
+	authzMu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.user, nil
+	}
+
+	review, err := clients.Kube.AuthenticationV1().TokenReviews().Create(ctx, &authnv1.TokenReview{
+		Spec: authnv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return authnv1.UserInfo{}, fmt.Errorf("token review failed: %v", err)
+	}
+	if !review.Status.Authenticated {
+		return authnv1.UserInfo{}, fmt.Errorf("invalid token")
+	}
+
+	authzMu.Lock()
+	for k, c := range tokenCache {
+		if time.Now().After(c.expires) {
+			delete(tokenCache, k)
+		}
+	}
+	tokenCache[key] = cachedReview{user: review.Status.User, expires: time.Now().Add(time.Minute)}
+	authzMu.Unlock()
+	return review.Status.User, nil
+}
+
+// withinUserQuota counts a call against the user's per-minute quota
+func withinUserQuota(user string) bool {
+	if apiUserQuota <= 0 {
+		return true
+	}
+	authzMu.Lock()
+	defer authzMu.Unlock()
+	if now := time.Now().Truncate(time.Minute); !now.Equal(userWindow) {
+		userWindow = now
+		userCalls = map[string]int{}
+	}
+	userCalls[user]++
+	return userCalls[user] <= apiUserQuota
+}
+
+func writeAuthzMetrics(w io.Writer) {
+	authzMu.Lock()
+	defer authzMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_api_authz_total API calls checked with API_AUTHZ, by verb and result.")
+	fmt.Fprintln(w, "# TYPE selfhealing_api_authz_total counter")
+	for _, key := range sortedKeys(authzResults) {
+		verb, result, _ := strings.Cut(key, "|")
+		fmt.Fprintf(w, "selfhealing_api_authz_total{verb=%q,result=%q} %d\n", verb, result, authzResults[key])
+	}
+}
+
+// byUser is " by <user>" for log lines, or "" without API_AUTHZ
+func byUser(user string) string {
+	if user == "" {
+		return ""
+	}
+	return " by " + user
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEndpointsNeedToken(t *testing.T) {
+	saved := apiAuthz
+	apiAuthz = "kubernetes"
+	defer func() { apiAuthz = saved }()
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		url     string
+	}{
+		{"replay", handleReplay, http.MethodPost, "/api/v1/replay"},
+		{"graduate", handleGraduate, http.MethodPost, "/api/v1/observation/graduate?workload=shop/cart"},
+		{"tune", handleTuning, http.MethodPatch, "/api/v1/tuning"},
+		{"re-enable policy", handleEffectiveness, http.MethodDelete, "/api/v1/effectiveness?policy=Down:restart"},
+		{"unfreeze", handleSuppressions, http.MethodDelete, "/api/v1/suppressions?id=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			tt.handler(rr, httptest.NewRequest(tt.method, tt.url, nil))
+			if rr.Code != http.StatusUnauthorized {
+				t.Errorf("status %d without a token, want 401", rr.Code)
+			}
+		})
+	}
+}
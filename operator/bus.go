@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Message-bus ingestion. Event-driven platforms (Argo Events sensors,
+// pipelines, other controllers) can publish to NATS (bus_nats.go) or Kafka
+// (bus_kafka.go) instead of calling /webhook. A message is one of
+//   - an Alertmanager webhook payload ({"alerts": [...]})
+//   - a single alert ({"labels": {...}, "status": "firing", ...})
+//   - a remediation request, which becomes a firing alert with a
+//     recovery_action label:
+//
+//	{"action": "restart", "alertname": "PaymentsLatency", "namespace": "shop",
+//	 "app": "cart", "pod": "cart-7d4f-x2k9z", "labels": {"severity": "critical"}}
+//
+// Alerts from the bus carry source=nats or source=kafka and go through the
+// same pipeline as webhook alerts, including sharding. Delivery is
+// at-least-once: a message is acknowledged only after its alerts have been
+// processed, and a redelivered one is dropped by the IDEMPOTENCY_TTL check
+// because its alerts get the same startsAt (the time the message was
+// published, unless it has its own). A message that can't be parsed is
+// acknowledged and counted as rejected, so it can't block the consumer.
+
+// remediationRequest is a bus message asking for an action directly
+type remediationRequest struct {
+	Action      string            `json:"action"`
+	AlertName   string            `json:"alertname"`
+	Namespace   string            `json:"namespace"`
+	App         string            `json:"app"`
+	Pod         string            `json:"pod"`
+	Labels      map[string]string `json:"labels"`
+	RequestedAt time.Time         `json:"requestedAt"`
+}
+
+// decodeBusMessage turns a bus message into alerts and the group key to dedupe
+// them with; published is when the bus received it (zero if unknown)
+func decodeBusMessage(data []byte, published time.Time) ([]Alert, string, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, "", err
+	}
+	if published.IsZero() {
+		published = time.Now()
+	}
+	switch {
+	case probe["alerts"] != nil:
+		var msg WebhookMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return nil, "", err
+		}
+		return msg.Alerts, msg.GroupKey, nil
+
+	case probe["labels"] != nil && probe["action"] == nil:
+		var alert Alert
+		if err := json.Unmarshal(data, &alert); err != nil {
+			return nil, "", err
+		}
+		if alert.Status == "" {
+			alert.Status = "firing"
+		}
+		if alert.StartsAt.IsZero() {
+			alert.StartsAt = published
+		}
+		return []Alert{alert}, "", nil
+
+	case probe["action"] != nil:
+		var req remediationRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			return nil, "", err
+		}
+		if req.Action == "" {
+			return nil, "", fmt.Errorf("remediation request without an action")
+		}
+		labels := map[string]string{}
+		for k, v := range req.Labels {
+			labels[k] = v
+		}
+		labels["recovery_action"] = req.Action
+		labels["alertname"] = req.AlertName
+		if labels["alertname"] == "" {
+			labels["alertname"] = "RemediationRequest"
+		}
+		for k, v := range map[string]string{"namespace": req.Namespace, "app": req.App, "pod": req.Pod} {
+			if v != "" {
+				labels[k] = v
+			}
+		}
+		if req.RequestedAt.IsZero() {
+			req.RequestedAt = published
+		}
+		return []Alert{{Labels: labels, Status: "firing", StartsAt: req.RequestedAt}}, "", nil
+	}
+	return nil, "", fmt.Errorf("neither alerts, an alert nor a remediation request")
+}
+
+// handleBusMessage processes one message; the caller acknowledges it afterwards
+func handleBusMessage(ctx context.Context, source string, data []byte, published time.Time) {
+	alerts, groupKey, err := decodeBusMessage(data, published)
+	if err != nil {
+		adapterMu.Lock()
+		adapterRejected[source]++
+		adapterMu.Unlock()
+		log.Printf("Dropping unparseable %s message: %v", source, err)
+		return
+	}
+	for i := range alerts {
+		if alerts[i].Labels == nil {
+			alerts[i].Labels = map[string]string{}
+		}
+		alerts[i].Labels["source"] = source
+	}
+	adapterMu.Lock()
+	adapterReceived[source] += len(alerts)
+	adapterMu.Unlock()
+
+	if groupKey == "" {
+		groupKey = source
+	}
+	n := len(alerts)
+	if alerts = deliveries.dedupe(groupKey, alerts); len(alerts) < n {
+		log.Printf("Dropped %d redelivered alert(s) from %s", n-len(alerts), source)
+	}
+	if len(alerts) == 0 {
+		return
+	}
+	log.Printf("Received %d alert(s) from %s", len(alerts), source)
+	processAlerts(ctx, shards.routeAlerts(ctx, groupKey, alerts))
+}
+
+// consumeWithRetry runs a consumer until the operator stops, reconnecting with backoff
+func consumeWithRetry(name string, consume func(ctx context.Context) error) {
+	go func() {
+		backoff := time.Second
+		for operatorCtx.Err() == nil {
+			started := time.Now()
+			err := consume(operatorCtx)
+			if operatorCtx.Err() != nil {
+				return
+			}
+			if time.Since(started) > time.Minute {
+				backoff = time.Second
+			}
+			log.Printf("%s consumer stopped, reconnecting in %s: %v", name, backoff, err)
+			select {
+			case <-operatorCtx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+		}
+	}()
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Remediation event stream. With EVENTS_NATS_SUBJECT (on NATS_URL) or
+// EVENTS_KAFKA_TOPIC (through KAFKA_BRIDGE_URL) set, every decision on a firing
+// alert and every finished remediation is published as a JSON event, for data
+// teams and incident platforms that want the stream in real time:
+//
+//	{"schema": "selfhealing.io/remediation-event/v1", "type": "decision",
+//	 "id": "9f2c…", "time": "…", "alertname": "PodCrashLooping",
+//	 "namespace": "shop", "app": "cart", "decision": "restart",
+//	 "action": "restart", "traceId": "…", "explain": [...]}
+//
+// A decision's "decision" is the action taken or skip:<check> (the check that
+// skipped it, as in selfhealing_alert_decisions_total). An "outcome" event
+// repeats the history record: action, outcome, error, duration, steps and
+// historyId. Both carry the remediation's traceId when there is one. Fields
+// are only ever added within a schema version; a breaking change gets /v2.
+//
+// Publishing never holds up remediation: events wait in a bounded queue per
+// sink and are dropped (and counted) if the sink can't keep up. NATS events
+// are published on the subject as is, so a JetStream stream that captures it
+// makes them durable; Kafka events are keyed by namespace/app, so one
+// workload's events stay in order on one partition.
+
+const eventSchema = "selfhealing.io/remediation-event/v1"
+
+var (
+	eventsNATSSubject = envString("EVENTS_NATS_SUBJECT", "")
+	eventsKafkaTopic  = envString("EVENTS_KAFKA_TOPIC", "")
+	eventsQueueSize   = envInt("EVENTS_QUEUE_SIZE", 1000)
+
+	eventSinks []*eventSink
+
+	eventMu        sync.Mutex
+	eventPublished = map[string]int{} // sink|result
+)
+
+type remediationEvent struct {
+	Schema      string            `json:"schema"`
+	Type        string            `json:"type"` // decision, outcome
+	ID          string            `json:"id"`
+	Time        time.Time         `json:"time"`
+	AlertName   string            `json:"alertname"`
+	Fingerprint string            `json:"fingerprint,omitempty"`
+	Namespace   string            `json:"namespace"`
+	App         string            `json:"app,omitempty"`
+	Pod         string            `json:"pod,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Decision    string            `json:"decision,omitempty"` // decision events
+	Action      string            `json:"action,omitempty"`
+	Name        string            `json:"name,omitempty"`    // runbook or workflow name
+	Outcome     string            `json:"outcome,omitempty"` // outcome events: succeeded, failed
+	Error       string            `json:"error,omitempty"`
+	Duration    string            `json:"duration,omitempty"`
+	HistoryID   int               `json:"historyId,omitempty"`
+	Steps       []StepRecord      `json:"steps,omitempty"`
+	Explain     []TraceStep       `json:"explain,omitempty"`
+	TraceID     string            `json:"traceId,omitempty"`
+}
+
+// eventSink publishes queued events to one bus
+type eventSink struct {
+	name  string
+	queue chan remediationEvent
+	send  func(ctx context.Context, events []remediationEvent) error
+}
+
+func init() {
+	registerMetrics(writeEventMetrics)
+}
+
+func setupEventStream() {
+	if eventsNATSSubject != "" {
+		if natsURL == "" {
+			log.Fatal("EVENTS_NATS_SUBJECT requires NATS_URL")
+		}
+		p := &natsPublisher{subject: eventsNATSSubject}
+		eventSinks = append(eventSinks, &eventSink{name: "nats", send: p.send})
+		log.Printf("Remediation events enabled — publishing to NATS subject %s", eventsNATSSubject)
+	}
+	if eventsKafkaTopic != "" {
+		if kafkaBridgeURL == "" {
+			log.Fatal("EVENTS_KAFKA_TOPIC requires KAFKA_BRIDGE_URL")
+		}
+		eventSinks = append(eventSinks, &eventSink{name: "kafka", send: produceKafka})
+		log.Printf("Remediation events enabled — producing to Kafka topic %s via %s", eventsKafkaTopic, kafkaBridgeURL)
+	}
+	if len(eventSinks) == 0 {
+		return
+	}
+	for _, s := range eventSinks {
+		s.queue = make(chan remediationEvent, eventsQueueSize)
+		go s.run()
+	}
+	onRemediation(emitOutcome)
+}
+
+// emitDecision publishes how a firing alert was decided; action is nil if it was skipped
+func emitDecision(alert Alert, action *RecoveryAction, decision string, trace decisionTrace) {
+	if len(eventSinks) == 0 || alert.Status != "firing" {
+		return
+	}
+	ev := remediationEvent{
+		Type:        "decision",
+		AlertName:   alert.Labels["alertname"],
+		Fingerprint: alertFingerprint(alert),
+		Namespace:   alert.Labels["namespace"],
+		App:         alert.Labels["app"],
+		Pod:         alert.Labels["pod"],
+		Labels:      alert.Labels,
+		Tags:        remediationTags(alert.Labels),
+		Decision:    decision,
+		Explain:     trace,
+	}
+	if action != nil {
+		ev.Namespace, ev.App, ev.Pod = action.Namespace, action.App, action.Pod
+		ev.Action, ev.TraceID = action.Action, action.TraceID
+	}
+	emitEvent(ev)
+}
+
+// emitOutcome publishes a finished remediation
+func emitOutcome(rec ActionRecord) {
+	emitEvent(remediationEvent{
+		Type:      "outcome",
+		AlertName: rec.AlertName,
+		Namespace: rec.Namespace,
+		App:       rec.App,
+		Pod:       rec.Pod,
+		Labels:    rec.alertLabels,
+		Tags:      rec.Tags,
+		Action:    rec.Action,
+		Name:      rec.Name,
+		Outcome:   rec.Outcome,
+		Error:     rec.Error,
+		Duration:  rec.Duration,
+		HistoryID: rec.ID,
+		Steps:     rec.Steps,
+		Explain:   rec.Explain,
+		TraceID:   rec.TraceID,
+	})
+}
+
+func emitEvent(ev remediationEvent) {
+	ev.Schema, ev.ID, ev.Time = eventSchema, randomHex(16), time.Now().UTC()
+	for _, s := range eventSinks {
+		select {
+		case s.queue <- ev:
+		default:
+			countEvents(s.name, "dropped", 1)
+		}
+	}
+}
+
+// run publishes queued events in batches, retrying a failed batch a few times
+func (s *eventSink) run() {
+	const maxBatch = 100
+	for {
+		var batch []remediationEvent
+		select {
+		case <-operatorCtx.Done():
+			return
+		case ev := <-s.queue:
+			batch = append(batch, ev)
+		}
+	drain:
+		for len(batch) < maxBatch {
+			select {
+			case ev := <-s.queue:
+				batch = append(batch, ev)
+			default:
+				break drain
+			}
+		}
+
+		var err error
+		for attempt, backoff := 0, time.Second; attempt < 3; attempt, backoff = attempt+1, backoff*2 {
+			ctx, cancel := context.WithTimeout(operatorCtx, 15*time.Second)
+			err = s.send(ctx, batch)
+			cancel()
+			if err == nil || operatorCtx.Err() != nil {
+				break
+			}
+			pipeline.retrying("event", len(batch))
+			select {
+			case <-operatorCtx.Done():
+			case <-time.After(backoff):
+			}
+			pipeline.retrying("event", -len(batch))
+		}
+		if err != nil {
+			log.Printf("Failed to publish %d remediation event(s) to %s: %v", len(batch), s.name, err)
+			countEvents(s.name, "failed", len(batch))
+			continue
+		}
+		countEvents(s.name, "published", len(batch))
+	}
+}
+
+// natsPublisher keeps one connection open, redialing after a failure
+type natsPublisher struct {
+	subject string
+	conn    *natsConn // only used by the sink's goroutine
+}
+
+func (p *natsPublisher) send(ctx context.Context, events []remediationEvent) error {
+	if p.conn == nil {
+		c, err := dialNATS(ctx, natsURL)
+		if err != nil {
+			return err
+		}
+		// the server PINGs idle connections; readMsg answers them until the connection fails
+		go func() {
+			for {
+				if _, err := c.readMsg(); err != nil {
+					c.conn.Close()
+					return
+				}
+			}
+		}()
+		p.conn = c
+	}
+	for _, ev := range events {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		if err := p.conn.publish(p.subject, "", data); err != nil {
+			p.conn.conn.Close()
+			p.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// produceKafka sends events to the bridge's topic endpoint, keyed by workload
+func produceKafka(ctx context.Context, events []remediationEvent) error {
+	type record struct {
+		Key   string           `json:"key"`
+		Value remediationEvent `json:"value"`
+	}
+	records := make([]record, 0, len(events))
+	for _, ev := range events {
+		records = append(records, record{Key: ev.Namespace + "/" + ev.App, Value: ev})
+	}
+	return postJSON(ctx, "kafka", kafkaBridgeURL+"/topics/"+url.PathEscape(eventsKafkaTopic), map[string]interface{}{"records": records},
+		map[string]string{"Content-Type": kafkaJSONV2, "Accept": kafkaV2})
+}
+
+func countEvents(sink, result string, n int) {
+	eventMu.Lock()
+	eventPublished[sink+"|"+result] += n
+	eventMu.Unlock()
+}
+
+func writeEventMetrics(w io.Writer) {
+	eventMu.Lock()
+	defer eventMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_events_total Remediation events for the event stream, by sink and result (published, failed, dropped).")
+	fmt.Fprintln(w, "# TYPE selfhealing_events_total counter")
+	for _, s := range eventSinks {
+		for _, result := range []string{"published", "failed", "dropped"} {
+			fmt.Fprintf(w, "selfhealing_events_total{sink=%q,result=%q} %d\n", s.name, result, eventPublished[s.name+"|"+result])
+		}
+	}
+}
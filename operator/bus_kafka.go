@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Kafka consumer, through an HTTP bridge rather than the Kafka protocol: the
+// Strimzi Kafka Bridge or the Confluent REST Proxy (both speak the v2
+// consumer API). With KAFKA_BRIDGE_URL and KAFKA_TOPICS set, every replica
+// joins the consumer group KAFKA_GROUP, so partitions are spread over the
+// replicas and rebalanced when they come and go. Auto-commit is off: offsets
+// are committed after a batch of records has been processed, so a replica
+// that dies mid-batch leaves it to be redelivered (at-least-once).
+//
+// Record values must be JSON (the bridge's json format).
+
+var (
+	kafkaBridgeURL = strings.TrimRight(envString("KAFKA_BRIDGE_URL", ""), "/")
+	kafkaTopics    = envList("KAFKA_TOPICS")
+	kafkaGroup     = envString("KAFKA_GROUP", "self-healing-operator")
+)
+
+const (
+	kafkaV2     = "application/vnd.kafka.v2+json"
+	kafkaJSONV2 = "application/vnd.kafka.json.v2+json" // records with JSON values
+)
+
+type kafkaRecord struct {
+	Topic     string          `json:"topic"`
+	Partition int             `json:"partition"`
+	Offset    int64           `json:"offset"`
+	Value     json.RawMessage `json:"value"`
+	Timestamp int64           `json:"timestamp"` // ms; Strimzi only
+}
+
+func startKafka() {
+	if kafkaBridgeURL == "" || len(kafkaTopics) == 0 {
+		return
+	}
+	log.Printf("Kafka ingestion enabled — topics %s, consumer group %s, via %s", strings.Join(kafkaTopics, ","), kafkaGroup, kafkaBridgeURL)
+	consumeWithRetry("Kafka", consumeKafka)
+}
+
+// kafkaCall sends a v2 consumer API request and decodes the JSON answer into out
+func kafkaCall(ctx context.Context, method, u string, body, out interface{}, accept string) error {
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, rd)
+	if err != nil {
+		return err
+	}
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", kafkaV2)
+	}
+	req.Header.Set("Accept", accept)
+	resp, err := egressClient("kafka").Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", method, u, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// consumeKafka creates a consumer instance, subscribes and polls until an error
+func consumeKafka(ctx context.Context) error {
+	name := envString("POD_NAME", "self-healing-operator") + "-" + randomHex(4)
+	err := kafkaCall(ctx, http.MethodPost, kafkaBridgeURL+"/consumers/"+url.PathEscape(kafkaGroup), map[string]interface{}{
+		"name":               name,
+		"format":             "json",
+		"auto.offset.reset":  "earliest",
+		"enable.auto.commit": false,
+	}, nil, kafkaV2)
+	if err != nil {
+		return fmt.Errorf("failed to create consumer: %v", err)
+	}
+	// the bridge may report an address it isn't reachable at from here
+	base := kafkaBridgeURL + "/consumers/" + url.PathEscape(kafkaGroup) + "/instances/" + url.PathEscape(name)
+	defer func() {
+		cleanup, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := kafkaCall(cleanup, http.MethodDelete, base, nil, nil, kafkaV2); err != nil {
+			log.Printf("Failed to delete Kafka consumer %s: %v", name, err)
+		}
+	}()
+	if err := kafkaCall(ctx, http.MethodPost, base+"/subscription", map[string]interface{}{"topics": kafkaTopics}, nil, kafkaV2); err != nil {
+		return fmt.Errorf("failed to subscribe: %v", err)
+	}
+
+	for {
+		var records []kafkaRecord
+		if err := kafkaCall(ctx, http.MethodGet, base+"/records?timeout=5000", nil, &records, kafkaJSONV2); err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		for _, rec := range records {
+			var published time.Time
+			if rec.Timestamp > 0 {
+				published = time.UnixMilli(rec.Timestamp)
+			}
+			handleBusMessage(ctx, "kafka", rec.Value, published)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err() // not committed: redelivered to another replica
+		}
+		// an empty body commits everything this consumer has fetched
+		if err := kafkaCall(ctx, http.MethodPost, base+"/offsets", nil, nil, kafkaV2); err != nil {
+			return fmt.Errorf("failed to commit offsets: %v", err)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Result callbacks: an alert can carry a callback URL annotation (default
+// "callback_url"); when the remediation it triggered finishes, the operator
+// POSTs the result there so incident tooling can attach it to the incident.
+//
+// The URL comes from whoever writes alert rules, so only hosts listed in
+// CALLBACK_ALLOWED_HOSTS ("hooks.example.com", "*.example.com") are called;
+// callbacks are off while it's empty. With CALLBACK_SECRET set the body is
+// signed: X-Selfhealing-Signature: sha256=<hex HMAC-SHA256 of the body>.
+
+// CallbackPayload is the body POSTed to a callback URL
+type CallbackPayload struct {
+	ID          int               `json:"id"`
+	AlertName   string            `json:"alertname"`
+	AlertLabels map[string]string `json:"alertLabels,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Action      string            `json:"action"`
+	Name        string            `json:"name,omitempty"` // runbook or workflow name
+	Target      CallbackTarget    `json:"target"`
+	Outcome     string            `json:"outcome"` // succeeded, failed
+	Error       string            `json:"error,omitempty"`
+	StartedAt   time.Time         `json:"startedAt"`
+	FinishedAt  time.Time         `json:"finishedAt"`
+	Duration    string            `json:"duration"`
+}
+
+// CallbackTarget is the workload the action ran against
+type CallbackTarget struct {
+	Namespace string `json:"namespace"`
+	App       string `json:"app,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+}
+
+var (
+	callbackAnnotation   = envString("CALLBACK_ANNOTATION", "callback_url")
+	callbackAllowedHosts = envList("CALLBACK_ALLOWED_HOSTS")
+	callbackSecret       = credential("CALLBACK_SECRET")
+	callbackAttempts     = envInt("CALLBACK_ATTEMPTS", 3)
+
+	callbackMu    sync.Mutex
+	callbackStats = map[string]int{} // result -> count
+)
+
+func init() {
+	registerMetrics(writeCallbackMetrics)
+}
+
+func setupCallbacks() {
+	if len(callbackAllowedHosts) == 0 {
+		return
+	}
+	onRemediation(sendCallback)
+	log.Printf("Result callbacks enabled — '%s' annotation, hosts %s", callbackAnnotation, strings.Join(callbackAllowedHosts, ","))
+}
+
+// callbackURLFor returns the alert's callback URL if it's allowed
+func callbackURLFor(alert Alert) string {
+	raw := alert.Annotations[callbackAnnotation]
+	if raw == "" || len(callbackAllowedHosts) == 0 {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		log.Printf("Ignoring callback URL on alert %s — not an http(s) URL", alert.Labels["alertname"])
+		return ""
+	}
+	if !callbackHostAllowed(u.Hostname()) {
+		log.Printf("Ignoring callback URL on alert %s — host %s not in CALLBACK_ALLOWED_HOSTS", alert.Labels["alertname"], u.Hostname())
+		return ""
+	}
+	return raw
+}
+
+func callbackHostAllowed(host string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range callbackAllowedHosts {
+		allowed = strings.ToLower(allowed)
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// sendCallback is the remediation hook; records without a callback URL are ignored
+func sendCallback(rec ActionRecord) {
+	if rec.callbackURL == "" {
+		return
+	}
+	started := rec.StartedAt
+	duration, _ := time.ParseDuration(rec.Duration)
+	body, err := json.Marshal(CallbackPayload{
+		ID:          rec.ID,
+		AlertName:   rec.AlertName,
+		AlertLabels: rec.alertLabels,
+		Tags:        rec.Tags,
+		Action:      rec.Action,
+		Name:        rec.Name,
+		Target:      CallbackTarget{Namespace: rec.Namespace, App: rec.App, Pod: rec.Pod},
+		Outcome:     rec.Outcome,
+		Error:       rec.Error,
+		StartedAt:   started,
+		FinishedAt:  started.Add(duration),
+		Duration:    rec.Duration,
+	})
+	if err != nil {
+		log.Printf("Failed to encode callback: %v", err)
+		return
+	}
+
+	result := "failed"
+	for attempt := 1; attempt <= callbackAttempts; attempt++ {
+		retry, err := postCallback(rec.callbackURL, body)
+		if err == nil {
+			result = "sent"
+			break
+		}
+		log.Printf("Callback for remediation #%d failed (attempt %d/%d): %v", rec.ID, attempt, callbackAttempts, err)
+		if !retry {
+			break
+		}
+		pipeline.retrying("callback", 1)
+		time.Sleep(time.Duration(attempt) * 2 * time.Second)
+		pipeline.retrying("callback", -1)
+	}
+	callbackMu.Lock()
+	callbackStats[result]++
+	callbackMu.Unlock()
+}
+
+// postCallback sends one attempt and reports whether a failure is worth retrying
+func postCallback(target string, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret := callbackSecret.value(); secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-Selfhealing-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := egressClient("callback").Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return false, nil
+}
+
+func writeCallbackMetrics(w io.Writer) {
+	callbackMu.Lock()
+	defer callbackMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_callbacks_total Remediation result callbacks, by result.")
+	fmt.Fprintln(w, "# TYPE selfhealing_callbacks_total counter")
+	for _, result := range sortedKeys(callbackStats) {
+		fmt.Fprintf(w, "selfhealing_callbacks_total{result=%q} %d\n", result, callbackStats[result])
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Capacity action for alerts about pods that can't be scheduled because the
+// cluster is full (`recovery_action: capacity`). It looks for the app's (or
+// the alert pod's) Pending pods the scheduler marked Unschedulable, then asks
+// the node autoscaler for room:
+//   - karpenter: raise the cpu and memory limits of the NodePool named by the
+//     alert's `nodepool` label (else KARPENTER_NODEPOOL) by what those pods
+//     request, capped at KARPENTER_MAX_CPU / KARPENTER_MAX_MEMORY. Karpenter
+//     then provisions for the pods that are already pending
+//   - placeholder: run a Job of pause pods sized like the pending pods, with
+//     their node selector, affinity and tolerations and the low-priority
+//     CAPACITY_PLACEHOLDER_PRIORITY_CLASS. Cluster Autoscaler (or Karpenter)
+//     adds nodes for them, and real pods preempt them once there; the Job ends
+//     after CAPACITY_PLACEHOLDER_TTL
+//
+// CAPACITY_PROVIDER=auto uses karpenter if the API server serves
+// karpenter.sh/v1 NodePools and the alert names one, otherwise a placeholder.
+// The action then waits in the background, up to CAPACITY_WAIT_TIMEOUT, for
+// the pods to be scheduled, and reports the wait to the log and Slack; if the
+// room never arrives it escalates.
+
+const karpenterAPIVersion = "karpenter.sh/v1"
+
+var (
+	capacityProvider                 = envString("CAPACITY_PROVIDER", "auto")
+	karpenterNodePool                = envString("KARPENTER_NODEPOOL", "")
+	karpenterMaxCPU                  = envQuantity("KARPENTER_MAX_CPU", "256")
+	karpenterMaxMemory               = envQuantity("KARPENTER_MAX_MEMORY", "1Ti")
+	capacityPlaceholderImage         = envString("CAPACITY_PLACEHOLDER_IMAGE", "registry.k8s.io/pause:3.9")
+	capacityPlaceholderPriorityClass = envString("CAPACITY_PLACEHOLDER_PRIORITY_CLASS", "selfhealing-placeholder")
+	capacityPlaceholderTTL           = envDuration("CAPACITY_PLACEHOLDER_TTL", 30*time.Minute)
+	capacityPlaceholderMax           = envInt("CAPACITY_PLACEHOLDER_MAX", 5)
+	capacityWaitTimeout              = envDuration("CAPACITY_WAIT_TIMEOUT", 20*time.Minute)
+)
+
+// set once at startup by setupCapacity
+var karpenterServed bool
+
+// capacity requests, exported as metrics
+var (
+	capacityMu       sync.Mutex
+	capacityOutcomes = map[string]int{} // key = "provider|outcome"
+	capacityLastWait = map[string]time.Duration{}
+)
+
+func init() {
+	registerMetrics(writeCapacityMetrics)
+}
+
+func setupCapacity(kube kubernetes.Interface) {
+	karpenterServed = servesResource(kube, karpenterAPIVersion, "nodepools")
+	if karpenterServed {
+		log.Printf("Karpenter NodePools found — capacity actions can raise their limits")
+	}
+}
+
+// unschedulablePods returns the Pending pods of the action's app (or its pod) the scheduler couldn't place
+func unschedulablePods(ctx context.Context, cs kubernetes.Interface, action *RecoveryAction) ([]corev1.Pod, error) {
+	var candidates []corev1.Pod
+	if action.App != "" {
+		list, err := cs.CoreV1().Pods(action.Namespace).List(ctx, metav1.ListOptions{LabelSelector: "app=" + action.App})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods of %s/%s: %v", action.Namespace, action.App, err)
+		}
+		candidates = list.Items
+	} else if action.Pod != "" {
+		pod, err := cs.CoreV1().Pods(action.Namespace).Get(ctx, action.Pod, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pod %s/%s: %v", action.Namespace, action.Pod, err)
+		}
+		candidates = []corev1.Pod{*pod}
+	} else {
+		return nil, fmt.Errorf("alert has neither an app nor a pod label")
+	}
+
+	var out []corev1.Pod
+	for _, p := range candidates {
+		if p.Status.Phase == corev1.PodPending && p.Spec.NodeName == "" && unschedulable(&p) {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+func unschedulable(p *corev1.Pod) bool {
+	for _, c := range p.Status.Conditions {
+		if c.Type == corev1.PodScheduled {
+			return c.Status == corev1.ConditionFalse && c.Reason == corev1.PodReasonUnschedulable
+		}
+	}
+	return false
+}
+
+// startCapacity asks for room for the pending pods and waits for it in the background
+func startCapacity(ctx context.Context, c *Clients, action *RecoveryAction, alert Alert) error {
+	cs, err := c.For(ctx, action.Namespace)
+	if err != nil {
+		return err
+	}
+	pending, err := unschedulablePods(ctx, cs, action)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		log.Printf("No unschedulable pods for %s/%s — nothing to do", action.Namespace, action.App)
+		return nil
+	}
+
+	started := time.Now()
+	provider, detail, err := requestCapacity(ctx, c, action, pending)
+	if err != nil {
+		return err
+	}
+	log.Printf("Requested capacity for %d pending pod(s) of %s/%s via %s: %s", len(pending), action.Namespace, action.App, provider, detail)
+	action.Explain.add("capacity", traceInfo, provider+": "+detail)
+
+	go waitForCapacity(operatorCtx, cs, action, alert, provider, pending, started)
+	return nil
+}
+
+// requestCapacity picks the provider and asks it for room
+func requestCapacity(ctx context.Context, c *Clients, action *RecoveryAction, pending []corev1.Pod) (string, string, error) {
+	nodePool := action.Labels["nodepool"]
+	if nodePool == "" {
+		nodePool = karpenterNodePool
+	}
+	provider := capacityProvider
+	if provider == "auto" {
+		provider = "placeholder"
+		if karpenterServed && nodePool != "" {
+			provider = "karpenter"
+		}
+	}
+
+	switch provider {
+	case "karpenter":
+		if nodePool == "" {
+			return provider, "", fmt.Errorf("no Karpenter NodePool: set the alert's nodepool label or KARPENTER_NODEPOOL")
+		}
+		// NodePools are cluster-scoped, so this uses the operator's own client
+		detail, err := raiseNodePoolLimits(ctx, c.Kube, nodePool, pending)
+		return provider, detail, err
+	case "placeholder", "cluster-autoscaler":
+		cs, err := c.For(ctx, action.Namespace)
+		if err != nil {
+			return provider, "", err
+		}
+		detail, err := createPlaceholder(ctx, cs, action, pending)
+		return "placeholder", detail, err
+	default:
+		return provider, "", fmt.Errorf("unknown CAPACITY_PROVIDER %q", provider)
+	}
+}
+
+// pendingRequests sums the CPU and memory requests of the pods
+func pendingRequests(pods []corev1.Pod) corev1.ResourceList {
+	total := corev1.ResourceList{}
+	for i := range pods {
+		for name, q := range podRequests(&pods[i]) {
+			if name == corev1.ResourcePods {
+				continue
+			}
+			sum := total[name]
+			sum.Add(q)
+			total[name] = sum
+		}
+	}
+	return total
+}
+
+// raiseNodePoolLimits adds the pods' requests to the NodePool's cpu and memory limits
+func raiseNodePoolLimits(ctx context.Context, kube kubernetes.Interface, name string, pending []corev1.Pod) (string, error) {
+	p := "/apis/" + karpenterAPIVersion + "/nodepools/" + name
+	var pool unstructured.Unstructured
+	if err := apiRequest(ctx, kube, "GET", p, "", nil, &pool.Object); err != nil {
+		return "", fmt.Errorf("failed to get NodePool %s: %v", name, err)
+	}
+	limits, _, _ := unstructured.NestedStringMap(pool.Object, "spec", "limits")
+	if len(limits) == 0 {
+		return "", fmt.Errorf("NodePool %s has no limits — Karpenter isn't held back by them", name)
+	}
+
+	want := pendingRequests(pending)
+	ceilings := map[corev1.ResourceName]resource.Quantity{corev1.ResourceCPU: karpenterMaxCPU, corev1.ResourceMemory: karpenterMaxMemory}
+	patch := map[string]string{}
+	var changes []string
+	for _, res := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		current, ok := limits[string(res)]
+		if !ok {
+			continue
+		}
+		limit, err := resource.ParseQuantity(current)
+		if err != nil {
+			return "", fmt.Errorf("NodePool %s has an unreadable %s limit %q: %v", name, res, current, err)
+		}
+		raised := limit.DeepCopy()
+		raised.Add(want[res])
+		if ceiling := ceilings[res]; raised.Cmp(ceiling) > 0 {
+			raised = ceiling.DeepCopy()
+		}
+		if raised.Cmp(limit) <= 0 {
+			continue
+		}
+		patch[string(res)] = raised.String()
+		changes = append(changes, fmt.Sprintf("%s %s -> %s", res, current, raised.String()))
+	}
+	if len(patch) == 0 {
+		return "", fmt.Errorf("NodePool %s limits are already at KARPENTER_MAX_CPU/KARPENTER_MAX_MEMORY", name)
+	}
+
+	body := map[string]interface{}{"spec": map[string]interface{}{"limits": patch}}
+	if err := apiRequest(ctx, kube, "PATCH", p, string(types.MergePatchType), body, nil); err != nil {
+		return "", fmt.Errorf("failed to raise limits of NodePool %s: %v", name, err)
+	}
+	return "NodePool " + name + " limits " + strings.Join(changes, ", "), nil
+}
+
+// createPlaceholder runs pause pods sized like the largest pending pod, one per pending pod
+func createPlaceholder(ctx context.Context, cs kubernetes.Interface, action *RecoveryAction, pending []corev1.Pod) (string, error) {
+	largest, most := &pending[0], resource.Quantity{}
+	for i := range pending {
+		if mem := podRequests(&pending[i])[corev1.ResourceMemory]; mem.Cmp(most) > 0 {
+			largest, most = &pending[i], mem
+		}
+	}
+	requests := podRequests(largest)
+	delete(requests, corev1.ResourcePods)
+
+	count := int32(len(pending))
+	if limit := int32(capacityPlaceholderMax); limit > 0 && count > limit {
+		count = limit
+	}
+	deadline := int64(capacityPlaceholderTTL.Seconds())
+	ttl := int32(60)
+	backoff := int32(0)
+	podSecurity, containerSecurity := restrictedSecurity()
+	meta := metav1.ObjectMeta{GenerateName: "selfhealing-capacity-"}
+	markOwned(&meta, capacityPlaceholderTTL+time.Minute)
+	job := &batchv1.Job{
+		ObjectMeta: meta,
+		Spec: batchv1.JobSpec{
+			Parallelism:             &count,
+			BackoffLimit:            &backoff,
+			ActiveDeadlineSeconds:   &deadline,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{managedByLabel: managedByValue}},
+				Spec: corev1.PodSpec{
+					RestartPolicy:     corev1.RestartPolicyNever,
+					PriorityClassName: capacityPlaceholderPriorityClass,
+					NodeSelector:      largest.Spec.NodeSelector,
+					Affinity:          largest.Spec.Affinity,
+					Tolerations:       largest.Spec.Tolerations,
+					SecurityContext:   podSecurity,
+					Containers: []corev1.Container{{
+						Name:            "placeholder",
+						Image:           capacityPlaceholderImage,
+						Resources:       corev1.ResourceRequirements{Requests: requests},
+						SecurityContext: containerSecurity,
+					}},
+				},
+			},
+		},
+	}
+	created, err := cs.BatchV1().Jobs(action.Namespace).Create(ctx, job, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to create capacity placeholder: %v", err)
+	}
+	return fmt.Sprintf("placeholder Job %s/%s with %d pod(s) of cpu %s, memory %s", created.Namespace, created.Name, count,
+		requests.Cpu().String(), requests.Memory().String()), nil
+}
+
+// waitForCapacity reports when every pending pod has been scheduled (or is gone)
+func waitForCapacity(ctx context.Context, cs kubernetes.Interface, action *RecoveryAction, alert Alert, provider string, pending []corev1.Pod, started time.Time) {
+	rec := newActionRecord(action, provider, started)
+	target := action.Namespace + "/" + action.App
+	nodes := map[string]bool{}
+	waiting := len(pending)
+	err := wait.PollUntilContextTimeout(ctx, 15*time.Second, capacityWaitTimeout, false, func(ctx context.Context) (bool, error) {
+		waiting = 0
+		for _, p := range pending {
+			live, err := cs.CoreV1().Pods(p.Namespace).Get(ctx, p.Name, metav1.GetOptions{})
+			if err != nil || live.UID != p.UID {
+				continue // gone or replaced: no longer waiting for room
+			}
+			if live.Spec.NodeName == "" {
+				waiting++
+				continue
+			}
+			nodes[live.Spec.NodeName] = true
+		}
+		return waiting == 0, nil
+	})
+	took := time.Since(started).Round(time.Second)
+
+	outcome := "arrived"
+	if err != nil {
+		outcome = "timeout"
+		err = fmt.Errorf("%d of %d pod(s) still unschedulable after %s", waiting, len(pending), capacityWaitTimeout)
+		log.Printf("Capacity for %s did not arrive: %v", target, err)
+		escalate(action, alert, "capacity requested via "+provider+" but "+err.Error())
+	} else {
+		var names []string
+		for n := range nodes {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		log.Printf("Capacity arrived for %s after %s: %d pod(s) scheduled (nodes: %s)", target, took, len(pending), strings.Join(names, ", "))
+		msg := renderMessage("capacity-arrived", alertLocale(alert.Labels, alert.Annotations), map[string]interface{}{
+			"Namespace": action.Namespace, "App": action.App, "AlertName": action.AlertName, "Provider": provider,
+			"Took": took, "Pods": len(pending), "Nodes": names,
+		})
+		if err := postSlack(ctx, msg); err != nil {
+			log.Printf("Failed to post capacity report to Slack: %v", err)
+		}
+	}
+	recordHistory(rec, err)
+
+	capacityMu.Lock()
+	capacityOutcomes[provider+"|"+outcome]++
+	capacityLastWait[provider] = took
+	capacityMu.Unlock()
+}
+
+func writeCapacityMetrics(w io.Writer) {
+	capacityMu.Lock()
+	defer capacityMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_capacity_requests_total Capacity requests by provider and whether the room arrived")
+	fmt.Fprintln(w, "# TYPE selfhealing_capacity_requests_total counter")
+	for _, key := range sortedKeys(capacityOutcomes) {
+		provider, outcome, _ := strings.Cut(key, "|")
+		fmt.Fprintf(w, "selfhealing_capacity_requests_total{provider=%q,outcome=%q} %d\n", provider, outcome, capacityOutcomes[key])
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_capacity_wait_seconds How long the last capacity request waited for its pods to be scheduled")
+	fmt.Fprintln(w, "# TYPE selfhealing_capacity_wait_seconds gauge")
+	for _, provider := range sortedKeys(capacityLastWait) {
+		fmt.Fprintf(w, "selfhealing_capacity_wait_seconds{provider=%q} %g\n", provider, capacityLastWait[provider].Seconds())
+	}
+}
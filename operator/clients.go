@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Clients carries the API clients recovery actions run with. Actions take a
+// *Clients (or a kubernetes.Interface) rather than a global client, so
+// restart, redeploy and scale are tested against fake.NewSimpleClientset (see
+// actions_test.go):
+//
+//	c := &Clients{Kube: fake.NewSimpleClientset(pod)}
+//	err := restartPod(ctx, c, action)
+//
+// Some lookups still use package state: the informer cache (podLister,
+// deploymentLister, nil outside main), namespace and workload overrides
+// (through clients) and the env settings.
+type Clients struct {
+	// Kube acts with the operator's own identity
+	Kube kubernetes.Interface
+	// Dynamic acts on custom resources with the operator's own identity
+	Dynamic dynamic.Interface
+	// Scoped mints per-namespace clients; nil unless SCOPED_CLIENTS=true
+	Scoped *scopedClientFactory
+}
+
+// clients is the process-wide set, built in main
+var clients = &Clients{}
+
+// For returns the client recovery actions in the given namespace should
+// use: the scoped one when enabled, otherwise the operator's own
+func (c *Clients) For(ctx context.Context, namespace string) (kubernetes.Interface, error) {
+	if c.Scoped == nil {
+		return c.Kube, nil
+	}
+	sc, err := c.Scoped.forNamespace(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return sc.Typed, nil
+}
@@ -0,0 +1,265 @@
+// Command loadtest is a load generator for a running (test) operator. It
+// posts Alertmanager payloads to the webhook at a fixed rate from
+// -concurrency senders, spread over -workloads workloads, and reports the
+// alerts/second delivered and the webhook latency percentiles:
+//
+//	go run ./cmd/loadtest -url http://localhost:8080/webhook -rate 200 -duration 1m
+//
+// With -metrics-url (the admin /metrics) it also reports how many actions
+// finished meanwhile and how long they waited in the queue (see pipeline.go).
+// Point it at a test operator: use -action notify without SLACK_WEBHOOK_URL,
+// or MODE=recommend.
+//
+// -min-rate and -max-p99 are a budget: a run under the rate or over the
+// latency exits 1. The decision path itself is measured by the operator's
+// BenchmarkDecideAlert and BenchmarkProcessAlerts.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"self-healing-operator/pkg/alerts"
+)
+
+type loadBudget struct {
+	minRate float64
+	maxP99  time.Duration
+}
+
+// loadResult is one measured run
+type loadResult struct {
+	Label    string
+	N        int
+	Errors   int
+	Elapsed  time.Duration
+	Latency  []time.Duration
+	Extra    string
+	overRate bool
+	overP99  bool
+}
+
+func (r *loadResult) rate() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.N) / r.Elapsed.Seconds()
+}
+
+// percentile of the sorted latencies
+func (r *loadResult) percentile(p float64) time.Duration {
+	if len(r.Latency) == 0 {
+		return 0
+	}
+	i := int(p * float64(len(r.Latency)-1))
+	return r.Latency[i]
+}
+
+// check sorts the latencies and compares the run with the budget; it reports whether the run is within it
+func (r *loadResult) check(b loadBudget) bool {
+	sort.Slice(r.Latency, func(i, j int) bool { return r.Latency[i] < r.Latency[j] })
+	r.overRate = b.minRate > 0 && r.rate() < b.minRate
+	r.overP99 = b.maxP99 > 0 && r.percentile(0.99) > b.maxP99
+	return !r.overRate && !r.overP99
+}
+
+func (r *loadResult) String() string {
+	mark := func(over bool) string {
+		if over {
+			return " OVER BUDGET"
+		}
+		return ""
+	}
+	s := fmt.Sprintf("%-16s %8d  %6d errors  %10.1f/s%s  p50 %-10s p95 %-10s p99 %s%s",
+		r.Label, r.N, r.Errors, r.rate(), mark(r.overRate),
+		r.percentile(0.50).Round(time.Microsecond), r.percentile(0.95).Round(time.Microsecond),
+		r.percentile(0.99).Round(time.Microsecond), mark(r.overP99))
+	if r.Extra != "" {
+		s += "\n" + strings.Repeat(" ", 17) + r.Extra
+	}
+	return s
+}
+
+func main() {
+	var budget loadBudget
+	flag.Float64Var(&budget.minRate, "min-rate", 0, "budget: lowest acceptable alerts/second (0 = none)")
+	flag.DurationVar(&budget.maxP99, "max-p99", 0, "budget: highest acceptable p99 latency (0 = none)")
+	workloads := flag.Int("workloads", 50, "workloads (namespace/app) the alerts are spread over")
+	url := flag.String("url", "http://localhost:8080/webhook", "webhook URL of the operator under test")
+	rate := flag.Float64("rate", 100, "alerts per second")
+	duration := flag.Duration("duration", 30*time.Second, "how long to send")
+	concurrency := flag.Int("concurrency", 8, "concurrent senders")
+	batch := flag.Int("batch", 1, "alerts per webhook payload")
+	action := flag.String("action", "notify", "recovery_action label of the alerts")
+	metricsURL := flag.String("metrics-url", "", "admin /metrics of the operator, to report actions finished and their queue wait")
+	token := flag.String("admin-token", os.Getenv("ADMIN_TOKEN"), "bearer token for -metrics-url")
+	flag.Parse()
+	if *rate <= 0 || *concurrency < 1 || *batch < 1 || *workloads < 1 {
+		fmt.Fprintln(os.Stderr, "-rate, -concurrency, -batch and -workloads must be positive")
+		os.Exit(2)
+	}
+
+	gen := &loadAlerts{workloads: *workloads, action: *action}
+	scrape := func() map[string]float64 { return scrapeLoadMetrics(*metricsURL, *token) }
+	before := scrape()
+	res := loadWebhook(*url, gen, *rate, *duration, *concurrency, *batch)
+	if after := scrape(); before != nil && after != nil {
+		finished := after["selfhealing_remediation_finished_total"] - before["selfhealing_remediation_finished_total"]
+		n := after["selfhealing_remediation_queue_wait_seconds_count"] - before["selfhealing_remediation_queue_wait_seconds_count"]
+		wait := 0.0
+		if n > 0 {
+			wait = (after["selfhealing_remediation_queue_wait_seconds_sum"] - before["selfhealing_remediation_queue_wait_seconds_sum"]) / n
+		}
+		res.Extra = fmt.Sprintf("actions finished %.0f, mean queue wait %s, queue depth now %.0f",
+			finished, time.Duration(wait*float64(time.Second)).Round(time.Millisecond), after[`selfhealing_remediation_queue_depth{stage="pending"}`])
+	}
+
+	within := res.check(budget)
+	fmt.Println(res)
+	if !within {
+		fmt.Println("FAILED: over the performance budget")
+		os.Exit(1)
+	}
+}
+
+// loadAlerts generates the alerts of a load test, cycling through the workloads
+type loadAlerts struct {
+	workloads int
+	action    string
+	n         atomic.Int64
+}
+
+func (g *loadAlerts) next() alerts.Alert {
+	i := int(g.n.Add(1) - 1)
+	w := i % g.workloads
+	labels := map[string]string{
+		"alertname": "LoadTest",
+		"severity":  "warning",
+		"namespace": fmt.Sprintf("loadtest-%d", w%10),
+		"app":       fmt.Sprintf("app-%d", w),
+		"pod":       fmt.Sprintf("app-%d-%d", w, i),
+	}
+	if g.action != "" {
+		labels["recovery_action"] = g.action
+	}
+	return alerts.Alert{Labels: labels, Annotations: map[string]string{}, Status: "firing", StartsAt: time.Now(), Fingerprint: fmt.Sprintf("loadtest-%d", i)}
+}
+
+// loadWebhook posts payloads at the rate for the duration
+func loadWebhook(url string, gen *loadAlerts, rate float64, duration time.Duration, concurrency, batch int) *loadResult {
+	res := &loadResult{Label: "webhook"}
+	client := &http.Client{Timeout: 30 * time.Second}
+	ticks := make(chan struct{}, concurrency)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range ticks {
+				msg := alerts.WebhookMessage{GroupKey: fmt.Sprintf("loadtest/%d", gen.n.Load())}
+				for j := 0; j < batch; j++ {
+					msg.Alerts = append(msg.Alerts, gen.next())
+				}
+				body, _ := json.Marshal(msg)
+				start := time.Now()
+				resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+				took := time.Since(start)
+				failed := err != nil
+				if resp != nil {
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+					failed = failed || resp.StatusCode >= 300
+				}
+				mu.Lock()
+				res.N += batch
+				res.Latency = append(res.Latency, took)
+				if failed {
+					res.Errors++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// one tick per payload; ticks the senders can't keep up with are dropped, not queued
+	interval := time.Duration(float64(time.Second) * float64(batch) / rate)
+	ticker := time.NewTicker(interval)
+	deadline := time.After(duration)
+	start := time.Now()
+	dropped := 0
+send:
+	for {
+		select {
+		case <-deadline:
+			break send
+		case <-ticker.C:
+			select {
+			case ticks <- struct{}{}:
+			default:
+				dropped++
+			}
+		}
+	}
+	ticker.Stop()
+	close(ticks)
+	wg.Wait()
+	res.Elapsed = time.Since(start)
+	if dropped > 0 {
+		res.Extra = fmt.Sprintf("%d payload(s) not sent — the senders couldn't keep up; raise -concurrency", dropped)
+	}
+	return res
+}
+
+// scrapeLoadMetrics reads the unlabeled and pending-queue samples of a /metrics page; nil without a URL or on failure
+func scrapeLoadMetrics(url, token string) map[string]float64 {
+	if url == "" {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-metrics-url: %v\n", err)
+		return nil
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-metrics-url: %v\n", err)
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "-metrics-url: %s\n", resp.Status)
+		return nil
+	}
+	samples := map[string]float64{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(line[i+1:], 64); err == nil {
+			samples[line[:i]] = v
+		}
+	}
+	return samples
+}
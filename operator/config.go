@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Small helpers for reading optional settings from the environment.
+// Invalid values are logged and the default is used instead.
+
+var (
+	settingsMu      sync.Mutex
+	invalidSettings []string // reported by --validate-config
+)
+
+func ignoreSetting(key, v string, err error) {
+	log.Printf("Ignoring %s=%q: %v", key, v, err)
+	settingsMu.Lock()
+	invalidSettings = append(invalidSettings, fmt.Sprintf("%s=%q: %v", key, v, err))
+	settingsMu.Unlock()
+}
+
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envList splits a comma-separated value, dropping empty entries
+func envList(key string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		ignoreSetting(key, v, err)
+		return def
+	}
+	return b
+}
+
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		ignoreSetting(key, v, err)
+		return def
+	}
+	return n
+}
+
+func envFloat(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		ignoreSetting(key, v, err)
+		return def
+	}
+	return f
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		ignoreSetting(key, v, err)
+		return def
+	}
+	return d
+}
+
+func envQuantity(key, def string) resource.Quantity {
+	v := os.Getenv(key)
+	if v != "" {
+		q, err := resource.ParseQuantity(v)
+		if err == nil {
+			return q
+		}
+		ignoreSetting(key, v, err)
+	}
+	return resource.MustParse(def)
+}
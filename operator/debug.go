@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// Debug endpoints on the admin listener: a JSON dump of the operator's
+// in-memory state, plus Go pprof handlers when ENABLE_PPROF=true.
+
+func registerDebugHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/state", handleDebugState)
+
+	if envBool("ENABLE_PPROF", false) {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+}
+
+// debugState is everything the operator keeps in memory
+type debugState struct {
+	Time          time.Time             `json:"time"`
+	Goroutines    int                   `json:"goroutines"`
+	Cooldowns     map[string]time.Time  `json:"cooldowns"`
+	CooldownTime  string                `json:"cooldownTime"`
+	Recoveries    map[string]int        `json:"recoveries"`
+	Suppressions  []Suppression         `json:"suppressions"`
+	Policies      []PolicyEffectiveness `json:"policies"`
+	Rollouts      map[string]int        `json:"rollouts"`
+	Escalators    []string              `json:"escalators"`
+	ScopedClients []string              `json:"scopedClients,omitempty"`
+	BusyWorkloads []string              `json:"busyWorkloads"`
+	SafeMode      bool                  `json:"safeMode"`
+}
+
+func collectDebugState() debugState {
+	st := debugState{
+		Time:          time.Now(),
+		Goroutines:    runtime.NumGoroutine(),
+		Cooldowns:     map[string]time.Time{},
+		CooldownTime:  currentCooldown().String(),
+		Recoveries:    map[string]int{},
+		Suppressions:  listSuppressions(),
+		Policies:      effectiveness.snapshot(),
+		Rollouts:      map[string]int{},
+		Escalators:    []string{},
+		BusyWorkloads: workloadLocks.held(),
+		SafeMode:      safeMode.isActive(),
+	}
+
+	cooldownMu.Lock()
+	for k, v := range lastAction {
+		if time.Since(v) < cooldownTime {
+			st.Cooldowns[k] = v
+		}
+	}
+	cooldownMu.Unlock()
+
+	recoveryMu.Lock()
+	for k, v := range recoveryCount {
+		st.Recoveries[k] = v
+	}
+	recoveryMu.Unlock()
+
+	rolloutMu.Lock()
+	for k, v := range rolloutOutcomes {
+		st.Rollouts[k] = v
+	}
+	rolloutMu.Unlock()
+
+	for _, e := range escalators {
+		st.Escalators = append(st.Escalators, e.Name())
+	}
+
+	if f := clients.Scoped; f != nil {
+		f.mu.Lock()
+		for ns := range f.clients {
+			st.ScopedClients = append(st.ScopedClients, ns)
+		}
+		f.mu.Unlock()
+	}
+	return st
+}
+
+func handleDebugState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(collectDebugState())
+}
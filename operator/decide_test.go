@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Decision path benchmarks. Each runs at several policy counts, so a per-alert
+// lookup that grows with the number of policies shows up as a slope:
+//
+//	go test -run '^$' -bench 'DecideAlert|ProcessAlerts' -decide-budget 50us .
+//
+// With -decide-budget, BenchmarkDecideAlert fails at any policy count whose
+// mean decision takes longer; `make perf-budget` runs it that way.
+
+var decideBudget = flag.Duration("decide-budget", 0, "fail BenchmarkDecideAlert when a decision takes longer than this on average (0 = no budget)")
+
+var benchPolicyCounts = []int{1, 100, 1000, 10000}
+
+const benchWorkloads = 50
+
+// benchAlerts generates alerts spread over the policies and workloads
+type benchAlerts struct {
+	policies int
+	n        int
+}
+
+func (g *benchAlerts) next() Alert {
+	i := g.n
+	g.n++
+	p, w := i%g.policies, i%benchWorkloads
+	return Alert{
+		Labels: map[string]string{
+			"alertname":       fmt.Sprintf("BenchPolicy%d", p),
+			"recovery_action": "notify",
+			"severity":        "warning",
+			"namespace":       fmt.Sprintf("bench-%d", w%10),
+			"app":             fmt.Sprintf("app-%d", w),
+			"pod":             fmt.Sprintf("app-%d-%d", w, i),
+		},
+		Annotations: map[string]string{},
+		Status:      "firing",
+		StartsAt:    time.Now(),
+		Fingerprint: fmt.Sprintf("bench-%d", i),
+	}
+}
+
+// benchPolicies sets up n policies the way the decision path sees them: n
+// alert rules, each asking for a recovery action, with an effectiveness
+// scorecard and a remediation pending on one of their workloads. The
+// operator's state is put back when the benchmark ends.
+func benchPolicies(b *testing.B, n int) *benchAlerts {
+	b.Helper()
+	savedClients, savedEffectiveness := clients, effectiveness
+	clients = &Clients{Kube: fake.NewSimpleClientset()}
+	effectiveness = &effectivenessTracker{
+		window:     savedEffectiveness.window,
+		minSamples: savedEffectiveness.minSamples,
+		pending:    map[string]pendingRemediation{},
+		policies:   map[string]*PolicyEffectiveness{},
+	}
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() {
+		clients, effectiveness = savedClients, savedEffectiveness
+		cooldownMu.Lock()
+		lastAction = map[string]time.Time{}
+		cooldownMu.Unlock()
+		log.SetOutput(os.Stderr)
+	})
+
+	gen := &benchAlerts{policies: n}
+	for i := 0; i < n; i++ {
+		alert := gen.next()
+		effectiveness.recordRemediation(&RecoveryAction{
+			AlertName: alert.Labels["alertname"],
+			Action:    alert.Labels["recovery_action"],
+			Namespace: alert.Labels["namespace"],
+			App:       alert.Labels["app"],
+		})
+	}
+	return gen
+}
+
+func BenchmarkDecideAlert(b *testing.B) {
+	for _, n := range benchPolicyCounts {
+		b.Run(fmt.Sprintf("policies=%d", n), func(b *testing.B) {
+			gen := benchPolicies(b, n)
+			alerts := make([]Alert, 1000)
+			for i := range alerts {
+				alerts[i] = gen.next()
+			}
+			ctx := context.Background()
+			skipped := 0
+			b.ReportAllocs()
+			b.ResetTimer()
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				var trace decisionTrace
+				if decideAlert(ctx, alerts[i%len(alerts)], true, &trace) == nil {
+					skipped++
+				}
+			}
+			mean := time.Since(start) / time.Duration(b.N)
+			b.ReportMetric(float64(skipped)/float64(b.N), "skipped/op")
+			if *decideBudget > 0 && mean > *decideBudget {
+				b.Errorf("a decision with %d policies takes %s, over the %s budget", n, mean, *decideBudget)
+			}
+		})
+	}
+}
+
+// BenchmarkProcessAlerts runs webhook batches of 100 notify alerts through
+// processAlerts: decisions, per-workload locking and the actions' bookkeeping
+func BenchmarkProcessAlerts(b *testing.B) {
+	for _, n := range benchPolicyCounts {
+		b.Run(fmt.Sprintf("policies=%d", n), func(b *testing.B) {
+			gen := benchPolicies(b, n)
+			ctx := context.Background()
+			batch := make([]Alert, 100)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				for j := range batch {
+					batch[j] = gen.next()
+				}
+				b.StartTimer()
+				processAlerts(ctx, batch)
+			}
+		})
+	}
+}
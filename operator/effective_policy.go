@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Effective policy: GET /api/v1/effective-policy?namespace=ns&app=app shows
+// what the operator would apply to a workload right now and where each
+// setting comes from — the workload's annotations, its namespace's, or the
+// cluster-wide configuration (see workload_annotations.go for the order):
+//
+//	{"workload": "team-a/checkout", "deploymentFound": true, "mode": "active",
+//	 "settings": [
+//	   {"name": "enabled", "value": "true", "level": "namespace", "source": "namespace team-a"},
+//	   {"name": "cooldown", "value": "10m0s", "level": "workload", "source": "team-a/checkout"},
+//	   {"name": "max-replicas", "value": "unlimited", "level": "cluster", "source": "default"},
+//	   ...]}
+
+const (
+	levelWorkload  = "workload"
+	levelNamespace = "namespace"
+	levelCluster   = "cluster"
+)
+
+type effectivePolicy struct {
+	Workload        string          `json:"workload"`
+	DeploymentFound bool            `json:"deploymentFound"`
+	Mode            string          `json:"mode"`
+	Settings        []policySetting `json:"settings"`
+}
+
+type policySetting struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Level  string `json:"level"`  // workload, namespace or cluster
+	Source string `json:"source"` // the object or setting it comes from
+}
+
+// effectivePolicyFor resolves the workload's settings through the hierarchy
+func effectivePolicyFor(ov workloadOverrides, action *RecoveryAction) effectivePolicy {
+	p := effectivePolicy{
+		Workload:        workloadKey(action),
+		DeploymentFound: ov.found,
+		Mode:            operatingMode,
+	}
+	add := func(name, value, annotation, clusterSource string) {
+		s := policySetting{Name: name, Value: value, Level: levelCluster, Source: clusterSource}
+		if src := ov.sources[annotation]; src != "" && annotation != "" {
+			s.Level, s.Source = levelWorkload, src
+			if strings.HasPrefix(src, "namespace ") {
+				s.Level = levelNamespace
+			}
+		}
+		p.Settings = append(p.Settings, s)
+	}
+
+	enabled := !workloadOptIn
+	if ov.enabled != nil {
+		enabled = *ov.enabled
+	}
+	add("enabled", strconv.FormatBool(enabled), annoEnabled, "WORKLOAD_OPT_IN")
+
+	allowed := ov.actions
+	if len(allowed) == 0 {
+		allowed = recoveryActionNames
+	}
+	var on, off []string
+	for _, name := range allowed {
+		if actionTurnedOff(name) {
+			off = append(off, name)
+		} else {
+			on = append(on, name)
+		}
+	}
+	add("actions", strings.Join(on, ","), annoActions, "default")
+	if len(off) > 0 {
+		add("actions-turned-off", strings.Join(off, ","), "", "DISABLED_ACTIONS/ACTION_FLAGS_FILE")
+	}
+
+	add("cooldown", ov.cooldownFor().String(), annoCooldown, "tuning")
+
+	maxReplicas := "unlimited"
+	if ov.maxReplicas > 0 {
+		maxReplicas = strconv.Itoa(int(ov.maxReplicas))
+	}
+	add("max-replicas", maxReplicas, annoMaxReplicas, "default")
+
+	if time.Now().Before(ov.pausedUntil) {
+		paused := ov.pausedUntil.Format(time.RFC3339)
+		if ov.pausedBy != "" {
+			paused += " (" + ov.pausedBy + ")"
+		}
+		add("paused-until", paused, annoPausedUntil, "")
+	}
+	if until, ok := observation.until(action, false); ok {
+		add("observation-until", until.Format(time.RFC3339), "", "OBSERVATION_PERIOD")
+	}
+	return p
+}
+
+func handleEffectivePolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	namespace, app := r.URL.Query().Get("namespace"), r.URL.Query().Get("app")
+	if namespace == "" {
+		http.Error(w, "namespace is required", http.StatusBadRequest)
+		return
+	}
+	// the action a restart alert for the workload would decide
+	action := &RecoveryAction{Action: "restart", Namespace: namespace, App: app, Labels: map[string]string{"namespace": namespace}}
+	ov := workloadOverridesFor(r.Context(), action)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(effectivePolicyFor(ov, action))
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Effectiveness feedback loop: after a remediation, watch for the same alert
+// on the same target to start firing again within EFFECTIVENESS_WINDOW. If it
+// does, the remediation didn't fix anything; if the window passes quietly, it
+// counts as effective.
+//
+// Policies are identified by "alertname:action" — each alert rule carries
+// exactly one recovery_action, so that pair is what a user would tune or turn off.
+//
+// With EFFECTIVENESS_MIN_SCORE > 0, a policy whose score drops below that
+// value (after EFFECTIVENESS_MIN_SAMPLES outcomes) is auto-disabled. With a
+// persistent STORE it stays disabled across restarts, until someone re-enables
+// it with DELETE /api/v1/effectiveness?policy=alertname:action.
+
+// PolicyEffectiveness is the scorecard for one policy
+type PolicyEffectiveness struct {
+	Policy      string  `json:"policy"`
+	Effective   int     `json:"effective"`
+	Ineffective int     `json:"ineffective"`
+	Pending     int     `json:"pending"`
+	Score       float64 `json:"score"`
+	Disabled    bool    `json:"disabled"`
+}
+
+type pendingRemediation struct {
+	policy string
+	at     time.Time
+}
+
+type effectivenessTracker struct {
+	window     time.Duration
+	minScore   float64
+	minSamples int
+
+	mu       sync.Mutex
+	pending  map[string]pendingRemediation // key = alertname + "|" + namespace/app
+	policies map[string]*PolicyEffectiveness
+}
+
+var effectiveness = &effectivenessTracker{
+	window:     envDuration("EFFECTIVENESS_WINDOW", 15*time.Minute),
+	minScore:   envFloat("EFFECTIVENESS_MIN_SCORE", 0),
+	minSamples: envInt("EFFECTIVENESS_MIN_SAMPLES", 5),
+	pending:    map[string]pendingRemediation{},
+	policies:   map[string]*PolicyEffectiveness{},
+}
+
+func init() {
+	registerMetrics(effectiveness.writeMetrics)
+}
+
+func policyKey(action *RecoveryAction) string {
+	return action.AlertName + ":" + action.Action
+}
+
+func effectivenessTarget(action *RecoveryAction) string {
+	return action.AlertName + "|" + action.Namespace + "/" + action.App
+}
+
+// policyLocked returns the scorecard for a policy, creating it on first use.
+// Caller must hold t.mu.
+func (t *effectivenessTracker) policyLocked(policy string) *PolicyEffectiveness {
+	p, ok := t.policies[policy]
+	if !ok {
+		p = &PolicyEffectiveness{Policy: policy}
+		t.policies[policy] = p
+	}
+	return p
+}
+
+// settleLocked turns remediations whose window has passed into effective
+// outcomes. Caller must hold t.mu.
+func (t *effectivenessTracker) settleLocked(now time.Time) {
+	for key, rem := range t.pending {
+		if now.Sub(rem.at) >= t.window {
+			delete(t.pending, key)
+			t.scoreLocked(rem.policy, true)
+		}
+	}
+}
+
+func (t *effectivenessTracker) scoreLocked(policy string, effective bool) {
+	p := t.policyLocked(policy)
+	if effective {
+		p.Effective++
+	} else {
+		p.Ineffective++
+	}
+	total := p.Effective + p.Ineffective
+	p.Score = float64(p.Effective) / float64(total)
+
+	if t.minScore > 0 && !p.Disabled && total >= t.minSamples && p.Score < t.minScore {
+		p.Disabled = true
+		log.Printf("Policy %s auto-disabled — effectiveness %.2f below %.2f after %d remediations",
+			policy, p.Score, t.minScore, total)
+		go persist("disabled policy", func(ctx context.Context) error { return store.SavePolicyDisabled(ctx, policy, true) })
+	}
+}
+
+// observeFiring is called for every firing alert. An alert that (re)started
+// after a remediation of the same target marks that remediation ineffective.
+func (t *effectivenessTracker) observeFiring(action *RecoveryAction, startsAt time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.settleLocked(now)
+
+	key := effectivenessTarget(action)
+	rem, ok := t.pending[key]
+	if !ok {
+		return
+	}
+	// Alertmanager re-sends still-firing alerts; only a new firing counts
+	if !startsAt.IsZero() && !startsAt.After(rem.at) {
+		return
+	}
+	delete(t.pending, key)
+	t.scoreLocked(rem.policy, false)
+	log.Printf("Alert '%s' fired again for %s/%s %s after '%s' — counted as ineffective",
+		action.AlertName, action.Namespace, action.App, now.Sub(rem.at).Round(time.Second), action.Action)
+}
+
+// recordRemediation starts the observation window for a completed action
+func (t *effectivenessTracker) recordRemediation(action *RecoveryAction) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	policy := policyKey(action)
+	t.pending[effectivenessTarget(action)] = pendingRemediation{policy: policy, at: time.Now()}
+	t.policyLocked(policy)
+}
+
+// restoreDisabled marks policies disabled in a previous run as disabled again
+func (t *effectivenessTracker) restoreDisabled(policies []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, policy := range policies {
+		t.policyLocked(policy).Disabled = true
+	}
+}
+
+// enable clears a policy's auto-disable and starts its score over, so it gets
+// EFFECTIVENESS_MIN_SAMPLES new outcomes before it can be disabled again. It
+// reports whether the policy was disabled.
+func (t *effectivenessTracker) enable(policy string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.policies[policy]
+	if !ok || !p.Disabled {
+		return false
+	}
+	p.Disabled = false
+	p.Effective, p.Ineffective, p.Score = 0, 0, 0
+	return true
+}
+
+// settings returns a copy holding the tunable fields
+func (t *effectivenessTracker) settings() effectivenessTracker {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return effectivenessTracker{window: t.window, minScore: t.minScore, minSamples: t.minSamples}
+}
+
+// tune changes tunable fields under the lock
+func (t *effectivenessTracker) tune(fn func(t *effectivenessTracker)) {
+	t.mu.Lock()
+	fn(t)
+	t.mu.Unlock()
+}
+
+func (t *effectivenessTracker) isDisabled(action *RecoveryAction) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.policies[policyKey(action)]
+	return ok && p.Disabled
+}
+
+func (t *effectivenessTracker) snapshot() []PolicyEffectiveness {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.settleLocked(time.Now())
+
+	pendingByPolicy := map[string]int{}
+	for _, rem := range t.pending {
+		pendingByPolicy[rem.policy]++
+	}
+	out := make([]PolicyEffectiveness, 0, len(t.policies))
+	for _, name := range sortedKeys(t.policies) {
+		p := *t.policies[name]
+		p.Pending = pendingByPolicy[name]
+		out = append(out, p)
+	}
+	return out
+}
+
+func (t *effectivenessTracker) writeMetrics(w io.Writer) {
+	stats := t.snapshot()
+	fmt.Fprintln(w, "# HELP selfhealing_policy_remediations_total Remediation outcomes per policy, by whether the alert fired again within the window.")
+	fmt.Fprintln(w, "# TYPE selfhealing_policy_remediations_total counter")
+	for _, p := range stats {
+		fmt.Fprintf(w, "selfhealing_policy_remediations_total{policy=%q,outcome=\"effective\"} %d\n", p.Policy, p.Effective)
+		fmt.Fprintf(w, "selfhealing_policy_remediations_total{policy=%q,outcome=\"ineffective\"} %d\n", p.Policy, p.Ineffective)
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_policy_effectiveness_ratio Share of remediations that were not followed by the same alert.")
+	fmt.Fprintln(w, "# TYPE selfhealing_policy_effectiveness_ratio gauge")
+	for _, p := range stats {
+		if p.Effective+p.Ineffective > 0 {
+			fmt.Fprintf(w, "selfhealing_policy_effectiveness_ratio{policy=%q} %g\n", p.Policy, p.Score)
+		}
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_policy_disabled Whether the policy was auto-disabled for low effectiveness.")
+	fmt.Fprintln(w, "# TYPE selfhealing_policy_disabled gauge")
+	for _, p := range stats {
+		disabled := 0
+		if p.Disabled {
+			disabled = 1
+		}
+		fmt.Fprintf(w, "selfhealing_policy_disabled{policy=%q} %d\n", p.Policy, disabled)
+	}
+}
+
+// handleEffectiveness serves GET (scorecards) and DELETE ?policy=P (re-enable an auto-disabled policy)
+func handleEffectiveness(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(effectiveness.snapshot())
+
+	case http.MethodDelete:
+		policy := r.URL.Query().Get("policy")
+		if policy == "" {
+			http.Error(w, "policy query parameter required", http.StatusBadRequest)
+			return
+		}
+		user, ok := authorizeRequest(w, r, verbTune, "")
+		if !ok {
+			return
+		}
+		if !effectiveness.enable(policy) {
+			http.Error(w, "policy isn't disabled", http.StatusNotFound)
+			return
+		}
+		log.Printf("Policy %s re-enabled via API%s", policy, byUser(user))
+		ctx, cancel := context.WithTimeout(r.Context(), storeTimeout)
+		defer cancel()
+		if err := store.SavePolicyDisabled(ctx, policy, false); err != nil {
+			log.Printf("Failed to persist re-enabled policy to %s store: %v", store.Name(), err)
+			http.Error(w, "re-enabled, but not saved: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// policyStore records the disabled flags it's asked to save
+type policyStore struct {
+	memoryStore
+	mu    sync.Mutex
+	saved map[string]bool
+}
+
+// wait returns the saved flag for policy once a save arrived
+func (s *policyStore) wait(policy string) (disabled, ok bool) {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		s.mu.Lock()
+		disabled, ok = s.saved[policy]
+		s.mu.Unlock()
+		if ok {
+			return disabled, ok
+		}
+	}
+	return false, false
+}
+
+func (s *policyStore) SavePolicyDisabled(_ context.Context, policy string, disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saved[policy] = disabled
+	return nil
+}
+
+func newTestTracker(minScore float64, minSamples int) *effectivenessTracker {
+	return &effectivenessTracker{
+		window:     time.Minute,
+		minScore:   minScore,
+		minSamples: minSamples,
+		pending:    map[string]pendingRemediation{},
+		policies:   map[string]*PolicyEffectiveness{},
+	}
+}
+
+func TestEffectivenessAutoDisable(t *testing.T) {
+	tests := []struct {
+		name       string
+		minScore   float64
+		minSamples int
+		outcomes   []bool // effective?
+		disabled   bool
+	}{
+		{"off without a minimum score", 0, 1, []bool{false, false, false}, false},
+		{"too few samples", 0.5, 5, []bool{false, false, false, false}, false},
+		{"score below the minimum", 0.5, 4, []bool{true, false, false, false}, true},
+		{"score at the minimum", 0.5, 4, []bool{true, true, false, false}, false},
+	}
+	savedStore := store
+	defer func() { store = savedStore }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := &policyStore{saved: map[string]bool{}}
+			store = ps
+			tr := newTestTracker(tt.minScore, tt.minSamples)
+			tr.mu.Lock()
+			for _, effective := range tt.outcomes {
+				tr.scoreLocked("Down:restart", effective)
+			}
+			tr.mu.Unlock()
+			if got := tr.isDisabled(&RecoveryAction{AlertName: "Down", Action: "restart"}); got != tt.disabled {
+				t.Errorf("disabled = %v, want %v", got, tt.disabled)
+			}
+			if tt.disabled {
+				if disabled, ok := ps.wait("Down:restart"); !disabled || !ok {
+					t.Errorf("auto-disable wasn't saved")
+				}
+			}
+		})
+	}
+}
+
+func TestEffectivenessRefiringIsIneffective(t *testing.T) {
+	tr := newTestTracker(0, 1)
+	action := &RecoveryAction{AlertName: "Down", Action: "restart", Namespace: "shop", App: "cart"}
+	tr.recordRemediation(action)
+	started := tr.pending[effectivenessTarget(action)].at
+
+	tr.observeFiring(action, started) // the same firing, re-sent
+	tr.observeFiring(action, started.Add(time.Second))
+	p := tr.snapshot()[0]
+	if p.Ineffective != 1 || p.Pending != 0 {
+		t.Errorf("scorecard = %+v, want one ineffective remediation", p)
+	}
+}
+
+func TestReenableDisabledPolicy(t *testing.T) {
+	saved, savedStore := effectiveness, store
+	ps := &policyStore{saved: map[string]bool{}}
+	effectiveness, store = newTestTracker(0.5, 1), ps
+	defer func() { effectiveness, store = saved, savedStore }()
+	effectiveness.restoreDisabled([]string{"Down:restart"})
+
+	tests := []struct {
+		policy string
+		status int
+	}{
+		{"", http.StatusBadRequest},
+		{"Other:restart", http.StatusNotFound},
+		{"Down:restart", http.StatusNoContent},
+		{"Down:restart", http.StatusNotFound}, // already enabled
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		handleEffectiveness(rr, httptest.NewRequest(http.MethodDelete, "/api/v1/effectiveness?policy="+tt.policy, nil))
+		if rr.Code != tt.status {
+			t.Errorf("DELETE policy=%q: status %d, want %d", tt.policy, rr.Code, tt.status)
+		}
+	}
+
+	action := &RecoveryAction{AlertName: "Down", Action: "restart"}
+	if effectiveness.isDisabled(action) {
+		t.Error("policy still disabled")
+	}
+	if disabled, ok := ps.saved["Down:restart"]; !ok || disabled {
+		t.Errorf("store saved disabled=%v (saved %v), want false", disabled, ok)
+	}
+	if p := effectiveness.snapshot()[0]; p.Effective+p.Ineffective != 0 {
+		t.Errorf("re-enabled policy kept its old score: %+v", p)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Egress for outbound integrations. Every call to a service outside the
+// cluster API goes through the client of its integration (egressClient), so
+// clusters that only allow egress through a proxy can route it:
+//   - HTTPS_PROXY / HTTP_PROXY / NO_PROXY apply to all integrations (the
+//     Kubernetes client honours them too, so NO_PROXY has to cover the API
+//     server; the operator warns if it doesn't)
+//   - EGRESS_PROXY_<INTEGRATION> overrides the proxy for one integration: a
+//     proxy URL, or "direct" to bypass the proxy
+//   - EGRESS_CA_FILE is a PEM bundle trusted on top of the system roots for
+//     all integrations, e.g. a TLS-inspecting proxy's CA
+//   - EGRESS_CA_FILE_<INTEGRATION> adds a bundle for one integration, e.g. a
+//     Vault or Jira with a private CA
+//
+// Integrations are named in egressIntegrations; the setting suffix is the
+// name in upper case (EGRESS_PROXY_SLACK, EGRESS_CA_FILE_VAULT, ...).
+
+// egressIntegrations are the outbound integrations
+var egressIntegrations = []string{
+	"alertmanager", "aws", "callback", "elastic", "github", "gitlab", "grafana", "honeycomb",
+	"jira", "kafka", "node_recycle", "opsgenie", "otlp", "prometheus", "slack", "sns", "splunk", "vault",
+}
+
+const egressTimeout = 10 * time.Second
+
+var (
+	egressMu      sync.Mutex
+	egressClients = map[string]*http.Client{}
+)
+
+// setupEgress builds every integration's client, failing on bad settings
+func setupEgress() error {
+	for _, name := range egressIntegrations {
+		client, err := newEgressClient(name)
+		if err != nil {
+			return err
+		}
+		egressMu.Lock()
+		egressClients[name] = client
+		egressMu.Unlock()
+		if p := os.Getenv(egressSetting("EGRESS_PROXY", name)); p != "" {
+			log.Printf("Egress for %s — proxy %s", name, redactProxy(p))
+		}
+	}
+	if p := envString("HTTPS_PROXY", os.Getenv("https_proxy")); p != "" {
+		log.Printf("Egress — HTTPS proxy %s, NO_PROXY %q", redactProxy(p), noProxy())
+		if host := os.Getenv("KUBERNETES_SERVICE_HOST"); host != "" && !noProxyCovers(host) {
+			log.Printf("NO_PROXY doesn't cover the Kubernetes API server (%s) — its requests go through the proxy", host)
+		}
+	}
+	return nil
+}
+
+// egressClient returns the HTTP client of an outbound integration
+func egressClient(integration string) *http.Client {
+	egressMu.Lock()
+	defer egressMu.Unlock()
+	if c, ok := egressClients[integration]; ok {
+		return c
+	}
+	// setupEgress hasn't run (or doesn't know the name): build it now
+	c, err := newEgressClient(integration)
+	if err != nil {
+		log.Printf("Egress settings for %s are invalid, using the defaults — %v", integration, err)
+		c = &http.Client{Timeout: egressTimeout}
+	}
+	egressClients[integration] = c
+	return c
+}
+
+func egressSetting(prefix, integration string) string {
+	return prefix + "_" + strings.ToUpper(integration)
+}
+
+func newEgressClient(integration string) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if p := os.Getenv(egressSetting("EGRESS_PROXY", integration)); p != "" {
+		if p == "direct" {
+			transport.Proxy = nil
+		} else {
+			u, err := url.Parse(p)
+			if err != nil || u.Host == "" {
+				return nil, fmt.Errorf("%s: invalid proxy URL %q", egressSetting("EGRESS_PROXY", integration), p)
+			}
+			transport.Proxy = http.ProxyURL(u)
+		}
+	}
+
+	var bundles []string
+	for _, setting := range []string{"EGRESS_CA_FILE", egressSetting("EGRESS_CA_FILE", integration)} {
+		if file := os.Getenv(setting); file != "" {
+			bundles = append(bundles, setting+"="+file)
+		}
+	}
+	if len(bundles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, b := range bundles {
+			setting, file, _ := strings.Cut(b, "=")
+			pem, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", setting, err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("%s: no certificates in %s", setting, file)
+			}
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return &http.Client{Timeout: egressTimeout, Transport: transport}, nil
+}
+
+func noProxy() string {
+	return envString("NO_PROXY", os.Getenv("no_proxy"))
+}
+
+// noProxyCovers reports whether NO_PROXY exempts the host: exact hosts,
+// .domain suffixes, CIDRs and *
+func noProxyCovers(host string) bool {
+	for _, entry := range strings.Split(noProxy(), ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+		case entry == "*", entry == host:
+			return true
+		case strings.HasPrefix(entry, ".") && strings.HasSuffix(host, entry):
+			return true
+		case strings.Contains(entry, "/"):
+			if _, network, err := net.ParseCIDR(entry); err == nil && network.Contains(net.ParseIP(host)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// redactProxy drops the password from a proxy URL for logging
+func redactProxy(p string) string {
+	u, err := url.Parse(p)
+	if err != nil {
+		return "(invalid)"
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Alert enrichment. Alert labels are a snapshot from when the rule fired; once
+// an alert has an action, the operator looks up what the pod (or node) looks
+// like now:
+//   - pod phase, restart count and the last termination reason and exit code
+//   - the node it runs on and whether that node is Ready
+//   - the owner chain, e.g. ReplicaSet/web-7d4f → Deployment/web
+//   - the pod's most recent events (ENRICHMENT_EVENTS, newest first)
+//
+// The context shows up in the explain trace, is stored (and signed) with the
+// history record, and workflow `when` conditions can test it as if it were
+// labels: pod.phase, pod.restarts, pod.lastTerminationReason, pod.lastExitCode,
+// node, node.ready, owner.kind and owner.name (the top of the chain). E.g.
+// `when: "pod.lastTerminationReason=OOMKilled"`. ENRICHMENT=false turns it off.
+
+var (
+	enrichmentEnabled = envBool("ENRICHMENT", true)
+	enrichmentEvents  = envInt("ENRICHMENT_EVENTS", 5)
+)
+
+// Enrichment is the live Kubernetes context of an alert's target
+type Enrichment struct {
+	PodPhase              string    `json:"podPhase,omitempty"`
+	Restarts              int32     `json:"restarts,omitempty"`
+	LastTerminationReason string    `json:"lastTerminationReason,omitempty"`
+	LastExitCode          string    `json:"lastExitCode,omitempty"`
+	Node                  string    `json:"node,omitempty"`
+	NodeReady             *bool     `json:"nodeReady,omitempty"`
+	Owners                []string  `json:"owners,omitempty"` // Kind/name, nearest first
+	Events                []string  `json:"events,omitempty"`
+	CollectedAt           time.Time `json:"collectedAt"`
+}
+
+// enrichAction collects the target's live context; nil when there's nothing to look up
+func enrichAction(ctx context.Context, action *RecoveryAction) *Enrichment {
+	if !enrichmentEnabled || clients == nil || (action.Pod == "" && action.Labels["node"] == "") {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	cs := clients.Kube
+	e := &Enrichment{Node: action.Labels["node"], CollectedAt: time.Now()}
+
+	if action.Pod != "" {
+		pod := cachedPod(action.Namespace, action.Pod)
+		if pod == nil {
+			p, err := cs.CoreV1().Pods(action.Namespace).Get(ctx, action.Pod, metav1.GetOptions{})
+			if err != nil {
+				return nil // gone already; the action will find out
+			}
+			pod = p
+		}
+		e.PodPhase = string(pod.Status.Phase)
+		e.Node = pod.Spec.NodeName
+		var last *corev1.ContainerStateTerminated
+		for _, s := range pod.Status.ContainerStatuses {
+			e.Restarts += s.RestartCount
+			if t := s.LastTerminationState.Terminated; t != nil && (last == nil || t.FinishedAt.After(last.FinishedAt.Time)) {
+				last = t
+			}
+		}
+		if last != nil {
+			e.LastTerminationReason = last.Reason
+			e.LastExitCode = formatExitCode(last.ExitCode, podOS(pod))
+		}
+		e.Owners = ownerChain(ctx, cs, pod.Namespace, pod.OwnerReferences)
+		e.Events = recentEvents(ctx, cs, pod.Namespace, "Pod", pod.Name)
+	}
+
+	if e.Node != "" {
+		if n, err := cs.CoreV1().Nodes().Get(ctx, e.Node, metav1.GetOptions{}); err == nil {
+			ready, _ := nodeReady(n)
+			e.NodeReady = &ready
+			if action.Pod == "" {
+				e.Events = recentEvents(ctx, cs, "", "Node", e.Node)
+			}
+		}
+	}
+	return e
+}
+
+// ownerChain follows controller references up to the top-level workload
+func ownerChain(ctx context.Context, cs kubernetes.Interface, namespace string, refs []metav1.OwnerReference) []string {
+	var chain []string
+	for depth := 0; depth < 4; depth++ {
+		ref := metav1.GetControllerOfNoCopy(&metav1.ObjectMeta{OwnerReferences: refs})
+		if ref == nil {
+			break
+		}
+		chain = append(chain, ref.Kind+"/"+ref.Name)
+		refs = nil
+		switch ref.Kind {
+		case "ReplicaSet":
+			if rs, err := cs.AppsV1().ReplicaSets(namespace).Get(ctx, ref.Name, metav1.GetOptions{}); err == nil {
+				refs = rs.OwnerReferences
+			}
+		case "Job":
+			if job, err := cs.BatchV1().Jobs(namespace).Get(ctx, ref.Name, metav1.GetOptions{}); err == nil {
+				refs = job.OwnerReferences
+			}
+		case "ReplicationController":
+			// DeploymentConfigs own theirs
+			if rc, err := cs.CoreV1().ReplicationControllers(namespace).Get(ctx, ref.Name, metav1.GetOptions{}); err == nil {
+				refs = rc.OwnerReferences
+			}
+		}
+	}
+	return chain
+}
+
+// recentEvents returns the object's newest events as "Type Reason: message (xN)"
+func recentEvents(ctx context.Context, cs kubernetes.Interface, namespace, kind, name string) []string {
+	if enrichmentEvents <= 0 {
+		return nil
+	}
+	list, err := cs.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: "involvedObject.kind=" + kind + ",involvedObject.name=" + name,
+	})
+	if err != nil {
+		return nil
+	}
+	events := list.Items
+	sort.Slice(events, func(i, j int) bool { return eventTime(events[i]).After(eventTime(events[j])) })
+	var out []string
+	for i := 0; i < len(events) && i < enrichmentEvents; i++ {
+		ev := events[i]
+		line := fmt.Sprintf("%s %s: %s", ev.Type, ev.Reason, strings.TrimSpace(ev.Message))
+		if ev.Count > 1 {
+			line += fmt.Sprintf(" (x%d)", ev.Count)
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+func eventTime(ev corev1.Event) time.Time {
+	switch {
+	case !ev.LastTimestamp.IsZero():
+		return ev.LastTimestamp.Time
+	case !ev.EventTime.IsZero():
+		return ev.EventTime.Time
+	}
+	return ev.CreationTimestamp.Time
+}
+
+// String is the one-line form used in the explain trace
+func (e *Enrichment) String() string {
+	var parts []string
+	if e.PodPhase != "" {
+		parts = append(parts, fmt.Sprintf("pod %s, %d restart(s)", e.PodPhase, e.Restarts))
+	}
+	if e.LastTerminationReason != "" {
+		parts = append(parts, "last terminated "+e.LastTerminationReason+" (exit "+e.LastExitCode+")")
+	}
+	if e.Node != "" {
+		node := "node " + e.Node
+		if e.NodeReady != nil && !*e.NodeReady {
+			node += " (NotReady)"
+		}
+		parts = append(parts, node)
+	}
+	if len(e.Owners) > 0 {
+		parts = append(parts, "owned by "+strings.Join(e.Owners, " → "))
+	}
+	if len(e.Events) > 0 {
+		parts = append(parts, fmt.Sprintf("%d recent event(s), latest: %s", len(e.Events), e.Events[0]))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// conditionLabels are the labels `when` conditions see: the alert's, plus its enrichment
+func conditionLabels(action *RecoveryAction) map[string]string {
+	e := action.Enrichment
+	if e == nil {
+		return action.Labels
+	}
+	labels := make(map[string]string, len(action.Labels)+8)
+	for k, v := range action.Labels {
+		labels[k] = v
+	}
+	labels["pod.phase"] = e.PodPhase
+	labels["pod.restarts"] = strconv.Itoa(int(e.Restarts))
+	labels["pod.lastTerminationReason"] = e.LastTerminationReason
+	labels["pod.lastExitCode"] = e.LastExitCode
+	if e.Node != "" {
+		labels["node"] = e.Node
+	}
+	if e.NodeReady != nil {
+		labels["node.ready"] = strconv.FormatBool(*e.NodeReady)
+	}
+	if n := len(e.Owners); n > 0 {
+		labels["owner.kind"], labels["owner.name"], _ = strings.Cut(e.Owners[n-1], "/")
+	}
+	return labels
+}
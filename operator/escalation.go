@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Escalation: when the operator can't fix something on its own (the action
+// failed, or the policy was auto-disabled) it pages a human through whichever
+// paging tool is configured. Both integrations de-duplicate on the alert +
+// target, so repeated escalations update one incident instead of opening many.
+
+// Escalation carries the full remediation context sent to paging tools
+type Escalation struct {
+	AlertName   string
+	Action      string
+	Namespace   string
+	App         string
+	Pod         string
+	Reason      string
+	Labels      map[string]string
+	Annotations map[string]string
+	Tags        map[string]string // see tags.go
+	Time        time.Time
+}
+
+func (e Escalation) dedupKey() string {
+	return "selfhealing/" + e.AlertName + "/" + e.Namespace + "/" + e.App
+}
+
+func (e Escalation) summary() string {
+	name := "escalation-summary"
+	if e.Action == "notify" {
+		// nothing was tried, so nothing failed
+		name = "notify-summary"
+	}
+	return renderMessage(name, alertLocale(e.Labels, e.Annotations), e)
+}
+
+// Escalator sends an escalation to one paging tool
+type Escalator interface {
+	Name() string
+	Escalate(ctx context.Context, e Escalation) error
+}
+
+var escalators []Escalator
+
+var (
+	disabledEscalationsMu sync.Mutex
+	disabledEscalations   = map[string]time.Time{} // policy|namespace/app -> last escalation
+)
+
+// setupEscalators enables each integration whose credentials are configured
+func setupEscalators() {
+	if os.Getenv("OPSGENIE_API_KEY") != "" {
+		escalators = append(escalators, &opsgenieEscalator{
+			apiURL: envString("OPSGENIE_API_URL", "https://api.opsgenie.com"),
+			apiKey: credential("OPSGENIE_API_KEY"),
+		})
+	}
+	if os.Getenv("SPLUNK_ONCALL_URL") != "" {
+		escalators = append(escalators, &splunkOnCallEscalator{
+			url:        credential("SPLUNK_ONCALL_URL"),
+			routingKey: envString("SPLUNK_ONCALL_ROUTING_KEY", "default"),
+		})
+	}
+	for _, e := range escalators {
+		log.Printf("Escalation via %s enabled", e.Name())
+	}
+}
+
+// escalate fans the escalation out to every configured integration in the background
+func escalate(action *RecoveryAction, alert Alert, reason string) {
+	if len(escalators) == 0 {
+		return
+	}
+	e := Escalation{
+		AlertName:   action.AlertName,
+		Action:      action.Action,
+		Namespace:   action.Namespace,
+		App:         action.App,
+		Pod:         action.Pod,
+		Reason:      reason,
+		Labels:      alert.Labels,
+		Annotations: alert.Annotations,
+		Tags:        remediationTags(alert.Labels),
+		Time:        time.Now(),
+	}
+	for _, esc := range escalators {
+		go func(esc Escalator) {
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			defer cancel()
+			if err := esc.Escalate(ctx, e); err != nil {
+				log.Printf("Escalation via %s failed: %v", esc.Name(), err)
+				return
+			}
+			log.Printf("Escalated '%s' for %s/%s via %s", e.AlertName, e.Namespace, e.App, esc.Name())
+		}(esc)
+	}
+}
+
+// escalateDisabledPolicy pages about an auto-disabled policy at most hourly
+// per policy and target: the alert keeps being re-sent while it's disabled
+func escalateDisabledPolicy(action *RecoveryAction, alert Alert) {
+	key := policyKey(action) + "|" + action.Namespace + "/" + action.App
+	now := time.Now()
+	disabledEscalationsMu.Lock()
+	for k, at := range disabledEscalations {
+		if now.Sub(at) >= time.Hour {
+			delete(disabledEscalations, k)
+		}
+	}
+	if _, ok := disabledEscalations[key]; ok {
+		disabledEscalationsMu.Unlock()
+		return
+	}
+	disabledEscalations[key] = now
+	disabledEscalationsMu.Unlock()
+
+	escalate(action, alert, "policy "+policyKey(action)+" was auto-disabled for low effectiveness; manual attention needed")
+}
+
+// postJSON sends a JSON body and treats any non-2xx response as an error
+func postJSON(ctx context.Context, integration, url string, body interface{}, headers map[string]string) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := egressClient(integration).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+// opsgenieEscalator creates alerts through the Opsgenie Alert API v2
+type opsgenieEscalator struct {
+	apiURL string
+	apiKey credential
+}
+
+func (o *opsgenieEscalator) Name() string { return "opsgenie" }
+
+func (o *opsgenieEscalator) Escalate(ctx context.Context, e Escalation) error {
+	details := map[string]string{
+		"action":    e.Action,
+		"namespace": e.Namespace,
+		"app":       e.App,
+		"pod":       e.Pod,
+		"reason":    e.Reason,
+	}
+	for k, v := range e.Labels {
+		details["label_"+k] = v
+	}
+	body := map[string]interface{}{
+		"message":     truncate(e.summary(), 130),
+		"alias":       e.dedupKey(),
+		"description": e.Reason + "\n\n" + e.Annotations["description"],
+		"details":     details,
+		"tags":        append([]string{"self-healing", e.Action, e.Namespace}, tagList(e.Tags)...),
+		"source":      "self-healing-operator",
+		"priority":    "P2",
+	}
+	return postJSON(ctx, "opsgenie", o.apiURL+"/v2/alerts", body, map[string]string{
+		"Authorization": "GenieKey " + o.apiKey.value(),
+	})
+}
+
+// splunkOnCallEscalator uses the Splunk On-Call (VictorOps) REST endpoint
+// integration. url is the integration URL including the API key.
+type splunkOnCallEscalator struct {
+	url        credential
+	routingKey string
+}
+
+func (s *splunkOnCallEscalator) Name() string { return "splunk-oncall" }
+
+func (s *splunkOnCallEscalator) Escalate(ctx context.Context, e Escalation) error {
+	body := map[string]interface{}{
+		"message_type":        "CRITICAL",
+		"entity_id":           e.dedupKey(),
+		"entity_display_name": e.summary(),
+		"state_message":       e.Reason,
+		"state_start_time":    e.Time.Unix(),
+		"monitoring_tool":     "self-healing-operator",
+		"alert_name":          e.AlertName,
+		"recovery_action":     e.Action,
+		"namespace":           e.Namespace,
+		"app":                 e.App,
+		"pod":                 e.Pod,
+		"description":         e.Annotations["description"],
+	}
+	for k, v := range e.Tags {
+		body["tag_"+k] = v
+	}
+	return postJSON(ctx, "splunk", strings.TrimRight(s.url.value(), "/")+"/"+s.routingKey, body, nil)
+}
+
+// truncate shortens s to at most n bytes, without splitting a UTF-8 character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	cut := n - 3
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "..."
+}
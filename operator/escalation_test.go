@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// recordingEscalator counts the escalations it receives
+type recordingEscalator struct {
+	got chan Escalation
+}
+
+func (r *recordingEscalator) Name() string { return "recording" }
+
+func (r *recordingEscalator) Escalate(_ context.Context, e Escalation) error {
+	r.got <- e
+	return nil
+}
+
+// wait returns how many escalations arrived, waiting for at least want
+func (r *recordingEscalator) wait(want int) int {
+	n := 0
+	timeout := time.After(time.Second)
+	for {
+		if n >= want {
+			timeout = time.After(100 * time.Millisecond) // any extra ones
+		}
+		select {
+		case <-r.got:
+			n++
+		case <-timeout:
+			return n
+		}
+	}
+}
+
+func TestTruncateKeepsCharacters(t *testing.T) {
+	s := strings.Repeat("ü", 100) // 2 bytes each
+	for n := 4; n < 20; n++ {
+		got := truncate(s, n)
+		if len(got) > n || !utf8.ValidString(got) || !strings.HasSuffix(got, "...") {
+			t.Errorf("truncate(_, %d) = %q", n, got)
+		}
+	}
+	if got := truncate("short", 10); got != "short" {
+		t.Errorf("truncate changed a short string: %q", got)
+	}
+}
+
+func TestSummaryOfNotify(t *testing.T) {
+	e := Escalation{AlertName: "DiskFull", Action: "notify", Namespace: "shop", App: "cart"}
+	if got := e.summary(); strings.Contains(got, "failed") {
+		t.Errorf("notify summary says it failed: %q", got)
+	}
+	e.Action = "restart"
+	if got := e.summary(); !strings.Contains(got, "failed") {
+		t.Errorf("restart summary = %q, want a failure", got)
+	}
+}
+
+func TestDisabledPolicyEscalatesHourly(t *testing.T) {
+	saved := escalators
+	rec := &recordingEscalator{got: make(chan Escalation, 10)}
+	escalators = []Escalator{rec}
+	disabledEscalationsMu.Lock()
+	savedThrottle := disabledEscalations
+	disabledEscalations = map[string]time.Time{}
+	disabledEscalationsMu.Unlock()
+	defer func() {
+		escalators = saved
+		disabledEscalationsMu.Lock()
+		disabledEscalations = savedThrottle
+		disabledEscalationsMu.Unlock()
+	}()
+
+	action := &RecoveryAction{AlertName: "DiskFull", Action: "restart", Namespace: "shop", App: "cart"}
+	for i := 0; i < 3; i++ {
+		escalateDisabledPolicy(action, Alert{})
+	}
+	other := *action
+	other.App = "checkout"
+	escalateDisabledPolicy(&other, Alert{})
+
+	if n := rec.wait(2); n != 2 {
+		t.Errorf("%d escalations, want one per policy and target", n)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+)
+
+// Explain traces: decideAlert records every check it runs (which action
+// policies were looked at and which matched, each guardrail and its result,
+// the resolved action parameters) as a list of steps. Executed actions carry
+// their trace into the history record, so
+//
+//	curl localhost:8080/api/v1/history?id=42
+//
+// answers "why did it restart that pod?". Test alerts return the same trace.
+
+const (
+	tracePass = "pass"
+	traceSkip = "skip"
+	traceInfo = "info"
+)
+
+// TraceStep is one check of the decision for an alert
+type TraceStep struct {
+	Check  string `json:"check"`
+	Result string `json:"result"` // pass, skip, info
+	Detail string `json:"detail,omitempty"`
+}
+
+type decisionTrace []TraceStep
+
+// add appends a step; a nil trace ignores it
+func (t *decisionTrace) add(check, result, detail string) {
+	if t == nil {
+		return
+	}
+	*t = append(*t, TraceStep{Check: check, Result: result, Detail: detail})
+}
+
+// parameters describes the values the action will run with
+func (a *RecoveryAction) parameters() string {
+	var parts []string
+	for _, kv := range [][2]string{
+		{"namespace", a.Namespace},
+		{"app", a.App},
+		{"pod", a.Pod},
+		{"runbook", a.Runbook},
+		{"workflow", a.Workflow},
+		{"callback", a.Callback},
+	} {
+		if kv[1] != "" {
+			parts = append(parts, kv[0]+"="+kv[1])
+		}
+	}
+	if a.Escalate {
+		parts = append(parts, "escalate=true")
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Stuck finalizers. A Namespace or custom resource whose controller (or
+// admission webhook, or aggregated API) is gone stays Terminating forever,
+// because nothing is left to remove its finalizers; clearing them by hand is
+// a frequent chore. `recovery_action: clear_finalizers` does it, guarded:
+//   - it always needs approval: whatever MODE says, the decision becomes a
+//     recommendation with an approval link, and nothing changes until someone
+//     approves it
+//   - only finalizers listed in FINALIZER_ALLOWLIST are removed (none by
+//     default), others are left and reported
+//   - the object must have been terminating for FINALIZER_STUCK_AFTER
+//
+// The target is the alert's namespace, or the custom resource named by the
+// customresource_group/_version/_kind labels (as kube-state-metrics' custom
+// resource state metrics set them) and the name label:
+//
+//	- alert: NamespaceStuckTerminating
+//	  expr: kube_namespace_status_phase{phase="Terminating"} == 1
+//	  for: 30m
+//	  labels:
+//	    recovery_action: clear_finalizers
+//
+// For a Namespace, both metadata.finalizers and spec.finalizers (the
+// "kubernetes" finalizer the namespace controller holds until the content is
+// gone, through the finalize subresource) are cleared.
+
+var (
+	finalizerAllowlist  = envList("FINALIZER_ALLOWLIST")
+	finalizerStuckAfter = envDuration("FINALIZER_STUCK_AFTER", 15*time.Minute)
+
+	finalizerMu      sync.Mutex
+	finalizerCleared = map[string]int{} // kind -> finalizers removed
+)
+
+func init() {
+	registerMetrics(writeFinalizerMetrics)
+}
+
+// requiresApproval reports whether the action only ever runs once approved
+func requiresApproval(action string) bool {
+	return action == "clear_finalizers" || isWebhookAction(action)
+}
+
+// finalizerTarget is the object whose finalizers are cleared
+type finalizerTarget struct {
+	groupVersion, kind, resource string
+	namespace, name              string // namespace is empty for cluster-scoped objects
+}
+
+func (t finalizerTarget) path() string {
+	prefix := "/apis/" + t.groupVersion
+	if t.groupVersion == "v1" {
+		prefix = "/api/v1"
+	}
+	if t.namespace == "" {
+		return path.Join(prefix, t.resource, t.name)
+	}
+	return path.Join(prefix, "namespaces", t.namespace, t.resource, t.name)
+}
+
+func (t finalizerTarget) String() string {
+	if t.namespace == "" {
+		return t.kind + " " + t.name
+	}
+	return t.kind + " " + t.namespace + "/" + t.name
+}
+
+func (t finalizerTarget) objectReference() corev1.ObjectReference {
+	return corev1.ObjectReference{APIVersion: t.groupVersion, Kind: t.kind, Namespace: t.namespace, Name: t.name}
+}
+
+// finalizerTargetFor finds the object the alert is about
+func finalizerTargetFor(kube kubernetes.Interface, labels map[string]string) (finalizerTarget, error) {
+	kind := labels["customresource_kind"]
+	if kind == "" {
+		if labels["namespace"] == "" {
+			return finalizerTarget{}, fmt.Errorf("no namespace label in alert for clear_finalizers")
+		}
+		return finalizerTarget{groupVersion: "v1", kind: "Namespace", resource: "namespaces", name: labels["namespace"]}, nil
+	}
+
+	group, version, name := labels["customresource_group"], labels["customresource_version"], labels["name"]
+	if version == "" || name == "" {
+		return finalizerTarget{}, fmt.Errorf("clear_finalizers on a %s needs the customresource_version and name labels", kind)
+	}
+	gv := version
+	if group != "" {
+		gv = group + "/" + version
+	}
+	list, err := kube.Discovery().ServerResourcesForGroupVersion(gv)
+	if err != nil {
+		return finalizerTarget{}, fmt.Errorf("failed to look up %s in %s: %v", kind, gv, err)
+	}
+	for _, r := range list.APIResources {
+		if r.Kind != kind || strings.Contains(r.Name, "/") {
+			continue
+		}
+		t := finalizerTarget{groupVersion: gv, kind: kind, resource: r.Name, name: name}
+		if r.Namespaced {
+			if t.namespace = labels["namespace"]; t.namespace == "" {
+				return finalizerTarget{}, fmt.Errorf("%s is namespaced, but the alert has no namespace label", kind)
+			}
+		}
+		return t, nil
+	}
+	return finalizerTarget{}, fmt.Errorf("%s doesn't serve kind %s", gv, kind)
+}
+
+// splitFinalizers separates the allowlisted finalizers from the others
+func splitFinalizers(finalizers []string) (allowed, kept []string) {
+	for _, f := range finalizers {
+		listed := false
+		for _, a := range finalizerAllowlist {
+			if f == a {
+				listed = true
+				break
+			}
+		}
+		if listed {
+			allowed = append(allowed, f)
+		} else {
+			kept = append(kept, f)
+		}
+	}
+	return allowed, kept
+}
+
+// clearFinalizers removes the allowlisted finalizers from the alert's stuck object
+func clearFinalizers(ctx context.Context, c *Clients, action *RecoveryAction) error {
+	// the object's type isn't known in advance, so this uses the operator's own client
+	t, err := finalizerTargetFor(c.Kube, action.Labels)
+	if err != nil {
+		return err
+	}
+	var obj unstructured.Unstructured
+	if err := apiRequest(ctx, c.Kube, "GET", t.path(), "", nil, &obj.Object); err != nil {
+		return fmt.Errorf("failed to get %s: %v", t, err)
+	}
+	deleted := obj.GetDeletionTimestamp()
+	if deleted == nil {
+		return fmt.Errorf("%s isn't being deleted; finalizers are only cleared from objects stuck terminating", t)
+	}
+	if age := time.Since(deleted.Time); age < finalizerStuckAfter {
+		return fmt.Errorf("%s has been terminating for only %s (FINALIZER_STUCK_AFTER is %s)", t, age.Round(time.Second), finalizerStuckAfter)
+	}
+
+	removeMeta, keepMeta := splitFinalizers(obj.GetFinalizers())
+	var removeSpec, keepSpec []string
+	if t.kind == "Namespace" {
+		spec, _, _ := unstructured.NestedStringSlice(obj.Object, "spec", "finalizers")
+		removeSpec, keepSpec = splitFinalizers(spec)
+	}
+	removed := append(append([]string(nil), removeMeta...), removeSpec...)
+	kept := append(append([]string(nil), keepMeta...), keepSpec...)
+	if len(removed) == 0 {
+		if len(kept) == 0 {
+			log.Printf("%s has no finalizers left — nothing to do", t)
+			return nil
+		}
+		return fmt.Errorf("%s is held by %s, none of which is in FINALIZER_ALLOWLIST", t, strings.Join(kept, ", "))
+	}
+
+	if len(removeMeta) > 0 {
+		// the test makes the patch fail if a controller changed the object since it was read
+		patch := []map[string]interface{}{
+			{"op": "test", "path": "/metadata/resourceVersion", "value": obj.GetResourceVersion()},
+			{"op": "replace", "path": "/metadata/finalizers", "value": nonNil(keepMeta)},
+		}
+		if err := apiRequest(ctx, c.Kube, "PATCH", t.path(), string(types.JSONPatchType), patch, nil); err != nil {
+			return fmt.Errorf("failed to remove finalizers from %s: %v", t, err)
+		}
+	}
+	if len(removeSpec) > 0 {
+		ns, err := c.Kube.CoreV1().Namespaces().Get(ctx, t.name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get namespace %s: %v", t.name, err)
+		}
+		ns.Spec.Finalizers = nil
+		for _, f := range keepSpec {
+			ns.Spec.Finalizers = append(ns.Spec.Finalizers, corev1.FinalizerName(f))
+		}
+		if _, err := c.Kube.CoreV1().Namespaces().Finalize(ctx, ns, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to finalize namespace %s: %v", t.name, err)
+		}
+	}
+
+	finalizerMu.Lock()
+	finalizerCleared[t.kind] += len(removed)
+	finalizerMu.Unlock()
+	log.Printf("Removed finalizers %s from %s (terminating since %s)", strings.Join(removed, ", "), t, deleted.Format(time.RFC3339))
+	action.Explain.add("finalizers", traceInfo, "removed "+strings.Join(removed, ", "))
+	if len(kept) > 0 {
+		return fmt.Errorf("removed %s, but %s is still held by %s, which isn't in FINALIZER_ALLOWLIST", strings.Join(removed, ", "), t, strings.Join(kept, ", "))
+	}
+	return nil
+}
+
+// nonNil keeps an empty list an empty JSON array rather than null
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func writeFinalizerMetrics(w io.Writer) {
+	finalizerMu.Lock()
+	defer finalizerMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_finalizers_removed_total Finalizers clear_finalizers removed, by kind of object.")
+	fmt.Fprintln(w, "# TYPE selfhealing_finalizers_removed_total counter")
+	for _, kind := range sortedKeys(finalizerCleared) {
+		fmt.Fprintf(w, "selfhealing_finalizers_removed_total{kind=%q} %d\n", kind, finalizerCleared[kind])
+	}
+}
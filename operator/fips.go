@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+)
+
+// Crypto policy. CRYPTO_POLICY=fips makes the operator use FIPS 140
+// validated cryptography only, and refuse to start unless it can:
+//   - the binary must be a FIPS build (make build-operator-fips: Go's
+//     BoringCrypto module, GOEXPERIMENT=boringcrypto) with the module active,
+//     which also restricts TLS — webhooks, egress, NATS, the API server — to
+//     FIPS-approved versions, cipher suites and curves
+//   - signing and signature verification only accept approved algorithms:
+//     ECDSA P-256 audit and policy bundle keys (not Ed25519), SNS messages
+//     with SignatureVersion 2 (RSA with SHA-256, not SHA-1)
+//
+// The checks run before any of those features is set up, so a key or
+// setting that isn't allowed stops the operator (or --validate-config)
+// instead of running with it. HMACs (approval links, callbacks, AWS
+// signatures) are HMAC-SHA256 and allowed either way.
+//
+// The default policy uses the standard Go crypto; a FIPS build runs with
+// either policy, and says which in selfhealing_crypto_fips.
+
+const (
+	cryptoPolicyDefault = "default"
+	cryptoPolicyFIPS    = "fips"
+)
+
+var cryptoPolicy = envString("CRYPTO_POLICY", cryptoPolicyDefault)
+
+// fipsApproved are the signature algorithms allowed under CRYPTO_POLICY=fips
+var fipsApproved = []string{"ecdsa-p256-sha256", "rsa-pkcs1v15-sha256"}
+
+func init() {
+	registerMetrics(writeCryptoMetrics)
+}
+
+// setupCryptoPolicy checks that the crypto the policy requires is active
+func setupCryptoPolicy() error {
+	switch cryptoPolicy {
+	case cryptoPolicyDefault:
+		if fipsBuild {
+			log.Printf("FIPS build (BoringCrypto active: %t), running with CRYPTO_POLICY=%s", fipsActive(), cryptoPolicy)
+		}
+		return nil
+	case cryptoPolicyFIPS:
+	default:
+		return fmt.Errorf("invalid CRYPTO_POLICY %q (want %q or %q)", cryptoPolicy, cryptoPolicyDefault, cryptoPolicyFIPS)
+	}
+	if !fipsBuild {
+		return fmt.Errorf("CRYPTO_POLICY=fips needs a FIPS build of the operator (make build-operator-fips)")
+	}
+	if !fipsActive() {
+		return fmt.Errorf("CRYPTO_POLICY=fips: this is a FIPS build, but the BoringCrypto module isn't active on this platform")
+	}
+	log.Printf("Crypto policy: FIPS — BoringCrypto active, only %v signatures accepted", fipsApproved)
+	return nil
+}
+
+// cryptoAllowed refuses a signature algorithm the crypto policy doesn't allow for the feature
+func cryptoAllowed(feature, algorithm string) error {
+	if cryptoPolicy != cryptoPolicyFIPS || containsString(fipsApproved, algorithm) {
+		return nil
+	}
+	return fmt.Errorf("%s: %s isn't FIPS-approved and CRYPTO_POLICY=fips (allowed: %v)", feature, algorithm, fipsApproved)
+}
+
+func writeCryptoMetrics(w io.Writer) {
+	build := "standard"
+	if fipsBuild {
+		build = "boringcrypto"
+	}
+	active := 0
+	if fipsActive() {
+		active = 1
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_crypto_fips Whether FIPS-validated crypto (BoringCrypto) is active, with the build and CRYPTO_POLICY.")
+	fmt.Fprintln(w, "# TYPE selfhealing_crypto_fips gauge")
+	fmt.Fprintf(w, "selfhealing_crypto_fips{build=%q,policy=%q} %d\n", build, cryptoPolicy, active)
+}
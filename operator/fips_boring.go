@@ -0,0 +1,17 @@
+//go:build boringcrypto
+
+package main
+
+// FIPS builds link Go's BoringCrypto module; they need cgo:
+//   CGO_ENABLED=1 GOEXPERIMENT=boringcrypto go build
+// fipsonly restricts TLS to FIPS-approved settings whenever the module is active.
+
+import (
+	"crypto/boring"
+	_ "crypto/tls/fipsonly"
+)
+
+const fipsBuild = true
+
+// fipsActive reports whether crypto operations go through BoringCrypto
+func fipsActive() bool { return boring.Enabled() }
@@ -0,0 +1,8 @@
+//go:build !boringcrypto
+
+package main
+
+const fipsBuild = false
+
+// fipsActive reports whether crypto operations go through BoringCrypto
+func fipsActive() bool { return false }
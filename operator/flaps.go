@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Flapping alerts.
+//
+// Mixed batches: when an alert flaps faster than Alertmanager's group
+// interval, one notification can carry it both firing and resolved (or the
+// same alert twice). Each fingerprint is handled once per batch: the
+// notification with the latest timestamp (startsAt for firing, endsAt for
+// resolved) wins, and if the batch had it both firing and resolved, nothing
+// acts on it — acting on a stale half of it would just add to the churn.
+//
+// Flap detection: every fire/resolve cycle of a fingerprint is counted (a
+// mixed batch is one cycle). An alert that cycles more than FLAP_THRESHOLD
+// times within an hour gets a suppression for FLAP_SUPPRESS_FOR, visible and
+// removable like any other in /api/v1/suppressions, and a Slack message.
+// GET /api/v1/flapping lists the alerts that cycled in the last hour, so the
+// rule behind them can be fixed (usually a missing `for:` or a threshold
+// right at the normal value).
+
+var (
+	flapThreshold   = envInt("FLAP_THRESHOLD", 6)
+	flapSuppressFor = envDuration("FLAP_SUPPRESS_FOR", time.Hour)
+
+	flapMu         sync.Mutex
+	flapStates     = map[string]*flapState{} // fingerprint -> state
+	flapCounts     = map[string]int{}        // alertname -> mixed batches
+	flapSuppressed = map[string]int{}        // alertname -> auto-suppressions
+)
+
+const flapWindow = time.Hour
+
+// flapState is the fire/resolve history of one alert fingerprint
+type flapState struct {
+	Fingerprint   string            `json:"fingerprint"`
+	AlertName     string            `json:"alertname"`
+	Namespace     string            `json:"namespace"`
+	App           string            `json:"app,omitempty"`
+	Labels        map[string]string `json:"labels"`
+	Cycles        int               `json:"cycles"` // within the last hour
+	LastStatus    string            `json:"lastStatus"`
+	LastChange    time.Time         `json:"lastChange"`
+	SuppressionID int               `json:"suppressionId,omitempty"`
+
+	cycles          []time.Time
+	suppressedUntil time.Time
+}
+
+func init() {
+	registerMetrics(writeFlapMetrics)
+}
+
+// alertTime is when the alert last changed state
+func alertTime(a Alert) time.Time {
+	if a.Status == "resolved" && !a.EndsAt.IsZero() {
+		return a.EndsAt
+	}
+	return a.StartsAt
+}
+
+// collapseBatch keeps the latest notification per fingerprint and returns the
+// fingerprints that were both firing and resolved in the batch
+func collapseBatch(alerts []Alert) ([]Alert, map[string]bool) {
+	latest := map[string]int{}
+	statuses := map[string]string{}
+	mixed := map[string]bool{}
+	for i, a := range alerts {
+		fp := alertFingerprint(a)
+		if s, ok := statuses[fp]; ok && s != a.Status {
+			mixed[fp] = true
+		}
+		statuses[fp] = a.Status
+		if j, ok := latest[fp]; !ok || !alertTime(a).Before(alertTime(alerts[j])) {
+			latest[fp] = i
+		}
+	}
+	if len(latest) == len(alerts) {
+		return alerts, nil
+	}
+	out := make([]Alert, 0, len(latest))
+	for i, a := range alerts {
+		if latest[alertFingerprint(a)] == i {
+			out = append(out, a)
+		}
+	}
+	for fp := range mixed {
+		a := alerts[latest[fp]]
+		log.Printf("Alert %s (%s) is both firing and resolved in one batch — keeping the %s notification and not acting on it",
+			a.Labels["alertname"], fp, a.Status)
+		flapMu.Lock()
+		flapCounts[a.Labels["alertname"]]++
+		flapMu.Unlock()
+	}
+	return out, mixed
+}
+
+// observeFlaps counts the alert's fire/resolve cycles and suppresses it once
+// it flaps too often; mixed says the batch had it both firing and resolved
+func observeFlaps(alert Alert, mixed bool) {
+	if flapThreshold <= 0 {
+		return
+	}
+	fp := alertFingerprint(alert)
+	now := time.Now()
+
+	flapMu.Lock()
+	for key, s := range flapStates {
+		if now.Sub(s.LastChange) > flapWindow && now.After(s.suppressedUntil) {
+			delete(flapStates, key)
+		}
+	}
+	s := flapStates[fp]
+	if s == nil {
+		namespace := alert.Labels["namespace"]
+		if namespace == "" {
+			namespace = "default"
+		}
+		s = &flapState{
+			Fingerprint: fp,
+			AlertName:   alert.Labels["alertname"],
+			Namespace:   namespace,
+			App:         alert.Labels["app"],
+			Labels:      alert.Labels,
+		}
+		flapStates[fp] = s
+	}
+	if mixed || s.LastStatus == "resolved" && alert.Status == "firing" {
+		s.cycles = append(s.cycles, now)
+	}
+	if s.LastStatus != alert.Status || mixed {
+		s.LastChange = now
+	}
+	s.LastStatus = alert.Status
+	s.pruneLocked(now)
+	flapping := len(s.cycles) > flapThreshold && !now.Before(s.suppressedUntil)
+	if flapping {
+		s.suppressedUntil = now.Add(flapSuppressFor)
+	}
+	cycles := len(s.cycles)
+	flapMu.Unlock()
+	if !flapping {
+		return
+	}
+
+	sup, err := addSuppression(suppressionRequest{
+		AlertName: s.AlertName,
+		Namespace: s.Namespace,
+		App:       s.App,
+		Duration:  flapSuppressFor.String(),
+		Reason:    fmt.Sprintf("flapping: %d fire/resolve cycles in the last hour (fingerprint %s)", cycles, fp),
+	})
+	if err != nil {
+		log.Printf("Alert %s is flapping but couldn't be suppressed: %v", s.AlertName, err)
+		return
+	}
+	flapMu.Lock()
+	s.SuppressionID = sup.ID
+	flapSuppressed[s.AlertName]++
+	flapMu.Unlock()
+	notifySlack(renderMessage("alert-flapping", alertLocale(alert.Labels, alert.Annotations), map[string]interface{}{
+		"AlertName":     s.AlertName,
+		"Namespace":     s.Namespace,
+		"App":           s.App,
+		"Cycles":        cycles,
+		"For":           flapSuppressFor,
+		"SuppressionID": sup.ID,
+	}))
+}
+
+// pruneLocked drops cycles older than the window. Caller must hold flapMu.
+func (s *flapState) pruneLocked(now time.Time) {
+	i := 0
+	for i < len(s.cycles) && now.Sub(s.cycles[i]) > flapWindow {
+		i++
+	}
+	s.cycles = s.cycles[i:]
+	s.Cycles = len(s.cycles)
+}
+
+// listFlapping returns the alerts that cycled within the last hour, most cycles first
+func listFlapping() []flapState {
+	flapMu.Lock()
+	defer flapMu.Unlock()
+	now := time.Now()
+	var out []flapState
+	for _, s := range flapStates {
+		s.pruneLocked(now)
+		if s.Cycles > 0 {
+			out = append(out, *s)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Cycles != out[j].Cycles {
+			return out[i].Cycles > out[j].Cycles
+		}
+		return out[i].Fingerprint < out[j].Fingerprint
+	})
+	return out
+}
+
+// handleFlapping serves GET /api/v1/flapping
+func handleFlapping(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	out := listFlapping()
+	if out == nil {
+		out = []flapState{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func writeFlapMetrics(w io.Writer) {
+	flapMu.Lock()
+	defer flapMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_alert_flaps_total Notification batches that had an alert both firing and resolved, by alertname.")
+	fmt.Fprintln(w, "# TYPE selfhealing_alert_flaps_total counter")
+	for _, name := range sortedKeys(flapCounts) {
+		fmt.Fprintf(w, "selfhealing_alert_flaps_total{alertname=%q} %d\n", name, flapCounts[name])
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_flap_suppressions_total Suppressions added because an alert flapped more than FLAP_THRESHOLD times an hour, by alertname.")
+	fmt.Fprintln(w, "# TYPE selfhealing_flap_suppressions_total counter")
+	for _, name := range sortedKeys(flapSuppressed) {
+		fmt.Fprintf(w, "selfhealing_flap_suppressions_total{alertname=%q} %d\n", name, flapSuppressed[name])
+	}
+}
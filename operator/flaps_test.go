@@ -0,0 +1,166 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCollapseBatch(t *testing.T) {
+	flapMu.Lock()
+	saved := flapCounts
+	flapCounts = map[string]int{}
+	flapMu.Unlock()
+	defer func() {
+		flapMu.Lock()
+		flapCounts = saved
+		flapMu.Unlock()
+	}()
+
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	alert := func(name, status string, at time.Time) Alert {
+		a := Alert{Status: status, Labels: map[string]string{"alertname": name, "namespace": "shop"}, StartsAt: t0}
+		if status == "resolved" {
+			a.EndsAt = at
+		} else {
+			a.StartsAt = at
+		}
+		return a
+	}
+
+	tests := []struct {
+		name       string
+		batch      []Alert
+		wantStatus map[string]string // alertname -> status kept
+		wantMixed  []string
+	}{
+		{"distinct alerts pass through",
+			[]Alert{alert("A", "firing", t0), alert("B", "resolved", t0.Add(time.Minute))},
+			map[string]string{"A": "firing", "B": "resolved"}, nil},
+		{"same alert twice is handled once",
+			[]Alert{alert("A", "firing", t0), alert("A", "firing", t0)},
+			map[string]string{"A": "firing"}, nil},
+		{"resolved after firing: resolved wins and nothing acts",
+			[]Alert{alert("A", "firing", t0), alert("A", "resolved", t0.Add(time.Minute))},
+			map[string]string{"A": "resolved"}, []string{"A"}},
+		{"later firing wins over an earlier resolve",
+			[]Alert{alert("A", "resolved", t0.Add(time.Minute)), alert("A", "firing", t0.Add(2*time.Minute))},
+			map[string]string{"A": "firing"}, []string{"A"}},
+		{"only the mixed alert is marked",
+			[]Alert{alert("A", "firing", t0), alert("B", "firing", t0), alert("A", "resolved", t0.Add(time.Minute))},
+			map[string]string{"A": "resolved", "B": "firing"}, []string{"A"}},
+	}
+	for _, tt := range tests {
+		out, mixed := collapseBatch(tt.batch)
+		got := map[string]string{}
+		for _, a := range out {
+			if _, dup := got[a.Labels["alertname"]]; dup {
+				t.Errorf("%s: %s kept twice", tt.name, a.Labels["alertname"])
+			}
+			got[a.Labels["alertname"]] = a.Status
+		}
+		if len(got) != len(tt.wantStatus) {
+			t.Errorf("%s: kept %v, want %v", tt.name, got, tt.wantStatus)
+		}
+		for name, status := range tt.wantStatus {
+			if got[name] != status {
+				t.Errorf("%s: %s kept as %q, want %q", tt.name, name, got[name], status)
+			}
+		}
+		if len(mixed) != len(tt.wantMixed) {
+			t.Errorf("%s: %d mixed, want %v", tt.name, len(mixed), tt.wantMixed)
+		}
+		for _, a := range out {
+			want := false
+			for _, name := range tt.wantMixed {
+				want = want || a.Labels["alertname"] == name
+			}
+			if mixed[alertFingerprint(a)] != want {
+				t.Errorf("%s: %s mixed = %v, want %v", tt.name, a.Labels["alertname"], !want, want)
+			}
+		}
+	}
+	flapMu.Lock()
+	defer flapMu.Unlock()
+	if flapCounts["A"] != 3 || flapCounts["B"] != 0 {
+		t.Errorf("mixed batches counted = %v, want A three times", flapCounts)
+	}
+}
+
+func TestObserveFlapsSuppresses(t *testing.T) {
+	resetSuppressions(t)
+	flapMu.Lock()
+	savedStates, savedSuppressed := flapStates, flapSuppressed
+	flapStates, flapSuppressed = map[string]*flapState{}, map[string]int{}
+	flapMu.Unlock()
+	savedThreshold := flapThreshold
+	flapThreshold = 2
+	defer func() {
+		flapThreshold = savedThreshold
+		flapMu.Lock()
+		flapStates, flapSuppressed = savedStates, savedSuppressed
+		flapMu.Unlock()
+	}()
+
+	labels := map[string]string{"alertname": "Flappy", "namespace": "shop", "app": "cart"}
+	action := &RecoveryAction{AlertName: "Flappy", Namespace: "shop", App: "cart"}
+	tests := []struct {
+		name       string
+		status     string
+		mixed      bool
+		cycles     int
+		suppressed bool
+	}{
+		{"fires", "firing", false, 0, false},
+		{"resolves", "resolved", false, 0, false},
+		{"fires again: one cycle", "firing", false, 1, false},
+		{"repeated firing isn't a cycle", "firing", false, 1, false},
+		{"resolves", "resolved", false, 1, false},
+		{"mixed batch counts as a cycle", "resolved", true, 2, false},
+		{"fires again: over the threshold", "firing", false, 3, true},
+		{"resolves", "resolved", false, 3, true},
+		{"keeps flapping: suppressed once", "firing", false, 4, true},
+	}
+	for _, tt := range tests {
+		observeFlaps(Alert{Status: tt.status, Labels: labels}, tt.mixed)
+		var cycles int
+		if list := listFlapping(); len(list) == 1 {
+			cycles = list[0].Cycles
+		}
+		if cycles != tt.cycles {
+			t.Errorf("%s: %d cycles, want %d", tt.name, cycles, tt.cycles)
+		}
+		if got := activeSuppression(action) != nil; got != tt.suppressed {
+			t.Errorf("%s: suppressed = %v, want %v", tt.name, got, tt.suppressed)
+		}
+	}
+	if n := len(listSuppressions()); n != 1 {
+		t.Errorf("%d suppressions, want 1", n)
+	}
+	flapMu.Lock()
+	defer flapMu.Unlock()
+	if flapSuppressed["Flappy"] != 1 {
+		t.Errorf("auto-suppressions = %d, want 1", flapSuppressed["Flappy"])
+	}
+}
+
+func TestObserveFlapsOff(t *testing.T) {
+	flapMu.Lock()
+	saved := flapStates
+	flapStates = map[string]*flapState{}
+	flapMu.Unlock()
+	savedThreshold := flapThreshold
+	flapThreshold = 0
+	defer func() {
+		flapThreshold = savedThreshold
+		flapMu.Lock()
+		flapStates = saved
+		flapMu.Unlock()
+	}()
+
+	for _, status := range []string{"firing", "resolved", "firing"} {
+		observeFlaps(Alert{Status: status, Labels: map[string]string{"alertname": "Flappy"}}, true)
+	}
+	if list := listFlapping(); len(list) != 0 {
+		t.Errorf("flapping = %+v, want nothing tracked with FLAP_THRESHOLD=0", list)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Garbage collection of operator-created resources. Everything the operator
+// creates is labelled app.kubernetes.io/managed-by=self-healing-operator and
+// annotated with selfhealing.io/expires-at (RFC 3339); long-lived objects
+// push the expiry forward while they're in use. Every GC_INTERVAL the
+// collector deletes expired objects of the kinds in GC_KINDS, so a failed
+// workflow or a replica that went away doesn't leave them behind.
+//
+// Objects without the expiry annotation are never collected.
+
+const (
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "self-healing-operator"
+	expiresAtAnno  = "selfhealing.io/expires-at"
+)
+
+// gcKind lists and deletes one kind of object in all namespaces
+type gcKind struct {
+	list   func(ctx context.Context, cs kubernetes.Interface, opts metav1.ListOptions) ([]metav1.ObjectMeta, error)
+	delete func(ctx context.Context, cs kubernetes.Interface, namespace, name string) error
+}
+
+var gcKinds = map[string]gcKind{
+	"leases": {
+		list: func(ctx context.Context, cs kubernetes.Interface, opts metav1.ListOptions) ([]metav1.ObjectMeta, error) {
+			l, err := cs.CoordinationV1().Leases(metav1.NamespaceAll).List(ctx, opts)
+			if err != nil {
+				return nil, err
+			}
+			var out []metav1.ObjectMeta
+			for _, o := range l.Items {
+				out = append(out, o.ObjectMeta)
+			}
+			return out, nil
+		},
+		delete: func(ctx context.Context, cs kubernetes.Interface, namespace, name string) error {
+			return cs.CoordinationV1().Leases(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		},
+	},
+	"jobs": {
+		list: func(ctx context.Context, cs kubernetes.Interface, opts metav1.ListOptions) ([]metav1.ObjectMeta, error) {
+			l, err := cs.BatchV1().Jobs(metav1.NamespaceAll).List(ctx, opts)
+			if err != nil {
+				return nil, err
+			}
+			var out []metav1.ObjectMeta
+			for _, o := range l.Items {
+				out = append(out, o.ObjectMeta)
+			}
+			return out, nil
+		},
+		delete: func(ctx context.Context, cs kubernetes.Interface, namespace, name string) error {
+			// take the Job's pods with it
+			background := metav1.DeletePropagationBackground
+			return cs.BatchV1().Jobs(namespace).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &background})
+		},
+	},
+	"configmaps": {
+		list: func(ctx context.Context, cs kubernetes.Interface, opts metav1.ListOptions) ([]metav1.ObjectMeta, error) {
+			l, err := cs.CoreV1().ConfigMaps(metav1.NamespaceAll).List(ctx, opts)
+			if err != nil {
+				return nil, err
+			}
+			var out []metav1.ObjectMeta
+			for _, o := range l.Items {
+				out = append(out, o.ObjectMeta)
+			}
+			return out, nil
+		},
+		delete: func(ctx context.Context, cs kubernetes.Interface, namespace, name string) error {
+			return cs.CoreV1().ConfigMaps(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		},
+	},
+}
+
+var (
+	gcInterval = envDuration("GC_INTERVAL", 5*time.Minute)
+	gcKindList = envList("GC_KINDS")
+
+	gcMu      sync.Mutex
+	gcDeleted = map[string]int{} // kind -> objects deleted
+	gcErrors  = map[string]int{} // kind -> failed lists/deletes
+)
+
+func init() {
+	registerMetrics(writeGCMetrics)
+}
+
+// markOwned labels an object as the operator's and sets it to expire after ttl
+func markOwned(meta *metav1.ObjectMeta, ttl time.Duration) {
+	if meta.Labels == nil {
+		meta.Labels = map[string]string{}
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	meta.Labels[managedByLabel] = managedByValue
+	meta.Annotations[expiresAtAnno] = time.Now().Add(ttl).UTC().Format(time.RFC3339)
+}
+
+// startGC runs the collector every GC_INTERVAL; 0 disables it
+func startGC(kube kubernetes.Interface) {
+	if gcInterval <= 0 {
+		return
+	}
+	kinds := gcKindList
+	if len(kinds) == 0 {
+		kinds = sortedKeys(gcKinds)
+	}
+	for _, k := range kinds {
+		if _, ok := gcKinds[k]; !ok {
+			log.Fatalf("Invalid GC_KINDS entry %q (want one of %v)", k, sortedKeys(gcKinds))
+		}
+	}
+	log.Printf("Garbage collection of operator-created %v every %s", kinds, gcInterval)
+	go func() {
+		for range time.Tick(gcInterval) {
+			for _, k := range kinds {
+				collectGarbage(kube, k)
+			}
+		}
+	}()
+}
+
+func collectGarbage(kube kubernetes.Interface, kind string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	gk := gcKinds[kind]
+	objs, err := gk.list(ctx, kube, metav1.ListOptions{LabelSelector: managedByLabel + "=" + managedByValue})
+	if err != nil {
+		log.Printf("GC: failed to list %s: %v", kind, err)
+		gcMu.Lock()
+		gcErrors[kind]++
+		gcMu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	for _, o := range objs {
+		expires, err := time.Parse(time.RFC3339, o.Annotations[expiresAtAnno])
+		if err != nil || now.Before(expires) {
+			continue
+		}
+		err = gk.delete(ctx, kube, o.Namespace, o.Name)
+		gcMu.Lock()
+		if err != nil {
+			gcErrors[kind]++
+		} else {
+			gcDeleted[kind]++
+		}
+		gcMu.Unlock()
+		if err != nil {
+			log.Printf("GC: failed to delete %s %s/%s: %v", kind, o.Namespace, o.Name, err)
+			continue
+		}
+		log.Printf("GC: deleted %s %s/%s (expired %s)", kind, o.Namespace, o.Name, expires.Format(time.RFC3339))
+	}
+}
+
+func writeGCMetrics(w io.Writer) {
+	gcMu.Lock()
+	defer gcMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_gc_deleted_total Expired operator-created objects deleted, by kind.")
+	fmt.Fprintln(w, "# TYPE selfhealing_gc_deleted_total counter")
+	for _, kind := range sortedKeys(gcDeleted) {
+		fmt.Fprintf(w, "selfhealing_gc_deleted_total{kind=%q} %d\n", kind, gcDeleted[kind])
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_gc_errors_total Failed GC lists and deletes, by kind.")
+	fmt.Fprintln(w, "# TYPE selfhealing_gc_errors_total counter")
+	for _, kind := range sortedKeys(gcErrors) {
+		fmt.Fprintf(w, "selfhealing_gc_errors_total{kind=%q} %d\n", kind, gcErrors[kind])
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// grafanaClient writes annotations through the Grafana HTTP API
+// (POST /api/annotations). Enabled by GRAFANA_URL; GRAFANA_API_TOKEN is a
+// service account token with the annotations:write permission.
+type grafanaClient struct {
+	baseURL string
+	token   credential
+}
+
+func newGrafanaClient() *grafanaClient {
+	u := os.Getenv("GRAFANA_URL")
+	if u == "" {
+		return nil
+	}
+	return &grafanaClient{
+		baseURL: strings.TrimRight(u, "/"),
+		token:   credential("GRAFANA_API_TOKEN"),
+	}
+}
+
+func (g *grafanaClient) Name() string { return "grafana" }
+
+func (g *grafanaClient) annotate(ctx context.Context, timeMs int64, tags []string, text string) error {
+	body := map[string]interface{}{
+		"time": timeMs,
+		"tags": tags,
+		"text": text,
+	}
+	var headers map[string]string
+	if token := g.token.value(); token != "" {
+		headers = map[string]string{"Authorization": "Bearer " + token}
+	}
+	return postJSON(ctx, "grafana", g.baseURL+"/api/annotations", body, headers)
+}
+
+// Mark implements MarkerSink
+func (g *grafanaClient) Mark(ctx context.Context, m DeployMarker) error {
+	tags := []string{"self-healing", "deploy", m.Action, m.Namespace, m.Deployment}
+	return g.annotate(ctx, m.Time.UnixMilli(), tags, m.Message)
+}
+
+// grafanaRemediations is set when every remediation should be annotated
+var grafanaRemediations *grafanaClient
+
+func setupGrafanaAnnotations() {
+	grafanaRemediations = newGrafanaClient()
+	if grafanaRemediations == nil || !envBool("GRAFANA_ANNOTATE_REMEDIATIONS", true) {
+		return
+	}
+	onRemediation(annotateRemediation)
+	log.Printf("Grafana annotations for remediations enabled")
+}
+
+// annotateRemediation writes one annotation per executed remediation, tagged
+// so dashboards can filter by action, namespace and workload
+func annotateRemediation(rec ActionRecord) {
+	action := rec.Action
+	if rec.Name != "" {
+		action = rec.Action + ":" + rec.Name
+	}
+	tags := []string{
+		"self-healing",
+		"action:" + action,
+		"namespace:" + rec.Namespace,
+		"workload:" + rec.App,
+		"outcome:" + rec.Outcome,
+	}
+	text := fmt.Sprintf("Self-healing %s on %s/%s %s (alert %s)", action, rec.Namespace, rec.App, rec.Outcome, rec.AlertName)
+	if rec.Pod != "" {
+		text += "<br>pod: " + rec.Pod
+	}
+	if rec.Error != "" {
+		text += "<br>error: " + rec.Error
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := grafanaRemediations.annotate(ctx, rec.StartedAt.UnixMilli(), tags, text); err != nil {
+		log.Printf("Grafana annotation failed: %v", err)
+	}
+}
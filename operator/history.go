@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// History keeps the last HISTORY_SIZE executed remediations in memory.
+// Multi-step remediations (runbooks, workflows) are one record with their
+// steps attached, so a whole run can be read as a unit.
+
+// StepRecord is the result of one step of a multi-step remediation
+type StepRecord struct {
+	Name      string    `json:"name"`
+	Action    string    `json:"action"`
+	Status    string    `json:"status"` // succeeded, failed, skipped
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+	Duration  string    `json:"duration"`
+}
+
+// ActionRecord is one executed remediation
+type ActionRecord struct {
+	ID        int               `json:"id"`
+	StartedAt time.Time         `json:"startedAt"`
+	Duration  string            `json:"duration"`
+	AlertName string            `json:"alertname"`
+	Action    string            `json:"action"`
+	Name      string            `json:"name,omitempty"` // runbook or workflow name
+	Namespace string            `json:"namespace"`
+	App       string            `json:"app"`
+	Pod       string            `json:"pod,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"` // the policy's REMEDIATION_TAGS, see tags.go
+	Outcome   string            `json:"outcome"`        // succeeded, failed
+	Error     string            `json:"error,omitempty"`
+	Steps     []StepRecord      `json:"steps,omitempty"`
+	Explain   []TraceStep       `json:"explain,omitempty"` // how the action was decided, see explain.go
+	Context   *Enrichment       `json:"context,omitempty"` // live pod/node context, see enrich.go
+	TraceID   string            `json:"traceId,omitempty"` // see tracing.go
+	Changes   []ObjectChange    `json:"changes,omitempty"` // spec before/after, see snapshot.go
+
+	PrevDigest string          `json:"prevDigest,omitempty"` // audit chain, see audit.go
+	Signature  *AuditSignature `json:"signature,omitempty"`
+
+	// not stored or signed: only passed on to remediation hooks
+	callbackURL   string
+	alertLabels   map[string]string
+	alertStartsAt time.Time
+	parentSpan    string
+	journalID     uint64
+}
+
+// remediationHooks are called (in the background) with every finished record,
+// for integrations that report on remediations
+var remediationHooks []func(ActionRecord)
+
+func onRemediation(fn func(ActionRecord)) {
+	remediationHooks = append(remediationHooks, fn)
+}
+
+var (
+	historyMu     sync.Mutex
+	history       []ActionRecord
+	historySize   = envInt("HISTORY_SIZE", 500)
+	nextHistoryID = 1 // guarded by auditChainMu, which numbers and chains records
+)
+
+// newActionRecord starts a record for an action; finish it with recordHistory
+func newActionRecord(action *RecoveryAction, name string, started time.Time) ActionRecord {
+	return ActionRecord{
+		StartedAt: started,
+		AlertName: action.AlertName,
+		Action:    action.Action,
+		Name:      name,
+		Namespace: action.Namespace,
+		App:       action.App,
+		Pod:       action.Pod,
+		Tags:      remediationTags(action.Labels),
+		Explain:   action.Explain,
+		Context:   action.Enrichment,
+		TraceID:   action.TraceID,
+		Changes:   action.Changes,
+
+		callbackURL:   action.Callback,
+		alertLabels:   action.Labels,
+		alertStartsAt: action.StartsAt,
+		parentSpan:    action.parentSpan,
+		journalID:     action.journalID,
+	}
+}
+
+// recordHistory fills in the outcome and appends the record, dropping the oldest past HISTORY_SIZE
+func recordHistory(rec ActionRecord, err error) {
+	rec.Duration = time.Since(rec.StartedAt).Round(time.Millisecond).String()
+	rec.Outcome = "succeeded"
+	if err != nil {
+		rec.Outcome = "failed"
+		rec.Error = err.Error()
+	}
+	actionJournal.end(rec.journalID, rec.Outcome)
+
+	payload, chainErr := chainAuditRecord(&rec)
+	signAuditRecord(&rec, payload, chainErr)
+
+	historyMu.Lock()
+	// records signed at the same time can finish out of order; keep ID order
+	i := len(history)
+	for i > 0 && history[i-1].ID > rec.ID {
+		i--
+	}
+	history = append(history, ActionRecord{})
+	copy(history[i+1:], history[i:])
+	history[i] = rec
+	if len(history) > historySize {
+		history = history[len(history)-historySize:]
+	}
+	historyMu.Unlock()
+
+	persist("history", func(ctx context.Context) error { return store.AppendHistory(ctx, rec) })
+	for _, fn := range remediationHooks {
+		go fn(rec)
+	}
+}
+
+// listHistory returns up to limit records, newest first
+func listHistory(limit int) []ActionRecord {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	if limit <= 0 || limit > len(history) {
+		limit = len(history)
+	}
+	out := make([]ActionRecord, 0, limit)
+	for i := len(history) - 1; i >= 0 && len(out) < limit; i-- {
+		out = append(out, history[i])
+	}
+	return out
+}
+
+// findHistory returns the record with the given ID, if it's still kept
+func findHistory(id int) (ActionRecord, bool) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].ID == id {
+			return history[i], true
+		}
+	}
+	return ActionRecord{}, false
+}
+
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if id := r.URL.Query().Get("id"); id != "" {
+		n, _ := strconv.Atoi(id)
+		rec, ok := findHistory(n)
+		if !ok {
+			http.Error(w, "No such record", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rec)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		key, value, err := parseTagFilter(tag)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		out := []ActionRecord{}
+		for _, rec := range listHistory(0) {
+			if rec.Tags[key] == value && (limit <= 0 || len(out) < limit) {
+				out = append(out, rec)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listHistory(limit))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Idempotent deliveries: Alertmanager retries webhooks that time out, and an
+// Alertmanager HA pair sends every notification twice. Each alert of a
+// delivery gets a key built from the Alertmanager groupKey, the alert's
+// fingerprint (or a hash of its labels), its status and startsAt; a key seen
+// within IDEMPOTENCY_TTL is dropped before it reaches the decision checks.
+// A re-notification after repeat_interval is longer ago than the TTL and goes
+// through as usual (where the cooldown applies).
+//
+// Keys live in memory. With sharding, replicas forward the groupKey along
+// with the alerts, so duplicates arriving at different replicas are caught
+// by the namespace's owner.
+
+type deliveryDedup struct {
+	ttl time.Duration
+
+	mu         sync.Mutex
+	seen       map[string]time.Time
+	duplicates int
+}
+
+var deliveries = &deliveryDedup{
+	ttl:  envDuration("IDEMPOTENCY_TTL", 5*time.Minute),
+	seen: map[string]time.Time{},
+}
+
+func init() {
+	registerMetrics(deliveries.writeMetrics)
+}
+
+// alertFingerprint returns Alertmanager's fingerprint, or a hash of the labels
+func alertFingerprint(alert Alert) string {
+	if alert.Fingerprint != "" {
+		return alert.Fingerprint
+	}
+	names := make([]string, 0, len(alert.Labels))
+	for k := range alert.Labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	h := fnv.New64a()
+	for _, k := range names {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(alert.Labels[k]))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+func idempotencyKey(groupKey string, alert Alert) string {
+	return groupKey + "|" + alertFingerprint(alert) + "|" + alert.Status + "|" + alert.StartsAt.UTC().Format(time.RFC3339Nano)
+}
+
+// dedupe returns the alerts not delivered before within the TTL, and remembers them
+func (d *deliveryDedup) dedupe(groupKey string, alerts []Alert) []Alert {
+	if d.ttl <= 0 {
+		return alerts
+	}
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for k, at := range d.seen {
+		if now.Sub(at) >= d.ttl {
+			delete(d.seen, k)
+		}
+	}
+	out := alerts[:0:0]
+	for _, alert := range alerts {
+		// every Watchdog heartbeat has the same startsAt, and each one counts
+		if alert.Labels["alertname"] == watchdog.alertName {
+			out = append(out, alert)
+			continue
+		}
+		key := idempotencyKey(groupKey, alert)
+		if _, ok := d.seen[key]; ok {
+			d.duplicates++
+			continue
+		}
+		d.seen[key] = now
+		out = append(out, alert)
+	}
+	return out
+}
+
+func (d *deliveryDedup) writeMetrics(w io.Writer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_duplicate_alerts_total Alerts dropped because the same delivery was already received.")
+	fmt.Fprintln(w, "# TYPE selfhealing_duplicate_alerts_total counter")
+	fmt.Fprintf(w, "selfhealing_duplicate_alerts_total %d\n", d.duplicates)
+}
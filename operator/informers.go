@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+)
+
+// Informer cache: Deployments, Pods and Namespaces are watched once at startup
+// so target lookups read from memory instead of issuing a List per action,
+// which is what hammers the API server during alert storms. Writes still go to the API
+// server. Set INFORMER_CACHE=false to fall back to direct List calls.
+
+var (
+	deploymentLister appslisters.DeploymentLister
+	podLister        corelisters.PodLister
+	namespaceLister  corelisters.NamespaceLister
+)
+
+// startInformers starts the shared informers and waits for the initial sync
+func startInformers(cs kubernetes.Interface, stop <-chan struct{}) error {
+	factory := informers.NewSharedInformerFactory(cs, envDuration("INFORMER_RESYNC", 10*time.Minute))
+	deployments := factory.Apps().V1().Deployments()
+	pods := factory.Core().V1().Pods()
+	namespaces := factory.Core().V1().Namespaces()
+
+	// Touch the informers so the factory knows to start them
+	depInformer := deployments.Informer()
+	podInformer := pods.Informer()
+	nsInformer := namespaces.Informer()
+	watchStuckRollouts(depInformer)
+
+	factory.Start(stop)
+	log.Println("Waiting for informer caches to sync...")
+	if !cache.WaitForCacheSync(stop, depInformer.HasSynced, podInformer.HasSynced, nsInformer.HasSynced) {
+		return fmt.Errorf("informer caches did not sync")
+	}
+
+	deploymentLister = deployments.Lister()
+	podLister = pods.Lister()
+	namespaceLister = namespaces.Lister()
+	log.Println("Informer caches synced")
+	return nil
+}
+
+// findDeployment returns a copy of the first (by name) deployment labelled
+// app=<app> in the namespace, from the cache when available.
+func findDeployment(ctx context.Context, cs kubernetes.Interface, namespace, app string) (*appsv1.Deployment, error) {
+	if deploymentLister != nil {
+		selector := labels.SelectorFromSet(labels.Set{"app": app})
+		cached, err := deploymentLister.Deployments(namespace).List(selector)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list cached deployments: %v", err)
+		}
+		if len(cached) == 0 {
+			return nil, fmt.Errorf("no deployment with label app=%s in namespace %s", app, namespace)
+		}
+		sort.Slice(cached, func(i, j int) bool { return cached[i].Name < cached[j].Name })
+		// Cached objects are shared; callers mutate the result
+		return cached[0].DeepCopy(), nil
+	}
+
+	deployments, err := cs.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "app=" + app,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments: %v", err)
+	}
+	if len(deployments.Items) == 0 {
+		return nil, fmt.Errorf("no deployment with label app=%s in namespace %s", app, namespace)
+	}
+	items := deployments.Items
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return &items[0], nil
+}
+
+// updateDeployment applies change to dep and writes it. dep may come from the
+// cache and be behind the API server, so on a conflict the change is applied
+// again to a fresh copy. It returns the deployment as it was before the change
+// that went through, for recordChange; errors from change are returned as is.
+func updateDeployment(ctx context.Context, cs kubernetes.Interface, dep *appsv1.Deployment, change func(*appsv1.Deployment) error) (before, updated *appsv1.Deployment, err error) {
+	deployments := cs.AppsV1().Deployments(dep.Namespace)
+	current := dep
+	var changeErr error
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if current == nil {
+			fresh, err := deployments.Get(ctx, dep.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			current = fresh
+		}
+		before = current.DeepCopy()
+		if changeErr = change(current); changeErr != nil {
+			return changeErr
+		}
+		var err error
+		if updated, err = deployments.Update(ctx, current, metav1.UpdateOptions{}); err != nil {
+			current = nil
+		}
+		return err
+	})
+	if err != nil && changeErr == nil {
+		return nil, nil, fmt.Errorf("failed to update deployment %s/%s: %v", dep.Namespace, dep.Name, err)
+	}
+	return before, updated, err
+}
+
+// getNamespace returns the namespace, from the cache when available
+func getNamespace(ctx context.Context, cs kubernetes.Interface, name string) (*corev1.Namespace, error) {
+	if namespaceLister != nil {
+		return namespaceLister.Get(name)
+	}
+	return cs.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+}
+
+// cachedPod returns the pod from the cache, or nil if the cache is disabled
+// or hasn't seen it.
+func cachedPod(namespace, name string) *corev1.Pod {
+	if podLister == nil {
+		return nil
+	}
+	pod, err := podLister.Pods(namespace).Get(name)
+	if err != nil {
+		return nil
+	}
+	return pod
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestFindDeploymentPicksFirstByName(t *testing.T) {
+	a, b := testDeployment("shop", "cart", 1), testDeployment("shop", "cart", 1)
+	a.Name, b.Name = "cart-a", "cart-b"
+	kube := fake.NewSimpleClientset()
+	// the API server doesn't promise an order
+	kube.PrependReactor("list", "deployments", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &appsv1.DeploymentList{Items: []appsv1.Deployment{*b, *a}}, nil
+	})
+
+	dep, err := findDeployment(context.Background(), kube, "shop", "cart")
+	if err != nil {
+		t.Fatalf("findDeployment: %v", err)
+	}
+	if dep.Name != "cart-a" {
+		t.Errorf("picked %s, want cart-a", dep.Name)
+	}
+}
+
+func TestUpdateDeploymentRetriesConflict(t *testing.T) {
+	ctx := context.Background()
+	kube := fake.NewSimpleClientset(testDeployment("shop", "cart", 1))
+	stale, _ := kube.AppsV1().Deployments("shop").Get(ctx, "cart", metav1.GetOptions{})
+	stale.ResourceVersion = "1"
+
+	// the first write finds the cached copy behind
+	conflicts := 0
+	kube.PrependReactor("update", "deployments", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts == 0 {
+			conflicts++
+			return true, nil, apierrors.NewConflict(schema.GroupResource{Group: "apps", Resource: "deployments"}, "cart", nil)
+		}
+		return false, nil, nil
+	})
+
+	changes := 0
+	_, updated, err := updateDeployment(ctx, kube, stale, func(dep *appsv1.Deployment) error {
+		changes++
+		dep.Spec.Template.Annotations = map[string]string{"bumped": "yes"}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("updateDeployment: %v", err)
+	}
+	if changes != 2 {
+		t.Errorf("change applied %d times, want 2 (stale copy, then a fresh one)", changes)
+	}
+	if updated.Spec.Template.Annotations["bumped"] != "yes" {
+		t.Errorf("update lost the change: %v", updated.Spec.Template.Annotations)
+	}
+	gets := 0
+	for _, a := range kube.Actions() {
+		if a.GetVerb() == "get" {
+			gets++
+		}
+	}
+	if gets != 2 {
+		t.Errorf("%d gets, want the test's and one fresh get for the retry", gets)
+	}
+}
@@ -0,0 +1,156 @@
+package testing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"time"
+)
+
+// Payload is the Alertmanager webhook body (version 4), with all fields
+// Alertmanager sends so handlers are exercised with realistic input
+type Payload struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []Alert           `json:"alerts"`
+}
+
+// Alert is one alert in a Payload
+type Alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// AlertBuilder builds an Alert; every method returns the builder for chaining
+type AlertBuilder struct {
+	alert Alert
+}
+
+// NewAlert starts a firing alert with the given alertname, started now
+func NewAlert(name string) *AlertBuilder {
+	return &AlertBuilder{alert: Alert{
+		Status:       "firing",
+		Labels:       map[string]string{"alertname": name},
+		Annotations:  map[string]string{},
+		StartsAt:     time.Now(),
+		GeneratorURL: "http://prometheus.monitoring:9090/graph",
+	}}
+}
+
+// Label sets a label
+func (b *AlertBuilder) Label(key, value string) *AlertBuilder {
+	b.alert.Labels[key] = value
+	return b
+}
+
+// Annotation sets an annotation
+func (b *AlertBuilder) Annotation(key, value string) *AlertBuilder {
+	b.alert.Annotations[key] = value
+	return b
+}
+
+// Action sets the recovery_action label
+func (b *AlertBuilder) Action(action string) *AlertBuilder {
+	return b.Label("recovery_action", action)
+}
+
+// Severity sets the severity label
+func (b *AlertBuilder) Severity(severity string) *AlertBuilder {
+	return b.Label("severity", severity)
+}
+
+// Target points the alert at a workload; pod may be empty
+func (b *AlertBuilder) Target(namespace, app, pod string) *AlertBuilder {
+	b.Label("namespace", namespace).Label("app", app)
+	if pod != "" {
+		b.Label("pod", pod)
+	}
+	return b
+}
+
+// StartedAt overrides when the alert started firing
+func (b *AlertBuilder) StartedAt(t time.Time) *AlertBuilder {
+	b.alert.StartsAt = t
+	return b
+}
+
+// Resolved marks the alert resolved now
+func (b *AlertBuilder) Resolved() *AlertBuilder {
+	b.alert.Status = "resolved"
+	b.alert.EndsAt = time.Now()
+	return b
+}
+
+// Build returns the alert with its fingerprint computed from the labels
+func (b *AlertBuilder) Build() Alert {
+	a := b.alert
+	a.Labels = copyMap(a.Labels)
+	a.Annotations = copyMap(a.Annotations)
+	a.Fingerprint = fingerprint(a.Labels)
+	return a
+}
+
+// NewPayload groups alerts into one webhook payload the way Alertmanager
+// would for a route grouped by alertname
+func NewPayload(alerts ...*AlertBuilder) Payload {
+	p := Payload{
+		Version:           "4",
+		Status:            "resolved",
+		Receiver:          "self-healing-webhook",
+		GroupLabels:       map[string]string{},
+		CommonAnnotations: map[string]string{},
+		ExternalURL:       "http://alertmanager.monitoring:9093",
+	}
+	for i, b := range alerts {
+		a := b.Build()
+		if a.Status == "firing" {
+			p.Status = "firing"
+		}
+		if i == 0 {
+			p.CommonLabels = copyMap(a.Labels)
+			p.GroupLabels["alertname"] = a.Labels["alertname"]
+		} else {
+			for k, v := range p.CommonLabels {
+				if a.Labels[k] != v {
+					delete(p.CommonLabels, k)
+				}
+			}
+		}
+		p.Alerts = append(p.Alerts, a)
+	}
+	p.GroupKey = "{}:{alertname=\"" + p.GroupLabels["alertname"] + "\"}"
+	return p
+}
+
+func fingerprint(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k + "\xff" + labels[k] + "\xff"))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func copyMap(m map[string]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
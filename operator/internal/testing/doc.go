@@ -0,0 +1,18 @@
+// Package testing is a harness for integration tests of the operator that
+// need a Kubernetes API but not a real cluster.
+//
+// Env starts a throwaway etcd and kube-apiserver with controller-runtime's
+// envtest (install the binaries with `setup-envtest use 1.28 -p path` and
+// point KUBEBUILDER_ASSETS at the result), optionally with the operator's
+// CRDs installed. There are no controllers, so a Deployment never gets pods on
+// its own — create them with the fixture helpers.
+//
+// Alerts are built with NewAlert and wrapped in an Alertmanager webhook payload
+// with NewPayload; Sender delivers payloads the way Alertmanager does,
+// retrying on 429 and 5xx and honouring Retry-After, so tests can cover the
+// operator shedding load and retries.
+//
+// TestWebhookRestartsPod in the operator package (webhook_test.go) is a
+// complete example: it delivers a restart alert to handleWebhook and waits
+// for the pod to be deleted.
+package testing
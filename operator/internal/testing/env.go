@@ -0,0 +1,51 @@
+package testing
+
+import (
+	"errors"
+	"os"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// ErrNoAssets is returned by Start when KUBEBUILDER_ASSETS is not set;
+// tests usually skip on it
+var ErrNoAssets = errors.New("KUBEBUILDER_ASSETS is not set; install etcd and kube-apiserver with setup-envtest")
+
+// Env is a running envtest control plane (etcd + kube-apiserver)
+type Env struct {
+	Config    *rest.Config
+	Clientset *kubernetes.Clientset
+
+	env *envtest.Environment
+}
+
+// Start launches the control plane, installs the CRDs found at crdPaths
+// (files or directories, e.g. manifests/operator) and waits until they're
+// served
+func Start(crdPaths ...string) (*Env, error) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		return nil, ErrNoAssets
+	}
+	e := &Env{env: &envtest.Environment{
+		CRDDirectoryPaths:     crdPaths,
+		ErrorIfCRDPathMissing: true,
+	}}
+	cfg, err := e.env.Start()
+	if err != nil {
+		return nil, err
+	}
+	cfg.QPS, cfg.Burst = 100, 200
+	e.Config = cfg
+	if e.Clientset, err = kubernetes.NewForConfig(cfg); err != nil {
+		e.Stop()
+		return nil, err
+	}
+	return e, nil
+}
+
+// Stop shuts the control plane down and removes its data
+func (e *Env) Stop() {
+	e.env.Stop()
+}
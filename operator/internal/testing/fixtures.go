@@ -0,0 +1,82 @@
+package testing
+
+import (
+	"context"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CreateNamespace creates a namespace, ignoring one that already exists
+func (e *Env) CreateNamespace(ctx context.Context, name string) error {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	_, err := e.Clientset.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
+
+// CreateDeployment creates a Deployment selecting app=<app>, the label the
+// operator resolves targets by
+func (e *Env) CreateDeployment(ctx context.Context, namespace, app string, replicas int32) (*appsv1.Deployment, error) {
+	if err := e.CreateNamespace(ctx, namespace); err != nil {
+		return nil, err
+	}
+	labels := map[string]string{"app": app}
+	d := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: app, Namespace: namespace, Labels: labels},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       podSpec(),
+			},
+		},
+	}
+	return e.Clientset.AppsV1().Deployments(namespace).Create(ctx, d, metav1.CreateOptions{})
+}
+
+// CreatePod creates a pod directly, standing in for one a ReplicaSet would
+// have created
+func (e *Env) CreatePod(ctx context.Context, namespace, name string, labels map[string]string) (*corev1.Pod, error) {
+	if err := e.CreateNamespace(ctx, namespace); err != nil {
+		return nil, err
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Spec:       podSpec(),
+	}
+	return e.Clientset.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{})
+}
+
+func podSpec() corev1.PodSpec {
+	return corev1.PodSpec{
+		Containers: []corev1.Container{{Name: "app", Image: "registry.k8s.io/pause:3.9"}},
+	}
+}
+
+// Eventually polls cond every interval until it returns true, returns an
+// error, or ctx is done
+func Eventually(ctx context.Context, interval time.Duration, cond func() (bool, error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ok, err := cond()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
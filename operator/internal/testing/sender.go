@@ -0,0 +1,138 @@
+package testing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Sender posts payloads to a webhook URL like Alertmanager's notifier:
+// 429 and 5xx responses are retried with exponential backoff (or after the
+// server's Retry-After), other 4xx responses fail immediately.
+type Sender struct {
+	URL         string
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	Client      *http.Client
+
+	mu       sync.Mutex
+	attempts []Attempt
+}
+
+// Attempt is one delivery attempt, recorded for assertions
+type Attempt struct {
+	Time       time.Time
+	StatusCode int // 0 when the request itself failed
+	Err        error
+}
+
+// NewSender returns a Sender with short backoffs suited to tests
+func NewSender(url string) *Sender {
+	return &Sender{
+		URL:         url,
+		MaxAttempts: 5,
+		MinBackoff:  50 * time.Millisecond,
+		MaxBackoff:  2 * time.Second,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Send delivers the payload and returns the final status code
+func (s *Sender) Send(ctx context.Context, p Payload) (int, error) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return 0, err
+	}
+	backoff := s.MinBackoff
+	var lastErr error
+	for attempt := 1; attempt <= s.MaxAttempts; attempt++ {
+		status, retryAfter, err := s.post(ctx, body)
+		s.record(Attempt{Time: time.Now(), StatusCode: status, Err: err})
+		switch {
+		case err == nil && status < 300:
+			return status, nil
+		case err == nil && status != http.StatusTooManyRequests && status < 500:
+			return status, fmt.Errorf("webhook returned %d", status)
+		case err != nil:
+			lastErr = err
+		default:
+			lastErr = fmt.Errorf("webhook returned %d", status)
+		}
+
+		wait := backoff
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		if wait > s.MaxBackoff {
+			wait = s.MaxBackoff
+		}
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+	return 0, fmt.Errorf("giving up after %d attempts: %v", s.MaxAttempts, lastErr)
+}
+
+func (s *Sender) post(ctx context.Context, body []byte) (int, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Alertmanager/harness")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	resp.Body.Close()
+	var retryAfter time.Duration
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		retryAfter = time.Duration(secs) * time.Second
+	}
+	return resp.StatusCode, retryAfter, nil
+}
+
+func (s *Sender) record(a Attempt) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts = append(s.attempts, a)
+}
+
+// Attempts returns every delivery attempt so far
+func (s *Sender) Attempts() []Attempt {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Attempt(nil), s.attempts...)
+}
+
+// Flood sends n payloads from the given number of concurrent workers and
+// returns the final status code of each, to check behaviour under load
+func (s *Sender) Flood(ctx context.Context, n, workers int, payload func(i int) Payload) []int {
+	statuses := make([]int, n)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				statuses[i], _ = s.Send(ctx, payload(i))
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return statuses
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The interruption agent: `self-healing-operator interruption-agent` runs on
+// each spot node (manifests/operator/interruption-agent.yaml, a DaemonSet
+// with hostNetwork so the metadata service answers) and watches the
+// instance metadata for notices:
+//   - EC2 (IMDSv2): spot/instance-action, the two-minute interruption
+//     warning, and events/recommendations/rebalance
+//   - GCE: instance/preempted, the 30-second preemption notice
+//
+// It POSTs each notice once to the operator's /webhook/interruption (see
+// interruptions.go), retrying until the operator takes it. NODE_NAME (from
+// the downward API) names the node; the provider is detected unless -provider
+// says which.
+
+const (
+	ec2MetadataURL = "http://169.254.169.254/latest"
+	gceMetadataURL = "http://metadata.google.internal/computeMetadata/v1"
+)
+
+// metadataClient talks to the link-local metadata services, never through a proxy
+var metadataClient = &http.Client{Timeout: 2 * time.Second, Transport: &http.Transport{Proxy: nil}}
+
+func runInterruptionAgent(args []string) int {
+	fs := flag.NewFlagSet("interruption-agent", flag.ContinueOnError)
+	provider := fs.String("provider", "auto", "aws, gcp or auto")
+	operatorURL := fs.String("url", envString("INTERRUPTION_OPERATOR_URL", "http://self-healing-operator.default.svc:8080/webhook/interruption"), "the operator's interruption webhook")
+	interval := fs.Duration("interval", 5*time.Second, "how often to poll the metadata service")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	node := os.Getenv("NODE_NAME")
+	if node == "" {
+		fmt.Fprintln(os.Stderr, "NODE_NAME is required (set it from spec.nodeName)")
+		return 2
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if *provider == "auto" {
+		*provider = detectProvider(ctx)
+		if *provider == "" {
+			fmt.Fprintln(os.Stderr, "no EC2 or GCE metadata service found; set -provider")
+			return 1
+		}
+	}
+	var poll func(context.Context) []interruptionNotice
+	switch *provider {
+	case "aws":
+		poll = pollEC2Metadata
+	case "gcp":
+		poll = pollGCEMetadata
+	default:
+		fmt.Fprintf(os.Stderr, "unknown provider %q\n", *provider)
+		return 2
+	}
+	log.Printf("Interruption agent for node %s (%s) — reporting to %s", node, *provider, *operatorURL)
+
+	sent := map[string]bool{} // kind -> reported
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		for _, n := range poll(ctx) {
+			if sent[n.Kind] {
+				continue
+			}
+			n.Node, n.Provider = node, *provider
+			if err := reportInterruption(ctx, *operatorURL, n); err != nil {
+				log.Printf("Failed to report the %s notice, retrying: %v", n.Kind, err)
+				continue
+			}
+			sent[n.Kind] = true
+			log.Printf("Reported %s notice for node %s (deadline %s)", n.Kind, node, n.Deadline.Format(time.RFC3339))
+		}
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-ticker.C:
+		}
+	}
+}
+
+// detectProvider finds out which metadata service answers
+func detectProvider(ctx context.Context) string {
+	if _, err := ec2Token(ctx); err == nil {
+		return "aws"
+	}
+	if _, err := metadataGet(ctx, gceMetadataURL+"/instance/id", map[string]string{"Metadata-Flavor": "Google"}); err == nil {
+		return "gcp"
+	}
+	return ""
+}
+
+// metadataGet returns the body of a metadata path, "" for a 404
+func metadataGet(ctx context.Context, url string, headers map[string]string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	switch {
+	case err != nil:
+		return "", err
+	case resp.StatusCode == http.StatusNotFound:
+		return "", nil
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// ec2Token gets an IMDSv2 session token
+func ec2Token(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, ec2MetadataURL+"/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("IMDS token request returned %s", resp.Status)
+	}
+	token, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return string(token), err
+}
+
+func pollEC2Metadata(ctx context.Context) []interruptionNotice {
+	token, err := ec2Token(ctx)
+	if err != nil {
+		log.Printf("EC2 metadata unavailable: %v", err)
+		return nil
+	}
+	headers := map[string]string{"X-aws-ec2-metadata-token": token}
+	instance, _ := metadataGet(ctx, ec2MetadataURL+"/meta-data/instance-id", headers)
+	var out []interruptionNotice
+	// {"action": "terminate", "time": "2017-09-18T08:22:00Z"}
+	if body, err := metadataGet(ctx, ec2MetadataURL+"/meta-data/spot/instance-action", headers); err == nil && body != "" {
+		var ia struct {
+			Action string    `json:"action"`
+			Time   time.Time `json:"time"`
+		}
+		if err := json.Unmarshal([]byte(body), &ia); err == nil {
+			out = append(out, interruptionNotice{Instance: instance, Kind: interruptionSpot, Action: ia.Action,
+				Time: ia.Time.Add(-noticeWindows[interruptionSpot]), Deadline: ia.Time})
+		}
+	}
+	// {"noticeTime": "2020-11-05T08:22:00Z"}
+	if body, err := metadataGet(ctx, ec2MetadataURL+"/meta-data/events/recommendations/rebalance", headers); err == nil && body != "" {
+		var rb struct {
+			NoticeTime time.Time `json:"noticeTime"`
+		}
+		if err := json.Unmarshal([]byte(body), &rb); err == nil {
+			out = append(out, interruptionNotice{Instance: instance, Kind: interruptionRebalance, Time: rb.NoticeTime})
+		}
+	}
+	return out
+}
+
+func pollGCEMetadata(ctx context.Context) []interruptionNotice {
+	headers := map[string]string{"Metadata-Flavor": "Google"}
+	preempted, err := metadataGet(ctx, gceMetadataURL+"/instance/preempted", headers)
+	if err != nil {
+		log.Printf("GCE metadata unavailable: %v", err)
+		return nil
+	}
+	if preempted != "TRUE" {
+		return nil
+	}
+	instance, _ := metadataGet(ctx, gceMetadataURL+"/instance/name", headers)
+	now := time.Now().Truncate(time.Second)
+	return []interruptionNotice{{Instance: instance, Kind: interruptionPreempt, Time: now, Deadline: now.Add(noticeWindows[interruptionPreempt])}}
+}
+
+// reportInterruption POSTs a notice to the operator
+func reportInterruption(ctx context.Context, url string, n interruptionNotice) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv("INTERRUPTION_WEBHOOK_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("operator returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Spot and preemptible node interruptions. The cloud warns before it takes a
+// spot node back — two minutes for an EC2 Spot interruption, 30 seconds for
+// a GCP preemption — so instead of waiting for the node to go NotReady
+// (node-not-ready), the operator drains it while it still runs. Notices come
+// from
+//   - SQS: with INTERRUPTION_SQS_QUEUE_URL set, the operator long-polls a
+//     queue that an EventBridge rule sends "EC2 Spot Instance Interruption
+//     Warning" and "EC2 Instance Rebalance Recommendation" events to, signed
+//     with the AWS credentials of aws-sm secrets (see secrets_aws.go). The
+//     instance is matched to its node by the node's providerID.
+//   - the instance metadata: `self-healing-operator interruption-agent` runs
+//     as a DaemonSet on spot nodes, polls the EC2 or GCE metadata service and
+//     POSTs the notice to /webhook/interruption (INTERRUPTION_WEBHOOK_TOKEN)
+//
+// A notice becomes a NodeInterruption alert for the node, with the
+// node-interruption runbook, so suppressions, action flags, safe mode and the
+// history apply as to any alert:
+//   - cordon the node and mark it interrupted (selfhealing.io/interrupted-at),
+//     so node-not-ready leaves it alone when it goes away
+//   - evict its pods through the eviction API, which honours
+//     PodDisruptionBudgets, with grace periods cut to what's left before the
+//     deadline
+//   - retry evictions blocked by a PodDisruptionBudget until the deadline;
+//     with INTERRUPTION_PDB_OVERRIDE=true the pods still there
+//     INTERRUPTION_FORCE_BEFORE before it are deleted, since the node takes
+//     them down anyway. Pods left at the deadline fail the runbook.
+//
+// A rebalance recommendation (the instance is at elevated risk, with no
+// deadline yet) only cordons the node; INTERRUPTION_REBALANCE=drain drains
+// it too and ignore drops it.
+
+const (
+	interruptionAlertName  = "NodeInterruption"
+	interruptionAnnotation = "selfhealing.io/interrupted-at"
+
+	interruptionSpot      = "spot-interruption"
+	interruptionRebalance = "rebalance"
+	interruptionPreempt   = "preemption"
+)
+
+var (
+	interruptionQueueURL      = envString("INTERRUPTION_SQS_QUEUE_URL", "")
+	interruptionRebalanceMode = envString("INTERRUPTION_REBALANCE", "cordon") // cordon, drain, ignore
+	interruptionPDBOverride   = envBool("INTERRUPTION_PDB_OVERRIDE", false)
+	interruptionForceBefore   = envDuration("INTERRUPTION_FORCE_BEFORE", 20*time.Second)
+	interruptionWebhookToken  = credential("INTERRUPTION_WEBHOOK_TOKEN")
+
+	interruptionAWS = &awsSecrets{}
+
+	interruptionMu      sync.Mutex
+	interruptionNotices = map[string]int{} // source|kind|result
+)
+
+// interruptionNotice is one warning that a node is going away, as POSTed by
+// the interruption agent
+type interruptionNotice struct {
+	Node     string    `json:"node,omitempty"`
+	Instance string    `json:"instance,omitempty"`
+	Provider string    `json:"provider"` // aws, gcp
+	Kind     string    `json:"kind"`     // spot-interruption, rebalance, preemption
+	Action   string    `json:"action,omitempty"`
+	Time     time.Time `json:"time"`               // when the notice was given
+	Deadline time.Time `json:"deadline,omitempty"` // when the node goes away
+}
+
+// noticeWindows is how long each kind of notice gives, when it doesn't say
+var noticeWindows = map[string]time.Duration{
+	interruptionSpot:    2 * time.Minute,
+	interruptionPreempt: 30 * time.Second,
+}
+
+// interruptionAlert turns a notice into the alert for its node; nil if it's ignored
+func interruptionAlert(n interruptionNotice) *Alert {
+	if n.Kind == interruptionRebalance && interruptionRebalanceMode == "ignore" {
+		return nil
+	}
+	if n.Time.IsZero() {
+		n.Time = time.Now()
+	}
+	if n.Deadline.IsZero() && noticeWindows[n.Kind] > 0 {
+		n.Deadline = n.Time.Add(noticeWindows[n.Kind])
+	}
+	severity := "critical"
+	if n.Kind == interruptionRebalance {
+		severity = "warning"
+	}
+	alert := &Alert{
+		Labels: map[string]string{
+			"alertname":         interruptionAlertName,
+			"node":              n.Node,
+			"app":               n.Node, // one workload per node, for locks and cooldowns
+			"instance":          n.Instance,
+			"provider":          n.Provider,
+			"interruption_kind": n.Kind,
+			"severity":          severity,
+			"recovery_action":   "runbook",
+			"runbook":           "node-interruption",
+		},
+		Annotations: map[string]string{
+			"summary": fmt.Sprintf("%s %s notice for node %s", n.Provider, n.Kind, n.Node),
+		},
+		Status:      "firing",
+		StartsAt:    n.Time,
+		Fingerprint: "interruption|" + n.Provider + "|" + n.Node + "|" + n.Kind,
+	}
+	if n.Action != "" {
+		alert.Annotations["instance_action"] = n.Action
+	}
+	if !n.Deadline.IsZero() {
+		alert.Annotations["deadline"] = n.Deadline.UTC().Format(time.RFC3339)
+		alert.Annotations["summary"] += " (terminates at " + n.Deadline.UTC().Format(time.RFC3339) + ")"
+	}
+	return alert
+}
+
+// handleInterruptionNotice takes notices from the interruption agent
+func handleInterruptionNotice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := checkAdapterToken(r, interruptionWebhookToken.value()); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var n interruptionNotice
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&n); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if n.Node == "" || noticeWindows[n.Kind] == 0 && n.Kind != interruptionRebalance {
+		http.Error(w, "node and a kind (spot-interruption, rebalance or preemption) are required", http.StatusBadRequest)
+		return
+	}
+	acceptInterruption(r.Context(), "agent", n)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+// acceptInterruption feeds a notice through the alert pipeline
+func acceptInterruption(ctx context.Context, source string, n interruptionNotice) {
+	alert := interruptionAlert(n)
+	if alert == nil {
+		countInterruption(source, n.Kind, "ignored")
+		return
+	}
+	alerts := deliveries.dedupe("interruption", []Alert{*alert})
+	if len(alerts) == 0 {
+		countInterruption(source, n.Kind, "duplicate")
+		return
+	}
+	countInterruption(source, n.Kind, "accepted")
+	if n.Kind != interruptionRebalance {
+		// the cordon of an earlier rebalance recommendation mustn't hold the drain back
+		t := alert.Target()
+		cooldownMu.Lock()
+		delete(lastAction, t.Namespace+"/"+t.App)
+		cooldownMu.Unlock()
+	}
+	log.Printf("Interruption notice (%s) for node %s from %s — deadline %s", n.Kind, n.Node, source, alert.Annotations["deadline"])
+	processAlerts(ctx, shards.routeAlerts(ctx, "interruption", alerts))
+}
+
+func countInterruption(source, kind, result string) {
+	interruptionMu.Lock()
+	interruptionNotices[source+"|"+kind+"|"+result]++
+	interruptionMu.Unlock()
+}
+
+// --- SQS ---
+
+// eventBridgeEvent is the part of an EC2 event the operator reads
+type eventBridgeEvent struct {
+	DetailType string    `json:"detail-type"`
+	Source     string    `json:"source"`
+	Time       time.Time `json:"time"`
+	Detail     struct {
+		InstanceID     string `json:"instance-id"`
+		InstanceAction string `json:"instance-action"`
+	} `json:"detail"`
+}
+
+// eventKinds maps EventBridge detail types to notice kinds
+var eventKinds = map[string]string{
+	"EC2 Spot Instance Interruption Warning": interruptionSpot,
+	"EC2 Instance Rebalance Recommendation":  interruptionRebalance,
+}
+
+// startInterruptionQueue long-polls INTERRUPTION_SQS_QUEUE_URL for EC2 interruption events
+func startInterruptionQueue(kube kubernetes.Interface) {
+	if interruptionQueueURL == "" {
+		return
+	}
+	u, err := url.Parse(interruptionQueueURL)
+	if err != nil || u.Host == "" {
+		log.Fatalf("Invalid INTERRUPTION_SQS_QUEUE_URL %q", interruptionQueueURL)
+	}
+	// https://sqs.<region>.amazonaws.com/<account>/<queue>
+	region := awsRegion("")
+	if parts := strings.Split(u.Host, "."); len(parts) > 2 && parts[0] == "sqs" {
+		region = parts[1]
+	}
+	if region == "" {
+		log.Fatalf("INTERRUPTION_SQS_QUEUE_URL needs AWS_REGION")
+	}
+	log.Printf("Interruption notices — polling SQS queue %s", interruptionQueueURL)
+	go func() {
+		for operatorCtx.Err() == nil {
+			if err := pollInterruptionQueue(operatorCtx, kube, u.Scheme+"://"+u.Host+"/", region); err != nil && operatorCtx.Err() == nil {
+				log.Printf("Failed to poll the interruption queue: %v", err)
+				select {
+				case <-operatorCtx.Done():
+				case <-time.After(10 * time.Second):
+				}
+			}
+		}
+	}()
+}
+
+// pollInterruptionQueue receives one batch of messages and handles them
+func pollInterruptionQueue(ctx context.Context, kube kubernetes.Interface, endpoint, region string) error {
+	var out struct {
+		Messages []struct {
+			ReceiptHandle string `json:"ReceiptHandle"`
+			Body          string `json:"Body"`
+		} `json:"Messages"`
+	}
+	err := callSQS(ctx, endpoint, region, "ReceiveMessage", map[string]interface{}{
+		"QueueUrl": interruptionQueueURL, "MaxNumberOfMessages": 10, "WaitTimeSeconds": 20,
+	}, &out)
+	if err != nil {
+		return err
+	}
+	for _, m := range out.Messages {
+		handleInterruptionEvent(ctx, kube, m.Body)
+		// handled or not understood, it's done with: a redelivery wouldn't do better
+		if err := callSQS(ctx, endpoint, region, "DeleteMessage", map[string]interface{}{
+			"QueueUrl": interruptionQueueURL, "ReceiptHandle": m.ReceiptHandle,
+		}, nil); err != nil {
+			log.Printf("Failed to delete an interruption message: %v", err)
+		}
+	}
+	return nil
+}
+
+// handleInterruptionEvent turns an EventBridge event into a notice for its node
+func handleInterruptionEvent(ctx context.Context, kube kubernetes.Interface, body string) {
+	var ev eventBridgeEvent
+	if err := json.Unmarshal([]byte(body), &ev); err != nil {
+		countInterruption("sqs", "unknown", "invalid")
+		log.Printf("Ignoring an interruption message that isn't an EventBridge event: %v", err)
+		return
+	}
+	kind, ok := eventKinds[ev.DetailType]
+	if !ok {
+		countInterruption("sqs", "unknown", "ignored")
+		return
+	}
+	node, err := nodeForInstance(ctx, kube, ev.Detail.InstanceID)
+	if err != nil {
+		countInterruption("sqs", kind, "unknown-node")
+		log.Printf("Interruption notice for %s ignored — %v", ev.Detail.InstanceID, err)
+		return
+	}
+	acceptInterruption(ctx, "sqs", interruptionNotice{
+		Node: node, Instance: ev.Detail.InstanceID, Provider: "aws", Kind: kind,
+		Action: ev.Detail.InstanceAction, Time: ev.Time,
+	})
+}
+
+// nodeForInstance finds the node whose providerID ends in the instance ID
+func nodeForInstance(ctx context.Context, kube kubernetes.Interface, instance string) (string, error) {
+	if instance == "" {
+		return "", fmt.Errorf("no instance-id")
+	}
+	nodes, err := kube.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	for _, n := range nodes.Items {
+		// aws:///us-east-1a/i-0123456789abcdef0
+		if strings.HasSuffix(n.Spec.ProviderID, "/"+instance) {
+			return n.Name, nil
+		}
+	}
+	return "", fmt.Errorf("no node has instance %s", instance)
+}
+
+// callSQS calls an SQS action with the AWS JSON protocol
+func callSQS(ctx context.Context, endpoint, region, action string, in map[string]interface{}, out interface{}) error {
+	creds, err := interruptionAWS.credentials(ctx, region)
+	if err != nil {
+		return err
+	}
+	body, _ := json.Marshal(in)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "AmazonSQS."+action)
+	signAWSRequest(req, body, creds, region, "sqs", time.Now())
+	// the long poll outlasts the egress client's timeout
+	client := *egressClient("aws")
+	client.Timeout = 30 * time.Second
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&e)
+		return fmt.Errorf("SQS %s returned %s %s %s", action, resp.Status, e.Type, e.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// --- runbook steps ---
+
+// interruptionDeadline is when the node goes away; zero for a rebalance recommendation
+func interruptionDeadline(rc *runbookContext) time.Time {
+	t, _ := time.Parse(time.RFC3339, rc.alert.Annotations["deadline"])
+	return t
+}
+
+// stepCordonInterrupted cordons the node and marks it as interrupted
+func stepCordonInterrupted(ctx context.Context, rc *runbookContext) error {
+	if err := stepCordonNode(ctx, rc); err != nil {
+		return err
+	}
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, interruptionAnnotation, time.Now().UTC().Format(time.RFC3339))
+	if _, err := rc.clients.Kube.CoreV1().Nodes().Patch(ctx, rc.node, types.MergePatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to annotate node %s: %v", rc.node, err)
+	}
+	if rc.alert.Labels["interruption_kind"] == interruptionRebalance && interruptionRebalanceMode != "drain" {
+		log.Printf("Node %s cordoned on a rebalance recommendation; its pods stay until an interruption notice", rc.node)
+		return errRunbookDone
+	}
+	return nil
+}
+
+// interruptionGrace shortens a pod's grace period to what's left before the deadline
+func interruptionGrace(p *corev1.Pod, deadline time.Time) *int64 {
+	if deadline.IsZero() {
+		return nil
+	}
+	own := int64(corev1.DefaultTerminationGracePeriodSeconds)
+	if p.Spec.TerminationGracePeriodSeconds != nil {
+		own = *p.Spec.TerminationGracePeriodSeconds
+	}
+	left := int64(time.Until(deadline).Seconds())
+	if own <= left {
+		return nil
+	}
+	if left < 1 {
+		left = 1
+	}
+	return &left
+}
+
+func stepEvictBeforeDeadline(ctx context.Context, rc *runbookContext) error {
+	deadline := interruptionDeadline(rc)
+	pods, err := drainablePods(ctx, rc.clients.Kube, rc.node)
+	if err != nil {
+		return err
+	}
+	for i := range pods {
+		err := evictPod(ctx, rc.clients.Kube, pods[i].Namespace, pods[i].Name, interruptionGrace(&pods[i], deadline))
+		if err != nil && !apierrors.IsTooManyRequests(err) {
+			return err
+		}
+	}
+	log.Printf("Requested eviction of %d pod(s) from interrupted node %s", len(pods), rc.node)
+	return nil
+}
+
+// verifyDrainedBeforeDeadline retries evictions until the node is empty or the deadline
+func verifyDrainedBeforeDeadline(ctx context.Context, rc *runbookContext) error {
+	deadline := interruptionDeadline(rc)
+	timeout := time.Until(deadline)
+	if deadline.IsZero() {
+		timeout = drainTimeout(rc)
+	}
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	var left []corev1.Pod
+	err := wait.PollUntilContextTimeout(ctx, 2*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		pods, err := drainablePods(ctx, rc.clients.Kube, rc.node)
+		if err != nil {
+			return false, nil
+		}
+		left = pods
+		force := interruptionPDBOverride && !deadline.IsZero() && time.Until(deadline) <= interruptionForceBefore
+		for i := range pods {
+			p := &pods[i]
+			grace := interruptionGrace(p, deadline)
+			if force {
+				log.Printf("Deleting %s/%s from node %s despite its PodDisruptionBudget — the node terminates at %s", p.Namespace, p.Name, rc.node, deadline.Format(time.RFC3339))
+				rc.clients.Kube.CoreV1().Pods(p.Namespace).Delete(ctx, p.Name, metav1.DeleteOptions{GracePeriodSeconds: grace})
+			} else {
+				evictPod(ctx, rc.clients.Kube, p.Namespace, p.Name, grace)
+			}
+		}
+		return len(pods) == 0, nil
+	})
+	if err != nil {
+		names := make([]string, 0, len(left))
+		for _, p := range left {
+			names = append(names, p.Namespace+"/"+p.Name)
+		}
+		when := "at its deadline"
+		if deadline.IsZero() {
+			when = "after " + timeout.String()
+		}
+		return fmt.Errorf("node %s still had %d pod(s) %s (PodDisruptionBudgets?): %s", rc.node, len(left), when, strings.Join(names, ", "))
+	}
+	if deadline.IsZero() {
+		// a rebalance recommendation has no termination time
+		log.Printf("Interrupted node %s drained", rc.node)
+		return nil
+	}
+	log.Printf("Interrupted node %s drained with %s to spare", rc.node, time.Until(deadline).Round(time.Second))
+	return nil
+}
+
+// nodeInterrupted reports whether the node was drained for an interruption
+func nodeInterrupted(node *corev1.Node) bool {
+	_, ok := node.Annotations[interruptionAnnotation]
+	return ok
+}
+
+func init() {
+	registerMetrics(writeInterruptionMetrics)
+}
+
+func writeInterruptionMetrics(w io.Writer) {
+	interruptionMu.Lock()
+	defer interruptionMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_interruption_notices_total Spot/preemption notices received, by source, kind and result.")
+	fmt.Fprintln(w, "# TYPE selfhealing_interruption_notices_total counter")
+	for _, key := range sortedKeys(interruptionNotices) {
+		parts := strings.SplitN(key, "|", 3)
+		fmt.Fprintf(w, "selfhealing_interruption_notices_total{source=%q,kind=%q,result=%q} %d\n", parts[0], parts[1], parts[2], interruptionNotices[key])
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Issue creation: when a workload keeps failing despite remediation — its
+// policy was auto-disabled by the effectiveness circuit breaker — the
+// operator opens an issue in the team's repository so the failure is fixed
+// at the source, not just paged about. The issue carries the pod's recent
+// logs, the workload's events and its remediation history.
+//
+// GITHUB_REPO (owner/repo, with GITHUB_TOKEN) or GITLAB_PROJECT (id or
+// group/project, with GITLAB_TOKEN) selects the tracker. ISSUE_OWNERS_FILE
+// assigns issues CODEOWNERS-style: each line is a namespace/app pattern
+// followed by usernames, and the last matching line wins:
+//
+//	*/*            @platform-team
+//	shop/*         @alice
+//	shop/payments  @bob @carol
+//
+// Only one issue per workload and alert is kept open: an open issue with the
+// same title is left alone.
+
+const issueLabel = "self-healing"
+
+// Issue is what the operator files about a workload
+type Issue struct {
+	Title     string
+	Body      string
+	Assignees []string
+	Labels    []string
+}
+
+// IssueTracker opens issues in one tracker
+type IssueTracker interface {
+	Name() string
+	// FindOpen returns the URL of an open issue with the title, or ""
+	FindOpen(ctx context.Context, title string) (string, error)
+	Create(ctx context.Context, issue Issue) (string, error)
+}
+
+var (
+	issueTrackers []IssueTracker
+	issueOwners   []ownerRule
+	issueLogLines = int64(envInt("ISSUE_LOG_LINES", 50))
+	issueThrottle = time.Hour
+
+	issuesMu     sync.Mutex
+	issuesFiled  = map[string]time.Time{} // title -> when it was last checked, within issueThrottle
+	issueResults = map[string]int{}       // tracker|result -> count
+)
+
+func init() {
+	registerMetrics(writeIssueMetrics)
+}
+
+type ownerRule struct {
+	pattern string
+	owners  []string
+}
+
+// setupIssueTrackers enables each tracker whose settings are present
+func setupIssueTrackers() {
+	if repo := os.Getenv("GITHUB_REPO"); repo != "" {
+		issueTrackers = append(issueTrackers, &githubTracker{
+			apiURL: strings.TrimRight(envString("GITHUB_API_URL", "https://api.github.com"), "/"),
+			repo:   repo,
+			token:  credential("GITHUB_TOKEN"),
+		})
+	}
+	if project := os.Getenv("GITLAB_PROJECT"); project != "" {
+		issueTrackers = append(issueTrackers, &gitlabTracker{
+			apiURL:  strings.TrimRight(envString("GITLAB_URL", "https://gitlab.com"), "/") + "/api/v4",
+			project: project,
+			token:   credential("GITLAB_TOKEN"),
+		})
+	}
+	if file := os.Getenv("ISSUE_OWNERS_FILE"); file != "" {
+		rules, err := loadOwnerRules(file)
+		if err != nil {
+			log.Fatalf("Failed to load ISSUE_OWNERS_FILE: %v", err)
+		}
+		issueOwners = rules
+	}
+	for _, t := range issueTrackers {
+		log.Printf("Issue creation via %s enabled (%d owner rule(s))", t.Name(), len(issueOwners))
+	}
+}
+
+func loadOwnerRules(file string) ([]ownerRule, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rules []ownerRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if _, err := path.Match(fields[0], ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", fields[0], err)
+		}
+		rule := ownerRule{pattern: fields[0]}
+		for _, o := range fields[1:] {
+			rule.owners = append(rule.owners, strings.TrimPrefix(o, "@"))
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// ownersFor returns the owners of the last rule matching namespace/app
+func ownersFor(namespace, app string) []string {
+	var owners []string
+	for _, r := range issueOwners {
+		if ok, _ := path.Match(r.pattern, namespace+"/"+app); ok {
+			owners = r.owners
+		}
+	}
+	return owners
+}
+
+// issueDue records a tracker check for title unless one ran within the hour;
+// titles checked longer ago are dropped so the map doesn't grow forever
+func issueDue(title string, now time.Time) bool {
+	issuesMu.Lock()
+	defer issuesMu.Unlock()
+	for t, last := range issuesFiled {
+		if now.Sub(last) >= issueThrottle {
+			delete(issuesFiled, t)
+		}
+	}
+	if _, ok := issuesFiled[title]; ok {
+		return false
+	}
+	issuesFiled[title] = now
+	return true
+}
+
+// fileIssue opens an issue about a workload in every configured tracker, in the background
+func fileIssue(action *RecoveryAction, alert Alert, reason string) {
+	if len(issueTrackers) == 0 {
+		return
+	}
+	title := fmt.Sprintf("Self-healing gave up on %s/%s (%s)", action.Namespace, action.App, action.AlertName)
+
+	// the circuit stays open, so the same alert keeps arriving; check the tracker at most hourly
+	if !issueDue(title, time.Now()) {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(operatorCtx, time.Minute)
+		defer cancel()
+		issue := Issue{
+			Title:     title,
+			Body:      issueBody(ctx, action, alert, reason),
+			Assignees: ownersFor(action.Namespace, action.App),
+			Labels:    []string{issueLabel, action.Namespace},
+		}
+		for _, t := range issueTrackers {
+			result := "created"
+			existing, err := t.FindOpen(ctx, title)
+			switch {
+			case err != nil:
+				result = "error"
+				log.Printf("Failed to search %s issues: %v", t.Name(), err)
+			case existing != "":
+				result = "exists"
+				log.Printf("Issue for %s/%s already open: %s", action.Namespace, action.App, existing)
+			default:
+				url, err := t.Create(ctx, issue)
+				if err != nil {
+					result = "error"
+					log.Printf("Failed to open %s issue: %v", t.Name(), err)
+					break
+				}
+				log.Printf("Opened %s issue for %s/%s: %s", t.Name(), action.Namespace, action.App, url)
+			}
+			issuesMu.Lock()
+			issueResults[t.Name()+"|"+result]++
+			issuesMu.Unlock()
+		}
+	}()
+}
+
+// issueBody collects what a developer needs to start on the failure
+func issueBody(ctx context.Context, action *RecoveryAction, alert Alert, reason string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "The self-healing operator stopped remediating `%s/%s`: %s.\n\n", action.Namespace, action.App, reason)
+	fmt.Fprintf(&b, "- Alert: `%s` (severity %s)\n", action.AlertName, alert.Labels["severity"])
+	fmt.Fprintf(&b, "- Policy: `%s`\n", policyKey(action))
+	if tags := remediationTags(alert.Labels); tags != nil {
+		fmt.Fprintf(&b, "- Tags: %s\n", formatTags(tags))
+	}
+	if d := alert.Annotations["description"]; d != "" {
+		fmt.Fprintf(&b, "- Description: %s\n", d)
+	}
+
+	b.WriteString("\n### Remediation history\n\n| Time | Action | Outcome | Error |\n|---|---|---|---|\n")
+	for _, rec := range listHistory(0) {
+		if rec.Namespace == action.Namespace && rec.App == action.App {
+			fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", rec.StartedAt.Format(time.RFC3339), rec.Action, rec.Outcome, rec.Error)
+		}
+	}
+
+	if clients.Kube == nil {
+		return b.String()
+	}
+	pod := action.Pod
+	if pod == "" {
+		if pods, err := clients.Kube.CoreV1().Pods(action.Namespace).List(ctx, metav1.ListOptions{LabelSelector: "app=" + action.App}); err == nil && len(pods.Items) > 0 {
+			pod = pods.Items[0].Name
+		}
+	}
+	if pod != "" {
+		for _, previous := range []bool{true, false} {
+			raw, err := clients.Kube.CoreV1().Pods(action.Namespace).GetLogs(pod, &corev1.PodLogOptions{
+				Previous:  previous,
+				TailLines: &issueLogLines,
+			}).DoRaw(ctx)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(&b, "\n### Logs of %s (previous=%t)\n\n```\n%s\n```\n", pod, previous, truncate(string(raw), 30000))
+			break
+		}
+	}
+
+	events, err := clients.Kube.CoreV1().Events(action.Namespace).List(ctx, metav1.ListOptions{})
+	if err == nil {
+		b.WriteString("\n### Events\n\n| Last seen | Object | Reason | Message |\n|---|---|---|---|\n")
+		for _, e := range events.Items {
+			name := e.InvolvedObject.Name
+			if name != pod && name != action.App && !strings.HasPrefix(name, action.App+"-") {
+				continue
+			}
+			fmt.Fprintf(&b, "| %s | %s/%s | %s | %s |\n", e.LastTimestamp.Format(time.RFC3339),
+				e.InvolvedObject.Kind, name, e.Reason, strings.ReplaceAll(e.Message, "|", "\\|"))
+		}
+	}
+	return b.String()
+}
+
+// doJSON sends body (if any) and decodes a 2xx response into out (if any)
+func doJSON(ctx context.Context, integration, method, url string, body, out interface{}, headers map[string]string) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := egressClient(integration).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// githubTracker uses the GitHub REST API (github.com or Enterprise via GITHUB_API_URL)
+type githubTracker struct {
+	apiURL string
+	repo   string
+	token  credential
+}
+
+func (g *githubTracker) Name() string { return "github" }
+
+func (g *githubTracker) headers() map[string]string {
+	return map[string]string{
+		"Authorization":        "Bearer " + g.token.value(),
+		"X-GitHub-Api-Version": "2022-11-28",
+	}
+}
+
+func (g *githubTracker) FindOpen(ctx context.Context, title string) (string, error) {
+	var issues []struct {
+		Title   string `json:"title"`
+		HTMLURL string `json:"html_url"`
+	}
+	q := url.Values{"state": {"open"}, "labels": {issueLabel}, "per_page": {"100"}}
+	if err := doJSON(ctx, "github", http.MethodGet, g.apiURL+"/repos/"+g.repo+"/issues?"+q.Encode(), nil, &issues, g.headers()); err != nil {
+		return "", err
+	}
+	for _, i := range issues {
+		if i.Title == title {
+			return i.HTMLURL, nil
+		}
+	}
+	return "", nil
+}
+
+func (g *githubTracker) Create(ctx context.Context, issue Issue) (string, error) {
+	var out struct {
+		HTMLURL string `json:"html_url"`
+	}
+	body := map[string]interface{}{
+		"title":     issue.Title,
+		"body":      issue.Body,
+		"labels":    issue.Labels,
+		"assignees": issue.Assignees,
+	}
+	err := doJSON(ctx, "github", http.MethodPost, g.apiURL+"/repos/"+g.repo+"/issues", body, &out, g.headers())
+	return out.HTMLURL, err
+}
+
+// gitlabTracker uses the GitLab v4 API (gitlab.com or self-managed via GITLAB_URL)
+type gitlabTracker struct {
+	apiURL  string
+	project string
+	token   credential
+}
+
+func (g *gitlabTracker) Name() string { return "gitlab" }
+
+func (g *gitlabTracker) projectURL() string {
+	return g.apiURL + "/projects/" + url.PathEscape(g.project)
+}
+
+func (g *gitlabTracker) headers() map[string]string {
+	return map[string]string{"PRIVATE-TOKEN": g.token.value()}
+}
+
+func (g *gitlabTracker) FindOpen(ctx context.Context, title string) (string, error) {
+	var issues []struct {
+		Title  string `json:"title"`
+		WebURL string `json:"web_url"`
+	}
+	q := url.Values{"state": {"opened"}, "labels": {issueLabel}, "search": {title}, "in": {"title"}}
+	if err := doJSON(ctx, "gitlab", http.MethodGet, g.projectURL()+"/issues?"+q.Encode(), nil, &issues, g.headers()); err != nil {
+		return "", err
+	}
+	for _, i := range issues {
+		if i.Title == title {
+			return i.WebURL, nil
+		}
+	}
+	return "", nil
+}
+
+func (g *gitlabTracker) Create(ctx context.Context, issue Issue) (string, error) {
+	// GitLab assigns by user ID
+	var ids []int
+	for _, name := range issue.Assignees {
+		var users []struct {
+			ID int `json:"id"`
+		}
+		if err := doJSON(ctx, "gitlab", http.MethodGet, g.apiURL+"/users?username="+url.QueryEscape(name), nil, &users, g.headers()); err != nil || len(users) == 0 {
+			log.Printf("GitLab user %s not found — not assigning", name)
+			continue
+		}
+		ids = append(ids, users[0].ID)
+	}
+	var out struct {
+		WebURL string `json:"web_url"`
+	}
+	body := map[string]interface{}{
+		"title":        issue.Title,
+		"description":  issue.Body,
+		"labels":       strings.Join(issue.Labels, ","),
+		"assignee_ids": ids,
+	}
+	err := doJSON(ctx, "gitlab", http.MethodPost, g.projectURL()+"/issues", body, &out, g.headers())
+	return out.WebURL, err
+}
+
+func writeIssueMetrics(w io.Writer) {
+	issuesMu.Lock()
+	defer issuesMu.Unlock()
+	if len(issueTrackers) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_issues_total Issue creation attempts for workloads the operator gave up on, by tracker and result.")
+	fmt.Fprintln(w, "# TYPE selfhealing_issues_total counter")
+	for _, key := range sortedKeys(issueResults) {
+		tracker, result, _ := strings.Cut(key, "|")
+		fmt.Fprintf(w, "selfhealing_issues_total{tracker=%q,result=%q} %d\n", tracker, result, issueResults[key])
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIssueDueThrottlesAndPrunes(t *testing.T) {
+	issuesMu.Lock()
+	saved := issuesFiled
+	issuesFiled = map[string]time.Time{}
+	issuesMu.Unlock()
+	defer func() {
+		issuesMu.Lock()
+		issuesFiled = saved
+		issuesMu.Unlock()
+	}()
+
+	now := time.Now()
+	tests := []struct {
+		name  string
+		title string
+		at    time.Time
+		want  bool
+		kept  int
+	}{
+		{"first check", "a", now, true, 1},
+		{"within the hour", "a", now.Add(59 * time.Minute), false, 1},
+		{"another title", "b", now.Add(30 * time.Minute), true, 2},
+		{"after the hour, stale a is pruned", "c", now.Add(61 * time.Minute), true, 2},
+		{"a is due again", "a", now.Add(62 * time.Minute), true, 3},
+		{"much later, only the new title is left", "d", now.Add(5 * time.Hour), true, 1},
+	}
+	for _, tt := range tests {
+		if got := issueDue(tt.title, tt.at); got != tt.want {
+			t.Errorf("%s: issueDue(%q) = %v, want %v", tt.name, tt.title, got, tt.want)
+		}
+		issuesMu.Lock()
+		n := len(issuesFiled)
+		issuesMu.Unlock()
+		if n != tt.kept {
+			t.Errorf("%s: %d titles tracked, want %d", tt.name, n, tt.kept)
+		}
+	}
+}
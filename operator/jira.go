@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Jira tickets follow a remediation's lifecycle, one ticket per alert and
+// target:
+//   - the first action on the target opens it; later actions add a comment
+//   - every escalation adds a comment (Jira is also an escalation target)
+//   - the alert resolving after a remediation moves the ticket through the
+//     JIRA_RESOLVE_TRANSITION transition
+//
+// Tickets carry a label derived from the alert and target, so the operator
+// finds its open ticket again after a restart.
+//
+// JIRA_FIELDS_FILE maps policies ("alertname:action", with * wildcards) to
+// ticket fields; the last matching entry wins. Values may use {{alertname}},
+// {{namespace}}, {{app}}, {{severity}} and {{action}}:
+//
+//	- policy: "*"
+//	  fields:
+//	    project: {key: OPS}
+//	    issuetype: {name: Incident}
+//	- policy: "KubePodCrashLooping:*"
+//	  fields:
+//	    project: {key: SHOP}
+//	    issuetype: {name: Bug}
+//	    priority: {name: High}
+//	    components: [{name: "{{app}}"}]
+
+const jiraTargetLabelPrefix = "selfhealing-"
+
+var (
+	jiraURL               = strings.TrimRight(os.Getenv("JIRA_URL"), "/")
+	jiraResolveTransition = envString("JIRA_RESOLVE_TRANSITION", "Done")
+
+	jira = &jiraClient{tickets: map[string]string{}, results: map[string]int{}}
+)
+
+type jiraFieldRule struct {
+	Policy string                 `json:"policy"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
+type jiraClient struct {
+	user  string
+	token credential
+	rules []jiraFieldRule
+
+	// one ticket operation at a time, so two hooks for one target can't both create it
+	mu      sync.Mutex
+	tickets map[string]string // target -> open ticket key
+
+	statsMu sync.Mutex
+	results map[string]int // operation|result -> count
+}
+
+func init() {
+	registerMetrics(jira.writeMetrics)
+}
+
+// setupJira enables the Jira integration when JIRA_URL is set
+func setupJira() {
+	if jiraURL == "" {
+		return
+	}
+	jira.user = os.Getenv("JIRA_USER")
+	jira.token = credential("JIRA_API_TOKEN")
+	if file := os.Getenv("JIRA_FIELDS_FILE"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatalf("Failed to read JIRA_FIELDS_FILE: %v", err)
+		}
+		if err := yaml.Unmarshal(data, &jira.rules); err != nil {
+			log.Fatalf("Failed to parse JIRA_FIELDS_FILE: %v", err)
+		}
+	}
+	if len(jira.rules) == 0 {
+		project := os.Getenv("JIRA_PROJECT")
+		if project == "" {
+			log.Fatalf("JIRA_URL is set but neither JIRA_PROJECT nor JIRA_FIELDS_FILE is")
+		}
+		jira.rules = []jiraFieldRule{{Policy: "*", Fields: map[string]interface{}{
+			"project":   map[string]interface{}{"key": project},
+			"issuetype": map[string]interface{}{"name": envString("JIRA_ISSUE_TYPE", "Incident")},
+		}}}
+	}
+	onRemediation(jira.onRemediation)
+	escalators = append(escalators, jira)
+	log.Printf("Jira tickets enabled — %s (%d field rule(s))", jiraURL, len(jira.rules))
+}
+
+func jiraTarget(alertname, namespace, app string) string {
+	if namespace == "" {
+		namespace = "default"
+	}
+	return alertname + "|" + namespace + "/" + app
+}
+
+// targetLabel is the Jira label identifying a target's tickets
+func (j *jiraClient) targetLabel(target string) string {
+	h := fnv.New64a()
+	h.Write([]byte(target))
+	return fmt.Sprintf("%s%x", jiraTargetLabelPrefix, h.Sum64())
+}
+
+// fieldsFor returns the ticket fields for a policy, with placeholders filled in
+func (j *jiraClient) fieldsFor(policy string, vars *strings.Replacer) map[string]interface{} {
+	var fields map[string]interface{}
+	for _, r := range j.rules {
+		if ok, _ := path.Match(r.Policy, policy); ok {
+			fields = r.Fields
+		}
+	}
+	out, _ := expandJiraValue(fields, vars).(map[string]interface{})
+	if out == nil {
+		out = map[string]interface{}{}
+	}
+	return out
+}
+
+func expandJiraValue(v interface{}, vars *strings.Replacer) interface{} {
+	switch v := v.(type) {
+	case string:
+		return vars.Replace(v)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			out[k] = expandJiraValue(val, vars)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, val := range v {
+			out[i] = expandJiraValue(val, vars)
+		}
+		return out
+	}
+	return v
+}
+
+func (j *jiraClient) do(ctx context.Context, method, path string, body, out interface{}) error {
+	headers := map[string]string{}
+	token := j.token.value()
+	if j.user != "" {
+		// Jira Cloud: email + API token
+		headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(j.user+":"+token))
+	} else if token != "" {
+		// Jira Data Center: personal access token
+		headers["Authorization"] = "Bearer " + token
+	}
+	return doJSON(ctx, "jira", method, jiraURL+path, body, out, headers)
+}
+
+// openTicket returns the open ticket of a target, looking it up in Jira if
+// this process doesn't know it. Caller must hold j.mu.
+func (j *jiraClient) openTicket(ctx context.Context, target string) (string, error) {
+	if key, ok := j.tickets[target]; ok {
+		return key, nil
+	}
+	var out struct {
+		Issues []struct {
+			Key string `json:"key"`
+		} `json:"issues"`
+	}
+	jql := fmt.Sprintf(`labels = "%s" AND statusCategory != Done ORDER BY created DESC`, j.targetLabel(target))
+	q := url.Values{"jql": {jql}, "fields": {"key"}, "maxResults": {"1"}}
+	if err := j.do(ctx, http.MethodGet, "/rest/api/2/search?"+q.Encode(), nil, &out); err != nil {
+		return "", err
+	}
+	if len(out.Issues) == 0 {
+		return "", nil
+	}
+	j.tickets[target] = out.Issues[0].Key
+	return out.Issues[0].Key, nil
+}
+
+// ticket adds a comment to the target's open ticket, or opens one with the
+// comment as its description
+func (j *jiraClient) ticket(ctx context.Context, target, policy, summary, text string, vars *strings.Replacer, tags map[string]string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	key, err := j.openTicket(ctx, target)
+	if err != nil {
+		j.count("search", err)
+		return err
+	}
+	if key != "" {
+		err := j.do(ctx, http.MethodPost, "/rest/api/2/issue/"+key+"/comment", map[string]string{"body": text}, nil)
+		j.count("comment", err)
+		return err
+	}
+
+	fields := j.fieldsFor(policy, vars)
+	fields["summary"] = truncate(summary, 255)
+	fields["description"] = text
+	labels, _ := fields["labels"].([]interface{})
+	labels = append(labels, "self-healing", j.targetLabel(target))
+	for _, tag := range tagList(tags) {
+		labels = append(labels, tag)
+	}
+	fields["labels"] = labels
+	var out struct {
+		Key string `json:"key"`
+	}
+	err = j.do(ctx, http.MethodPost, "/rest/api/2/issue", map[string]interface{}{"fields": fields}, &out)
+	j.count("create", err)
+	if err != nil {
+		return err
+	}
+	j.tickets[target] = out.Key
+	log.Printf("Opened Jira ticket %s for %s", out.Key, target)
+	return nil
+}
+
+func (j *jiraClient) count(op string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	j.statsMu.Lock()
+	j.results[op+"|"+result]++
+	j.statsMu.Unlock()
+}
+
+func jiraVars(alertname, namespace, app, severity, action string) *strings.Replacer {
+	return strings.NewReplacer(
+		"{{alertname}}", alertname,
+		"{{namespace}}", namespace,
+		"{{app}}", app,
+		"{{severity}}", severity,
+		"{{action}}", action,
+	)
+}
+
+// onRemediation opens the ticket on the first action and comments on later ones
+func (j *jiraClient) onRemediation(rec ActionRecord) {
+	ctx, cancel := context.WithTimeout(operatorCtx, 30*time.Second)
+	defer cancel()
+	target := jiraTarget(rec.AlertName, rec.Namespace, rec.App)
+	text := fmt.Sprintf("Self-healing ran '%s' on %s/%s: %s", rec.Action, rec.Namespace, rec.App, rec.Outcome)
+	if rec.Name != "" {
+		text = fmt.Sprintf("Self-healing ran %s '%s' on %s/%s: %s", rec.Action, rec.Name, rec.Namespace, rec.App, rec.Outcome)
+	}
+	if rec.Error != "" {
+		text += "\n\nError: " + rec.Error
+	}
+	for _, s := range rec.Steps {
+		text += fmt.Sprintf("\n* %s (%s): %s %s", s.Name, s.Action, s.Status, s.Error)
+	}
+	text += fmt.Sprintf("\n\nHistory record #%d, started %s, took %s.", rec.ID, rec.StartedAt.Format(time.RFC3339), rec.Duration)
+	summary := fmt.Sprintf("%s on %s/%s", rec.AlertName, rec.Namespace, rec.App)
+	vars := jiraVars(rec.AlertName, rec.Namespace, rec.App, rec.alertLabels["severity"], rec.Action)
+	if err := j.ticket(ctx, target, rec.AlertName+":"+rec.Action, summary, text, vars, rec.Tags); err != nil {
+		log.Printf("Failed to update Jira for %s: %v", target, err)
+	}
+}
+
+func (j *jiraClient) Name() string { return "jira" }
+
+// Escalate comments on (or opens) the target's ticket
+func (j *jiraClient) Escalate(ctx context.Context, e Escalation) error {
+	target := jiraTarget(e.AlertName, e.Namespace, e.App)
+	text := "Escalated: " + e.Reason
+	if d := e.Annotations["description"]; d != "" {
+		text += "\n\n" + d
+	}
+	vars := jiraVars(e.AlertName, e.Namespace, e.App, e.Labels["severity"], e.Action)
+	return j.ticket(ctx, target, e.AlertName+":"+e.Action, e.summary(), text, vars, e.Tags)
+}
+
+// observeResolved resolves the ticket of a resolved alert, if there is one
+func (j *jiraClient) observeResolved(alert Alert) {
+	if jiraURL == "" || alert.Status != "resolved" {
+		return
+	}
+	target := jiraTarget(alert.Labels["alertname"], alert.Labels["namespace"], alert.Labels["app"])
+	go func() {
+		ctx, cancel := context.WithTimeout(operatorCtx, 30*time.Second)
+		defer cancel()
+		if err := j.resolve(ctx, target); err != nil {
+			log.Printf("Failed to resolve Jira ticket for %s: %v", target, err)
+		}
+	}()
+}
+
+func (j *jiraClient) resolve(ctx context.Context, target string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	key, err := j.openTicket(ctx, target)
+	if err != nil || key == "" {
+		return err
+	}
+	comment := map[string]string{"body": "The alert resolved; recovery verified by the self-healing operator."}
+	if err := j.do(ctx, http.MethodPost, "/rest/api/2/issue/"+key+"/comment", comment, nil); err != nil {
+		j.count("comment", err)
+		return err
+	}
+
+	var out struct {
+		Transitions []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"transitions"`
+	}
+	if err := j.do(ctx, http.MethodGet, "/rest/api/2/issue/"+key+"/transitions", nil, &out); err != nil {
+		return err
+	}
+	for _, t := range out.Transitions {
+		if strings.EqualFold(t.Name, jiraResolveTransition) {
+			err := j.do(ctx, http.MethodPost, "/rest/api/2/issue/"+key+"/transitions",
+				map[string]interface{}{"transition": map[string]string{"id": t.ID}}, nil)
+			j.count("resolve", err)
+			if err != nil {
+				return err
+			}
+			delete(j.tickets, target)
+			log.Printf("Resolved Jira ticket %s for %s", key, target)
+			return nil
+		}
+	}
+	err = fmt.Errorf("ticket %s has no %q transition", key, jiraResolveTransition)
+	j.count("resolve", err)
+	return err
+}
+
+func (j *jiraClient) writeMetrics(w io.Writer) {
+	if jiraURL == "" {
+		return
+	}
+	j.statsMu.Lock()
+	defer j.statsMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_jira_requests_total Jira ticket operations, by operation and result.")
+	fmt.Fprintln(w, "# TYPE selfhealing_jira_requests_total counter")
+	for _, key := range sortedKeys(j.results) {
+		op, result, _ := strings.Cut(key, "|")
+		fmt.Fprintf(w, "selfhealing_jira_requests_total{operation=%q,result=%q} %d\n", op, result, j.results[key])
+	}
+}
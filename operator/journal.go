@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Action journal for crash recovery. With ACTION_JOURNAL set to a file path,
+// every action is written to a local write-ahead journal before it runs, and marked done when it finishes — for runbooks,
+// workflows and capacity requests when their run ends, not when they start.
+// An action that can't be journaled isn't run; it fails and escalates like
+// any other failed action.
+//
+// On startup the entries left open by a crash are recovered:
+//   - actions in JOURNAL_REPLAY_ACTIONS (restart, redeploy, notify: safe to
+//     run twice) that began less than JOURNAL_REPLAY_MAX_AGE ago are run
+//     again, through the same last-moment checks as any action (feature
+//     flags, pauses, preconditions)
+//   - anything else — a scale that may have been applied already, a runbook
+//     cut off halfway, an entry too old, or one that was already re-run once
+//     and crashed the operator again — is recorded as a failed action
+//     ("interrupted"), escalated and posted to Slack, for a person to check
+//
+// Put the journal on a volume that outlives the container: the emptyDir in
+// manifests/operator/deployment.yaml covers container restarts (crashes, OOM
+// kills), a PersistentVolume also covers the pod being rescheduled.
+//
+// JOURNAL_BACKEND selects how the journal is kept:
+//   - file   (default) JSON lines, synced to disk after every write and
+//     compacted whenever no action is open
+//   - sqlite an SQLite database in WAL mode with synchronous=FULL (needs a
+//     build with -tags sqlite and CGO_ENABLED=1, see journal_sqlite.go)
+
+var (
+	journalPath          = envString("ACTION_JOURNAL", "")
+	journalReplayActions = envList("JOURNAL_REPLAY_ACTIONS")
+	journalReplayMaxAge  = envDuration("JOURNAL_REPLAY_MAX_AGE", 15*time.Minute)
+	journalBackendKind   = envString("JOURNAL_BACKEND", "file")
+
+	actionJournal = &journalState{open: map[uint64]*journalEntry{}, results: map[string]int{}}
+)
+
+// defaultJournalReplayActions are the actions that are safe to run twice
+var defaultJournalReplayActions = []string{"restart", "redeploy", "notify"}
+
+// journalCompactBytes is the journal size past which it's compacted once no action is open
+const journalCompactBytes = 1 << 20
+
+// journalEntry is one line of the journal
+type journalEntry struct {
+	ID      uint64          `json:"id"`
+	Op      string          `json:"op"` // begin, end
+	At      time.Time       `json:"at"`
+	Action  *RecoveryAction `json:"action,omitempty"`
+	Alert   *Alert          `json:"alert,omitempty"`
+	Replays int             `json:"replays,omitempty"` // times the action was re-run after a crash
+	Outcome string          `json:"outcome,omitempty"` // succeeded, failed, skipped, interrupted
+}
+
+// journalBackend keeps the journal durably; each write must be on disk when it returns
+type journalBackend interface {
+	load() ([]*journalEntry, error)      // begin entries without an end, oldest first
+	append(e *journalEntry) error        // a begin or an end
+	reset(entries []*journalEntry) error // replaces the journal with the entries
+	size() int64                         // bytes on disk
+}
+
+// journalBackends maps JOURNAL_BACKEND values to constructors; backends that
+// need a build tag register themselves
+var journalBackends = map[string]func(path string) (journalBackend, error){
+	"file": func(path string) (journalBackend, error) { return &fileJournal{path: path}, nil },
+}
+
+type journalState struct {
+	mu      sync.Mutex
+	b       journalBackend
+	nextID  uint64
+	open    map[uint64]*journalEntry
+	results map[string]int // writes and recoveries, by result
+}
+
+func init() {
+	registerMetrics(actionJournal.writeMetrics)
+}
+
+// setupJournal opens the journal and returns the entries a crash left open
+func setupJournal() ([]*journalEntry, error) {
+	if journalPath == "" {
+		return nil, nil
+	}
+	if len(journalReplayActions) == 0 {
+		journalReplayActions = defaultJournalReplayActions
+	}
+	newBackend, ok := journalBackends[journalBackendKind]
+	if !ok {
+		return nil, fmt.Errorf("unknown JOURNAL_BACKEND %q", journalBackendKind)
+	}
+	b, err := newBackend(journalPath)
+	if err != nil {
+		return nil, err
+	}
+	open, err := b.load()
+	if err != nil {
+		return nil, err
+	}
+	// keep only the open entries; each is ended once it's recovered
+	if err := b.reset(open); err != nil {
+		return nil, err
+	}
+	j := actionJournal
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.b = b
+	j.nextID = uint64(time.Now().UnixNano())
+	for _, e := range open {
+		j.open[e.ID] = e
+	}
+	log.Printf("Action journal %s (%s) opened — %d action(s) left open by the last run", journalPath, journalBackendKind, len(open))
+	return open, nil
+}
+
+// sortJournal orders entries oldest first
+func sortJournal(entries []*journalEntry) {
+	sort.Slice(entries, func(i, k int) bool { return entries[i].At.Before(entries[k].At) })
+}
+
+// fileJournal keeps the journal as JSON lines in one file
+type fileJournal struct {
+	path string
+	f    *os.File
+	n    int64
+}
+
+// load returns the begin entries without an end; a torn last line is ignored
+func (fj *fileJournal) load() ([]*journalEntry, error) {
+	f, err := os.Open(fj.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the action journal: %v", err)
+	}
+	defer f.Close()
+	open := map[uint64]*journalEntry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var e journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Printf("Skipping an unreadable action journal line — %v", err)
+			continue
+		}
+		switch e.Op {
+		case "begin":
+			open[e.ID] = &e
+		case "end":
+			delete(open, e.ID)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the action journal: %v", err)
+	}
+	out := make([]*journalEntry, 0, len(open))
+	for _, e := range open {
+		out = append(out, e)
+	}
+	sortJournal(out)
+	return out, nil
+}
+
+// reset replaces the journal with the entries, atomically
+func (fj *fileJournal) reset(entries []*journalEntry) error {
+	tmp := fj.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to compact the action journal: %v", err)
+	}
+	var size int64
+	for _, e := range entries {
+		line, _ := json.Marshal(e)
+		n, err := f.Write(append(line, '\n'))
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("failed to compact the action journal: %v", err)
+		}
+		size += int64(n)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to compact the action journal: %v", err)
+	}
+	f.Close()
+	if err := os.Rename(tmp, fj.path); err != nil {
+		return fmt.Errorf("failed to compact the action journal: %v", err)
+	}
+	if dir, err := os.Open(filepath.Dir(fj.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	if fj.f != nil {
+		fj.f.Close()
+	}
+	if fj.f, err = os.OpenFile(fj.path, os.O_APPEND|os.O_WRONLY, 0o600); err != nil {
+		return fmt.Errorf("failed to open the action journal: %v", err)
+	}
+	fj.n = size
+	return nil
+}
+
+// append writes an entry and syncs it to disk
+func (fj *fileJournal) append(e *journalEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	n, err := fj.f.Write(append(line, '\n'))
+	if err == nil {
+		err = fj.f.Sync()
+	}
+	fj.n += int64(n)
+	return err
+}
+
+func (fj *fileJournal) size() int64 { return fj.n }
+
+// appendLocked writes an entry through the backend. Caller must hold j.mu.
+func (j *journalState) appendLocked(e *journalEntry) error {
+	if err := j.b.append(e); err != nil {
+		j.results["write_failed"]++
+		return fmt.Errorf("failed to write the action journal: %v", err)
+	}
+	j.results["written"]++
+	return nil
+}
+
+// begin journals an action about to run; an error means it mustn't run. A
+// re-run after a crash keeps its entry, rewritten with the replay count.
+func (j *journalState) begin(action *RecoveryAction, alert Alert) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.b == nil {
+		return nil
+	}
+	id := action.journalID
+	if id == 0 {
+		j.nextID++
+		id = j.nextID
+	}
+	e := &journalEntry{ID: id, Op: "begin", At: time.Now(), Action: action, Alert: &alert, Replays: action.journalReplays}
+	if err := j.appendLocked(e); err != nil {
+		return err
+	}
+	j.open[e.ID] = e
+	action.journalID = e.ID
+	return nil
+}
+
+// skipped ends a recovered entry whose re-run was skipped before it began
+func (j *journalState) skipped(id uint64, replays int) {
+	j.mu.Lock()
+	e := j.open[id]
+	j.mu.Unlock()
+	if e != nil && e.Replays < replays {
+		j.end(id, "skipped")
+	}
+}
+
+// end marks a journaled action done, compacting the journal when nothing is open
+func (j *journalState) end(id uint64, outcome string) {
+	if id == 0 {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.b == nil || j.open[id] == nil {
+		return
+	}
+	delete(j.open, id)
+	if err := j.appendLocked(&journalEntry{ID: id, Op: "end", At: time.Now(), Outcome: outcome}); err != nil {
+		// the entry is recovered after a restart; at worst a finished action is reported interrupted
+		log.Printf("Action journal: %v", err)
+		return
+	}
+	if len(j.open) == 0 && j.b.size() > journalCompactBytes {
+		if err := j.b.reset(nil); err != nil {
+			log.Printf("Action journal: %v", err)
+		}
+	}
+}
+
+// recoverJournal re-runs or reports the actions a crash left open
+func recoverJournal(open []*journalEntry) {
+	for _, e := range open {
+		action, alert := e.Action, Alert{}
+		if e.Alert != nil {
+			alert = *e.Alert
+		}
+		if action == nil {
+			actionJournal.end(e.ID, "interrupted")
+			continue
+		}
+		age := time.Since(e.At)
+		reason := ""
+		switch {
+		case !containsString(journalReplayActions, action.Action):
+			reason = "'" + action.Action + "' isn't safe to run twice (JOURNAL_REPLAY_ACTIONS)"
+		case age > journalReplayMaxAge:
+			reason = fmt.Sprintf("it began %s ago, more than JOURNAL_REPLAY_MAX_AGE", age.Round(time.Second))
+		case e.Replays > 0:
+			reason = "it was already re-run once after a crash"
+		}
+		if reason == "" {
+			log.Printf("Re-running '%s' for alert '%s' on %s/%s — interrupted by an operator restart", action.Action, action.AlertName, action.Namespace, action.App)
+			actionJournal.count("rerun")
+			action.Explain.add("journal", traceInfo, "re-run: interrupted by an operator restart at "+e.At.Format(time.RFC3339))
+			action.journalID, action.journalReplays = e.ID, e.Replays+1
+			go func(id uint64, replays int) {
+				if err := performAction(operatorCtx, action, alert); err != nil {
+					log.Printf("Re-run of '%s' for alert '%s' failed: %v", action.Action, action.AlertName, err)
+				}
+				actionJournal.skipped(id, replays)
+			}(e.ID, action.journalReplays)
+			continue
+		}
+
+		log.Printf("'%s' for alert '%s' on %s/%s was interrupted by an operator restart and isn't re-run — %s",
+			action.Action, action.AlertName, action.Namespace, action.App, reason)
+		err := fmt.Errorf("interrupted by an operator restart; not re-run because %s — check %s/%s by hand", reason, action.Namespace, action.App)
+		name := action.Runbook
+		if action.Action == "workflow" {
+			name = action.Workflow
+		}
+		recordHistory(newActionRecord(action, name, e.At), err)
+		actionJournal.end(e.ID, "interrupted")
+		actionJournal.count("interrupted")
+		escalate(action, alert, err.Error())
+		notifySlack(renderMessage("action-interrupted", "", map[string]interface{}{
+			"Action": action.Action, "AlertName": action.AlertName, "Namespace": action.Namespace, "App": action.App,
+			"StartedAt": e.At, "Reason": reason,
+		}))
+	}
+}
+
+func (j *journalState) count(result string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.results[result]++
+}
+
+func (j *journalState) writeMetrics(w io.Writer) {
+	if journalPath == "" {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_journal_open_actions Journaled actions that haven't finished.")
+	fmt.Fprintln(w, "# TYPE selfhealing_journal_open_actions gauge")
+	fmt.Fprintf(w, "selfhealing_journal_open_actions %d\n", len(j.open))
+	var size int64
+	if j.b != nil {
+		size = j.b.size()
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_journal_size_bytes Size of the action journal on disk.")
+	fmt.Fprintln(w, "# TYPE selfhealing_journal_size_bytes gauge")
+	fmt.Fprintf(w, "selfhealing_journal_size_bytes %d\n", size)
+	fmt.Fprintln(w, "# HELP selfhealing_journal_events_total Journal writes (written, write_failed) and actions recovered after a restart (rerun, interrupted).")
+	fmt.Fprintln(w, "# TYPE selfhealing_journal_events_total counter")
+	for _, result := range sortedKeys(j.results) {
+		fmt.Fprintf(w, "selfhealing_journal_events_total{result=%q} %d\n", result, j.results[result])
+	}
+}
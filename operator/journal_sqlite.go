@@ -0,0 +1,132 @@
+//go:build sqlite
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// The SQLite action journal (JOURNAL_BACKEND=sqlite). Open entries are rows
+// of one table: a begin inserts its row, an end deletes it. The database runs
+// in WAL mode with synchronous=FULL, so a write is on disk when the statement
+// returns and a crash mid-write leaves the last committed state.
+
+var sqliteJournalSchema = []string{
+	`CREATE TABLE IF NOT EXISTS action_journal (
+		id    INTEGER PRIMARY KEY,
+		at_ns INTEGER NOT NULL,
+		entry TEXT NOT NULL
+	)`,
+}
+
+type sqliteJournal struct {
+	path string
+	db   *sql.DB
+}
+
+func init() {
+	journalBackends["sqlite"] = newSQLiteJournal
+}
+
+func newSQLiteJournal(path string) (journalBackend, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_synchronous=FULL&_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the action journal: %v", err)
+	}
+	// one connection: writes are serialized by journalState anyway
+	db.SetMaxOpenConns(1)
+	for _, stmt := range sqliteJournalSchema {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create the action journal schema: %v", err)
+		}
+	}
+	var mode string
+	if err := db.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil || mode != "wal" {
+		db.Close()
+		return nil, fmt.Errorf("action journal %s isn't in WAL mode (journal_mode=%q, %v)", path, mode, err)
+	}
+	return &sqliteJournal{path: path, db: db}, nil
+}
+
+// load returns the open entries; an unreadable row is skipped
+func (sj *sqliteJournal) load() ([]*journalEntry, error) {
+	rows, err := sj.db.Query(`SELECT entry FROM action_journal ORDER BY at_ns`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the action journal: %v", err)
+	}
+	defer rows.Close()
+	var out []*journalEntry
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return nil, fmt.Errorf("failed to read the action journal: %v", err)
+		}
+		var e journalEntry
+		if err := json.Unmarshal([]byte(raw), &e); err != nil {
+			continue
+		}
+		out = append(out, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the action journal: %v", err)
+	}
+	return out, nil
+}
+
+// append inserts a begin (replacing the row of a re-run) or deletes the row of an end
+func (sj *sqliteJournal) append(e *journalEntry) error {
+	if e.Op == "end" {
+		_, err := sj.db.Exec(`DELETE FROM action_journal WHERE id = ?`, int64(e.ID))
+		return err
+	}
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = sj.db.Exec(`INSERT OR REPLACE INTO action_journal (id, at_ns, entry) VALUES (?, ?, ?)`,
+		int64(e.ID), e.At.UnixNano(), string(raw))
+	return err
+}
+
+// reset replaces the rows with the entries in one transaction
+func (sj *sqliteJournal) reset(entries []*journalEntry) error {
+	tx, err := sj.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to compact the action journal: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM action_journal`); err != nil {
+		return fmt.Errorf("failed to compact the action journal: %v", err)
+	}
+	for _, e := range entries {
+		raw, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO action_journal (id, at_ns, entry) VALUES (?, ?, ?)`,
+			int64(e.ID), e.At.UnixNano(), string(raw)); err != nil {
+			return fmt.Errorf("failed to compact the action journal: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to compact the action journal: %v", err)
+	}
+	// fold the WAL back into the database while nothing is open
+	sj.db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`)
+	return nil
+}
+
+// size is the database plus its write-ahead log
+func (sj *sqliteJournal) size() int64 {
+	var n int64
+	for _, p := range []string{sj.path, sj.path + "-wal"} {
+		if fi, err := os.Stat(p); err == nil {
+			n += fi.Size()
+		}
+	}
+	return n
+}
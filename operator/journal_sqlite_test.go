@@ -0,0 +1,19 @@
+//go:build sqlite
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "actions.db")
+	testJournalBackend(t, func() journalBackend {
+		b, err := newSQLiteJournal(path)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		return b
+	})
+}
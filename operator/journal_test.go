@@ -0,0 +1,49 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testJournalBackend checks that open entries survive reopening the backend
+func testJournalBackend(t *testing.T, open func() journalBackend) {
+	t.Helper()
+	b := open()
+	if err := b.reset(nil); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	now := time.Now()
+	for id, name := range map[uint64]string{1: "restart", 2: "scale", 3: "redeploy"} {
+		e := &journalEntry{ID: id, Op: "begin", At: now.Add(time.Duration(id) * time.Second), Action: &RecoveryAction{Action: name}}
+		if err := b.append(e); err != nil {
+			t.Fatalf("append begin %d: %v", id, err)
+		}
+	}
+	if err := b.append(&journalEntry{ID: 2, Op: "end", At: now, Outcome: "succeeded"}); err != nil {
+		t.Fatalf("append end: %v", err)
+	}
+	// a re-run rewrites its begin with the replay count
+	if err := b.append(&journalEntry{ID: 3, Op: "begin", At: now.Add(3 * time.Second), Action: &RecoveryAction{Action: "redeploy"}, Replays: 1}); err != nil {
+		t.Fatalf("append re-run: %v", err)
+	}
+
+	got, err := open().load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(got) != 2 || got[0].ID != 1 || got[1].ID != 3 {
+		t.Fatalf("open entries = %+v, want 1 and 3 oldest first", got)
+	}
+	if got[0].Action.Action != "restart" || got[1].Replays != 1 {
+		t.Errorf("entries not restored: %+v %+v", got[0], got[1])
+	}
+	if b.size() == 0 {
+		t.Errorf("size is 0 with open entries")
+	}
+}
+
+func TestFileJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "actions.journal")
+	testJournalBackend(t, func() journalBackend { return &fileJournal{path: path} })
+}
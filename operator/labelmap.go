@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Alert label mapping. The operator reads pod, namespace, app, container and
+// node from an alert's labels, but exporters and relabeling rules don't all
+// agree on the names (pod vs pod_name vs kubernetes_pod_name). Before an
+// alert is routed or decided on, every canonical label it lacks is filled in
+// from the first source label that is present and, if the source has a regex,
+// matches it; the first capture group (or the whole match) becomes the value.
+//
+// LABEL_MAPPING_FILE maps canonical labels to their sources, in order. A
+// canonical label listed there replaces its built-in sources (labelSources);
+// the others keep theirs:
+//
+//	pod:
+//	- from: pod_name
+//	- from: kubernetes_pod_name
+//	namespace:
+//	- from: kubernetes_namespace
+//	app:
+//	- from: service
+//	- from: job
+//	  regex: '^kubernetes-(.+)$'
+//	node:
+//	- from: instance
+//	  regex: '^([^:]+):\d+$'
+//
+// Labels an alert already has are never overwritten.
+
+var (
+	labelMappingMu sync.Mutex
+	labelMapping   = compileLabelSources(labelSources)
+	labelMapped    = map[string]int{} // label|from -> count
+)
+
+// labelSources are the built-in sources of the canonical labels
+var labelSources = map[string][]labelSource{
+	"pod":       {{From: "pod_name"}, {From: "kubernetes_pod_name"}},
+	"namespace": {{From: "kubernetes_namespace"}},
+	"container": {{From: "container_name"}},
+	"app":       {{From: "app_kubernetes_io_name"}, {From: "label_app_kubernetes_io_name"}, {From: "label_app"}},
+}
+
+type labelSource struct {
+	From  string `json:"from"`
+	Regex string `json:"regex,omitempty"`
+
+	re *regexp.Regexp
+}
+
+func init() {
+	registerMetrics(writeLabelMappingMetrics)
+}
+
+// setupLabelMapping loads LABEL_MAPPING_FILE, if set
+func setupLabelMapping() {
+	file := os.Getenv("LABEL_MAPPING_FILE")
+	if file == "" {
+		return
+	}
+	if err := loadLabelMapping(file); err != nil {
+		log.Fatalf("LABEL_MAPPING_FILE: %v", err)
+	}
+	labelMappingMu.Lock()
+	n := len(labelMapping)
+	labelMappingMu.Unlock()
+	log.Printf("Alert label mapping loaded — sources for %d canonical label(s)", n)
+}
+
+func loadLabelMapping(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read: %v", err)
+	}
+	var custom map[string][]labelSource
+	if err := yaml.Unmarshal(data, &custom); err != nil {
+		return fmt.Errorf("failed to parse: %v", err)
+	}
+	sources := map[string][]labelSource{}
+	for label, s := range labelSources {
+		sources[label] = s
+	}
+	for label, s := range custom {
+		for _, src := range s {
+			if src.From == "" {
+				return fmt.Errorf("every source of %s needs a from label", label)
+			}
+			if _, err := regexp.Compile(src.Regex); err != nil {
+				return fmt.Errorf("bad regex for %s from %s: %v", label, src.From, err)
+			}
+		}
+		sources[label] = s
+	}
+	compiled := compileLabelSources(sources)
+	labelMappingMu.Lock()
+	labelMapping = compiled
+	labelMappingMu.Unlock()
+	return nil
+}
+
+// compileLabelSources compiles the sources' regexes; they were checked when loaded
+func compileLabelSources(sources map[string][]labelSource) map[string][]labelSource {
+	out := make(map[string][]labelSource, len(sources))
+	for label, s := range sources {
+		for _, src := range s {
+			if src.Regex != "" {
+				src.re = regexp.MustCompile(src.Regex)
+			}
+			out[label] = append(out[label], src)
+		}
+	}
+	return out
+}
+
+// mapAlertLabels fills in the canonical labels the alerts lack, in place
+func mapAlertLabels(alerts []Alert) {
+	labelMappingMu.Lock()
+	defer labelMappingMu.Unlock()
+	for _, alert := range alerts {
+		if alert.Labels == nil {
+			continue
+		}
+		for _, label := range sortedKeys(labelMapping) {
+			if alert.Labels[label] != "" {
+				continue
+			}
+			for _, src := range labelMapping[label] {
+				if v, ok := src.extract(alert.Labels); ok {
+					alert.Labels[label] = v
+					labelMapped[label+"|"+src.From]++
+					break
+				}
+			}
+		}
+	}
+}
+
+// extract returns the source's value for the canonical label, if it has one
+func (s labelSource) extract(labels map[string]string) (string, bool) {
+	v := labels[s.From]
+	if v == "" {
+		return "", false
+	}
+	if s.re == nil {
+		return v, true
+	}
+	m := s.re.FindStringSubmatch(v)
+	switch {
+	case m == nil:
+		return "", false
+	case len(m) > 1:
+		return m[1], m[1] != ""
+	}
+	return m[0], true
+}
+
+func writeLabelMappingMetrics(w io.Writer) {
+	labelMappingMu.Lock()
+	defer labelMappingMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_alert_labels_mapped_total Canonical alert labels filled in by the label mapping, by label and source label.")
+	fmt.Fprintln(w, "# TYPE selfhealing_alert_labels_mapped_total counter")
+	for _, key := range sortedKeys(labelMapped) {
+		label, from, _ := strings.Cut(key, "|")
+		fmt.Fprintf(w, "selfhealing_alert_labels_mapped_total{label=%q,from=%q} %d\n", label, from, labelMapped[key])
+	}
+}
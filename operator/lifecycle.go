@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Request and process lifetimes. Work done while handling a request (deciding
+// and running actions) uses the request's context, so a client that goes
+// away cancels its Kubernetes calls, and each action gets ACTION_TIMEOUT.
+// Work that outlives the request (runbooks, workflows, delayed actions,
+// rollout watches) runs under operatorCtx instead.
+//
+// On SIGTERM the listeners stop accepting, in-flight requests get up to
+// SHUTDOWN_TIMEOUT to finish, and then operatorCtx is cancelled, which stops
+// the background work.
+
+var (
+	operatorCtx, stopOperator = context.WithCancel(context.Background())
+
+	actionTimeout   = envDuration("ACTION_TIMEOUT", 2*time.Minute)
+	shutdownTimeout = envDuration("SHUTDOWN_TIMEOUT", 20*time.Second)
+
+	serversMu sync.Mutex
+	servers   []*http.Server
+)
+
+// runUntilShutdown blocks until a listener fails or the process is asked to stop
+func runUntilShutdown(errs <-chan error) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err := <-errs:
+		log.Fatal(err)
+	case s := <-sig:
+		log.Printf("Received %s — shutting down", s)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	serversMu.Lock()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Listener %s did not shut down cleanly: %v", srv.Addr, err)
+		}
+	}
+	serversMu.Unlock()
+	stopOperator()
+	log.Printf("Shutdown complete")
+}
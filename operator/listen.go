@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Listener addresses. LISTEN_ADDR (and ADMIN_LISTEN_ADDR) is a
+// comma-separated list, each entry either host:port or unix:/path:
+//
+//	:8080                             all interfaces, IPv4 and IPv6 (default)
+//	[::]:8080                         same, written explicitly for IPv6 clusters
+//	0.0.0.0:8080                      IPv4 only
+//	[::]:8080,unix:/run/sh/hook.sock  TCP plus a socket for a sidecar proxy
+//
+// Without them the legacy PORT / ADMIN_PORT settings apply.
+
+// listenAddrs returns the addresses configured by addrKey, falling back to ":<portKey>"
+func listenAddrs(addrKey, portKey, defPort string) []string {
+	if addrs := envList(addrKey); len(addrs) > 0 {
+		return addrs
+	}
+	return []string{":" + envString(portKey, defPort)}
+}
+
+func listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// a socket left behind by a previous run would make Listen fail
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %v", path, err)
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// serveAll serves handler on every address and returns a channel that
+// receives the first server error. The servers stop in runUntilShutdown.
+func serveAll(name string, addrs []string, handler http.Handler) (<-chan error, error) {
+	errs := make(chan error, len(addrs))
+	for _, addr := range addrs {
+		l, err := listen(addr)
+		if err != nil {
+			return nil, fmt.Errorf("%s listener on %s: %v", name, addr, err)
+		}
+		log.Printf("%s listening on %s", name, l.Addr())
+		srv := &http.Server{
+			Addr:        l.Addr().String(),
+			Handler:     handler,
+			BaseContext: func(net.Listener) context.Context { return operatorCtx },
+		}
+		serversMu.Lock()
+		servers = append(servers, srv)
+		serversMu.Unlock()
+		go func() {
+			if err := srv.Serve(l); err != http.ErrServerClosed {
+				errs <- err
+			}
+		}()
+	}
+	return errs, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Decision log sampling: every alert decision is logged while volume is low,
+// but during an alert storm at most LOG_SAMPLE_RATE lines per second per
+// alertname are written (0 logs everything). Dropped lines are summed up in
+// one line per alertname and second, and every decision is still counted in
+// selfhealing_alert_decisions_total, so sampling only affects the log.
+
+type logSampler struct {
+	rate int
+
+	mu        sync.Mutex
+	windows   map[string]*sampleWindow // alertname -> current second
+	dropped   map[string]int           // alertname -> lines dropped in total
+	decisions map[string]int           // alertname|decision -> count
+}
+
+type sampleWindow struct {
+	start   time.Time
+	logged  int
+	dropped int
+}
+
+var decisionLog = &logSampler{
+	rate:      envInt("LOG_SAMPLE_RATE", 20),
+	windows:   map[string]*sampleWindow{},
+	dropped:   map[string]int{},
+	decisions: map[string]int{},
+}
+
+func init() {
+	registerMetrics(decisionLog.writeMetrics)
+}
+
+// logf logs a decision line for an alert unless its alertname is over the rate
+func (s *logSampler) logf(alertname, format string, args ...interface{}) {
+	now := time.Now()
+	s.mu.Lock()
+	if s.rate <= 0 {
+		s.mu.Unlock()
+		log.Printf(format, args...)
+		return
+	}
+	win, ok := s.windows[alertname]
+	if !ok || now.Sub(win.start) >= time.Second {
+		if ok && win.dropped > 0 {
+			log.Printf("Log sampling — dropped %d decision line(s) for alert '%s' in the second from %s",
+				win.dropped, alertname, win.start.Format(time.RFC3339))
+		}
+		win = &sampleWindow{start: now}
+		s.windows[alertname] = win
+	}
+	if win.logged >= s.rate {
+		win.dropped++
+		s.dropped[alertname]++
+		s.mu.Unlock()
+		return
+	}
+	win.logged++
+	s.mu.Unlock()
+	log.Printf(format, args...)
+}
+
+func (s *logSampler) sampleRate() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rate
+}
+
+// count records the outcome of one decision: the action, or the check that skipped it
+func (s *logSampler) count(alertname, decision string) {
+	s.mu.Lock()
+	s.decisions[alertname+"|"+decision]++
+	s.mu.Unlock()
+}
+
+// decisionOutcome names what a decision ended in, for count
+func decisionOutcome(action *RecoveryAction, trace decisionTrace) string {
+	if action != nil {
+		return action.Action
+	}
+	if len(trace) == 0 {
+		return "skip"
+	}
+	return "skip:" + trace[len(trace)-1].Check
+}
+
+func (s *logSampler) writeMetrics(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_alert_decisions_total Alert decisions, by alertname and the action taken or the check that skipped it.")
+	fmt.Fprintln(w, "# TYPE selfhealing_alert_decisions_total counter")
+	for _, key := range sortedKeys(s.decisions) {
+		alertname, decision, _ := strings.Cut(key, "|")
+		fmt.Fprintf(w, "selfhealing_alert_decisions_total{alertname=%q,decision=%q} %d\n", alertname, decision, s.decisions[key])
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_decision_log_dropped_total Decision log lines dropped by sampling, by alertname.")
+	fmt.Fprintln(w, "# TYPE selfhealing_decision_log_dropped_total counter")
+	for _, alertname := range sortedKeys(s.dropped) {
+		fmt.Fprintf(w, "selfhealing_decision_log_dropped_total{alertname=%q} %d\n", alertname, s.dropped[alertname])
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,22 +11,22 @@ import (
 	"sync"
 	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+
+	"self-healing-operator/pkg/actions"
+	"self-healing-operator/pkg/alerts"
 )
 
 // Alert represents a single Alertmanager alert
-type Alert struct {
-	Labels      map[string]string `json:"labels"`
-	Annotations map[string]string `json:"annotations"`
-	Status      string            `json:"status"`
-}
+type Alert = alerts.Alert
 
 // WebhookMessage is the payload sent by Alertmanager
-type WebhookMessage struct {
-	Alerts []Alert `json:"alerts"`
-}
+type WebhookMessage = alerts.WebhookMessage
 
 // RecoveryAction holds the parsed action details from an alert
 type RecoveryAction struct {
@@ -34,11 +35,26 @@ type RecoveryAction struct {
 	Namespace string
 	App       string
 	AlertName string
+	Runbook   string            // set when Action is "runbook"
+	Workflow  string            // set when Action is "workflow"
+	Labels    map[string]string // all alert labels, for actions that need more context
+	Escalate  bool              // page after the action ("+escalate" in a severity mapping)
+	Callback  string            // URL to POST the result to, see callbacks.go
+	Explain   decisionTrace     // how the action was decided, see explain.go
+
+	Enrichment *Enrichment // live pod/node context, see enrich.go
+	StartsAt   time.Time   // when the alert started firing
+	TraceID    string      // see tracing.go
+	parentSpan string      // from the webhook request's traceparent
+	slaTier    int         // 1 when run after a remediation SLA breach, see sla.go
+
+	Changes []ObjectChange // what the action changed, see snapshot.go
+
+	journalID      uint64 // see journal.go
+	journalReplays int
+	rollout        *rolloutWatch // a rollout the action started, see rollout.go
 }
 
-// global k8s client - created once at startup
-var clientset *kubernetes.Clientset
-
 // cooldown: skip recovery if the same app just had an action in the last 3 minutes.
 // This prevents alert→restart→alert→restart infinite loops.
 var (
@@ -53,52 +69,188 @@ var (
 	recoveryCount = map[string]int{}
 )
 
-func isCoolingDown(key string) bool {
+// currentCooldown returns the global cooldown, which the tuning API can change
+func currentCooldown() time.Duration {
+	cooldownMu.Lock()
+	defer cooldownMu.Unlock()
+	return cooldownTime
+}
+
+// isCoolingDown reports whether the key was acted on within the cooldown
+func isCoolingDown(key string, cooldown time.Duration) bool {
 	cooldownMu.Lock()
 	defer cooldownMu.Unlock()
 	last, ok := lastAction[key]
-	return ok && time.Since(last) < cooldownTime
+	return ok && time.Since(last) < cooldown
 }
 
 func recordCooldown(key string) {
+	now := time.Now()
 	cooldownMu.Lock()
-	defer cooldownMu.Unlock()
-	lastAction[key] = time.Now()
+	lastAction[key] = now
+	cooldownMu.Unlock()
+
+	persist("cooldown", func(ctx context.Context) error { return store.SaveCooldown(ctx, key, now) })
 }
 
 func recordRecovery(action string) {
 	recoveryMu.Lock()
 	defer recoveryMu.Unlock()
 	recoveryCount[action]++
-	log.Printf("Recovery totals — restart:%d redeploy:%d scale:%d",
-		recoveryCount["restart"], recoveryCount["redeploy"], recoveryCount["scale"])
+	log.Printf("Recovery totals — restart:%d redeploy:%d scale:%d runbook:%d workflow:%d capacity:%d",
+		recoveryCount["restart"], recoveryCount["redeploy"], recoveryCount["scale"], recoveryCount["runbook"], recoveryCount["workflow"], recoveryCount["capacity"])
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "--validate-config" {
+		os.Exit(runValidateConfig(os.Args[2:]))
+	}
+	// before anything signs or verifies, so the subcommands follow the policy too
+	if err := setupCryptoPolicy(); err != nil {
+		log.Fatalf("Crypto policy: %v", err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify-audit" {
+		os.Exit(runVerifyAudit(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "policy-bundle" {
+		os.Exit(runPolicyBundle(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "register-alertmanager" {
+		os.Exit(runRegisterAlertmanager(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "interruption-agent" {
+		os.Exit(runInterruptionAgent(os.Args[2:]))
+	}
+
 	log.Println("Starting Self-Healing Operator...")
+	tuneRuntime()
+	if operatingMode != modeActive && operatingMode != modeRecommend {
+		log.Fatalf("Invalid MODE %q (want %q or %q)", operatingMode, modeActive, modeRecommend)
+	}
+	log.Printf("Operating mode: %s", operatingMode)
+	if err := setupEgress(); err != nil {
+		log.Fatalf("Invalid egress settings: %v", err)
+	}
+	if err := checkRemediationTags(); err != nil {
+		log.Fatalf("Invalid REMEDIATION_TAGS: %v", err)
+	}
 
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		log.Fatalf("Failed to get in-cluster config: %v", err)
 	}
 
-	clientset, err = kubernetes.NewForConfig(config)
+	kube, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		log.Fatalf("Failed to create Kubernetes client: %v", err)
 	}
 
+	clients.Kube = kube
+	if clients.Dynamic, err = dynamic.NewForConfig(config); err != nil {
+		log.Fatalf("Failed to create dynamic client: %v", err)
+	}
 	log.Println("Connected to Kubernetes cluster")
 
-	http.HandleFunc("/webhook", handleWebhook)
-	http.HandleFunc("/health", handleHealth)
+	if err := setupSecrets(kube); err != nil {
+		log.Fatalf("Failed to resolve credentials: %v", err)
+	}
+
+	if err := setupAuditSigning(); err != nil {
+		log.Fatalf("Failed to set up audit signing: %v", err)
+	}
+	if err := setupStore(config); err != nil {
+		log.Fatalf("Failed to set up state store: %v", err)
+	}
+	setupObservation()
+
+	if envBool("INFORMER_CACHE", true) {
+		if err := startInformers(kube, make(chan struct{})); err != nil {
+			log.Fatalf("Failed to start informers: %v", err)
+		}
+	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	if os.Getenv("SCOPED_CLIENTS") == "true" {
+		clients.Scoped = newScopedClientFactory(kube, config)
+		log.Printf("Scoped clients enabled — acting as ServiceAccount '%s' in each target namespace", clients.Scoped.saName)
+	}
+
+	setupOpenShift(kube)
+	setupCapacity(kube)
+	setupEscalators()
+	setupIssueTrackers()
+	setupJira()
+	setupPreconditions()
+	setupLabelMapping()
+	setupMarkerSinks()
+	setupGrafanaAnnotations()
+	setupCallbacks()
+	setupTracing()
+	setupEventStream()
+
+	if err := loadWorkflows(envString("WORKFLOWS_FILE", "/etc/self-healing/workflows.yaml")); err != nil {
+		log.Fatalf("Failed to load workflows: %v", err)
+	}
+	if err := loadNotificationTemplates(envString("NOTIFICATION_TEMPLATES_FILE", "/etc/self-healing/notifications.yaml")); err != nil {
+		log.Fatalf("Failed to load notification templates: %v", err)
+	}
+	if err := setupActionFlags(); err != nil {
+		log.Fatalf("Failed to load action flags: %v", err)
+	}
+	interrupted, err := setupJournal()
+	if err != nil {
+		log.Fatalf("Failed to open the action journal: %v", err)
 	}
 
-	log.Printf("Listening on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webhook", allowSources(handleWebhook))
+	mux.HandleFunc("/webhook/zabbix", allowSources(handleAdapter("zabbix", adaptZabbix)))
+	mux.HandleFunc("/webhook/nagios", allowSources(handleAdapter("nagios", adaptNagios)))
+	mux.HandleFunc("/webhook/icinga", allowSources(handleAdapter("icinga", adaptNagios)))
+	mux.HandleFunc("/webhook/sns", allowSources(handleAdapter("cloudwatch", adaptSNS)))
+	mux.HandleFunc("/webhook/gcp", allowSources(handleAdapter("gcp", adaptGCP)))
+	mux.HandleFunc("/webhook/azure", allowSources(handleAdapter("azure", adaptAzure)))
+	mux.HandleFunc("/webhook/interruption", allowSources(handleInterruptionNotice))
+	mux.HandleFunc("/health", handleHealth)
+	mux.HandleFunc("/ready", handleReady)
+	mux.HandleFunc("/api/v1/effectiveness", handleEffectiveness)
+	mux.HandleFunc("/api/v1/suppressions", handleSuppressions)
+	mux.HandleFunc("/api/v1/flapping", handleFlapping)
+	mux.HandleFunc("/api/v1/history", handleHistory)
+	mux.HandleFunc("/api/v1/analytics", handleAnalytics)
+	mux.HandleFunc("/api/v1/recommendations", handleRecommendations)
+	mux.HandleFunc("/api/v1/recommendations/approve", handleApproveRecommendation)
+	mux.HandleFunc("/slack/commands", handleSlackCommand)
+	mux.HandleFunc("/slack/interactions", handleSlackInteraction)
+	mux.HandleFunc("/api/v1/observation", handleObservation)
+	mux.HandleFunc("/api/v1/effective-policy", handleEffectivePolicy)
+	mux.HandleFunc("/api/v1/test-alert", allowSources(handleTestAlert))
+	mux.HandleFunc("/api/v1/replay", handleReplay)
+	mux.HandleFunc("/api/v1/active-alerts", handleActiveAlerts)
+	mux.HandleFunc("/api/v1/quota-bumps", handleQuotaBumps)
+	mux.HandleFunc("/api/v1/quota-bumps/approve", handleApproveQuotaBump)
+	mux.HandleFunc("/api/v1/temporary-changes", handleTemporaryChanges)
+
+	startAdminServer()
+	startWatchdog()
+	startSafeMode()
+	startSharding(kube)
+	startGC(kube)
+	startPolicyRules(config)
+	startSelfHealingChecks(config, kube)
+	startQuotaReverts(kube)
+	startRescheduleReverts(kube)
+	startTemporaryReverts(kube)
+	startInterruptionQueue(kube)
+	startWatchAdmissionWebhooks(kube)
+	startNATS()
+	startKafka()
+	recoverJournal(interrupted)
+
+	errs, err := serveAll("Webhook", listenAddrs("LISTEN_ADDR", "PORT", "8080"), mux)
+	if err != nil {
+		log.Fatal(err)
+	}
+	runUntilShutdown(errs)
 }
 
 func handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -123,84 +275,412 @@ func handleWebhook(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Printf("Received %d alert(s)", len(msg.Alerts))
+	alerts := shards.route(r, msg.GroupKey, msg.Alerts)
+	if n := len(alerts); n > 0 {
+		if alerts = deliveries.dedupe(msg.GroupKey, alerts); len(alerts) < n {
+			log.Printf("Dropped %d duplicate alert(s) of group %s", n-len(alerts), msg.GroupKey)
+		}
+	}
+	processAlerts(withTraceParent(r.Context(), r.Header.Get("traceparent")), alerts)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
 
-	for _, alert := range msg.Alerts {
-		if alert.Status != "firing" {
+// processAlerts decides and runs the recovery action for each firing alert;
+// every ingestion path (Alertmanager and the adapters) ends up here
+func processAlerts(ctx context.Context, alerts []Alert) {
+	mapAlertLabels(alerts)
+	jobs := map[string][]workloadJob{}
+	alerts, mixed := collapseBatch(alerts)
+	for _, alert := range alerts {
+		if watchdog.observe(alert) {
 			continue
 		}
-
-		action := parseRecoveryAction(alert)
+		safeMode.observe(alert)
+		jira.observeResolved(alert)
+		observeSLAResolved(alert)
+		observeTemporaryResolved(alert)
+		observeAnalyticsResolved(alert)
+		observeFlaps(alert, mixed[alertFingerprint(alert)])
+		if mixed[alertFingerprint(alert)] {
+			decisionLog.count(alert.Labels["alertname"], "skip:mixed-batch")
+			emitDecision(alert, nil, "skip:mixed-batch", nil)
+			continue
+		}
+		var trace decisionTrace
+		action := decideAlert(ctx, alert, false, &trace)
+		decisionLog.count(alert.Labels["alertname"], decisionOutcome(action, trace))
+		archiveAlert(alert, decisionOutcome(action, trace))
 		if action == nil {
-			log.Printf("No recovery_action label on alert: %s", alert.Labels["alertname"])
+			emitDecision(alert, nil, decisionOutcome(action, trace), trace)
 			continue
 		}
+		action.Explain = trace
+		startTrace(ctx, action)
+		emitDecision(alert, action, action.Action, trace)
+		decisionLog.logf(action.AlertName, "Decided '%s' for alert '%s' (%s)", action.Action, action.AlertName, action.parameters())
 
-		// Cooldown check — skip if this app was just acted on
 		cooldownKey := action.Namespace + "/" + action.App
-		if isCoolingDown(cooldownKey) {
-			log.Printf("Skipping '%s' for %s — cooldown active (last action within %s)",
-				action.Action, cooldownKey, cooldownTime)
+		observing := observation.holds(action)
+		if operatingMode == modeRecommend || requiresApproval(action.Action) || observing {
+			recommendAction(action, alert)
+			recordCooldown(cooldownKey)
 			continue
 		}
 
-		log.Printf("Executing '%s' for alert '%s' (app: %s/%s, pod: %s)",
-			action.Action, action.AlertName, action.Namespace, action.App, action.Pod)
+		jobs[cooldownKey] = append(jobs[cooldownKey], workloadJob{action: action, alert: alert})
+	}
+	runPerWorkload(ctx, jobs)
+}
+
+// decideAlert runs the checks that decide whether an alert leads to an
+// action and returns the action, or nil if it's skipped. A dry run has no
+// side effects (counters, effectiveness stats, escalations); trace, if not
+// nil, receives one step per check.
+func decideAlert(ctx context.Context, alert Alert, dryRun bool, trace *decisionTrace) *RecoveryAction {
+	if alert.Status != "firing" {
+		trace.add("status", traceSkip, "alert is "+alert.Status)
+		return nil
+	}
+	trace.add("status", tracePass, "firing")
+
+	if reason, detail := staleReason(alert, time.Now()); reason != "" {
+		if !dryRun {
+			countStaleAlert(reason)
+			decisionLog.logf(alert.Labels["alertname"], "Ignoring stale alert %s — %s", alert.Labels["alertname"], detail)
+		}
+		trace.add("age", traceSkip, detail)
+		return nil
+	}
+	trace.add("age", tracePass, "")
+
+	if dryRun && wouldShed(alert) || !dryRun && shouldShed(alert) {
+		if !dryRun {
+			decisionLog.logf(alert.Labels["alertname"], "Shedding low-priority alert %s (severity %q) — operator near its memory limit",
+				alert.Labels["alertname"], alert.Labels["severity"])
+		}
+		trace.add("load-shedding", traceSkip, "operator near its memory limit and the alert isn't high priority")
+		return nil
+	}
+	trace.add("load-shedding", tracePass, "")
+
+	action := parseRecoveryAction(alert, trace)
+	if action == nil {
+		if !dryRun {
+			decisionLog.logf(alert.Labels["alertname"], "No recovery action for alert %s (severity %q)", alert.Labels["alertname"], alert.Labels["severity"])
+		}
+		trace.add("action", traceSkip, fmt.Sprintf("no recovery action for severity %q (recovery_actions annotation, recovery_action label, SEVERITY_ACTIONS)", alert.Labels["severity"]))
+		return nil
+	}
+	detail := action.Action
+	if action.Escalate {
+		detail += " (+escalate)"
+	}
+	trace.add("action", tracePass, detail)
+
+	if reason := actionFlagRefusal(action); reason != "" {
+		if !dryRun {
+			decisionLog.logf(alert.Labels["alertname"], "Skipping '%s' for alert '%s' on %s/%s — %s",
+				action.Action, action.AlertName, action.Namespace, action.App, reason)
+		}
+		trace.add("feature-flag", traceSkip, reason)
+		return nil
+	}
+	trace.add("feature-flag", tracePass, "")
+
+	if action.Enrichment = enrichAction(ctx, action); action.Enrichment != nil {
+		trace.add("enrichment", traceInfo, action.Enrichment.String())
+	}
+
+	if !dryRun {
+		effectiveness.observeFiring(action, alert.StartsAt)
+	}
+	if sup := activeSuppression(action); sup != nil {
+		if !dryRun {
+			decisionLog.logf(alert.Labels["alertname"], "Skipping '%s' for alert '%s' on %s/%s — suppressed by #%d until %s",
+				action.Action, action.AlertName, action.Namespace, action.App, sup.ID, sup.ExpiresAt.Format(time.RFC3339))
+		}
+		trace.add("suppression", traceSkip, fmt.Sprintf("suppressed by #%d until %s", sup.ID, sup.ExpiresAt.Format(time.RFC3339)))
+		return nil
+	}
+	trace.add("suppression", tracePass, "")
+
+	if effectiveness.isDisabled(action) {
+		if !dryRun {
+			decisionLog.logf(alert.Labels["alertname"], "Skipping '%s' for alert '%s' — policy auto-disabled for low effectiveness",
+				action.Action, action.AlertName)
+			escalateDisabledPolicy(action, alert)
+			fileIssue(action, alert, "policy "+policyKey(action)+" was auto-disabled because remediations kept failing to fix the alert")
+		}
+		trace.add("effectiveness", traceSkip, "policy "+policyKey(action)+" auto-disabled for low effectiveness")
+		return nil
+	}
+	trace.add("effectiveness", tracePass, "")
+
+	overrides := workloadOverridesFor(ctx, action)
+	if reason := overrides.refusal(action); reason != "" {
+		if !dryRun {
+			decisionLog.logf(alert.Labels["alertname"], "Skipping '%s' for alert '%s' on %s/%s — %s",
+				action.Action, action.AlertName, action.Namespace, action.App, reason)
+		}
+		trace.add("workload-annotations", traceSkip, reason)
+		return nil
+	}
+	trace.add("workload-annotations", tracePass, overrides.String())
 
-		if err := executeRecoveryAction(action); err != nil {
-			log.Printf("Recovery action failed: %v", err)
+	if reason := windowsRefusal(ctx, action); reason != "" {
+		if !dryRun {
+			decisionLog.logf(alert.Labels["alertname"], "Skipping '%s' for alert '%s' on %s/%s — %s",
+				action.Action, action.AlertName, action.Namespace, action.App, reason)
+		}
+		trace.add("platform", traceSkip, reason)
+		return nil
+	}
+	trace.add("platform", tracePass, "")
+
+	// Cooldown check — skip if this app was just acted on
+	cooldownKey := action.Namespace + "/" + action.App
+	cooldown := overrides.cooldownFor()
+	if isCoolingDown(cooldownKey, cooldown) {
+		if !dryRun {
+			decisionLog.logf(alert.Labels["alertname"], "Skipping '%s' for %s — cooldown active (last action within %s)",
+				action.Action, cooldownKey, cooldown)
+		}
+		trace.add("cooldown", traceSkip, fmt.Sprintf("%s was acted on within the last %s", cooldownKey, cooldown))
+		return nil
+	}
+	trace.add("cooldown", tracePass, "")
+
+	if at, ok := spreadScheduled(cooldownKey); ok {
+		if !dryRun {
+			decisionLog.logf(alert.Labels["alertname"], "Skipping '%s' for %s — an action is already scheduled for %s",
+				action.Action, cooldownKey, at.Format(time.RFC3339))
+		}
+		trace.add("spread", traceSkip, "an action on "+cooldownKey+" is already scheduled for "+at.Format(time.RFC3339))
+		return nil
+	}
+	trace.add("spread", tracePass, "")
+	trace.add("parameters", traceInfo, action.parameters())
+	return action
+}
+
+// performAction executes a recovery action and records its outcome
+// (history, cooldown, totals, effectiveness, escalation on failure)
+func performAction(ctx context.Context, action *RecoveryAction, alert Alert) error {
+	ctx, cancel := context.WithTimeout(ctx, actionTimeout)
+	defer cancel()
+	if !isBackgroundAction(action.Action) {
+		// runbooks and workflows take the workload lock for their whole run
+		unlock, err := workloadLocks.lock(ctx, workloadKey(action))
+		if err != nil {
+			log.Printf("Skipping '%s' for alert '%s' — %v", action.Action, action.AlertName, err)
+			pipeline.dequeue(action)
+			return err
+		}
+		defer unlock()
+		action.Explain.add("workload-lock", tracePass, "")
+	}
+	done := pipeline.begin(action)
+	defer done()
+	// a flag may have been turned off while the action waited
+	if reason := actionFlagRefusal(action); reason != "" {
+		log.Printf("Skipping '%s' for alert '%s' on %s/%s — %s", action.Action, action.AlertName, action.Namespace, action.App, reason)
+		return errors.New(reason)
+	}
+	if reason := workloadOverridesFor(ctx, action).pauseRefusal(action); reason != "" {
+		log.Printf("Skipping '%s' for alert '%s' on %s/%s — %s", action.Action, action.AlertName, action.Namespace, action.App, reason)
+		return errors.New(reason)
+	}
+	if reason := checkPreconditions(ctx, action); reason != "" {
+		log.Printf("Skipping '%s' for alert '%s' on %s/%s — %s", action.Action, action.AlertName, action.Namespace, action.App, reason)
+		return errors.New(reason)
+	}
+	if reason := temporaryRefusal(action, alert); reason != "" {
+		log.Printf("Skipping '%s' for alert '%s' on %s/%s — %s", action.Action, action.AlertName, action.Namespace, action.App, reason)
+		return errors.New(reason)
+	}
+	if reason := actionRateRefusal(); reason != "" {
+		log.Printf("Skipping '%s' for alert '%s' on %s/%s — %s", action.Action, action.AlertName, action.Namespace, action.App, reason)
+		return errors.New(reason)
+	}
+
+	log.Printf("Executing '%s' for alert '%s' (app: %s/%s, pod: %s)",
+		action.Action, action.AlertName, action.Namespace, action.App, action.Pod)
+
+	if err := actionJournal.begin(action, alert); err != nil {
+		log.Printf("Not executing '%s' for alert '%s' — %v", action.Action, action.AlertName, err)
+		escalate(action, alert, err.Error())
+		return err
+	}
+	startSLA(action, alert)
+	started := time.Now()
+	err := executeRecoveryAction(ctx, clients, action, alert)
+	if !isBackgroundAction(action.Action) {
+		// runbooks and workflows record their own history when they finish,
+		// actions that started a rollout when it has
+		rec := newActionRecord(action, "", started)
+		if err == nil && action.rollout != nil {
+			go watchRollout(*action.rollout, action, alert, rec)
 		} else {
-			log.Printf("Recovery action '%s' completed OK", action.Action)
-			recordCooldown(cooldownKey)
-			recordRecovery(action.Action)
+			recordHistory(rec, err)
 		}
+	} else if err != nil {
+		actionJournal.end(action.journalID, "failed")
+	}
+	if err != nil {
+		log.Printf("Recovery action failed: %v", err)
+		escalate(action, alert, err.Error())
+		return err
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
+	log.Printf("Recovery action '%s' completed OK", action.Action)
+	if err := registerTemporary(ctx, action, alert); err != nil {
+		log.Printf("Temporary '%s' for %s/%s won't be reverted — %v", action.Action, action.Namespace, action.App, err)
+		escalate(action, alert, "'"+action.Action+"' was meant to be temporary, but won't be reverted automatically: "+err.Error())
+	}
+	if action.Escalate {
+		escalate(action, alert, "'"+action.Action+"' ran for a "+alert.Labels["severity"]+" alert; escalating per severity mapping")
+	}
+	recordCooldown(action.Namespace + "/" + action.App)
+	recordRecovery(action.Action)
+	effectiveness.recordRemediation(action)
+	return nil
 }
 
-func parseRecoveryAction(alert Alert) *RecoveryAction {
-	recoveryAction := alert.Labels["recovery_action"]
+func parseRecoveryAction(alert Alert, trace *decisionTrace) *RecoveryAction {
+	recoveryAction, escalate := selectAction(alert, trace)
 	if recoveryAction == "" {
 		return nil
 	}
 
-	namespace := alert.Labels["namespace"]
-	if namespace == "" {
-		namespace = "default"
-	}
-
+	target := alert.Target()
 	return &RecoveryAction{
 		Action:    recoveryAction,
-		Pod:       alert.Labels["pod"],
-		Namespace: namespace,
-		App:       alert.Labels["app"],
-		AlertName: alert.Labels["alertname"],
+		Pod:       target.Pod,
+		Namespace: target.Namespace,
+		App:       target.App,
+		AlertName: target.AlertName,
+		Runbook:   target.Runbook,
+		Workflow:  target.Workflow,
+		Labels:    alert.Labels,
+		Escalate:  escalate,
+		Callback:  callbackURLFor(alert),
+		StartsAt:  alert.StartsAt,
 	}
 }
 
-func executeRecoveryAction(action *RecoveryAction) error {
-	ctx := context.Background()
-	switch action.Action {
-	case "restart":
-		return restartPod(ctx, action)
-	case "redeploy":
-		return redeployDeployment(ctx, action)
-	case "scale":
-		return scaleDeployment(ctx, action)
-	default:
-		return fmt.Errorf("unknown recovery action: %s", action.Action)
-	}
+// isBackgroundAction reports whether the action only starts work that finishes later
+func isBackgroundAction(action string) bool {
+	return action == "runbook" || action == "workflow" || action == "capacity"
+}
+
+// recoveryActions runs the operator's actions by name. They need more than an
+// actions.Action gets, so executeRecoveryAction hands them the clients, the
+// parsed action and the alert through the context.
+var recoveryActions *actions.Executor
+
+type recoveryCallKey struct{}
+
+type recoveryCall struct {
+	clients *Clients
+	action  *RecoveryAction
+	alert   Alert
+}
+
+func init() {
+	recoveryActions = actions.NewExecutor(
+		recoveryAction("restart", func(ctx context.Context, c *Clients, action *RecoveryAction, _ Alert) error {
+			return restartPod(ctx, c, action)
+		}),
+		recoveryAction("redeploy", func(ctx context.Context, c *Clients, action *RecoveryAction, _ Alert) error {
+			return redeployDeployment(ctx, c, action)
+		}),
+		recoveryAction("scale", scaleDeployment),
+		recoveryAction("notify", func(_ context.Context, _ *Clients, action *RecoveryAction, alert Alert) error {
+			// notify-only: no change to the cluster, just tell a human
+			escalate(action, alert, "alert "+action.AlertName+" ("+alert.Labels["severity"]+") needs attention; no automatic action configured")
+			return nil
+		}),
+		recoveryAction("runbook", startRunbook),
+		recoveryAction("workflow", startWorkflow),
+		recoveryAction("capacity", startCapacity),
+		recoveryAction("resolve_stuck_rollout", resolveStuckRollout),
+		recoveryAction("reschedule_elsewhere", func(ctx context.Context, c *Clients, action *RecoveryAction, _ Alert) error {
+			return rescheduleElsewhere(ctx, c, action)
+		}),
+		recoveryAction("apply_vpa_recommendation", func(ctx context.Context, c *Clients, action *RecoveryAction, _ Alert) error {
+			return applyVPA(ctx, c, action)
+		}),
+		recoveryAction("clear_finalizers", func(ctx context.Context, c *Clients, action *RecoveryAction, _ Alert) error {
+			return clearFinalizers(ctx, c, action)
+		}),
+		recoveryAction("webhook_fail_open", func(ctx context.Context, c *Clients, action *RecoveryAction, _ Alert) error {
+			return bypassWebhook(ctx, c, action)
+		}),
+		recoveryAction("disable_webhook", func(ctx context.Context, c *Clients, action *RecoveryAction, _ Alert) error {
+			return bypassWebhook(ctx, c, action)
+		}),
+		recoveryAction("node_cleanup", func(ctx context.Context, c *Clients, action *RecoveryAction, _ Alert) error {
+			return nodeCleanup(ctx, c, action)
+		}),
+		recoveryAction("patch_resource", func(ctx context.Context, c *Clients, action *RecoveryAction, alert Alert) error {
+			return patchResource(ctx, c, action, resourceParams(alert))
+		}),
+		recoveryAction("delete_resource", func(ctx context.Context, c *Clients, action *RecoveryAction, alert Alert) error {
+			return deleteResource(ctx, c, action, resourceParams(alert))
+		}),
+	)
+	recoveryActionNames = recoveryActions.Names()
+}
+
+// recoveryAction adapts one of the operator's actions to actions.Action
+func recoveryAction(name string, fn func(ctx context.Context, c *Clients, action *RecoveryAction, alert Alert) error) actions.Action {
+	return actions.Func(name, func(ctx context.Context, _ kubernetes.Interface, _ alerts.Target) error {
+		call, ok := ctx.Value(recoveryCallKey{}).(recoveryCall)
+		if !ok {
+			return fmt.Errorf("%s must be run through executeRecoveryAction", name)
+		}
+		return fn(ctx, call.clients, call.action, call.alert)
+	})
+}
+
+func executeRecoveryAction(ctx context.Context, c *Clients, action *RecoveryAction, alert Alert) error {
+	ctx = context.WithValue(ctx, recoveryCallKey{}, recoveryCall{clients: c, action: action, alert: alert})
+	return recoveryActions.Execute(ctx, c.Kube, action.Action, alert.Target())
 }
 
 // restartPod deletes the pod - Kubernetes recreates it via the ReplicaSet
-func restartPod(ctx context.Context, action *RecoveryAction) error {
+func restartPod(ctx context.Context, c *Clients, action *RecoveryAction) error {
 	if action.Pod == "" {
 		return fmt.Errorf("no pod name in alert labels for restart action")
 	}
 
+	cs, err := c.For(ctx, action.Namespace)
+	if err != nil {
+		return err
+	}
+
+	pod := cachedPod(action.Namespace, action.Pod)
+	if pod == nil {
+		if live, err := cs.CoreV1().Pods(action.Namespace).Get(ctx, action.Pod, metav1.GetOptions{}); err == nil {
+			pod = live
+		}
+	}
+	if pod != nil && pod.DeletionTimestamp != nil {
+		log.Printf("Pod %s/%s is already terminating — nothing to do", action.Namespace, action.Pod)
+		return nil
+	}
+	if pod != nil {
+		// nodes and other namespaces' pods need the operator's own cluster-wide client
+		if err := checkRescheduleFeasible(ctx, c.Kube, pod); err != nil {
+			action.Explain.add("reschedule-feasibility", traceSkip, err.Error())
+			return err
+		}
+	}
+
 	log.Printf("Deleting pod %s/%s", action.Namespace, action.Pod)
-	err := clientset.CoreV1().Pods(action.Namespace).Delete(ctx, action.Pod, metav1.DeleteOptions{})
+	err = cs.CoreV1().Pods(action.Namespace).Delete(ctx, action.Pod, metav1.DeleteOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to delete pod %s/%s: %v", action.Namespace, action.Pod, err)
 	}
@@ -209,61 +689,103 @@ func restartPod(ctx context.Context, action *RecoveryAction) error {
 }
 
 // redeployDeployment triggers a rolling restart by bumping an annotation
-func redeployDeployment(ctx context.Context, action *RecoveryAction) error {
-	deployments, err := clientset.AppsV1().Deployments(action.Namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: "app=" + action.App,
-	})
+func redeployDeployment(ctx context.Context, c *Clients, action *RecoveryAction) error {
+	cs, err := c.For(ctx, action.Namespace)
 	if err != nil {
-		return fmt.Errorf("failed to list deployments: %v", err)
-	}
-	if len(deployments.Items) == 0 {
-		return fmt.Errorf("no deployment with label app=%s in namespace %s", action.App, action.Namespace)
+		return err
 	}
 
-	dep := deployments.Items[0]
-	if dep.Spec.Template.Annotations == nil {
-		dep.Spec.Template.Annotations = make(map[string]string)
+	dep, err := findDeployment(ctx, cs, action.Namespace, action.App)
+	if err != nil {
+		if dc := findDeploymentConfig(ctx, cs, action.Namespace, action.App); dc != nil {
+			return rolloutLatest(ctx, cs, action, dc)
+		}
+		return err
 	}
-	dep.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
-
-	_, err = clientset.AppsV1().Deployments(action.Namespace).Update(ctx, &dep, metav1.UpdateOptions{})
+	if err := verifyPodImages(ctx, dep.Spec.Template.Spec); err != nil {
+		return fmt.Errorf("refusing to redeploy %s/%s: %v", action.Namespace, dep.Name, err)
+	}
+	restartedAt := time.Now().Format(time.RFC3339)
+	before, updated, err := updateDeployment(ctx, cs, dep, func(dep *appsv1.Deployment) error {
+		if dep.Spec.Template.Annotations == nil {
+			dep.Spec.Template.Annotations = make(map[string]string)
+		}
+		dep.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] = restartedAt
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to update deployment %s/%s: %v", action.Namespace, dep.Name, err)
+		return err
 	}
+	recordChange(action, "Deployment", updated.Namespace, updated.Name, before, updated)
 	log.Printf("Rolling restart triggered for deployment %s/%s", action.Namespace, dep.Name)
+	action.rollout = &rolloutWatch{cs: cs, namespace: action.Namespace, name: updated.Name, generation: updated.Generation}
+	emitDeployMarker(action, updated.Name, updated.Generation)
 	return nil
 }
 
-// scaleDeployment adds one replica to the deployment
-func scaleDeployment(ctx context.Context, action *RecoveryAction) error {
-	deployments, err := clientset.AppsV1().Deployments(action.Namespace).List(ctx, metav1.ListOptions{
-		LabelSelector: "app=" + action.App,
-	})
+// scaleDeployment adds SCALE_STEP replicas to the deployment (or DeploymentConfig)
+func scaleDeployment(ctx context.Context, c *Clients, action *RecoveryAction, alert Alert) error {
+	cs, err := c.For(ctx, action.Namespace)
 	if err != nil {
-		return fmt.Errorf("failed to list deployments: %v", err)
-	}
-	if len(deployments.Items) == 0 {
-		return fmt.Errorf("no deployment with label app=%s in namespace %s", action.App, action.Namespace)
+		return err
 	}
 
-	dep := deployments.Items[0]
+	dep, err := findDeployment(ctx, cs, action.Namespace, action.App)
+	var dc *unstructured.Unstructured
+	if err != nil {
+		if dc = findDeploymentConfig(ctx, cs, action.Namespace, action.App); dc == nil {
+			return err
+		}
+		if dep, err = dcAsDeployment(dc); err != nil {
+			return err
+		}
+	}
 
 	currentReplicas := int32(1)
 	if dep.Spec.Replicas != nil {
 		currentReplicas = *dep.Spec.Replicas
 	}
-	newReplicas := currentReplicas + 1
+	step := int32(scaleStep)
+	if step < 1 {
+		step = 1
+	}
+	ov := parseWorkloadAnnotations(action.Namespace+"/"+dep.Name, dep.Annotations).inherit(namespaceOverrides(ctx, action))
+	if limit := ov.maxReplicas; limit > 0 {
+		if currentReplicas >= limit {
+			return fmt.Errorf("%s/%s already has %d replicas (%s: %d on %s)", action.Namespace, dep.Name, currentReplicas, annoMaxReplicas, limit, ov.sources[annoMaxReplicas])
+		}
+		if currentReplicas+step > limit {
+			step = limit - currentReplicas
+		}
+	}
+	step, err = checkScaleQuota(ctx, cs, dep, step, action, alert)
+	if err != nil {
+		return err
+	}
+	newReplicas := currentReplicas + step
 
-	scale, err := clientset.AppsV1().Deployments(action.Namespace).GetScale(ctx, dep.Name, metav1.GetOptions{})
+	if dc != nil {
+		updated, err := scaleDeploymentConfig(ctx, cs, dc, newReplicas)
+		if err != nil {
+			return err
+		}
+		recordChange(action, "DeploymentConfig", dc.GetNamespace(), dc.GetName(), dc.Object, updated.Object)
+		log.Printf("DeploymentConfig %s/%s scaled %d -> %d replicas", action.Namespace, dep.Name, currentReplicas, newReplicas)
+		return nil
+	}
+	scale, err := cs.AppsV1().Deployments(action.Namespace).GetScale(ctx, dep.Name, metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to get scale for %s/%s: %v", action.Namespace, dep.Name, err)
 	}
 
 	scale.Spec.Replicas = newReplicas
-	_, err = clientset.AppsV1().Deployments(action.Namespace).UpdateScale(ctx, dep.Name, scale, metav1.UpdateOptions{})
+	_, err = cs.AppsV1().Deployments(action.Namespace).UpdateScale(ctx, dep.Name, scale, metav1.UpdateOptions{})
 	if err != nil {
 		return fmt.Errorf("failed to scale %s/%s: %v", action.Namespace, dep.Name, err)
 	}
+	if after, err := cs.AppsV1().Deployments(action.Namespace).Get(ctx, dep.Name, metav1.GetOptions{}); err == nil {
+		recordChange(action, "Deployment", dep.Namespace, dep.Name, dep, after)
+	}
 	log.Printf("Deployment %s/%s scaled %d -> %d replicas", action.Namespace, dep.Name, currentReplicas, newReplicas)
 	return nil
-}
\ No newline at end of file
+}
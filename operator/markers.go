@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Deploy markers: whenever the operator starts a new rollout (redeploy, or a
+// runbook/workflow step that changes the pod template) it drops a marker into
+// the observability tools that are configured, so dashboards show exactly
+// when the healer intervened.
+
+// DeployMarker describes one operator-initiated rollout
+type DeployMarker struct {
+	Action     string
+	AlertName  string
+	Namespace  string
+	Deployment string
+	Version    string // new deployment generation
+	Message    string
+	Time       time.Time
+}
+
+// MarkerSink writes deploy markers to one tool
+type MarkerSink interface {
+	Name() string
+	Mark(ctx context.Context, m DeployMarker) error
+}
+
+var markerSinks []MarkerSink
+
+func setupMarkerSinks() {
+	if os.Getenv("HONEYCOMB_API_KEY") != "" {
+		markerSinks = append(markerSinks, &honeycombSink{
+			apiURL:  envString("HONEYCOMB_API_URL", "https://api.honeycomb.io"),
+			apiKey:  credential("HONEYCOMB_API_KEY"),
+			dataset: envString("HONEYCOMB_DATASET", "__all__"),
+		})
+	}
+	if g := newGrafanaClient(); g != nil {
+		markerSinks = append(markerSinks, g)
+	}
+	if u := os.Getenv("ELASTIC_APM_KIBANA_URL"); u != "" {
+		markerSinks = append(markerSinks, &elasticSink{
+			kibanaURL: strings.TrimRight(u, "/"),
+			apiKey:    credential("ELASTIC_APM_API_KEY"),
+		})
+	}
+	for _, s := range markerSinks {
+		log.Printf("Deploy markers via %s enabled", s.Name())
+	}
+}
+
+// emitDeployMarker sends a marker to every sink in the background
+func emitDeployMarker(action *RecoveryAction, deployment string, generation int64) {
+	if len(markerSinks) == 0 {
+		return
+	}
+	m := DeployMarker{
+		Action:     action.Action,
+		AlertName:  action.AlertName,
+		Namespace:  action.Namespace,
+		Deployment: deployment,
+		Version:    fmt.Sprintf("generation-%d", generation),
+		Message:    fmt.Sprintf("self-healing %s of %s/%s (alert %s)", action.Action, action.Namespace, deployment, action.AlertName),
+		Time:       time.Now(),
+	}
+	for _, sink := range markerSinks {
+		go func(sink MarkerSink) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := sink.Mark(ctx, m); err != nil {
+				log.Printf("Deploy marker via %s failed: %v", sink.Name(), err)
+			}
+		}(sink)
+	}
+}
+
+// honeycombSink uses the Honeycomb Markers API
+type honeycombSink struct {
+	apiURL  string
+	apiKey  credential
+	dataset string
+}
+
+func (h *honeycombSink) Name() string { return "honeycomb" }
+
+func (h *honeycombSink) Mark(ctx context.Context, m DeployMarker) error {
+	body := map[string]interface{}{
+		"message":    m.Message,
+		"type":       "self-healing-" + m.Action,
+		"start_time": m.Time.Unix(),
+	}
+	return postJSON(ctx, "honeycomb", h.apiURL+"/1/markers/"+url.PathEscape(h.dataset), body, map[string]string{
+		"X-Honeycomb-Team": h.apiKey.value(),
+	})
+}
+
+// elasticSink creates APM service annotations through Kibana. The
+// deployment name is used as the APM service name.
+type elasticSink struct {
+	kibanaURL string
+	apiKey    credential
+}
+
+func (e *elasticSink) Name() string { return "elastic-apm" }
+
+func (e *elasticSink) Mark(ctx context.Context, m DeployMarker) error {
+	body := map[string]interface{}{
+		"@timestamp": m.Time.UTC().Format(time.RFC3339),
+		"service":    map[string]string{"version": m.Version},
+		"message":    m.Message,
+		"tags":       []string{"self-healing", m.Action, m.Namespace},
+	}
+	headers := map[string]string{"kbn-xsrf": "true"}
+	if key := e.apiKey.value(); key != "" {
+		headers["Authorization"] = "ApiKey " + key
+	}
+	return postJSON(ctx, "elastic", e.kibanaURL+"/api/apm/services/"+url.PathEscape(m.Deployment)+"/annotation", body, headers)
+}
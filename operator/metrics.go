@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Minimal Prometheus text-format exporter. Each feature registers a writer
+// that prints its own series; /metrics just runs them all in order.
+
+var (
+	metricsMu      sync.Mutex
+	metricsWriters []func(w io.Writer)
+)
+
+func registerMetrics(fn func(w io.Writer)) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	metricsWriters = append(metricsWriters, fn)
+}
+
+func init() {
+	registerMetrics(writeRecoveryMetrics)
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	metricsMu.Lock()
+	writers := append([]func(io.Writer){}, metricsWriters...)
+	metricsMu.Unlock()
+
+	var buf bytes.Buffer
+	if tracingEnabled() && strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text") {
+		// exemplars are only valid in OpenMetrics, see tracing.go
+		for _, fn := range writers {
+			fn(exemplarWriter{&buf})
+		}
+		fmt.Fprintln(&buf, "# EOF")
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		w.Write(buf.Bytes())
+		return
+	}
+	for _, fn := range writers {
+		fn(&buf)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+// exemplarWriter tells metric writers the scraper accepts exemplars
+type exemplarWriter struct{ io.Writer }
+
+func writeRecoveryMetrics(w io.Writer) {
+	recoveryMu.Lock()
+	defer recoveryMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_recoveries_total Recovery actions completed successfully.")
+	fmt.Fprintln(w, "# TYPE selfhealing_recoveries_total counter")
+	for _, action := range sortedKeys(recoveryCount) {
+		fmt.Fprintf(w, "selfhealing_recoveries_total{action=%q} %d\n", action, recoveryCount[action])
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
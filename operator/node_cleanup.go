@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Node disk cleanup. `recovery_action: node_cleanup` frees disk on the node
+// in the alert's node label with a privileged Job pinned to it, which enters
+// the host's namespaces and
+//   - truncates container logs under /var/log/pods larger than
+//     NODE_CLEANUP_LOG_MAX_SIZE, and deletes rotated ones that large (ship
+//     logs elsewhere if they matter: the truncated part is gone)
+//   - if the image filesystem (NODE_CLEANUP_IMAGE_FS) is still at least
+//     NODE_CLEANUP_THRESHOLD percent full, removes unused images with
+//     `crictl rmi --prune` (the node's own crictl)
+//
+// Only nodes matching NODE_CLEANUP_NODES (comma-separated names or globs,
+// none by default) are cleaned. The Job runs in NODE_CLEANUP_NAMESPACE as
+// system-node-critical, so the kubelet admits it despite DiskPressure, and
+// tolerates every taint; the namespace has to allow privileged pods. The
+// disk usage before and after is kept in the action's trace.
+//
+//	- alert: NodeDiskPressure
+//	  expr: kube_node_status_condition{condition="DiskPressure",status="true"} == 1
+//	  labels:
+//	    recovery_action: node_cleanup
+
+var (
+	nodeCleanupNodes     = envList("NODE_CLEANUP_NODES")
+	nodeCleanupNamespace = envString("NODE_CLEANUP_NAMESPACE", envString("POD_NAMESPACE", "default"))
+	nodeCleanupImage     = envString("NODE_CLEANUP_IMAGE", "busybox:1.36")
+	nodeCleanupLogMax    = envString("NODE_CLEANUP_LOG_MAX_SIZE", "100Mi")
+	nodeCleanupThreshold = envInt("NODE_CLEANUP_THRESHOLD", 80)
+	nodeCleanupImageFS   = envString("NODE_CLEANUP_IMAGE_FS", "/var/lib/containerd")
+	nodeCleanupTimeout   = envDuration("NODE_CLEANUP_TIMEOUT", 5*time.Minute)
+
+	nodeCleanupMu      sync.Mutex
+	nodeCleanupResults = map[string]int{} // result
+	nodeCleanupFreed   = map[string]int{} // node -> percentage points freed, last run
+)
+
+func init() {
+	registerMetrics(writeNodeCleanupMetrics)
+}
+
+// nodeCleanupScript runs in the host's namespaces; it prints "usage <percent>"
+// before and after so the operator can report what it freed
+const nodeCleanupScript = `set -u
+usage() { df -P "$IMAGE_FS" | awk 'NR==2 { sub("%", "", $5); print $5 }'; }
+echo "usage $(usage)"
+find /var/log/pods -type f -name '*.log' -size +"$LOG_MAX_KB"k -print -exec truncate -s 0 {} \;
+find /var/log/pods -type f -name '*.log.*' -size +"$LOG_MAX_KB"k -print -delete
+if [ "$(usage)" -ge "$THRESHOLD" ]; then
+  crictl rmi --prune
+fi
+echo "usage $(usage)"
+`
+
+// nodeCleanupAllowed reports whether the node matches NODE_CLEANUP_NODES
+func nodeCleanupAllowed(node string) bool {
+	for _, pattern := range nodeCleanupNodes {
+		if ok, _ := path.Match(pattern, node); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeCleanup(ctx context.Context, c *Clients, action *RecoveryAction) error {
+	node := action.Labels["node"]
+	if node == "" {
+		return fmt.Errorf("no node label in alert for node_cleanup")
+	}
+	if !nodeCleanupAllowed(node) {
+		countNodeCleanup("refused")
+		return fmt.Errorf("node %s doesn't match NODE_CLEANUP_NODES", node)
+	}
+	logMax, err := resource.ParseQuantity(nodeCleanupLogMax)
+	if err != nil {
+		return fmt.Errorf("invalid NODE_CLEANUP_LOG_MAX_SIZE %q: %v", nodeCleanupLogMax, err)
+	}
+
+	// the Job is pinned to a node, so it uses the operator's own client
+	backoff := int32(0)
+	deadline := int64(nodeCleanupTimeout.Seconds())
+	ttl := int32(time.Hour.Seconds())
+	privileged := true
+	meta := metav1.ObjectMeta{GenerateName: "selfhealing-node-cleanup-", Labels: map[string]string{"selfhealing.io/node": node}}
+	markOwned(&meta, time.Hour)
+	job := &batchv1.Job{
+		ObjectMeta: meta,
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoff,
+			ActiveDeadlineSeconds:   &deadline,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{managedByLabel: managedByValue}},
+				Spec: corev1.PodSpec{
+					RestartPolicy:     corev1.RestartPolicyNever,
+					NodeName:          node,
+					HostPID:           true,
+					PriorityClassName: "system-node-critical",
+					Tolerations:       []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+					Containers: []corev1.Container{{
+						Name:  "cleanup",
+						Image: nodeCleanupImage,
+						// PID 1 is the host's init: enter its mount namespace to see the host's files and crictl
+						Command: []string{"nsenter", "-t", "1", "-m", "--", "sh", "-c", nodeCleanupScript},
+						Env: []corev1.EnvVar{
+							{Name: "IMAGE_FS", Value: nodeCleanupImageFS},
+							{Name: "LOG_MAX_KB", Value: strconv.FormatInt(logMax.Value()/1024, 10)},
+							{Name: "THRESHOLD", Value: strconv.Itoa(nodeCleanupThreshold)},
+						},
+						SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+					}},
+				},
+			},
+		},
+	}
+	jobs := c.Kube.BatchV1().Jobs(nodeCleanupNamespace)
+	created, err := jobs.Create(ctx, job, metav1.CreateOptions{})
+	if err != nil {
+		countNodeCleanup("failed")
+		return fmt.Errorf("failed to create cleanup job on node %s: %v", node, err)
+	}
+	log.Printf("Started cleanup job %s/%s on node %s", nodeCleanupNamespace, created.Name, node)
+
+	var failed bool
+	err = wait.PollUntilContextTimeout(ctx, 5*time.Second, nodeCleanupTimeout+time.Minute, true, func(ctx context.Context) (bool, error) {
+		j, err := jobs.Get(ctx, created.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		if j.Status.Succeeded > 0 {
+			return true, nil
+		}
+		if j.Status.Failed > 0 {
+			failed = true
+			return true, nil
+		}
+		return false, nil
+	})
+	if err != nil {
+		countNodeCleanup("failed")
+		return fmt.Errorf("cleanup job %s/%s on node %s didn't finish: %v", nodeCleanupNamespace, created.Name, node, err)
+	}
+	output := nodeCleanupOutput(ctx, c, created.Name)
+	if failed {
+		countNodeCleanup("failed")
+		return fmt.Errorf("cleanup job %s/%s on node %s failed: %s", nodeCleanupNamespace, created.Name, node, lastLine(strings.TrimSpace(output)))
+	}
+
+	before, after, ok := diskUsage(output)
+	countNodeCleanup("succeeded")
+	if !ok {
+		log.Printf("Cleaned up node %s", node)
+		return nil
+	}
+	nodeCleanupMu.Lock()
+	nodeCleanupFreed[node] = before - after
+	nodeCleanupMu.Unlock()
+	log.Printf("Cleaned up node %s — %s at %d%% before, %d%% after", node, nodeCleanupImageFS, before, after)
+	action.Explain.add("node_cleanup", traceInfo, fmt.Sprintf("%s on %s: %d%% → %d%%", nodeCleanupImageFS, node, before, after))
+	return nil
+}
+
+// nodeCleanupOutput reads the cleanup pod's log, or "" if it can't
+func nodeCleanupOutput(ctx context.Context, c *Clients, job string) string {
+	pods, err := c.Kube.CoreV1().Pods(nodeCleanupNamespace).List(ctx, metav1.ListOptions{LabelSelector: "job-name=" + job})
+	if err != nil || len(pods.Items) == 0 {
+		return ""
+	}
+	limit := int64(64 * 1024)
+	stream, err := c.Kube.CoreV1().Pods(nodeCleanupNamespace).GetLogs(pods.Items[0].Name, &corev1.PodLogOptions{LimitBytes: &limit}).Stream(ctx)
+	if err != nil {
+		return ""
+	}
+	defer stream.Close()
+	data, _ := io.ReadAll(stream)
+	return string(data)
+}
+
+// diskUsage finds the first and last "usage <percent>" lines of the script's output
+func diskUsage(output string) (before, after int, ok bool) {
+	var seen []int
+	for _, line := range strings.Split(output, "\n") {
+		if v, found := strings.CutPrefix(strings.TrimSpace(line), "usage "); found {
+			if n, err := strconv.Atoi(v); err == nil {
+				seen = append(seen, n)
+			}
+		}
+	}
+	if len(seen) < 2 {
+		return 0, 0, false
+	}
+	return seen[0], seen[len(seen)-1], true
+}
+
+func countNodeCleanup(result string) {
+	nodeCleanupMu.Lock()
+	nodeCleanupResults[result]++
+	nodeCleanupMu.Unlock()
+}
+
+func writeNodeCleanupMetrics(w io.Writer) {
+	nodeCleanupMu.Lock()
+	defer nodeCleanupMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_node_cleanups_total node_cleanup runs, by result (succeeded, failed, refused).")
+	fmt.Fprintln(w, "# TYPE selfhealing_node_cleanups_total counter")
+	for _, result := range sortedKeys(nodeCleanupResults) {
+		fmt.Fprintf(w, "selfhealing_node_cleanups_total{result=%q} %d\n", result, nodeCleanupResults[result])
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_node_cleanup_freed_percent Percentage points of the image filesystem the last cleanup of a node freed.")
+	fmt.Fprintln(w, "# TYPE selfhealing_node_cleanup_freed_percent gauge")
+	for _, node := range sortedKeys(nodeCleanupFreed) {
+		fmt.Fprintf(w, "selfhealing_node_cleanup_freed_percent{node=%q} %d\n", node, nodeCleanupFreed[node])
+	}
+}
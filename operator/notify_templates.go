@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Notification templates. Every message the operator writes for people — the
+// escalation summary sent to Opsgenie, Splunk On-Call and Jira, and the Slack
+// posts about recommendations, quota bumps, capacity, safe mode and the
+// watchdog — is rendered from a Go text/template. The built-in templates
+// produce the English messages; NOTIFICATION_TEMPLATES_FILE (the
+// self-healing-notifications ConfigMap) overrides any of them per locale:
+//
+//	locale: de
+//	templates:
+//	  escalation-summary:
+//	    de: "Selbstheilung '{{.Action}}' für {{.Namespace}}/{{.App}} fehlgeschlagen ({{.AlertName}})"
+//
+// A message about an alert is written in the alert's `locale` label or
+// annotation, everything else in the file's locale (NOTIFICATION_LOCALE,
+// default en). A locale without its own variant falls back to its base
+// language (de-AT → de), then the default locale, then the built-in text.
+
+var notificationLocale = envString("NOTIFICATION_LOCALE", "en")
+
+// builtinMessages are the English defaults, and the list of message names a
+// templates file may override
+var builtinMessages = map[string]string{
+	"escalation-summary": "Self-healing '{{.Action}}' failed for {{.Namespace}}/{{.App}} ({{.AlertName}})",
+	"notify-summary":     "Alert {{.AlertName}} on {{.Namespace}}/{{.App}} needs attention (no automatic action configured)",
+	"recommendation": "*Self-healing recommendation #{{.ID}}*: `{{.Action}}` on `{{.Namespace}}/{{.App}}` for alert `{{.AlertName}}`" +
+		"{{with .Tags}} ({{tags .}}){{end}}" +
+		"{{with .Preview}}\n{{.Summary}}{{range .Lines}}\n• {{.}}{{end}}{{end}}" +
+		"{{if .Link}}\n<{{.Link}}|Approve and run now> (valid until {{.ExpiresAt.Format \"Mon, 02 Jan 2006 15:04:05 MST\"}}){{end}}",
+	"quota-bump": "*Quota bump #{{.ID}}* needed to scale `{{.Namespace}}/{{.App}}` for alert `{{.AlertName}}` (for {{.TTL}}):" +
+		"{{range .Changes}}\n• `{{.Quota}}` {{.Resource}}: {{.From}} → {{.To}}{{end}}" +
+		"{{if .Link}}\n<{{.Link}}|Approve, raise the quota and scale> (valid until {{.ExpiresAt.Format \"Mon, 02 Jan 2006 15:04:05 MST\"}}){{end}}",
+	"capacity-arrived":   "Capacity arrived for {{.Namespace}}/{{.App}} after {{.Took}}: {{.Pods}} pod(s) scheduled (nodes: {{join .Nodes \", \"}})",
+	"safe-mode-entered":  ":warning: Self-healing operator entered safe mode: control plane degraded ({{join .Triggers \", \"}}). Remediations are on hold.",
+	"safe-mode-left":     ":white_check_mark: Self-healing operator left safe mode after {{.Held}}; {{.Released}} held action(s) will be re-checked.",
+	"watchdog-missing":   ":rotating_light: Self-healing operator: {{.Reason}}. Alerts are probably not being delivered.",
+	"watchdog-recovered": ":white_check_mark: Self-healing operator is receiving the `{{.AlertName}}` heartbeat again.",
+	"webhook-restored":   ":white_check_mark: Admission webhooks of `{{.Configuration}}` restored: their Service is ready again.",
+	"action-interrupted": ":warning: `{{.Action}}` for alert `{{.AlertName}}` on `{{.Namespace}}/{{.App}}` was interrupted by an operator restart " +
+		"(started {{.StartedAt.Format \"2006-01-02 15:04:05\"}}) and wasn't run again: {{.Reason}}. Check the workload by hand.",
+	"observation-graduated": ":mortar_board: `{{.Workload}}` finished observation (since {{.Since.Format \"2006-01-02 15:04\"}}) and is now remediated automatically. " +
+		"It would have run: {{.WouldHave}}.",
+	"temporary-drifted": ":warning: Temporary `{{.Action}}` for alert `{{.AlertName}}` on `{{.Namespace}}/{{.App}}` was only partly reverted: " +
+		"these fields changed since, and were left alone:{{range .Fields}}\n• {{.}}{{end}}",
+	"alert-flapping": ":repeat: Alert `{{.AlertName}}` on `{{.Namespace}}/{{.App}}` flapped {{.Cycles}} times in the last hour; " +
+		"remediation is suppressed for {{.For}} (suppression #{{.SuppressionID}}). Its rule probably needs a longer `for:`.",
+	"check-failed": ":x: SelfHealingCheck `{{.Namespace}}/{{.Name}}` failed: {{.Message}}",
+}
+
+var messageFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"tags":  formatTags,
+}
+
+type notificationFile struct {
+	Locale    string                       `json:"locale"`
+	Templates map[string]map[string]string `json:"templates"`
+}
+
+var (
+	messagesMu sync.Mutex
+	// message name → locale → template; "" holds the built-in
+	messages = compileBuiltinMessages()
+)
+
+func compileBuiltinMessages() map[string]map[string]*template.Template {
+	out := map[string]map[string]*template.Template{}
+	for name, text := range builtinMessages {
+		out[name] = map[string]*template.Template{"": template.Must(template.New(name).Option("missingkey=error").Funcs(messageFuncs).Parse(text))}
+	}
+	return out
+}
+
+// loadNotificationTemplates reads the templates file; a missing file keeps the built-in messages
+func loadNotificationTemplates(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var f notificationFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	loaded := compileBuiltinMessages()
+	count := 0
+	for name, variants := range f.Templates {
+		if _, ok := loaded[name]; !ok {
+			return fmt.Errorf("%s: unknown message %q (known: %s)", path, name, strings.Join(sortedKeys(builtinMessages), ", "))
+		}
+		for locale, text := range variants {
+			t, err := template.New(name + "." + locale).Option("missingkey=error").Funcs(messageFuncs).Parse(text)
+			if err != nil {
+				return fmt.Errorf("%s: message %q (%s): %v", path, name, locale, err)
+			}
+			loaded[name][strings.ToLower(strings.ReplaceAll(locale, "_", "-"))] = t
+			count++
+		}
+	}
+
+	messagesMu.Lock()
+	messages = loaded
+	if f.Locale != "" {
+		notificationLocale = f.Locale
+	}
+	messagesMu.Unlock()
+	log.Printf("Loaded %d notification template(s) from %s (default locale %s)", count, path, notificationLocale)
+	return nil
+}
+
+// alertLocale is the locale an alert asks its notifications to be written in, or ""
+func alertLocale(labels, annotations map[string]string) string {
+	if l := labels["locale"]; l != "" {
+		return l
+	}
+	return annotations["locale"]
+}
+
+// renderMessage renders the named message in the locale (the default locale
+// if empty). A template that fails to render falls back to the built-in one,
+// so a broken override never swallows a notification.
+func renderMessage(name, locale string, data interface{}) string {
+	messagesMu.Lock()
+	variants := messages[name]
+	var candidates []string
+	for _, l := range []string{locale, notificationLocale} {
+		l = strings.ToLower(strings.ReplaceAll(l, "_", "-"))
+		if l == "" {
+			continue
+		}
+		candidates = append(candidates, l)
+		if base, _, ok := strings.Cut(l, "-"); ok {
+			candidates = append(candidates, base)
+		}
+	}
+	candidates = append(candidates, "")
+	var t *template.Template
+	for _, c := range candidates {
+		if t = variants[c]; t != nil {
+			break
+		}
+	}
+	builtin := variants[""]
+	messagesMu.Unlock()
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		log.Printf("Notification template %s failed, using the built-in one: %v", t.Name(), err)
+		buf.Reset()
+		if err := builtin.Execute(&buf, data); err != nil {
+			return name
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Observation mode for new workloads. With OBSERVATION_PERIOD set, a
+// workload (namespace/app) the operator decides an action for the first time
+// is onboarded in observation: for that long its actions are published as
+// recommendations (as with MODE=recommend, approve links included) instead of
+// run, and GET /api/v1/observation reports what would have happened. When the
+// period is over the operator posts a summary to Slack and acts normally.
+// notify actions aren't held back.
+//
+// Onboarding is sticky: the time is kept in the store (STORE), and workloads
+// with actions in the restored history count as onboarded when they were
+// first acted on. With the memory store a restart starts every burn-in over.
+// POST /api/v1/observation/graduate?workload=ns/app on the admin listener
+// ends a workload's observation early.
+
+var (
+	observationPeriod = envDuration("OBSERVATION_PERIOD", 0)
+
+	observation = &observationState{
+		onboarded: map[string]time.Time{},
+		reports:   map[string]*observationReport{},
+	}
+)
+
+type observationState struct {
+	mu        sync.Mutex
+	onboarded map[string]time.Time          // workload -> onboarded at
+	reports   map[string]*observationReport // workloads in observation with would-have actions
+	held      map[string]int                // action -> recommended instead of run
+	graduated int
+}
+
+// observationReport is what would have happened to a workload in observation
+type observationReport struct {
+	Workload  string         `json:"workload"`
+	Since     time.Time      `json:"since"`
+	Until     time.Time      `json:"until"`
+	WouldHave map[string]int `json:"wouldHave"` // "alertname:action" -> count
+	Last      time.Time      `json:"last,omitempty"`
+}
+
+func init() {
+	registerMetrics(observation.writeMetrics)
+}
+
+// setupObservation starts graduating workloads whose observation is over
+func setupObservation() {
+	if observationPeriod <= 0 {
+		return
+	}
+	if store.Name() == "memory" {
+		log.Printf("OBSERVATION_PERIOD is set with the memory store — every restart starts the burn-in of all workloads over")
+	}
+	log.Printf("Observation mode enabled — new workloads are recommend-only for %s", observationPeriod)
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-operatorCtx.Done():
+				return
+			case <-ticker.C:
+				observation.graduate(time.Now())
+			}
+		}
+	}()
+}
+
+// restore loads onboarding times, counting workloads with past actions as onboarded
+func (o *observationState) restore(onboarded map[string]time.Time, history []ActionRecord) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for workload, at := range onboarded {
+		o.onboarded[workload] = at
+	}
+	for _, rec := range history {
+		key := rec.Namespace + "/" + rec.App
+		if at, ok := o.onboarded[key]; !ok || rec.StartedAt.Before(at) {
+			o.onboarded[key] = rec.StartedAt
+		}
+	}
+}
+
+// until returns when the action's workload leaves observation and whether
+// it's in observation now, onboarding it if it's new and onboard is set
+func (o *observationState) until(action *RecoveryAction, onboard bool) (time.Time, bool) {
+	if observationPeriod <= 0 || action.Action == "notify" {
+		return time.Time{}, false
+	}
+	key := workloadKey(action)
+	now := time.Now()
+	o.mu.Lock()
+	at, ok := o.onboarded[key]
+	if !ok {
+		if !onboard {
+			o.mu.Unlock()
+			return now.Add(observationPeriod), true
+		}
+		at = now
+		o.onboarded[key] = at
+	}
+	o.mu.Unlock()
+	if !ok {
+		log.Printf("Workload %s onboarded — observing it until %s", key, at.Add(observationPeriod).Format(time.RFC3339))
+		go persist("onboarded workload", func(ctx context.Context) error { return store.SaveOnboarded(ctx, key, at) })
+	}
+	return at.Add(observationPeriod), now.Before(at.Add(observationPeriod))
+}
+
+// holds reports whether the action's workload is in observation, onboarding
+// it if it's new; held actions are added to the workload's report
+func (o *observationState) holds(action *RecoveryAction) bool {
+	until, ok := o.until(action, true)
+	if !ok {
+		return false
+	}
+	key := workloadKey(action)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	r := o.reports[key]
+	if r == nil {
+		r = &observationReport{Workload: key, Since: until.Add(-observationPeriod), Until: until, WouldHave: map[string]int{}}
+		o.reports[key] = r
+	}
+	r.WouldHave[action.AlertName+":"+action.Action]++
+	r.Last = time.Now()
+	if o.held == nil {
+		o.held = map[string]int{}
+	}
+	o.held[action.Action]++
+	action.Explain.add("observation", traceInfo, "workload in observation until "+until.Format(time.RFC3339)+": recommending instead of acting")
+	return true
+}
+
+// graduate ends observation for workloads whose period is over and reports on them
+func (o *observationState) graduate(now time.Time) {
+	o.mu.Lock()
+	var done []*observationReport
+	for key, r := range o.reports {
+		if !now.Before(r.Until) {
+			done = append(done, r)
+			delete(o.reports, key)
+		}
+	}
+	o.graduated += len(done)
+	o.mu.Unlock()
+	sort.Slice(done, func(i, j int) bool { return done[i].Workload < done[j].Workload })
+	for _, r := range done {
+		summary := make([]string, 0, len(r.WouldHave))
+		for _, k := range sortedKeys(r.WouldHave) {
+			summary = append(summary, fmt.Sprintf("%s ×%d", k, r.WouldHave[k]))
+		}
+		log.Printf("Workload %s graduated from observation — would have run: %s", r.Workload, strings.Join(summary, ", "))
+		notifySlack(renderMessage("observation-graduated", "", map[string]interface{}{
+			"Workload": r.Workload, "Since": r.Since, "WouldHave": strings.Join(summary, ", "),
+		}))
+	}
+}
+
+// graduateNow ends a workload's observation
+func (o *observationState) graduateNow(workload string) bool {
+	now := time.Now()
+	at := now.Add(-observationPeriod)
+	o.mu.Lock()
+	if cur, ok := o.onboarded[workload]; !ok || !now.Before(cur.Add(observationPeriod)) {
+		o.mu.Unlock()
+		return false
+	}
+	o.onboarded[workload] = at
+	if r := o.reports[workload]; r != nil {
+		r.Until = now
+	}
+	o.mu.Unlock()
+	persist("onboarded workload", func(ctx context.Context) error { return store.SaveOnboarded(ctx, workload, at) })
+	o.graduate(now)
+	return true
+}
+
+// list returns the workloads in observation, including those with nothing to report yet
+func (o *observationState) list() []observationReport {
+	now := time.Now()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	out := []observationReport{}
+	for _, key := range sortedKeys(o.onboarded) {
+		at := o.onboarded[key]
+		if !now.Before(at.Add(observationPeriod)) {
+			continue
+		}
+		r := observationReport{Workload: key, Since: at, Until: at.Add(observationPeriod), WouldHave: map[string]int{}}
+		if rep := o.reports[key]; rep != nil {
+			r = *rep
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func handleObservation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(observation.list())
+}
+
+func handleGraduate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	workload := r.URL.Query().Get("workload")
+	namespace, _, ok := strings.Cut(workload, "/")
+	if !ok {
+		http.Error(w, "workload=namespace/app is required", http.StatusBadRequest)
+		return
+	}
+	user, ok := authorizeRequest(w, r, verbApprove, namespace)
+	if !ok {
+		return
+	}
+	if !observation.graduateNow(workload) {
+		http.Error(w, "workload "+workload+" isn't in observation", http.StatusNotFound)
+		return
+	}
+	log.Printf("Workload %s graduated from observation early via the admin API%s", workload, byUser(user))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (o *observationState) writeMetrics(w io.Writer) {
+	now := time.Now()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	observing := 0
+	if observationPeriod > 0 {
+		for _, at := range o.onboarded {
+			if now.Before(at.Add(observationPeriod)) {
+				observing++
+			}
+		}
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_observation_workloads Workloads in observation (recommend-only burn-in).")
+	fmt.Fprintln(w, "# TYPE selfhealing_observation_workloads gauge")
+	fmt.Fprintf(w, "selfhealing_observation_workloads %d\n", observing)
+	fmt.Fprintln(w, "# HELP selfhealing_observation_held_total Actions recommended instead of run because their workload was in observation, by action.")
+	fmt.Fprintln(w, "# TYPE selfhealing_observation_held_total counter")
+	for _, action := range sortedKeys(o.held) {
+		fmt.Fprintf(w, "selfhealing_observation_held_total{action=%q} %d\n", action, o.held[action])
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_observation_graduated_total Workloads that finished observation with actions to report.")
+	fmt.Fprintln(w, "# TYPE selfhealing_observation_graduated_total counter")
+	fmt.Fprintf(w, "selfhealing_observation_graduated_total %d\n", o.graduated)
+}
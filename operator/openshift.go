@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// OpenShift support. With OPENSHIFT=auto (the default) the operator asks the
+// API server at startup whether it serves the OpenShift API groups:
+//   - apps.openshift.io: an app without a Deployment is looked up as a
+//     DeploymentConfig (label app=<app>). redeploy starts a new rollout
+//     (`oc rollout latest`), scale raises spec.replicas with the same
+//     max-replicas and quota checks as Deployments, and the workflow step
+//     rollback goes back to the previous revision (`oc rollout undo`),
+//     turning off automatic image triggers like oc does so the old image sticks
+//   - security.openshift.io: Jobs the operator creates leave runAsUser unset
+//     so SecurityContextConstraints assign one from the namespace's range;
+//     elsewhere they run as 65534. Either way they satisfy restricted-v2 and
+//     the restricted Pod Security Standard
+//
+// DeploymentConfigs are read and written as unstructured JSON through the
+// typed client's REST client, so there's no dependency on OpenShift's client.
+
+const (
+	dcAPIVersion  = "apps.openshift.io/v1"
+	sccAPIVersion = "security.openshift.io/v1"
+)
+
+var openshiftMode = envString("OPENSHIFT", "auto")
+
+// set once at startup by setupOpenShift
+var (
+	openshiftDeploymentConfigs bool
+	openshiftSCC               bool
+)
+
+func setupOpenShift(kube kubernetes.Interface) {
+	switch openshiftMode {
+	case "true":
+		openshiftDeploymentConfigs, openshiftSCC = true, true
+	case "false":
+		return
+	default:
+		openshiftDeploymentConfigs = servesResource(kube, dcAPIVersion, "deploymentconfigs")
+		openshiftSCC = servesResource(kube, sccAPIVersion, "securitycontextconstraints")
+	}
+	if openshiftDeploymentConfigs || openshiftSCC {
+		log.Printf("OpenShift support: DeploymentConfigs %t, SecurityContextConstraints %t", openshiftDeploymentConfigs, openshiftSCC)
+	}
+}
+
+func servesResource(kube kubernetes.Interface, groupVersion, resource string) bool {
+	list, err := kube.Discovery().ServerResourcesForGroupVersion(groupVersion)
+	if err != nil {
+		return false
+	}
+	for _, r := range list.APIResources {
+		if r.Name == resource {
+			return true
+		}
+	}
+	return false
+}
+
+func dcPath(namespace string, parts ...string) string {
+	return path.Join(append([]string{"/apis", dcAPIVersion, "namespaces", namespace, "deploymentconfigs"}, parts...)...)
+}
+
+// apiRequest sends a JSON request to an API path the typed client has no methods for
+// and decodes the reply into out
+func apiRequest(ctx context.Context, cs kubernetes.Interface, verb, p, contentType string, body, out interface{}) error {
+	req := cs.Discovery().RESTClient().Verb(verb).AbsPath(p)
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		req = req.SetHeader("Content-Type", contentType).Body(raw)
+	}
+	raw, err := req.DoRaw(ctx)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(raw, out)
+}
+
+// findDeploymentConfig returns the app's DeploymentConfig, or nil if
+// DeploymentConfigs aren't served or the app has none
+func findDeploymentConfig(ctx context.Context, cs kubernetes.Interface, namespace, app string) *unstructured.Unstructured {
+	if !openshiftDeploymentConfigs || app == "" {
+		return nil
+	}
+	raw, err := cs.Discovery().RESTClient().Get().AbsPath(dcPath(namespace)).Param("labelSelector", "app="+app).DoRaw(ctx)
+	if err != nil {
+		log.Printf("Failed to list DeploymentConfigs in %s: %v", namespace, err)
+		return nil
+	}
+	var list unstructured.UnstructuredList
+	if err := list.UnmarshalJSON(raw); err != nil {
+		log.Printf("Failed to list DeploymentConfigs in %s: %v", namespace, err)
+		return nil
+	}
+	if len(list.Items) == 0 {
+		return nil
+	}
+	return &list.Items[0]
+}
+
+// dcAsDeployment is a read-only Deployment view of a DeploymentConfig, so the
+// image, annotation and quota checks written for Deployments apply to it too
+func dcAsDeployment(dc *unstructured.Unstructured) (*appsv1.Deployment, error) {
+	dep := &appsv1.Deployment{}
+	dep.Name, dep.Namespace, dep.UID = dc.GetName(), dc.GetNamespace(), dc.GetUID()
+	dep.Annotations, dep.Generation = dc.GetAnnotations(), dc.GetGeneration()
+	replicas, _, _ := unstructured.NestedInt64(dc.Object, "spec", "replicas")
+	r := int32(replicas)
+	dep.Spec.Replicas = &r
+	if tpl, ok, _ := unstructured.NestedMap(dc.Object, "spec", "template"); ok {
+		var t corev1.PodTemplateSpec
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(tpl, &t); err != nil {
+			return nil, fmt.Errorf("DeploymentConfig %s/%s has an unreadable pod template: %v", dep.Namespace, dep.Name, err)
+		}
+		dep.Spec.Template = t
+	}
+	return dep, nil
+}
+
+// rolloutLatest starts a new deployment of the DeploymentConfig, like `oc rollout latest`
+func rolloutLatest(ctx context.Context, cs kubernetes.Interface, action *RecoveryAction, dc *unstructured.Unstructured) error {
+	dep, err := dcAsDeployment(dc)
+	if err != nil {
+		return err
+	}
+	if err := verifyPodImages(ctx, dep.Spec.Template.Spec); err != nil {
+		return fmt.Errorf("refusing to redeploy %s/%s: %v", dep.Namespace, dep.Name, err)
+	}
+	request := map[string]interface{}{
+		"kind":       "DeploymentRequest",
+		"apiVersion": dcAPIVersion,
+		"name":       dep.Name,
+		"latest":     true,
+		"force":      true,
+	}
+	var updated unstructured.Unstructured
+	if err := apiRequest(ctx, cs, "POST", dcPath(dep.Namespace, dep.Name, "instantiate"), "application/json", request, &updated); err != nil {
+		return fmt.Errorf("failed to roll out DeploymentConfig %s/%s: %v", dep.Namespace, dep.Name, err)
+	}
+	version, _, _ := unstructured.NestedInt64(updated.Object, "status", "latestVersion")
+	log.Printf("New rollout #%d started for DeploymentConfig %s/%s", version, dep.Namespace, dep.Name)
+	emitDeployMarker(action, dep.Name, updated.GetGeneration())
+	return nil
+}
+
+// scaleDeploymentConfig sets spec.replicas of the DeploymentConfig and returns it as updated
+func scaleDeploymentConfig(ctx context.Context, cs kubernetes.Interface, dc *unstructured.Unstructured, replicas int32) (*unstructured.Unstructured, error) {
+	patch := map[string]interface{}{"spec": map[string]interface{}{"replicas": replicas}}
+	var updated unstructured.Unstructured
+	err := apiRequest(ctx, cs, "PATCH", dcPath(dc.GetNamespace(), dc.GetName()), "application/merge-patch+json", patch, &updated.Object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scale DeploymentConfig %s/%s: %v", dc.GetNamespace(), dc.GetName(), err)
+	}
+	return &updated, nil
+}
+
+// rollbackDeploymentConfig goes back to the previous deployment of the
+// DeploymentConfig, like `oc rollout undo`: the server builds the rolled-back
+// config, automatic image triggers are turned off so they don't immediately
+// roll forward again, and the result is saved
+func rollbackDeploymentConfig(ctx context.Context, cs kubernetes.Interface, action *RecoveryAction, dc *unstructured.Unstructured) error {
+	ns, name := dc.GetNamespace(), dc.GetName()
+	version, _, _ := unstructured.NestedInt64(dc.Object, "status", "latestVersion")
+	if version < 2 {
+		return fmt.Errorf("DeploymentConfig %s/%s has no earlier deployment to roll back to", ns, name)
+	}
+	rollback := map[string]interface{}{
+		"kind":       "DeploymentConfigRollback",
+		"apiVersion": dcAPIVersion,
+		"name":       name,
+		"spec": map[string]interface{}{
+			"from":            map[string]interface{}{"name": fmt.Sprintf("%s-%d", name, version-1)},
+			"revision":        version - 1,
+			"includeTemplate": true,
+		},
+	}
+	var rolled unstructured.Unstructured
+	if err := apiRequest(ctx, cs, "POST", dcPath(ns, name, "rollback"), "application/json", rollback, &rolled); err != nil {
+		return fmt.Errorf("failed to roll back DeploymentConfig %s/%s: %v", ns, name, err)
+	}
+	dep, err := dcAsDeployment(&rolled)
+	if err != nil {
+		return err
+	}
+	if err := verifyPodImages(ctx, dep.Spec.Template.Spec); err != nil {
+		return fmt.Errorf("refusing to roll back %s/%s: %v", ns, name, err)
+	}
+
+	triggers, _, _ := unstructured.NestedSlice(rolled.Object, "spec", "triggers")
+	for _, t := range triggers {
+		if params, ok := t.(map[string]interface{})["imageChangeParams"].(map[string]interface{}); ok && params["automatic"] == true {
+			params["automatic"] = false
+			log.Printf("Turned off automatic image trigger of %s/%s — re-enable it with `oc set triggers` once fixed", ns, name)
+		}
+	}
+	unstructured.SetNestedSlice(rolled.Object, triggers, "spec", "triggers")
+
+	var updated unstructured.Unstructured
+	if err := apiRequest(ctx, cs, "PUT", dcPath(ns, name), "application/json", rolled.Object, &updated); err != nil {
+		return fmt.Errorf("failed to update DeploymentConfig %s/%s: %v", ns, name, err)
+	}
+	recordChange(action, "DeploymentConfig", ns, name, dc.Object, updated.Object)
+	log.Printf("DeploymentConfig %s/%s rolled back to deployment #%d", ns, name, version-1)
+	emitDeployMarker(action, name, updated.GetGeneration())
+	return nil
+}
+
+// restrictedSecurity returns the pod and container security contexts for Jobs
+// the operator creates: non-root, no privilege escalation, no capabilities
+func restrictedSecurity() (*corev1.PodSecurityContext, *corev1.SecurityContext) {
+	nonRoot, escalate := true, false
+	pod := &corev1.PodSecurityContext{
+		RunAsNonRoot:   &nonRoot,
+		SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+	}
+	if !openshiftSCC {
+		// SCCs assign a UID from the namespace's range and reject any other
+		nobody := int64(65534)
+		pod.RunAsUser = &nobody
+	}
+	container := &corev1.SecurityContext{
+		AllowPrivilegeEscalation: &escalate,
+		Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+	}
+	return pod, container
+}
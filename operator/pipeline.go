@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Remediation pipeline health, so the operator can alert about itself
+// falling behind (see the self-healing-operator group in
+// manifests/monitoring/prometheus-rules.yaml):
+//   - queue depth: actions decided but not started yet — waiting for a
+//     spread-out batch, for their workload's lock, or held by safe mode
+//   - workers busy: actions running now, by action
+//   - retry backlog: callbacks and event batches waiting to be retried
+//   - oldest pending action: how long the longest-waiting action has been
+//     queued
+
+var pipeline = &pipelineState{
+	queued:  map[*RecoveryAction]time.Time{},
+	busy:    map[string]int{},
+	retries: map[string]int{},
+}
+
+type pipelineState struct {
+	mu       sync.Mutex
+	queued   map[*RecoveryAction]time.Time // action -> queued at
+	busy     map[string]int                // action name -> running
+	retries  map[string]int                // kind -> waiting to be retried
+	waitSum  time.Duration                 // time queued, for actions that started
+	waitN    int
+	finished int
+}
+
+func init() {
+	registerMetrics(pipeline.writeMetrics)
+}
+
+// enqueue records a decided action waiting to run
+func (p *pipelineState) enqueue(action *RecoveryAction) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.queued[action]; !ok {
+		p.queued[action] = time.Now()
+	}
+}
+
+// dequeue forgets a queued action that won't run
+func (p *pipelineState) dequeue(action *RecoveryAction) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.queued, action)
+}
+
+// begin moves an action from the queue to the busy workers and returns the
+// function to call when it's done
+func (p *pipelineState) begin(action *RecoveryAction) func() {
+	name := action.Action
+	p.mu.Lock()
+	if at, ok := p.queued[action]; ok {
+		p.waitSum += time.Since(at)
+		p.waitN++
+		delete(p.queued, action)
+	}
+	p.busy[name]++
+	p.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			p.busy[name]--
+			p.finished++
+			p.mu.Unlock()
+		})
+	}
+}
+
+// retrying counts n items of a kind waiting for a retry; call it again with -n when they're retried
+func (p *pipelineState) retrying(kind string, n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.retries[kind] += n
+}
+
+func (p *pipelineState) writeMetrics(w io.Writer) {
+	held := safeMode.heldSince()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	oldest := time.Duration(0)
+	for _, at := range p.queued {
+		if d := now.Sub(at); d > oldest {
+			oldest = d
+		}
+	}
+	for _, at := range held {
+		if d := now.Sub(at); d > oldest {
+			oldest = d
+		}
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_remediation_queue_depth Actions decided but not started, by stage (pending, safe_mode).")
+	fmt.Fprintln(w, "# TYPE selfhealing_remediation_queue_depth gauge")
+	fmt.Fprintf(w, "selfhealing_remediation_queue_depth{stage=\"pending\"} %d\n", len(p.queued))
+	fmt.Fprintf(w, "selfhealing_remediation_queue_depth{stage=\"safe_mode\"} %d\n", len(held))
+	fmt.Fprintln(w, "# HELP selfhealing_remediation_oldest_pending_seconds How long the longest-waiting queued action has waited, 0 if none.")
+	fmt.Fprintln(w, "# TYPE selfhealing_remediation_oldest_pending_seconds gauge")
+	fmt.Fprintf(w, "selfhealing_remediation_oldest_pending_seconds %.3f\n", oldest.Seconds())
+	fmt.Fprintln(w, "# HELP selfhealing_remediation_queue_wait_seconds Time actions waited between decision and start.")
+	fmt.Fprintln(w, "# TYPE selfhealing_remediation_queue_wait_seconds summary")
+	fmt.Fprintf(w, "selfhealing_remediation_queue_wait_seconds_sum %.3f\n", p.waitSum.Seconds())
+	fmt.Fprintf(w, "selfhealing_remediation_queue_wait_seconds_count %d\n", p.waitN)
+	fmt.Fprintln(w, "# HELP selfhealing_remediation_workers_busy Actions running now, by action.")
+	fmt.Fprintln(w, "# TYPE selfhealing_remediation_workers_busy gauge")
+	for _, name := range sortedKeys(p.busy) {
+		fmt.Fprintf(w, "selfhealing_remediation_workers_busy{action=%q} %d\n", name, p.busy[name])
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_remediation_finished_total Actions that started and finished, including ones a last-moment check skipped.")
+	fmt.Fprintln(w, "# TYPE selfhealing_remediation_finished_total counter")
+	fmt.Fprintf(w, "selfhealing_remediation_finished_total %d\n", p.finished)
+	fmt.Fprintln(w, "# HELP selfhealing_retry_backlog Deliveries waiting to be retried, by kind (callback, event).")
+	fmt.Fprintln(w, "# TYPE selfhealing_retry_backlog gauge")
+	for _, kind := range []string{"callback", "event"} {
+		fmt.Fprintf(w, "selfhealing_retry_backlog{kind=%q} %d\n", kind, p.retries[kind])
+	}
+}
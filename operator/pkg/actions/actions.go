@@ -0,0 +1,92 @@
+package actions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"k8s.io/client-go/kubernetes"
+
+	"self-healing-operator/pkg/alerts"
+)
+
+// ErrUnknownAction is returned (wrapped) for actions that aren't registered
+var ErrUnknownAction = errors.New("unknown recovery action")
+
+// Action is one kind of remediation
+type Action interface {
+	// Name is what alerts ask for, e.g. in the recovery_action label
+	Name() string
+	// Execute runs the action for the target
+	Execute(ctx context.Context, kube kubernetes.Interface, target alerts.Target) error
+}
+
+type funcAction struct {
+	name string
+	fn   func(ctx context.Context, kube kubernetes.Interface, target alerts.Target) error
+}
+
+func (a funcAction) Name() string { return a.name }
+
+func (a funcAction) Execute(ctx context.Context, kube kubernetes.Interface, target alerts.Target) error {
+	return a.fn(ctx, kube, target)
+}
+
+// Func makes an Action of a function
+func Func(name string, fn func(ctx context.Context, kube kubernetes.Interface, target alerts.Target) error) Action {
+	return funcAction{name: name, fn: fn}
+}
+
+// Executor runs registered actions by name. It's safe for concurrent use.
+type Executor struct {
+	mu      sync.RWMutex
+	actions map[string]Action
+}
+
+// NewExecutor returns an executor with the actions registered
+func NewExecutor(actions ...Action) *Executor {
+	e := &Executor{actions: map[string]Action{}}
+	for _, a := range actions {
+		e.Register(a)
+	}
+	return e
+}
+
+// Register adds an action, replacing one of the same name
+func (e *Executor) Register(a Action) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.actions[a.Name()] = a
+}
+
+// Has reports whether an action is registered
+func (e *Executor) Has(name string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.actions[name] != nil
+}
+
+// Names lists the registered actions, sorted
+func (e *Executor) Names() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	names := make([]string, 0, len(e.actions))
+	for name := range e.actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Execute runs the named action for the target
+func (e *Executor) Execute(ctx context.Context, kube kubernetes.Interface, name string, target alerts.Target) error {
+	e.mu.RLock()
+	a := e.actions[name]
+	e.mu.RUnlock()
+	if a == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownAction, name)
+	}
+	return a.Execute(ctx, kube, target)
+}
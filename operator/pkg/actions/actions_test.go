@@ -0,0 +1,80 @@
+package actions
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"self-healing-operator/pkg/alerts"
+)
+
+func deletePod() Action {
+	return Func("restart", func(ctx context.Context, kube kubernetes.Interface, t alerts.Target) error {
+		return kube.CoreV1().Pods(t.Namespace).Delete(ctx, t.Pod, metav1.DeleteOptions{})
+	})
+}
+
+func TestExecuteRunsAction(t *testing.T) {
+	kube := fake.NewSimpleClientset(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "cart-1"}})
+	exec := NewExecutor(deletePod())
+
+	alert := alerts.Alert{Labels: map[string]string{"namespace": "shop", "pod": "cart-1"}}
+	if err := exec.Execute(context.Background(), kube, "restart", alert.Target()); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	pods, err := kube.CoreV1().Pods("shop").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) != 0 {
+		t.Errorf("pod still there after restart: %v", pods.Items)
+	}
+}
+
+func TestExecuteUnknownAction(t *testing.T) {
+	kube := fake.NewSimpleClientset()
+	err := NewExecutor(deletePod()).Execute(context.Background(), kube, "reboot", alerts.Target{Namespace: "shop"})
+	if !errors.Is(err, ErrUnknownAction) {
+		t.Fatalf("err = %v, want ErrUnknownAction", err)
+	}
+	if len(kube.Actions()) != 0 {
+		t.Errorf("unknown action reached the cluster: %v", kube.Actions())
+	}
+}
+
+func TestExecuteReturnsActionError(t *testing.T) {
+	// the pod doesn't exist, so the delete fails
+	err := NewExecutor(deletePod()).Execute(context.Background(), fake.NewSimpleClientset(), "restart", alerts.Target{Namespace: "shop", Pod: "gone"})
+	if err == nil {
+		t.Fatal("Execute succeeded for a missing pod")
+	}
+}
+
+func TestRegisterReplaces(t *testing.T) {
+	called := ""
+	exec := NewExecutor(deletePod())
+	exec.Register(Func("restart", func(context.Context, kubernetes.Interface, alerts.Target) error {
+		called = "replacement"
+		return nil
+	}))
+	exec.Register(Func("scale", func(context.Context, kubernetes.Interface, alerts.Target) error { return nil }))
+
+	if err := exec.Execute(context.Background(), fake.NewSimpleClientset(), "restart", alerts.Target{}); err != nil {
+		t.Fatal(err)
+	}
+	if called != "replacement" {
+		t.Errorf("the first restart ran, not the replacement")
+	}
+	if got, want := exec.Names(), []string{"restart", "scale"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Names() = %v, want %v", got, want)
+	}
+	if !exec.Has("scale") || exec.Has("drain") {
+		t.Errorf("Has reports the wrong actions")
+	}
+}
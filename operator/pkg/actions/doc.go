@@ -0,0 +1,12 @@
+// Package actions runs recovery actions against a cluster. An Action does
+// one kind of remediation for an alert's target; an Executor looks actions
+// up by name:
+//
+//	exec := actions.NewExecutor(actions.Func("drain_queue", func(ctx context.Context, kube kubernetes.Interface, t alerts.Target) error {
+//		return drain(ctx, t.Namespace, t.App)
+//	}))
+//	err := exec.Execute(ctx, kube, "drain_queue", alert.Target())
+//
+// The operator dispatches its own actions (restart, redeploy, scale, ...)
+// through an Executor too.
+package actions
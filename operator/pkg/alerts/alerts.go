@@ -0,0 +1,80 @@
+package alerts
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Alert statuses
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// DefaultNamespace is the target namespace of alerts without a namespace label
+const DefaultNamespace = "default"
+
+// Alert represents a single Alertmanager alert
+type Alert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	Status      string            `json:"status"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+	Fingerprint string            `json:"fingerprint,omitempty"`
+}
+
+// WebhookMessage is the payload sent by Alertmanager
+type WebhookMessage struct {
+	GroupKey string  `json:"groupKey,omitempty"`
+	Alerts   []Alert `json:"alerts"`
+}
+
+// Target is what an alert is about and what it asks for, from its labels
+type Target struct {
+	AlertName string
+	Namespace string // DefaultNamespace if the alert has none
+	App       string
+	Pod       string
+	Node      string
+	Runbook   string // runbook label, for the runbook action
+	Workflow  string // workflow label, for the workflow action
+}
+
+// Decode reads an Alertmanager webhook payload
+func Decode(r io.Reader) (WebhookMessage, error) {
+	var msg WebhookMessage
+	if err := json.NewDecoder(r).Decode(&msg); err != nil {
+		return WebhookMessage{}, fmt.Errorf("invalid webhook payload: %v", err)
+	}
+	return msg, nil
+}
+
+// Firing reports whether the alert is firing
+func (a Alert) Firing() bool {
+	return a.Status == StatusFiring
+}
+
+// Resolved reports whether the alert has resolved
+func (a Alert) Resolved() bool {
+	return a.Status == StatusResolved
+}
+
+// Target reads the alert's target from its labels
+func (a Alert) Target() Target {
+	namespace := a.Labels["namespace"]
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	return Target{
+		AlertName: a.Labels["alertname"],
+		Namespace: namespace,
+		App:       a.Labels["app"],
+		Pod:       a.Labels["pod"],
+		Node:      a.Labels["node"],
+		Runbook:   a.Labels["runbook"],
+		Workflow:  a.Labels["workflow"],
+	}
+}
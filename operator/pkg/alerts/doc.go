@@ -0,0 +1,15 @@
+// Package alerts holds the alert types the operator acts on, as Alertmanager
+// sends them to its webhook, and reads the target of an alert from its labels.
+//
+//	msg, err := alerts.Decode(r.Body)
+//	for _, a := range msg.Alerts {
+//		if !a.Firing() {
+//			continue
+//		}
+//		t := a.Target()
+//		fmt.Println(t.AlertName, "on", t.Namespace+"/"+t.App)
+//	}
+//
+// The label names are the canonical ones (namespace, app, pod, …); the
+// operator maps alternatives onto them before it reads them.
+package alerts
@@ -0,0 +1,220 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client talks to one operator. The zero value isn't usable; use New.
+type Client struct {
+	baseURL string
+	http    *http.Client
+	token   string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient replaces the default client (30s timeout), e.g. for custom TLS
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.http = hc }
+}
+
+// WithBearerToken sends "Authorization: Bearer <token>" with every request,
+// for operators behind an authenticating proxy
+func WithBearerToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// New returns a client for the operator at baseURL, e.g. "http://self-healing-operator:8080"
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// APIError is a non-2xx response from the operator
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("operator returned %d: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether err is a 404 from the operator
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// History returns up to limit remediations, newest first; 0 returns all the operator keeps
+func (c *Client) History(ctx context.Context, limit int) ([]ActionRecord, error) {
+	q := url.Values{}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	var out []ActionRecord
+	err := c.do(ctx, http.MethodGet, "/api/v1/history", q, nil, &out)
+	return out, err
+}
+
+// HistoryRecord returns one remediation with its decision trace
+func (c *Client) HistoryRecord(ctx context.Context, id int) (*ActionRecord, error) {
+	var out ActionRecord
+	if err := c.do(ctx, http.MethodGet, "/api/v1/history", url.Values{"id": {strconv.Itoa(id)}}, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Simulate runs a synthetic alert through the decision checks without acting on it
+func (c *Client) Simulate(ctx context.Context, req SimulateRequest) (*SimulateResult, error) {
+	var out SimulateResult
+	if err := c.do(ctx, http.MethodPost, "/api/v1/test-alert", nil, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Freeze stops healing of the matching alerts until the duration ends or Unfreeze is called
+func (c *Client) Freeze(ctx context.Context, req FreezeRequest) (*Suppression, error) {
+	body := map[string]string{
+		"alertname": req.AlertName,
+		"namespace": req.Namespace,
+		"app":       req.App,
+		"duration":  req.Duration.String(),
+		"reason":    req.Reason,
+	}
+	var out Suppression
+	if err := c.do(ctx, http.MethodPost, "/api/v1/suppressions", nil, body, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Suppressions returns the active freezes
+func (c *Client) Suppressions(ctx context.Context) ([]Suppression, error) {
+	var out []Suppression
+	err := c.do(ctx, http.MethodGet, "/api/v1/suppressions", nil, nil, &out)
+	return out, err
+}
+
+// Unfreeze ends a freeze early
+func (c *Client) Unfreeze(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/suppressions", url.Values{"id": {strconv.Itoa(id)}}, nil, nil)
+}
+
+// ActiveAlerts returns the alerts Alertmanager has firing, each with the
+// operator's remediation state for it. The filter's zero fields match everything.
+func (c *Client) ActiveAlerts(ctx context.Context, filter ActiveAlertFilter) ([]ActiveAlert, error) {
+	q := url.Values{}
+	for key, v := range map[string]string{"alertname": filter.AlertName, "namespace": filter.Namespace, "remediation": filter.Remediation} {
+		if v != "" {
+			q.Set(key, v)
+		}
+	}
+	if filter.Silenced {
+		q.Set("silenced", "true")
+	}
+	if filter.Inhibited {
+		q.Set("inhibited", "true")
+	}
+	var out []ActiveAlert
+	err := c.do(ctx, http.MethodGet, "/api/v1/active-alerts", q, nil, &out)
+	return out, err
+}
+
+// Recommendations returns the actions proposed in MODE=recommend
+func (c *Client) Recommendations(ctx context.Context) ([]Recommendation, error) {
+	var out []Recommendation
+	err := c.do(ctx, http.MethodGet, "/api/v1/recommendations", nil, nil, &out)
+	return out, err
+}
+
+// ApproveRecommendation executes a pending recommendation. The token is the
+// one in its approval link; the operator's reply is returned.
+func (c *Client) ApproveRecommendation(ctx context.Context, id int, token string) (string, error) {
+	return c.approve(ctx, "/api/v1/recommendations/approve", id, token)
+}
+
+// QuotaBumps returns the quota bumps waiting for approval or already applied
+func (c *Client) QuotaBumps(ctx context.Context) ([]QuotaBump, error) {
+	var out []QuotaBump
+	err := c.do(ctx, http.MethodGet, "/api/v1/quota-bumps", nil, nil, &out)
+	return out, err
+}
+
+// ApproveQuotaBump raises the quotas of a pending bump and runs its scale
+func (c *Client) ApproveQuotaBump(ctx context.Context, id int, token string) (string, error) {
+	return c.approve(ctx, "/api/v1/quota-bumps/approve", id, token)
+}
+
+func (c *Client) approve(ctx context.Context, path string, id int, token string) (string, error) {
+	var out bytes.Buffer
+	q := url.Values{"id": {strconv.Itoa(id)}, "token": {token}}
+	if err := c.do(ctx, http.MethodPost, path, q, nil, &out); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// do sends a request with an optional JSON body and decodes the reply into out:
+// JSON for most endpoints, plain text into a *bytes.Buffer
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	switch dst := out.(type) {
+	case nil:
+		return nil
+	case *bytes.Buffer:
+		_, err = io.Copy(dst, resp.Body)
+		return err
+	default:
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+}
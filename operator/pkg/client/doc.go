@@ -0,0 +1,20 @@
+// Package client is a Go client for the self-healing operator's HTTP API, for
+// tooling that wants to read the remediation history, dry-run alerts, freeze
+// healing for a workload or approve pending changes without hand-writing
+// HTTP calls.
+//
+//	c := client.New("http://self-healing-operator.monitoring:8080")
+//	res, err := c.Simulate(ctx, client.SimulateRequest{Namespace: "shop", App: "cart", Severity: "critical"})
+//	if err == nil && res.Decision == "execute" {
+//		fmt.Println("would run", res.Action.Action)
+//	}
+//
+//	// freeze healing of cart for a deploy window
+//	s, err := c.Freeze(ctx, client.FreezeRequest{Namespace: "shop", App: "cart", Duration: 2 * time.Hour, Reason: "migration"})
+//	...
+//	err = c.Unfreeze(ctx, s.ID)
+//
+// The types mirror the operator's JSON; fields the operator adds later are
+// ignored. Errors from the operator come back as *APIError with the status
+// code and message.
+package client
@@ -0,0 +1,201 @@
+package client
+
+import "time"
+
+// ActionRecord is one executed remediation from the history
+type ActionRecord struct {
+	ID        int               `json:"id"`
+	StartedAt time.Time         `json:"startedAt"`
+	Duration  string            `json:"duration"`
+	AlertName string            `json:"alertname"`
+	Action    string            `json:"action"`
+	Name      string            `json:"name,omitempty"` // runbook or workflow name
+	Namespace string            `json:"namespace"`
+	App       string            `json:"app"`
+	Pod       string            `json:"pod,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"` // team, cost center, tier, ...
+	Outcome   string            `json:"outcome"`        // succeeded, failed
+	Error     string            `json:"error,omitempty"`
+	Steps     []StepRecord      `json:"steps,omitempty"`
+	Explain   []TraceStep       `json:"explain,omitempty"`
+	Changes   []ObjectChange    `json:"changes,omitempty"`
+
+	PrevDigest string          `json:"prevDigest,omitempty"`
+	Signature  *AuditSignature `json:"signature,omitempty"`
+}
+
+// StepRecord is one step of a runbook or workflow run
+type StepRecord struct {
+	Name      string    `json:"name"`
+	Action    string    `json:"action"`
+	Status    string    `json:"status"` // succeeded, failed, skipped, cancelled
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"startedAt"`
+	Duration  string    `json:"duration"`
+}
+
+// ObjectChange is what a remediation changed in one object's spec
+type ObjectChange struct {
+	Kind      string        `json:"kind"`
+	Namespace string        `json:"namespace"`
+	Name      string        `json:"name"`
+	Fields    []FieldChange `json:"fields"`
+	Truncated bool          `json:"truncated,omitempty"`
+}
+
+// FieldChange is one changed field, e.g. spec.replicas; Before or After is nil
+// when the field was added or removed
+type FieldChange struct {
+	Path   string      `json:"path"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// TraceStep is one check of the decision that led (or didn't lead) to an action
+type TraceStep struct {
+	Check  string `json:"check"`
+	Result string `json:"result"` // pass, skip, info
+	Detail string `json:"detail,omitempty"`
+}
+
+// AuditSignature signs a history record, see `self-healing-operator verify-audit`
+type AuditSignature struct {
+	KeyID     string `json:"keyId"`
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"value"`
+}
+
+// SimulateRequest describes a synthetic firing alert; App, Pod or Labels["node"] is required
+type SimulateRequest struct {
+	AlertName   string            `json:"alertname,omitempty"`
+	Namespace   string            `json:"namespace,omitempty"`
+	App         string            `json:"app,omitempty"`
+	Pod         string            `json:"pod,omitempty"`
+	Severity    string            `json:"severity,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// SimulateResult is what the operator would do with the alert
+type SimulateResult struct {
+	Alert    Alert           `json:"alert"`
+	Decision string          `json:"decision"` // execute, recommend, hold, skip
+	Action   *SimulateAction `json:"action,omitempty"`
+	Trace    []TraceStep     `json:"trace"`
+}
+
+// SimulateAction is the action a simulated alert would run
+type SimulateAction struct {
+	Action    string `json:"action"`
+	Namespace string `json:"namespace"`
+	App       string `json:"app"`
+	Pod       string `json:"pod,omitempty"`
+	Runbook   string `json:"runbook,omitempty"`
+	Workflow  string `json:"workflow,omitempty"`
+	Escalate  bool   `json:"escalate,omitempty"`
+}
+
+// Alert is an alert as the operator sees it
+type Alert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	Status      string            `json:"status"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+	Fingerprint string            `json:"fingerprint,omitempty"`
+}
+
+// FreezeRequest stops the operator from acting on a namespace, app or alert for a while
+type FreezeRequest struct {
+	AlertName string
+	Namespace string
+	App       string
+	Duration  time.Duration
+	Reason    string
+}
+
+// Suppression is an active freeze
+type Suppression struct {
+	ID        int       `json:"id"`
+	AlertName string    `json:"alertname,omitempty"`
+	Namespace string    `json:"namespace"`
+	App       string    `json:"app,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Recommendation is an action proposed in MODE=recommend, waiting for approval
+type Recommendation struct {
+	ID        int       `json:"id"`
+	AlertName string    `json:"alertname"`
+	Action    string    `json:"action"`
+	Namespace string    `json:"namespace"`
+	App       string    `json:"app"`
+	Pod       string    `json:"pod,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Status    string    `json:"status"` // pending, approved, expired
+}
+
+// ActiveAlertFilter narrows ActiveAlerts
+type ActiveAlertFilter struct {
+	AlertName   string
+	Namespace   string
+	Remediation string // one remediation status, e.g. cooldown
+	Silenced    bool   // include silenced alerts
+	Inhibited   bool   // include inhibited alerts
+}
+
+// ActiveAlert is a firing alert next to what the operator is doing about it
+type ActiveAlert struct {
+	AlertName   string            `json:"alertname"`
+	Namespace   string            `json:"namespace,omitempty"`
+	App         string            `json:"app,omitempty"`
+	Severity    string            `json:"severity,omitempty"`
+	Fingerprint string            `json:"fingerprint"`
+	StartsAt    time.Time         `json:"startsAt"`
+	State       string            `json:"state"` // Alertmanager's: active, suppressed
+	SilencedBy  []string          `json:"silencedBy,omitempty"`
+	InhibitedBy []string          `json:"inhibitedBy,omitempty"`
+	Receivers   []string          `json:"receivers,omitempty"`
+	Labels      map[string]string `json:"labels"`
+	Remediation RemediationState  `json:"remediation"`
+}
+
+// RemediationState is the operator's side of an active alert
+type RemediationState struct {
+	// in-progress, pending-approval, turned-off, suppressed, cooldown,
+	// remediated, failed, waiting or no-action
+	Status           string     `json:"status"`
+	Detail           string     `json:"detail,omitempty"`
+	Action           string     `json:"action,omitempty"`
+	CooldownUntil    *time.Time `json:"cooldownUntil,omitempty"`
+	SuppressionID    int        `json:"suppressionId,omitempty"`
+	RecommendationID int        `json:"recommendationId,omitempty"`
+	LastAction       *struct {
+		ID        int       `json:"id"`
+		Action    string    `json:"action"`
+		Outcome   string    `json:"outcome"`
+		StartedAt time.Time `json:"startedAt"`
+	} `json:"lastAction,omitempty"`
+}
+
+// QuotaBump is a temporary quota increase waiting for approval (SCALE_QUOTA_POLICY=bump)
+type QuotaBump struct {
+	ID        int           `json:"id"`
+	Namespace string        `json:"namespace"`
+	App       string        `json:"app"`
+	Changes   []QuotaChange `json:"changes"`
+	CreatedAt time.Time     `json:"createdAt"`
+	ExpiresAt time.Time     `json:"expiresAt"`
+	Status    string        `json:"status"` // pending, approved, expired
+}
+
+// QuotaChange is one quota value a bump raises
+type QuotaChange struct {
+	Quota    string `json:"quota"`
+	Resource string `json:"resource"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+}
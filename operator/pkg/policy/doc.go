@@ -0,0 +1,18 @@
+// Package policy decides which recovery action an alert asks for. The
+// sources, in order:
+//   - a severity mapping in the alert's recovery_actions annotation, e.g.
+//     "warning=notify, critical=restart, page=redeploy+escalate"
+//   - the alert's recovery_action label
+//   - the engine's default severity mapping (the operator's SEVERITY_ACTIONS)
+//
+// The first source with an action for the alert's severity wins; a
+// "+escalate" suffix asks for a page after the action.
+//
+//	engine := policy.Engine{Defaults: "warning=notify, critical=restart"}
+//	d := engine.Select(alert)
+//	if d.Action != "" {
+//		err = executor.Execute(ctx, kube, d.Action, alert.Target())
+//	}
+//
+// Decision.Steps lists every source looked at, for explaining a decision.
+package policy
@@ -0,0 +1,69 @@
+package policy
+
+import (
+	"strings"
+
+	"self-healing-operator/pkg/alerts"
+)
+
+// Sources of an action, as named in Decision.Steps
+const (
+	SourceAnnotation = "recovery_actions annotation"
+	SourceLabel      = "recovery_action label"
+	SourceDefaults   = "SEVERITY_ACTIONS"
+)
+
+// Engine selects actions for alerts
+type Engine struct {
+	// Defaults is a severity mapping for alerts that carry neither the
+	// annotation nor a recovery_action label
+	Defaults string
+}
+
+// Decision is the action an alert asks for
+type Decision struct {
+	Action   string // "" if no source has one
+	Escalate bool   // page after the action
+	Steps    []Step
+}
+
+// Step is one source looked at
+type Step struct {
+	Source string
+	Action string // "" if the source has none for the alert's severity
+}
+
+// ParseSeverityActions turns "sev=action, sev=action" into a map; entries
+// without "=" are ignored
+func ParseSeverityActions(spec string) map[string]string {
+	out := map[string]string{}
+	for _, part := range strings.Split(spec, ",") {
+		sev, action, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		out[strings.TrimSpace(sev)] = strings.TrimSpace(action)
+	}
+	return out
+}
+
+// Select picks the action for an alert
+func (e Engine) Select(alert alerts.Alert) Decision {
+	var d Decision
+	severity := alert.Labels["severity"]
+	look := func(source, found string) {
+		d.Steps = append(d.Steps, Step{Source: source, Action: found})
+		d.Action = found
+	}
+	if spec := alert.Annotations["recovery_actions"]; spec != "" {
+		look(SourceAnnotation, ParseSeverityActions(spec)[severity])
+	}
+	if d.Action == "" && alert.Labels["recovery_action"] != "" {
+		look(SourceLabel, alert.Labels["recovery_action"])
+	}
+	if d.Action == "" && e.Defaults != "" {
+		look(SourceDefaults, ParseSeverityActions(e.Defaults)[severity])
+	}
+	d.Action, d.Escalate = strings.CutSuffix(d.Action, "+escalate")
+	return d
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"self-healing-operator/pkg/policy"
+)
+
+// Policy bundles: GET /api/v1/policy-bundle on the admin listener exports the
+// policy set as one signed JSON document, and POST imports one, so policies
+// tested in staging can be promoted to production as a unit:
+//
+//	curl -s staging-operator:9090/api/v1/policy-bundle > bundle.json
+//	curl -X POST prod-operator:9090/api/v1/policy-bundle?dryRun=true --data-binary @bundle.json
+//	curl -X POST prod-operator:9090/api/v1/policy-bundle --data-binary @bundle.json
+//
+// A bundle holds
+//   - guardrails: the live tuning settings (cooldown, spread, effectiveness
+//     thresholds, ...; see tuning.go)
+//   - disabledPolicies: policies auto-disabled for low effectiveness
+//   - workflows and severityActions: the WORKFLOWS_FILE definitions and the
+//     SEVERITY_ACTIONS default
+//
+// Import validates the whole bundle before changing anything: the schema
+// version, every guardrail value and every workflow. Guardrails and disabled
+// policies are then applied and saved to the store, like a tuning change;
+// policies disabled here but not in the bundle stay disabled. Workflows and
+// severityActions come from the deployment's ConfigMap and environment, so
+// import doesn't change them — it reports where they differ, and
+// `self-healing-operator policy-bundle workflows < bundle.json` prints the
+// workflows file to commit to the ConfigMap.
+//
+// Bundles are signed with the audit signer (AUDIT_SIGNING_KEY or
+// AUDIT_VAULT_KEY, see audit.go) when one is configured. With
+// POLICY_BUNDLE_KEYS (comma-separated public key files) set, import only
+// accepts bundles signed by one of those keys; without it, import is refused
+// unless POLICY_BUNDLE_ALLOW_UNSIGNED=true, which skips verification. Verify a
+// bundle offline with
+//
+//	self-healing-operator policy-bundle verify cosign.pub < bundle.json
+
+const (
+	policyBundleAPIVersion = "selfhealing.io/v1"
+	policyBundleKind       = "PolicyBundle"
+)
+
+var (
+	policyBundleKeyFiles      = envList("POLICY_BUNDLE_KEYS")
+	policyBundleAllowUnsigned = envBool("POLICY_BUNDLE_ALLOW_UNSIGNED", false)
+
+	policyBundleMu      sync.Mutex
+	policyBundleImports = map[string]int{} // result
+)
+
+// PolicyBundle is the exported policy set
+type PolicyBundle struct {
+	APIVersion string           `json:"apiVersion"`
+	Kind       string           `json:"kind"`
+	Metadata   PolicyBundleMeta `json:"metadata"`
+	Spec       PolicyBundleSpec `json:"spec"`
+	Signature  *AuditSignature  `json:"signature,omitempty"`
+}
+
+type PolicyBundleMeta struct {
+	ExportedAt   time.Time `json:"exportedAt"`
+	ExportedFrom string    `json:"exportedFrom,omitempty"` // PUBLIC_URL or pod name
+	Description  string    `json:"description,omitempty"`
+}
+
+type PolicyBundleSpec struct {
+	Guardrails       map[string]string `json:"guardrails"`
+	DisabledPolicies []string          `json:"disabledPolicies"`
+	Workflows        []Workflow        `json:"workflows"`
+	SeverityActions  map[string]string `json:"severityActions,omitempty"`
+}
+
+type guardrailChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// policyBundleResult answers an import
+type policyBundleResult struct {
+	DryRun           bool                       `json:"dryRun"`
+	SignedBy         string                     `json:"signedBy,omitempty"`
+	GuardrailChanges map[string]guardrailChange `json:"guardrailChanges"`
+	NewlyDisabled    []string                   `json:"newlyDisabled"`
+	Drift            []string                   `json:"drift,omitempty"` // workflows and severityActions that differ
+}
+
+func init() {
+	registerMetrics(writePolicyBundleMetrics)
+}
+
+func exportPolicyBundle(ctx context.Context, description string) (*PolicyBundle, error) {
+	b := &PolicyBundle{
+		APIVersion: policyBundleAPIVersion,
+		Kind:       policyBundleKind,
+		Metadata: PolicyBundleMeta{
+			ExportedAt:   time.Now().UTC().Truncate(time.Second),
+			ExportedFrom: envString("PUBLIC_URL", envString("POD_NAME", "")),
+			Description:  description,
+		},
+		Spec: PolicyBundleSpec{
+			Guardrails:       currentTuning(),
+			DisabledPolicies: []string{},
+			Workflows:        []Workflow{},
+			SeverityActions:  policy.ParseSeverityActions(defaultSeverityActions),
+		},
+	}
+	for _, p := range effectiveness.snapshot() {
+		if p.Disabled {
+			b.Spec.DisabledPolicies = append(b.Spec.DisabledPolicies, p.Policy)
+		}
+	}
+	sort.Strings(b.Spec.DisabledPolicies)
+	workflowsMu.RLock()
+	for _, name := range sortedKeys(workflows) {
+		b.Spec.Workflows = append(b.Spec.Workflows, *workflows[name])
+	}
+	workflowsMu.RUnlock()
+
+	if auditSignerImpl == nil {
+		return b, nil
+	}
+	payload, err := policyBundlePayload(*b)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := auditSignerImpl.Sign(ctx, payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign policy bundle: %v", err)
+	}
+	b.Signature = &AuditSignature{
+		KeyID:     auditSignerImpl.KeyID(),
+		Algorithm: auditSignerImpl.Algorithm(),
+		Value:     base64.StdEncoding.EncodeToString(sig),
+	}
+	return b, nil
+}
+
+// policyBundlePayload is the exact byte string that is signed: the bundle as
+// JSON without its signature
+func policyBundlePayload(b PolicyBundle) ([]byte, error) {
+	b.Signature = nil
+	return json.Marshal(b)
+}
+
+// decodePolicyBundle parses a bundle, rejecting unknown versions and fields
+func decodePolicyBundle(data []byte) (*PolicyBundle, error) {
+	var probe struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("invalid policy bundle: %v", err)
+	}
+	if probe.Kind != policyBundleKind || probe.APIVersion != policyBundleAPIVersion {
+		return nil, fmt.Errorf("unsupported bundle %s %s (want %s %s)", probe.APIVersion, probe.Kind, policyBundleAPIVersion, policyBundleKind)
+	}
+	var b PolicyBundle
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&b); err != nil {
+		return nil, fmt.Errorf("invalid policy bundle: %v", err)
+	}
+	return &b, nil
+}
+
+// verifyPolicyBundle checks the signature against keys and returns the key ID
+// that signed it ("" for an accepted unsigned bundle)
+func verifyPolicyBundle(b *PolicyBundle, keys []crypto.PublicKey, allowUnsigned bool) (string, error) {
+	if b.Signature == nil {
+		if len(keys) > 0 || !allowUnsigned {
+			return "", fmt.Errorf("policy bundle is not signed")
+		}
+		return "", nil
+	}
+	if len(keys) == 0 {
+		if !allowUnsigned {
+			return "", fmt.Errorf("no POLICY_BUNDLE_KEYS to verify the bundle's signature with")
+		}
+		return "", nil
+	}
+	payload, err := policyBundlePayload(*b)
+	if err != nil {
+		return "", err
+	}
+	sig, err := base64.StdEncoding.DecodeString(b.Signature.Value)
+	if err != nil {
+		return "", fmt.Errorf("invalid bundle signature: %v", err)
+	}
+	for _, pub := range keys {
+		if verifySignature(pub, payload, sig) {
+			return b.Signature.KeyID, nil
+		}
+	}
+	return "", fmt.Errorf("bundle signature (key %s) does not verify against the trusted keys", b.Signature.KeyID)
+}
+
+// importPolicyBundle validates the bundle and, unless dryRun, applies its
+// guardrails and disabled policies
+func importPolicyBundle(ctx context.Context, b *PolicyBundle, dryRun bool) (*policyBundleResult, error) {
+	res := &policyBundleResult{DryRun: dryRun, GuardrailChanges: map[string]guardrailChange{}, NewlyDisabled: []string{}}
+
+	var problems []string
+	for _, name := range sortedKeys(b.Spec.Guardrails) {
+		if _, ok := tunables[name]; !ok {
+			problems = append(problems, fmt.Sprintf("guardrail %s: unknown setting", name))
+		}
+	}
+	seen := map[string]bool{}
+	for _, wf := range b.Spec.Workflows {
+		wf.Steps = append([]WorkflowStep(nil), wf.Steps...) // compile fills in the steps
+		if err := wf.compile(); err != nil {
+			problems = append(problems, fmt.Sprintf("workflow %q: %v", wf.Name, err))
+		}
+		if seen[wf.Name] {
+			problems = append(problems, fmt.Sprintf("workflow %q defined twice", wf.Name))
+		}
+		seen[wf.Name] = true
+	}
+	for _, policy := range b.Spec.DisabledPolicies {
+		if alertname, action, ok := strings.Cut(policy, ":"); !ok || alertname == "" || action == "" {
+			problems = append(problems, fmt.Sprintf("disabled policy %q: want alertname:action", policy))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+
+	// applyTuning validates values as it sets them and rolls back on error,
+	// so a dry run applies and then restores them
+	before := currentTuning()
+	if err := applyTuning(b.Spec.Guardrails); err != nil {
+		return nil, fmt.Errorf("guardrails: %v", err)
+	}
+	after := currentTuning()
+	if dryRun {
+		restoreTuning(before)
+	}
+	for _, name := range sortedKeys(after) {
+		if before[name] != after[name] {
+			res.GuardrailChanges[name] = guardrailChange{From: before[name], To: after[name]}
+		}
+	}
+
+	disabled := map[string]bool{}
+	for _, p := range effectiveness.snapshot() {
+		disabled[p.Policy] = p.Disabled
+	}
+	for _, policy := range b.Spec.DisabledPolicies {
+		if !disabled[policy] {
+			res.NewlyDisabled = append(res.NewlyDisabled, policy)
+		}
+	}
+	sort.Strings(res.NewlyDisabled)
+
+	res.Drift = policyBundleDrift(b)
+
+	if dryRun {
+		return res, nil
+	}
+	effectiveness.restoreDisabled(res.NewlyDisabled)
+	for _, policy := range res.NewlyDisabled {
+		if err := store.SavePolicyDisabled(ctx, policy, true); err != nil {
+			return res, fmt.Errorf("applied, but disabled policy %s not saved: %v", policy, err)
+		}
+	}
+	if len(res.GuardrailChanges) > 0 {
+		if err := store.SaveTuning(ctx, after); err != nil {
+			return res, fmt.Errorf("applied, but guardrails not saved: %v", err)
+		}
+	}
+	return res, nil
+}
+
+// policyBundleDrift lists how the bundle's workflows and severity mapping
+// differ from the ones this operator runs with
+func policyBundleDrift(b *PolicyBundle) []string {
+	var drift []string
+	workflowsMu.RLock()
+	theirs := map[string]Workflow{}
+	for _, wf := range b.Spec.Workflows {
+		theirs[wf.Name] = wf
+	}
+	for _, name := range sortedKeys(theirs) {
+		ours, ok := workflows[name]
+		switch {
+		case !ok:
+			drift = append(drift, fmt.Sprintf("workflow %s is not defined here", name))
+		case !sameSteps(ours.Steps, theirs[name].Steps):
+			drift = append(drift, fmt.Sprintf("workflow %s differs", name))
+		}
+	}
+	for _, name := range sortedKeys(workflows) {
+		if _, ok := theirs[name]; !ok {
+			drift = append(drift, fmt.Sprintf("workflow %s is not in the bundle", name))
+		}
+	}
+	workflowsMu.RUnlock()
+
+	ours := policy.ParseSeverityActions(defaultSeverityActions)
+	for _, sev := range sortedKeys(b.Spec.SeverityActions) {
+		if ours[sev] != b.Spec.SeverityActions[sev] {
+			drift = append(drift, fmt.Sprintf("SEVERITY_ACTIONS %s: %q here, %q in the bundle", sev, ours[sev], b.Spec.SeverityActions[sev]))
+		}
+	}
+	for _, sev := range sortedKeys(ours) {
+		if _, ok := b.Spec.SeverityActions[sev]; !ok {
+			drift = append(drift, fmt.Sprintf("SEVERITY_ACTIONS %s: %q here, not in the bundle", sev, ours[sev]))
+		}
+	}
+	return drift
+}
+
+func sameSteps(a, b []WorkflowStep) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return bytes.Equal(x, y)
+}
+
+func policyBundleKeys() ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for _, path := range policyBundleKeyFiles {
+		pub, err := readPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, pub)
+	}
+	return keys, nil
+}
+
+func handlePolicyBundle(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		b, err := exportPolicyBundle(r.Context(), r.URL.Query().Get("description"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(b)
+
+	case http.MethodPost:
+		user, ok := authorizeRequest(w, r, verbTune, "")
+		if !ok {
+			return
+		}
+		dryRun := r.URL.Query().Get("dryRun") == "true"
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		b, err := decodePolicyBundle(data)
+		if err != nil {
+			countPolicyBundleImport("invalid")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		keys, err := policyBundleKeys()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		signedBy, err := verifyPolicyBundle(b, keys, policyBundleAllowUnsigned)
+		if err != nil {
+			countPolicyBundleImport("untrusted")
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), storeTimeout)
+		defer cancel()
+		res, err := importPolicyBundle(ctx, b, dryRun)
+		if err != nil && res == nil {
+			countPolicyBundleImport("invalid")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		res.SignedBy = signedBy
+		if dryRun {
+			countPolicyBundleImport("dry-run")
+		} else {
+			countPolicyBundleImport("applied")
+			log.Printf("Policy bundle from %s (exported %s) imported via admin API%s — %d guardrail change(s), %d policy(ies) disabled, %d drift(s)",
+				b.Metadata.ExportedFrom, b.Metadata.ExportedAt.Format(time.RFC3339), byUser(user),
+				len(res.GuardrailChanges), len(res.NewlyDisabled), len(res.Drift))
+			for _, name := range sortedKeys(res.GuardrailChanges) {
+				c := res.GuardrailChanges[name]
+				log.Printf("Tuning changed by policy bundle — %s: %s -> %s", name, c.From, c.To)
+			}
+		}
+		if err != nil {
+			log.Printf("Failed to persist policy bundle to %s store: %v", store.Name(), err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// runPolicyBundle is the policy-bundle subcommand, for working with exported
+// bundles offline
+func runPolicyBundle(args []string) int {
+	usage := func() int {
+		fmt.Fprintln(os.Stderr, "usage: self-healing-operator policy-bundle verify <public-key.pem> < bundle.json")
+		fmt.Fprintln(os.Stderr, "       self-healing-operator policy-bundle workflows < bundle.json")
+		return 2
+	}
+	if len(args) == 0 {
+		return usage()
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	b, err := decodePolicyBundle(data)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	switch {
+	case args[0] == "verify" && len(args) == 2:
+		pub, err := readPublicKey(args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		if _, err := verifyPolicyBundle(b, []crypto.PublicKey{pub}, false); err != nil {
+			fmt.Printf("FAILED: %v\n", err)
+			return 1
+		}
+		fmt.Printf("OK: bundle exported from %s at %s, signed by %s\n",
+			b.Metadata.ExportedFrom, b.Metadata.ExportedAt.Format(time.RFC3339), b.Signature.KeyID)
+		return 0
+	case args[0] == "workflows" && len(args) == 1:
+		out, err := yaml.Marshal(workflowFile{Workflows: b.Spec.Workflows})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		os.Stdout.Write(out)
+		return 0
+	}
+	return usage()
+}
+
+func countPolicyBundleImport(result string) {
+	policyBundleMu.Lock()
+	policyBundleImports[result]++
+	policyBundleMu.Unlock()
+}
+
+func writePolicyBundleMetrics(w io.Writer) {
+	policyBundleMu.Lock()
+	defer policyBundleMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_policy_bundle_imports_total Policy bundle imports, by result (applied, dry-run, invalid, untrusted).")
+	fmt.Fprintln(w, "# TYPE selfhealing_policy_bundle_imports_total counter")
+	for _, result := range sortedKeys(policyBundleImports) {
+		fmt.Fprintf(w, "selfhealing_policy_bundle_imports_total{result=%q} %d\n", result, policyBundleImports[result])
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// Alert rules from policies. A RemediationPolicy
+// (manifests/operator/crd-remediationpolicy.yaml) describes an alert together
+// with what the operator does about it, and with POLICY_RULES=true the
+// operator generates the PrometheusRule (prometheus-operator) for it, so the
+// rule's recovery_action and thresholds can't drift from the policy:
+//
+//	apiVersion: selfhealing.io/v1alpha1
+//	kind: RemediationPolicy
+//	metadata:
+//	  name: cart-memory
+//	  namespace: shop
+//	spec:
+//	  alertName: CartHighMemory
+//	  expr: container_memory_working_set_bytes{namespace="shop", container="cart"} > ${memory}
+//	  thresholds:
+//	    memory: "500000000"
+//	  for: 5m
+//	  severity: critical
+//	  app: cart
+//	  recoveryAction: restart
+//	  annotations:
+//	    remediation_sla: 10m
+//	  tags:
+//	    team: checkout
+//
+// ${name} in expr is replaced by thresholds[name]. The rule, named
+// selfhealing-<policy>, lives in the policy's namespace and is owned by the
+// policy, so deleting the policy deletes it; its alert's namespace label is
+// always the policy's namespace, so a tenant's policy can only lead to actions
+// in its own namespace. POLICY_RULE_LABELS (key=value, comma-separated) are
+// added to every rule for the Prometheus ruleSelector. tags (keys from
+// REMEDIATION_TAGS, see tags.go) become labels of the rule like labels do. Policies are synced
+// every POLICY_RULES_SYNC; the result is in each policy's status.
+
+var (
+	policyRulesEnabled = envBool("POLICY_RULES", false)
+	policyRulesSync    = envDuration("POLICY_RULES_SYNC", time.Minute)
+	policyRuleLabels   = envList("POLICY_RULE_LABELS")
+
+	remediationPolicyGVR = schema.GroupVersionResource{Group: policyGroup, Version: "v1alpha1", Resource: policyResource}
+	prometheusRuleGVR    = schema.GroupVersionResource{Group: "monitoring.coreos.com", Version: "v1", Resource: "prometheusrules"}
+
+	thresholdRef     = regexp.MustCompile(`\$\{([A-Za-z0-9_]+)\}`)
+	promDurationExpr = regexp.MustCompile(`^([0-9]+(ms|s|m|h|d|w|y))+$`)
+
+	policyRulesMu      sync.Mutex
+	policyRulesResults = map[string]int{} // result
+	policyRulesSynced  int                // policies whose rule is current, as of the last sync
+)
+
+// RemediationPolicySpec is the spec of a RemediationPolicy
+type RemediationPolicySpec struct {
+	AlertName      string            `json:"alertName"`
+	Expr           string            `json:"expr"`
+	Thresholds     map[string]string `json:"thresholds,omitempty"`
+	For            string            `json:"for,omitempty"`
+	Severity       string            `json:"severity,omitempty"`
+	App            string            `json:"app,omitempty"`
+	RecoveryAction string            `json:"recoveryAction"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Annotations    map[string]string `json:"annotations,omitempty"`
+	Tags           map[string]string `json:"tags,omitempty"`
+}
+
+// promRule and promRuleGroup are the parts of a PrometheusRule spec the operator writes
+type promRule struct {
+	Alert       string            `json:"alert"`
+	Expr        string            `json:"expr"`
+	For         string            `json:"for,omitempty"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type promRuleGroup struct {
+	Name  string     `json:"name"`
+	Rules []promRule `json:"rules"`
+}
+
+func init() {
+	registerMetrics(writePolicyRulesMetrics)
+}
+
+// policyRule builds the alert rule of a policy
+func policyRule(namespace string, spec RemediationPolicySpec) (promRule, error) {
+	if spec.AlertName == "" || spec.Expr == "" || spec.RecoveryAction == "" {
+		return promRule{}, fmt.Errorf("alertName, expr and recoveryAction are required")
+	}
+	known := false
+	for _, a := range recoveryActionNames {
+		known = known || a == spec.RecoveryAction
+	}
+	if !known {
+		return promRule{}, fmt.Errorf("unknown recoveryAction %q", spec.RecoveryAction)
+	}
+	if spec.For != "" && !promDurationExpr.MatchString(spec.For) {
+		return promRule{}, fmt.Errorf("invalid for %q", spec.For)
+	}
+	var missing []string
+	expr := thresholdRef.ReplaceAllStringFunc(spec.Expr, func(ref string) string {
+		name := thresholdRef.FindStringSubmatch(ref)[1]
+		v, ok := spec.Thresholds[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return promRule{}, fmt.Errorf("expr refers to thresholds not in the policy: %s", strings.Join(missing, ", "))
+	}
+
+	labels := map[string]string{}
+	for k, v := range spec.Labels {
+		labels[k] = v
+	}
+	for _, k := range sortedKeys(spec.Tags) {
+		if !isTagKey(k) {
+			return promRule{}, fmt.Errorf("tag %q isn't one of REMEDIATION_TAGS (%s)", k, strings.Join(remediationTagKeys, ", "))
+		}
+		labels[k] = spec.Tags[k]
+	}
+	labels["recovery_action"] = spec.RecoveryAction
+	labels["severity"] = spec.Severity
+	if labels["severity"] == "" {
+		labels["severity"] = "warning"
+	}
+	if spec.App != "" {
+		labels["app"] = spec.App
+	}
+	// the tenant's own namespace, whatever the expression returns
+	labels["namespace"] = namespace
+	return promRule{Alert: spec.AlertName, Expr: expr, For: spec.For, Labels: labels, Annotations: spec.Annotations}, nil
+}
+
+// policyRuleObject is the PrometheusRule generated for a policy
+func policyRuleObject(policy *unstructured.Unstructured, rule promRule) (*unstructured.Unstructured, error) {
+	group := promRuleGroup{Name: "selfhealing." + policy.GetNamespace() + "." + policy.GetName(), Rules: []promRule{rule}}
+	data, err := json.Marshal(map[string]interface{}{"groups": []promRuleGroup{group}})
+	if err != nil {
+		return nil, err
+	}
+	var spec map[string]interface{}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, err
+	}
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	obj.SetAPIVersion(prometheusRuleGVR.Group + "/" + prometheusRuleGVR.Version)
+	obj.SetKind("PrometheusRule")
+	obj.SetNamespace(policy.GetNamespace())
+	obj.SetName("selfhealing-" + policy.GetName())
+	labels := map[string]string{managedByLabel: managedByValue}
+	for _, kv := range policyRuleLabels {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			labels[k] = v
+		}
+	}
+	obj.SetLabels(labels)
+	controller := true
+	obj.SetOwnerReferences([]metav1.OwnerReference{{
+		APIVersion: policy.GetAPIVersion(),
+		Kind:       policy.GetKind(),
+		Name:       policy.GetName(),
+		UID:        policy.GetUID(),
+		Controller: &controller,
+	}})
+	return obj, nil
+}
+
+// syncPolicyRule creates or updates a policy's PrometheusRule; it reports whether it changed anything
+func syncPolicyRule(ctx context.Context, dyn dynamic.Interface, policy *unstructured.Unstructured) (bool, error) {
+	var spec RemediationPolicySpec
+	data, _ := json.Marshal(policy.Object["spec"])
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return false, fmt.Errorf("invalid spec: %v", err)
+	}
+	rule, err := policyRule(policy.GetNamespace(), spec)
+	if err != nil {
+		return false, err
+	}
+	want, err := policyRuleObject(policy, rule)
+	if err != nil {
+		return false, err
+	}
+
+	rules := dyn.Resource(prometheusRuleGVR).Namespace(policy.GetNamespace())
+	have, err := rules.Get(ctx, want.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = rules.Create(ctx, want, metav1.CreateOptions{})
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+	owned := false
+	for _, ref := range have.GetOwnerReferences() {
+		owned = owned || ref.UID == policy.GetUID()
+	}
+	if !owned {
+		return false, fmt.Errorf("PrometheusRule %s already exists and isn't owned by this policy", want.GetName())
+	}
+	if reflect.DeepEqual(have.Object["spec"], want.Object["spec"]) && reflect.DeepEqual(have.GetLabels(), want.GetLabels()) {
+		return false, nil
+	}
+	have.Object["spec"] = want.Object["spec"]
+	have.SetLabels(want.GetLabels())
+	_, err = rules.Update(ctx, have, metav1.UpdateOptions{})
+	return err == nil, err
+}
+
+// syncPolicyRules brings every policy's PrometheusRule up to date
+func syncPolicyRules(ctx context.Context, dyn dynamic.Interface) error {
+	list, err := dyn.Resource(remediationPolicyGVR).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list RemediationPolicies: %v", err)
+	}
+	synced := 0
+	for i := range list.Items {
+		policy := &list.Items[i]
+		if shardingEnabled {
+			if owner, _ := shards.owner(policy.GetNamespace()); owner != shards.self {
+				continue
+			}
+		}
+		changed, err := syncPolicyRule(ctx, dyn, policy)
+		status := map[string]interface{}{
+			"rule":               "selfhealing-" + policy.GetName(),
+			"observedGeneration": policy.GetGeneration(),
+			"phase":              "Synced",
+		}
+		result := "unchanged"
+		switch {
+		case err != nil:
+			status["phase"], status["message"] = "Failed", err.Error()
+			result = "failed"
+			log.Printf("Failed to sync the PrometheusRule of RemediationPolicy %s/%s: %v", policy.GetNamespace(), policy.GetName(), err)
+		case changed:
+			result = "updated"
+			log.Printf("PrometheusRule selfhealing-%s in %s updated from its RemediationPolicy", policy.GetName(), policy.GetNamespace())
+		}
+		if err == nil {
+			synced++
+		}
+		policyRulesMu.Lock()
+		policyRulesResults[result]++
+		policyRulesMu.Unlock()
+
+		if old, _, _ := unstructured.NestedMap(policy.Object, "status"); !reflect.DeepEqual(stripSyncTime(old), status) {
+			status["lastSyncTime"] = time.Now().UTC().Format(time.RFC3339)
+			policy.Object["status"] = status
+			if _, err := dyn.Resource(remediationPolicyGVR).Namespace(policy.GetNamespace()).UpdateStatus(ctx, policy, metav1.UpdateOptions{}); err != nil {
+				log.Printf("Failed to update the status of RemediationPolicy %s/%s: %v", policy.GetNamespace(), policy.GetName(), err)
+			}
+		}
+	}
+	policyRulesMu.Lock()
+	policyRulesSynced = synced
+	policyRulesMu.Unlock()
+	return nil
+}
+
+// stripSyncTime drops the timestamp, so an unchanged status isn't rewritten on every sync
+func stripSyncTime(status map[string]interface{}) map[string]interface{} {
+	if status == nil {
+		return nil
+	}
+	delete(status, "lastSyncTime")
+	return status
+}
+
+// startPolicyRules syncs the policies' PrometheusRules every POLICY_RULES_SYNC
+func startPolicyRules(config *rest.Config) {
+	if !policyRulesEnabled {
+		return
+	}
+	dyn, err := dynamic.NewForConfig(config)
+	if err != nil {
+		log.Fatalf("Failed to create dynamic client for POLICY_RULES: %v", err)
+	}
+	log.Printf("Generating PrometheusRules from RemediationPolicies every %s", policyRulesSync)
+	go func() {
+		ticker := time.NewTicker(policyRulesSync)
+		defer ticker.Stop()
+		for {
+			ctx, cancel := context.WithTimeout(operatorCtx, time.Minute)
+			if err := syncPolicyRules(ctx, dyn); err != nil {
+				log.Printf("Policy rule sync failed: %v", err)
+			}
+			cancel()
+			select {
+			case <-operatorCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func writePolicyRulesMetrics(w io.Writer) {
+	if !policyRulesEnabled {
+		return
+	}
+	policyRulesMu.Lock()
+	defer policyRulesMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_policy_rule_syncs_total PrometheusRule syncs from RemediationPolicies, by result (unchanged, updated, failed).")
+	fmt.Fprintln(w, "# TYPE selfhealing_policy_rule_syncs_total counter")
+	for _, result := range sortedKeys(policyRulesResults) {
+		fmt.Fprintf(w, "selfhealing_policy_rule_syncs_total{result=%q} %d\n", result, policyRulesResults[result])
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_policy_rules_synced RemediationPolicies whose PrometheusRule is current, as of the last sync.")
+	fmt.Fprintln(w, "# TYPE selfhealing_policy_rules_synced gauge")
+	fmt.Fprintf(w, "selfhealing_policy_rules_synced %d\n", policyRulesSynced)
+}
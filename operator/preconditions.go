@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"sigs.k8s.io/yaml"
+)
+
+// PromQL preconditions. An alert can be minutes old by the time its action
+// runs (group_wait, spread, safe mode, an approval), so a policy may ask
+// Prometheus whether the problem is still there immediately before acting.
+// PRECONDITIONS_FILE maps policies ("alertname:action", with * wildcards) to
+// queries; every matching entry applies. A query passes when it returns at
+// least one sample (like an alerting rule), or, with `expect: empty`, when it
+// returns none. Queries may use {{alertname}}, {{namespace}}, {{app}},
+// {{pod}} and {{node}}:
+//
+//	- policy: "HighErrorRate:*"
+//	  preconditions:
+//	  - name: error-rate-still-high
+//	    query: 'sum(rate(http_requests_total{namespace="{{namespace}}",app="{{app}}",status=~"5.."}[2m]))
+//	      / sum(rate(http_requests_total{namespace="{{namespace}}",app="{{app}}"}[2m])) > 0.05'
+//	- policy: "*:restart"
+//	  preconditions:
+//	  - name: fewer-than-20pct-unready
+//	    query: 'avg(kube_pod_status_ready{namespace="{{namespace}}",pod=~"{{app}}-.*",condition="false"}) >= 0.2'
+//	    expect: empty
+//
+// A failed precondition skips the action without starting a cooldown, so the
+// next notification is judged afresh. If Prometheus can't be queried the
+// action is skipped too, unless PRECONDITION_ON_ERROR=run.
+
+var (
+	prometheusURL       = strings.TrimRight(envString("PROMETHEUS_URL", "http://prometheus.monitoring:9090"), "/")
+	preconditionOnError = envString("PRECONDITION_ON_ERROR", "skip")
+
+	preconditionRules []preconditionRule
+
+	preconditionMu      sync.Mutex
+	preconditionResults = map[string]int{} // name|result -> count
+)
+
+type preconditionRule struct {
+	Policy        string         `json:"policy"`
+	Preconditions []precondition `json:"preconditions"`
+}
+
+type precondition struct {
+	Name   string `json:"name"`
+	Query  string `json:"query"`
+	Expect string `json:"expect"` // results (default) or empty
+}
+
+func init() {
+	registerMetrics(writePreconditionMetrics)
+}
+
+// setupPreconditions loads PRECONDITIONS_FILE, if set
+func setupPreconditions() {
+	file := os.Getenv("PRECONDITIONS_FILE")
+	if file == "" {
+		return
+	}
+	if err := loadPreconditions(file); err != nil {
+		log.Fatalf("PRECONDITIONS_FILE: %v", err)
+	}
+	log.Printf("PromQL preconditions enabled — %d rule(s), Prometheus at %s", len(preconditionRules), prometheusURL)
+}
+
+func loadPreconditions(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read: %v", err)
+	}
+	if err := yaml.Unmarshal(data, &preconditionRules); err != nil {
+		return fmt.Errorf("failed to parse: %v", err)
+	}
+	for _, r := range preconditionRules {
+		if _, err := path.Match(r.Policy, ""); err != nil {
+			return fmt.Errorf("bad policy pattern %q: %v", r.Policy, err)
+		}
+		for _, p := range r.Preconditions {
+			if p.Name == "" || p.Query == "" {
+				return fmt.Errorf("every precondition of %q needs a name and a query", r.Policy)
+			}
+			if p.Expect != "" && p.Expect != "results" && p.Expect != "empty" {
+				return fmt.Errorf("precondition %s: expect must be results or empty", p.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// preconditionsFor returns every precondition whose policy pattern matches the action
+func preconditionsFor(action *RecoveryAction) []precondition {
+	var out []precondition
+	for _, r := range preconditionRules {
+		if ok, _ := path.Match(r.Policy, policyKey(action)); ok {
+			out = append(out, r.Preconditions...)
+		}
+	}
+	return out
+}
+
+// checkPreconditions evaluates the action's preconditions, adds the result to
+// its explain trace, and describes the first one that doesn't hold, or returns ""
+func checkPreconditions(ctx context.Context, action *RecoveryAction) string {
+	checks := preconditionsFor(action)
+	if len(checks) == 0 {
+		return ""
+	}
+	vars := strings.NewReplacer(
+		"{{alertname}}", action.AlertName,
+		"{{namespace}}", action.Namespace,
+		"{{app}}", action.App,
+		"{{pod}}", action.Pod,
+		"{{node}}", action.Labels["node"],
+	)
+	for _, p := range checks {
+		samples, err := queryPrometheus(ctx, vars.Replace(p.Query))
+		result := "pass"
+		var reason string
+		switch {
+		case err != nil:
+			result = "error"
+			if preconditionOnError != "run" {
+				reason = fmt.Sprintf("precondition %s could not be checked: %v", p.Name, err)
+			} else {
+				log.Printf("Precondition %s could not be checked, running anyway (PRECONDITION_ON_ERROR=run): %v", p.Name, err)
+			}
+		case p.Expect == "empty" && samples > 0:
+			result = "fail"
+			reason = fmt.Sprintf("precondition %s no longer holds (%d sample(s), expected none)", p.Name, samples)
+		case p.Expect != "empty" && samples == 0:
+			result = "fail"
+			reason = fmt.Sprintf("precondition %s no longer holds (query returned nothing)", p.Name)
+		}
+		preconditionMu.Lock()
+		preconditionResults[p.Name+"|"+result]++
+		preconditionMu.Unlock()
+		if reason != "" {
+			action.Explain.add("preconditions", traceSkip, reason)
+			return reason
+		}
+	}
+	action.Explain.add("preconditions", tracePass, fmt.Sprintf("%d checked", len(checks)))
+	return ""
+}
+
+// queryPrometheus runs an instant query and returns the number of samples;
+// a scalar counts as one sample unless it is 0
+func queryPrometheus(ctx context.Context, query string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, prometheusURL+"/api/v1/query?"+url.Values{"query": {query}}.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := egressClient("prometheus").Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			ResultType string          `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, fmt.Errorf("Prometheus returned %s", resp.Status)
+	}
+	if body.Status != "success" {
+		return 0, fmt.Errorf("query failed: %s", body.Error)
+	}
+	switch body.Data.ResultType {
+	case "scalar":
+		var value [2]interface{}
+		if err := json.Unmarshal(body.Data.Result, &value); err != nil {
+			return 0, err
+		}
+		if value[1] == "0" {
+			return 0, nil
+		}
+		return 1, nil
+	default:
+		var samples []json.RawMessage
+		if err := json.Unmarshal(body.Data.Result, &samples); err != nil {
+			return 0, fmt.Errorf("unexpected %s result: %v", body.Data.ResultType, err)
+		}
+		return len(samples), nil
+	}
+}
+
+func writePreconditionMetrics(w io.Writer) {
+	preconditionMu.Lock()
+	defer preconditionMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_precondition_checks_total PromQL precondition evaluations, by precondition and result (pass, fail, error).")
+	fmt.Fprintln(w, "# TYPE selfhealing_precondition_checks_total counter")
+	for _, key := range sortedKeys(preconditionResults) {
+		name, result, _ := strings.Cut(key, "|")
+		fmt.Fprintf(w, "selfhealing_precondition_checks_total{precondition=%q,result=%q} %d\n", name, result, preconditionResults[key])
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Action previews. A recommendation — an action waiting for approval, in
+// MODE=recommend or in observation — carries a preview of what approving it
+// would change, worked out against the live cluster when it's published, so
+// approvers don't have to go and look:
+//   - restart: the pod that's deleted
+//   - redeploy: the Deployment's template change and the pods it replaces
+//   - scale: replicas before and after (SCALE_STEP, capped by max-replicas)
+//   - node runbooks and drains: the node and the pods evicted from it
+//   - patch_resource: the object diff, from a server-side dry run of the patch
+//   - delete_resource and clear_finalizers: the object and what's removed
+//
+// The preview is in the Slack message, the Kubernetes Event and
+// /api/v1/recommendations. It's a snapshot: the cluster can change before the
+// approval, and the action re-checks everything when it runs.
+
+// maxPreviewPods is how many affected pods a preview names
+const maxPreviewPods = 10
+
+// ActionPreview is what approving an action would change
+type ActionPreview struct {
+	Summary  string         `json:"summary"`
+	Changes  []ObjectChange `json:"changes,omitempty"` // object diffs, as recorded in the history
+	Node     string         `json:"node,omitempty"`
+	Pods     []string       `json:"pods,omitempty"`     // namespace/name of the pods affected
+	MorePods int            `json:"morePods,omitempty"` // affected pods not listed
+	Error    string         `json:"error,omitempty"`    // why the preview is incomplete
+}
+
+// Lines renders the preview's details for messages
+func (p *ActionPreview) Lines() []string {
+	var lines []string
+	for _, c := range p.Changes {
+		for _, f := range c.Fields {
+			lines = append(lines, fmt.Sprintf("%s %s/%s %s: %s → %s", c.Kind, c.Namespace, c.Name, f.Path, previewValue(f.Before), previewValue(f.After)))
+		}
+		if c.Truncated {
+			lines = append(lines, fmt.Sprintf("%s %s/%s: more fields change", c.Kind, c.Namespace, c.Name))
+		}
+	}
+	if len(p.Pods) > 0 {
+		pods := strings.Join(p.Pods, ", ")
+		if p.MorePods > 0 {
+			pods += fmt.Sprintf(" and %d more", p.MorePods)
+		}
+		lines = append(lines, "pods affected: "+pods)
+	}
+	if p.Error != "" {
+		lines = append(lines, "preview incomplete: "+p.Error)
+	}
+	return lines
+}
+
+func previewValue(v interface{}) string {
+	if v == nil {
+		return "(none)"
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	s := string(data)
+	if len(s) > 120 {
+		s = s[:117] + "..."
+	}
+	return s
+}
+
+// previewAction works out what running the action would change, without changing anything
+func previewAction(ctx context.Context, action *RecoveryAction, alert Alert) *ActionPreview {
+	if clients.Kube == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	p := &ActionPreview{}
+	var err error
+	switch action.Action {
+	case "restart":
+		err = previewRestart(ctx, action, p)
+	case "redeploy":
+		err = previewRedeploy(ctx, action, p)
+	case "scale":
+		err = previewScale(ctx, action, p)
+	case "runbook":
+		if node := action.Labels["node"]; node != "" && strings.HasPrefix(action.Runbook, "node-") {
+			p.Summary = fmt.Sprintf("runbook %s cordons node %s and evicts its pods", action.Runbook, node)
+			err = previewNodePods(ctx, node, p)
+		} else {
+			p.Summary = "runbook " + action.Runbook + " on " + action.Namespace + "/" + action.App
+		}
+	case "node_cleanup":
+		p.Node = action.Labels["node"]
+		p.Summary = "truncates large container logs and prunes unused images on node " + p.Node + "; no pods are evicted"
+	case "patch_resource":
+		err = previewPatchResource(ctx, action, alert, p)
+	case "delete_resource":
+		var target resourceTarget
+		if target, err = resolveResourceTarget(action, resourceParams(alert)); err == nil {
+			p.Summary = "deletes " + target.String()
+		}
+	case "clear_finalizers":
+		var t finalizerTarget
+		if t, err = finalizerTargetFor(clients.Kube, action.Labels); err == nil {
+			p.Summary = fmt.Sprintf("removes the allowlisted finalizers from %s %s", t.kind, strings.TrimPrefix(t.namespace+"/"+t.name, "/"))
+		}
+	default:
+		p.Summary = fmt.Sprintf("%s on %s/%s", action.Action, action.Namespace, action.App)
+	}
+	if err != nil {
+		p.Error = err.Error()
+	}
+	if p.Summary == "" {
+		p.Summary = fmt.Sprintf("%s on %s/%s", action.Action, action.Namespace, action.App)
+	}
+	return p
+}
+
+func previewRestart(ctx context.Context, action *RecoveryAction, p *ActionPreview) error {
+	if action.Pod == "" {
+		return fmt.Errorf("no pod name in alert labels")
+	}
+	p.Summary = fmt.Sprintf("deletes pod %s/%s; its controller creates a replacement", action.Namespace, action.Pod)
+	p.Pods = []string{action.Namespace + "/" + action.Pod}
+	pod := cachedPod(action.Namespace, action.Pod)
+	if pod == nil {
+		live, err := clients.Kube.CoreV1().Pods(action.Namespace).Get(ctx, action.Pod, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		pod = live
+	}
+	p.Node = pod.Spec.NodeName
+	return nil
+}
+
+func previewRedeploy(ctx context.Context, action *RecoveryAction, p *ActionPreview) error {
+	dep, err := findDeployment(ctx, clients.Kube, action.Namespace, action.App)
+	if err != nil {
+		return err
+	}
+	after := dep.DeepCopy()
+	if after.Spec.Template.Annotations == nil {
+		after.Spec.Template.Annotations = map[string]string{}
+	}
+	after.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
+	preview := &RecoveryAction{}
+	recordChange(preview, "Deployment", dep.Namespace, dep.Name, dep, after)
+	p.Changes = preview.Changes
+	replicas := int32(1)
+	if dep.Spec.Replicas != nil {
+		replicas = *dep.Spec.Replicas
+	}
+	p.Summary = fmt.Sprintf("rolling restart of Deployment %s/%s: all %d pod(s) are replaced", dep.Namespace, dep.Name, replicas)
+	return previewPods(ctx, dep.Namespace, metav1.FormatLabelSelector(dep.Spec.Selector), p)
+}
+
+func previewScale(ctx context.Context, action *RecoveryAction, p *ActionPreview) error {
+	dep, err := findDeployment(ctx, clients.Kube, action.Namespace, action.App)
+	if err != nil {
+		return err
+	}
+	current := int32(1)
+	if dep.Spec.Replicas != nil {
+		current = *dep.Spec.Replicas
+	}
+	step := int32(scaleStep)
+	if step < 1 {
+		step = 1
+	}
+	if limit := workloadOverridesFor(ctx, action).maxReplicas; limit > 0 && current+step > limit {
+		step = limit - current
+	}
+	if step <= 0 {
+		p.Summary = fmt.Sprintf("Deployment %s/%s is already at its max-replicas (%d): nothing would change", dep.Namespace, dep.Name, current)
+		return nil
+	}
+	after := dep.DeepCopy()
+	after.Spec.Replicas = &[]int32{current + step}[0]
+	preview := &RecoveryAction{}
+	recordChange(preview, "Deployment", dep.Namespace, dep.Name, dep, after)
+	p.Changes = preview.Changes
+	p.Summary = fmt.Sprintf("scales Deployment %s/%s from %d to %d replicas (quota permitting)", dep.Namespace, dep.Name, current, current+step)
+	return nil
+}
+
+func previewPatchResource(ctx context.Context, action *RecoveryAction, alert Alert, p *ActionPreview) error {
+	params := resourceParams(alert)
+	target, err := resolveResourceTarget(action, params)
+	if err != nil {
+		return err
+	}
+	p.Summary = "patches " + target.String()
+	patch, err := expandLabelRefs(params["patch"], action.Labels)
+	if err != nil {
+		return err
+	}
+	patchType := types.MergePatchType
+	if params["patchType"] == "json" {
+		patchType = types.JSONPatchType
+	}
+	dyn, err := clients.DynamicFor(ctx, target.namespace)
+	if err != nil {
+		return err
+	}
+	objects := dyn.Resource(target.gvr).Namespace(target.namespace)
+	before, err := objects.Get(ctx, target.name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	after, err := objects.Patch(ctx, target.name, patchType, []byte(patch), metav1.PatchOptions{DryRun: []string{metav1.DryRunAll}, FieldManager: "self-healing-operator"})
+	if err != nil {
+		return fmt.Errorf("dry run of the patch failed: %v", err)
+	}
+	preview := &RecoveryAction{}
+	recordResourceChange(preview, before.GetKind(), target, before.Object, after.Object)
+	p.Changes = preview.Changes
+	return nil
+}
+
+// previewNodePods lists the pods a drain of the node would evict
+func previewNodePods(ctx context.Context, node string, p *ActionPreview) error {
+	p.Node = node
+	pods, err := drainablePods(ctx, clients.Kube, node)
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		p.addPod(pod.Namespace + "/" + pod.Name)
+	}
+	return nil
+}
+
+// previewPods lists the pods matching the selector
+func previewPods(ctx context.Context, namespace, selector string, p *ActionPreview) error {
+	pods, err := clients.Kube.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods.Items {
+		p.addPod(pod.Namespace + "/" + pod.Name)
+	}
+	return nil
+}
+
+func (p *ActionPreview) addPod(name string) {
+	if len(p.Pods) < maxPreviewPods {
+		p.Pods = append(p.Pods, name)
+	} else {
+		p.MorePods++
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Priority-aware restarts. When one batch of alerts acts on several
+// workloads, they run in tiers by pod priority, lowest first: each tier
+// finishes before the next starts, so low-priority pods give way before
+// important ones are touched.
+//
+// A pod with priority RESTART_FEASIBILITY_MIN_PRIORITY or higher is only
+// restarted if its replacement can be scheduled right now: some Ready,
+// schedulable node of its OS whose taints it tolerates and whose labels match
+// its nodeSelector must have room for its CPU and memory requests. Its own slot
+// counts as free, unless pending pods of the same or higher priority are
+// waiting to take it. Affinity and topology spread are not evaluated.
+
+var (
+	restartPriorityOrder          = envBool("RESTART_PRIORITY_ORDER", true)
+	restartFeasibilityMinPriority = int32(envInt("RESTART_FEASIBILITY_MIN_PRIORITY", 1))
+)
+
+func podPriority(p *corev1.Pod) int32 {
+	if p == nil || p.Spec.Priority == nil {
+		return 0
+	}
+	return *p.Spec.Priority
+}
+
+// jobsPriority is the lowest priority of the pods a workload's jobs act on
+func jobsPriority(list []workloadJob) int32 {
+	var lowest int32
+	for i, j := range list {
+		p := podPriority(cachedPod(j.action.Namespace, j.action.Pod))
+		if i == 0 || p < lowest {
+			lowest = p
+		}
+	}
+	return lowest
+}
+
+// priorityTiers groups the workloads of a batch by priority, lowest first
+func priorityTiers(jobs map[string][]workloadJob) [][]string {
+	keys := sortedKeys(jobs)
+	if !restartPriorityOrder || len(keys) < 2 {
+		return [][]string{keys}
+	}
+	byPriority := map[int32][]string{}
+	var priorities []int32
+	for _, key := range keys {
+		p := jobsPriority(jobs[key])
+		if _, ok := byPriority[p]; !ok {
+			priorities = append(priorities, p)
+		}
+		byPriority[p] = append(byPriority[p], key)
+	}
+	sort.Slice(priorities, func(i, j int) bool { return priorities[i] < priorities[j] })
+	tiers := make([][]string, 0, len(priorities))
+	for _, p := range priorities {
+		tiers = append(tiers, byPriority[p])
+	}
+	return tiers
+}
+
+// checkRescheduleFeasible refuses to delete a high-priority pod that has nowhere to go
+func checkRescheduleFeasible(ctx context.Context, cs kubernetes.Interface, pod *corev1.Pod) error {
+	priority := podPriority(pod)
+	if priority < restartFeasibilityMinPriority {
+		return nil
+	}
+	node, err := fittingNode(ctx, cs, pod, "")
+	if err != nil {
+		return err
+	}
+	if node == "" {
+		return fmt.Errorf("pod %s/%s (priority %d) could not be rescheduled: no node has room for it", pod.Namespace, pod.Name, priority)
+	}
+	return nil
+}
+
+// fittingNode returns a node other than exclude that the pod would fit on, or "" if there is none
+func fittingNode(ctx context.Context, cs kubernetes.Interface, pod *corev1.Pod, exclude string) (string, error) {
+	priority := podPriority(pod)
+	nodes, err := cs.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to list nodes for the scheduling check: %v", err)
+	}
+	pods, err := allPods(ctx, cs)
+	if err != nil {
+		return "", fmt.Errorf("failed to list pods for the scheduling check: %v", err)
+	}
+
+	creditOwnSlot := true
+	for i := range pods {
+		if p := &pods[i]; p.Spec.NodeName == "" && p.Status.Phase == corev1.PodPending && podPriority(p) >= priority {
+			creditOwnSlot = false
+		}
+	}
+	used := map[string]corev1.ResourceList{}
+	for i := range pods {
+		p := &pods[i]
+		if p.Spec.NodeName == "" || p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if creditOwnSlot && p.UID == pod.UID {
+			continue
+		}
+		addRequests(used, p.Spec.NodeName, p)
+	}
+
+	want := podRequests(pod)
+	for i := range nodes.Items {
+		if n := &nodes.Items[i]; n.Name != exclude && nodeFits(n, pod, want, used[n.Name]) {
+			return n.Name, nil
+		}
+	}
+	return "", nil
+}
+
+func allPods(ctx context.Context, cs kubernetes.Interface) ([]corev1.Pod, error) {
+	if podLister != nil {
+		cached, err := podLister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		out := make([]corev1.Pod, 0, len(cached))
+		for _, p := range cached {
+			out = append(out, *p)
+		}
+		return out, nil
+	}
+	list, err := cs.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// podRequests sums the CPU and memory requests of a pod's containers, counting one pod slot
+func podRequests(p *corev1.Pod) corev1.ResourceList {
+	out := corev1.ResourceList{corev1.ResourcePods: resource.MustParse("1")}
+	for _, c := range p.Spec.Containers {
+		for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			if q, ok := c.Resources.Requests[name]; ok {
+				sum := out[name]
+				sum.Add(q)
+				out[name] = sum
+			}
+		}
+	}
+	return out
+}
+
+func addRequests(used map[string]corev1.ResourceList, node string, p *corev1.Pod) {
+	if used[node] == nil {
+		used[node] = corev1.ResourceList{}
+	}
+	for name, q := range podRequests(p) {
+		sum := used[node][name]
+		sum.Add(q)
+		used[node][name] = sum
+	}
+}
+
+func nodeFits(node *corev1.Node, pod *corev1.Pod, want, used corev1.ResourceList) bool {
+	if node.Spec.Unschedulable {
+		return false
+	}
+	if ready, _ := nodeReady(node); !ready {
+		return false
+	}
+	if os := podOS(pod); os != "" && os != nodeOS(node) {
+		return false
+	}
+	for k, v := range pod.Spec.NodeSelector {
+		if node.Labels[k] != v {
+			return false
+		}
+	}
+	for i := range node.Spec.Taints {
+		t := &node.Spec.Taints[i]
+		if t.Effect == corev1.TaintEffectPreferNoSchedule {
+			continue
+		}
+		tolerated := false
+		for _, tol := range pod.Spec.Tolerations {
+			if tol.ToleratesTaint(t) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return false
+		}
+	}
+	for name, q := range want {
+		free := node.Status.Allocatable[name].DeepCopy()
+		free.Sub(used[name])
+		if free.Cmp(q) < 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Quota-aware scaling: before the scale action adds SCALE_STEP replicas it
+// works out what one more pod costs (container requests and limits, with the
+// namespace's LimitRange defaults filled in) and how many of those still fit
+// in every ResourceQuota of the namespace. When they don't all fit,
+// SCALE_QUOTA_POLICY decides:
+//
+//	skip  don't scale, and say which quota is full (default)
+//	fit   add as many replicas as fit; skip if none does
+//	bump  ask for a temporary quota increase; once it's approved through
+//	      the link posted to Slack (or /api/v1/quota-bumps/approve) the
+//	      quotas are raised just enough and the scale runs
+//
+// Bumped quotas keep their original values in an annotation and are put back
+// after QUOTA_BUMP_TTL, by whichever replica notices first. Scoped quotas are
+// counted as if they applied to the pod, which errs on the side of skipping.
+
+const (
+	quotaPolicySkip = "skip"
+	quotaPolicyFit  = "fit"
+	quotaPolicyBump = "bump"
+
+	quotaOriginalAnno  = "selfhealing.io/quota-original"
+	quotaBumpUntilAnno = "selfhealing.io/quota-bump-until"
+)
+
+var (
+	scaleStep        = envInt("SCALE_STEP", 1)
+	scaleQuotaPolicy = envString("SCALE_QUOTA_POLICY", quotaPolicySkip)
+	quotaBumpTTL     = envDuration("QUOTA_BUMP_TTL", time.Hour)
+
+	quotaMu       sync.Mutex
+	quotaBumps    = map[int]*QuotaBump{}
+	nextQuotaBump = 1
+	quotaResults  = map[string]int{} // skipped, fitted, bump-requested, bumped, reverted
+)
+
+func init() {
+	registerMetrics(writeQuotaMetrics)
+}
+
+// QuotaBump is a pending or approved request to raise a namespace's quotas
+type QuotaBump struct {
+	ID        int           `json:"id"`
+	Namespace string        `json:"namespace"`
+	App       string        `json:"app"`
+	Changes   []quotaChange `json:"changes"`
+	CreatedAt time.Time     `json:"createdAt"`
+	ExpiresAt time.Time     `json:"expiresAt"`
+	Status    string        `json:"status"` // pending, approved, expired
+
+	action *RecoveryAction
+	alert  Alert
+}
+
+type quotaChange struct {
+	Quota    string `json:"quota"`
+	Resource string `json:"resource"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+}
+
+// quotaFit is how many more replicas the namespace's quotas allow
+type quotaFit struct {
+	replicas int32 // -1: no quota limits the pod
+	reason   string
+	perPod   corev1.ResourceList
+	quotas   []corev1.ResourceQuota
+}
+
+// podCost returns what one pod of the template counts against quota, with
+// LimitRange container defaults applied; a container over a LimitRange
+// maximum makes the pod unadmittable
+func podCost(spec corev1.PodSpec, ranges []corev1.LimitRange) (corev1.ResourceList, error) {
+	cost := corev1.ResourceList{corev1.ResourcePods: resource.MustParse("1")}
+	add := func(name corev1.ResourceName, q resource.Quantity) {
+		sum := cost[name]
+		sum.Add(q)
+		cost[name] = sum
+	}
+	for _, c := range spec.Containers {
+		requests, limits := c.Resources.Requests.DeepCopy(), c.Resources.Limits.DeepCopy()
+		if requests == nil {
+			requests = corev1.ResourceList{}
+		}
+		if limits == nil {
+			limits = corev1.ResourceList{}
+		}
+		for _, lr := range ranges {
+			for _, item := range lr.Spec.Limits {
+				if item.Type != corev1.LimitTypeContainer {
+					continue
+				}
+				for name, q := range item.Default {
+					if _, ok := limits[name]; !ok {
+						limits[name] = q
+					}
+				}
+				for name, q := range item.DefaultRequest {
+					if _, ok := requests[name]; !ok {
+						requests[name] = q
+					}
+				}
+				for name, max := range item.Max {
+					if q, ok := limits[name]; ok && q.Cmp(max) > 0 {
+						return nil, fmt.Errorf("container %s %s limit %s is over LimitRange %s maximum %s", c.Name, name, q.String(), lr.Name, max.String())
+					}
+				}
+			}
+		}
+		// like the API server: a missing request defaults to the limit
+		for name, q := range limits {
+			if _, ok := requests[name]; !ok {
+				requests[name] = q
+			}
+		}
+		for name, q := range requests {
+			add("requests."+name, q)
+			if name == corev1.ResourceCPU || name == corev1.ResourceMemory || name == corev1.ResourceEphemeralStorage {
+				add(name, q)
+			}
+		}
+		for name, q := range limits {
+			add("limits."+name, q)
+		}
+	}
+	return cost, nil
+}
+
+// replicasThatFit checks the quotas and LimitRanges of the deployment's namespace
+func replicasThatFit(ctx context.Context, cs kubernetes.Interface, dep *appsv1.Deployment) (quotaFit, error) {
+	fit := quotaFit{replicas: -1}
+	ranges, err := cs.CoreV1().LimitRanges(dep.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fit, fmt.Errorf("failed to list limit ranges in %s: %v", dep.Namespace, err)
+	}
+	quotas, err := cs.CoreV1().ResourceQuotas(dep.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fit, fmt.Errorf("failed to list resource quotas in %s: %v", dep.Namespace, err)
+	}
+	fit.quotas = quotas.Items
+
+	fit.perPod, err = podCost(dep.Spec.Template.Spec, ranges.Items)
+	if err != nil {
+		fit.replicas, fit.reason = 0, err.Error()
+		return fit, nil
+	}
+	for _, q := range quotas.Items {
+		for name, hard := range q.Status.Hard {
+			per, ok := fit.perPod[name]
+			if !ok || per.IsZero() {
+				continue
+			}
+			used := q.Status.Used[name]
+			n := int32((hard.MilliValue() - used.MilliValue()) / per.MilliValue())
+			if n < 0 {
+				n = 0
+			}
+			if fit.replicas < 0 || n < fit.replicas {
+				fit.replicas = n
+				fit.reason = fmt.Sprintf("quota %s: %s used %s of %s, a pod needs %s", q.Name, name, used.String(), hard.String(), per.String())
+			}
+		}
+	}
+	return fit, nil
+}
+
+// checkScaleQuota returns how many replicas the scale action may add (up to
+// step), or an error explaining why it doesn't scale
+func checkScaleQuota(ctx context.Context, cs kubernetes.Interface, dep *appsv1.Deployment, step int32, action *RecoveryAction, alert Alert) (int32, error) {
+	fit, err := replicasThatFit(ctx, cs, dep)
+	if err != nil {
+		// missing RBAC or a flaky API shouldn't block the scale; the API server still enforces quota
+		log.Printf("Quota check for %s/%s failed, scaling anyway: %v", dep.Namespace, dep.Name, err)
+		return step, nil
+	}
+	if fit.replicas < 0 || fit.replicas >= step {
+		action.Explain.add("quota", tracePass, "")
+		return step, nil
+	}
+
+	switch {
+	case scaleQuotaPolicy == quotaPolicyFit && fit.replicas > 0:
+		countQuotaResult("fitted")
+		action.Explain.add("quota", traceInfo, fmt.Sprintf("only %d of %d replicas fit (%s)", fit.replicas, step, fit.reason))
+		return fit.replicas, nil
+	case scaleQuotaPolicy == quotaPolicyBump:
+		id, err := requestQuotaBump(dep, fit, step, action, alert)
+		if err != nil {
+			return 0, err
+		}
+		countQuotaResult("bump-requested")
+		action.Explain.add("quota", traceSkip, fmt.Sprintf("%s; quota bump #%d awaits approval", fit.reason, id))
+		return 0, fmt.Errorf("%s/%s won't fit (%s) — quota bump #%d awaits approval", dep.Namespace, dep.Name, fit.reason, id)
+	}
+	countQuotaResult("skipped")
+	action.Explain.add("quota", traceSkip, fit.reason)
+	return 0, fmt.Errorf("%s/%s won't fit: %s", dep.Namespace, dep.Name, fit.reason)
+}
+
+// requestQuotaBump records the quota changes step more pods need and asks for approval
+func requestQuotaBump(dep *appsv1.Deployment, fit quotaFit, step int32, action *RecoveryAction, alert Alert) (int, error) {
+	var changes []quotaChange
+	for _, q := range fit.quotas {
+		var names []string
+		for name := range q.Status.Hard {
+			names = append(names, string(name))
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			per, ok := fit.perPod[corev1.ResourceName(name)]
+			if !ok || per.IsZero() {
+				continue
+			}
+			hard := q.Status.Hard[corev1.ResourceName(name)]
+			need := q.Status.Used[corev1.ResourceName(name)].DeepCopy()
+			for i := int32(0); i < step; i++ {
+				need.Add(per)
+			}
+			if need.Cmp(hard) > 0 {
+				changes = append(changes, quotaChange{Quota: q.Name, Resource: name, From: hard.String(), To: need.String()})
+			}
+		}
+	}
+	if len(changes) == 0 {
+		return 0, fmt.Errorf("%s/%s won't fit (%s) and no quota can be raised", dep.Namespace, dep.Name, fit.reason)
+	}
+
+	quotaMu.Lock()
+	for _, b := range quotaBumps {
+		if b.Namespace == dep.Namespace && b.App == action.App && b.Status == "pending" && time.Now().Before(b.ExpiresAt) {
+			quotaMu.Unlock()
+			return b.ID, nil
+		}
+	}
+	now := time.Now()
+	b := &QuotaBump{
+		ID:        nextQuotaBump,
+		Namespace: dep.Namespace,
+		App:       action.App,
+		Changes:   changes,
+		CreatedAt: now,
+		ExpiresAt: now.Add(recommendationTTL),
+		Status:    "pending",
+		action:    action,
+		alert:     alert,
+	}
+	nextQuotaBump++
+	quotaBumps[b.ID] = b
+	quotaMu.Unlock()
+
+	log.Printf("Quota bump #%d requested for %s/%s (%d change(s))", b.ID, b.Namespace, b.App, len(changes))
+	go func() {
+		ctx, cancel := context.WithTimeout(operatorCtx, 15*time.Second)
+		defer cancel()
+		text := renderMessage("quota-bump", alertLocale(alert.Labels, alert.Annotations), map[string]interface{}{
+			"ID": b.ID, "Namespace": b.Namespace, "App": b.App, "AlertName": b.action.AlertName,
+			"TTL": quotaBumpTTL, "Changes": changes, "Link": quotaBumpLink(b.ID), "ExpiresAt": b.ExpiresAt,
+		})
+		if err := postSlack(ctx, text); err != nil {
+			log.Printf("Failed to post quota bump to Slack: %v", err)
+		}
+	}()
+	return b.ID, nil
+}
+
+func quotaBumpToken(id int) string {
+	return signToken("quota-bump/" + strconv.Itoa(id))
+}
+
+func quotaBumpLink(id int) string {
+	base := os.Getenv("PUBLIC_URL")
+	if base == "" {
+		return ""
+	}
+	q := url.Values{"id": {strconv.Itoa(id)}, "token": {quotaBumpToken(id)}}
+	return strings.TrimRight(base, "/") + "/api/v1/quota-bumps/approve?" + q.Encode()
+}
+
+// applyQuotaBump raises the quotas, keeping the original values to restore later
+func applyQuotaBump(ctx context.Context, b *QuotaBump) error {
+	cs, err := clients.For(ctx, b.Namespace)
+	if err != nil {
+		return err
+	}
+	until := time.Now().Add(quotaBumpTTL).UTC().Format(time.RFC3339)
+	byQuota := map[string][]quotaChange{}
+	for _, c := range b.Changes {
+		byQuota[c.Quota] = append(byQuota[c.Quota], c)
+	}
+	for _, name := range sortedKeys(byQuota) {
+		quotas := cs.CoreV1().ResourceQuotas(b.Namespace)
+		q, err := quotas.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get quota %s/%s: %v", b.Namespace, name, err)
+		}
+		original := map[string]string{}
+		if s := q.Annotations[quotaOriginalAnno]; s != "" {
+			json.Unmarshal([]byte(s), &original)
+		}
+		for _, c := range byQuota[name] {
+			res := corev1.ResourceName(c.Resource)
+			if _, ok := original[c.Resource]; !ok {
+				cur := q.Spec.Hard[res]
+				original[c.Resource] = cur.String()
+			}
+			to, err := resource.ParseQuantity(c.To)
+			if err != nil {
+				return err
+			}
+			if cur := q.Spec.Hard[res]; to.Cmp(cur) > 0 {
+				q.Spec.Hard[res] = to
+			}
+		}
+		raw, _ := json.Marshal(original)
+		if q.Annotations == nil {
+			q.Annotations = map[string]string{}
+		}
+		q.Annotations[quotaOriginalAnno] = string(raw)
+		q.Annotations[quotaBumpUntilAnno] = until
+		if _, err := quotas.Update(ctx, q, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to raise quota %s/%s: %v", b.Namespace, name, err)
+		}
+		log.Printf("Quota %s/%s raised until %s", b.Namespace, name, until)
+	}
+	countQuotaResult("bumped")
+	return nil
+}
+
+// startQuotaReverts puts bumped quotas back once their bump expires
+func startQuotaReverts(kube kubernetes.Interface) {
+	if scaleQuotaPolicy != quotaPolicyBump {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-operatorCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			ctx, cancel := context.WithTimeout(operatorCtx, 30*time.Second)
+			if err := revertQuotaBumps(ctx, kube); err != nil {
+				log.Printf("Failed to revert quota bumps: %v", err)
+			}
+			cancel()
+		}
+	}()
+}
+
+func revertQuotaBumps(ctx context.Context, kube kubernetes.Interface) error {
+	list, err := kube.CoreV1().ResourceQuotas("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, q := range list.Items {
+		until, err := time.Parse(time.RFC3339, q.Annotations[quotaBumpUntilAnno])
+		if err != nil || time.Now().Before(until) {
+			continue
+		}
+		original := map[string]string{}
+		json.Unmarshal([]byte(q.Annotations[quotaOriginalAnno]), &original)
+		for name, v := range original {
+			if qty, err := resource.ParseQuantity(v); err == nil {
+				q.Spec.Hard[corev1.ResourceName(name)] = qty
+			}
+		}
+		delete(q.Annotations, quotaOriginalAnno)
+		delete(q.Annotations, quotaBumpUntilAnno)
+		if _, err := kube.CoreV1().ResourceQuotas(q.Namespace).Update(ctx, &q, metav1.UpdateOptions{}); err != nil {
+			log.Printf("Failed to restore quota %s/%s: %v", q.Namespace, q.Name, err)
+			continue
+		}
+		countQuotaResult("reverted")
+		log.Printf("Quota %s/%s restored after its bump expired", q.Namespace, q.Name)
+	}
+	return nil
+}
+
+func countQuotaResult(result string) {
+	quotaMu.Lock()
+	quotaResults[result]++
+	quotaMu.Unlock()
+}
+
+func listQuotaBumps() []QuotaBump {
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	now := time.Now()
+	out := make([]QuotaBump, 0, len(quotaBumps))
+	for id := 1; id < nextQuotaBump; id++ {
+		b, ok := quotaBumps[id]
+		if !ok {
+			continue
+		}
+		if b.Status == "pending" && now.After(b.ExpiresAt) {
+			b.Status = "expired"
+		}
+		out = append(out, *b)
+	}
+	return out
+}
+
+func handleQuotaBumps(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listQuotaBumps())
+}
+
+// handleApproveQuotaBump raises the quotas of a pending bump and runs the
+// scale it was requested for. Like recommendations, the HMAC token in the
+// link authorizes it.
+func handleApproveQuotaBump(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "id query parameter required", http.StatusBadRequest)
+		return
+	}
+	if !hmac.Equal([]byte(r.URL.Query().Get("token")), []byte(quotaBumpToken(id))) {
+		http.Error(w, "invalid approval token", http.StatusForbidden)
+		return
+	}
+	quotaMu.Lock()
+	namespace := ""
+	if b, ok := quotaBumps[id]; ok {
+		namespace = b.Namespace
+	}
+	quotaMu.Unlock()
+	user, ok := authorizeRequest(w, r, verbApprove, namespace)
+	if !ok {
+		return
+	}
+
+	quotaMu.Lock()
+	b, ok := quotaBumps[id]
+	switch {
+	case !ok:
+		quotaMu.Unlock()
+		http.Error(w, "quota bump not found", http.StatusNotFound)
+		return
+	case b.Status != "pending":
+		quotaMu.Unlock()
+		http.Error(w, "quota bump already "+b.Status, http.StatusConflict)
+		return
+	case time.Now().After(b.ExpiresAt):
+		b.Status = "expired"
+		quotaMu.Unlock()
+		http.Error(w, "quota bump expired", http.StatusGone)
+		return
+	}
+	b.Status = "approved"
+	quotaMu.Unlock()
+
+	log.Printf("Quota bump #%d approved%s — raising quotas in %s", b.ID, byUser(user), b.Namespace)
+	if err := applyQuotaBump(r.Context(), b); err != nil {
+		http.Error(w, fmt.Sprintf("approved, but raising the quota failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	// a bump requested by a workflow step runs just the scale, not the whole workflow again
+	scale := *b.action
+	scale.Action = "scale"
+	if err := performAction(r.Context(), &scale, b.alert); err != nil {
+		http.Error(w, fmt.Sprintf("quota raised, but the scale failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "Quota bump #%d approved: quotas in %s raised for %s, %s/%s scaled\n",
+		b.ID, b.Namespace, quotaBumpTTL, b.Namespace, b.App)
+}
+
+func writeQuotaMetrics(w io.Writer) {
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_scale_quota_total Scale actions limited by ResourceQuota, by result.")
+	fmt.Fprintln(w, "# TYPE selfhealing_scale_quota_total counter")
+	for _, result := range sortedKeys(quotaResults) {
+		fmt.Fprintf(w, "selfhealing_scale_quota_total{result=%q} %d\n", result, quotaResults[result])
+	}
+}
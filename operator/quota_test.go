@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testLimitRange(defaults, defaultRequests, max corev1.ResourceList) *corev1.LimitRange {
+	return &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "defaults"},
+		Spec: corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{{
+			Type:           corev1.LimitTypeContainer,
+			Default:        defaults,
+			DefaultRequest: defaultRequests,
+			Max:            max,
+		}}},
+	}
+}
+
+func testQuota(name string, hard, used corev1.ResourceList) *corev1.ResourceQuota {
+	return &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: name},
+		Status:     corev1.ResourceQuotaStatus{Hard: hard, Used: used},
+	}
+}
+
+func resources(kv ...string) corev1.ResourceList {
+	out := corev1.ResourceList{}
+	for i := 0; i+1 < len(kv); i += 2 {
+		out[corev1.ResourceName(kv[i])] = resource.MustParse(kv[i+1])
+	}
+	return out
+}
+
+func TestPodCost(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     corev1.ResourceList
+		lim     corev1.ResourceList
+		ranges  []corev1.LimitRange
+		want    map[string]string
+		wantErr string
+	}{
+		{"requests and limits", resources("cpu", "100m", "memory", "64Mi"), resources("memory", "128Mi"), nil,
+			map[string]string{"pods": "1", "requests.cpu": "100m", "cpu": "100m", "requests.memory": "64Mi", "limits.memory": "128Mi"}, ""},
+		{"request defaults to the limit", nil, resources("cpu", "500m"), nil,
+			map[string]string{"requests.cpu": "500m", "limits.cpu": "500m"}, ""},
+		{"limit range defaults fill the gaps", resources("cpu", "100m"), nil,
+			[]corev1.LimitRange{*testLimitRange(resources("cpu", "1", "memory", "256Mi"), resources("memory", "128Mi"), nil)},
+			map[string]string{"requests.cpu": "100m", "limits.cpu": "1", "requests.memory": "128Mi", "limits.memory": "256Mi"}, ""},
+		{"over the limit range maximum", nil, resources("memory", "2Gi"),
+			[]corev1.LimitRange{*testLimitRange(nil, nil, resources("memory", "1Gi"))},
+			nil, "over LimitRange defaults maximum"},
+	}
+	for _, tt := range tests {
+		spec := corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Resources: corev1.ResourceRequirements{Requests: tt.req, Limits: tt.lim}}}}
+		cost, err := podCost(spec, tt.ranges)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		for name, want := range tt.want {
+			got := cost[corev1.ResourceName(name)]
+			if got.Cmp(resource.MustParse(want)) != 0 {
+				t.Errorf("%s: %s = %s, want %s", tt.name, name, got.String(), want)
+			}
+		}
+	}
+}
+
+func TestCheckScaleQuota(t *testing.T) {
+	savedPolicy := scaleQuotaPolicy
+	defer func() { scaleQuotaPolicy = savedPolicy }()
+
+	tests := []struct {
+		name    string
+		policy  string
+		quotas  []*corev1.ResourceQuota
+		want    int32
+		wantErr string
+	}{
+		{"no quota", quotaPolicySkip, nil, 3, ""},
+		{"room for all", quotaPolicySkip,
+			[]*corev1.ResourceQuota{testQuota("compute", resources("requests.cpu", "2"), resources("requests.cpu", "1"))}, 3, ""},
+		{"full quota skips", quotaPolicySkip,
+			[]*corev1.ResourceQuota{testQuota("compute", resources("requests.cpu", "1"), resources("requests.cpu", "900m"))}, 0, "quota compute: requests.cpu"},
+		{"partial fit skips by default", quotaPolicySkip,
+			[]*corev1.ResourceQuota{testQuota("compute", resources("requests.cpu", "1"), resources("requests.cpu", "600m"))}, 0, "won't fit"},
+		{"partial fit scales what fits", quotaPolicyFit,
+			[]*corev1.ResourceQuota{testQuota("compute", resources("requests.cpu", "1"), resources("requests.cpu", "600m"))}, 1, ""},
+		{"tightest quota wins", quotaPolicyFit,
+			[]*corev1.ResourceQuota{
+				testQuota("compute", resources("requests.cpu", "10"), resources("requests.cpu", "0")),
+				testQuota("count", resources("pods", "5"), resources("pods", "3")),
+			}, 2, ""},
+		{"nothing fits with fit policy", quotaPolicyFit,
+			[]*corev1.ResourceQuota{testQuota("count", resources("pods", "3"), resources("pods", "3"))}, 0, "quota count: pods"},
+	}
+	for _, tt := range tests {
+		scaleQuotaPolicy = tt.policy
+		kube := fake.NewSimpleClientset()
+		for _, q := range tt.quotas {
+			kube.CoreV1().ResourceQuotas("shop").Create(context.Background(), q, metav1.CreateOptions{})
+		}
+		dep := testDeployment("shop", "cart", 2)
+		dep.Spec.Template.Spec.Containers = []corev1.Container{{Name: "app", Resources: corev1.ResourceRequirements{Requests: resources("cpu", "300m")}}}
+
+		got, err := checkScaleQuota(context.Background(), kube, dep, 3, &RecoveryAction{Namespace: "shop", App: "cart"}, Alert{})
+		if got != tt.want {
+			t.Errorf("%s: replicas = %d, want %d", tt.name, got, tt.want)
+		}
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
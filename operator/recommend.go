@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Recommend-only mode (MODE=recommend): the operator still parses alerts and
+// applies every check (suppressions, cooldowns, ...) and decides on an action,
+// but instead of executing it publishes a recommendation — a Kubernetes Event
+// on the target, a Slack message with a one-click approve link, and the
+// /api/v1/recommendations list. Unlike a dry run, an approved recommendation
+// is then executed for real.
+
+const (
+	modeActive    = "active"
+	modeRecommend = "recommend"
+)
+
+var operatingMode = envString("MODE", modeActive)
+
+// Recommendation is an action the operator would have taken
+type Recommendation struct {
+	ID        int       `json:"id"`
+	AlertName string    `json:"alertname"`
+	Action    string    `json:"action"`
+	Namespace string    `json:"namespace"`
+	App       string    `json:"app"`
+	Pod       string    `json:"pod,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Status    string    `json:"status"` // pending, approved, denied, expired
+	// what approving it would change, as of when it was published (see preview.go)
+	Preview *ActionPreview `json:"preview,omitempty"`
+
+	action *RecoveryAction
+	alert  Alert
+}
+
+var (
+	recommendationMu     sync.Mutex
+	recommendations      = map[int]*Recommendation{}
+	nextRecommendationID = 1
+	recommendationTTL    = envDuration("RECOMMENDATION_TTL", time.Hour)
+
+	// approval links carry an HMAC of the recommendation ID so the link itself is the credential
+	approvalSecret = loadApprovalSecret()
+)
+
+func loadApprovalSecret() []byte {
+	if s := os.Getenv("APPROVAL_SECRET"); s != "" {
+		return []byte(s)
+	}
+	// Random per process: links stop working after a restart, like the recommendations themselves
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Failed to generate approval secret: %v", err)
+	}
+	return b
+}
+
+func approvalToken(id int) string {
+	return signToken(strconv.Itoa(id))
+}
+
+// signToken returns the HMAC of s under the approval secret
+func signToken(s string) string {
+	mac := hmac.New(sha256.New, approvalSecret)
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func approvalLink(id int) string {
+	base := os.Getenv("PUBLIC_URL")
+	if base == "" {
+		return ""
+	}
+	q := url.Values{"id": {strconv.Itoa(id)}, "token": {approvalToken(id)}}
+	return strings.TrimRight(base, "/") + "/api/v1/recommendations/approve?" + q.Encode()
+}
+
+// recommendAction publishes the decided action instead of executing it
+func recommendAction(action *RecoveryAction, alert Alert) {
+	recommendationMu.Lock()
+	now := time.Now()
+	rec := &Recommendation{
+		ID:        nextRecommendationID,
+		AlertName: action.AlertName,
+		Action:    action.Action,
+		Namespace: action.Namespace,
+		App:       action.App,
+		Pod:       action.Pod,
+		CreatedAt: now,
+		ExpiresAt: now.Add(recommendationTTL),
+		Status:    "pending",
+		action:    action,
+		alert:     alert,
+	}
+	nextRecommendationID++
+	recommendations[rec.ID] = rec
+	recommendationMu.Unlock()
+
+	why := "recommend-only mode"
+	if requiresApproval(rec.Action) {
+		why = "needs approval"
+	} else if operatingMode != modeRecommend {
+		why = "workload in observation"
+	}
+	log.Printf("Recommendation #%d: '%s' for alert '%s' on %s/%s (%s)",
+		rec.ID, rec.Action, rec.AlertName, rec.Namespace, rec.App, why)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		preview := previewAction(ctx, action, alert)
+		recommendationMu.Lock()
+		rec.Preview = preview
+		recommendationMu.Unlock()
+		if err := recordRecommendationEvent(ctx, rec); err != nil {
+			log.Printf("Failed to record recommendation event: %v", err)
+		}
+		text := renderMessage("recommendation", alertLocale(alert.Labels, alert.Annotations), map[string]interface{}{
+			"ID": rec.ID, "Action": rec.Action, "Namespace": rec.Namespace, "App": rec.App, "Pod": rec.Pod,
+			"AlertName": rec.AlertName, "Link": approvalLink(rec.ID), "ExpiresAt": rec.ExpiresAt, "Preview": preview,
+			"Tags": remediationTags(alert.Labels),
+		})
+		if err := postRecommendation(ctx, rec.ID, text); err != nil {
+			log.Printf("Failed to post recommendation to Slack: %v", err)
+		}
+	}()
+}
+
+// recordRecommendationEvent attaches a Normal event to the target deployment
+// (or the pod, the object stuck on finalizers, or the webhook configuration),
+// so `kubectl describe` shows what the healer would do
+func recordRecommendationEvent(ctx context.Context, rec *Recommendation) error {
+	ref := corev1.ObjectReference{Kind: "Pod", Namespace: rec.Namespace, Name: rec.Pod, APIVersion: "v1"}
+	if rec.Action == "clear_finalizers" {
+		t, err := finalizerTargetFor(clients.Kube, rec.action.Labels)
+		if err != nil {
+			return err
+		}
+		ref = t.objectReference()
+	} else if isWebhookAction(rec.Action) {
+		cfg, _, err := webhookTargetFor(ctx, clients.Kube, rec.action.Labels)
+		if err != nil {
+			return err
+		}
+		ref = corev1.ObjectReference{Kind: "ValidatingWebhookConfiguration", Name: cfg.Name, UID: cfg.UID, APIVersion: "admissionregistration.k8s.io/v1"}
+	} else if dep, err := findDeployment(ctx, clients.Kube, rec.Namespace, rec.App); err == nil {
+		ref = corev1.ObjectReference{Kind: "Deployment", Namespace: dep.Namespace, Name: dep.Name, UID: dep.UID, APIVersion: "apps/v1"}
+	} else if rec.Pod == "" {
+		return err
+	}
+
+	now := metav1.Now()
+	meta := metav1.ObjectMeta{GenerateName: "selfhealing-recommendation-"}
+	// Events expire on their own; the labels just make them easy to find
+	markOwned(&meta, recommendationTTL)
+	// events about cluster-scoped objects (and a terminating namespace can't take new ones) go to default
+	eventNamespace := ref.Namespace
+	if eventNamespace == "" {
+		eventNamespace = metav1.NamespaceDefault
+	}
+	message := fmt.Sprintf("Self-healing recommends '%s' for alert %s (recommendation #%d)", rec.Action, rec.AlertName, rec.ID)
+	if rec.Preview != nil {
+		message += ": " + rec.Preview.Summary
+	}
+	_, err := clients.Kube.CoreV1().Events(eventNamespace).Create(ctx, &corev1.Event{
+		ObjectMeta:     meta,
+		InvolvedObject: ref,
+		Reason:         "RemediationRecommended",
+		Message:        message,
+		Type:           corev1.EventTypeNormal,
+		Source:         corev1.EventSource{Component: "self-healing-operator"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}, metav1.CreateOptions{})
+	return err
+}
+
+func listRecommendations() []Recommendation {
+	recommendationMu.Lock()
+	defer recommendationMu.Unlock()
+	now := time.Now()
+	out := make([]Recommendation, 0, len(recommendations))
+	for id := 1; id < nextRecommendationID; id++ {
+		rec, ok := recommendations[id]
+		if !ok {
+			continue
+		}
+		if rec.Status == "pending" && now.After(rec.ExpiresAt) {
+			rec.Status = "expired"
+		}
+		out = append(out, *rec)
+	}
+	return out
+}
+
+func handleRecommendations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listRecommendations())
+}
+
+var (
+	errRecommendationNotFound = errors.New("recommendation not found")
+	errRecommendationExpired  = errors.New("recommendation expired")
+)
+
+// decideRecommendation moves a pending recommendation to approved or denied
+func decideRecommendation(id int, status string) (*Recommendation, error) {
+	recommendationMu.Lock()
+	defer recommendationMu.Unlock()
+	rec, ok := recommendations[id]
+	switch {
+	case !ok:
+		return nil, errRecommendationNotFound
+	case rec.Status != "pending":
+		return nil, fmt.Errorf("recommendation already %s", rec.Status)
+	case time.Now().After(rec.ExpiresAt):
+		rec.Status = "expired"
+		return nil, errRecommendationExpired
+	}
+	rec.Status = status
+	return rec, nil
+}
+
+// handleApproveRecommendation executes a pending recommendation. GET is
+// allowed so the Slack link works with one click; the HMAC token authorizes it.
+func handleApproveRecommendation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "id query parameter required", http.StatusBadRequest)
+		return
+	}
+	if !hmac.Equal([]byte(r.URL.Query().Get("token")), []byte(approvalToken(id))) {
+		http.Error(w, "invalid approval token", http.StatusForbidden)
+		return
+	}
+	recommendationMu.Lock()
+	namespace := ""
+	if rec, ok := recommendations[id]; ok {
+		namespace = rec.Namespace
+	}
+	recommendationMu.Unlock()
+	user, ok := authorizeRequest(w, r, verbApprove, namespace)
+	if !ok {
+		return
+	}
+
+	rec, err := decideRecommendation(id, "approved")
+	switch {
+	case errors.Is(err, errRecommendationNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, errRecommendationExpired):
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	log.Printf("Recommendation #%d approved%s — executing '%s' for %s/%s", rec.ID, byUser(user), rec.Action, rec.Namespace, rec.App)
+	if err := performAction(r.Context(), rec.action, rec.alert); err != nil {
+		http.Error(w, fmt.Sprintf("approved, but '%s' failed: %v", rec.Action, err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "Recommendation #%d approved: '%s' executed for %s/%s\n", rec.ID, rec.Action, rec.Namespace, rec.App)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"self-healing-operator/pkg/policy"
+)
+
+// Shadow replay: POST /api/v1/replay runs a proposed action policy against
+// past alerts and reports what it would have done next to what the current
+// policy does, so a policy change can be checked against real incidents
+// before it goes live. Nothing is executed.
+//
+//	curl -X POST localhost:8080/api/v1/replay -d '{
+//	  "severityActions": "warning=notify, critical=restart",
+//	  "recoveryActions": {"PodCrashLooping": "warning=restart, critical=redeploy+escalate"},
+//	  "since": "72h"}'
+//
+// A proposal replaces SEVERITY_ACTIONS and, per alertname, the
+// recovery_actions annotation of the alert rule; anything it leaves out stays
+// as it is. The alerts replayed are the firing alerts the operator received
+// (the first notification of each, kept in memory, the last ALERT_ARCHIVE_SIZE
+// of them), or the alerts given in the request, e.g. from an Alertmanager or
+// log export. Both policies are replayed in startsAt order with the current
+// cooldown per workload, so a policy that acts more often shows the actions
+// the cooldown would have held back. Each result also has the decision the
+// operator actually made when the alert arrived.
+
+var (
+	alertArchiveSize = envInt("ALERT_ARCHIVE_SIZE", 2000)
+
+	alertArchiveMu sync.Mutex
+	alertArchive   []archivedAlert
+	archivedSeen   = map[string]bool{} // fingerprint|startsAt
+)
+
+type archivedAlert struct {
+	Alert      Alert
+	ReceivedAt time.Time
+	Decision   string // as counted in selfhealing_alert_decisions_total
+}
+
+type replayRequest struct {
+	SeverityActions *string           `json:"severityActions"` // nil keeps SEVERITY_ACTIONS
+	RecoveryActions map[string]string `json:"recoveryActions"` // alertname -> severity mapping
+	Since           string            `json:"since"`           // e.g. 24h; default everything archived
+	AlertName       string            `json:"alertname"`
+	Namespace       string            `json:"namespace"`
+	Alerts          []Alert           `json:"alerts"` // replay these instead of the archive
+}
+
+type replayResult struct {
+	Alerts  int                     `json:"alerts"`
+	Changed int                     `json:"changed"` // alerts whose action differs
+	From    *time.Time              `json:"from,omitempty"`
+	To      *time.Time              `json:"to,omitempty"`
+	Actions map[string]*replayCount `json:"actions"` // action (or skip) -> counts
+	Results []replayAlertResult     `json:"results"`
+}
+
+type replayCount struct {
+	Current  int `json:"current"`
+	Proposed int `json:"proposed"`
+}
+
+type replayAlertResult struct {
+	StartsAt  time.Time     `json:"startsAt"`
+	AlertName string        `json:"alertname"`
+	Namespace string        `json:"namespace"`
+	App       string        `json:"app,omitempty"`
+	Severity  string        `json:"severity,omitempty"`
+	Recorded  string        `json:"recorded,omitempty"` // what the operator decided at the time
+	Current   string        `json:"current"`            // the current policy, "skip" if none
+	Proposed  string        `json:"proposed"`
+	Changed   bool          `json:"changed"`
+	Trace     decisionTrace `json:"trace"` // how the proposed policy chose
+}
+
+// archiveAlert keeps the first notification of a firing alert for replays
+func archiveAlert(alert Alert, decision string) {
+	if alertArchiveSize <= 0 || alert.Status != "firing" {
+		return
+	}
+	key := alertFingerprint(alert) + "|" + alert.StartsAt.String()
+	alertArchiveMu.Lock()
+	defer alertArchiveMu.Unlock()
+	if archivedSeen[key] {
+		return
+	}
+	archivedSeen[key] = true
+	alertArchive = append(alertArchive, archivedAlert{Alert: alert, ReceivedAt: time.Now(), Decision: decision})
+	if n := len(alertArchive) - alertArchiveSize; n > 0 {
+		for _, old := range alertArchive[:n] {
+			delete(archivedSeen, alertFingerprint(old.Alert)+"|"+old.Alert.StartsAt.String())
+		}
+		alertArchive = append([]archivedAlert(nil), alertArchive[n:]...)
+	}
+}
+
+// replayPolicy evaluates the current and proposed policy on the alerts
+func replayPolicy(req replayRequest, alerts []archivedAlert, cooldown time.Duration) replayResult {
+	defaults := defaultSeverityActions
+	if req.SeverityActions != nil {
+		defaults = *req.SeverityActions
+	}
+	sort.SliceStable(alerts, func(i, j int) bool { return alerts[i].Alert.StartsAt.Before(alerts[j].Alert.StartsAt) })
+
+	res := replayResult{Actions: map[string]*replayCount{}, Results: []replayAlertResult{}}
+	lastCurrent := map[string]time.Time{}
+	lastProposed := map[string]time.Time{}
+	// held applies the cooldown: the action, or "cooldown:<action>" if it would have waited
+	held := func(last map[string]time.Time, key, action string, at time.Time) string {
+		if action == "skip" {
+			return action
+		}
+		if prev, ok := last[key]; ok && at.Sub(prev) < cooldown {
+			return "cooldown:" + action
+		}
+		last[key] = at
+		return action
+	}
+	count := func(action string) *replayCount {
+		if res.Actions[action] == nil {
+			res.Actions[action] = &replayCount{}
+		}
+		return res.Actions[action]
+	}
+
+	for _, a := range alerts {
+		alert := a.Alert
+		if req.AlertName != "" && alert.Labels["alertname"] != req.AlertName ||
+			req.Namespace != "" && alert.Labels["namespace"] != req.Namespace {
+			continue
+		}
+		current, currentEscalate := selectActionWith(alert, defaultSeverityActions, nil)
+
+		proposedAlert := alert
+		if spec, ok := req.RecoveryActions[alert.Labels["alertname"]]; ok {
+			proposedAlert.Annotations = map[string]string{}
+			for k, v := range alert.Annotations {
+				proposedAlert.Annotations[k] = v
+			}
+			proposedAlert.Annotations["recovery_actions"] = spec
+		}
+		var trace decisionTrace
+		proposed, proposedEscalate := selectActionWith(proposedAlert, defaults, &trace)
+
+		key := alert.Labels["namespace"] + "/" + alert.Labels["app"]
+		r := replayAlertResult{
+			StartsAt:  alert.StartsAt,
+			AlertName: alert.Labels["alertname"],
+			Namespace: alert.Labels["namespace"],
+			App:       alert.Labels["app"],
+			Severity:  alert.Labels["severity"],
+			Recorded:  a.Decision,
+			Current:   held(lastCurrent, key, replayAction(current, currentEscalate), alert.StartsAt),
+			Proposed:  held(lastProposed, key, replayAction(proposed, proposedEscalate), alert.StartsAt),
+			Trace:     trace,
+		}
+		r.Changed = r.Current != r.Proposed
+		if r.Changed {
+			res.Changed++
+		}
+		count(r.Current).Current++
+		count(r.Proposed).Proposed++
+		if res.From == nil {
+			res.From = &r.StartsAt
+		}
+		res.To = &r.StartsAt
+		res.Results = append(res.Results, r)
+	}
+	res.Alerts = len(res.Results)
+	return res
+}
+
+func replayAction(action string, escalate bool) string {
+	if action == "" {
+		return "skip"
+	}
+	if escalate {
+		return action + "+escalate"
+	}
+	return action
+}
+
+func handleReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := authorizeRequest(w, r, verbReplay, ""); !ok {
+		return
+	}
+	var req replayRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 8<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var since time.Time
+	if req.Since != "" {
+		d, err := time.ParseDuration(req.Since)
+		if err != nil || d <= 0 {
+			http.Error(w, "since: want a duration like 24h", http.StatusBadRequest)
+			return
+		}
+		since = time.Now().Add(-d)
+	}
+
+	var alerts []archivedAlert
+	if len(req.Alerts) > 0 {
+		for _, a := range req.Alerts {
+			if a.Status == "" {
+				a.Status = "firing"
+			}
+			if a.Status == "firing" && !a.StartsAt.Before(since) {
+				alerts = append(alerts, archivedAlert{Alert: a})
+			}
+		}
+	} else {
+		alertArchiveMu.Lock()
+		for _, a := range alertArchive {
+			if !a.Alert.StartsAt.Before(since) {
+				alerts = append(alerts, a)
+			}
+		}
+		alertArchiveMu.Unlock()
+	}
+	for name, spec := range req.RecoveryActions {
+		if strings.TrimSpace(spec) != "" && len(policy.ParseSeverityActions(spec)) == 0 {
+			http.Error(w, "recoveryActions."+name+": want \"severity=action, ...\"", http.StatusBadRequest)
+			return
+		}
+	}
+
+	res := replayPolicy(req, alerts, currentCooldown())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
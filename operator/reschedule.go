@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// Rescheduling elsewhere. A pod stuck in CrashLoopBackOff because of its node
+// (a bad disk, a broken GPU driver, a full conntrack table) just crashes again
+// when restart recreates it on the same node. `recovery_action:
+// reschedule_elsewhere`
+//   - checks that another node has room for the pod
+//   - taints its node selfhealing.io/reschedule-avoid:NoSchedule, so nothing
+//     new lands there for a while (running pods stay)
+//   - deletes the pod, waits for its replacement to be scheduled, and removes
+//     the taint again
+//
+// The taint is removed after RESCHEDULE_AVOID_TTL at the latest, also if the
+// operator restarts in between: the node carries the expiry in an annotation.
+// A pod that tolerates every NoSchedule taint would ignore the taint, so the
+// action refuses it.
+
+const (
+	rescheduleAvoidTaint = "selfhealing.io/reschedule-avoid"
+	rescheduleAvoidAnno  = "selfhealing.io/reschedule-avoid-until"
+)
+
+var rescheduleAvoidTTL = envDuration("RESCHEDULE_AVOID_TTL", 5*time.Minute)
+
+func rescheduleElsewhere(ctx context.Context, c *Clients, action *RecoveryAction) error {
+	if action.Pod == "" {
+		return fmt.Errorf("no pod name in alert labels for reschedule_elsewhere")
+	}
+	cs, err := c.For(ctx, action.Namespace)
+	if err != nil {
+		return err
+	}
+	pod, err := cs.CoreV1().Pods(action.Namespace).Get(ctx, action.Pod, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get pod %s/%s: %v", action.Namespace, action.Pod, err)
+	}
+	if pod.DeletionTimestamp != nil {
+		log.Printf("Pod %s/%s is already terminating — nothing to do", pod.Namespace, pod.Name)
+		return nil
+	}
+	bad := pod.Spec.NodeName
+	if bad == "" {
+		return fmt.Errorf("pod %s/%s isn't scheduled on a node", pod.Namespace, pod.Name)
+	}
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return fmt.Errorf("pod %s/%s has no controller to recreate it", pod.Namespace, pod.Name)
+	}
+	taint := corev1.Taint{Key: rescheduleAvoidTaint, Effect: corev1.TaintEffectNoSchedule}
+	for _, tol := range pod.Spec.Tolerations {
+		if tol.ToleratesTaint(&taint) {
+			return fmt.Errorf("pod %s/%s tolerates %s, so it could land on %s again", pod.Namespace, pod.Name, rescheduleAvoidTaint, bad)
+		}
+	}
+	// nodes and other namespaces' pods need the operator's own cluster-wide client
+	target, err := fittingNode(ctx, c.Kube, pod, bad)
+	if err != nil {
+		return err
+	}
+	if target == "" {
+		action.Explain.add("reschedule-feasibility", traceSkip, "no other node has room for the pod")
+		return fmt.Errorf("pod %s/%s has nowhere else to go: no node other than %s has room for it", pod.Namespace, pod.Name, bad)
+	}
+	action.Explain.add("reschedule-feasibility", tracePass, "fits on "+target)
+
+	until := time.Now().Add(rescheduleAvoidTTL)
+	if err := avoidNode(ctx, c.Kube, bad, until); err != nil {
+		return err
+	}
+	log.Printf("Tainted node %s %s:NoSchedule until %s", bad, rescheduleAvoidTaint, until.Format(time.RFC3339))
+
+	deleted := time.Now().Truncate(time.Second)
+	if err := cs.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{}); err != nil {
+		cleanup, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		unavoidNode(cleanup, c.Kube, bad, until)
+		return fmt.Errorf("failed to delete pod %s/%s: %v", pod.Namespace, pod.Name, err)
+	}
+	log.Printf("Pod %s/%s deleted — its replacement will avoid node %s", pod.Namespace, pod.Name, bad)
+	go waitForReplacement(cs, c.Kube, pod, owner.UID, deleted, until)
+	return nil
+}
+
+// avoidNode adds the NoSchedule taint and extends its expiry to until
+func avoidNode(ctx context.Context, kube kubernetes.Interface, name string, until time.Time) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := kube.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if current, err := time.Parse(time.RFC3339, node.Annotations[rescheduleAvoidAnno]); err == nil && current.After(until) {
+			until = current
+		}
+		if node.Annotations == nil {
+			node.Annotations = map[string]string{}
+		}
+		node.Annotations[rescheduleAvoidAnno] = until.UTC().Format(time.RFC3339)
+		tainted := false
+		for _, t := range node.Spec.Taints {
+			tainted = tainted || t.Key == rescheduleAvoidTaint
+		}
+		if !tainted {
+			now := metav1.Now()
+			node.Spec.Taints = append(node.Spec.Taints, corev1.Taint{
+				Key:       rescheduleAvoidTaint,
+				Effect:    corev1.TaintEffectNoSchedule,
+				TimeAdded: &now,
+			})
+		}
+		_, err = kube.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to taint node %s: %v", name, err)
+	}
+	return nil
+}
+
+// unavoidNode removes the taint, unless a later action has extended it past until
+func unavoidNode(ctx context.Context, kube kubernetes.Interface, name string, until time.Time) {
+	removed := false
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := kube.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		current, err := time.Parse(time.RFC3339, node.Annotations[rescheduleAvoidAnno])
+		if err != nil || current.After(until) {
+			return nil
+		}
+		delete(node.Annotations, rescheduleAvoidAnno)
+		taints := node.Spec.Taints[:0]
+		for _, t := range node.Spec.Taints {
+			if t.Key != rescheduleAvoidTaint {
+				taints = append(taints, t)
+			}
+		}
+		node.Spec.Taints = taints
+		_, err = kube.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+		removed = err == nil
+		return err
+	})
+	switch {
+	case err != nil:
+		log.Printf("Failed to remove %s from node %s: %v", rescheduleAvoidTaint, name, err)
+	case removed:
+		log.Printf("Removed %s from node %s", rescheduleAvoidTaint, name)
+	}
+}
+
+// waitForReplacement lifts the taint once the controller's replacement pod is scheduled
+func waitForReplacement(cs, kube kubernetes.Interface, old *corev1.Pod, owner types.UID, deleted, until time.Time) {
+	ctx, cancel := context.WithDeadline(operatorCtx, until)
+	defer cancel()
+	selector := labels.SelectorFromSet(old.Labels).String()
+	var placed string
+	wait.PollUntilContextCancel(ctx, 5*time.Second, false, func(ctx context.Context) (bool, error) {
+		list, err := cs.CoreV1().Pods(old.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return false, nil
+		}
+		for i := range list.Items {
+			p := &list.Items[i]
+			if ref := metav1.GetControllerOf(p); ref != nil && ref.UID == owner && p.UID != old.UID &&
+				!p.CreationTimestamp.Time.Before(deleted) && p.Spec.NodeName != "" {
+				placed = p.Name + " on " + p.Spec.NodeName
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if placed != "" {
+		log.Printf("Replacement for %s/%s scheduled: %s", old.Namespace, old.Name, placed)
+	} else {
+		log.Printf("No replacement for %s/%s was scheduled within %s", old.Namespace, old.Name, rescheduleAvoidTTL)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	unavoidNode(ctx, kube, old.Spec.NodeName, until)
+}
+
+// startRescheduleReverts removes reschedule-avoid taints whose expiry has
+// passed, e.g. because the operator restarted while waiting
+func startRescheduleReverts(kube kubernetes.Interface) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-operatorCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			ctx, cancel := context.WithTimeout(operatorCtx, 30*time.Second)
+			if err := revertRescheduleTaints(ctx, kube); err != nil {
+				log.Printf("Failed to remove expired reschedule taints: %v", err)
+			}
+			cancel()
+		}
+	}()
+}
+
+func revertRescheduleTaints(ctx context.Context, kube kubernetes.Interface) error {
+	nodes, err := kube.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, n := range nodes.Items {
+		if until, err := time.Parse(time.RFC3339, n.Annotations[rescheduleAvoidAnno]); err == nil && time.Now().After(until) {
+			unavoidNode(ctx, kube, n.Name, until)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// Actions on custom resources. patch_resource and delete_resource act on any
+// namespaced resource through the dynamic client, so policies can heal
+// objects other operators manage — restart a Strimzi Kafka cluster by
+// patching its annotation, delete a stuck Certificate so cert-manager
+// recreates it. What to act on comes from the alert rule's annotations:
+//
+//	labels:
+//	  recovery_action: patch_resource
+//	annotations:
+//	  resource_api_version: kafka.strimzi.io/v1beta2
+//	  resource: kafkas
+//	  resource_name: "${kafka}"
+//	  resource_patch: '{"metadata":{"annotations":{"strimzi.io/manual-rolling-update":"true"}}}'
+//	  resource_patch_type: merge      # merge (default) or json
+//
+// or, as workflow steps, from the step's params (apiVersion, resource, name,
+// patch, patchType). ${label} in the name and patch is replaced by the
+// alert's label. The object is always in the alert's namespace.
+//
+// Only resources listed in RESOURCE_ACTIONS_ALLOWED (resource.group,
+// comma-separated, e.g. kafkas.kafka.strimzi.io,certificates.cert-manager.io)
+// can be acted on, and the operator needs RBAC for them (see the commented
+// rule in manifests/operator/rbac.yaml). Changes to the object's spec,
+// labels and annotations are kept in the history record (see snapshot.go).
+
+var resourceActionsAllowed = envList("RESOURCE_ACTIONS_ALLOWED")
+
+// resourceParamAnnotations maps alert annotations to the params of the resource actions
+var resourceParamAnnotations = map[string]string{
+	"resource_api_version": "apiVersion",
+	"resource":             "resource",
+	"resource_name":        "name",
+	"resource_patch":       "patch",
+	"resource_patch_type":  "patchType",
+}
+
+// resourceTarget is the object a resource action acts on
+type resourceTarget struct {
+	gvr       schema.GroupVersionResource
+	namespace string
+	name      string
+}
+
+func (t resourceTarget) String() string {
+	return t.gvr.GroupResource().String() + " " + t.namespace + "/" + t.name
+}
+
+// resourceParams reads the resource action's params from the alert's annotations
+func resourceParams(alert Alert) map[string]string {
+	params := map[string]string{}
+	for annotation, param := range resourceParamAnnotations {
+		if v := alert.Annotations[annotation]; v != "" {
+			params[param] = v
+		}
+	}
+	return params
+}
+
+// resolveResourceTarget checks the params against the allowlist and fills in the alert's labels
+func resolveResourceTarget(action *RecoveryAction, params map[string]string) (resourceTarget, error) {
+	gv, err := schema.ParseGroupVersion(params["apiVersion"])
+	if err != nil || gv.Version == "" || params["resource"] == "" {
+		return resourceTarget{}, fmt.Errorf("apiVersion (group/version) and resource are required")
+	}
+	gvr := gv.WithResource(params["resource"])
+	if !containsString(resourceActionsAllowed, gvr.GroupResource().String()) {
+		return resourceTarget{}, fmt.Errorf("%s isn't in RESOURCE_ACTIONS_ALLOWED", gvr.GroupResource())
+	}
+	name, err := expandLabelRefs(params["name"], action.Labels)
+	if err != nil {
+		return resourceTarget{}, fmt.Errorf("name: %v", err)
+	}
+	if name == "" {
+		return resourceTarget{}, fmt.Errorf("name is required")
+	}
+	return resourceTarget{gvr: gvr, namespace: action.Namespace, name: name}, nil
+}
+
+// expandLabelRefs replaces ${label} with the label's value
+func expandLabelRefs(s string, labels map[string]string) (string, error) {
+	var missing []string
+	out := thresholdRef.ReplaceAllStringFunc(s, func(ref string) string {
+		label := thresholdRef.FindStringSubmatch(ref)[1]
+		v, ok := labels[label]
+		if !ok || v == "" {
+			missing = append(missing, label)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("the alert has no label %s", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
+
+// DynamicFor is the dynamic counterpart of For
+func (c *Clients) DynamicFor(ctx context.Context, namespace string) (dynamic.Interface, error) {
+	if c.Scoped == nil {
+		if c.Dynamic == nil {
+			return nil, fmt.Errorf("no dynamic client")
+		}
+		return c.Dynamic, nil
+	}
+	sc, err := c.Scoped.forNamespace(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return sc.Dynamic, nil
+}
+
+// patchResource patches the custom resource named by the params
+func patchResource(ctx context.Context, c *Clients, action *RecoveryAction, params map[string]string) error {
+	target, err := resolveResourceTarget(action, params)
+	if err != nil {
+		return fmt.Errorf("patch_resource: %v", err)
+	}
+	patch, err := expandLabelRefs(params["patch"], action.Labels)
+	if err != nil {
+		return fmt.Errorf("patch_resource: patch: %v", err)
+	}
+	if !json.Valid([]byte(patch)) || strings.TrimSpace(patch) == "" {
+		return fmt.Errorf("patch_resource: patch must be JSON")
+	}
+	patchType := types.MergePatchType
+	switch params["patchType"] {
+	case "", "merge":
+	case "json":
+		patchType = types.JSONPatchType
+	default:
+		return fmt.Errorf("patch_resource: patchType %q isn't merge or json", params["patchType"])
+	}
+
+	dyn, err := c.DynamicFor(ctx, target.namespace)
+	if err != nil {
+		return err
+	}
+	objects := dyn.Resource(target.gvr).Namespace(target.namespace)
+	before, err := objects.Get(ctx, target.name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get %s: %v", target, err)
+	}
+	after, err := objects.Patch(ctx, target.name, patchType, []byte(patch), metav1.PatchOptions{FieldManager: "self-healing-operator"})
+	if err != nil {
+		return fmt.Errorf("failed to patch %s: %v", target, err)
+	}
+	recordResourceChange(action, before.GetKind(), target, before.Object, after.Object)
+	log.Printf("Patched %s for alert '%s'", target, action.AlertName)
+	return nil
+}
+
+// deleteResource deletes the custom resource named by the params
+func deleteResource(ctx context.Context, c *Clients, action *RecoveryAction, params map[string]string) error {
+	target, err := resolveResourceTarget(action, params)
+	if err != nil {
+		return fmt.Errorf("delete_resource: %v", err)
+	}
+	dyn, err := c.DynamicFor(ctx, target.namespace)
+	if err != nil {
+		return err
+	}
+	propagation := metav1.DeletePropagationBackground
+	if err := dyn.Resource(target.gvr).Namespace(target.namespace).Delete(ctx, target.name, metav1.DeleteOptions{PropagationPolicy: &propagation}); err != nil {
+		return fmt.Errorf("failed to delete %s: %v", target, err)
+	}
+	log.Printf("Deleted %s for alert '%s'", target, action.AlertName)
+	return nil
+}
+
+// recordResourceChange keeps the difference in the object's spec, labels and annotations
+func recordResourceChange(action *RecoveryAction, kind string, target resourceTarget, before, after map[string]interface{}) {
+	var fields []FieldChange
+	diffValues("spec", before["spec"], after["spec"], &fields)
+	for _, key := range []string{"labels", "annotations"} {
+		b, _ := before["metadata"].(map[string]interface{})
+		a, _ := after["metadata"].(map[string]interface{})
+		diffValues("metadata."+key, b[key], a[key], &fields)
+	}
+	if len(fields) == 0 {
+		return
+	}
+	change := ObjectChange{Kind: kind, Namespace: target.namespace, Name: target.name, Fields: fields, resource: target.gvr}
+	if len(fields) > maxFieldChanges {
+		change.Fields, change.Truncated = fields[:maxFieldChanges], true
+	}
+	action.Changes = append(action.Changes, change)
+}
+
+// validateResourceParams checks a workflow step's params when the workflows are loaded
+func validateResourceParams(action string, params map[string]string) error {
+	gv, err := schema.ParseGroupVersion(params["apiVersion"])
+	if err != nil || gv.Version == "" || params["resource"] == "" || params["name"] == "" {
+		return fmt.Errorf("%s needs apiVersion (group/version), resource and name params", action)
+	}
+	if action == "patch_resource" {
+		if params["patch"] == "" {
+			return fmt.Errorf("patch_resource needs a patch param")
+		}
+		if pt := params["patchType"]; pt != "" && pt != "merge" && pt != "json" {
+			return fmt.Errorf("patchType %q isn't merge or json", pt)
+		}
+	}
+	if gr := gv.WithResource(params["resource"]).GroupResource(); !containsString(resourceActionsAllowed, gr.String()) {
+		log.Printf("Workflow steps using %s on %s will fail — it isn't in RESOURCE_ACTIONS_ALLOWED", action, gr)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Rollout watching: bumping the restartedAt annotation only proves the API
+// accepted the change. After a redeploy we follow the rollout the same way
+// `kubectl rollout status` does and record whether the new pods actually
+// became available.
+
+var rolloutTimeout = envDuration("ROLLOUT_TIMEOUT", 5*time.Minute)
+
+const rolloutPollInterval = 2 * time.Second
+
+// rollout outcomes
+const (
+	rolloutComplete = "complete"
+	rolloutFailed   = "failed"  // progress deadline exceeded
+	rolloutTimedOut = "timeout" // ROLLOUT_TIMEOUT passed first
+)
+
+var (
+	rolloutMu       sync.Mutex
+	rolloutOutcomes = map[string]int{}
+)
+
+func init() {
+	registerMetrics(writeRolloutMetrics)
+}
+
+// rolloutStatus reports whether a deployment finished rolling out to the given
+// generation, mirroring the checks in kubectl's DeploymentStatusViewer.
+func rolloutStatus(dep *appsv1.Deployment, generation int64) (done bool, err error) {
+	if dep.Status.ObservedGeneration < generation {
+		return false, nil
+	}
+	for _, c := range dep.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Status == corev1.ConditionFalse && c.Reason == "ProgressDeadlineExceeded" {
+			return false, fmt.Errorf("deployment %s/%s exceeded its progress deadline", dep.Namespace, dep.Name)
+		}
+	}
+	replicas := int32(1)
+	if dep.Spec.Replicas != nil {
+		replicas = *dep.Spec.Replicas
+	}
+	switch {
+	case dep.Status.UpdatedReplicas < replicas:
+		return false, nil
+	case dep.Status.Replicas > dep.Status.UpdatedReplicas:
+		return false, nil // old replicas still terminating
+	case dep.Status.AvailableReplicas < dep.Status.UpdatedReplicas:
+		return false, nil
+	}
+	return true, nil
+}
+
+// waitForRollout polls the deployment until it reaches the given generation
+// and all replicas are updated and available, or until rolloutTimeout.
+func waitForRollout(ctx context.Context, cs kubernetes.Interface, namespace, name string, generation int64) (string, error) {
+	var failure error
+	err := wait.PollUntilContextTimeout(ctx, rolloutPollInterval, rolloutTimeout, true, func(ctx context.Context) (bool, error) {
+		dep, err := cs.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			log.Printf("Rollout watch: failed to get deployment %s/%s: %v", namespace, name, err)
+			return false, nil // transient; keep polling
+		}
+		done, err := rolloutStatus(dep, generation)
+		if err != nil {
+			failure = err
+			return false, err
+		}
+		return done, nil
+	})
+	switch {
+	case err == nil:
+		return rolloutComplete, nil
+	case failure != nil:
+		return rolloutFailed, failure
+	default:
+		return rolloutTimedOut, fmt.Errorf("rollout of %s/%s not complete after %s", namespace, name, rolloutTimeout)
+	}
+}
+
+// rolloutWatch is a rollout an action started
+type rolloutWatch struct {
+	cs         kubernetes.Interface
+	namespace  string
+	name       string
+	generation int64
+}
+
+// watchRollout follows the rollout an action started and only then records
+// the action's history, failed (and escalated) if the rollout failed or timed out
+func watchRollout(w rolloutWatch, action *RecoveryAction, alert Alert, rec ActionRecord) {
+	start := time.Now()
+	outcome, err := waitForRollout(operatorCtx, w.cs, w.namespace, w.name, w.generation)
+
+	rolloutMu.Lock()
+	rolloutOutcomes[outcome]++
+	rolloutMu.Unlock()
+
+	recordHistory(rec, err)
+	if err != nil {
+		log.Printf("Rollout of %s/%s did not succeed (%s): %v", w.namespace, w.name, outcome, err)
+		escalate(action, alert, fmt.Sprintf("'%s' was applied, but the rollout of %s/%s did not succeed (%s): %v", action.Action, w.namespace, w.name, outcome, err))
+		return
+	}
+	log.Printf("Rollout of %s/%s complete after %s", w.namespace, w.name, time.Since(start).Round(time.Second))
+}
+
+func writeRolloutMetrics(w io.Writer) {
+	rolloutMu.Lock()
+	defer rolloutMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_rollouts_total Outcomes of rollouts triggered by the operator.")
+	fmt.Fprintln(w, "# TYPE selfhealing_rollouts_total counter")
+	for _, outcome := range []string{rolloutComplete, rolloutFailed, rolloutTimedOut} {
+		fmt.Fprintf(w, "selfhealing_rollouts_total{outcome=%q} %d\n", outcome, rolloutOutcomes[outcome])
+	}
+}
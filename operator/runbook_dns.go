@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// dns-recovery runbook for cluster DNS failures:
+//   - probe: run a Job that resolves DNS_PROBE_NAME; if it resolves and the
+//     DNS Service has ready endpoints, DNS has recovered and the runbook stops
+//   - restart CoreDNS (DNS_DEPLOYMENT) and wait for the rollout
+//   - wait until the DNS Service (DNS_SERVICE) has ready endpoints again
+//   - probe again; the runbook fails if names still don't resolve
+//
+// Probe Jobs run in the DNS namespace and are garbage-collected after
+// DNS_PROBE_TTL.
+
+var (
+	dnsDeployment = envString("DNS_DEPLOYMENT", "kube-system/coredns")
+	dnsService    = envString("DNS_SERVICE", "kube-system/kube-dns")
+	dnsProbeName  = envString("DNS_PROBE_NAME", "kubernetes.default.svc.cluster.local")
+	dnsProbeImage = envString("DNS_PROBE_IMAGE", "busybox:1.36")
+	dnsProbeTTL   = envDuration("DNS_PROBE_TTL", time.Hour)
+)
+
+func splitNamespacedName(s string) (string, string) {
+	ns, name, ok := strings.Cut(s, "/")
+	if !ok {
+		return "kube-system", s
+	}
+	return ns, name
+}
+
+// stepProbeDNS ends the runbook if DNS already works
+func stepProbeDNS(ctx context.Context, rc *runbookContext) error {
+	ready, err := dnsReadyEndpoints(ctx, rc)
+	if err != nil {
+		return err
+	}
+	if ready > 0 && runDNSProbe(ctx, rc) == nil {
+		log.Printf("DNS resolves and %s has %d ready endpoint(s) — nothing to do", dnsService, ready)
+		return errRunbookDone
+	}
+	return nil
+}
+
+// stepRestartDNS rolls the CoreDNS pods
+func stepRestartDNS(ctx context.Context, rc *runbookContext) error {
+	ns, name := splitNamespacedName(dnsDeployment)
+	deployments := rc.clients.Kube.AppsV1().Deployments(ns)
+	dep, err := deployments.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get DNS deployment %s: %v", dnsDeployment, err)
+	}
+	if err := verifyPodImages(ctx, dep.Spec.Template.Spec); err != nil {
+		return fmt.Errorf("refusing to restart %s: %v", dnsDeployment, err)
+	}
+	if dep.Spec.Template.Annotations == nil {
+		dep.Spec.Template.Annotations = map[string]string{}
+	}
+	dep.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
+	updated, err := deployments.Update(ctx, dep, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to restart %s: %v", dnsDeployment, err)
+	}
+	rc.deployment, rc.generation = updated.Name, updated.Generation
+	log.Printf("Rolling restart triggered for DNS deployment %s", dnsDeployment)
+	emitDeployMarker(rc.action, updated.Name, updated.Generation)
+	return nil
+}
+
+func verifyDNSRollout(ctx context.Context, rc *runbookContext) error {
+	ns, _ := splitNamespacedName(dnsDeployment)
+	_, err := waitForRollout(ctx, rc.clients.Kube, ns, rc.deployment, rc.generation)
+	return err
+}
+
+// verifyDNSEndpoints waits for the DNS Service to have ready endpoints
+func verifyDNSEndpoints(ctx context.Context, rc *runbookContext) error {
+	var ready int
+	err := wait.PollUntilContextTimeout(ctx, 5*time.Second, runbookVerifyTimeout, true, func(ctx context.Context) (bool, error) {
+		n, err := dnsReadyEndpoints(ctx, rc)
+		if err != nil {
+			return false, nil
+		}
+		ready = n
+		return n > 0, nil
+	})
+	if err != nil {
+		return fmt.Errorf("service %s has no ready endpoints after %s", dnsService, runbookVerifyTimeout)
+	}
+	log.Printf("Service %s has %d ready endpoint(s)", dnsService, ready)
+	return nil
+}
+
+// verifyDNSResolves runs the probe Job once more
+func verifyDNSResolves(ctx context.Context, rc *runbookContext) error {
+	if err := runDNSProbe(ctx, rc); err != nil {
+		return err
+	}
+	log.Printf("DNS probe resolved %s", dnsProbeName)
+	return nil
+}
+
+func dnsReadyEndpoints(ctx context.Context, rc *runbookContext) (int, error) {
+	ns, name := splitNamespacedName(dnsService)
+	slices, err := rc.clients.Kube.DiscoveryV1().EndpointSlices(ns).List(ctx, metav1.ListOptions{
+		LabelSelector: discoveryv1.LabelServiceName + "=" + name,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list endpoints of %s: %v", dnsService, err)
+	}
+	ready := 0
+	for _, s := range slices.Items {
+		for _, ep := range s.Endpoints {
+			if ep.Conditions.Ready == nil || *ep.Conditions.Ready {
+				ready += len(ep.Addresses)
+			}
+		}
+	}
+	return ready, nil
+}
+
+// runDNSProbe runs a Job that resolves DNS_PROBE_NAME and waits for its result
+func runDNSProbe(ctx context.Context, rc *runbookContext) error {
+	ns, _ := splitNamespacedName(dnsService)
+	backoff := int32(0)
+	deadline := int64(60)
+	ttl := int32(dnsProbeTTL.Seconds())
+	podSecurity, containerSecurity := restrictedSecurity()
+	meta := metav1.ObjectMeta{GenerateName: "selfhealing-dns-probe-"}
+	markOwned(&meta, dnsProbeTTL)
+	job := &batchv1.Job{
+		ObjectMeta: meta,
+		Spec: batchv1.JobSpec{
+			BackoffLimit:            &backoff,
+			ActiveDeadlineSeconds:   &deadline,
+			TTLSecondsAfterFinished: &ttl,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{managedByLabel: managedByValue}},
+				Spec: corev1.PodSpec{
+					RestartPolicy:   corev1.RestartPolicyNever,
+					NodeSelector:    map[string]string{corev1.LabelOSStable: "linux"},
+					SecurityContext: podSecurity,
+					Containers: []corev1.Container{{
+						Name:            "probe",
+						Image:           dnsProbeImage,
+						Command:         []string{"nslookup", dnsProbeName},
+						SecurityContext: containerSecurity,
+					}},
+				},
+			},
+		},
+	}
+	jobs := rc.clients.Kube.BatchV1().Jobs(ns)
+	created, err := jobs.Create(ctx, job, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create DNS probe job: %v", err)
+	}
+
+	var failed bool
+	err = wait.PollUntilContextTimeout(ctx, 3*time.Second, 2*time.Minute, true, func(ctx context.Context) (bool, error) {
+		j, err := jobs.Get(ctx, created.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		if j.Status.Succeeded > 0 {
+			return true, nil
+		}
+		if j.Status.Failed > 0 {
+			failed = true
+			return true, nil
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("DNS probe job %s/%s didn't finish: %v", ns, created.Name, err)
+	}
+	if failed {
+		return fmt.Errorf("DNS probe job %s/%s could not resolve %s", ns, created.Name, dnsProbeName)
+	}
+	return nil
+}
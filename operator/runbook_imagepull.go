@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// image-pull-backoff runbook: find out why the kubelet can't pull and fix
+// that cause:
+//   - auth: copy IMAGE_PULL_SECRET_SOURCE (namespace/name) over the pod's
+//     imagePullSecret, creating and referencing it if the pod has none, and
+//     restart the pod
+//   - registry outage: rewrite the image to the mirror configured in
+//     IMAGE_MIRRORS ("docker.io=mirror.example.com/dockerhub,ghcr.io=…")
+//   - not found: roll the container back to the image of the previous
+//     Deployment revision
+//
+// Rewritten images go through the provenance gate like any other rollout.
+
+const (
+	pullFailureAuth     = "auth"
+	pullFailureNotFound = "not-found"
+	pullFailureOutage   = "outage"
+)
+
+var (
+	imagePullSecretSource = envString("IMAGE_PULL_SECRET_SOURCE", "")
+	imageMirrors          = parseImageMirrors(envList("IMAGE_MIRRORS"))
+)
+
+// pullFailure is what the diagnose step found
+type pullFailure struct {
+	kind      string
+	pod       string
+	container string
+	image     string
+	message   string
+}
+
+// substrings of kubelet/runtime pull errors, checked in order
+var pullFailurePatterns = []struct {
+	kind     string
+	patterns []string
+}{
+	{pullFailureAuth, []string{"unauthorized", "authentication required", "no basic auth credentials", "access denied", "denied:", "forbidden"}},
+	{pullFailureNotFound, []string{"manifest unknown", "not found", "does not exist", "invalid reference format"}},
+	{pullFailureOutage, []string{"timeout", "connection refused", "connection reset", "no such host", "tls handshake", "eof",
+		"internal server error", "bad gateway", "service unavailable", "too many requests"}},
+}
+
+func classifyPullFailure(message string) string {
+	m := strings.ToLower(message)
+	for _, p := range pullFailurePatterns {
+		for _, s := range p.patterns {
+			if strings.Contains(m, s) {
+				return p.kind
+			}
+		}
+	}
+	return ""
+}
+
+func parseImageMirrors(entries []string) map[string]string {
+	out := map[string]string{}
+	for _, e := range entries {
+		if from, to, ok := strings.Cut(e, "="); ok {
+			out[strings.TrimSpace(from)] = strings.TrimRight(strings.TrimSpace(to), "/")
+		}
+	}
+	return out
+}
+
+// splitImageRegistry returns the registry and the rest of a reference,
+// with Docker Hub's implicit defaults spelled out
+func splitImageRegistry(image string) (string, string) {
+	first, rest, ok := strings.Cut(image, "/")
+	if ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return first, rest
+	}
+	if !ok {
+		return "docker.io", "library/" + image
+	}
+	return "docker.io", image
+}
+
+// mirrorImage returns the image on its configured mirror, or "" if none
+func mirrorImage(image string) string {
+	registry, rest := splitImageRegistry(image)
+	mirror, ok := imageMirrors[registry]
+	if !ok {
+		return ""
+	}
+	return mirror + "/" + rest
+}
+
+// stepDiagnosePull finds a container stuck pulling and classifies the failure
+func stepDiagnosePull(ctx context.Context, rc *runbookContext) error {
+	var pods []corev1.Pod
+	if rc.action.Pod != "" {
+		p, err := rc.cs.CoreV1().Pods(rc.action.Namespace).Get(ctx, rc.action.Pod, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get pod %s/%s: %v", rc.action.Namespace, rc.action.Pod, err)
+		}
+		pods = append(pods, *p)
+	} else {
+		list, err := rc.cs.CoreV1().Pods(rc.action.Namespace).List(ctx, metav1.ListOptions{LabelSelector: "app=" + rc.action.App})
+		if err != nil {
+			return fmt.Errorf("failed to list pods of %s/%s: %v", rc.action.Namespace, rc.action.App, err)
+		}
+		pods = list.Items
+	}
+
+	for _, p := range pods {
+		statuses := append(append([]corev1.ContainerStatus{}, p.Status.InitContainerStatuses...), p.Status.ContainerStatuses...)
+		for _, cs := range statuses {
+			w := cs.State.Waiting
+			if w == nil || (w.Reason != "ImagePullBackOff" && w.Reason != "ErrImagePull") {
+				continue
+			}
+			kind := classifyPullFailure(w.Message)
+			if kind == "" {
+				return fmt.Errorf("unrecognised pull failure for %s in %s: %s", cs.Image, p.Name, truncate(w.Message, 200))
+			}
+			rc.pull = &pullFailure{kind: kind, pod: p.Name, container: cs.Name, image: cs.Image, message: w.Message}
+			log.Printf("Pull failure for %s in %s/%s classified as %s: %s", cs.Image, p.Namespace, p.Name, kind, truncate(w.Message, 200))
+			return nil
+		}
+	}
+	log.Printf("No container of %s/%s is failing to pull any more", rc.action.Namespace, rc.action.App)
+	return errRunbookDone
+}
+
+// stepFixPull applies the remediation for the diagnosed failure
+func stepFixPull(ctx context.Context, rc *runbookContext) error {
+	switch rc.pull.kind {
+	case pullFailureAuth:
+		return refreshPullSecret(ctx, rc)
+	case pullFailureOutage:
+		mirrored := mirrorImage(rc.pull.image)
+		if mirrored == "" {
+			registry, _ := splitImageRegistry(rc.pull.image)
+			return fmt.Errorf("registry %s looks unavailable and IMAGE_MIRRORS has no mirror for it", registry)
+		}
+		return setContainerImage(ctx, rc, mirrored)
+	case pullFailureNotFound:
+		previous, err := previousImage(ctx, rc)
+		if err != nil {
+			return err
+		}
+		return setContainerImage(ctx, rc, previous)
+	}
+	return fmt.Errorf("no remediation for pull failure %q", rc.pull.kind)
+}
+
+// verifyPullFixed waits for the new rollout, or the restarted pod if the
+// Deployment wasn't changed
+func verifyPullFixed(ctx context.Context, rc *runbookContext) error {
+	if rc.generation == 0 {
+		return verifyReadyReplacement(ctx, rc)
+	}
+	return verifyRollout(ctx, rc)
+}
+
+func refreshPullSecret(ctx context.Context, rc *runbookContext) error {
+	srcNS, srcName, ok := strings.Cut(imagePullSecretSource, "/")
+	if !ok {
+		return fmt.Errorf("pull is failing on credentials and IMAGE_PULL_SECRET_SOURCE (namespace/name) isn't set")
+	}
+	src, err := rc.clients.Kube.CoreV1().Secrets(srcNS).Get(ctx, srcName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to read source secret %s: %v", imagePullSecretSource, err)
+	}
+
+	pod, err := rc.cs.CoreV1().Pods(rc.action.Namespace).Get(ctx, rc.pull.pod, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get pod %s/%s: %v", rc.action.Namespace, rc.pull.pod, err)
+	}
+	name := srcName
+	referenced := len(pod.Spec.ImagePullSecrets) > 0
+	if referenced {
+		name = pod.Spec.ImagePullSecrets[0].Name
+	}
+
+	secrets := rc.cs.CoreV1().Secrets(rc.action.Namespace)
+	existing, err := secrets.Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		_, err = secrets.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Type:       src.Type,
+			Data:       src.Data,
+		}, metav1.CreateOptions{})
+	case err == nil:
+		// the type is immutable; both are dockerconfigjson in practice
+		existing.Data = src.Data
+		_, err = secrets.Update(ctx, existing, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write pull secret %s/%s: %v", rc.action.Namespace, name, err)
+	}
+	log.Printf("Pull secret %s/%s refreshed from %s", rc.action.Namespace, name, imagePullSecretSource)
+
+	if !referenced {
+		// the template has to reference the secret; that rolls the Deployment
+		dep, err := findDeployment(ctx, rc.cs, rc.action.Namespace, rc.action.App)
+		if err != nil {
+			return err
+		}
+		before, updated, err := updateDeployment(ctx, rc.cs, dep, func(dep *appsv1.Deployment) error {
+			dep.Spec.Template.Spec.ImagePullSecrets = append(dep.Spec.Template.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		recordChange(rc.action, "Deployment", dep.Namespace, dep.Name, before, updated)
+		rc.deployment, rc.generation = updated.Name, updated.Generation
+		return nil
+	}
+
+	rc.restartedAt = time.Now()
+	err = rc.cs.CoreV1().Pods(rc.action.Namespace).Delete(ctx, rc.pull.pod, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to restart pod %s/%s: %v", rc.action.Namespace, rc.pull.pod, err)
+	}
+	return nil
+}
+
+// setContainerImage points the failing container at another image
+func setContainerImage(ctx context.Context, rc *runbookContext, image string) error {
+	dep, err := findDeployment(ctx, rc.cs, rc.action.Namespace, rc.action.App)
+	if err != nil {
+		return err
+	}
+	var old string
+	before, updated, err := updateDeployment(ctx, rc.cs, dep, func(dep *appsv1.Deployment) error {
+		spec := &dep.Spec.Template.Spec
+		var c *corev1.Container
+		for i := range spec.InitContainers {
+			if spec.InitContainers[i].Name == rc.pull.container {
+				c = &spec.InitContainers[i]
+			}
+		}
+		for i := range spec.Containers {
+			if spec.Containers[i].Name == rc.pull.container {
+				c = &spec.Containers[i]
+			}
+		}
+		if c == nil {
+			return fmt.Errorf("deployment %s/%s has no container %s", dep.Namespace, dep.Name, rc.pull.container)
+		}
+		old = c.Image
+		c.Image = image
+		if err := verifyPodImages(ctx, *spec); err != nil {
+			return fmt.Errorf("refusing to roll %s/%s: %v", dep.Namespace, dep.Name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	recordChange(rc.action, "Deployment", dep.Namespace, dep.Name, before, updated)
+	rc.deployment, rc.generation = updated.Name, updated.Generation
+	emitDeployMarker(rc.action, updated.Name, updated.Generation)
+	log.Printf("Container %s of %s/%s: image %s -> %s", rc.pull.container, dep.Namespace, dep.Name, old, image)
+	return nil
+}
+
+// previousImage returns the container's image in the newest older revision
+// of the Deployment that used a different one
+func previousImage(ctx context.Context, rc *runbookContext) (string, error) {
+	dep, err := findDeployment(ctx, rc.cs, rc.action.Namespace, rc.action.App)
+	if err != nil {
+		return "", err
+	}
+	owned, err := ownedReplicaSets(ctx, rc.cs, dep)
+	if err != nil {
+		return "", err
+	}
+	for _, rs := range owned {
+		spec := rs.Spec.Template.Spec
+		for _, c := range append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...) {
+			if c.Name == rc.pull.container && c.Image != rc.pull.image {
+				log.Printf("Rolling %s back to %s from revision %d", rc.pull.container, c.Image, rsRevision(rs))
+				return c.Image, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("image %s not found and no earlier revision of %s/%s uses another image", rc.pull.image, dep.Namespace, dep.Name)
+}
+
+func rsRevision(rs appsv1.ReplicaSet) int {
+	n, _ := strconv.Atoi(rs.Annotations["deployment.kubernetes.io/revision"])
+	return n
+}
+
+// ownedReplicaSets returns the Deployment's replica sets, newest revision first
+func ownedReplicaSets(ctx context.Context, cs kubernetes.Interface, dep *appsv1.Deployment) ([]appsv1.ReplicaSet, error) {
+	selector, err := metav1.LabelSelectorAsSelector(dep.Spec.Selector)
+	if err != nil {
+		selector = labels.Everything()
+	}
+	list, err := cs.AppsV1().ReplicaSets(dep.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list replica sets of %s/%s: %v", dep.Namespace, dep.Name, err)
+	}
+	var owned []appsv1.ReplicaSet
+	for _, rs := range list.Items {
+		if owner := metav1.GetControllerOf(&rs); owner != nil && owner.UID == dep.UID {
+			owned = append(owned, rs)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool { return rsRevision(owned[i]) > rsRevision(owned[j]) })
+	return owned, nil
+}
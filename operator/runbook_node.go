@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// node-not-ready runbook: a node that stops reporting keeps its pods
+// "Running" on paper (Terminating, once evicted) and Deployments don't
+// replace them until someone deletes them. The runbook
+//   - waits until the node has been NotReady for NODE_NOT_READY_GRACE, and
+//     stops early (successfully) if it comes back, or if it was drained for a
+//     spot interruption (see interruptions.go)
+//   - cordons it
+//   - force-deletes its pods; pods with PersistentVolumeClaims only with
+//     NODE_NOT_READY_FORCE_VOLUMES=true, which also sets the
+//     node.kubernetes.io/out-of-service taint so their volumes are detached.
+//     Only enable it if a NotReady node is known to be powered off — a node
+//     that is merely partitioned would keep writing to the volume.
+//   - waits for every moved workload to have a ready pod on another node
+//   - POSTs {"node", "providerID", "alert"} to NODE_RECYCLE_URL, if set, so
+//     infrastructure automation can replace the machine
+
+var (
+	nodeNotReadyGrace        = envDuration("NODE_NOT_READY_GRACE", 5*time.Minute)
+	nodeNotReadyForceVolumes = envBool("NODE_NOT_READY_FORCE_VOLUMES", false)
+	nodeRecycleURL           = envString("NODE_RECYCLE_URL", "")
+)
+
+// movedWorkload is the controller of a pod deleted from the node
+type movedWorkload struct {
+	namespace string
+	owner     metav1.OwnerReference
+}
+
+// errRunbookDone ends a runbook early without failing it
+var errRunbookDone = errors.New("nothing left to do")
+
+// nodeReady returns the node's Ready condition status and when it last changed
+func nodeReady(node *corev1.Node) (bool, time.Time) {
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue, c.LastTransitionTime.Time
+		}
+	}
+	return false, node.CreationTimestamp.Time
+}
+
+// stepWaitNotReady waits out the grace period; a node that recovers ends the runbook
+func stepWaitNotReady(ctx context.Context, rc *runbookContext) error {
+	node, err := alertNode(ctx, rc)
+	if err != nil {
+		return err
+	}
+	rc.node = node
+
+	var since time.Time
+	recovered, interrupted := false, false
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Second, nodeNotReadyGrace+time.Minute, true, func(ctx context.Context) (bool, error) {
+		n, err := rc.clients.Kube.CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		if nodeInterrupted(n) {
+			interrupted = true
+			return true, nil
+		}
+		ready, changed := nodeReady(n)
+		if ready {
+			recovered = true
+			return true, nil
+		}
+		since = changed
+		return time.Since(changed) >= nodeNotReadyGrace, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not confirm node %s state: %v", node, err)
+	}
+	if recovered {
+		log.Printf("Node %s is Ready again — nothing to do", node)
+		return errRunbookDone
+	}
+	if interrupted {
+		log.Printf("Node %s was drained for a spot interruption — the cloud replaces it", node)
+		return errRunbookDone
+	}
+	log.Printf("Node %s NotReady since %s", node, since.Format(time.RFC3339))
+	return nil
+}
+
+// stepForceDeletePods deletes the dead node's pods so their controllers replace them
+func stepForceDeletePods(ctx context.Context, rc *runbookContext) error {
+	pods, err := drainablePods(ctx, rc.clients.Kube, rc.node)
+	if err != nil {
+		return err
+	}
+	if nodeNotReadyForceVolumes {
+		if err := taintOutOfService(ctx, rc); err != nil {
+			return err
+		}
+	}
+
+	rc.movedOwners = map[string]movedWorkload{}
+	zero := int64(0)
+	deleted, kept := 0, 0
+	for _, p := range pods {
+		if hasClaims(p) && !nodeNotReadyForceVolumes {
+			log.Printf("Leaving %s/%s on node %s — it has volumes (NODE_NOT_READY_FORCE_VOLUMES is off)", p.Namespace, p.Name, rc.node)
+			kept++
+			continue
+		}
+		err := rc.clients.Kube.CoreV1().Pods(p.Namespace).Delete(ctx, p.Name, metav1.DeleteOptions{GracePeriodSeconds: &zero})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete pod %s/%s: %v", p.Namespace, p.Name, err)
+		}
+		deleted++
+		if owner := metav1.GetControllerOf(&p); owner != nil {
+			rc.movedOwners[string(owner.UID)] = movedWorkload{namespace: p.Namespace, owner: *owner}
+		}
+	}
+	log.Printf("Force-deleted %d pod(s) from node %s (%d with volumes left)", deleted, rc.node, kept)
+	return nil
+}
+
+func hasClaims(p corev1.Pod) bool {
+	for _, v := range p.Spec.Volumes {
+		if v.PersistentVolumeClaim != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// taintOutOfService marks the node as shut down so volume attachments are released
+func taintOutOfService(ctx context.Context, rc *runbookContext) error {
+	nodes := rc.clients.Kube.CoreV1().Nodes()
+	n, err := nodes.Get(ctx, rc.node, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node %s: %v", rc.node, err)
+	}
+	for _, t := range n.Spec.Taints {
+		if t.Key == corev1.TaintNodeOutOfService {
+			return nil
+		}
+	}
+	n.Spec.Taints = append(n.Spec.Taints, corev1.Taint{
+		Key:    corev1.TaintNodeOutOfService,
+		Value:  "nodeshutdown",
+		Effect: corev1.TaintEffectNoExecute,
+	})
+	if _, err := nodes.Update(ctx, n, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to taint node %s out-of-service: %v", rc.node, err)
+	}
+	log.Printf("Node %s tainted %s", rc.node, corev1.TaintNodeOutOfService)
+	return nil
+}
+
+// verifyRescheduled waits until every moved workload has a ready pod on another node
+func verifyRescheduled(ctx context.Context, rc *runbookContext) error {
+	var waiting []string
+	err := wait.PollUntilContextTimeout(ctx, 5*time.Second, runbookVerifyTimeout, true, func(ctx context.Context) (bool, error) {
+		waiting = waiting[:0]
+		for _, m := range rc.movedOwners {
+			if !hasReadyPodElsewhere(ctx, rc, m.namespace, m.owner) {
+				waiting = append(waiting, m.namespace+"/"+m.owner.Name)
+			}
+		}
+		return len(waiting) == 0, nil
+	})
+	if err != nil {
+		return fmt.Errorf("no ready replacement after %s for %v", runbookVerifyTimeout, waiting)
+	}
+	log.Printf("Workloads from node %s rescheduled (%d owner(s))", rc.node, len(rc.movedOwners))
+	return nil
+}
+
+func hasReadyPodElsewhere(ctx context.Context, rc *runbookContext, namespace string, owner metav1.OwnerReference) bool {
+	var pods []corev1.Pod
+	if podLister != nil {
+		cached, err := podLister.Pods(namespace).List(labels.Everything())
+		if err != nil {
+			return false
+		}
+		for _, p := range cached {
+			pods = append(pods, *p)
+		}
+	} else {
+		list, err := rc.clients.Kube.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false
+		}
+		pods = list.Items
+	}
+	for _, p := range pods {
+		c := metav1.GetControllerOf(&p)
+		if c == nil || c.UID != owner.UID || p.Spec.NodeName == rc.node || p.DeletionTimestamp != nil {
+			continue
+		}
+		if podReady(&p) {
+			return true
+		}
+	}
+	return false
+}
+
+// stepRecycleNode hands the machine to infrastructure automation
+func stepRecycleNode(ctx context.Context, rc *runbookContext) error {
+	if nodeRecycleURL == "" {
+		return nil
+	}
+	var providerID string
+	if n, err := rc.clients.Kube.CoreV1().Nodes().Get(ctx, rc.node, metav1.GetOptions{}); err == nil {
+		providerID = n.Spec.ProviderID
+	}
+	body, _ := json.Marshal(map[string]string{
+		"node":       rc.node,
+		"providerID": providerID,
+		"alert":      rc.action.AlertName,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, nodeRecycleURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := egressClient("node_recycle").Do(req)
+	if err != nil {
+		return fmt.Errorf("node recycle request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("node recycle request returned status %d", resp.StatusCode)
+	}
+	log.Printf("Requested recycling of node %s (%s)", rc.node, providerID)
+	return nil
+}
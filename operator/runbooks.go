@@ -0,0 +1,535 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Built-in runbooks: named, multi-step remediations with verification, so
+// alert rules can say `recovery_action: runbook` + `runbook: crashloop-standard`
+// instead of assembling primitives. Steps run in order; the first failing
+// step (or failing verification) stops the runbook and escalates.
+//
+// Runbooks can take minutes, so they run in the background and the webhook
+// returns as soon as one has started.
+
+// runbookStep is one step of a runbook. Verify, if set, must pass before the
+// next step starts.
+type runbookStep struct {
+	Name   string
+	Run    func(ctx context.Context, rc *runbookContext) error
+	Verify func(ctx context.Context, rc *runbookContext) error
+}
+
+type runbook struct {
+	Name        string
+	Description string
+	Steps       []runbookStep
+}
+
+// runbookContext is shared by the steps of one runbook run
+type runbookContext struct {
+	clients *Clients
+	cs      kubernetes.Interface // clients.For(action.Namespace)
+	action  *RecoveryAction
+	alert   Alert
+
+	// filled in by steps for later steps
+	node        string
+	nodeOS      string
+	restartedAt time.Time
+	generation  int64
+	deployment  string
+	pvcSize     resource.Quantity
+	movedOwners map[string]movedWorkload // controller UID -> workload of a deleted pod
+	pull        *pullFailure
+}
+
+var (
+	runbookVerifyTimeout = envDuration("RUNBOOK_VERIFY_TIMEOUT", 3*time.Minute)
+	runbookOOMMaxMemory  = envQuantity("RUNBOOK_OOM_MAX_MEMORY", "1Gi")
+	runbookPVCMaxSize    = envQuantity("RUNBOOK_PVC_MAX_SIZE", "100Gi")
+)
+
+var runbooks = map[string]*runbook{
+	"crashloop-standard": {
+		Name:        "crashloop-standard",
+		Description: "Capture logs, restart the pod and wait for a ready replacement; fall back to a rolling restart",
+		Steps: []runbookStep{
+			{Name: "capture-logs", Run: stepCaptureLogs},
+			{Name: "restart-pod", Run: stepRestartPod, Verify: verifyReadyReplacement},
+		},
+	},
+	"oom-bump-and-restart": {
+		Name:        "oom-bump-and-restart",
+		Description: "Raise container memory limits by 25% (capped) and verify the rollout",
+		Steps: []runbookStep{
+			{Name: "capture-logs", Run: stepCaptureLogs},
+			{Name: "bump-memory", Run: stepBumpMemory, Verify: verifyRollout},
+		},
+	},
+	"node-pressure-drain": {
+		Name:        "node-pressure-drain",
+		Description: "Cordon the node and evict its pods (respecting PodDisruptionBudgets)",
+		Steps: []runbookStep{
+			{Name: "cordon-node", Run: stepCordonNode},
+			{Name: "evict-pods", Run: stepEvictPods, Verify: verifyNodeDrained},
+		},
+	},
+	"node-not-ready": {
+		Name:        "node-not-ready",
+		Description: "After a grace period, cordon a NotReady node, force-delete its pods and verify they were rescheduled",
+		Steps: []runbookStep{
+			{Name: "wait-grace-period", Run: stepWaitNotReady},
+			{Name: "cordon-node", Run: stepCordonNode},
+			{Name: "force-delete-pods", Run: stepForceDeletePods, Verify: verifyRescheduled},
+			{Name: "recycle-node", Run: stepRecycleNode},
+		},
+	},
+	"node-interruption": {
+		Name:        "node-interruption",
+		Description: "Cordon a spot/preemptible node with an interruption notice and evict its pods before the deadline (respecting PodDisruptionBudgets)",
+		Steps: []runbookStep{
+			{Name: "cordon-node", Run: stepCordonInterrupted},
+			{Name: "evict-pods", Run: stepEvictBeforeDeadline, Verify: verifyDrainedBeforeDeadline},
+		},
+	},
+	"dns-recovery": {
+		Name:        "dns-recovery",
+		Description: "Probe cluster DNS; if it's broken, restart CoreDNS and verify endpoints and resolution",
+		Steps: []runbookStep{
+			{Name: "probe", Run: stepProbeDNS},
+			{Name: "restart-coredns", Run: stepRestartDNS, Verify: verifyDNSRollout},
+			{Name: "verify-endpoints", Run: verifyDNSEndpoints},
+			{Name: "verify-resolution", Run: verifyDNSResolves},
+		},
+	},
+	"image-pull-backoff": {
+		Name:        "image-pull-backoff",
+		Description: "Classify the pull failure and refresh the pull secret, switch to a mirror registry or roll back to the previous image",
+		Steps: []runbookStep{
+			{Name: "diagnose", Run: stepDiagnosePull},
+			{Name: "remediate", Run: stepFixPull, Verify: verifyPullFixed},
+		},
+	},
+	"pvc-full-expand": {
+		Name:        "pvc-full-expand",
+		Description: "Grow the PersistentVolumeClaim by 50% (capped) and verify the resize",
+		Steps: []runbookStep{
+			{Name: "expand-pvc", Run: stepExpandPVC, Verify: verifyPVCResized},
+		},
+	},
+}
+
+// runbook outcomes, exported as metrics
+var (
+	runbookMu       sync.Mutex
+	runbookRunning  = map[string]bool{} // key = "namespace/app"
+	runbookOutcomes = map[string]int{}  // key = "name|outcome"
+)
+
+func init() {
+	registerMetrics(writeRunbookMetrics)
+}
+
+// startRunbook validates the runbook and runs it in the background
+func startRunbook(ctx context.Context, c *Clients, action *RecoveryAction, alert Alert) error {
+	rb, ok := runbooks[action.Runbook]
+	if !ok {
+		return fmt.Errorf("unknown runbook %q", action.Runbook)
+	}
+
+	cs, err := c.For(ctx, action.Namespace)
+	if err != nil {
+		return err
+	}
+
+	key := action.Namespace + "/" + action.App
+	runbookMu.Lock()
+	if runbookRunning[key] {
+		runbookMu.Unlock()
+		return fmt.Errorf("a runbook is already running for %s", key)
+	}
+	runbookRunning[key] = true
+	runbookMu.Unlock()
+
+	go func() {
+		defer func() {
+			runbookMu.Lock()
+			delete(runbookRunning, key)
+			runbookMu.Unlock()
+		}()
+		unlock, err := workloadLocks.lock(operatorCtx, key)
+		if err != nil {
+			log.Printf("Runbook %s not started — %v", rb.Name, err)
+			return
+		}
+		defer unlock()
+		runRunbook(operatorCtx, rb, &runbookContext{cs: cs, clients: c, action: action, alert: alert})
+	}()
+	return nil
+}
+
+func runRunbook(ctx context.Context, rb *runbook, rc *runbookContext) {
+	target := rc.action.Namespace + "/" + rc.action.App
+	rec := newActionRecord(rc.action, rb.Name, time.Now())
+	log.Printf("Runbook %s started for %s", rb.Name, target)
+
+	outcome := "succeeded"
+	var runErr error
+	for i, step := range rb.Steps {
+		log.Printf("Runbook %s [%d/%d] %s", rb.Name, i+1, len(rb.Steps), step.Name)
+		start := time.Now()
+		err := step.Run(ctx, rc)
+		if err == nil && step.Verify != nil {
+			err = step.Verify(ctx, rc)
+		}
+		sr := StepRecord{
+			Name:      step.Name,
+			Action:    step.Name,
+			Status:    stepSucceeded,
+			StartedAt: start,
+			Duration:  time.Since(start).Round(time.Millisecond).String(),
+		}
+		if errors.Is(err, errRunbookDone) {
+			rec.Steps = append(rec.Steps, sr)
+			log.Printf("Runbook %s finished early at step %s for %s", rb.Name, step.Name, target)
+			break
+		}
+		if err != nil {
+			sr.Status, sr.Error = stepFailed, err.Error()
+		}
+		rec.Steps = append(rec.Steps, sr)
+		if err != nil {
+			outcome = "failed"
+			runErr = fmt.Errorf("step %s: %v", step.Name, err)
+			log.Printf("Runbook %s failed at step %s for %s: %v", rb.Name, step.Name, target, err)
+			escalate(rc.action, rc.alert, fmt.Sprintf("runbook %s failed at step %s: %v", rb.Name, step.Name, err))
+			break
+		}
+	}
+	rec.Changes = rc.action.Changes
+	recordHistory(rec, runErr)
+
+	runbookMu.Lock()
+	runbookOutcomes[rb.Name+"|"+outcome]++
+	runbookMu.Unlock()
+	log.Printf("Runbook %s %s for %s", rb.Name, outcome, target)
+}
+
+func writeRunbookMetrics(w io.Writer) {
+	runbookMu.Lock()
+	defer runbookMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_runbooks_total Runbook runs by outcome.")
+	fmt.Fprintln(w, "# TYPE selfhealing_runbooks_total counter")
+	for _, key := range sortedKeys(runbookOutcomes) {
+		name, outcome, _ := strings.Cut(key, "|")
+		fmt.Fprintf(w, "selfhealing_runbooks_total{runbook=%q,outcome=%q} %d\n", name, outcome, runbookOutcomes[key])
+	}
+}
+
+// --- steps ---
+
+// stepCaptureLogs writes the tail of the crashed container's logs to the operator log
+func stepCaptureLogs(ctx context.Context, rc *runbookContext) error {
+	if rc.action.Pod == "" {
+		log.Printf("No pod label on alert — skipping log capture")
+		return nil
+	}
+	defer captureRuntimeDiagnostics(ctx, rc)
+	tail := int64(50)
+	for _, previous := range []bool{true, false} {
+		raw, err := rc.cs.CoreV1().Pods(rc.action.Namespace).GetLogs(rc.action.Pod, &corev1.PodLogOptions{
+			Previous:  previous,
+			TailLines: &tail,
+		}).DoRaw(ctx)
+		if err != nil {
+			continue
+		}
+		log.Printf("Last %d log lines of %s/%s (previous=%t):\n%s", tail, rc.action.Namespace, rc.action.Pod, previous, raw)
+		return nil
+	}
+	// Logs are nice to have; never fail the runbook over them
+	log.Printf("Could not capture logs for %s/%s", rc.action.Namespace, rc.action.Pod)
+	return nil
+}
+
+func stepRestartPod(ctx context.Context, rc *runbookContext) error {
+	rc.restartedAt = time.Now()
+	return restartPod(ctx, rc.clients, rc.action)
+}
+
+// verifyReadyReplacement waits for a ready pod of the app created after the
+// restart; if none shows up it falls back to a rolling restart of the whole
+// deployment.
+func verifyReadyReplacement(ctx context.Context, rc *runbookContext) error {
+	err := wait.PollUntilContextTimeout(ctx, rolloutPollInterval, runbookVerifyTimeout, false, func(ctx context.Context) (bool, error) {
+		pods, err := rc.cs.CoreV1().Pods(rc.action.Namespace).List(ctx, metav1.ListOptions{LabelSelector: "app=" + rc.action.App})
+		if err != nil {
+			return false, nil
+		}
+		for _, p := range pods.Items {
+			created := p.CreationTimestamp.Time
+			if p.DeletionTimestamp == nil && !created.Before(rc.restartedAt.Truncate(time.Second)) && podReady(&p) {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err == nil {
+		return nil
+	}
+
+	log.Printf("No ready replacement for %s/%s after %s — falling back to rolling restart",
+		rc.action.Namespace, rc.action.Pod, runbookVerifyTimeout)
+	if err := stepRollingRestart(ctx, rc); err != nil {
+		return err
+	}
+	return verifyRollout(ctx, rc)
+}
+
+func podReady(p *corev1.Pod) bool {
+	for _, c := range p.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// stepBumpMemory raises every container's memory limit by 25%, up to RUNBOOK_OOM_MAX_MEMORY
+func stepBumpMemory(ctx context.Context, rc *runbookContext) error {
+	dep, err := findDeployment(ctx, rc.cs, rc.action.Namespace, rc.action.App)
+	if err != nil {
+		return err
+	}
+	if err := verifyPodImages(ctx, dep.Spec.Template.Spec); err != nil {
+		return fmt.Errorf("refusing to roll %s/%s: %v", dep.Namespace, dep.Name, err)
+	}
+
+	_, updated, err := updateDeployment(ctx, rc.cs, dep, bumpMemoryLimits)
+	if err != nil {
+		return err
+	}
+	rc.deployment, rc.generation = updated.Name, updated.Generation
+	emitDeployMarker(rc.action, updated.Name, updated.Generation)
+	return nil
+}
+
+// bumpMemoryLimits raises the memory limits in the deployment's template
+func bumpMemoryLimits(dep *appsv1.Deployment) error {
+	bumped := 0
+	for i := range dep.Spec.Template.Spec.Containers {
+		c := &dep.Spec.Template.Spec.Containers[i]
+		limit, ok := c.Resources.Limits[corev1.ResourceMemory]
+		if !ok {
+			continue
+		}
+		newLimit := resource.NewQuantity(limit.Value()*5/4, resource.BinarySI)
+		if newLimit.Cmp(runbookOOMMaxMemory) > 0 {
+			capped := runbookOOMMaxMemory.DeepCopy()
+			newLimit = &capped
+		}
+		if newLimit.Cmp(limit) <= 0 {
+			continue
+		}
+		log.Printf("Raising memory limit of %s/%s container %s: %s -> %s",
+			dep.Namespace, dep.Name, c.Name, limit.String(), newLimit.String())
+		c.Resources.Limits[corev1.ResourceMemory] = *newLimit
+		bumped++
+	}
+	if bumped == 0 {
+		return fmt.Errorf("no container in %s/%s has a memory limit below %s", dep.Namespace, dep.Name, runbookOOMMaxMemory.String())
+	}
+	return nil
+}
+
+func verifyRollout(ctx context.Context, rc *runbookContext) error {
+	_, err := waitForRollout(ctx, rc.cs, rc.action.Namespace, rc.deployment, rc.generation)
+	return err
+}
+
+// alertNode finds the node an alert is about: the `node` label, or the node of the alerting pod
+func alertNode(ctx context.Context, rc *runbookContext) (string, error) {
+	if n := rc.action.Labels["node"]; n != "" {
+		return n, nil
+	}
+	if rc.action.Pod == "" {
+		return "", fmt.Errorf("alert has neither a node nor a pod label")
+	}
+	pod, err := rc.clients.Kube.CoreV1().Pods(rc.action.Namespace).Get(ctx, rc.action.Pod, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get pod %s/%s: %v", rc.action.Namespace, rc.action.Pod, err)
+	}
+	if pod.Spec.NodeName == "" {
+		return "", fmt.Errorf("pod %s/%s is not scheduled", rc.action.Namespace, rc.action.Pod)
+	}
+	return pod.Spec.NodeName, nil
+}
+
+// Nodes are cluster-scoped, so node steps use the operator's own client
+func stepCordonNode(ctx context.Context, rc *runbookContext) error {
+	node, err := alertNode(ctx, rc)
+	if err != nil {
+		return err
+	}
+	rc.node = node
+	patch := []byte(`{"spec":{"unschedulable":true}}`)
+	n, err := rc.clients.Kube.CoreV1().Nodes().Patch(ctx, node, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to cordon node %s: %v", node, err)
+	}
+	rc.nodeOS = nodeOS(n)
+	log.Printf("Node %s (%s) cordoned", node, rc.nodeOS)
+	return nil
+}
+
+// drainablePods lists pods on the node that an eviction would move
+func drainablePods(ctx context.Context, cs kubernetes.Interface, node string) ([]corev1.Pod, error) {
+	pods, err := cs.CoreV1().Pods("").List(ctx, metav1.ListOptions{FieldSelector: "spec.nodeName=" + node})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods on node %s: %v", node, err)
+	}
+	var out []corev1.Pod
+	for _, p := range pods.Items {
+		if p.DeletionTimestamp != nil || p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if _, mirror := p.Annotations[corev1.MirrorPodAnnotationKey]; mirror {
+			continue
+		}
+		owner := metav1.GetControllerOf(&p)
+		if owner != nil && owner.Kind == "DaemonSet" {
+			continue
+		}
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Namespace+"/"+out[i].Name < out[j].Namespace+"/"+out[j].Name })
+	return out, nil
+}
+
+func stepEvictPods(ctx context.Context, rc *runbookContext) error {
+	pods, err := drainablePods(ctx, rc.clients.Kube, rc.node)
+	if err != nil {
+		return err
+	}
+	for i := range pods {
+		p := &pods[i]
+		err := evictPod(ctx, rc.clients.Kube, p.Namespace, p.Name, drainGracePeriod(rc, p))
+		if err != nil && !apierrors.IsTooManyRequests(err) {
+			return err
+		}
+		// 429 means a PodDisruptionBudget blocks it for now; verification retries
+	}
+	log.Printf("Requested eviction of %d pod(s) from node %s", len(pods), rc.node)
+	return nil
+}
+
+func evictPod(ctx context.Context, cs kubernetes.Interface, namespace, name string, grace *int64) error {
+	eviction := &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	if grace != nil {
+		eviction.DeleteOptions = &metav1.DeleteOptions{GracePeriodSeconds: grace}
+	}
+	err := cs.PolicyV1().Evictions(namespace).Evict(ctx, eviction)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// verifyNodeDrained keeps retrying evictions until the node is empty
+func verifyNodeDrained(ctx context.Context, rc *runbookContext) error {
+	timeout := drainTimeout(rc)
+	err := wait.PollUntilContextTimeout(ctx, 5*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		pods, err := drainablePods(ctx, rc.clients.Kube, rc.node)
+		if err != nil {
+			return false, nil
+		}
+		for i := range pods {
+			evictPod(ctx, rc.clients.Kube, pods[i].Namespace, pods[i].Name, drainGracePeriod(rc, &pods[i]))
+		}
+		return len(pods) == 0, nil
+	})
+	if err != nil {
+		return fmt.Errorf("node %s still has pods after %s", rc.node, timeout)
+	}
+	log.Printf("Node %s drained", rc.node)
+	return nil
+}
+
+// stepExpandPVC grows the claim named by the alert's `persistentvolumeclaim` label
+func stepExpandPVC(ctx context.Context, rc *runbookContext) error {
+	name := rc.action.Labels["persistentvolumeclaim"]
+	if name == "" {
+		return fmt.Errorf("alert has no persistentvolumeclaim label")
+	}
+	pvcs := rc.cs.CoreV1().PersistentVolumeClaims(rc.action.Namespace)
+	pvc, err := pvcs.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get pvc %s/%s: %v", rc.action.Namespace, name, err)
+	}
+
+	if sc := pvc.Spec.StorageClassName; sc != nil && *sc != "" {
+		class, err := rc.clients.Kube.StorageV1().StorageClasses().Get(ctx, *sc, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get storage class %s: %v", *sc, err)
+		}
+		if class.AllowVolumeExpansion == nil || !*class.AllowVolumeExpansion {
+			return fmt.Errorf("storage class %s does not allow volume expansion", *sc)
+		}
+	}
+
+	current := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	newSize := resource.NewQuantity(current.Value()*3/2, resource.BinarySI)
+	if newSize.Cmp(runbookPVCMaxSize) > 0 {
+		capped := runbookPVCMaxSize.DeepCopy()
+		newSize = &capped
+	}
+	if newSize.Cmp(current) <= 0 {
+		return fmt.Errorf("pvc %s/%s is already at the %s cap", rc.action.Namespace, name, runbookPVCMaxSize.String())
+	}
+
+	pvc.Spec.Resources.Requests[corev1.ResourceStorage] = *newSize
+	if _, err := pvcs.Update(ctx, pvc, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to expand pvc %s/%s: %v", rc.action.Namespace, name, err)
+	}
+	rc.pvcSize = *newSize
+	log.Printf("PVC %s/%s resize requested: %s -> %s", rc.action.Namespace, name, current.String(), newSize.String())
+	return nil
+}
+
+// verifyPVCResized waits until the volume reports the new capacity. A pending
+// filesystem resize counts as done: the kubelet finishes it on next mount.
+func verifyPVCResized(ctx context.Context, rc *runbookContext) error {
+	name := rc.action.Labels["persistentvolumeclaim"]
+	return wait.PollUntilContextTimeout(ctx, 5*time.Second, runbookVerifyTimeout, true, func(ctx context.Context) (bool, error) {
+		pvc, err := rc.cs.CoreV1().PersistentVolumeClaims(rc.action.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		for _, c := range pvc.Status.Conditions {
+			if c.Type == corev1.PersistentVolumeClaimFileSystemResizePending && c.Status == corev1.ConditionTrue {
+				return true, nil
+			}
+		}
+		capacity := pvc.Status.Capacity[corev1.ResourceStorage]
+		return capacity.Cmp(rc.pvcSize) >= 0, nil
+	})
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Safe mode: while the control plane is degraded, acting on workloads makes
+// things worse — every restart or rollout is more load on an API server or
+// etcd that is already struggling. A firing alert named in SAFE_MODE_ALERTS
+// (or labelled safe_mode="true") puts the operator in safe mode:
+//   - actions other than notify are queued instead of run (one per workload,
+//     the latest wins, at most SAFE_MODE_QUEUE_SIZE)
+//   - Slack is told when safe mode starts and ends
+//   - when every such alert has resolved, queued actions whose alert hasn't
+//     resolved in the meantime and that are younger than SAFE_MODE_MAX_AGE go
+//     through the usual checks again and run
+//
+// The control-plane alerts themselves pass through as usual, so a notify
+// mapping still pages someone. A trigger alert that's neither re-sent nor
+// resolved for SAFE_MODE_TIMEOUT is forgotten; keep the Alertmanager
+// repeat_interval for these alerts below it.
+
+var safeModeDefaultAlerts = []string{
+	"KubeAPIDown", "KubeAPIErrorBudgetBurn", "KubeAPITerminatedRequests",
+	"KubeControllerManagerDown", "KubeSchedulerDown",
+	"etcdNoLeader", "etcdInsufficientMembers", "etcdMembersDown",
+	"etcdHighNumberOfFailedGRPCRequests", "etcdHighCommitDurations",
+}
+
+type safeModeState struct {
+	alerts    map[string]bool
+	timeout   time.Duration
+	maxAge    time.Duration
+	queueSize int
+
+	mu       sync.Mutex
+	active   map[string]time.Time // trigger alert identity -> last seen
+	since    time.Time
+	queue    map[string]queuedJob // workload -> latest held action
+	entered  int
+	released int
+	dropped  int
+}
+
+type queuedJob struct {
+	job      workloadJob
+	alertID  string
+	queuedAt time.Time
+}
+
+var safeMode = newSafeModeState()
+
+func newSafeModeState() *safeModeState {
+	names := envList("SAFE_MODE_ALERTS")
+	if len(names) == 0 {
+		names = safeModeDefaultAlerts
+	}
+	s := &safeModeState{
+		alerts:    map[string]bool{},
+		timeout:   envDuration("SAFE_MODE_TIMEOUT", 5*time.Hour),
+		maxAge:    envDuration("SAFE_MODE_MAX_AGE", 15*time.Minute),
+		queueSize: envInt("SAFE_MODE_QUEUE_SIZE", 100),
+		active:    map[string]time.Time{},
+		queue:     map[string]queuedJob{},
+	}
+	for _, n := range names {
+		s.alerts[n] = true
+	}
+	return s
+}
+
+func init() {
+	registerMetrics(safeMode.writeMetrics)
+}
+
+// startSafeMode expires trigger alerts that stopped arriving
+func startSafeMode() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			safeMode.update()
+		}
+	}()
+}
+
+// alertIdentity identifies an alert across firing and resolved notifications
+func alertIdentity(alert Alert) string {
+	keys := sortedKeys(alert.Labels)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+alert.Labels[k])
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s *safeModeState) isTrigger(alert Alert) bool {
+	return s.alerts[alert.Labels["alertname"]] || alert.Labels["safe_mode"] == "true"
+}
+
+// observe tracks trigger alerts and drops queued actions whose alert resolved
+func (s *safeModeState) observe(alert Alert) {
+	id := alertIdentity(alert)
+	if !s.isTrigger(alert) {
+		if alert.Status == "resolved" {
+			s.mu.Lock()
+			for key, q := range s.queue {
+				if q.alertID == id {
+					delete(s.queue, key)
+				}
+			}
+			s.mu.Unlock()
+		}
+		return
+	}
+
+	s.mu.Lock()
+	if alert.Status == "firing" {
+		s.active[id] = time.Now()
+	} else {
+		delete(s.active, id)
+	}
+	s.mu.Unlock()
+	s.update()
+}
+
+// update enters or leaves safe mode to match the trigger alerts
+func (s *safeModeState) update() {
+	s.mu.Lock()
+	for id, seen := range s.active {
+		if time.Since(seen) > s.timeout {
+			delete(s.active, id)
+		}
+	}
+	var release []queuedJob
+	switch {
+	case len(s.active) > 0 && s.since.IsZero():
+		s.since = time.Now()
+		s.entered++
+		triggers := s.triggersLocked()
+		s.mu.Unlock()
+		log.Printf("Entering safe mode — control plane degraded (%s); holding actions", strings.Join(triggers, ", "))
+		notifySlack(renderMessage("safe-mode-entered", "", map[string]interface{}{"Triggers": triggers}))
+		return
+	case len(s.active) == 0 && !s.since.IsZero():
+		held := time.Since(s.since)
+		s.since = time.Time{}
+		for _, q := range s.queue {
+			if time.Since(q.queuedAt) <= s.maxAge {
+				release = append(release, q)
+			} else {
+				s.dropped++
+			}
+		}
+		s.queue = map[string]queuedJob{}
+		s.released += len(release)
+		s.mu.Unlock()
+		log.Printf("Leaving safe mode after %s — re-checking %d held action(s)", held.Round(time.Second), len(release))
+		notifySlack(renderMessage("safe-mode-left", "", map[string]interface{}{"Held": held.Round(time.Second), "Released": len(release)}))
+		go s.replay(release)
+		return
+	}
+	s.mu.Unlock()
+}
+
+func (s *safeModeState) triggersLocked() []string {
+	names := map[string]bool{}
+	for id := range s.active {
+		for _, kv := range strings.Split(id, ",") {
+			if v, ok := strings.CutPrefix(kv, "alertname="); ok {
+				names[v] = true
+			}
+		}
+	}
+	return sortedKeys(names)
+}
+
+// replay sends held actions through the decision checks again
+func (s *safeModeState) replay(held []queuedJob) {
+	sort.Slice(held, func(i, j int) bool { return held[i].queuedAt.Before(held[j].queuedAt) })
+	jobs := map[string][]workloadJob{}
+	for _, q := range held {
+		trace := decisionTrace{{Check: "safe-mode", Result: traceInfo, Detail: "held since " + q.queuedAt.Format(time.RFC3339) + ", decided again on replay"}}
+		action := decideAlert(operatorCtx, q.job.alert, false, &trace)
+		if action == nil {
+			continue
+		}
+		action.Explain = trace
+		key := workloadKey(action)
+		jobs[key] = append(jobs[key], workloadJob{action: action, alert: q.job.alert})
+	}
+	runPerWorkload(operatorCtx, jobs)
+}
+
+// isActive reports whether safe mode is on
+func (s *safeModeState) isActive() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.since.IsZero()
+}
+
+// hold queues the job if safe mode is on and the action changes the cluster
+func (s *safeModeState) hold(key string, j workloadJob) bool {
+	if j.action.Action == "notify" {
+		return false
+	}
+	s.update()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.since.IsZero() {
+		return false
+	}
+	if _, ok := s.queue[key]; !ok && len(s.queue) >= s.queueSize {
+		s.dropped++
+		log.Printf("Dropping '%s' for %s — safe mode queue is full", j.action.Action, key)
+		return true
+	}
+	s.queue[key] = queuedJob{job: j, alertID: alertIdentity(j.alert), queuedAt: time.Now()}
+	log.Printf("Holding '%s' for %s — safe mode", j.action.Action, key)
+	return true
+}
+
+// heldSince returns when each held action was queued
+func (s *safeModeState) heldSince() []time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]time.Time, 0, len(s.queue))
+	for _, q := range s.queue {
+		out = append(out, q.queuedAt)
+	}
+	return out
+}
+
+func (s *safeModeState) writeMetrics(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	active := 0
+	if !s.since.IsZero() {
+		active = 1
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_safe_mode Whether the operator is holding actions because the control plane is degraded.")
+	fmt.Fprintln(w, "# TYPE selfhealing_safe_mode gauge")
+	fmt.Fprintf(w, "selfhealing_safe_mode %d\n", active)
+	fmt.Fprintln(w, "# HELP selfhealing_safe_mode_queued Actions held until safe mode ends.")
+	fmt.Fprintln(w, "# TYPE selfhealing_safe_mode_queued gauge")
+	fmt.Fprintf(w, "selfhealing_safe_mode_queued %d\n", len(s.queue))
+	fmt.Fprintln(w, "# HELP selfhealing_safe_mode_entered_total Times safe mode started.")
+	fmt.Fprintln(w, "# TYPE selfhealing_safe_mode_entered_total counter")
+	fmt.Fprintf(w, "selfhealing_safe_mode_entered_total %d\n", s.entered)
+	fmt.Fprintln(w, "# HELP selfhealing_safe_mode_released_total Held actions re-checked when safe mode ended.")
+	fmt.Fprintln(w, "# TYPE selfhealing_safe_mode_released_total counter")
+	fmt.Fprintf(w, "selfhealing_safe_mode_released_total %d\n", s.released)
+	fmt.Fprintln(w, "# HELP selfhealing_safe_mode_dropped_total Held actions dropped (queue full or too old).")
+	fmt.Fprintln(w, "# TYPE selfhealing_safe_mode_dropped_total counter")
+	fmt.Fprintf(w, "selfhealing_safe_mode_dropped_total %d\n", s.dropped)
+}
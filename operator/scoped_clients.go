@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Scoped clients: instead of acting with the operator's own (cluster-wide)
+// ServiceAccount, mint a short-lived bound token for a per-namespace
+// ServiceAccount and build clients from it. That ServiceAccount only has a
+// Role in its own namespace, so a leaked token can't touch anything else.
+//
+// Enabled with SCOPED_CLIENTS=true. Each target namespace needs a
+// ServiceAccount named SCOPED_SA_NAME bound to a remediation Role
+// (see manifests/operator/scoped-rbac.yaml).
+
+// ScopedClients is the set of clients built from one namespace's token
+type ScopedClients struct {
+	Namespace string
+	Typed     kubernetes.Interface
+	Dynamic   dynamic.Interface
+	expiresAt time.Time
+}
+
+type scopedClientFactory struct {
+	kube       kubernetes.Interface // the operator's own client, for TokenRequests
+	baseConfig *rest.Config
+	saName     string
+	audience   string
+	ttl        time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*ScopedClients // key = namespace
+}
+
+// tokens are refreshed this long before they actually expire
+const scopedTokenRefreshSkew = time.Minute
+
+func newScopedClientFactory(kube kubernetes.Interface, base *rest.Config) *scopedClientFactory {
+	ttl := 10 * time.Minute
+	if v := os.Getenv("SCOPED_TOKEN_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 10*time.Minute {
+			ttl = d
+		} else {
+			log.Printf("Ignoring SCOPED_TOKEN_TTL=%q (must be a duration >= 10m)", v)
+		}
+	}
+
+	saName := os.Getenv("SCOPED_SA_NAME")
+	if saName == "" {
+		saName = "self-healing-remediator"
+	}
+
+	return &scopedClientFactory{
+		kube:       kube,
+		baseConfig: base,
+		saName:     saName,
+		audience:   os.Getenv("SCOPED_TOKEN_AUDIENCE"),
+		ttl:        ttl,
+		clients:    map[string]*ScopedClients{},
+	}
+}
+
+// forNamespace returns clients authenticated as the remediation ServiceAccount
+// of the given namespace, minting a new bound token when the cached one is
+// about to expire.
+func (f *scopedClientFactory) forNamespace(ctx context.Context, namespace string) (*ScopedClients, error) {
+	if c := f.cached(namespace); c != nil {
+		return c, nil
+	}
+
+	expSeconds := int64(f.ttl.Seconds())
+	req := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			ExpirationSeconds: &expSeconds,
+		},
+	}
+	if f.audience != "" {
+		req.Spec.Audiences = []string{f.audience}
+	}
+
+	// The token request itself is made with the operator's own identity
+	tr, err := f.kube.CoreV1().ServiceAccounts(namespace).CreateToken(ctx, f.saName, req, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to request token for %s/%s: %v", namespace, f.saName, err)
+	}
+
+	cfg := rest.AnonymousClientConfig(f.baseConfig)
+	cfg.BearerToken = tr.Status.Token
+
+	typed, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build scoped client for %s: %v", namespace, err)
+	}
+	dyn, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build scoped dynamic client for %s: %v", namespace, err)
+	}
+
+	c := &ScopedClients{
+		Namespace: namespace,
+		Typed:     typed,
+		Dynamic:   dyn,
+		expiresAt: tr.Status.ExpirationTimestamp.Time,
+	}
+
+	// The lock isn't held during the token request, so another caller may
+	// have minted one for the namespace meanwhile; keep whichever lasts longer
+	f.mu.Lock()
+	if cur, ok := f.clients[namespace]; ok && cur.expiresAt.After(c.expiresAt) {
+		f.mu.Unlock()
+		return cur, nil
+	}
+	f.clients[namespace] = c
+	f.mu.Unlock()
+	log.Printf("Minted scoped token for %s/%s (expires %s)", namespace, f.saName, c.expiresAt.Format(time.RFC3339))
+	return c, nil
+}
+
+// cached returns the namespace's clients if their token is still fresh
+func (f *scopedClientFactory) cached(namespace string) *ScopedClients {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok := f.clients[namespace]; ok && time.Until(c.expiresAt) > scopedTokenRefreshSkew {
+		return c
+	}
+	return nil
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Secret backends for integration credentials. A credential setting (see
+// credentialSettings) can hold the credential itself, as before, or a
+// reference to where it is kept:
+//
+//	SLACK_WEBHOOK_URL=k8s:monitoring/self-healing-integrations#slack-webhook
+//	JIRA_API_TOKEN=vault:secret/data/self-healing/jira#token
+//	GITHUB_TOKEN=aws-sm:prod/self-healing/github#token
+//
+//   - k8s:[namespace/]name#key reads a key of a Kubernetes Secret (namespace
+//     defaults to the operator's, POD_NAMESPACE)
+//   - vault:path#field reads a field of a Vault secret at /v1/<path> (KV v1
+//     or v2), with VAULT_TOKEN or, with VAULT_K8S_ROLE, Vault's Kubernetes
+//     auth as the operator's ServiceAccount
+//   - aws-sm:secret-id[#key] reads an AWS Secrets Manager secret, the whole
+//     SecretString or one key of it as JSON, with the AWS_* credentials from
+//     the environment or IRSA (AWS_ROLE_ARN + AWS_WEB_IDENTITY_TOKEN_FILE)
+//
+// References are resolved at startup (a missing secret stops the operator)
+// and every SECRET_REFRESH after that, so a rotated credential is picked up
+// without a restart: integrations read their credential each time they use
+// it. A failed refresh keeps the last value.
+
+var (
+	secretRefresh = envDuration("SECRET_REFRESH", 5*time.Minute)
+
+	// credentialSettings are the settings read through the secret backends
+	credentialSettings = []string{
+		"SLACK_WEBHOOK_URL", "JIRA_API_TOKEN", "GITHUB_TOKEN", "GITLAB_TOKEN",
+		"OPSGENIE_API_KEY", "SPLUNK_ONCALL_URL", "GRAFANA_API_TOKEN",
+		"HONEYCOMB_API_KEY", "ELASTIC_APM_API_KEY", "CALLBACK_SECRET",
+		"AZURE_WEBHOOK_TOKEN", "GCP_WEBHOOK_TOKEN", "SLACK_SIGNING_SECRET",
+		"INTERRUPTION_WEBHOOK_TOKEN",
+	}
+
+	secretProviders = map[string]secretProvider{
+		"k8s":    kubeSecrets{},
+		"vault":  &vaultSecrets{},
+		"aws-sm": &awsSecrets{},
+	}
+
+	secretKube kubernetes.Interface
+
+	secretMu      sync.Mutex
+	secretCache   = map[string]string{} // setting -> resolved value
+	secretFetches = map[string]int{}    // provider|result
+	secretRotated = map[string]int{}    // setting
+)
+
+// credential names a credential setting; value resolves it when it's used
+type credential string
+
+func (c credential) value() string {
+	return secretValue(string(c))
+}
+
+// secretRef is a parsed reference: provider:path#key
+type secretRef struct {
+	provider, path, key string
+}
+
+// secretProvider fetches a referenced secret
+type secretProvider interface {
+	fetch(ctx context.Context, ref secretRef) (string, error)
+}
+
+func init() {
+	registerMetrics(writeSecretMetrics)
+}
+
+// parseSecretRef returns the reference a setting holds, if it holds one
+func parseSecretRef(v string) (secretRef, bool, error) {
+	scheme, rest, ok := strings.Cut(v, ":")
+	if !ok || secretProviders[scheme] == nil || strings.HasPrefix(rest, "//") {
+		// plain values (including URLs) aren't references
+		return secretRef{}, false, nil
+	}
+	path, key, _ := strings.Cut(rest, "#")
+	if path == "" || key == "" && scheme != "aws-sm" {
+		return secretRef{}, true, fmt.Errorf("want %s:path#key", scheme)
+	}
+	return secretRef{provider: scheme, path: path, key: key}, true, nil
+}
+
+// secretValue returns a credential setting's current value
+func secretValue(setting string) string {
+	raw := os.Getenv(setting)
+	ref, isRef, err := parseSecretRef(raw)
+	if !isRef {
+		return raw
+	}
+	secretMu.Lock()
+	v, ok := secretCache[setting]
+	secretMu.Unlock()
+	if ok || err != nil {
+		return v
+	}
+	// not resolved at startup (e.g. --validate-config): fetch it now
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	v, err = fetchSecret(ctx, setting, ref)
+	if err != nil {
+		log.Printf("Failed to resolve %s — %v", setting, err)
+	}
+	return v
+}
+
+func fetchSecret(ctx context.Context, setting string, ref secretRef) (string, error) {
+	v, err := secretProviders[ref.provider].fetch(ctx, ref)
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	secretMu.Lock()
+	defer secretMu.Unlock()
+	secretFetches[ref.provider+"|"+result]++
+	if err != nil {
+		return secretCache[setting], fmt.Errorf("%s %s: %v", ref.provider, ref.path, err)
+	}
+	if old, ok := secretCache[setting]; ok && old != v {
+		secretRotated[setting]++
+		log.Printf("Credential %s rotated — using the new value from %s", setting, ref.provider)
+	}
+	secretCache[setting] = v
+	return v, nil
+}
+
+// resolveSecrets fetches every referenced credential
+func resolveSecrets(ctx context.Context) error {
+	var failed []string
+	for _, setting := range credentialSettings {
+		ref, isRef, err := parseSecretRef(os.Getenv(setting))
+		if !isRef {
+			continue
+		}
+		if err == nil {
+			_, err = fetchSecret(ctx, setting, ref)
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", setting, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// setupSecrets resolves the referenced credentials and keeps them fresh
+func setupSecrets(kube kubernetes.Interface) error {
+	secretKube = kube
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	err := resolveSecrets(ctx)
+	cancel()
+	if err != nil {
+		return err
+	}
+	secretMu.Lock()
+	n := len(secretCache)
+	secretMu.Unlock()
+	if n == 0 || secretRefresh <= 0 {
+		return nil
+	}
+	log.Printf("Secret backends enabled — %d credential(s) from references, refreshed every %s", n, secretRefresh)
+	go func() {
+		ticker := time.NewTicker(secretRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-operatorCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			ctx, cancel := context.WithTimeout(operatorCtx, 30*time.Second)
+			if err := resolveSecrets(ctx); err != nil {
+				log.Printf("Keeping the previous credentials — %v", err)
+			}
+			cancel()
+		}
+	}()
+	return nil
+}
+
+// kubeSecrets reads keys of Kubernetes Secrets
+type kubeSecrets struct{}
+
+func (kubeSecrets) fetch(ctx context.Context, ref secretRef) (string, error) {
+	if secretKube == nil {
+		return "", fmt.Errorf("no Kubernetes client")
+	}
+	namespace, name, ok := strings.Cut(ref.path, "/")
+	if !ok {
+		namespace, name = envString("POD_NAMESPACE", "default"), ref.path
+	}
+	s, err := secretKube.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	v, ok := s.Data[ref.key]
+	if !ok {
+		return "", fmt.Errorf("secret %s/%s has no key %s", namespace, name, ref.key)
+	}
+	return string(v), nil
+}
+
+// vaultSecrets reads Vault secrets, logging in with Kubernetes auth if there's no VAULT_TOKEN
+type vaultSecrets struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time // zero for VAULT_TOKEN
+}
+
+const serviceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+func (v *vaultSecrets) authToken(ctx context.Context, addr string) (string, error) {
+	if t := os.Getenv("VAULT_TOKEN"); t != "" {
+		return t, nil
+	}
+	role := os.Getenv("VAULT_K8S_ROLE")
+	if role == "" {
+		return "", fmt.Errorf("VAULT_TOKEN or VAULT_K8S_ROLE is required")
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.token != "" && time.Now().Before(v.expires) {
+		return v.token, nil
+	}
+	jwt, err := os.ReadFile(serviceAccountTokenFile)
+	if err != nil {
+		return "", err
+	}
+	body := map[string]string{"role": role, "jwt": strings.TrimSpace(string(jwt))}
+	var out struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	u := addr + "/v1/auth/" + envString("VAULT_K8S_AUTH_MOUNT", "kubernetes") + "/login"
+	if err := vaultCall(ctx, http.MethodPost, u, "", body, &out); err != nil {
+		return "", fmt.Errorf("vault login: %v", err)
+	}
+	// log in again at half the lease, well before the token expires
+	v.token = out.Auth.ClientToken
+	v.expires = time.Now().Add(time.Duration(out.Auth.LeaseDuration) * time.Second / 2)
+	return v.token, nil
+}
+
+func (v *vaultSecrets) fetch(ctx context.Context, ref secretRef) (string, error) {
+	addr := strings.TrimRight(os.Getenv("VAULT_ADDR"), "/")
+	if addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR is required")
+	}
+	token, err := v.authToken(ctx, addr)
+	if err != nil {
+		return "", err
+	}
+	var out struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := vaultCall(ctx, http.MethodGet, addr+"/v1/"+strings.TrimLeft(ref.path, "/"), token, nil, &out); err != nil {
+		return "", err
+	}
+	data := out.Data
+	// KV v2 nests the secret under data.data, next to its metadata
+	if inner, ok := data["data"].(map[string]interface{}); ok && data["metadata"] != nil {
+		data = inner
+	}
+	value, ok := data[ref.key]
+	if !ok || value == nil {
+		return "", fmt.Errorf("no field %s", ref.key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+func vaultCall(ctx context.Context, method, u, token string, body, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = strings.NewReader(string(data))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	resp, err := egressClient("vault").Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault returned %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func writeSecretMetrics(w io.Writer) {
+	secretMu.Lock()
+	defer secretMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_secret_fetches_total Credential fetches from secret backends, by provider and result (ok, error).")
+	fmt.Fprintln(w, "# TYPE selfhealing_secret_fetches_total counter")
+	for _, key := range sortedKeys(secretFetches) {
+		provider, result, _ := strings.Cut(key, "|")
+		fmt.Fprintf(w, "selfhealing_secret_fetches_total{provider=%q,result=%q} %d\n", provider, result, secretFetches[key])
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_secret_rotations_total Credentials whose value changed on a refresh, by setting.")
+	fmt.Fprintln(w, "# TYPE selfhealing_secret_rotations_total counter")
+	for _, setting := range sortedKeys(secretRotated) {
+		fmt.Fprintf(w, "selfhealing_secret_rotations_total{setting=%q} %d\n", setting, secretRotated[setting])
+	}
+}
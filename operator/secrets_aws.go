@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AWS Secrets Manager, for aws-sm: references (see secrets.go). Requests are
+// signed with Signature Version 4 by hand rather than pulling in the AWS SDK
+// for one API call. Credentials come from AWS_ACCESS_KEY_ID /
+// AWS_SECRET_ACCESS_KEY (/ AWS_SESSION_TOKEN), or from IRSA: with
+// AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE set (EKS sets both for a
+// ServiceAccount annotated with eks.amazonaws.com/role-arn) the operator
+// assumes the role with STS and renews the credentials before they expire.
+// The region is AWS_REGION, AWS_DEFAULT_REGION, or the one in an ARN secret id.
+
+// awsCredentials are signing credentials
+type awsCredentials struct {
+	accessKey, secretKey, sessionToken string
+	expires                            time.Time // zero for static credentials
+}
+
+// awsSecrets reads secrets from AWS Secrets Manager
+type awsSecrets struct {
+	mu    sync.Mutex
+	creds awsCredentials
+}
+
+func awsRegion(secretID string) string {
+	if r := envString("AWS_REGION", os.Getenv("AWS_DEFAULT_REGION")); r != "" {
+		return r
+	}
+	// arn:aws:secretsmanager:<region>:<account>:secret:<name>
+	if parts := strings.Split(secretID, ":"); len(parts) > 3 && parts[0] == "arn" {
+		return parts[3]
+	}
+	return ""
+}
+
+func (a *awsSecrets) credentials(ctx context.Context, region string) (awsCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return awsCredentials{accessKey: id, secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"), sessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	role, tokenFile := os.Getenv("AWS_ROLE_ARN"), os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	if role == "" || tokenFile == "" {
+		return awsCredentials{}, fmt.Errorf("AWS_ACCESS_KEY_ID, or AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE, are required")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.creds.accessKey != "" && time.Now().Before(a.creds.expires.Add(-5*time.Minute)) {
+		return a.creds, nil
+	}
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	creds, err := assumeRoleWithWebIdentity(ctx, region, role, strings.TrimSpace(string(token)))
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("failed to assume %s: %v", role, err)
+	}
+	a.creds = creds
+	return creds, nil
+}
+
+// assumeRoleWithWebIdentity trades the ServiceAccount token for role credentials (unsigned)
+func assumeRoleWithWebIdentity(ctx context.Context, region, role, token string) (awsCredentials, error) {
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {role},
+		"RoleSessionName":  {"self-healing-operator"},
+		"WebIdentityToken": {token},
+	}
+	endpoint := "https://sts.amazonaws.com/"
+	if region != "" {
+		endpoint = "https://sts." + region + ".amazonaws.com/"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := egressClient("aws").Do(req)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return awsCredentials{}, fmt.Errorf("STS returned %s", resp.Status)
+	}
+	var out struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return awsCredentials{}, fmt.Errorf("failed to decode STS response: %v", err)
+	}
+	c := out.Credentials
+	return awsCredentials{accessKey: c.AccessKeyID, secretKey: c.SecretAccessKey, sessionToken: c.SessionToken, expires: c.Expiration}, nil
+}
+
+func (a *awsSecrets) fetch(ctx context.Context, ref secretRef) (string, error) {
+	region := awsRegion(ref.path)
+	if region == "" {
+		return "", fmt.Errorf("AWS_REGION is required")
+	}
+	creds, err := a.credentials(ctx, region)
+	if err != nil {
+		return "", err
+	}
+	body, _ := json.Marshal(map[string]string{"SecretId": ref.path})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://secretsmanager."+region+".amazonaws.com/", strings.NewReader(string(body)))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	signAWSRequest(req, body, creds, region, "secretsmanager", time.Now())
+	resp, err := egressClient("aws").Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&e)
+		return "", fmt.Errorf("Secrets Manager returned %s %s %s", resp.Status, e.Type, e.Message)
+	}
+	var out struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	if ref.key == "" {
+		return out.SecretString, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(out.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("secret isn't a JSON object, so it has no key %s", ref.key)
+	}
+	value, ok := fields[ref.key]
+	if !ok || value == nil {
+		return "", fmt.Errorf("no key %s", ref.key)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// signAWSRequest adds a Signature Version 4 Authorization header
+func signAWSRequest(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, req.URL.Query().Encode(), canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+creds.secretKey), date)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", creds.accessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Self-healing checks: a SelfHealingCheck
+// (manifests/operator/crd-selfhealingcheck.yaml) proves the healing loop
+// works, end to end and on a schedule. With SELF_HEALING_CHECKS=true the
+// operator runs each check in its namespace:
+//
+//	apiVersion: selfhealing.io/v1alpha1
+//	kind: SelfHealingCheck
+//	metadata:
+//	  name: crashloop
+//	  namespace: selfhealing-canary
+//	spec:
+//	  failure: crash      # or unready
+//	  action: restart     # the recovery_action the alert asks for
+//	  deadline: 5m
+//	  interval: 1h        # run again every hour; without it, once per spec change
+//
+// A run
+//   - deploys a disposable canary Deployment, selfhealing-canary-<check>,
+//     owned by the check (CHECK_CANARY_IMAGE, a shell loop)
+//   - breaks it once it's ready: its pods are annotated
+//     selfhealing.io/canary-break, which they read through the downward API
+//     (the kubelet takes up to a minute to pass it on) and then crash or
+//     stop being ready
+//   - once a pod is seen failing, delivers the alert a rule would send for it
+//     (alert: synthetic, the default) through the normal decision pipeline;
+//     with alert: prometheus it waits for the cluster's own rules to notice
+//   - passes when a remediation of the canary succeeded and the canary is
+//     ready again without broken pods within deadline of the failure, and
+//     fails otherwise, with what the operator decided about the alert
+//   - resolves the alert and deletes the canary
+//
+// The run's progress and result are in the check's status (phase, result,
+// message, healedAfter, the remediation's history id), exported as
+// selfhealing_check_runs_total and selfhealing_check_passing, and a failed
+// run is posted to Slack. The canary opts in with selfhealing.io/enabled, its
+// cooldown is cleared when it breaks, and everything else — action flags,
+// safe mode, MODE=recommend, observation — applies to it like to any
+// workload, so a check fails when they'd hold a real remediation back.
+
+const (
+	checkBreakAnnotation = "selfhealing.io/canary-break"
+	checkLabel           = "selfhealing.io/check"
+
+	checkPhaseDeploying = "Deploying"
+	checkPhaseBreaking  = "Breaking"
+	checkPhaseHealing   = "Healing"
+	checkPassed         = "Passed"
+	checkFailed         = "Failed"
+)
+
+var (
+	checksEnabled       = envBool("SELF_HEALING_CHECKS", false)
+	checksSync          = envDuration("CHECKS_SYNC", 10*time.Second)
+	checkCanaryImage    = envString("CHECK_CANARY_IMAGE", "busybox:1.36")
+	selfHealingCheckGVR = schema.GroupVersionResource{Group: policyGroup, Version: "v1alpha1", Resource: "selfhealingchecks"}
+
+	checksMu      sync.Mutex
+	checkRuns     = map[string]int{}  // namespace/name|result
+	checkPassing  = map[string]bool{} // namespace/name -> last result
+	checkHealTime = map[string]float64{}
+)
+
+// canaryScript breaks the way its pod's break annotation says
+const canaryScript = `touch /tmp/ready
+while true; do
+  case "$(cat /etc/canary/break 2>/dev/null)" in
+    crash) echo "canary broken: crashing"; exit 1 ;;
+    unready) rm -f /tmp/ready ;;
+  esac
+  sleep 1
+done`
+
+// SelfHealingCheckSpec is the spec of a SelfHealingCheck
+type SelfHealingCheckSpec struct {
+	Failure   string `json:"failure,omitempty"`   // crash (default) or unready
+	Alert     string `json:"alert,omitempty"`     // synthetic (default) or prometheus
+	AlertName string `json:"alertName,omitempty"` // of the synthetic alert
+	Action    string `json:"action,omitempty"`    // recovery_action of the synthetic alert
+	Runbook   string `json:"runbook,omitempty"`   // with action: runbook
+	Deadline  string `json:"deadline,omitempty"`
+	Interval  string `json:"interval,omitempty"`
+	Image     string `json:"image,omitempty"`
+}
+
+// SelfHealingCheckStatus is the status of a SelfHealingCheck
+type SelfHealingCheckStatus struct {
+	Phase              string            `json:"phase,omitempty"`
+	Result             string            `json:"result,omitempty"` // of the last finished run
+	Message            string            `json:"message,omitempty"`
+	StartedAt          string            `json:"startedAt,omitempty"`
+	BrokenAt           string            `json:"brokenAt,omitempty"`
+	FailedAt           string            `json:"failedAt,omitempty"` // the canary was seen failing
+	FinishedAt         string            `json:"finishedAt,omitempty"`
+	HealedAfter        string            `json:"healedAfter,omitempty"`
+	Decision           string            `json:"decision,omitempty"` // what the operator decided about the alert
+	Remediation        *checkRemediation `json:"remediation,omitempty"`
+	Passes             int               `json:"passes,omitempty"`
+	Failures           int               `json:"failures,omitempty"`
+	ObservedGeneration int64             `json:"observedGeneration,omitempty"`
+}
+
+type checkRemediation struct {
+	HistoryID int    `json:"historyId"`
+	Action    string `json:"action"`
+	Outcome   string `json:"outcome"`
+	Error     string `json:"error,omitempty"`
+}
+
+func init() {
+	registerMetrics(writeCheckMetrics)
+}
+
+// checkSettings are a spec with its defaults filled in and its durations parsed
+type checkSettings struct {
+	SelfHealingCheckSpec
+	deadline, interval time.Duration
+}
+
+func parseCheckSpec(spec SelfHealingCheckSpec) (checkSettings, error) {
+	s := checkSettings{SelfHealingCheckSpec: spec, deadline: 5 * time.Minute}
+	if s.Failure == "" {
+		s.Failure = "crash"
+	}
+	if s.Alert == "" {
+		s.Alert = "synthetic"
+	}
+	if s.AlertName == "" {
+		s.AlertName = "SelfHealingCheckFailing"
+	}
+	if s.Action == "" {
+		s.Action = "restart"
+	}
+	if s.Image == "" {
+		s.Image = checkCanaryImage
+	}
+	if s.Failure != "crash" && s.Failure != "unready" {
+		return s, fmt.Errorf("failure must be crash or unready, not %q", s.Failure)
+	}
+	if s.Alert != "synthetic" && s.Alert != "prometheus" {
+		return s, fmt.Errorf("alert must be synthetic or prometheus, not %q", s.Alert)
+	}
+	if !containsString(recoveryActionNames, s.Action) {
+		return s, fmt.Errorf("unknown action %q", s.Action)
+	}
+	if s.Action == "runbook" {
+		if _, ok := runbooks[s.Runbook]; !ok {
+			return s, fmt.Errorf("action runbook needs a known runbook, not %q", s.Runbook)
+		}
+	}
+	var err error
+	if spec.Deadline != "" {
+		if s.deadline, err = time.ParseDuration(spec.Deadline); err != nil || s.deadline <= 0 {
+			return s, fmt.Errorf("invalid deadline %q", spec.Deadline)
+		}
+	}
+	if spec.Interval != "" {
+		if s.interval, err = time.ParseDuration(spec.Interval); err != nil || s.interval < time.Minute {
+			return s, fmt.Errorf("invalid interval %q (at least 1m)", spec.Interval)
+		}
+	}
+	return s, nil
+}
+
+// canaryName is the canary Deployment (and its app label) of a check
+func canaryName(check string) string {
+	name := "selfhealing-canary-" + check
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return name
+}
+
+// canaryDeployment builds the canary of a check
+func canaryDeployment(check *unstructured.Unstructured, s checkSettings) *appsv1.Deployment {
+	name := canaryName(check.GetName())
+	labels := map[string]string{"app": name, checkLabel: check.GetName(), managedByLabel: managedByValue}
+	replicas := int32(1)
+	controller := true
+	nonRoot, noEscalation := true, false
+	user := int64(65534)
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   check.GetNamespace(),
+			Labels:      labels,
+			Annotations: map[string]string{annoEnabled: "true"},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: check.GetAPIVersion(),
+				Kind:       check.GetKind(),
+				Name:       check.GetName(),
+				UID:        check.GetUID(),
+				Controller: &controller,
+			}},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": name, checkLabel: check.GetName()}},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					TerminationGracePeriodSeconds: new(int64),
+					SecurityContext:               &corev1.PodSecurityContext{RunAsNonRoot: &nonRoot, RunAsUser: &user},
+					Containers: []corev1.Container{{
+						Name:    "canary",
+						Image:   s.Image,
+						Command: []string{"sh", "-c", canaryScript},
+						ReadinessProbe: &corev1.Probe{
+							ProbeHandler:  corev1.ProbeHandler{Exec: &corev1.ExecAction{Command: []string{"test", "-f", "/tmp/ready"}}},
+							PeriodSeconds: 2,
+						},
+						SecurityContext: &corev1.SecurityContext{
+							AllowPrivilegeEscalation: &noEscalation,
+							Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+						},
+						VolumeMounts: []corev1.VolumeMount{{Name: "break", MountPath: "/etc/canary"}},
+					}},
+					Volumes: []corev1.Volume{{
+						Name: "break",
+						VolumeSource: corev1.VolumeSource{DownwardAPI: &corev1.DownwardAPIVolumeSource{
+							Items: []corev1.DownwardAPIVolumeFile{{
+								Path:     "break",
+								FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.annotations['" + checkBreakAnnotation + "']"},
+							}},
+						}},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// checkAlert is the alert a rule would send about the broken canary
+func checkAlert(check *unstructured.Unstructured, s checkSettings, status SelfHealingCheckStatus, pod string) Alert {
+	failedAt := checkTime(status.FailedAt)
+	labels := map[string]string{
+		"alertname":         s.AlertName,
+		"namespace":         check.GetNamespace(),
+		"app":               canaryName(check.GetName()),
+		"pod":               pod,
+		"severity":          "critical",
+		"recovery_action":   s.Action,
+		"selfhealing_check": check.GetName(),
+	}
+	if s.Runbook != "" {
+		labels["runbook"] = s.Runbook
+	}
+	return Alert{
+		Labels:      labels,
+		Annotations: map[string]string{"summary": fmt.Sprintf("SelfHealingCheck %s/%s broke its canary (%s)", check.GetNamespace(), check.GetName(), s.Failure)},
+		Status:      "firing",
+		StartsAt:    failedAt,
+		Fingerprint: fmt.Sprintf("selfhealing-check|%s/%s|%d", check.GetNamespace(), check.GetName(), failedAt.Unix()),
+	}
+}
+
+func checkTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339, s)
+	return t
+}
+
+// checkDue reports whether a finished (or never run) check should run again
+func checkDue(check *unstructured.Unstructured, s checkSettings, status SelfHealingCheckStatus, now time.Time) bool {
+	if status.FinishedAt == "" || status.ObservedGeneration != check.GetGeneration() {
+		return true
+	}
+	return s.interval > 0 && !now.Before(checkTime(status.FinishedAt).Add(s.interval))
+}
+
+// canaryPods lists the pods of a check's canary that aren't going away
+func canaryPods(ctx context.Context, kube kubernetes.Interface, check *unstructured.Unstructured) ([]corev1.Pod, error) {
+	list, err := kube.CoreV1().Pods(check.GetNamespace()).List(ctx, metav1.ListOptions{LabelSelector: checkLabel + "=" + check.GetName()})
+	if err != nil {
+		return nil, err
+	}
+	var out []corev1.Pod
+	for _, p := range list.Items {
+		if p.DeletionTimestamp == nil {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+// checkRemediationSince finds the latest remediation of the canary since the time
+func checkRemediationSince(check *unstructured.Unstructured, since time.Time) *checkRemediation {
+	app := canaryName(check.GetName())
+	for _, rec := range listHistory(0) {
+		if rec.Namespace == check.GetNamespace() && rec.App == app && !rec.StartedAt.Before(since) {
+			return &checkRemediation{HistoryID: rec.ID, Action: rec.Action, Outcome: rec.Outcome, Error: rec.Error}
+		}
+	}
+	return nil
+}
+
+// checkDecision is what the operator last decided about an alert for the canary since the time
+func checkDecision(check *unstructured.Unstructured, since time.Time) string {
+	app := canaryName(check.GetName())
+	alertArchiveMu.Lock()
+	defer alertArchiveMu.Unlock()
+	for i := len(alertArchive) - 1; i >= 0; i-- {
+		a := alertArchive[i]
+		if a.ReceivedAt.Before(since) {
+			break
+		}
+		if a.Alert.Labels["namespace"] == check.GetNamespace() && a.Alert.Labels["app"] == app {
+			return a.Decision
+		}
+	}
+	return ""
+}
+
+// reconcileCheck moves a check's run one step on; it returns the new status
+func reconcileCheck(ctx context.Context, kube kubernetes.Interface, check *unstructured.Unstructured, now time.Time) SelfHealingCheckStatus {
+	var spec SelfHealingCheckSpec
+	var status SelfHealingCheckStatus
+	data, _ := json.Marshal(check.Object["spec"])
+	json.Unmarshal(data, &spec)
+	data, _ = json.Marshal(check.Object["status"])
+	json.Unmarshal(data, &status)
+	key := check.GetNamespace() + "/" + check.GetName()
+
+	s, err := parseCheckSpec(spec)
+	if err != nil {
+		if status.ObservedGeneration != check.GetGeneration() || status.Phase != checkFailed {
+			status.Message = "invalid spec: " + err.Error()
+			status.ObservedGeneration = check.GetGeneration()
+			finishCheck(ctx, kube, check, s, &status, checkFailed, now)
+		}
+		return status
+	}
+	deployments := kube.AppsV1().Deployments(check.GetNamespace())
+
+	switch status.Phase {
+	case checkPhaseDeploying:
+		dep, err := deployments.Get(ctx, canaryName(check.GetName()), metav1.GetOptions{})
+		switch {
+		case err == nil && dep.Status.ReadyReplicas > 0 && dep.Status.ObservedGeneration >= dep.Generation:
+			pods, err := canaryPods(ctx, kube, check)
+			if err != nil {
+				status.Message = "failed to list canary pods: " + err.Error()
+				return status
+			}
+			patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`, checkBreakAnnotation, s.Failure)
+			for _, p := range pods {
+				if _, err := kube.CoreV1().Pods(p.Namespace).Patch(ctx, p.Name, types.MergePatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+					status.Message = "failed to break the canary: " + err.Error()
+					return status
+				}
+			}
+			log.Printf("SelfHealingCheck %s: canary ready, breaking it (%s)", key, s.Failure)
+			status.Phase, status.BrokenAt, status.Message = checkPhaseBreaking, now.Format(time.RFC3339), "canary broken, waiting for it to fail"
+		case err != nil && !apierrors.IsNotFound(err):
+			status.Message = "failed to read the canary: " + err.Error()
+		case now.After(checkTime(status.StartedAt).Add(s.deadline)):
+			status.Message = fmt.Sprintf("the canary didn't become ready within %s", s.deadline)
+			finishCheck(ctx, kube, check, s, &status, checkFailed, now)
+		}
+
+	case checkPhaseBreaking:
+		pods, err := canaryPods(ctx, kube, check)
+		if err != nil {
+			status.Message = "failed to list canary pods: " + err.Error()
+			return status
+		}
+		for _, p := range pods {
+			if p.Annotations[checkBreakAnnotation] == "" || podReady(&p) {
+				continue
+			}
+			status.Phase, status.FailedAt = checkPhaseHealing, now.Format(time.RFC3339)
+			status.Message = "canary failing, waiting for the operator to heal it"
+			log.Printf("SelfHealingCheck %s: canary pod %s is failing — expecting it healed by %s", key, p.Name, now.Add(s.deadline).Format(time.RFC3339))
+			cooldownMu.Lock()
+			delete(lastAction, check.GetNamespace()+"/"+canaryName(check.GetName()))
+			cooldownMu.Unlock()
+			if s.Alert == "synthetic" {
+				go processAlerts(operatorCtx, []Alert{checkAlert(check, s, status, p.Name)})
+			}
+			return status
+		}
+		if now.After(checkTime(status.BrokenAt).Add(s.deadline)) {
+			status.Message = fmt.Sprintf("the canary didn't fail within %s of being broken", s.deadline)
+			finishCheck(ctx, kube, check, s, &status, checkFailed, now)
+		}
+
+	case checkPhaseHealing:
+		status.Remediation = checkRemediationSince(check, checkTime(status.BrokenAt))
+		status.Decision = checkDecision(check, checkTime(status.BrokenAt))
+		healed := false
+		if dep, err := deployments.Get(ctx, canaryName(check.GetName()), metav1.GetOptions{}); err == nil && dep.Status.ReadyReplicas > 0 {
+			pods, err := canaryPods(ctx, kube, check)
+			healed = err == nil
+			for _, p := range pods {
+				healed = healed && p.Annotations[checkBreakAnnotation] == ""
+			}
+		}
+		failedAt := checkTime(status.FailedAt)
+		switch {
+		case healed && status.Remediation != nil && status.Remediation.Outcome == "succeeded":
+			status.HealedAfter = now.Sub(failedAt).Round(time.Second).String()
+			status.Message = fmt.Sprintf("healed by %s (history #%d) %s after failing", status.Remediation.Action, status.Remediation.HistoryID, status.HealedAfter)
+			finishCheck(ctx, kube, check, s, &status, checkPassed, now)
+		case now.After(failedAt.Add(s.deadline)):
+			status.Message = checkFailureMessage(s, status, healed)
+			finishCheck(ctx, kube, check, s, &status, checkFailed, now)
+		}
+
+	default: // not started, or finished
+		if !checkDue(check, s, status, now) {
+			return status
+		}
+		want := canaryDeployment(check, s)
+		_, err := deployments.Create(ctx, want, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			// left over from a run the operator didn't finish: start from a fresh one
+			err = deployments.Delete(ctx, want.Name, metav1.DeleteOptions{})
+			status.Message = "removing the canary of an earlier run"
+			if err != nil {
+				status.Message = "failed to remove the canary of an earlier run: " + err.Error()
+			}
+			return status
+		}
+		if err != nil {
+			status.Message = "failed to deploy the canary: " + err.Error()
+			return status
+		}
+		log.Printf("SelfHealingCheck %s: deployed canary %s", key, want.Name)
+		status = SelfHealingCheckStatus{
+			Phase:              checkPhaseDeploying,
+			Result:             status.Result,
+			Message:            "deploying the canary",
+			StartedAt:          now.Format(time.RFC3339),
+			Passes:             status.Passes,
+			Failures:           status.Failures,
+			ObservedGeneration: check.GetGeneration(),
+		}
+	}
+	return status
+}
+
+// checkFailureMessage explains why a canary wasn't healed in time
+func checkFailureMessage(s checkSettings, status SelfHealingCheckStatus, healed bool) string {
+	msg := fmt.Sprintf("not healed within %s", s.deadline)
+	switch {
+	case status.Remediation != nil:
+		msg += fmt.Sprintf(": %s (history #%d) %s", status.Remediation.Action, status.Remediation.HistoryID, status.Remediation.Outcome)
+		if status.Remediation.Error != "" {
+			msg += ": " + status.Remediation.Error
+		}
+		if status.Remediation.Outcome == "succeeded" && !healed {
+			msg += ", but the canary still has broken pods"
+		}
+	case status.Decision != "":
+		msg += ": the operator decided " + status.Decision + " for the alert"
+	case s.Alert == "prometheus":
+		msg += ": no alert for the canary arrived"
+	default:
+		msg += ": no remediation ran"
+	}
+	return msg
+}
+
+// finishCheck ends a run: resolves the alert, removes the canary and records the result
+func finishCheck(ctx context.Context, kube kubernetes.Interface, check *unstructured.Unstructured, s checkSettings, status *SelfHealingCheckStatus, result string, now time.Time) {
+	key := check.GetNamespace() + "/" + check.GetName()
+	if status.FailedAt != "" && s.Alert == "synthetic" {
+		alert := checkAlert(check, s, *status, "")
+		alert.Status, alert.EndsAt = "resolved", now
+		go processAlerts(operatorCtx, []Alert{alert})
+	}
+	if status.StartedAt != "" {
+		background := metav1.DeletePropagationBackground
+		err := kube.AppsV1().Deployments(check.GetNamespace()).Delete(ctx, canaryName(check.GetName()), metav1.DeleteOptions{PropagationPolicy: &background})
+		if err != nil && !apierrors.IsNotFound(err) {
+			log.Printf("SelfHealingCheck %s: failed to delete the canary: %v", key, err)
+		}
+	}
+	status.Phase, status.Result, status.FinishedAt = result, result, now.Format(time.RFC3339)
+	if result == checkPassed {
+		status.Passes++
+	} else {
+		status.Failures++
+	}
+	log.Printf("SelfHealingCheck %s %s: %s", key, result, status.Message)
+
+	checksMu.Lock()
+	checkRuns[key+"|"+result]++
+	checkPassing[key] = result == checkPassed
+	if d, err := time.ParseDuration(status.HealedAfter); err == nil && result == checkPassed {
+		checkHealTime[key] = d.Seconds()
+	}
+	checksMu.Unlock()
+	if result == checkFailed {
+		notifySlack(renderMessage("check-failed", "", map[string]interface{}{
+			"Namespace": check.GetNamespace(), "Name": check.GetName(), "Message": status.Message,
+		}))
+	}
+}
+
+// syncChecks moves every check on and saves the statuses that changed
+func syncChecks(ctx context.Context, dyn dynamic.Interface, kube kubernetes.Interface) error {
+	list, err := dyn.Resource(selfHealingCheckGVR).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list SelfHealingChecks: %v", err)
+	}
+	now := time.Now()
+	for i := range list.Items {
+		check := &list.Items[i]
+		if shardingEnabled {
+			if owner, _ := shards.owner(check.GetNamespace()); owner != shards.self {
+				continue
+			}
+		}
+		status := reconcileCheck(ctx, kube, check, now)
+		data, _ := json.Marshal(status)
+		var want map[string]interface{}
+		json.Unmarshal(data, &want)
+		if old, _, _ := unstructured.NestedMap(check.Object, "status"); reflect.DeepEqual(old, want) {
+			continue
+		}
+		check.Object["status"] = want
+		if _, err := dyn.Resource(selfHealingCheckGVR).Namespace(check.GetNamespace()).UpdateStatus(ctx, check, metav1.UpdateOptions{}); err != nil {
+			log.Printf("Failed to update the status of SelfHealingCheck %s/%s: %v", check.GetNamespace(), check.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// startSelfHealingChecks runs the SelfHealingChecks every CHECKS_SYNC
+func startSelfHealingChecks(config *rest.Config, kube kubernetes.Interface) {
+	if !checksEnabled {
+		return
+	}
+	dyn, err := dynamic.NewForConfig(config)
+	if err != nil {
+		log.Fatalf("Failed to create dynamic client for SELF_HEALING_CHECKS: %v", err)
+	}
+	log.Printf("Running SelfHealingChecks (synced every %s)", checksSync)
+	go func() {
+		ticker := time.NewTicker(checksSync)
+		defer ticker.Stop()
+		for {
+			ctx, cancel := context.WithTimeout(operatorCtx, time.Minute)
+			if err := syncChecks(ctx, dyn, kube); err != nil {
+				log.Printf("SelfHealingCheck sync failed: %v", err)
+			}
+			cancel()
+			select {
+			case <-operatorCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func writeCheckMetrics(w io.Writer) {
+	if !checksEnabled {
+		return
+	}
+	checksMu.Lock()
+	defer checksMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_check_runs_total Finished SelfHealingCheck runs, by check and result (Passed, Failed).")
+	fmt.Fprintln(w, "# TYPE selfhealing_check_runs_total counter")
+	for _, key := range sortedKeys(checkRuns) {
+		check, result, _ := strings.Cut(key, "|")
+		namespace, name, _ := strings.Cut(check, "/")
+		fmt.Fprintf(w, "selfhealing_check_runs_total{namespace=%q,check=%q,result=%q} %d\n", namespace, name, result, checkRuns[key])
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_check_passing Whether the last run of a SelfHealingCheck passed.")
+	fmt.Fprintln(w, "# TYPE selfhealing_check_passing gauge")
+	for _, key := range sortedKeys(checkPassing) {
+		namespace, name, _ := strings.Cut(key, "/")
+		passing := 0
+		if checkPassing[key] {
+			passing = 1
+		}
+		fmt.Fprintf(w, "selfhealing_check_passing{namespace=%q,check=%q} %d\n", namespace, name, passing)
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_check_heal_seconds Time from the canary failing until it was healed, in the last passing run of a SelfHealingCheck.")
+	fmt.Fprintln(w, "# TYPE selfhealing_check_heal_seconds gauge")
+	for _, key := range sortedKeys(checkHealTime) {
+		namespace, name, _ := strings.Cut(key, "/")
+		fmt.Fprintf(w, "selfhealing_check_heal_seconds{namespace=%q,check=%q} %g\n", namespace, name, checkHealTime[key])
+	}
+}
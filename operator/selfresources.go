@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/automaxprocs/maxprocs"
+)
+
+// Self-resource awareness: size GOMAXPROCS to the container CPU quota, set
+// the Go memory limit just below the container memory limit, export the
+// operator's own resource usage, and shed low-priority alerts when memory gets
+// close to the limit during an alert storm (critical alerts are still handled).
+
+var (
+	containerMemoryLimit int64 // bytes, 0 if unlimited/unknown
+	shedMemoryRatio      = envFloat("SHED_MEMORY_RATIO", 0.85)
+
+	memSampleMu   sync.Mutex
+	memSampleAt   time.Time
+	memSampleHeap uint64
+
+	shedMu    sync.Mutex
+	shedCount = map[string]int{} // key = severity
+)
+
+func init() {
+	registerMetrics(writeSelfMetrics)
+}
+
+// tuneRuntime applies cgroup limits to the Go runtime. Explicit GOMAXPROCS /
+// GOMEMLIMIT environment settings win.
+func tuneRuntime() {
+	if _, err := maxprocs.Set(maxprocs.Logger(log.Printf)); err != nil {
+		log.Printf("Failed to set GOMAXPROCS from CPU quota: %v", err)
+	}
+
+	containerMemoryLimit = readCgroupMemoryLimit()
+	if containerMemoryLimit == 0 {
+		return
+	}
+	if os.Getenv("GOMEMLIMIT") == "" {
+		// Leave headroom for non-heap memory (stacks, runtime, buffers)
+		soft := containerMemoryLimit * 9 / 10
+		debug.SetMemoryLimit(soft)
+		log.Printf("Container memory limit %d MiB — Go memory limit set to %d MiB",
+			containerMemoryLimit>>20, soft>>20)
+	}
+}
+
+// readCgroupMemoryLimit reads the cgroup v2 (or v1) memory limit; 0 means none
+func readCgroupMemoryLimit() int64 {
+	for _, path := range []string{
+		"/sys/fs/cgroup/memory.max",
+		"/sys/fs/cgroup/memory/memory.limit_in_bytes",
+	} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		v := strings.TrimSpace(string(data))
+		if v == "max" {
+			return 0
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		// cgroup v1 reports "unlimited" as a huge page-aligned number
+		if err != nil || n <= 0 || n >= 1<<62 {
+			return 0
+		}
+		return n
+	}
+	return 0
+}
+
+// heapInUse samples the heap at most once a second; ReadMemStats stops the world
+func heapInUse() uint64 {
+	memSampleMu.Lock()
+	defer memSampleMu.Unlock()
+	if time.Since(memSampleAt) > time.Second {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		memSampleHeap = ms.HeapInuse
+		memSampleAt = time.Now()
+	}
+	return memSampleHeap
+}
+
+// isHighPriority reports whether an alert must be handled even under memory pressure
+func isHighPriority(alert Alert) bool {
+	switch alert.Labels["severity"] {
+	case "critical", "page":
+		return true
+	}
+	return false
+}
+
+// shouldShed reports whether a low-priority alert should be dropped because
+// the operator is close to its own memory limit
+func shouldShed(alert Alert) bool {
+	if !wouldShed(alert) {
+		return false
+	}
+	shedMu.Lock()
+	shedCount[alert.Labels["severity"]]++
+	shedMu.Unlock()
+	return true
+}
+
+// wouldShed is shouldShed without counting the alert as shed
+func wouldShed(alert Alert) bool {
+	if containerMemoryLimit == 0 || isHighPriority(alert) {
+		return false
+	}
+	return float64(heapInUse()) >= shedMemoryRatio*float64(containerMemoryLimit)
+}
+
+func writeSelfMetrics(w io.Writer) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	fmt.Fprintln(w, "# HELP selfhealing_go_goroutines Number of goroutines in the operator.")
+	fmt.Fprintln(w, "# TYPE selfhealing_go_goroutines gauge")
+	fmt.Fprintf(w, "selfhealing_go_goroutines %d\n", runtime.NumGoroutine())
+	fmt.Fprintln(w, "# HELP selfhealing_go_gomaxprocs GOMAXPROCS of the operator.")
+	fmt.Fprintln(w, "# TYPE selfhealing_go_gomaxprocs gauge")
+	fmt.Fprintf(w, "selfhealing_go_gomaxprocs %d\n", runtime.GOMAXPROCS(0))
+	fmt.Fprintln(w, "# HELP selfhealing_go_heap_inuse_bytes Heap bytes in use.")
+	fmt.Fprintln(w, "# TYPE selfhealing_go_heap_inuse_bytes gauge")
+	fmt.Fprintf(w, "selfhealing_go_heap_inuse_bytes %d\n", ms.HeapInuse)
+	fmt.Fprintln(w, "# HELP selfhealing_go_sys_bytes Bytes obtained from the OS by the Go runtime.")
+	fmt.Fprintln(w, "# TYPE selfhealing_go_sys_bytes gauge")
+	fmt.Fprintf(w, "selfhealing_go_sys_bytes %d\n", ms.Sys)
+	fmt.Fprintln(w, "# HELP selfhealing_container_memory_limit_bytes Container memory limit seen by the operator (0 = none).")
+	fmt.Fprintln(w, "# TYPE selfhealing_container_memory_limit_bytes gauge")
+	fmt.Fprintf(w, "selfhealing_container_memory_limit_bytes %d\n", containerMemoryLimit)
+
+	shedMu.Lock()
+	defer shedMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_alerts_shed_total Low-priority alerts dropped under memory pressure.")
+	fmt.Fprintln(w, "# TYPE selfhealing_alerts_shed_total counter")
+	for _, sev := range sortedKeys(shedCount) {
+		fmt.Fprintf(w, "selfhealing_alerts_shed_total{severity=%q} %d\n", sev, shedCount[sev])
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+
+	"self-healing-operator/pkg/policy"
+)
+
+// Severity-based action selection: one alert rule can map severities to
+// different actions through a `recovery_actions` annotation instead of
+// duplicating the rule per action, e.g.
+//
+//	recovery_actions: "warning=notify, critical=restart, page=redeploy+escalate"
+//
+// The alert's `severity` label picks the entry; "+escalate" also pages after
+// the action runs. SEVERITY_ACTIONS sets a cluster-wide default mapping in the
+// same format for alerts that carry neither the annotation nor a
+// recovery_action label. The selection itself is pkg/policy.
+
+var defaultSeverityActions = os.Getenv("SEVERITY_ACTIONS")
+
+// selectAction picks the action for an alert: the severity mapping from the
+// alert's annotation first, then the recovery_action label, then the
+// SEVERITY_ACTIONS default. The "+escalate" suffix is split off. Each source
+// looked at is added to trace.
+func selectAction(alert Alert, trace *decisionTrace) (action string, escalate bool) {
+	return selectActionWith(alert, defaultSeverityActions, trace)
+}
+
+// selectActionWith is selectAction with defaults in place of SEVERITY_ACTIONS,
+// for evaluating a proposed mapping (see replay.go)
+func selectActionWith(alert Alert, defaults string, trace *decisionTrace) (action string, escalate bool) {
+	d := policy.Engine{Defaults: defaults}.Select(alert)
+	for _, step := range d.Steps {
+		if step.Action == "" {
+			trace.add("policy", traceSkip, step.Source+": no action for severity "+alert.Labels["severity"])
+			continue
+		}
+		trace.add("policy", tracePass, step.Source+": "+step.Action)
+	}
+	return d.Action, d.Escalate
+}
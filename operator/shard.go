@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Sharding (SHARDING=true) lets several operator replicas split the alert
+// load by namespace. Each replica keeps a Lease named <SHARD_GROUP>-<pod> in
+// its own namespace; the unexpired leases of the group are the ring members.
+// A namespace belongs to the member with the highest hash(member, namespace)
+// (rendezvous hashing), so a member joining or leaving only moves its own
+// share of namespaces.
+//
+// Alertmanager can keep sending to the Service: a replica that receives an
+// alert for a namespace it doesn't own forwards it to the owner's /webhook.
+// Forwarded requests are never forwarded again, and an unreachable owner
+// means the receiving replica handles the alert itself. Watchdog heartbeats
+// go to every member so each replica's readiness stays accurate.
+//
+// Use a shared store (STORE=crd or postgres) so cooldowns survive a namespace
+// moving to another member.
+
+const (
+	shardGroupLabel        = "selfhealing.io/shard-group"
+	shardEndpointAnno      = "selfhealing.io/endpoint"
+	shardForwardedHeader   = "X-Selfhealing-Forwarded"
+	shardClusterScopedName = "_cluster" // ring key for alerts without a namespace
+)
+
+var (
+	shardingEnabled    = envBool("SHARDING", false)
+	shardGroup         = envString("SHARD_GROUP", "self-healing-operator")
+	shardLeaseDuration = envDuration("SHARD_LEASE_DURATION", 30*time.Second)
+
+	// a replica that's gone leaves its Lease behind; GC removes it after this
+	shardLeaseGCAfter = 10 * shardLeaseDuration
+
+	shards = &shardRing{members: map[string]string{}}
+)
+
+type shardRing struct {
+	mu        sync.Mutex
+	self      string
+	members   map[string]string // identity -> webhook endpoint
+	forwarded map[string]int    // result -> count
+	client    *http.Client
+}
+
+func init() {
+	registerMetrics(shards.writeMetrics)
+}
+
+// startSharding registers this replica and keeps the member list current
+func startSharding(kube kubernetes.Interface) {
+	if !shardingEnabled {
+		return
+	}
+	self := envString("POD_NAME", "")
+	if self == "" {
+		self, _ = os.Hostname()
+	}
+	endpoint := envString("SHARD_ENDPOINT", "")
+	if endpoint == "" {
+		endpoint = "http://" + net.JoinHostPort(envString("POD_IP", self), envString("PORT", "8080"))
+	}
+	namespace := envString("POD_NAMESPACE", "default")
+
+	shards.mu.Lock()
+	shards.self = self
+	shards.members[self] = endpoint
+	shards.forwarded = map[string]int{}
+	shards.client = &http.Client{Timeout: 10 * time.Second}
+	shards.mu.Unlock()
+
+	log.Printf("Sharding enabled — member %s of group %s (%s)", self, shardGroup, endpoint)
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := renewShardLease(ctx, kube, namespace, self, endpoint); err != nil {
+				log.Printf("Failed to renew shard lease: %v", err)
+			} else if err := shards.sync(ctx, kube, namespace); err != nil {
+				log.Printf("Failed to list shard members: %v", err)
+			}
+			cancel()
+			time.Sleep(shardLeaseDuration / 3)
+		}
+	}()
+}
+
+func renewShardLease(ctx context.Context, kube kubernetes.Interface, namespace, self, endpoint string) error {
+	leases := kube.CoordinationV1().Leases(namespace)
+	name := shardGroup + "-" + self
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(shardLeaseDuration.Seconds())
+
+	lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		meta := metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{shardGroupLabel: shardGroup},
+			Annotations: map[string]string{shardEndpointAnno: endpoint},
+		}
+		markOwned(&meta, shardLeaseGCAfter)
+		_, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: meta,
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &self,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	markOwned(&lease.ObjectMeta, shardLeaseGCAfter)
+	lease.Annotations[shardEndpointAnno] = endpoint
+	lease.Spec.HolderIdentity = &self
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// sync replaces the member list with the group's unexpired leases
+func (s *shardRing) sync(ctx context.Context, kube kubernetes.Interface, namespace string) error {
+	list, err := kube.CoordinationV1().Leases(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: shardGroupLabel + "=" + shardGroup,
+	})
+	if err != nil {
+		return err
+	}
+	members := map[string]string{}
+	for _, l := range list.Items {
+		if l.Spec.HolderIdentity == nil || l.Spec.RenewTime == nil || l.Spec.LeaseDurationSeconds == nil {
+			continue
+		}
+		expires := l.Spec.RenewTime.Add(time.Duration(*l.Spec.LeaseDurationSeconds) * time.Second)
+		if time.Now().After(expires) {
+			continue
+		}
+		members[*l.Spec.HolderIdentity] = l.Annotations[shardEndpointAnno]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// our own lease was just renewed, but keep ourselves in even if the list lags
+	members[s.self] = s.members[s.self]
+	if len(members) != len(s.members) {
+		log.Printf("Shard ring now has %d member(s)", len(members))
+	}
+	s.members = members
+	return nil
+}
+
+// owner returns the member that handles a namespace
+func (s *shardRing) owner(namespace string) (string, string) {
+	if namespace == "" {
+		namespace = shardClusterScopedName
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var best, endpoint string
+	var bestScore uint64
+	for id, ep := range s.members {
+		h := fnv.New64a()
+		h.Write([]byte(id))
+		h.Write([]byte{0})
+		h.Write([]byte(namespace))
+		if score := h.Sum64(); best == "" || score > bestScore || (score == bestScore && id < best) {
+			best, endpoint, bestScore = id, ep, score
+		}
+	}
+	return best, endpoint
+}
+
+// route forwards the alerts owned by other members and returns the ones to
+// handle here. Requests that were already forwarded are kept as they are.
+func (s *shardRing) route(r *http.Request, groupKey string, alerts []Alert) []Alert {
+	if r.Header.Get(shardForwardedHeader) != "" {
+		return alerts
+	}
+	return s.routeAlerts(r.Context(), groupKey, alerts)
+}
+
+// routeAlerts is route for alerts that didn't arrive over HTTP (see bus.go)
+func (s *shardRing) routeAlerts(ctx context.Context, groupKey string, alerts []Alert) []Alert {
+	// the owner is picked by namespace, so map the labels first
+	mapAlertLabels(alerts)
+	if !shardingEnabled {
+		return alerts
+	}
+	var local []Alert
+	remote := map[string][]Alert{} // endpoint -> alerts
+	for _, alert := range alerts {
+		if alert.Labels["alertname"] == watchdog.alertName {
+			for _, ep := range s.peers() {
+				remote[ep] = append(remote[ep], alert)
+			}
+			local = append(local, alert)
+			continue
+		}
+		id, ep := s.owner(alert.Labels["namespace"])
+		if id == s.self || ep == "" {
+			local = append(local, alert)
+			continue
+		}
+		remote[ep] = append(remote[ep], alert)
+	}
+
+	for _, ep := range sortedKeys(remote) {
+		if err := s.forward(ctx, ep, groupKey, remote[ep]); err != nil {
+			log.Printf("Forwarding %d alert(s) to shard %s failed, handling them here: %v", len(remote[ep]), ep, err)
+			for _, alert := range remote[ep] {
+				if alert.Labels["alertname"] != watchdog.alertName {
+					local = append(local, alert)
+				}
+			}
+		}
+	}
+	return local
+}
+
+func (s *shardRing) peers() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []string
+	for id, ep := range s.members {
+		if id != s.self && ep != "" {
+			out = append(out, ep)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (s *shardRing) forward(ctx context.Context, endpoint, groupKey string, alerts []Alert) error {
+	body, err := json.Marshal(WebhookMessage{GroupKey: groupKey, Alerts: alerts})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/webhook", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(shardForwardedHeader, s.self)
+
+	result := "ok"
+	defer func() {
+		s.mu.Lock()
+		s.forwarded[result]++
+		s.mu.Unlock()
+	}()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		result = "error"
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		result = "error"
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *shardRing) writeMetrics(w io.Writer) {
+	if !shardingEnabled {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_shard_members Operator replicas in the shard ring.")
+	fmt.Fprintln(w, "# TYPE selfhealing_shard_members gauge")
+	fmt.Fprintf(w, "selfhealing_shard_members %d\n", len(s.members))
+	fmt.Fprintln(w, "# HELP selfhealing_shard_forwards_total Alert batches forwarded to the owning replica, by result.")
+	fmt.Fprintln(w, "# TYPE selfhealing_shard_forwards_total counter")
+	for _, result := range sortedKeys(s.forwarded) {
+		fmt.Fprintf(w, "selfhealing_shard_forwards_total{result=%q} %d\n", result, s.forwarded[result])
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Remediation SLAs. When a recovery action runs, a timer starts for the alert
+// on that workload; the resolved notification from Alertmanager stops it and
+// records the time to recovery. If the alert is still firing when the SLA
+// runs out, the remediation breached it and the operator escalates on its
+// own instead of waiting for the next notification and the cooldown:
+//   - remediation_sla_breach: page (the default) escalates through the
+//     configured escalators
+//   - remediation_sla_breach: <action> runs that action as the next tier
+//     (e.g. redeploy after a restart didn't help); if it breaches the SLA
+//     too, the operator pages
+//
+// The SLA is the alert rule's remediation_sla annotation, or REMEDIATION_SLA
+// (default 0: no SLA); REMEDIATION_SLA_BREACH is the default breach action.
+//
+//	annotations:
+//	  remediation_sla: 10m
+//	  remediation_sla_breach: redeploy
+//
+// Time to recovery is measured from the first remediation until the alert
+// resolves (so the next tier doesn't restart the clock), and exported as
+// selfhealing_recovery_seconds{namespace,app}. Timers live in memory: a
+// restart forgets them. Alertmanager has to send resolved notifications
+// (send_resolved, on by default for webhooks).
+
+var (
+	remediationSLA       = envDuration("REMEDIATION_SLA", 0)
+	remediationSLABreach = envString("REMEDIATION_SLA_BREACH", "page")
+
+	recoveryBuckets = []float64{30, 60, 120, 300, 600, 900, 1800, 3600, 7200}
+
+	slaMu         sync.Mutex
+	slaTimers     = map[string]*slaTimer{}  // alertname|namespace/app
+	slaResults    = map[string]int{}        // alertname|result
+	recoveryTimes = map[string]*histogram{} // namespace/app
+)
+
+// slaTimer watches one remediated alert until it resolves
+type slaTimer struct {
+	first    time.Time // when the first remediation of the episode ran
+	deadline time.Time
+	tier     int // 0 for the alert's own action, 1 once the breach action ran
+	breached bool
+	timer    *time.Timer
+}
+
+func init() {
+	registerMetrics(writeSLAMetrics)
+}
+
+func slaKey(alertname, namespace, app string) string {
+	if namespace == "" {
+		namespace = "default"
+	}
+	return alertname + "|" + namespace + "/" + app
+}
+
+// slaFor reads the alert's SLA and breach action
+func slaFor(alert Alert) (time.Duration, string) {
+	sla, breach := remediationSLA, remediationSLABreach
+	if v := alert.Annotations["remediation_sla"]; v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			sla = d
+		} else {
+			log.Printf("Ignoring remediation_sla %q of alert %s — %v", v, alert.Labels["alertname"], err)
+		}
+	}
+	if v := strings.TrimSpace(alert.Annotations["remediation_sla_breach"]); v != "" {
+		breach = v
+	}
+	return sla, breach
+}
+
+// startSLA starts (or, for the next tier, restarts) the alert's SLA timer
+// when its action runs
+func startSLA(action *RecoveryAction, alert Alert) {
+	sla, _ := slaFor(alert)
+	if sla <= 0 || alert.Status != "firing" {
+		return
+	}
+	key := slaKey(action.AlertName, action.Namespace, action.App)
+	now := time.Now()
+
+	slaMu.Lock()
+	defer slaMu.Unlock()
+	t := slaTimers[key]
+	if t == nil {
+		t = &slaTimer{first: now}
+		slaTimers[key] = t
+	} else {
+		t.timer.Stop()
+	}
+	t.tier = action.slaTier
+	t.deadline = now.Add(sla)
+	t.timer = time.AfterFunc(sla, func() { breachSLA(key, t, action, alert) })
+}
+
+// breachSLA escalates an alert that outlived its SLA
+func breachSLA(key string, t *slaTimer, action *RecoveryAction, alert Alert) {
+	slaMu.Lock()
+	if slaTimers[key] != t || time.Now().Before(t.deadline) {
+		// resolved, or restarted for the next tier, in the meantime
+		slaMu.Unlock()
+		return
+	}
+	if !t.breached {
+		t.breached = true
+		slaResults[action.AlertName+"|breached"]++
+	}
+	tier := t.tier
+	since := time.Since(t.first).Round(time.Second)
+	slaMu.Unlock()
+
+	sla, breach := slaFor(alert)
+	reason := fmt.Sprintf("alert %s on %s/%s still firing %s after '%s' (SLA %s)", action.AlertName, action.Namespace, action.App, since, action.Action, sla)
+	if breach == "page" || tier > 0 {
+		log.Printf("Remediation SLA breached — %s; paging", reason)
+		escalate(action, alert, "remediation SLA breached: "+reason)
+		return
+	}
+
+	log.Printf("Remediation SLA breached — %s; running '%s' as the next tier", reason, breach)
+	next := *action
+	next.Action, next.Escalate, next.slaTier = breach, false, tier+1
+	next.Explain = append(append(decisionTrace(nil), action.Explain...), TraceStep{Check: "sla", Result: traceInfo, Detail: "next tier after SLA breach: " + reason})
+	if operatingMode == modeRecommend || requiresApproval(next.Action) || observation.holds(&next) {
+		recommendAction(&next, alert)
+		return
+	}
+	go performAction(operatorCtx, &next, alert)
+}
+
+// observeSLAResolved stops the alert's timer and records its time to recovery
+func observeSLAResolved(alert Alert) {
+	if alert.Status != "resolved" {
+		return
+	}
+	key := slaKey(alert.Labels["alertname"], alert.Labels["namespace"], alert.Labels["app"])
+	slaMu.Lock()
+	defer slaMu.Unlock()
+	t := slaTimers[key]
+	if t == nil {
+		return
+	}
+	t.timer.Stop()
+	delete(slaTimers, key)
+
+	resolved := alert.EndsAt
+	if resolved.IsZero() || resolved.After(time.Now()) {
+		resolved = time.Now()
+	}
+	took := resolved.Sub(t.first)
+	if took < 0 {
+		took = 0
+	}
+	workload := key[strings.Index(key, "|")+1:]
+	if recoveryTimes[workload] == nil {
+		recoveryTimes[workload] = &histogram{buckets: recoveryBuckets}
+	}
+	recoveryTimes[workload].observe(took.Seconds(), "", resolved)
+	if !t.breached {
+		slaResults[alert.Labels["alertname"]+"|met"]++
+	}
+	log.Printf("Alert %s resolved %s after its first remediation", key, took.Round(time.Second))
+}
+
+func writeSLAMetrics(w io.Writer) {
+	slaMu.Lock()
+	defer slaMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_remediation_sla_total Remediated alerts by whether they resolved within their SLA (met, breached).")
+	fmt.Fprintln(w, "# TYPE selfhealing_remediation_sla_total counter")
+	for _, key := range sortedKeys(slaResults) {
+		alertname, result, _ := strings.Cut(key, "|")
+		fmt.Fprintf(w, "selfhealing_remediation_sla_total{alertname=%q,result=%q} %d\n", alertname, result, slaResults[key])
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_remediation_sla_pending Remediated alerts still firing within or past their SLA.")
+	fmt.Fprintln(w, "# TYPE selfhealing_remediation_sla_pending gauge")
+	fmt.Fprintf(w, "selfhealing_remediation_sla_pending %d\n", len(slaTimers))
+	fmt.Fprintln(w, "# HELP selfhealing_recovery_seconds Time from the first remediation of an alert until it resolved, by workload.")
+	fmt.Fprintln(w, "# TYPE selfhealing_recovery_seconds histogram")
+	for _, workload := range sortedKeys(recoveryTimes) {
+		namespace, app, _ := strings.Cut(workload, "/")
+		writeHistogram(w, "selfhealing_recovery_seconds", fmt.Sprintf("namespace=%q,app=%q", namespace, app), recoveryTimes[workload], false)
+	}
+}
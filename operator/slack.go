@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+)
+
+// slackWebhookURL is a Slack incoming-webhook URL; empty disables Slack messages
+var slackWebhookURL = credential("SLACK_WEBHOOK_URL")
+
+// postSlack sends a message through the incoming webhook. text uses Slack
+// mrkdwn, so links are written as <url|label>.
+func postSlack(ctx context.Context, text string) error {
+	u := slackWebhookURL.value()
+	if u == "" {
+		return nil
+	}
+	return postJSON(ctx, "slack", u, map[string]string{"text": text}, nil)
+}
+
+// postSlackBlocks sends a Block Kit message through the incoming webhook;
+// text is the fallback shown in notifications
+func postSlackBlocks(ctx context.Context, text string, blocks []map[string]interface{}) error {
+	u := slackWebhookURL.value()
+	if u == "" {
+		return nil
+	}
+	return postJSON(ctx, "slack", u, map[string]interface{}{"text": text, "blocks": blocks}, nil)
+}
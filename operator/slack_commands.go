@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	authnv1 "k8s.io/api/authentication/v1"
+)
+
+// Slack slash commands and buttons, so on-call can drive the operator from
+// chat. With SLACK_SIGNING_SECRET set (the Slack app's signing secret):
+//   - POST /slack/commands is the slash command's request URL:
+//	/selfheal freeze payments/checkout 1h deploy in progress
+//	/selfheal unfreeze 4
+//	/selfheal pending
+//	/selfheal approve 3
+//	/selfheal deny 3
+//	/selfheal status
+//   - POST /slack/interactions is the app's interactivity URL: recommendations
+//     posted to Slack get Approve and Deny buttons
+//
+// Every request is verified with the signing secret — the v0 HMAC-SHA256 of
+// the timestamp and the body, no more than 5 minutes old — and anything else
+// is refused. SLACK_ALLOWED_USERS limits the commands to some Slack users (IDs
+// or names); with API_AUTHZ=kubernetes each one also needs the RBAC verb
+// (freeze or approve) on remediationpolicies.selfhealing.io as the user
+// slack:<user ID>. Approved actions run in the background and their outcome
+// is posted back to the channel.
+
+var (
+	slackSigningSecret = credential("SLACK_SIGNING_SECRET")
+	slackAllowedUsers  = envList("SLACK_ALLOWED_USERS")
+
+	slackCommandMu sync.Mutex
+	slackCommands  = map[string]int{} // command|result -> count
+)
+
+// slackMaxSkew is how old (or how far in the future) a signed request may be
+const slackMaxSkew = 5 * time.Minute
+
+const slackUsage = "Usage: `/selfheal freeze [namespace/]app duration [reason]`, `unfreeze <id>`, " +
+	"`pending`, `approve <id>`, `deny <id>`, `status`"
+
+// slackCommandsEnabled reports whether the operator takes commands from Slack
+func slackCommandsEnabled() bool {
+	return slackSigningSecret.value() != ""
+}
+
+// slackResponse is the reply to a slash command or a response_url message
+type slackResponse struct {
+	ResponseType    string                   `json:"response_type,omitempty"` // in_channel or ephemeral
+	Text            string                   `json:"text"`
+	Blocks          []map[string]interface{} `json:"blocks,omitempty"`
+	ReplaceOriginal bool                     `json:"replace_original,omitempty"`
+}
+
+// slackUser is who ran a command or pressed a button
+type slackUser struct {
+	ID   string `json:"id"`
+	Name string `json:"username"`
+}
+
+func (u slackUser) String() string {
+	if u.Name != "" {
+		return "slack:" + u.Name
+	}
+	return "slack:" + u.ID
+}
+
+// verifySlackRequest reads the body and checks its Slack signature
+func verifySlackRequest(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	secret := slackSigningSecret.value()
+	if secret == "" {
+		http.Error(w, "Slack commands are not enabled (SLACK_SIGNING_SECRET)", http.StatusNotFound)
+		return nil, false
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<16))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	ts := r.Header.Get("X-Slack-Request-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		http.Error(w, "missing or invalid X-Slack-Request-Timestamp", http.StatusUnauthorized)
+		return nil, false
+	}
+	if skew := time.Since(time.Unix(sec, 0)); skew > slackMaxSkew || skew < -slackMaxSkew {
+		http.Error(w, "request timestamp too old", http.StatusUnauthorized)
+		return nil, false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", ts, body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(r.Header.Get("X-Slack-Signature")), []byte(expected)) {
+		log.Printf("Slack request to %s refused — invalid signature", r.URL.Path)
+		http.Error(w, "invalid Slack signature", http.StatusUnauthorized)
+		return nil, false
+	}
+	return body, true
+}
+
+// authorizeSlackUser checks that the user may use verb in the namespace
+func authorizeSlackUser(ctx context.Context, user slackUser, verb, namespace string) error {
+	if len(slackAllowedUsers) > 0 && !containsString(slackAllowedUsers, user.ID) && !containsString(slackAllowedUsers, user.Name) {
+		return fmt.Errorf("%s is not in SLACK_ALLOWED_USERS", user)
+	}
+	if apiAuthz != "kubernetes" {
+		return nil
+	}
+	_, err := reviewAccess(ctx, authnv1.UserInfo{Username: "slack:" + user.ID}, verb, namespace)
+	return err
+}
+
+// slackCommandNames are the commands counted by name; anything else is "unknown"
+var slackCommandNames = []string{"freeze", "unfreeze", "pending", "approve", "deny", "status", "help"}
+
+func countSlackCommand(command, result string) {
+	if !containsString(slackCommandNames, command) {
+		command = "unknown"
+	}
+	slackCommandMu.Lock()
+	slackCommands[command+"|"+result]++
+	slackCommandMu.Unlock()
+}
+
+// handleSlackCommand is the request URL of the /selfheal slash command
+func handleSlackCommand(w http.ResponseWriter, r *http.Request) {
+	body, ok := verifySlackRequest(w, r)
+	if !ok {
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user := slackUser{ID: form.Get("user_id"), Name: form.Get("user_name")}
+	args := strings.Fields(form.Get("text"))
+	command := "help"
+	if len(args) > 0 {
+		command, args = strings.ToLower(args[0]), args[1:]
+	}
+	resp, result := runSlackCommand(r.Context(), command, args, user, form.Get("response_url"))
+	countSlackCommand(command, result)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// runSlackCommand runs one /selfheal command, returning the reply and the metric result
+func runSlackCommand(ctx context.Context, command string, args []string, user slackUser, responseURL string) (slackResponse, string) {
+	ephemeral := func(text, result string) (slackResponse, string) {
+		return slackResponse{ResponseType: "ephemeral", Text: text}, result
+	}
+	switch command {
+	case "freeze":
+		if len(args) < 2 {
+			return ephemeral("Usage: `/selfheal freeze [namespace/]app duration [reason]`", "invalid")
+		}
+		req := suppressionRequest{App: args[0], Duration: args[1], Reason: strings.Join(args[2:], " ")}
+		if ns, app, ok := strings.Cut(args[0], "/"); ok {
+			req.Namespace, req.App = ns, app
+		}
+		if req.Namespace == "" {
+			req.Namespace = "default"
+		}
+		if req.Reason == "" {
+			req.Reason = "frozen from Slack by " + user.String()
+		}
+		if err := authorizeSlackUser(ctx, user, verbFreeze, req.Namespace); err != nil {
+			return ephemeral(err.Error(), "denied")
+		}
+		s, err := addSuppression(req)
+		if err != nil {
+			return ephemeral(err.Error(), "invalid")
+		}
+		log.Printf("Suppression #%d created by %s", s.ID, user)
+		return slackResponse{ResponseType: "in_channel", Text: fmt.Sprintf("Self-healing frozen for `%s/%s` until %s (suppression #%d, `/selfheal unfreeze %d` to lift it)",
+			s.Namespace, s.App, s.ExpiresAt.Format(time.RFC1123), s.ID, s.ID)}, "ok"
+
+	case "unfreeze":
+		id, err := slackID(args)
+		if err != nil {
+			return ephemeral(err.Error(), "invalid")
+		}
+		suppressionMu.Lock()
+		namespace := ""
+		if s, ok := suppressions[id]; ok {
+			namespace = s.Namespace
+		}
+		suppressionMu.Unlock()
+		if err := authorizeSlackUser(ctx, user, verbFreeze, namespace); err != nil {
+			return ephemeral(err.Error(), "denied")
+		}
+		if !removeSuppression(id) {
+			return ephemeral(fmt.Sprintf("Suppression #%d not found", id), "invalid")
+		}
+		log.Printf("Suppression #%d removed by %s", id, user)
+		return slackResponse{ResponseType: "in_channel", Text: fmt.Sprintf("Suppression #%d lifted: self-healing resumes", id)}, "ok"
+
+	case "approve", "deny":
+		id, err := slackID(args)
+		if err != nil {
+			return ephemeral(err.Error(), "invalid")
+		}
+		text, result := decideFromSlack(ctx, id, command == "approve", user, responseURL)
+		if result != "ok" {
+			return ephemeral(text, result)
+		}
+		return slackResponse{ResponseType: "in_channel", Text: text}, result
+
+	case "pending":
+		var blocks []map[string]interface{}
+		for _, rec := range listRecommendations() {
+			if rec.Status != "pending" {
+				continue
+			}
+			text := fmt.Sprintf("*#%d* `%s` on `%s/%s` for alert `%s` (until %s)", rec.ID, rec.Action, rec.Namespace, rec.App, rec.AlertName, rec.ExpiresAt.Format("15:04 MST"))
+			if rec.Preview != nil {
+				text += "\n" + rec.Preview.Summary
+			}
+			blocks = append(blocks, slackSection(text), decisionButtons(rec.ID))
+		}
+		if len(blocks) == 0 {
+			return ephemeral("No recommendations are waiting for approval", "ok")
+		}
+		return slackResponse{ResponseType: "ephemeral", Text: fmt.Sprintf("%d recommendation(s) waiting for approval", len(blocks)/2), Blocks: blocks}, "ok"
+
+	case "status":
+		return ephemeral(slackStatus(), "ok")
+
+	case "help":
+		return ephemeral(slackUsage, "ok")
+	}
+	return ephemeral(fmt.Sprintf("Unknown command `%s`. %s", command, slackUsage), "invalid")
+}
+
+func slackID(args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("an ID is required")
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(args[0], "#"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid ID %q", args[0])
+	}
+	return id, nil
+}
+
+// slackStatus summarises what the operator is doing
+func slackStatus() string {
+	pending := 0
+	for _, rec := range listRecommendations() {
+		if rec.Status == "pending" {
+			pending++
+		}
+	}
+	lines := []string{fmt.Sprintf("*Self-healing* — mode `%s`, %d recommendation(s) pending", operatingMode, pending)}
+	if safeMode.isActive() {
+		lines = append(lines, ":warning: in safe mode: remediations are on hold")
+	}
+	for _, s := range listSuppressions() {
+		target := s.Namespace + "/" + s.App
+		if s.App == "" {
+			target = s.Namespace + " alert " + s.AlertName
+		}
+		lines = append(lines, fmt.Sprintf("• frozen #%d: `%s` until %s", s.ID, target, s.ExpiresAt.Format(time.RFC1123)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// decideFromSlack approves or denies a recommendation for a Slack user. An
+// approved action runs in the background; its outcome goes to responseURL.
+func decideFromSlack(ctx context.Context, id int, approve bool, user slackUser, responseURL string) (string, string) {
+	recommendationMu.Lock()
+	namespace := ""
+	if rec, ok := recommendations[id]; ok {
+		namespace = rec.Namespace
+	}
+	recommendationMu.Unlock()
+	if err := authorizeSlackUser(ctx, user, verbApprove, namespace); err != nil {
+		return err.Error(), "denied"
+	}
+	status := "denied"
+	if approve {
+		status = "approved"
+	}
+	rec, err := decideRecommendation(id, status)
+	if err != nil {
+		return fmt.Sprintf("Recommendation #%d: %v", id, err), "invalid"
+	}
+	if !approve {
+		log.Printf("Recommendation #%d denied by %s — '%s' for %s/%s won't run", rec.ID, user, rec.Action, rec.Namespace, rec.App)
+		return fmt.Sprintf("Recommendation #%d denied by %s: `%s` on `%s/%s` won't run", rec.ID, user, rec.Action, rec.Namespace, rec.App), "ok"
+	}
+
+	log.Printf("Recommendation #%d approved by %s — executing '%s' for %s/%s", rec.ID, user, rec.Action, rec.Namespace, rec.App)
+	go func() {
+		outcome := fmt.Sprintf(":white_check_mark: Recommendation #%d: `%s` executed for `%s/%s`", rec.ID, rec.Action, rec.Namespace, rec.App)
+		if err := performAction(operatorCtx, rec.action, rec.alert); err != nil {
+			outcome = fmt.Sprintf(":x: Recommendation #%d: `%s` failed for `%s/%s`: %v", rec.ID, rec.Action, rec.Namespace, rec.App, err)
+		}
+		ctx, cancel := context.WithTimeout(operatorCtx, 15*time.Second)
+		defer cancel()
+		if err := respondSlack(ctx, responseURL, slackResponse{ResponseType: "in_channel", Text: outcome}); err != nil {
+			log.Printf("Failed to post the outcome of recommendation #%d to Slack: %v", rec.ID, err)
+		}
+	}()
+	return fmt.Sprintf("Recommendation #%d approved by %s: running `%s` on `%s/%s`", rec.ID, user, rec.Action, rec.Namespace, rec.App), "ok"
+}
+
+// respondSlack posts to a command's response_url, or to the incoming webhook
+// when there's none. Only Slack's own hooks are accepted as response URLs.
+func respondSlack(ctx context.Context, responseURL string, resp slackResponse) error {
+	if responseURL == "" {
+		return postSlack(ctx, resp.Text)
+	}
+	u, err := url.Parse(responseURL)
+	if err != nil || u.Scheme != "https" || u.Host != "hooks.slack.com" {
+		return fmt.Errorf("response_url %q is not a Slack hook", responseURL)
+	}
+	return postJSON(ctx, "slack", responseURL, resp, nil)
+}
+
+// slackInteraction is the part of a block_actions payload the operator reads
+type slackInteraction struct {
+	Type        string    `json:"type"`
+	User        slackUser `json:"user"`
+	ResponseURL string    `json:"response_url"`
+	Message     struct {
+		Text string `json:"text"`
+	} `json:"message"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+}
+
+// handleSlackInteraction is the interactivity URL: the Approve and Deny buttons
+func handleSlackInteraction(w http.ResponseWriter, r *http.Request) {
+	body, ok := verifySlackRequest(w, r)
+	if !ok {
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var p slackInteraction
+	if err := json.Unmarshal([]byte(form.Get("payload")), &p); err != nil {
+		http.Error(w, "invalid payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Slack wants an answer within 3 seconds; the outcome goes to response_url
+	w.WriteHeader(http.StatusOK)
+	if p.Type != "block_actions" {
+		return
+	}
+	for _, a := range p.Actions {
+		if a.ActionID != "approve" && a.ActionID != "deny" {
+			continue
+		}
+		text, result := "", "invalid"
+		id, err := strconv.Atoi(a.Value)
+		if err != nil {
+			text = fmt.Sprintf("invalid recommendation ID %q", a.Value)
+		} else {
+			text, result = decideFromSlack(r.Context(), id, a.ActionID == "approve", p.User, p.ResponseURL)
+		}
+		countSlackCommand(a.ActionID, result)
+		// a decision replaces the buttons; a refusal is only shown to the user
+		resp := slackResponse{ResponseType: "ephemeral", Text: text}
+		if result == "ok" {
+			resp = slackResponse{ReplaceOriginal: true, Text: strings.TrimSpace(p.Message.Text + "\n" + text)}
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(operatorCtx, 15*time.Second)
+			defer cancel()
+			if err := respondSlack(ctx, p.ResponseURL, resp); err != nil {
+				log.Printf("Failed to answer a Slack interaction: %v", err)
+			}
+		}()
+	}
+}
+
+func slackSection(text string) map[string]interface{} {
+	return map[string]interface{}{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": text}}
+}
+
+// decisionButtons is the Approve / Deny actions block of a recommendation
+func decisionButtons(id int) map[string]interface{} {
+	value := strconv.Itoa(id)
+	return map[string]interface{}{
+		"type": "actions",
+		"elements": []map[string]interface{}{
+			{"type": "button", "action_id": "approve", "value": value, "style": "primary",
+				"text": map[string]string{"type": "plain_text", "text": "Approve"}},
+			{"type": "button", "action_id": "deny", "value": value, "style": "danger",
+				"text": map[string]string{"type": "plain_text", "text": "Deny"}},
+		},
+	}
+}
+
+// postRecommendation posts a recommendation to Slack, with buttons when Slack commands are enabled
+func postRecommendation(ctx context.Context, id int, text string) error {
+	if !slackCommandsEnabled() {
+		return postSlack(ctx, text)
+	}
+	return postSlackBlocks(ctx, text, []map[string]interface{}{slackSection(text), decisionButtons(id)})
+}
+
+func init() {
+	registerMetrics(writeSlackCommandMetrics)
+}
+
+func writeSlackCommandMetrics(w io.Writer) {
+	slackCommandMu.Lock()
+	defer slackCommandMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_slack_commands_total Slack slash commands and button presses, by command and result.")
+	fmt.Fprintln(w, "# TYPE selfhealing_slack_commands_total counter")
+	for _, key := range sortedKeys(slackCommands) {
+		command, result, _ := strings.Cut(key, "|")
+		fmt.Fprintf(w, "selfhealing_slack_commands_total{command=%q,result=%q} %d\n", command, result, slackCommands[key])
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Before/after snapshots. Actions that rewrite a workload — redeploy,
+// rollback (resolve_stuck_rollout, the rollback workflow step, the
+// image-pull-backoff runbook) and scale — capture the object's spec before
+// and after the change and keep the difference in the history record, so a
+// reviewer sees exactly what the operator changed:
+//
+//	"changes": [{"kind": "Deployment", "namespace": "shop", "name": "cart",
+//	  "fields": [{"path": "spec.replicas", "before": 3, "after": 4}]}]
+//
+// Paths use the JSON field names; list items with a name (containers, env,
+// volumes) are addressed by it, e.g. spec.template.spec.containers[name=app].image.
+// At most maxFieldChanges fields are kept per object. The changes are part of
+// the signed audit record.
+
+const maxFieldChanges = 100
+
+// ObjectChange is what an action changed in one object's spec
+type ObjectChange struct {
+	Kind      string        `json:"kind"`
+	Namespace string        `json:"namespace"`
+	Name      string        `json:"name"`
+	Fields    []FieldChange `json:"fields"`
+	Truncated bool          `json:"truncated,omitempty"`
+
+	resource schema.GroupVersionResource // for kinds other than the built-in ones, see temporary.go
+}
+
+// FieldChange is one changed field; Before or After is missing when the field was added or removed
+type FieldChange struct {
+	Path   string      `json:"path"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// recordChange adds the difference between two versions of an object's spec
+// to the action; objects are anything that marshals to JSON with a spec
+func recordChange(action *RecoveryAction, kind, namespace, name string, before, after interface{}) {
+	a, err1 := specOf(before)
+	b, err2 := specOf(after)
+	if err1 != nil || err2 != nil {
+		return
+	}
+	var fields []FieldChange
+	diffValues("spec", a, b, &fields)
+	if len(fields) == 0 {
+		return
+	}
+	change := ObjectChange{Kind: kind, Namespace: namespace, Name: name, Fields: fields}
+	if len(fields) > maxFieldChanges {
+		change.Fields, change.Truncated = fields[:maxFieldChanges], true
+	}
+	action.Changes = append(action.Changes, change)
+}
+
+// specOf returns the object's spec as generic JSON values
+func specOf(obj interface{}) (interface{}, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m["spec"], nil
+}
+
+func diffValues(path string, a, b interface{}, out *[]FieldChange) {
+	am, aMap := a.(map[string]interface{})
+	bm, bMap := b.(map[string]interface{})
+	if aMap && bMap {
+		keys := map[string]bool{}
+		for k := range am {
+			keys[k] = true
+		}
+		for k := range bm {
+			keys[k] = true
+		}
+		for _, k := range sortedKeys(keys) {
+			diffValues(path+"."+k, am[k], bm[k], out)
+		}
+		return
+	}
+	as, aList := a.([]interface{})
+	bs, bList := b.([]interface{})
+	if aList && bList {
+		if an, bn, ok := byName(as, bs); ok {
+			names := map[string]bool{}
+			for k := range an {
+				names[k] = true
+			}
+			for k := range bn {
+				names[k] = true
+			}
+			for _, k := range sortedKeys(names) {
+				diffValues(path+"[name="+k+"]", an[k], bn[k], out)
+			}
+			return
+		}
+		n := len(as)
+		if len(bs) > n {
+			n = len(bs)
+		}
+		for i := 0; i < n; i++ {
+			var x, y interface{}
+			if i < len(as) {
+				x = as[i]
+			}
+			if i < len(bs) {
+				y = bs[i]
+			}
+			diffValues(path+"["+strconv.Itoa(i)+"]", x, y, out)
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*out = append(*out, FieldChange{Path: path, Before: a, After: b})
+	}
+}
+
+// byName indexes two lists by their items' name, if every item has a distinct one
+func byName(as, bs []interface{}) (map[string]interface{}, map[string]interface{}, bool) {
+	index := func(list []interface{}) (map[string]interface{}, bool) {
+		m := map[string]interface{}{}
+		for _, item := range list {
+			obj, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			name, ok := obj["name"].(string)
+			if _, dup := m[name]; !ok || dup {
+				return nil, false
+			}
+			m[name] = obj
+		}
+		return m, true
+	}
+	an, ok1 := index(as)
+	bn, ok2 := index(bs)
+	return an, bn, ok1 && ok2 && len(as)+len(bs) > 0
+}
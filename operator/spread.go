@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Spreading mass remediations: when one policy (alertname + action) matches
+// many workloads at once — a node dies and 50 pods crashloop — acting on all
+// of them together hammers the scheduler and image registries. With spreading
+// on, a policy acts on at most SPREAD_BATCH_SIZE workloads per SPREAD_INTERVAL
+// and each action is delayed by a random 0..SPREAD_JITTER on top.
+//
+// The alert rule can override these with the annotations spread_batch_size,
+// spread_interval and spread_jitter. Workloads whose action is scheduled for
+// later skip new alerts until it has run.
+
+type spreadConfig struct {
+	batchSize int
+	interval  time.Duration
+	jitter    time.Duration
+}
+
+var (
+	defaultSpread = spreadConfig{
+		batchSize: envInt("SPREAD_BATCH_SIZE", 0),
+		interval:  envDuration("SPREAD_INTERVAL", 30*time.Second),
+		jitter:    envDuration("SPREAD_JITTER", 0),
+	}
+
+	spreadMu      sync.Mutex
+	spreadSlots   = map[string]*spreadSlot{} // policy -> current batch
+	spreadPending = map[string]time.Time{}   // workload -> when its action runs
+	spreadDelayed int                        // actions that were delayed
+)
+
+// spreadSlot is the batch a policy is currently filling
+type spreadSlot struct {
+	start time.Time
+	used  int
+}
+
+func init() {
+	registerMetrics(writeSpreadMetrics)
+}
+
+// currentSpread returns the defaults, which the tuning API can change
+func currentSpread() spreadConfig {
+	spreadMu.Lock()
+	defer spreadMu.Unlock()
+	return defaultSpread
+}
+
+func setSpread(fn func(*spreadConfig)) {
+	spreadMu.Lock()
+	fn(&defaultSpread)
+	spreadMu.Unlock()
+}
+
+// spreadConfigFor applies the alert's annotations to the defaults
+func spreadConfigFor(alert Alert) spreadConfig {
+	cfg := currentSpread()
+	if v := alert.Annotations["spread_batch_size"]; v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.batchSize = n
+		}
+	}
+	if v := alert.Annotations["spread_interval"]; v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.interval = d
+		}
+	}
+	if v := alert.Annotations["spread_jitter"]; v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.jitter = d
+		}
+	}
+	return cfg
+}
+
+// spreadDelay returns how long to wait before acting on a workload and
+// claims its place in the policy's batches
+func spreadDelay(action *RecoveryAction, alert Alert) time.Duration {
+	cfg := spreadConfigFor(alert)
+	if cfg.batchSize <= 0 && cfg.jitter <= 0 {
+		return 0
+	}
+
+	spreadMu.Lock()
+	defer spreadMu.Unlock()
+	now := time.Now()
+	var delay time.Duration
+	if cfg.batchSize > 0 && cfg.interval > 0 {
+		key := policyKey(action)
+		slot := spreadSlots[key]
+		if slot == nil || now.Sub(slot.start) >= cfg.interval {
+			// the previous batch is over
+			slot = &spreadSlot{start: now}
+			spreadSlots[key] = slot
+		} else if slot.used >= cfg.batchSize {
+			slot.start = slot.start.Add(cfg.interval)
+			slot.used = 0
+		}
+		slot.used++
+		if slot.start.After(now) {
+			delay = slot.start.Sub(now)
+		}
+	}
+	if cfg.jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(cfg.jitter)))
+	}
+	return delay
+}
+
+// spreadScheduled returns when the workload's delayed action runs, if one is waiting
+func spreadScheduled(key string) (time.Time, bool) {
+	spreadMu.Lock()
+	defer spreadMu.Unlock()
+	at, ok := spreadPending[key]
+	return at, ok
+}
+
+// runSpread runs a workload's jobs after the delay, in the background
+func runSpread(key string, list []workloadJob, delay time.Duration) {
+	spreadMu.Lock()
+	spreadPending[key] = time.Now().Add(delay)
+	spreadDelayed++
+	spreadMu.Unlock()
+	log.Printf("Delaying '%s' for %s by %s to spread out policy %s",
+		list[0].action.Action, key, delay.Round(time.Millisecond), policyKey(list[0].action))
+
+	go func() {
+		select {
+		case <-time.After(delay):
+		case <-operatorCtx.Done():
+		}
+		spreadMu.Lock()
+		delete(spreadPending, key)
+		spreadMu.Unlock()
+		runWorkloadJobs(operatorCtx, key, list)
+	}()
+}
+
+func writeSpreadMetrics(w io.Writer) {
+	spreadMu.Lock()
+	defer spreadMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_spread_pending Actions waiting for their batch.")
+	fmt.Fprintln(w, "# TYPE selfhealing_spread_pending gauge")
+	fmt.Fprintf(w, "selfhealing_spread_pending %d\n", len(spreadPending))
+	fmt.Fprintln(w, "# HELP selfhealing_spread_delayed_total Actions delayed to spread out mass remediations.")
+	fmt.Fprintln(w, "# TYPE selfhealing_spread_delayed_total counter")
+	fmt.Fprintf(w, "selfhealing_spread_delayed_total %d\n", spreadDelayed)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func resetSpread(t *testing.T, cfg spreadConfig) {
+	spreadMu.Lock()
+	savedCfg, savedSlots := defaultSpread, spreadSlots
+	defaultSpread, spreadSlots = cfg, map[string]*spreadSlot{}
+	spreadMu.Unlock()
+	t.Cleanup(func() {
+		spreadMu.Lock()
+		defaultSpread, spreadSlots = savedCfg, savedSlots
+		spreadMu.Unlock()
+	})
+}
+
+func TestSpreadConfigFor(t *testing.T) {
+	resetSpread(t, spreadConfig{batchSize: 5, interval: time.Minute})
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        spreadConfig
+	}{
+		{"defaults", nil, spreadConfig{batchSize: 5, interval: time.Minute}},
+		{"all overridden",
+			map[string]string{"spread_batch_size": "2", "spread_interval": "10s", "spread_jitter": "3s"},
+			spreadConfig{batchSize: 2, interval: 10 * time.Second, jitter: 3 * time.Second}},
+		{"zero batch turns batching off", map[string]string{"spread_batch_size": "0"}, spreadConfig{interval: time.Minute}},
+		{"unparsable values are ignored",
+			map[string]string{"spread_batch_size": "many", "spread_interval": "soon"},
+			spreadConfig{batchSize: 5, interval: time.Minute}},
+	}
+	for _, tt := range tests {
+		if got := spreadConfigFor(Alert{Annotations: tt.annotations}); got != tt.want {
+			t.Errorf("%s: config = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSpreadDelayBatches(t *testing.T) {
+	resetSpread(t, spreadConfig{batchSize: 2, interval: time.Minute})
+	restart := &RecoveryAction{AlertName: "PodCrashLooping", Action: "restart"}
+	scale := &RecoveryAction{AlertName: "PodCrashLooping", Action: "scale"}
+
+	tests := []struct {
+		name   string
+		action *RecoveryAction
+		alert  Alert
+		want   time.Duration // the delay, to the nearest interval
+	}{
+		{"first of the batch", restart, Alert{}, 0},
+		{"second of the batch", restart, Alert{}, 0},
+		{"third waits for the next batch", restart, Alert{}, time.Minute},
+		{"fourth shares it", restart, Alert{}, time.Minute},
+		{"fifth waits two batches", restart, Alert{}, 2 * time.Minute},
+		{"another policy has its own batches", scale, Alert{}, 0},
+		{"spreading off for this rule", restart, Alert{Annotations: map[string]string{"spread_batch_size": "0"}}, 0},
+	}
+	for _, tt := range tests {
+		got := spreadDelay(tt.action, tt.alert).Round(time.Minute)
+		if got != tt.want {
+			t.Errorf("%s: delay = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSpreadDelayJitter(t *testing.T) {
+	resetSpread(t, spreadConfig{jitter: 50 * time.Millisecond})
+	action := &RecoveryAction{AlertName: "PodCrashLooping", Action: "restart"}
+	for i := 0; i < 100; i++ {
+		if d := spreadDelay(action, Alert{}); d < 0 || d >= 50*time.Millisecond {
+			t.Fatalf("delay = %s, want within the jitter", d)
+		}
+	}
+}
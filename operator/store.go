@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+// Store persists operator state so it survives restarts: cooldowns, action
+// history, disabled policies (the effectiveness circuit breaker), suppressions
+// and when workloads were onboarded (observation.go). The
+// in-memory maps stay the source of truth while running; the store is
+// written through on every change and read once at startup.
+//
+// STORE selects the backend:
+//   - memory   (default) nothing is persisted
+//   - crd      one SelfHealingState custom resource, for small clusters
+//   - postgres database/sql with STORE_DSN, for high-volume installations
+//   - sqlite   database/sql with STORE_DSN as the file path (needs a build
+//     with -tags sqlite and CGO_ENABLED=1)
+type Store interface {
+	Name() string
+
+	SaveCooldown(ctx context.Context, key string, at time.Time) error
+	LoadCooldowns(ctx context.Context) (map[string]time.Time, error)
+
+	AppendHistory(ctx context.Context, rec ActionRecord) error
+	LoadHistory(ctx context.Context, limit int) ([]ActionRecord, error) // oldest first
+
+	SavePolicyDisabled(ctx context.Context, policy string, disabled bool) error
+	LoadDisabledPolicies(ctx context.Context) ([]string, error)
+
+	SaveTuning(ctx context.Context, settings map[string]string) error
+	LoadTuning(ctx context.Context) (map[string]string, error)
+
+	SaveOnboarded(ctx context.Context, workload string, at time.Time) error
+	LoadOnboarded(ctx context.Context) (map[string]time.Time, error)
+
+	SaveSuppression(ctx context.Context, s Suppression) error
+	DeleteSuppression(ctx context.Context, id int) error
+	LoadSuppressions(ctx context.Context) ([]Suppression, error)
+}
+
+var store Store = memoryStore{}
+
+// sqlDrivers maps STORE values to database/sql driver names; drivers register
+// themselves here from files guarded by build tags
+var sqlDrivers = map[string]string{}
+
+const storeTimeout = 5 * time.Second
+
+func setupStore(config *rest.Config) error {
+	kind := envString("STORE", "memory")
+	var err error
+	switch kind {
+	case "memory":
+		return nil
+	case "crd":
+		store, err = newCRDStore(config, envString("POD_NAMESPACE", "default"))
+	default:
+		driver, ok := sqlDrivers[kind]
+		if !ok {
+			return fmt.Errorf("unknown STORE %q", kind)
+		}
+		store, err = newSQLStore(kind, driver, os.Getenv("STORE_DSN"))
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Using %s store for operator state", store.Name())
+	return restoreState()
+}
+
+// restoreState loads persisted state into the in-memory maps
+func restoreState() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cooldowns, err := store.LoadCooldowns(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load cooldowns: %v", err)
+	}
+	cooldownMu.Lock()
+	for k, v := range cooldowns {
+		lastAction[k] = v
+	}
+	cooldownMu.Unlock()
+
+	recs, err := store.LoadHistory(ctx, historySize)
+	if err != nil {
+		return fmt.Errorf("failed to load history: %v", err)
+	}
+	historyMu.Lock()
+	history = recs
+	historyMu.Unlock()
+	restoreAuditChain(recs)
+	restoreRemediationIndex(recs)
+
+	disabled, err := store.LoadDisabledPolicies(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load disabled policies: %v", err)
+	}
+	effectiveness.restoreDisabled(disabled)
+
+	tuning, err := store.LoadTuning(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load tuning: %v", err)
+	}
+	restoreTuning(tuning)
+
+	onboarded, err := store.LoadOnboarded(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load onboarded workloads: %v", err)
+	}
+	observation.restore(onboarded, recs)
+
+	supps, err := store.LoadSuppressions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load suppressions: %v", err)
+	}
+	restoreSuppressions(supps)
+
+	log.Printf("Restored %d cooldown(s), %d history record(s), %d disabled polic(ies), %d tuned setting(s), %d onboarded workload(s), %d suppression(s)",
+		len(cooldowns), len(recs), len(disabled), len(tuning), len(onboarded), len(supps))
+	return nil
+}
+
+// persist runs a store write with a timeout and logs failures; state is
+// already updated in memory, so a failed write only loses it on restart
+func persist(what string, fn func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	if err := fn(ctx); err != nil {
+		log.Printf("Failed to persist %s to %s store: %v", what, store.Name(), err)
+	}
+}
+
+// memoryStore persists nothing
+type memoryStore struct{}
+
+func (memoryStore) Name() string { return "memory" }
+
+func (memoryStore) SaveCooldown(context.Context, string, time.Time) error { return nil }
+
+func (memoryStore) LoadCooldowns(context.Context) (map[string]time.Time, error) { return nil, nil }
+
+func (memoryStore) AppendHistory(context.Context, ActionRecord) error { return nil }
+
+func (memoryStore) LoadHistory(context.Context, int) ([]ActionRecord, error) { return nil, nil }
+
+func (memoryStore) SavePolicyDisabled(context.Context, string, bool) error { return nil }
+
+func (memoryStore) LoadDisabledPolicies(context.Context) ([]string, error) { return nil, nil }
+
+func (memoryStore) SaveTuning(context.Context, map[string]string) error { return nil }
+
+func (memoryStore) LoadTuning(context.Context) (map[string]string, error) { return nil, nil }
+
+func (memoryStore) SaveOnboarded(context.Context, string, time.Time) error { return nil }
+
+func (memoryStore) LoadOnboarded(context.Context) (map[string]time.Time, error) { return nil, nil }
+
+func (memoryStore) SaveSuppression(context.Context, Suppression) error { return nil }
+
+func (memoryStore) DeleteSuppression(context.Context, int) error { return nil }
+
+func (memoryStore) LoadSuppressions(context.Context) ([]Suppression, error) { return nil, nil }
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
+)
+
+// crdStore keeps all state in a single SelfHealingState object
+// (manifests/operator/crd-selfhealingstate.yaml). Objects in etcd are capped
+// at ~1.5MB, so only the newest CRD_HISTORY_SIZE history records are kept —
+// use the SQL store for high-volume installations.
+type crdStore struct {
+	client      dynamic.ResourceInterface
+	name        string
+	historySize int
+}
+
+var selfHealingStateGVR = schema.GroupVersionResource{
+	Group:    "selfhealing.io",
+	Version:  "v1alpha1",
+	Resource: "selfhealingstates",
+}
+
+// crdState is the spec of the SelfHealingState object
+type crdState struct {
+	Cooldowns        map[string]time.Time `json:"cooldowns,omitempty"`
+	History          []ActionRecord       `json:"history,omitempty"`
+	DisabledPolicies []string             `json:"disabledPolicies,omitempty"`
+	Tuning           map[string]string    `json:"tuning,omitempty"`
+	Onboarded        map[string]time.Time `json:"onboarded,omitempty"`
+	Suppressions     []Suppression        `json:"suppressions,omitempty"`
+}
+
+func newCRDStore(config *rest.Config, namespace string) (*crdStore, error) {
+	dyn, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %v", err)
+	}
+	return &crdStore{
+		client:      dyn.Resource(selfHealingStateGVR).Namespace(namespace),
+		name:        envString("STORE_CRD_NAME", "operator-state"),
+		historySize: envInt("CRD_HISTORY_SIZE", 100),
+	}, nil
+}
+
+func (s *crdStore) Name() string { return "crd" }
+
+// get returns the state object, or a new unsaved one if it doesn't exist yet
+func (s *crdStore) get(ctx context.Context) (*unstructured.Unstructured, *crdState, error) {
+	obj, err := s.client.Get(ctx, s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		obj = &unstructured.Unstructured{}
+		obj.SetAPIVersion(selfHealingStateGVR.Group + "/" + selfHealingStateGVR.Version)
+		obj.SetKind("SelfHealingState")
+		obj.SetName(s.name)
+		return obj, &crdState{}, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	st := &crdState{}
+	if spec, ok := obj.Object["spec"]; ok {
+		data, err := json.Marshal(spec)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := json.Unmarshal(data, st); err != nil {
+			return nil, nil, fmt.Errorf("invalid SelfHealingState spec: %v", err)
+		}
+	}
+	return obj, st, nil
+}
+
+// update applies fn to the state with optimistic concurrency
+func (s *crdStore) update(ctx context.Context, fn func(st *crdState)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj, st, err := s.get(ctx)
+		if err != nil {
+			return err
+		}
+		fn(st)
+
+		data, err := json.Marshal(st)
+		if err != nil {
+			return err
+		}
+		var spec map[string]interface{}
+		if err := json.Unmarshal(data, &spec); err != nil {
+			return err
+		}
+		obj.Object["spec"] = spec
+
+		if obj.GetResourceVersion() == "" {
+			_, err = s.client.Create(ctx, obj, metav1.CreateOptions{})
+		} else {
+			_, err = s.client.Update(ctx, obj, metav1.UpdateOptions{})
+		}
+		return err
+	})
+}
+
+func (s *crdStore) SaveCooldown(ctx context.Context, key string, at time.Time) error {
+	return s.update(ctx, func(st *crdState) {
+		if st.Cooldowns == nil {
+			st.Cooldowns = map[string]time.Time{}
+		}
+		st.Cooldowns[key] = at
+		// drop expired entries so the object doesn't grow forever
+		cooldown := currentCooldown()
+		for k, v := range st.Cooldowns {
+			if time.Since(v) > cooldown {
+				delete(st.Cooldowns, k)
+			}
+		}
+	})
+}
+
+func (s *crdStore) LoadCooldowns(ctx context.Context) (map[string]time.Time, error) {
+	_, st, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return st.Cooldowns, nil
+}
+
+func (s *crdStore) AppendHistory(ctx context.Context, rec ActionRecord) error {
+	return s.update(ctx, func(st *crdState) {
+		st.History = append(st.History, rec)
+		if len(st.History) > s.historySize {
+			st.History = st.History[len(st.History)-s.historySize:]
+		}
+	})
+}
+
+func (s *crdStore) LoadHistory(ctx context.Context, limit int) ([]ActionRecord, error) {
+	_, st, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(st.History) > limit {
+		return st.History[len(st.History)-limit:], nil
+	}
+	return st.History, nil
+}
+
+func (s *crdStore) SavePolicyDisabled(ctx context.Context, policy string, disabled bool) error {
+	return s.update(ctx, func(st *crdState) {
+		set := map[string]bool{}
+		for _, p := range st.DisabledPolicies {
+			set[p] = true
+		}
+		if disabled {
+			set[policy] = true
+		} else {
+			delete(set, policy)
+		}
+		st.DisabledPolicies = st.DisabledPolicies[:0]
+		for p := range set {
+			st.DisabledPolicies = append(st.DisabledPolicies, p)
+		}
+		sort.Strings(st.DisabledPolicies)
+	})
+}
+
+func (s *crdStore) LoadDisabledPolicies(ctx context.Context) ([]string, error) {
+	_, st, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return st.DisabledPolicies, nil
+}
+
+func (s *crdStore) SaveTuning(ctx context.Context, settings map[string]string) error {
+	return s.update(ctx, func(st *crdState) { st.Tuning = settings })
+}
+
+func (s *crdStore) LoadTuning(ctx context.Context) (map[string]string, error) {
+	_, st, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return st.Tuning, nil
+}
+
+func (s *crdStore) SaveOnboarded(ctx context.Context, workload string, at time.Time) error {
+	return s.update(ctx, func(st *crdState) {
+		if st.Onboarded == nil {
+			st.Onboarded = map[string]time.Time{}
+		}
+		st.Onboarded[workload] = at
+	})
+}
+
+func (s *crdStore) LoadOnboarded(ctx context.Context) (map[string]time.Time, error) {
+	_, st, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return st.Onboarded, nil
+}
+
+// SaveSuppression adds or replaces a suppression, dropping expired ones
+func (s *crdStore) SaveSuppression(ctx context.Context, sup Suppression) error {
+	return s.update(ctx, func(st *crdState) {
+		st.Suppressions = unexpiredSuppressions(st.Suppressions, sup.ID)
+		st.Suppressions = append(st.Suppressions, sup)
+	})
+}
+
+func (s *crdStore) DeleteSuppression(ctx context.Context, id int) error {
+	return s.update(ctx, func(st *crdState) {
+		st.Suppressions = unexpiredSuppressions(st.Suppressions, id)
+	})
+}
+
+func (s *crdStore) LoadSuppressions(ctx context.Context) ([]Suppression, error) {
+	_, st, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return unexpiredSuppressions(st.Suppressions, 0), nil
+}
+
+// unexpiredSuppressions keeps the suppressions that haven't expired, except id
+func unexpiredSuppressions(supps []Suppression, id int) []Suppression {
+	now := time.Now()
+	var out []Suppression
+	for _, sup := range supps {
+		if sup.ID != id && now.Before(sup.ExpiresAt) {
+			out = append(out, sup)
+		}
+	}
+	return out
+}
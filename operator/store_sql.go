@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// sqlStore keeps state in six tables, created on startup if missing.
+// The same statements run on PostgreSQL and SQLite; only the placeholder
+// syntax differs.
+type sqlStore struct {
+	kind string
+	db   *sql.DB
+}
+
+func init() {
+	sqlDrivers["postgres"] = "postgres"
+}
+
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS selfhealing_cooldowns (
+		key TEXT PRIMARY KEY,
+		at  TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS selfhealing_history (
+		id         INTEGER PRIMARY KEY,
+		started_at TIMESTAMP NOT NULL,
+		record     TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS selfhealing_disabled_policies (
+		policy TEXT PRIMARY KEY
+	)`,
+	`CREATE TABLE IF NOT EXISTS selfhealing_tuning (
+		name  TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS selfhealing_onboarded (
+		workload TEXT PRIMARY KEY,
+		at       TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS selfhealing_suppressions (
+		id          INTEGER PRIMARY KEY,
+		expires_at  TIMESTAMP NOT NULL,
+		suppression TEXT NOT NULL
+	)`,
+}
+
+func newSQLStore(kind, driver, dsn string) (*sqlStore, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("STORE_DSN is required for the %s store", kind)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s store: %v", kind, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for _, stmt := range sqlSchema {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create %s schema: %v", kind, err)
+		}
+	}
+	return &sqlStore{kind: kind, db: db}, nil
+}
+
+func (s *sqlStore) Name() string { return s.kind }
+
+// q rewrites ? placeholders to $n for PostgreSQL
+func (s *sqlStore) q(query string) string {
+	if s.kind != "postgres" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *sqlStore) SaveCooldown(ctx context.Context, key string, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.q(`INSERT INTO selfhealing_cooldowns (key, at) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET at = excluded.at`), key, at.UTC())
+	return err
+}
+
+func (s *sqlStore) LoadCooldowns(ctx context.Context) (map[string]time.Time, error) {
+	rows, err := s.db.QueryContext(ctx, s.q(`SELECT key, at FROM selfhealing_cooldowns WHERE at > ?`),
+		time.Now().Add(-currentCooldown()).UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]time.Time{}
+	for rows.Next() {
+		var key string
+		var at time.Time
+		if err := rows.Scan(&key, &at); err != nil {
+			return nil, err
+		}
+		out[key] = at
+	}
+	return out, rows.Err()
+}
+
+func (s *sqlStore) AppendHistory(ctx context.Context, rec ActionRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, s.q(`INSERT INTO selfhealing_history (id, started_at, record) VALUES (?, ?, ?)`),
+		rec.ID, rec.StartedAt.UTC(), string(data))
+	return err
+}
+
+func (s *sqlStore) LoadHistory(ctx context.Context, limit int) ([]ActionRecord, error) {
+	rows, err := s.db.QueryContext(ctx, s.q(`SELECT record FROM selfhealing_history ORDER BY id DESC LIMIT ?`), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ActionRecord
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var rec ActionRecord
+		if err := json.Unmarshal([]byte(data), &rec); err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	// reverse to oldest first
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out, rows.Err()
+}
+
+func (s *sqlStore) SavePolicyDisabled(ctx context.Context, policy string, disabled bool) error {
+	var err error
+	if disabled {
+		_, err = s.db.ExecContext(ctx, s.q(`INSERT INTO selfhealing_disabled_policies (policy) VALUES (?)
+			ON CONFLICT (policy) DO NOTHING`), policy)
+	} else {
+		_, err = s.db.ExecContext(ctx, s.q(`DELETE FROM selfhealing_disabled_policies WHERE policy = ?`), policy)
+	}
+	return err
+}
+
+func (s *sqlStore) LoadDisabledPolicies(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT policy FROM selfhealing_disabled_policies ORDER BY policy`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var p string
+		if err := rows.Scan(&p); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqlStore) SaveTuning(ctx context.Context, settings map[string]string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for name, value := range settings {
+		if _, err := tx.ExecContext(ctx, s.q(`INSERT INTO selfhealing_tuning (name, value) VALUES (?, ?)
+			ON CONFLICT (name) DO UPDATE SET value = excluded.value`), name, value); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) LoadTuning(ctx context.Context) (map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT name, value FROM selfhealing_tuning`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]string{}
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		out[name] = value
+	}
+	return out, rows.Err()
+}
+
+func (s *sqlStore) SaveOnboarded(ctx context.Context, workload string, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.q(`INSERT INTO selfhealing_onboarded (workload, at) VALUES (?, ?)
+		ON CONFLICT (workload) DO UPDATE SET at = excluded.at`), workload, at.UTC())
+	return err
+}
+
+func (s *sqlStore) LoadOnboarded(ctx context.Context) (map[string]time.Time, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT workload, at FROM selfhealing_onboarded`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]time.Time{}
+	for rows.Next() {
+		var workload string
+		var at time.Time
+		if err := rows.Scan(&workload, &at); err != nil {
+			return nil, err
+		}
+		out[workload] = at
+	}
+	return out, rows.Err()
+}
+
+// SaveSuppression adds or replaces a suppression, dropping expired ones
+func (s *sqlStore) SaveSuppression(ctx context.Context, sup Suppression) error {
+	data, err := json.Marshal(sup)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, s.q(`DELETE FROM selfhealing_suppressions WHERE expires_at <= ?`), time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, s.q(`INSERT INTO selfhealing_suppressions (id, expires_at, suppression) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET expires_at = excluded.expires_at, suppression = excluded.suppression`), sup.ID, sup.ExpiresAt.UTC(), string(data))
+	return err
+}
+
+func (s *sqlStore) DeleteSuppression(ctx context.Context, id int) error {
+	_, err := s.db.ExecContext(ctx, s.q(`DELETE FROM selfhealing_suppressions WHERE id = ?`), id)
+	return err
+}
+
+// LoadSuppressions returns the unexpired suppressions, oldest first
+func (s *sqlStore) LoadSuppressions(ctx context.Context) ([]Suppression, error) {
+	rows, err := s.db.QueryContext(ctx, s.q(`SELECT suppression FROM selfhealing_suppressions WHERE expires_at > ? ORDER BY id`), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Suppression
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var sup Suppression
+		if err := json.Unmarshal([]byte(data), &sup); err != nil {
+			return nil, fmt.Errorf("invalid suppression record: %v", err)
+		}
+		out = append(out, sup)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,14 @@
+//go:build sqlite
+
+package main
+
+// SQLite support needs cgo, so it is only compiled in with
+//   CGO_ENABLED=1 go build -tags sqlite
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	sqlDrivers["sqlite"] = "sqlite3"
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Stuck rollouts. A Deployment whose new ReplicaSet doesn't become available
+// within progressDeadlineSeconds gets Progressing=False with reason
+// ProgressDeadlineExceeded, and then stays half-rolled: Kubernetes never rolls
+// back on its own. `recovery_action: resolve_stuck_rollout`
+//   - pauses the Deployment so the rollout makes no further progress
+//   - rolls back to the last ready ReplicaSet: the newest older revision that
+//     still has ready pods, else the previous revision
+//   - resumes it so the rollback rolls out, and notifies the escalation channels
+//
+// A Deployment that isn't stuck (any more) is left alone. The alert names the
+// Deployment with a `deployment` label (kube-state-metrics) or an `app` label.
+// With STUCK_ROLLOUT_WATCH=true the operator also watches Deployment
+// conditions itself (needs the informer cache) and feeds a
+// DeploymentRolloutStuck alert into the normal decision path, so cooldowns,
+// suppressions and workload annotations still apply.
+
+const stuckRolloutAlertName = "DeploymentRolloutStuck"
+
+var stuckRolloutWatch = envBool("STUCK_ROLLOUT_WATCH", false)
+
+// rolloutStuck reports whether the Deployment's rollout exceeded its progress deadline
+func rolloutStuck(dep *appsv1.Deployment) bool {
+	for _, c := range dep.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing {
+			return c.Status == corev1.ConditionFalse && c.Reason == "ProgressDeadlineExceeded"
+		}
+	}
+	return false
+}
+
+// stuckDeployment finds the Deployment the alert is about
+func stuckDeployment(ctx context.Context, cs kubernetes.Interface, action *RecoveryAction) (*appsv1.Deployment, error) {
+	if name := action.Labels["deployment"]; name != "" {
+		dep, err := cs.AppsV1().Deployments(action.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get deployment %s/%s: %v", action.Namespace, name, err)
+		}
+		return dep, nil
+	}
+	return findDeployment(ctx, cs, action.Namespace, action.App)
+}
+
+// lastReadyReplicaSet picks the rollback target among the replica sets older than the current revision
+func lastReadyReplicaSet(owned []appsv1.ReplicaSet, current int) *appsv1.ReplicaSet {
+	var previous *appsv1.ReplicaSet
+	for i := range owned {
+		if rsRevision(owned[i]) >= current {
+			continue
+		}
+		if owned[i].Status.ReadyReplicas > 0 {
+			return &owned[i]
+		}
+		if previous == nil {
+			previous = &owned[i]
+		}
+	}
+	return previous
+}
+
+func resolveStuckRollout(ctx context.Context, c *Clients, action *RecoveryAction, alert Alert) error {
+	cs, err := c.For(ctx, action.Namespace)
+	if err != nil {
+		return err
+	}
+	dep, err := stuckDeployment(ctx, cs, action)
+	if err != nil {
+		return err
+	}
+	if !rolloutStuck(dep) {
+		log.Printf("Rollout of %s/%s isn't past its progress deadline — nothing to do", dep.Namespace, dep.Name)
+		return nil
+	}
+
+	owned, err := ownedReplicaSets(ctx, cs, dep)
+	if err != nil {
+		return err
+	}
+	current, _ := strconv.Atoi(dep.Annotations["deployment.kubernetes.io/revision"])
+	target := lastReadyReplicaSet(owned, current)
+	if target == nil {
+		return fmt.Errorf("rollout of %s/%s is stuck and there is no earlier revision to roll back to", dep.Namespace, dep.Name)
+	}
+	template := rollbackTemplate(target)
+	if err := verifyPodImages(ctx, template.Spec); err != nil {
+		return fmt.Errorf("refusing to roll back %s/%s: %v", dep.Namespace, dep.Name, err)
+	}
+
+	deployments := cs.AppsV1().Deployments(dep.Namespace)
+	paused, err := deployments.Patch(ctx, dep.Name, types.StrategicMergePatchType, []byte(`{"spec":{"paused":true}}`), metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to pause deployment %s/%s: %v", dep.Namespace, dep.Name, err)
+	}
+	log.Printf("Paused stuck rollout of %s/%s at revision %d", dep.Namespace, dep.Name, current)
+
+	paused.Spec.Template = *template
+	paused.Spec.Paused = false
+	updated, err := deployments.Update(ctx, paused, metav1.UpdateOptions{})
+	if err != nil {
+		// leave it paused rather than let the broken rollout carry on
+		return fmt.Errorf("deployment %s/%s is paused but the rollback failed: %v", dep.Namespace, dep.Name, err)
+	}
+	recordChange(action, "Deployment", dep.Namespace, dep.Name, dep, updated)
+	log.Printf("Deployment %s/%s rolled back from revision %d to %d", dep.Namespace, dep.Name, current, rsRevision(*target))
+	action.rollout = &rolloutWatch{cs: cs, namespace: dep.Namespace, name: updated.Name, generation: updated.Generation}
+	emitDeployMarker(action, updated.Name, updated.Generation)
+
+	escalate(action, alert, fmt.Sprintf("rollout of %s/%s exceeded its progress deadline; rolled back from revision %d to %d (%d ready pods)",
+		dep.Namespace, dep.Name, current, rsRevision(*target), target.Status.ReadyReplicas))
+	return nil
+}
+
+// watchStuckRollouts raises a DeploymentRolloutStuck alert when a Deployment's
+// rollout passes its progress deadline
+func watchStuckRollouts(informer cache.SharedIndexInformer) {
+	if !stuckRolloutWatch {
+		return
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			before, ok1 := oldObj.(*appsv1.Deployment)
+			after, ok2 := newObj.(*appsv1.Deployment)
+			if !ok1 || !ok2 || rolloutStuck(before) || !rolloutStuck(after) {
+				return
+			}
+			if shardingEnabled {
+				if owner, _ := shards.owner(after.Namespace); owner != shards.self {
+					return
+				}
+			}
+			log.Printf("Rollout of %s/%s exceeded its progress deadline", after.Namespace, after.Name)
+			go processAlerts(operatorCtx, []Alert{stuckRolloutAlert(after)})
+		},
+	})
+	log.Printf("Watching Deployments for rollouts stuck past their progress deadline")
+}
+
+func stuckRolloutAlert(dep *appsv1.Deployment) Alert {
+	app := dep.Labels["app"]
+	if dep.Spec.Selector != nil && dep.Spec.Selector.MatchLabels["app"] != "" {
+		app = dep.Spec.Selector.MatchLabels["app"]
+	}
+	return Alert{
+		Labels: map[string]string{
+			"alertname":       stuckRolloutAlertName,
+			"severity":        "warning",
+			"namespace":       dep.Namespace,
+			"deployment":      dep.Name,
+			"app":             app,
+			"recovery_action": "resolve_stuck_rollout",
+		},
+		Annotations: map[string]string{
+			"summary": fmt.Sprintf("Rollout of %s/%s exceeded its progress deadline", dep.Namespace, dep.Name),
+		},
+		Status:   "firing",
+		StartsAt: time.Now(),
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Suppressions ("ignore rules") temporarily stop remediation for an
+// alertname + target while someone debugs it by hand. They expire on their
+// own; an empty AlertName or App matches any.
+//
+// Every firing alert is checked against them, so they're indexed by
+// namespace and expired rules are only swept once the earliest has expired:
+// the lookup costs the rules of one namespace, not all of them.
+//
+// Rules are saved to the store, so with a persistent STORE they survive a
+// restart and replicas sharing the store start with the same rules.
+
+// Suppression is one active ignore rule
+type Suppression struct {
+	ID        int       `json:"id"`
+	AlertName string    `json:"alertname,omitempty"`
+	Namespace string    `json:"namespace"`
+	App       string    `json:"app,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// suppressionRequest is the body of POST /api/v1/suppressions
+type suppressionRequest struct {
+	AlertName string `json:"alertname"`
+	Namespace string `json:"namespace"`
+	App       string `json:"app"`
+	Duration  string `json:"duration"` // e.g. "2h"
+	Reason    string `json:"reason"`
+}
+
+// longest suppression accepted, so a forgotten rule can't disable healing forever
+const maxSuppression = 7 * 24 * time.Hour
+
+var (
+	suppressionMu     sync.Mutex
+	suppressions      = map[int]*Suppression{}
+	suppressionsByNS  = map[string][]*Suppression{} // namespace -> rules, for activeSuppression
+	nextSuppressionID = 1
+	nextExpiry        time.Time // earliest ExpiresAt, zero with no rules
+)
+
+func (s *Suppression) matches(action *RecoveryAction) bool {
+	return (s.AlertName == "" || s.AlertName == action.AlertName) &&
+		s.Namespace == action.Namespace &&
+		(s.App == "" || s.App == action.App)
+}
+
+// expireSuppressionsLocked drops expired rules. Caller must hold suppressionMu.
+func expireSuppressionsLocked(now time.Time) {
+	if nextExpiry.IsZero() || now.Before(nextExpiry) {
+		return
+	}
+	for id, s := range suppressions {
+		if !now.Before(s.ExpiresAt) {
+			log.Printf("Suppression #%d expired (%s on %s/%s)", id, s.AlertName, s.Namespace, s.App)
+			delete(suppressions, id) // stores drop expired rules themselves
+		}
+	}
+	reindexSuppressionsLocked()
+}
+
+// reindexSuppressionsLocked rebuilds the namespace index and the next expiry. Caller must hold suppressionMu.
+func reindexSuppressionsLocked() {
+	suppressionsByNS = map[string][]*Suppression{}
+	nextExpiry = time.Time{}
+	for id := 1; id < nextSuppressionID; id++ {
+		s, ok := suppressions[id]
+		if !ok {
+			continue
+		}
+		suppressionsByNS[s.Namespace] = append(suppressionsByNS[s.Namespace], s)
+		if nextExpiry.IsZero() || s.ExpiresAt.Before(nextExpiry) {
+			nextExpiry = s.ExpiresAt
+		}
+	}
+}
+
+// activeSuppression returns the rule suppressing this action, if any
+func activeSuppression(action *RecoveryAction) *Suppression {
+	suppressionMu.Lock()
+	defer suppressionMu.Unlock()
+	expireSuppressionsLocked(time.Now())
+	for _, s := range suppressionsByNS[action.Namespace] {
+		if s.matches(action) {
+			return s
+		}
+	}
+	return nil
+}
+
+func addSuppression(req suppressionRequest) (*Suppression, error) {
+	d, err := time.ParseDuration(req.Duration)
+	if err != nil {
+		return nil, fmt.Errorf("invalid duration %q: %v", req.Duration, err)
+	}
+	if d <= 0 || d > maxSuppression {
+		return nil, fmt.Errorf("duration must be between 0 and %s", maxSuppression)
+	}
+	if req.AlertName == "" && req.App == "" {
+		return nil, fmt.Errorf("at least one of alertname or app is required")
+	}
+	if req.Namespace == "" {
+		req.Namespace = "default"
+	}
+
+	suppressionMu.Lock()
+	now := time.Now()
+	s := &Suppression{
+		ID:        nextSuppressionID,
+		AlertName: req.AlertName,
+		Namespace: req.Namespace,
+		App:       req.App,
+		Reason:    req.Reason,
+		CreatedAt: now,
+		ExpiresAt: now.Add(d),
+	}
+	nextSuppressionID++
+	suppressions[s.ID] = s
+	suppressionsByNS[s.Namespace] = append(suppressionsByNS[s.Namespace], s)
+	if nextExpiry.IsZero() || s.ExpiresAt.Before(nextExpiry) {
+		nextExpiry = s.ExpiresAt
+	}
+	saved := *s
+	suppressionMu.Unlock()
+	log.Printf("Suppression #%d added: alert '%s' on %s/%s for %s (%s)",
+		s.ID, s.AlertName, s.Namespace, s.App, d, s.Reason)
+	persist("suppression", func(ctx context.Context) error { return store.SaveSuppression(ctx, saved) })
+	return &saved, nil
+}
+
+func listSuppressions() []Suppression {
+	suppressionMu.Lock()
+	defer suppressionMu.Unlock()
+	expireSuppressionsLocked(time.Now())
+	out := make([]Suppression, 0, len(suppressions))
+	for id := 1; id < nextSuppressionID; id++ {
+		if s, ok := suppressions[id]; ok {
+			out = append(out, *s)
+		}
+	}
+	return out
+}
+
+func removeSuppression(id int) bool {
+	suppressionMu.Lock()
+	if _, ok := suppressions[id]; !ok {
+		suppressionMu.Unlock()
+		return false
+	}
+	delete(suppressions, id)
+	reindexSuppressionsLocked()
+	suppressionMu.Unlock()
+	log.Printf("Suppression #%d removed", id)
+	persist("removed suppression", func(ctx context.Context) error { return store.DeleteSuppression(ctx, id) })
+	return true
+}
+
+// restoreSuppressions puts back the rules saved by a previous run
+func restoreSuppressions(saved []Suppression) {
+	suppressionMu.Lock()
+	defer suppressionMu.Unlock()
+	for i := range saved {
+		s := saved[i]
+		suppressions[s.ID] = &s
+		if s.ID >= nextSuppressionID {
+			nextSuppressionID = s.ID + 1
+		}
+	}
+	reindexSuppressionsLocked()
+	expireSuppressionsLocked(time.Now())
+}
+
+// handleSuppressions serves GET (list), POST (add) and DELETE ?id=N (remove)
+func handleSuppressions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(listSuppressions())
+
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<16)
+		var req suppressionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Namespace == "" {
+			req.Namespace = "default"
+		}
+		user, ok := authorizeRequest(w, r, verbFreeze, req.Namespace)
+		if !ok {
+			return
+		}
+		s, err := addSuppression(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if user != "" {
+			log.Printf("Suppression #%d created by %s", s.ID, user)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(s)
+
+	case http.MethodDelete:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "id query parameter required", http.StatusBadRequest)
+			return
+		}
+		suppressionMu.Lock()
+		namespace := ""
+		if s, ok := suppressions[id]; ok {
+			namespace = s.Namespace
+		}
+		suppressionMu.Unlock()
+		if _, ok := authorizeRequest(w, r, verbFreeze, namespace); !ok {
+			return
+		}
+		if !removeSuppression(id) {
+			http.Error(w, "suppression not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// suppressionStore keeps suppressions in memory, like a persistent store would
+type suppressionStore struct {
+	memoryStore
+	mu    sync.Mutex
+	saved map[int]Suppression
+}
+
+func (s *suppressionStore) SaveSuppression(_ context.Context, sup Suppression) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saved[sup.ID] = sup
+	return nil
+}
+
+func (s *suppressionStore) DeleteSuppression(_ context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.saved, id)
+	return nil
+}
+
+func (s *suppressionStore) LoadSuppressions(context.Context) ([]Suppression, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Suppression
+	for _, sup := range s.saved {
+		out = append(out, sup)
+	}
+	return out, nil
+}
+
+// resetSuppressions empties the rules for a test and puts them back after it
+func resetSuppressions(t *testing.T) {
+	suppressionMu.Lock()
+	saved, savedNext := suppressions, nextSuppressionID
+	suppressions, nextSuppressionID = map[int]*Suppression{}, 1
+	reindexSuppressionsLocked()
+	suppressionMu.Unlock()
+	t.Cleanup(func() {
+		suppressionMu.Lock()
+		suppressions, nextSuppressionID = saved, savedNext
+		reindexSuppressionsLocked()
+		suppressionMu.Unlock()
+	})
+}
+
+func TestSuppressionMatches(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Suppression
+		want bool
+	}{
+		{"alert and app", Suppression{AlertName: "Down", Namespace: "shop", App: "cart"}, true},
+		{"any alert", Suppression{Namespace: "shop", App: "cart"}, true},
+		{"any app", Suppression{AlertName: "Down", Namespace: "shop"}, true},
+		{"other alert", Suppression{AlertName: "Slow", Namespace: "shop", App: "cart"}, false},
+		{"other app", Suppression{AlertName: "Down", Namespace: "shop", App: "web"}, false},
+		{"other namespace", Suppression{AlertName: "Down", Namespace: "bank", App: "cart"}, false},
+	}
+	action := &RecoveryAction{AlertName: "Down", Namespace: "shop", App: "cart"}
+	for _, tt := range tests {
+		if got := tt.rule.matches(action); got != tt.want {
+			t.Errorf("%s: matches = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAddSuppressionValidates(t *testing.T) {
+	resetSuppressions(t)
+	tests := []struct {
+		name string
+		req  suppressionRequest
+		ok   bool
+	}{
+		{"valid", suppressionRequest{AlertName: "Down", Duration: "1h"}, true},
+		{"no duration", suppressionRequest{AlertName: "Down"}, false},
+		{"negative", suppressionRequest{AlertName: "Down", Duration: "-1h"}, false},
+		{"too long", suppressionRequest{AlertName: "Down", Duration: "200h"}, false},
+		{"matches everything", suppressionRequest{Duration: "1h"}, false},
+	}
+	for _, tt := range tests {
+		s, err := addSuppression(tt.req)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+		if err == nil && s.Namespace != "default" {
+			t.Errorf("%s: namespace = %q, want default", tt.name, s.Namespace)
+		}
+	}
+}
+
+func TestSuppressionExpires(t *testing.T) {
+	resetSuppressions(t)
+	s, err := addSuppression(suppressionRequest{Namespace: "shop", App: "cart", Duration: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	action := &RecoveryAction{AlertName: "Down", Namespace: "shop", App: "cart"}
+	if activeSuppression(action) == nil {
+		t.Fatal("new suppression doesn't apply")
+	}
+	suppressionMu.Lock()
+	suppressions[s.ID].ExpiresAt = time.Now().Add(-time.Second)
+	reindexSuppressionsLocked()
+	suppressionMu.Unlock()
+	if activeSuppression(action) != nil {
+		t.Error("expired suppression still applies")
+	}
+	if n := len(listSuppressions()); n != 0 {
+		t.Errorf("%d suppressions listed after expiry", n)
+	}
+}
+
+func TestSuppressionsSurviveRestart(t *testing.T) {
+	resetSuppressions(t)
+	ss := &suppressionStore{saved: map[int]Suppression{}}
+	savedStore := store
+	store = ss
+	defer func() { store = savedStore }()
+
+	kept, _ := addSuppression(suppressionRequest{Namespace: "shop", App: "cart", Duration: "1h"})
+	removed, _ := addSuppression(suppressionRequest{Namespace: "shop", App: "web", Duration: "1h"})
+	removeSuppression(removed.ID)
+
+	// a new process starts with no rules and loads them from the store
+	resetSuppressions(t)
+	loaded, err := store.LoadSuppressions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoreSuppressions(loaded)
+
+	if activeSuppression(&RecoveryAction{Namespace: "shop", App: "cart"}) == nil {
+		t.Error("saved suppression wasn't restored")
+	}
+	if activeSuppression(&RecoveryAction{Namespace: "shop", App: "web"}) != nil {
+		t.Error("removed suppression came back")
+	}
+	next, _ := addSuppression(suppressionRequest{Namespace: "shop", App: "db", Duration: "1h"})
+	if next.ID <= kept.ID {
+		t.Errorf("new suppression got ID %d, reusing restored IDs (up to %d)", next.ID, kept.ID)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Remediation tags: ownership labels such as the team, cost center and
+// service tier that a policy carries, so remediation activity can be sliced by
+// who owns it. REMEDIATION_TAGS names the tag keys (alert label names,
+// default team,cost_center,tier). A RemediationPolicy sets them with
+//
+//	spec:
+//	  tags:
+//	    team: payments
+//	    cost_center: "cc-4411"
+//	    tier: "1"
+//
+// which become labels of its generated rule; any other alert rule can carry
+// the same labels directly. An action's tags are the alert's values for those
+// keys, and they follow the action into
+//   - the history and the signed audit record (tags), and
+//     /api/v1/history?tag=team=payments
+//   - selfhealing_remediations_by_tag_total, with one label per tag key
+//   - escalations (Opsgenie tags, Splunk On-Call fields, Jira labels), the
+//     recommendation message ({{.Tags}} in notification templates), result
+//     callbacks and remediation events
+//   - /api/v1/analytics?tag=team=payments (only that team's remediations) and
+//     ?by=cost_center (remediations, failures and MTTR per cost center)
+
+var (
+	remediationTagKeys = envList("REMEDIATION_TAGS")
+
+	tagKeyPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+	tagsMu        sync.Mutex
+	tagRemediated = map[string]*taggedCount{} // key: the quoted label values
+)
+
+// taggedCount is one series of selfhealing_remediations_by_tag_total
+type taggedCount struct {
+	values []string // tag values in key order, then action and outcome
+	n      int
+}
+
+// reservedTagKeys are labels the operator reads for other purposes
+var reservedTagKeys = []string{"alertname", "namespace", "app", "pod", "severity", "recovery_action", "runbook", "workflow", "action", "outcome"}
+
+func init() {
+	if len(remediationTagKeys) == 0 {
+		remediationTagKeys = []string{"team", "cost_center", "tier"}
+	}
+	registerMetrics(writeTagMetrics)
+	onRemediation(countTaggedRemediation)
+}
+
+// checkRemediationTags reports a REMEDIATION_TAGS key that can't be a label name
+func checkRemediationTags() error {
+	for _, k := range remediationTagKeys {
+		if !tagKeyPattern.MatchString(k) {
+			return fmt.Errorf("%q isn't a valid label name", k)
+		}
+		if containsString(reservedTagKeys, k) {
+			return fmt.Errorf("%q is a label the operator already uses", k)
+		}
+	}
+	return nil
+}
+
+// isTagKey reports whether the key is one of REMEDIATION_TAGS
+func isTagKey(key string) bool {
+	return containsString(remediationTagKeys, key)
+}
+
+// remediationTags picks the tags out of an alert's labels, nil if it has none
+func remediationTags(labels map[string]string) map[string]string {
+	var tags map[string]string
+	for _, k := range remediationTagKeys {
+		if v := labels[k]; v != "" {
+			if tags == nil {
+				tags = map[string]string{}
+			}
+			tags[k] = v
+		}
+	}
+	return tags
+}
+
+// parseTagFilter parses "key=value" as used by the tag query parameters
+func parseTagFilter(s string) (string, string, error) {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || !isTagKey(k) {
+		return "", "", fmt.Errorf("tag must be key=value with a key from REMEDIATION_TAGS (%s)", strings.Join(remediationTagKeys, ", "))
+	}
+	return k, v, nil
+}
+
+// tagList formats tags as "key:value" in key order, e.g. for Opsgenie tags and Jira labels
+func tagList(tags map[string]string) []string {
+	var out []string
+	for _, k := range sortedKeys(tags) {
+		out = append(out, k+":"+strings.ReplaceAll(tags[k], " ", "_"))
+	}
+	return out
+}
+
+// formatTags renders tags for people: "team=payments, tier=1"
+func formatTags(tags map[string]string) string {
+	var parts []string
+	for _, k := range sortedKeys(tags) {
+		parts = append(parts, k+"="+tags[k])
+	}
+	return strings.Join(parts, ", ")
+}
+
+func countTaggedRemediation(rec ActionRecord) {
+	values := make([]string, 0, len(remediationTagKeys)+2)
+	for _, k := range remediationTagKeys {
+		values = append(values, rec.Tags[k])
+	}
+	values = append(values, rec.Action, rec.Outcome)
+	// quoted, so a "|" or "," in a tag value can't run into the next one
+	key := fmt.Sprintf("%q", values)
+	tagsMu.Lock()
+	c := tagRemediated[key]
+	if c == nil {
+		c = &taggedCount{values: values}
+		tagRemediated[key] = c
+	}
+	c.n++
+	tagsMu.Unlock()
+}
+
+func writeTagMetrics(w io.Writer) {
+	tagsMu.Lock()
+	defer tagsMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_remediations_by_tag_total Remediations by the tags of their policy (REMEDIATION_TAGS), action and outcome.")
+	fmt.Fprintln(w, "# TYPE selfhealing_remediations_by_tag_total counter")
+	for _, key := range sortedKeys(tagRemediated) {
+		c := tagRemediated[key]
+		values := c.values
+		var labels []string
+		for i, k := range remediationTagKeys {
+			labels = append(labels, fmt.Sprintf("%s=%q", k, values[i]))
+		}
+		n := len(remediationTagKeys)
+		labels = append(labels, fmt.Sprintf("action=%q", values[n]), fmt.Sprintf("outcome=%q", values[n+1]))
+		fmt.Fprintf(w, "selfhealing_remediations_by_tag_total{%s} %d\n", strings.Join(labels, ","), c.n)
+	}
+}
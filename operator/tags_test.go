@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTagMetricsWithSeparatorInValue(t *testing.T) {
+	savedKeys := remediationTagKeys
+	remediationTagKeys = []string{"team", "cost_center", "tier"}
+	tagsMu.Lock()
+	saved := tagRemediated
+	tagRemediated = map[string]*taggedCount{}
+	tagsMu.Unlock()
+	defer func() {
+		remediationTagKeys = savedKeys
+		tagsMu.Lock()
+		tagRemediated = saved
+		tagsMu.Unlock()
+	}()
+
+	countTaggedRemediation(ActionRecord{Action: "restart", Outcome: "succeeded", Tags: map[string]string{"team": "a|b"}})
+	countTaggedRemediation(ActionRecord{Action: "restart", Outcome: "succeeded", Tags: map[string]string{"team": "a|b"}})
+	countTaggedRemediation(ActionRecord{Action: "restart", Outcome: "succeeded", Tags: map[string]string{"team": "a", "cost_center": "b"}})
+
+	var buf bytes.Buffer
+	writeTagMetrics(&buf)
+	out := buf.String()
+	if want := `team="a|b",cost_center="",tier="",action="restart",outcome="succeeded"} 2`; !strings.Contains(out, want) {
+		t.Errorf("metrics missing %s:\n%s", want, out)
+	}
+	if want := `team="a",cost_center="b",tier="",action="restart",outcome="succeeded"} 1`; !strings.Contains(out, want) {
+		t.Errorf("metrics missing %s:\n%s", want, out)
+	}
+}
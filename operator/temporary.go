@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Temporary changes. An alert with a temporary_ttl label or annotation
+// (a duration, at most TEMPORARY_MAX_TTL) makes its action temporary: a
+// scale-up, or a patch_resource that relaxes a probe or shifts traffic, is
+// undone when the alert resolves, or after the TTL at the latest:
+//
+//	labels:
+//	  recovery_action: patch_resource
+//	annotations:
+//	  temporary_ttl: 30m
+//	  resource_api_version: networking.istio.io/v1beta1
+//	  resource: virtualservices
+//	  ...
+//
+// After the action succeeds its revert — the fields it changed, with their
+// values before and after (see snapshot.go) — is written to a ConfigMap in the
+// operator's namespace, so it survives restarts and any replica can run it.
+// Every TEMPORARY_REVERT_INTERVAL the reconciler reverts the changes whose TTL
+// has passed, whether or not the resolved notification ever arrived.
+//
+// A revert only puts back fields that still have the value the action set:
+// a field changed since (by an HPA, a person, a later deploy) is left alone and
+// reported to Slack. Only actions in temporaryActions can be temporary; for
+// the others the alert is refused rather than acting without a revert.
+
+const (
+	temporaryTTLLabel      = "temporary_ttl"
+	temporaryLabel         = "selfhealing.io/temporary-change"
+	temporaryAlertLabel    = "selfhealing.io/alert-fingerprint"
+	temporaryClaimAnno     = "selfhealing.io/revert-claimed-at"
+	temporaryDataKey       = "change.json"
+	temporaryClaimDuration = 2 * time.Minute
+)
+
+var (
+	temporaryMaxTTL         = envDuration("TEMPORARY_MAX_TTL", 24*time.Hour)
+	temporaryRevertInterval = envDuration("TEMPORARY_REVERT_INTERVAL", time.Minute)
+	temporaryNamespace      = envString("POD_NAMESPACE", "default")
+
+	temporaryMu      sync.Mutex
+	temporaryResults = map[string]int{} // registered, reverted, drifted, gone, failed
+)
+
+// temporaryActions are the actions whose changes can be reverted
+var temporaryActions = []string{"scale", "patch_resource"}
+
+// changeResources are the resources of the kinds recordChange is called with
+var changeResources = map[string]schema.GroupVersionResource{
+	"Deployment":       {Group: "apps", Version: "v1", Resource: "deployments"},
+	"DeploymentConfig": {Group: "apps.openshift.io", Version: "v1", Resource: "deploymentconfigs"},
+}
+
+// TemporaryChange is a temporary action's revert
+type TemporaryChange struct {
+	ID          string            `json:"id"`
+	Action      string            `json:"action"`
+	AlertName   string            `json:"alertname"`
+	Fingerprint string            `json:"fingerprint"`
+	Namespace   string            `json:"namespace"`
+	App         string            `json:"app"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	Until       time.Time         `json:"until"`
+	Objects     []temporaryObject `json:"objects"`
+}
+
+// temporaryObject is one object the action changed
+type temporaryObject struct {
+	APIVersion string        `json:"apiVersion"`
+	Resource   string        `json:"resource"`
+	Kind       string        `json:"kind"`
+	Namespace  string        `json:"namespace"`
+	Name       string        `json:"name"`
+	Fields     []FieldChange `json:"fields"`
+}
+
+func init() {
+	registerMetrics(writeTemporaryMetrics)
+}
+
+// temporaryTTL returns the alert's temporary_ttl; 0 means the action isn't temporary
+func temporaryTTL(alert Alert) (time.Duration, error) {
+	s := alert.Labels[temporaryTTLLabel]
+	if s == "" {
+		s = alert.Annotations[temporaryTTLLabel]
+	}
+	if s == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(s)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", temporaryTTLLabel, s)
+	}
+	if ttl > temporaryMaxTTL {
+		ttl = temporaryMaxTTL
+	}
+	return ttl, nil
+}
+
+// temporaryRefusal says why a temporary action can't run
+func temporaryRefusal(action *RecoveryAction, alert Alert) string {
+	ttl, err := temporaryTTL(alert)
+	if err != nil {
+		return err.Error()
+	}
+	if ttl > 0 && !containsString(temporaryActions, action.Action) {
+		return fmt.Sprintf("'%s' can't be undone, so it can't be temporary (%s; temporary actions: %s)",
+			action.Action, temporaryTTLLabel, strings.Join(temporaryActions, ", "))
+	}
+	return ""
+}
+
+// registerTemporary records the revert of a temporary action that just succeeded
+func registerTemporary(ctx context.Context, action *RecoveryAction, alert Alert) error {
+	ttl, err := temporaryTTL(alert)
+	if err != nil || ttl == 0 {
+		return err
+	}
+	now := time.Now()
+	tc := TemporaryChange{
+		ID:          strconv.FormatInt(now.UnixNano(), 36),
+		Action:      action.Action,
+		AlertName:   action.AlertName,
+		Fingerprint: alertFingerprint(alert),
+		Namespace:   action.Namespace,
+		App:         action.App,
+		CreatedAt:   now.UTC(),
+		Until:       now.Add(ttl).UTC(),
+	}
+	for _, c := range action.Changes {
+		gvr := c.resource
+		if gvr.Resource == "" {
+			gvr = changeResources[c.Kind]
+		}
+		if gvr.Resource == "" || c.Truncated {
+			return fmt.Errorf("the change to %s %s/%s can't be reverted", c.Kind, c.Namespace, c.Name)
+		}
+		tc.Objects = append(tc.Objects, temporaryObject{
+			APIVersion: gvr.GroupVersion().String(), Resource: gvr.Resource,
+			Kind: c.Kind, Namespace: c.Namespace, Name: c.Name, Fields: c.Fields,
+		})
+	}
+	if len(tc.Objects) == 0 {
+		// nothing changed (already at max-replicas, patch was a no-op): nothing to revert
+		return nil
+	}
+	data, err := json.Marshal(tc)
+	if err != nil {
+		return err
+	}
+	meta := metav1.ObjectMeta{
+		Name:      "selfhealing-temporary-" + tc.ID,
+		Namespace: temporaryNamespace,
+		Labels:    map[string]string{managedByLabel: managedByValue, temporaryLabel: "true", temporaryAlertLabel: labelSafe(tc.Fingerprint)},
+	}
+	_, err = clients.Kube.CoreV1().ConfigMaps(temporaryNamespace).Create(ctx, &corev1.ConfigMap{
+		ObjectMeta: meta,
+		Data:       map[string]string{temporaryDataKey: string(data)},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to record the revert: %v", err)
+	}
+	countTemporary("registered")
+	action.Explain.add("temporary", traceInfo, "reverted when the alert resolves, at "+tc.Until.Format(time.RFC3339)+" at the latest")
+	log.Printf("'%s' for %s/%s is temporary — reverted when '%s' resolves, at %s at the latest",
+		action.Action, action.Namespace, action.App, action.AlertName, tc.Until.Format(time.RFC3339))
+	return nil
+}
+
+// labelSafe shortens a value to fit a label
+func labelSafe(s string) string {
+	if len(s) > 63 {
+		return s[:63]
+	}
+	return s
+}
+
+// observeTemporaryResolved reverts the temporary changes of a resolved alert
+func observeTemporaryResolved(alert Alert) {
+	if alert.Status != "resolved" || clients.Kube == nil {
+		return
+	}
+	selector := temporaryLabel + "=true," + temporaryAlertLabel + "=" + labelSafe(alertFingerprint(alert))
+	go func() {
+		ctx, cancel := context.WithTimeout(operatorCtx, 30*time.Second)
+		defer cancel()
+		if err := revertTemporaryChanges(ctx, clients.Kube, selector, true); err != nil {
+			log.Printf("Failed to revert the temporary changes of resolved alert '%s': %v", alert.Labels["alertname"], err)
+		}
+	}()
+}
+
+// startTemporaryReverts reverts expired temporary changes every TEMPORARY_REVERT_INTERVAL
+func startTemporaryReverts(kube kubernetes.Interface) {
+	if temporaryRevertInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(temporaryRevertInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-operatorCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			ctx, cancel := context.WithTimeout(operatorCtx, 30*time.Second)
+			if err := revertTemporaryChanges(ctx, kube, temporaryLabel+"=true", false); err != nil {
+				log.Printf("Failed to revert temporary changes: %v", err)
+			}
+			cancel()
+		}
+	}()
+}
+
+// revertTemporaryChanges reverts the matching changes that are due, or all of them when resolved
+func revertTemporaryChanges(ctx context.Context, kube kubernetes.Interface, selector string, resolved bool) error {
+	configMaps := kube.CoreV1().ConfigMaps(temporaryNamespace)
+	list, err := configMaps.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+	for i := range list.Items {
+		cm := &list.Items[i]
+		var tc TemporaryChange
+		if err := json.Unmarshal([]byte(cm.Data[temporaryDataKey]), &tc); err != nil {
+			log.Printf("Deleting unreadable temporary change %s: %v", cm.Name, err)
+			configMaps.Delete(ctx, cm.Name, metav1.DeleteOptions{})
+			continue
+		}
+		if !resolved && time.Now().Before(tc.Until) {
+			continue
+		}
+		if claimed, err := time.Parse(time.RFC3339, cm.Annotations[temporaryClaimAnno]); err == nil && time.Since(claimed) < temporaryClaimDuration {
+			continue
+		}
+		// claim it, so only one replica reverts; a claim of a replica that died expires
+		if cm.Annotations == nil {
+			cm.Annotations = map[string]string{}
+		}
+		cm.Annotations[temporaryClaimAnno] = time.Now().UTC().Format(time.RFC3339)
+		if _, err := configMaps.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+			continue
+		}
+		why := "its TTL passed"
+		if resolved {
+			why = "the alert resolved"
+		}
+		if err := revertTemporary(ctx, tc, why); err != nil {
+			// stays claimed until the claim expires, then it's retried
+			countTemporary("failed")
+			log.Printf("Failed to revert temporary '%s' for %s/%s: %v", tc.Action, tc.Namespace, tc.App, err)
+			continue
+		}
+		if err := configMaps.Delete(ctx, cm.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			log.Printf("Failed to delete temporary change %s: %v", cm.Name, err)
+		}
+	}
+	return nil
+}
+
+// revertTemporary puts back the fields of each object that still have the action's value
+func revertTemporary(ctx context.Context, tc TemporaryChange, why string) error {
+	var drifted []string
+	for _, o := range tc.Objects {
+		gv, err := schema.ParseGroupVersion(o.APIVersion)
+		if err != nil {
+			return err
+		}
+		dyn, err := clients.DynamicFor(ctx, o.Namespace)
+		if err != nil {
+			return err
+		}
+		objects := dyn.Resource(gv.WithResource(o.Resource)).Namespace(o.Namespace)
+		obj, err := objects.Get(ctx, o.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			countTemporary("gone")
+			log.Printf("%s %s/%s is gone — nothing to revert", o.Kind, o.Namespace, o.Name)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get %s %s/%s: %v", o.Kind, o.Namespace, o.Name, err)
+		}
+		var content interface{} = obj.Object
+		reverted := 0
+		for _, f := range o.Fields {
+			updated, err := revertField(content, f.Path, f.Before, f.After)
+			if err != nil {
+				drifted = append(drifted, fmt.Sprintf("%s %s/%s %s (%v)", o.Kind, o.Namespace, o.Name, f.Path, err))
+				continue
+			}
+			content = updated
+			reverted++
+		}
+		if reverted == 0 {
+			continue
+		}
+		obj.Object = content.(map[string]interface{})
+		if _, err := objects.Update(ctx, obj, metav1.UpdateOptions{FieldManager: "self-healing-operator"}); err != nil {
+			return fmt.Errorf("failed to revert %s %s/%s: %v", o.Kind, o.Namespace, o.Name, err)
+		}
+		log.Printf("Reverted temporary '%s' on %s %s/%s (%d field(s)) — %s", tc.Action, o.Kind, o.Namespace, o.Name, reverted, why)
+	}
+	if len(drifted) > 0 {
+		countTemporary("drifted")
+		log.Printf("Temporary '%s' for %s/%s: left %d changed field(s) alone — %s", tc.Action, tc.Namespace, tc.App, len(drifted), strings.Join(drifted, "; "))
+		notifySlack(renderMessage("temporary-drifted", "", map[string]interface{}{
+			"Action": tc.Action, "AlertName": tc.AlertName, "Namespace": tc.Namespace, "App": tc.App, "Fields": drifted,
+		}))
+		return nil
+	}
+	countTemporary("reverted")
+	return nil
+}
+
+// errDrifted is returned for a field that no longer has the value the action set
+var errDrifted = errors.New("changed since")
+
+// revertField sets the field at a snapshot.go path back to before, if it still
+// has the value after; it returns the updated node. Map keys may contain dots
+// (annotations), so the path is matched against the keys that are there.
+func revertField(node interface{}, path string, before, after interface{}) (interface{}, error) {
+	if path == "" {
+		if !sameJSON(node, after) {
+			return nil, errDrifted
+		}
+		return before, nil
+	}
+	switch n := node.(type) {
+	case map[string]interface{}:
+		path = strings.TrimPrefix(path, ".")
+		key, rest := matchKey(n, path)
+		if key == "" {
+			// the action removed the field: put it back if it's a leaf that's still missing
+			if after != nil || strings.ContainsAny(path, ".[") {
+				return nil, errDrifted
+			}
+			n[path] = before
+			return n, nil
+		}
+		updated, err := revertField(n[key], rest, before, after)
+		if err != nil {
+			return nil, err
+		}
+		if updated == nil {
+			delete(n, key)
+		} else {
+			n[key] = updated
+		}
+		return n, nil
+	case []interface{}:
+		end := strings.Index(path, "]")
+		if !strings.HasPrefix(path, "[") || end < 0 {
+			return nil, errDrifted
+		}
+		sel, rest := path[1:end], path[end+1:]
+		i := -1
+		if name := strings.TrimPrefix(sel, "name="); name != sel {
+			for k, item := range n {
+				if m, ok := item.(map[string]interface{}); ok && m["name"] == name {
+					i = k
+				}
+			}
+		} else if k, err := strconv.Atoi(sel); err == nil && k < len(n) {
+			i = k
+		}
+		if i < 0 {
+			if after != nil || rest != "" {
+				return nil, errDrifted
+			}
+			return append(n, before), nil
+		}
+		updated, err := revertField(n[i], rest, before, after)
+		if err != nil {
+			return nil, err
+		}
+		if updated == nil {
+			return append(n[:i:i], n[i+1:]...), nil
+		}
+		n[i] = updated
+		return n, nil
+	}
+	return nil, errDrifted
+}
+
+// matchKey finds the longest key of the map that the path starts with
+func matchKey(m map[string]interface{}, path string) (key, rest string) {
+	for k := range m {
+		if len(k) <= len(key) || !strings.HasPrefix(path, k) {
+			continue
+		}
+		if r := path[len(k):]; r == "" || r[0] == '.' || r[0] == '[' {
+			key, rest = k, r
+		}
+	}
+	return key, rest
+}
+
+// sameJSON compares values as JSON, so 3 and 3.0 are the same
+func sameJSON(a, b interface{}) bool {
+	x, err1 := json.Marshal(a)
+	y, err2 := json.Marshal(b)
+	return err1 == nil && err2 == nil && string(x) == string(y)
+}
+
+// listTemporaryChanges returns the temporary changes not reverted yet, soonest first
+func listTemporaryChanges(ctx context.Context) ([]TemporaryChange, error) {
+	list, err := clients.Kube.CoreV1().ConfigMaps(temporaryNamespace).List(ctx, metav1.ListOptions{LabelSelector: temporaryLabel + "=true"})
+	if err != nil {
+		return nil, err
+	}
+	out := []TemporaryChange{}
+	for _, cm := range list.Items {
+		var tc TemporaryChange
+		if json.Unmarshal([]byte(cm.Data[temporaryDataKey]), &tc) == nil {
+			out = append(out, tc)
+		}
+	}
+	sort.Slice(out, func(i, k int) bool { return out[i].Until.Before(out[k].Until) })
+	return out, nil
+}
+
+func handleTemporaryChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	changes, err := listTemporaryChanges(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
+
+func countTemporary(result string) {
+	temporaryMu.Lock()
+	temporaryResults[result]++
+	temporaryMu.Unlock()
+}
+
+func writeTemporaryMetrics(w io.Writer) {
+	temporaryMu.Lock()
+	defer temporaryMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_temporary_changes_total Temporary actions recorded (registered) and what became of their reverts (reverted, drifted, gone, failed).")
+	fmt.Fprintln(w, "# TYPE selfhealing_temporary_changes_total counter")
+	for _, result := range sortedKeys(temporaryResults) {
+		fmt.Fprintf(w, "selfhealing_temporary_changes_total{result=%q} %d\n", result, temporaryResults[result])
+	}
+}
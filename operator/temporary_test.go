@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTemporaryTTL(t *testing.T) {
+	savedMax := temporaryMaxTTL
+	temporaryMaxTTL = 2 * time.Hour
+	defer func() { temporaryMaxTTL = savedMax }()
+
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		want        time.Duration
+		wantErr     bool
+	}{
+		{"not temporary", nil, nil, 0, false},
+		{"annotation", nil, map[string]string{temporaryTTLLabel: "30m"}, 30 * time.Minute, false},
+		{"label wins over annotation", map[string]string{temporaryTTLLabel: "10m"}, map[string]string{temporaryTTLLabel: "30m"}, 10 * time.Minute, false},
+		{"capped at the maximum", nil, map[string]string{temporaryTTLLabel: "48h"}, 2 * time.Hour, false},
+		{"unparsable", nil, map[string]string{temporaryTTLLabel: "a while"}, 0, true},
+		{"not positive", nil, map[string]string{temporaryTTLLabel: "-5m"}, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := temporaryTTL(Alert{Labels: tt.labels, Annotations: tt.annotations})
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%s: ttl = %s, %v; want %s, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestTemporaryRefusal(t *testing.T) {
+	ttl := map[string]string{temporaryTTLLabel: "30m"}
+	tests := []struct {
+		name   string
+		action string
+		labels map[string]string
+		want   string
+	}{
+		{"temporary scale", "scale", ttl, ""},
+		{"temporary patch", "patch_resource", ttl, ""},
+		{"restart can't be undone", "restart", ttl, "can't be undone"},
+		{"restart without a ttl", "restart", nil, ""},
+		{"bad ttl", "scale", map[string]string{temporaryTTLLabel: "soon"}, "invalid temporary_ttl"},
+	}
+	for _, tt := range tests {
+		got := temporaryRefusal(&RecoveryAction{Action: tt.action}, Alert{Labels: tt.labels})
+		if (tt.want == "") != (got == "") || !strings.Contains(got, tt.want) {
+			t.Errorf("%s: refusal = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRevertField(t *testing.T) {
+	obj := func() interface{} {
+		var v interface{}
+		json.Unmarshal([]byte(`{
+			"metadata": {"annotations": {"example.com/weight.canary": "90"}},
+			"spec": {
+				"replicas": 4,
+				"template": {"spec": {"containers": [
+					{"name": "app", "image": "app:2", "readinessProbe": {"failureThreshold": 10}},
+					{"name": "sidecar", "image": "proxy:1"}
+				]}}
+			}
+		}`), &v)
+		return v
+	}
+	tests := []struct {
+		name    string
+		path    string
+		before  interface{}
+		after   interface{}
+		check   string // path to read back, as a JSON value
+		want    string
+		wantErr bool
+	}{
+		{"scalar", ".spec.replicas", 3, 4, "spec.replicas", "3", false},
+		{"number types compare as JSON", "spec.replicas", 3, 4.0, "spec.replicas", "3", false},
+		{"drifted scalar is left alone", "spec.replicas", 3, 5, "", "", true},
+		{"key with dots", "metadata.annotations.example.com/weight.canary", "100", "90",
+			"metadata.annotations", `{"example.com/weight.canary":"100"}`, false},
+		{"list item by name", "spec.template.spec.containers[name=app].readinessProbe.failureThreshold", 3, 10,
+			"spec.template.spec.containers[0].readinessProbe", `{"failureThreshold":3}`, false},
+		{"list item by index", "spec.template.spec.containers[1].image", "proxy:0", "proxy:1",
+			"spec.template.spec.containers[1].image", `"proxy:0"`, false},
+		{"added field is removed", "spec.template.spec.containers[name=app].readinessProbe", nil, map[string]interface{}{"failureThreshold": 10},
+			"spec.template.spec.containers[0]", `{"image":"app:2","name":"app"}`, false},
+		{"removed field is put back", "spec.paused", true, nil, "spec.paused", "true", false},
+		{"removed container is gone since", "spec.template.spec.containers[name=worker].image", "w:1", "w:2", "", "", true},
+	}
+	for _, tt := range tests {
+		got, err := revertField(obj(), tt.path, tt.before, tt.after)
+		if tt.wantErr {
+			if !errors.Is(err, errDrifted) {
+				t.Errorf("%s: err = %v, want drifted", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if tt.check == "" {
+			continue
+		}
+		if v := lookupPath(got, tt.check); !sameJSON(v, json.RawMessage(tt.want)) {
+			raw, _ := json.Marshal(v)
+			t.Errorf("%s: %s = %s, want %s", tt.name, tt.check, raw, tt.want)
+		}
+	}
+}
+
+// lookupPath reads a plain dotted path with [index] list items
+func lookupPath(node interface{}, path string) interface{} {
+	for _, part := range strings.Split(strings.ReplaceAll(path, "[", ".["), ".") {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			node = n[part]
+		case []interface{}:
+			var i int
+			json.Unmarshal([]byte(strings.Trim(part, "[]")), &i)
+			node = n[i]
+		default:
+			return nil
+		}
+	}
+	return node
+}
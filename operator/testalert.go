@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Test alerts: POST /api/v1/test-alert builds a firing alert for a workload,
+// the way Alertmanager would deliver it, and runs it through the decision
+// checks as a dry run. Nothing is executed or recorded; the response shows
+// each check so a team onboarding a service can see what would happen.
+//
+//	curl -X POST localhost:8080/api/v1/test-alert \
+//	  -d '{"namespace":"shop","app":"cart","severity":"critical"}'
+
+type testAlertRequest struct {
+	AlertName   string            `json:"alertname"`
+	Namespace   string            `json:"namespace"`
+	App         string            `json:"app"`
+	Pod         string            `json:"pod"`
+	Severity    string            `json:"severity"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+type testAlertResult struct {
+	Alert    Alert            `json:"alert"`
+	Decision string           `json:"decision"` // execute, recommend, hold, skip
+	Action   *testAlertAction `json:"action,omitempty"`
+	Trace    decisionTrace    `json:"trace"`
+}
+
+type testAlertAction struct {
+	Action    string `json:"action"`
+	Namespace string `json:"namespace"`
+	App       string `json:"app"`
+	Pod       string `json:"pod,omitempty"`
+	Runbook   string `json:"runbook,omitempty"`
+	Workflow  string `json:"workflow,omitempty"`
+	Escalate  bool   `json:"escalate,omitempty"`
+}
+
+func handleTestAlert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<16)
+	var req testAlertRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.App == "" && req.Pod == "" && req.Labels["node"] == "" {
+		http.Error(w, "app, pod or labels.node required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	res := runTestAlert(ctx, req)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func runTestAlert(ctx context.Context, req testAlertRequest) testAlertResult {
+	var res testAlertResult
+	res.Alert = buildTestAlert(req)
+	resolveTestTarget(ctx, &res.Alert, &res.Trace)
+
+	action := decideAlert(ctx, res.Alert, true, &res.Trace)
+	if action == nil {
+		res.Decision = "skip"
+		return res
+	}
+	res.Action = &testAlertAction{
+		Action:    action.Action,
+		Namespace: action.Namespace,
+		App:       action.App,
+		Pod:       action.Pod,
+		Runbook:   action.Runbook,
+		Workflow:  action.Workflow,
+		Escalate:  action.Escalate,
+	}
+
+	for _, key := range workloadLocks.held() {
+		if key == workloadKey(action) {
+			res.Trace.add("workload-lock", traceInfo, "another action on "+key+" is running; this one would wait up to "+workloadLocks.waitTimeout().String())
+		}
+	}
+	if safeMode.isActive() && action.Action != "notify" {
+		res.Decision = "hold"
+		res.Trace.add("safe-mode", traceInfo, "control plane degraded: the action would be held until safe mode ends")
+	} else if operatingMode == modeRecommend {
+		res.Decision = "recommend"
+		res.Trace.add("mode", traceInfo, "MODE=recommend: a recommendation would be published instead of acting")
+	} else if requiresApproval(action.Action) {
+		res.Decision = "recommend"
+		res.Trace.add("mode", traceInfo, "'"+action.Action+"' always needs approval: a recommendation would be published")
+	} else if until, ok := observation.until(action, false); ok {
+		res.Decision = "recommend"
+		res.Trace.add("observation", traceInfo, action.Namespace+"/"+action.App+" would be in observation until "+until.Format(time.RFC3339)+": a recommendation would be published")
+	} else {
+		res.Decision = "execute"
+		res.Trace.add("mode", traceInfo, "'"+action.Action+"' would be executed")
+	}
+	return res
+}
+
+func buildTestAlert(req testAlertRequest) Alert {
+	labels := map[string]string{}
+	for k, v := range req.Labels {
+		labels[k] = v
+	}
+	set := func(k, v string) {
+		if v != "" {
+			labels[k] = v
+		}
+	}
+	set("alertname", req.AlertName)
+	set("namespace", req.Namespace)
+	set("app", req.App)
+	set("pod", req.Pod)
+	set("severity", req.Severity)
+	if labels["alertname"] == "" {
+		labels["alertname"] = "SelfHealingTestAlert"
+	}
+	if labels["severity"] == "" {
+		labels["severity"] = "warning"
+	}
+	if labels["namespace"] == "" {
+		labels["namespace"] = "default"
+	}
+
+	annotations := map[string]string{
+		"summary": "Synthetic test alert from /api/v1/test-alert",
+	}
+	for k, v := range req.Annotations {
+		annotations[k] = v
+	}
+	return Alert{
+		Labels:      labels,
+		Annotations: annotations,
+		Status:      "firing",
+		StartsAt:    time.Now(),
+	}
+}
+
+// resolveTestTarget checks the workload exists and, like a real pod alert
+// would, names one of its running pods when no pod was given
+func resolveTestTarget(ctx context.Context, alert *Alert, trace *decisionTrace) {
+	if clients.Kube == nil {
+		return
+	}
+	ns, app := alert.Labels["namespace"], alert.Labels["app"]
+	if app == "" {
+		return
+	}
+	deploy, err := clients.Kube.AppsV1().Deployments(ns).Get(ctx, app, metav1.GetOptions{})
+	if err != nil {
+		trace.add("target", traceInfo, fmt.Sprintf("deployment %s/%s: %v", ns, app, err))
+	} else {
+		trace.add("target", traceInfo, fmt.Sprintf("deployment %s/%s has %d/%d ready replicas",
+			ns, app, deploy.Status.ReadyReplicas, deploy.Status.Replicas))
+	}
+	if alert.Labels["pod"] != "" {
+		return
+	}
+	pods, err := clients.Kube.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{LabelSelector: "app=" + app})
+	if err != nil {
+		return
+	}
+	for _, p := range pods.Items {
+		if p.Status.Phase == corev1.PodRunning {
+			alert.Labels["pod"] = p.Name
+			trace.add("target", traceInfo, "using running pod "+p.Name)
+			return
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Remediation traces and latency exemplars. Every remediation gets a W3C
+// trace ID, continuing the trace of the webhook request when it carries a
+// `traceparent` header. With OTEL_EXPORTER_OTLP_ENDPOINT set, each finished
+// remediation is sent as an OTLP/HTTP trace: one span for the remediation and
+// one child span per runbook or workflow step.
+//
+// Two histograms measure how long remediations take:
+//   - selfhealing_action_duration_seconds{action,outcome}: running the action
+//   - selfhealing_alert_to_action_seconds{action}: from the alert starting to
+//     fire until its action finished
+//
+// When a scraper asks for OpenMetrics (Prometheus does by default) and tracing
+// is on, every bucket carries an exemplar with the trace ID of the latest
+// remediation that fell into it, so a slow bucket in Grafana links straight
+// to its trace. Prometheus keeps exemplars with --enable-feature=exemplar-storage.
+
+var (
+	otlpEndpoint = strings.TrimRight(envString("OTEL_EXPORTER_OTLP_ENDPOINT", ""), "/")
+	otlpService  = envString("OTEL_SERVICE_NAME", "self-healing-operator")
+
+	latencyBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+	latencyMu      sync.Mutex
+	actionDuration = map[string]*histogram{} // action|outcome
+	alertToAction  = map[string]*histogram{} // action
+)
+
+// histogram has per-bucket counts over its buckets (latencyBuckets unless
+// set), the last one being +Inf
+type histogram struct {
+	buckets   []float64
+	counts    []uint64
+	exemplars []exemplar
+	sum       float64
+	count     uint64
+}
+
+type exemplar struct {
+	traceID string
+	value   float64
+	at      time.Time
+}
+
+func init() {
+	registerMetrics(writeLatencyMetrics)
+	onRemediation(observeLatency)
+}
+
+func setupTracing() {
+	if otlpEndpoint == "" {
+		return
+	}
+	onRemediation(exportTrace)
+	log.Printf("Remediation traces enabled — exporting to %s/v1/traces", otlpEndpoint)
+}
+
+func tracingEnabled() bool {
+	return otlpEndpoint != ""
+}
+
+type traceParentKey struct{}
+
+// withTraceParent remembers an incoming W3C traceparent header, if valid
+func withTraceParent(ctx context.Context, header string) context.Context {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return ctx
+	}
+	if _, err := hex.DecodeString(parts[1] + parts[2]); err != nil || strings.Trim(parts[1], "0") == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, traceParentKey{}, [2]string{parts[1], parts[2]})
+}
+
+// startTrace gives the action its trace ID: the incoming request's, or a new one
+func startTrace(ctx context.Context, action *RecoveryAction) {
+	if parent, ok := ctx.Value(traceParentKey{}).([2]string); ok {
+		action.TraceID, action.parentSpan = parent[0], parent[1]
+		return
+	}
+	action.TraceID = randomHex(16)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (h *histogram) bounds() []float64 {
+	if h.buckets != nil {
+		return h.buckets
+	}
+	return latencyBuckets
+}
+
+func (h *histogram) observe(v float64, traceID string, at time.Time) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(h.bounds())+1)
+		h.exemplars = make([]exemplar, len(h.bounds())+1)
+	}
+	i := sort.SearchFloat64s(h.bounds(), v)
+	h.counts[i]++
+	if traceID != "" {
+		h.exemplars[i] = exemplar{traceID: traceID, value: v, at: at}
+	}
+	h.sum += v
+	h.count++
+}
+
+// observeLatency adds a finished remediation to the histograms
+func observeLatency(rec ActionRecord) {
+	took, err := time.ParseDuration(rec.Duration)
+	if err != nil {
+		return
+	}
+	finished := rec.StartedAt.Add(took)
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+	key := rec.Action + "|" + rec.Outcome
+	if actionDuration[key] == nil {
+		actionDuration[key] = &histogram{}
+	}
+	actionDuration[key].observe(took.Seconds(), rec.TraceID, finished)
+	if !rec.alertStartsAt.IsZero() && rec.alertStartsAt.Before(finished) {
+		if alertToAction[rec.Action] == nil {
+			alertToAction[rec.Action] = &histogram{}
+		}
+		alertToAction[rec.Action].observe(finished.Sub(rec.alertStartsAt).Seconds(), rec.TraceID, finished)
+	}
+}
+
+func writeLatencyMetrics(w io.Writer) {
+	_, withExemplars := w.(exemplarWriter)
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_action_duration_seconds Time spent running recovery actions, by action and outcome.")
+	fmt.Fprintln(w, "# TYPE selfhealing_action_duration_seconds histogram")
+	for _, key := range sortedKeys(actionDuration) {
+		action, outcome, _ := strings.Cut(key, "|")
+		writeHistogram(w, "selfhealing_action_duration_seconds", fmt.Sprintf("action=%q,outcome=%q", action, outcome), actionDuration[key], withExemplars)
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_alert_to_action_seconds Time from an alert starting to fire until its recovery action finished.")
+	fmt.Fprintln(w, "# TYPE selfhealing_alert_to_action_seconds histogram")
+	for _, action := range sortedKeys(alertToAction) {
+		writeHistogram(w, "selfhealing_alert_to_action_seconds", fmt.Sprintf("action=%q", action), alertToAction[action], withExemplars)
+	}
+}
+
+func writeHistogram(w io.Writer, name, labels string, h *histogram, withExemplars bool) {
+	var cumulative uint64
+	for i, n := range h.counts {
+		cumulative += n
+		le := "+Inf"
+		if i < len(h.bounds()) {
+			le = strconv.FormatFloat(h.bounds()[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d", name, labels, le, cumulative)
+		if ex := h.exemplars[i]; withExemplars && ex.traceID != "" {
+			fmt.Fprintf(w, " # {trace_id=%q} %g %.3f", ex.traceID, ex.value, float64(ex.at.UnixMilli())/1000)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+// exportTrace sends a finished remediation to the OTLP/HTTP collector
+func exportTrace(rec ActionRecord) {
+	if rec.TraceID == "" {
+		return
+	}
+	took, _ := time.ParseDuration(rec.Duration)
+	root := randomHex(8)
+	name := rec.Action
+	if rec.Name != "" {
+		name += " " + rec.Name
+	}
+	status := map[string]interface{}{"code": 1}
+	if rec.Outcome == "failed" {
+		status = map[string]interface{}{"code": 2, "message": rec.Error}
+	}
+	spans := []map[string]interface{}{{
+		"traceId":           rec.TraceID,
+		"spanId":            root,
+		"parentSpanId":      rec.parentSpan,
+		"name":              "remediate " + name,
+		"kind":              1, // internal
+		"startTimeUnixNano": strconv.FormatInt(rec.StartedAt.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(rec.StartedAt.Add(took).UnixNano(), 10),
+		"attributes": otlpAttributes(map[string]string{
+			"selfhealing.alertname": rec.AlertName,
+			"selfhealing.action":    rec.Action,
+			"selfhealing.outcome":   rec.Outcome,
+			"k8s.namespace.name":    rec.Namespace,
+			"k8s.pod.name":          rec.Pod,
+			"selfhealing.app":       rec.App,
+			"selfhealing.history":   strconv.Itoa(rec.ID),
+		}),
+		"status": status,
+	}}
+	for _, step := range rec.Steps {
+		stepTook, _ := time.ParseDuration(step.Duration)
+		stepStatus := map[string]interface{}{"code": 1}
+		if step.Status == "failed" {
+			stepStatus = map[string]interface{}{"code": 2, "message": step.Error}
+		}
+		spans = append(spans, map[string]interface{}{
+			"traceId":           rec.TraceID,
+			"spanId":            randomHex(8),
+			"parentSpanId":      root,
+			"name":              "step " + step.Name,
+			"kind":              1,
+			"startTimeUnixNano": strconv.FormatInt(step.StartedAt.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(step.StartedAt.Add(stepTook).UnixNano(), 10),
+			"attributes": otlpAttributes(map[string]string{
+				"selfhealing.action": step.Action,
+				"selfhealing.status": step.Status,
+			}),
+			"status": stepStatus,
+		})
+	}
+
+	body := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]string{"service.name": otlpService}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "self-healing-operator"},
+				"spans": spans,
+			}},
+		}},
+	}
+	ctx, cancel := context.WithTimeout(operatorCtx, 10*time.Second)
+	defer cancel()
+	if err := postJSON(ctx, "otlp", otlpEndpoint+"/v1/traces", body, nil); err != nil {
+		log.Printf("Failed to export trace %s for history record #%d: %v", rec.TraceID, rec.ID, err)
+	}
+}
+
+// otlpAttributes converts string attributes to OTLP key/values, skipping empty ones
+func otlpAttributes(attrs map[string]string) []map[string]interface{} {
+	var out []map[string]interface{}
+	for _, k := range sortedKeys(attrs) {
+		if attrs[k] != "" {
+			out = append(out, map[string]interface{}{"key": k, "value": map[string]string{"stringValue": attrs[k]}})
+		}
+	}
+	return out
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Live tuning: GET /api/v1/tuning on the admin listener shows the settings
+// on-call may change mid-incident, and PATCH /api/v1/tuning with a JSON
+// object of name → value changes them without a redeploy:
+//
+//	curl -X PATCH localhost:9090/api/v1/tuning -H "Authorization: Bearer $ADMIN_TOKEN" \
+//	  -d '{"cooldown":"10m","spread-batch-size":"5"}'
+//
+// A change is validated as a whole, logged, and saved to the store (the
+// SelfHealingState CR or the database), so it survives restarts and
+// overrides the environment from then on. With STORE=memory it lasts until
+// the next restart.
+
+type tunable struct {
+	get func() string
+	set func(string) error
+}
+
+var tunables = map[string]tunable{
+	"cooldown": {
+		get: func() string { return currentCooldown().String() },
+		set: durationSetter(0, func(d time.Duration) {
+			cooldownMu.Lock()
+			cooldownTime = d
+			cooldownMu.Unlock()
+		}),
+	},
+	"workload-lock-timeout": {
+		get: func() string { return workloadLocks.waitTimeout().String() },
+		set: durationSetter(time.Second, func(d time.Duration) {
+			workloadLocks.mu.Lock()
+			workloadLocks.timeout = d
+			workloadLocks.mu.Unlock()
+		}),
+	},
+	"spread-batch-size": {
+		get: func() string { return strconv.Itoa(currentSpread().batchSize) },
+		set: intSetter(func(n int) { setSpread(func(c *spreadConfig) { c.batchSize = n }) }),
+	},
+	"spread-interval": {
+		get: func() string { return currentSpread().interval.String() },
+		set: durationSetter(time.Second, func(d time.Duration) { setSpread(func(c *spreadConfig) { c.interval = d }) }),
+	},
+	"spread-jitter": {
+		get: func() string { return currentSpread().jitter.String() },
+		set: durationSetter(0, func(d time.Duration) { setSpread(func(c *spreadConfig) { c.jitter = d }) }),
+	},
+	"effectiveness-window": {
+		get: func() string { return effectiveness.settings().window.String() },
+		set: durationSetter(time.Minute, func(d time.Duration) { effectiveness.tune(func(t *effectivenessTracker) { t.window = d }) }),
+	},
+	"effectiveness-min-score": {
+		get: func() string { return strconv.FormatFloat(effectiveness.settings().minScore, 'g', -1, 64) },
+		set: func(v string) error {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f < 0 || f > 1 {
+				return fmt.Errorf("want a number between 0 and 1")
+			}
+			effectiveness.tune(func(t *effectivenessTracker) { t.minScore = f })
+			return nil
+		},
+	},
+	"effectiveness-min-samples": {
+		get: func() string { return strconv.Itoa(effectiveness.settings().minSamples) },
+		set: intSetter(func(n int) { effectiveness.tune(func(t *effectivenessTracker) { t.minSamples = n }) }),
+	},
+	"action-rate-limit": {
+		get: func() string { return strconv.Itoa(currentActionRateLimit()) },
+		set: intSetter(func(n int) {
+			actionRateMu.Lock()
+			actionRateLimit = n
+			actionRateMu.Unlock()
+		}),
+	},
+	"log-sample-rate": {
+		get: func() string { return strconv.Itoa(decisionLog.sampleRate()) },
+		set: intSetter(func(n int) {
+			decisionLog.mu.Lock()
+			decisionLog.rate = n
+			decisionLog.mu.Unlock()
+		}),
+	},
+}
+
+// durationSetter parses durations of at least min; 0 would make some
+// settings meaningless (a lock wait that may fail at once, an effectiveness
+// window that's over before anything can refire)
+func durationSetter(min time.Duration, apply func(time.Duration)) func(string) error {
+	return func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < min {
+			if min == 0 {
+				return fmt.Errorf("want a non-negative duration like 90s or 5m")
+			}
+			return fmt.Errorf("want a duration of at least %s, like 90s or 5m", min)
+		}
+		apply(d)
+		return nil
+	}
+}
+
+func intSetter(apply func(int)) func(string) error {
+	return func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("want a non-negative integer")
+		}
+		apply(n)
+		return nil
+	}
+}
+
+func currentTuning() map[string]string {
+	out := map[string]string{}
+	for name, t := range tunables {
+		out[name] = t.get()
+	}
+	return out
+}
+
+// applyTuning applies all changes or none
+func applyTuning(changes map[string]string) error {
+	var problems []string
+	for _, name := range sortedKeys(changes) {
+		if _, ok := tunables[name]; !ok {
+			problems = append(problems, fmt.Sprintf("%s: unknown setting", name))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+
+	// setters validate as they parse, so a bad value puts back the ones
+	// already applied
+	old := currentTuning()
+	for _, name := range sortedKeys(changes) {
+		if err := tunables[name].set(changes[name]); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if len(problems) > 0 {
+		for _, name := range sortedKeys(changes) {
+			tunables[name].set(old[name])
+		}
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// restoreTuning applies settings saved by an earlier run
+func restoreTuning(saved map[string]string) {
+	for _, name := range sortedKeys(saved) {
+		t, ok := tunables[name]
+		if !ok {
+			continue
+		}
+		if err := t.set(saved[name]); err != nil {
+			log.Printf("Ignoring saved tuning %s=%q: %v", name, saved[name], err)
+		}
+	}
+}
+
+func handleTuning(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch, http.MethodPut:
+		user, ok := authorizeRequest(w, r, verbTune, "")
+		if !ok {
+			return
+		}
+		var changes map[string]string
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&changes); err != nil {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		before := currentTuning()
+		if err := applyTuning(changes); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		after := currentTuning()
+		for _, name := range sortedKeys(changes) {
+			log.Printf("Tuning changed via admin API%s — %s: %s -> %s", byUser(user), name, before[name], after[name])
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), storeTimeout)
+		defer cancel()
+		if err := store.SaveTuning(ctx, after); err != nil {
+			log.Printf("Failed to persist tuning to %s store: %v", store.Name(), err)
+			http.Error(w, "applied, but not saved: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentTuning())
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Config validation: `self-healing-operator --validate-config` checks the
+// configuration the operator would start with and exits, so a pipeline can
+// gate a rollout of the operator's settings. Run it with the operator's
+// environment and mounts, e.g. as an init container or a Job using the same
+// ServiceAccount; `--offline` skips everything that needs the cluster or the
+// network, for linting ConfigMaps in CI.
+//
+// It prints one JSON document to stdout:
+//
+//	{"valid": false, "exitCode": 12, "checks": [
+//	  {"category": "rbac", "name": "delete pods", "status": "fail", "message": "not allowed"}, ...]}
+//
+// Checks, in order, and the exit code when one of them fails:
+//   - config (10): settings that don't parse, MODE, STORE, conflicting settings
+//   - policies (11): workflows, notification templates, preconditions,
+//     action flags, SEVERITY_ACTIONS, policy bundle keys
+//   - rbac (12): the ServiceAccount may do what the configured features need
+//     (a SelfSubjectAccessReview each)
+//   - connectivity (13): API server, store, Prometheus, NATS, Kafka bridge
+//
+// The exit code is that of the first category with a failure; warnings don't
+// fail. 0 means every check passed, 2 is a usage error.
+
+const (
+	exitConfigInvalid  = 10
+	exitPolicyInvalid  = 11
+	exitRBACMissing    = 12
+	exitUnreachable    = 13
+	validateCheckLimit = 10 * time.Second
+)
+
+type validationCheck struct {
+	Category string `json:"category"` // config, policies, rbac, connectivity
+	Name     string `json:"name"`
+	Status   string `json:"status"` // pass, warn, fail
+	Message  string `json:"message,omitempty"`
+}
+
+type validationReport struct {
+	Valid    bool              `json:"valid"`
+	ExitCode int               `json:"exitCode"`
+	Checks   []validationCheck `json:"checks"`
+}
+
+func (r *validationReport) add(category, name string, err error) {
+	c := validationCheck{Category: category, Name: name, Status: "pass"}
+	if err != nil {
+		c.Status, c.Message = "fail", err.Error()
+	}
+	r.Checks = append(r.Checks, c)
+}
+
+func (r *validationReport) warn(category, name, message string) {
+	r.Checks = append(r.Checks, validationCheck{Category: category, Name: name, Status: "warn", Message: message})
+}
+
+// rbacRequirement is a permission the operator needs, when needed says so
+type rbacRequirement struct {
+	verb, group, resource, subresource string
+	needed                             bool
+}
+
+func runValidateConfig(args []string) int {
+	offline := false
+	for _, a := range args {
+		switch a {
+		case "--offline":
+			offline = true
+		default:
+			fmt.Fprintln(os.Stderr, "usage: self-healing-operator --validate-config [--offline]")
+			return 2
+		}
+	}
+	report := validateConfig(offline)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+	return report.ExitCode
+}
+
+func validateConfig(offline bool) *validationReport {
+	r := &validationReport{Checks: []validationCheck{}}
+	validateSettings(r)
+	validatePolicies(r)
+	if offline {
+		r.warn("rbac", "permissions", "skipped (--offline)")
+		r.warn("connectivity", "endpoints", "skipped (--offline)")
+	} else {
+		validateCluster(r)
+	}
+
+	for _, step := range []struct {
+		category string
+		code     int
+	}{{"config", exitConfigInvalid}, {"policies", exitPolicyInvalid}, {"rbac", exitRBACMissing}, {"connectivity", exitUnreachable}} {
+		for _, c := range r.Checks {
+			if c.Category == step.category && c.Status == "fail" {
+				r.ExitCode = step.code
+				return r
+			}
+		}
+	}
+	r.Valid = true
+	return r
+}
+
+func validateSettings(r *validationReport) {
+	settingsMu.Lock()
+	invalid := append([]string(nil), invalidSettings...)
+	settingsMu.Unlock()
+	for _, s := range invalid {
+		r.add("config", "setting", fmt.Errorf("%s", s))
+	}
+	if len(invalid) == 0 {
+		r.add("config", "settings", nil)
+	}
+
+	var err error
+	if operatingMode != modeActive && operatingMode != modeRecommend {
+		err = fmt.Errorf("invalid MODE %q (want %q or %q)", operatingMode, modeActive, modeRecommend)
+	}
+	r.add("config", "MODE", err)
+
+	err = nil
+	if kind := envString("STORE", "memory"); kind != "memory" && kind != "crd" {
+		if _, ok := sqlDrivers[kind]; !ok {
+			err = fmt.Errorf("unknown STORE %q", kind)
+		} else if os.Getenv("STORE_DSN") == "" {
+			err = fmt.Errorf("STORE=%s needs STORE_DSN", kind)
+		}
+	}
+	r.add("config", "STORE", err)
+
+	err = nil
+	if _, ok := journalBackends[journalBackendKind]; !ok {
+		err = fmt.Errorf("unknown JOURNAL_BACKEND %q", journalBackendKind)
+	}
+	r.add("config", "JOURNAL_BACKEND", err)
+
+	conflicts := []struct {
+		name string
+		bad  bool
+		msg  string
+	}{
+		{"ADMIN_TOKEN", os.Getenv("ADMIN_TOKEN") != "" && apiAuthz == "kubernetes", "ADMIN_TOKEN and API_AUTHZ=kubernetes can't be combined"},
+		{"EVENTS_NATS_SUBJECT", eventsNATSSubject != "" && natsURL == "", "EVENTS_NATS_SUBJECT requires NATS_URL"},
+		{"EVENTS_KAFKA_TOPIC", eventsKafkaTopic != "" && kafkaBridgeURL == "", "EVENTS_KAFKA_TOPIC requires KAFKA_BRIDGE_URL"},
+		{"KAFKA_TOPICS", len(kafkaTopics) > 0 && kafkaBridgeURL == "", "KAFKA_TOPICS requires KAFKA_BRIDGE_URL"},
+	}
+	for _, c := range conflicts {
+		err = nil
+		if c.bad {
+			err = fmt.Errorf("%s", c.msg)
+		}
+		r.add("config", c.name, err)
+	}
+
+	if observationPeriod > 0 && envString("STORE", "memory") == "memory" {
+		r.warn("config", "OBSERVATION_PERIOD", "with the memory store every restart starts the burn-in of all workloads over")
+	}
+
+	r.add("config", "CRYPTO_POLICY", setupCryptoPolicy())
+	r.add("config", "audit signing", setupAuditSigning())
+	r.add("config", "egress", setupEgress())
+	r.add("config", "REMEDIATION_TAGS", checkRemediationTags())
+
+	for _, setting := range credentialSettings {
+		if _, isRef, err := parseSecretRef(os.Getenv(setting)); isRef {
+			r.add("config", setting, err)
+		}
+	}
+}
+
+func validatePolicies(r *validationReport) {
+	r.add("policies", "workflows", loadWorkflows(envString("WORKFLOWS_FILE", "/etc/self-healing/workflows.yaml")))
+	r.add("policies", "notification templates", loadNotificationTemplates(envString("NOTIFICATION_TEMPLATES_FILE", "/etc/self-healing/notifications.yaml")))
+	if file := os.Getenv("PRECONDITIONS_FILE"); file != "" {
+		r.add("policies", "preconditions", loadPreconditions(file))
+	}
+	if file := os.Getenv("LABEL_MAPPING_FILE"); file != "" {
+		r.add("policies", "label mapping", loadLabelMapping(file))
+	}
+	_, err := reloadActionFlags()
+	r.add("policies", "action flags", err)
+
+	var bad []string
+	for _, part := range strings.Split(defaultSeverityActions, ",") {
+		if part = strings.TrimSpace(part); part != "" && !strings.Contains(part, "=") {
+			bad = append(bad, part)
+		}
+	}
+	if len(bad) > 0 {
+		r.warn("policies", "SEVERITY_ACTIONS", fmt.Sprintf("entries without severity= are ignored: %s", strings.Join(bad, ", ")))
+	} else {
+		r.add("policies", "SEVERITY_ACTIONS", nil)
+	}
+
+	if len(policyBundleKeyFiles) > 0 {
+		_, err := policyBundleKeys()
+		r.add("policies", "POLICY_BUNDLE_KEYS", err)
+	}
+}
+
+func validateCluster(r *validationReport) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		r.add("connectivity", "kubernetes", fmt.Errorf("no in-cluster config (run in a pod, or pass --offline): %v", err))
+		return
+	}
+	kube, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		r.add("connectivity", "kubernetes", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), validateCheckLimit)
+	defer cancel()
+	version, err := kube.Discovery().ServerVersion()
+	if err != nil {
+		r.add("connectivity", "kubernetes", err)
+		return
+	}
+	r.add("connectivity", "kubernetes", nil)
+	r.Checks[len(r.Checks)-1].Message = "server " + version.GitVersion
+
+	storeKind := envString("STORE", "memory")
+	for _, req := range []rbacRequirement{
+		{"delete", "", "pods", "", true},
+		{"create", "", "pods", "eviction", true},
+		{"update", "apps", "deployments", "", true},
+		{"patch", "", "nodes", "", true},
+		{"create", "", "events", "", true},
+		{"update", "", "namespaces", "finalize", len(finalizerAllowlist) > 0},
+		{"update", "admissionregistration.k8s.io", "validatingwebhookconfigurations", "", webhookWatch},
+		{"update", "monitoring.coreos.com", "prometheusrules", "", policyRulesEnabled},
+		{"update", "selfhealing.io", "remediationpolicies", "status", policyRulesEnabled},
+		{"update", "selfhealing.io", "selfhealingchecks", "status", checksEnabled},
+		{"create", "apps", "deployments", "", checksEnabled},
+		{"patch", "", "pods", "", checksEnabled},
+		{"update", "selfhealing.io", "selfhealingstates", "", storeKind == "crd"},
+		{"create", "coordination.k8s.io", "leases", "", envBool("SHARDING", false)},
+		{"create", "", "serviceaccounts", "token", os.Getenv("SCOPED_CLIENTS") == "true"},
+		{"create", "authentication.k8s.io", "tokenreviews", "", apiAuthz == "kubernetes"},
+		{"create", "authorization.k8s.io", "subjectaccessreviews", "", apiAuthz == "kubernetes"},
+	} {
+		if !req.needed {
+			continue
+		}
+		name := req.verb + " " + req.resource
+		if req.subresource != "" {
+			name += "/" + req.subresource
+		}
+		review, err := kube.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Verb: req.verb, Group: req.group, Resource: req.resource, Subresource: req.subresource,
+			}},
+		}, metav1.CreateOptions{})
+		switch {
+		case err != nil:
+			r.add("rbac", name, err)
+		case !review.Status.Allowed:
+			msg := "not allowed cluster-wide"
+			if review.Status.Reason != "" {
+				msg += ": " + review.Status.Reason
+			}
+			r.add("rbac", name, fmt.Errorf("%s", msg))
+		default:
+			r.add("rbac", name, nil)
+		}
+	}
+
+	secretKube = kube
+	for _, setting := range credentialSettings {
+		if ref, isRef, err := parseSecretRef(os.Getenv(setting)); isRef && err == nil {
+			_, err := fetchSecret(ctx, setting, ref)
+			r.add("connectivity", "secret "+setting, err)
+		}
+	}
+
+	if err := setupStore(config); err != nil {
+		r.add("connectivity", "store", err)
+	} else {
+		_, err := store.LoadTuning(ctx)
+		r.add("connectivity", "store "+store.Name(), err)
+	}
+	if os.Getenv("PRECONDITIONS_FILE") != "" {
+		r.add("connectivity", "prometheus", probeURL(ctx, "prometheus", prometheusURL+"/-/ready"))
+	}
+	if natsURL != "" {
+		c, err := dialNATS(ctx, natsURL)
+		if err == nil {
+			c.conn.Close()
+		}
+		r.add("connectivity", "nats", err)
+	}
+	if kafkaBridgeURL != "" {
+		var topics []string
+		r.add("connectivity", "kafka bridge", kafkaCall(ctx, http.MethodGet, kafkaBridgeURL+"/topics", nil, &topics, kafkaV2))
+	}
+}
+
+// probeURL fails unless a GET returns 2xx
+func probeURL(ctx context.Context, integration, u string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := egressClient(integration).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", u, resp.Status)
+	}
+	return nil
+}
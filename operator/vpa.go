@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+)
+
+// VPA recommendations. Clusters that run the Vertical Pod Autoscaler with
+// `updateMode: "Off"` get recommendations but nothing applies them.
+// `recovery_action: apply_vpa_recommendation` (also a workflow step) does,
+// on the alert that shows the workload is undersized: it finds the VPA whose
+// targetRef is the alert's Deployment and sets each container's requests to
+// the recommended target. Limits keep their ratio to the requests, as the
+// VPA updater would do, unless the VPA says controlledValues: RequestsOnly.
+// Containers whose policy is mode: "Off" are left alone, and a change
+// smaller than VPA_MIN_CHANGE (a fraction, default 0.1) isn't worth a
+// rollout, so the action fails if every container is already close.
+
+const vpaAPIVersion = "autoscaling.k8s.io/v1"
+
+var vpaMinChange = envFloat("VPA_MIN_CHANGE", 0.1)
+
+func vpaPath(namespace string) string {
+	return path.Join("/apis", vpaAPIVersion, "namespaces", namespace, "verticalpodautoscalers")
+}
+
+// findVPA returns the VPA targeting the Deployment, or nil
+func findVPA(ctx context.Context, cs kubernetes.Interface, namespace, deployment string) (*unstructured.Unstructured, error) {
+	raw, err := cs.Discovery().RESTClient().Get().AbsPath(vpaPath(namespace)).DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VerticalPodAutoscalers in %s: %v", namespace, err)
+	}
+	var list unstructured.UnstructuredList
+	if err := list.UnmarshalJSON(raw); err != nil {
+		return nil, fmt.Errorf("failed to list VerticalPodAutoscalers in %s: %v", namespace, err)
+	}
+	for i := range list.Items {
+		kind, _, _ := unstructured.NestedString(list.Items[i].Object, "spec", "targetRef", "kind")
+		name, _, _ := unstructured.NestedString(list.Items[i].Object, "spec", "targetRef", "name")
+		if kind == "Deployment" && name == deployment {
+			return &list.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// vpaTargets reads status.recommendation: container name -> target requests
+func vpaTargets(vpa *unstructured.Unstructured) map[string]corev1.ResourceList {
+	recs, _, _ := unstructured.NestedSlice(vpa.Object, "status", "recommendation", "containerRecommendations")
+	out := map[string]corev1.ResourceList{}
+	for _, r := range recs {
+		rec, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _, _ := unstructured.NestedString(rec, "containerName")
+		target, _, _ := unstructured.NestedStringMap(rec, "target")
+		list := corev1.ResourceList{}
+		for res, v := range target {
+			if q, err := resource.ParseQuantity(v); err == nil {
+				list[corev1.ResourceName(res)] = q
+			}
+		}
+		if name != "" && len(list) > 0 {
+			out[name] = list
+		}
+	}
+	return out
+}
+
+// vpaContainerPolicy returns the mode and controlledValues the VPA sets for a container
+func vpaContainerPolicy(vpa *unstructured.Unstructured, container string) (mode, controlled string) {
+	policies, _, _ := unstructured.NestedSlice(vpa.Object, "spec", "resourcePolicy", "containerPolicies")
+	for _, name := range []string{container, "*"} {
+		for _, p := range policies {
+			policy, ok := p.(map[string]interface{})
+			if !ok || policy["containerName"] != name {
+				continue
+			}
+			mode, _, _ = unstructured.NestedString(policy, "mode")
+			controlled, _, _ = unstructured.NestedString(policy, "controlledValues")
+			return mode, controlled
+		}
+	}
+	return "", ""
+}
+
+// applyVPARecommendation sets the Deployment's requests to its VPA's targets
+func applyVPARecommendation(ctx context.Context, cs kubernetes.Interface, action *RecoveryAction) (*appsv1.Deployment, error) {
+	dep, err := findDeployment(ctx, cs, action.Namespace, action.App)
+	if err != nil {
+		return nil, err
+	}
+	vpa, err := findVPA(ctx, cs, action.Namespace, dep.Name)
+	if err != nil {
+		return nil, err
+	}
+	if vpa == nil {
+		return nil, fmt.Errorf("no VerticalPodAutoscaler targets deployment %s/%s", dep.Namespace, dep.Name)
+	}
+	targets := vpaTargets(vpa)
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("VerticalPodAutoscaler %s/%s has no recommendation yet", vpa.GetNamespace(), vpa.GetName())
+	}
+	if err := verifyPodImages(ctx, dep.Spec.Template.Spec); err != nil {
+		return nil, fmt.Errorf("refusing to roll %s/%s: %v", dep.Namespace, dep.Name, err)
+	}
+
+	var changes []string
+	_, updated, err := updateDeployment(ctx, cs, dep, func(dep *appsv1.Deployment) error {
+		var err error
+		changes, err = setVPARequests(dep, vpa, targets)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Applied VPA %s recommendation to deployment %s/%s: %s", vpa.GetName(), dep.Namespace, dep.Name, strings.Join(changes, ", "))
+	action.Explain.add("vpa", traceInfo, strings.Join(changes, ", "))
+	emitDeployMarker(action, updated.Name, updated.Generation)
+	return updated, nil
+}
+
+// setVPARequests sets the template's requests to the VPA's targets and
+// describes each change
+func setVPARequests(dep *appsv1.Deployment, vpa *unstructured.Unstructured, targets map[string]corev1.ResourceList) ([]string, error) {
+	var changes []string
+	for i := range dep.Spec.Template.Spec.Containers {
+		c := &dep.Spec.Template.Spec.Containers[i]
+		target, ok := targets[c.Name]
+		mode, controlled := vpaContainerPolicy(vpa, c.Name)
+		if !ok || mode == "Off" {
+			continue
+		}
+		for _, res := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			want, ok := target[res]
+			if !ok {
+				continue
+			}
+			have, hasRequest := c.Resources.Requests[res]
+			if hasRequest && !significantChange(have, want) {
+				continue
+			}
+			if c.Resources.Requests == nil {
+				c.Resources.Requests = corev1.ResourceList{}
+			}
+			c.Resources.Requests[res] = want
+			change := fmt.Sprintf("%s %s: %s -> %s", c.Name, res, have.String(), want.String())
+			if limit, ok := c.Resources.Limits[res]; ok && hasRequest && !have.IsZero() && controlled != "RequestsOnly" {
+				// keep the limit/request ratio, like the VPA updater
+				ratio := float64(want.MilliValue()) / float64(have.MilliValue())
+				scaled := resource.NewMilliQuantity(int64(float64(limit.MilliValue())*ratio), resource.DecimalSI)
+				if res == corev1.ResourceMemory {
+					scaled = resource.NewQuantity(int64(float64(limit.Value())*ratio), resource.BinarySI)
+				}
+				c.Resources.Limits[res] = *scaled
+				change += fmt.Sprintf(" (limit %s -> %s)", limit.String(), scaled.String())
+			}
+			if limit, ok := c.Resources.Limits[res]; ok && want.Cmp(limit) > 0 {
+				return nil, fmt.Errorf("recommended %s request for %s/%s container %s (%s) is above its limit %s",
+					res, dep.Namespace, dep.Name, c.Name, want.String(), limit.String())
+			}
+			changes = append(changes, change)
+		}
+	}
+	if len(changes) == 0 {
+		return nil, fmt.Errorf("%s/%s already runs within %.0f%% of its VPA recommendation", dep.Namespace, dep.Name, vpaMinChange*100)
+	}
+	return changes, nil
+}
+
+// significantChange reports whether want differs from have by more than VPA_MIN_CHANGE
+func significantChange(have, want resource.Quantity) bool {
+	h, w := float64(have.MilliValue()), float64(want.MilliValue())
+	if h == 0 {
+		return w != 0
+	}
+	diff := (w - h) / h
+	return diff > vpaMinChange || diff < -vpaMinChange
+}
+
+func applyVPA(ctx context.Context, c *Clients, action *RecoveryAction) error {
+	cs, err := c.For(ctx, action.Namespace)
+	if err != nil {
+		return err
+	}
+	updated, err := applyVPARecommendation(ctx, cs, action)
+	if err != nil {
+		return err
+	}
+	action.rollout = &rolloutWatch{cs: cs, namespace: updated.Namespace, name: updated.Name, generation: updated.Generation}
+	return nil
+}
+
+func stepApplyVPA(ctx context.Context, rc *runbookContext) error {
+	updated, err := applyVPARecommendation(ctx, rc.cs, rc.action)
+	if err != nil {
+		return err
+	}
+	rc.deployment, rc.generation = updated.Name, updated.Generation
+	return nil
+}
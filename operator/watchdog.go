@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Watchdog: Prometheus fires an always-on heartbeat alert (WATCHDOG_ALERTNAME)
+// that Alertmanager re-sends every repeat_interval. If it hasn't arrived for
+// WATCHDOG_TIMEOUT, the alerting pipeline is broken — not the cluster — so the
+// operator escalates on its own and reports not ready on /ready until
+// heartbeats come back.
+//
+// The Service sets publishNotReadyAddresses, otherwise a not-ready operator
+// would drop out of the endpoints and never receive the heartbeat that
+// recovers it.
+
+type watchdogState struct {
+	alertName string
+	timeout   time.Duration
+
+	mu       sync.Mutex
+	since    time.Time // last heartbeat, or startup before the first one
+	seen     bool
+	tripped  bool
+	trips    int
+	received int
+}
+
+var watchdog = &watchdogState{
+	alertName: envString("WATCHDOG_ALERTNAME", "Watchdog"),
+	timeout:   envDuration("WATCHDOG_TIMEOUT", 0),
+	since:     time.Now(),
+}
+
+func init() {
+	registerMetrics(watchdog.writeMetrics)
+}
+
+func (w *watchdogState) enabled() bool { return w.timeout > 0 }
+
+// observe records a heartbeat; it reports whether the alert was the watchdog
+// so the caller can skip it
+func (w *watchdogState) observe(alert Alert) bool {
+	if alert.Labels["alertname"] != w.alertName {
+		return false
+	}
+	if !w.enabled() || alert.Status != "firing" {
+		// nothing to record; the heartbeat rule never resolves unless Prometheus lost it
+		return true
+	}
+	w.mu.Lock()
+	recovered := w.tripped
+	w.since = time.Now()
+	w.seen = true
+	w.tripped = false
+	w.received++
+	w.mu.Unlock()
+
+	if recovered {
+		log.Printf("Watchdog heartbeat '%s' received again — alerting pipeline recovered", w.alertName)
+		notifySlack(renderMessage("watchdog-recovered", "", map[string]interface{}{"AlertName": w.alertName}))
+	}
+	return true
+}
+
+// missing returns how long the heartbeat has been missing past the timeout, or 0
+func (w *watchdogState) missing() time.Duration {
+	if !w.enabled() {
+		return 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if age := time.Since(w.since); age > w.timeout {
+		return age
+	}
+	return 0
+}
+
+// startWatchdog checks for a missing heartbeat every quarter of the timeout
+func startWatchdog() {
+	if !watchdog.enabled() {
+		return
+	}
+	log.Printf("Watchdog enabled — expecting '%s' at least every %s", watchdog.alertName, watchdog.timeout)
+	interval := watchdog.timeout / 4
+	if interval < 10*time.Second {
+		interval = 10 * time.Second
+	}
+	go func() {
+		for range time.Tick(interval) {
+			watchdog.check()
+		}
+	}()
+}
+
+func (w *watchdogState) check() {
+	age := w.missing()
+	if age == 0 {
+		return
+	}
+	w.mu.Lock()
+	if w.tripped {
+		w.mu.Unlock()
+		return
+	}
+	w.tripped = true
+	w.trips++
+	seen := w.seen
+	w.mu.Unlock()
+
+	reason := fmt.Sprintf("no '%s' heartbeat from Alertmanager for %s", w.alertName, age.Round(time.Second))
+	if !seen {
+		reason = fmt.Sprintf("no '%s' heartbeat from Alertmanager since the operator started %s ago", w.alertName, age.Round(time.Second))
+	}
+	log.Printf("Watchdog tripped — %s; alerting pipeline may be broken", reason)
+
+	action := &RecoveryAction{
+		Action:    "notify",
+		AlertName: "SelfHealingWatchdogMissing",
+		Namespace: envString("POD_NAMESPACE", "default"),
+		App:       "self-healing-operator",
+	}
+	escalate(action, Alert{Labels: map[string]string{"alertname": action.AlertName, "severity": "critical"}}, reason)
+	notifySlack(renderMessage("watchdog-missing", "", map[string]interface{}{"Reason": reason}))
+}
+
+// notifySlack posts an operator status message in the background
+func notifySlack(text string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := postSlack(ctx, text); err != nil {
+			log.Printf("Slack status message failed: %v", err)
+		}
+	}()
+}
+
+// handleReady is the readiness probe: not ready while the heartbeat is missing
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	if age := watchdog.missing(); age > 0 {
+		http.Error(w, fmt.Sprintf("watchdog heartbeat '%s' missing for %s", watchdog.alertName, age.Round(time.Second)),
+			http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ready"))
+}
+
+func (w *watchdogState) writeMetrics(out io.Writer) {
+	if !w.enabled() {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	healthy := 1
+	if w.tripped {
+		healthy = 0
+	}
+	fmt.Fprintln(out, "# HELP selfhealing_watchdog_healthy Whether the Alertmanager heartbeat is arriving in time.")
+	fmt.Fprintln(out, "# TYPE selfhealing_watchdog_healthy gauge")
+	fmt.Fprintf(out, "selfhealing_watchdog_healthy %d\n", healthy)
+	fmt.Fprintln(out, "# HELP selfhealing_watchdog_heartbeats_total Heartbeat alerts received.")
+	fmt.Fprintln(out, "# TYPE selfhealing_watchdog_heartbeats_total counter")
+	fmt.Fprintf(out, "selfhealing_watchdog_heartbeats_total %d\n", w.received)
+	fmt.Fprintln(out, "# HELP selfhealing_watchdog_trips_total Times the heartbeat went missing.")
+	fmt.Fprintln(out, "# TYPE selfhealing_watchdog_trips_total counter")
+	fmt.Fprintf(out, "selfhealing_watchdog_trips_total %d\n", w.trips)
+	if w.seen {
+		fmt.Fprintln(out, "# HELP selfhealing_watchdog_last_heartbeat_timestamp_seconds When the last heartbeat arrived.")
+		fmt.Fprintln(out, "# TYPE selfhealing_watchdog_last_heartbeat_timestamp_seconds gauge")
+		fmt.Fprintf(out, "selfhealing_watchdog_last_heartbeat_timestamp_seconds %d\n", w.since.Unix())
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	harness "self-healing-operator/internal/testing"
+)
+
+// TestWebhookRestartsPod delivers a restart alert to the webhook and checks
+// the pod is gone from a real API server
+func TestWebhookRestartsPod(t *testing.T) {
+	env, err := harness.Start()
+	if errors.Is(err, harness.ErrNoAssets) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Stop()
+
+	saved := clients
+	clients = &Clients{Kube: env.Clientset}
+	defer func() { clients = saved }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := env.CreateDeployment(ctx, "shop", "cart", 1); err != nil {
+		t.Fatal(err)
+	}
+	pod, err := env.CreatePod(ctx, "shop", "cart-1", map[string]string{"app": "cart"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(handleWebhook))
+	defer srv.Close()
+	alert := harness.NewAlert("HighMemoryUsage").Action("restart").Severity("warning").Target("shop", "cart", pod.Name)
+	if _, err := harness.NewSender(srv.URL).Send(ctx, harness.NewPayload(alert)); err != nil {
+		t.Fatal(err)
+	}
+
+	err = harness.Eventually(ctx, 100*time.Millisecond, func() (bool, error) {
+		_, err := env.Clientset.CoreV1().Pods("shop").Get(ctx, pod.Name, metav1.GetOptions{})
+		return apierrors.IsNotFound(err), nil
+	})
+	if err != nil {
+		t.Fatalf("pod %s/%s was not restarted: %v", pod.Namespace, pod.Name, err)
+	}
+	if recs := listHistory(1); len(recs) == 0 || recs[0].Action != "restart" || recs[0].Outcome != "succeeded" {
+		t.Errorf("history = %+v, want a succeeded restart", recs)
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Failing admission webhooks. A ValidatingWebhookConfiguration with
+// failurePolicy: Fail whose Service is down rejects every matching request,
+// so applies fail cluster-wide (often including the rollout that would fix
+// the webhook). Two actions take the webhook out of the way, both only once
+// approved (the decision becomes a recommendation, whatever MODE says):
+//   - webhook_fail_open sets the webhook's failurePolicy to Ignore
+//   - disable_webhook stops the API server calling it at all, through
+//     namespace and object selectors that match nothing
+//
+// The webhook's original settings are kept in the selfhealing.io/webhook-original
+// annotation of its configuration, and restored once the backing Service has
+// had ready endpoints for WEBHOOK_RESTORE_AFTER (checked every
+// WEBHOOK_CHECK_INTERVAL); only Service-backed webhooks are handled, since
+// nothing tells when a URL comes back.
+//
+// The alert names the webhook with a webhook label, or name as the API
+// server's apiserver_admission_webhook_* metrics do, and optionally its
+// configuration with webhookconfiguration; without a webhook, every webhook of
+// the configuration is bypassed. With WEBHOOK_WATCH=true the operator looks
+// for webhooks with failurePolicy Fail whose Service has no ready endpoints
+// itself and feeds an AdmissionWebhookDown alert (recovery_action
+// WEBHOOK_DOWN_ACTION) into the normal decision path.
+
+const (
+	webhookDownAlertName = "AdmissionWebhookDown"
+	webhookOriginalAnno  = "selfhealing.io/webhook-original"
+	// selectors on this label match nothing unless someone sets it
+	webhookDisabledLabel = "selfhealing.io/webhook-disabled"
+)
+
+var (
+	webhookWatch         = envBool("WEBHOOK_WATCH", false)
+	webhookDownAction    = envString("WEBHOOK_DOWN_ACTION", "webhook_fail_open")
+	webhookCheckInterval = envDuration("WEBHOOK_CHECK_INTERVAL", time.Minute)
+	webhookRestoreAfter  = envDuration("WEBHOOK_RESTORE_AFTER", 2*time.Minute)
+
+	webhookMu         sync.Mutex
+	webhookReported   = map[string]bool{}      // configuration/webhook reported down
+	webhookReadySince = map[string]time.Time{} // configuration/webhook whose Service is ready again
+	webhookChanges    = map[string]int{}       // action|result
+	webhookBypassed   int                      // webhooks currently bypassed, as of the last check
+	webhookLoop       sync.Once
+)
+
+// savedWebhook is what a bypass changed in a webhook, to restore it
+type savedWebhook struct {
+	Action            string                         `json:"action"`
+	FailurePolicy     *admissionv1.FailurePolicyType `json:"failurePolicy,omitempty"`
+	NamespaceSelector *metav1.LabelSelector          `json:"namespaceSelector,omitempty"`
+	ObjectSelector    *metav1.LabelSelector          `json:"objectSelector,omitempty"`
+	Since             time.Time                      `json:"since"`
+}
+
+func init() {
+	registerMetrics(writeWebhookMetrics)
+}
+
+func isWebhookAction(action string) bool {
+	return action == "webhook_fail_open" || action == "disable_webhook"
+}
+
+func savedWebhooks(cfg *admissionv1.ValidatingWebhookConfiguration) map[string]savedWebhook {
+	saved := map[string]savedWebhook{}
+	json.Unmarshal([]byte(cfg.Annotations[webhookOriginalAnno]), &saved)
+	return saved
+}
+
+func setSavedWebhooks(cfg *admissionv1.ValidatingWebhookConfiguration, saved map[string]savedWebhook) {
+	if len(saved) == 0 {
+		delete(cfg.Annotations, webhookOriginalAnno)
+		return
+	}
+	data, _ := json.Marshal(saved)
+	if cfg.Annotations == nil {
+		cfg.Annotations = map[string]string{}
+	}
+	cfg.Annotations[webhookOriginalAnno] = string(data)
+}
+
+// webhookTargetFor finds the configuration and webhook names the alert is about
+func webhookTargetFor(ctx context.Context, kube kubernetes.Interface, labels map[string]string) (*admissionv1.ValidatingWebhookConfiguration, []string, error) {
+	api := kube.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	webhook := labels["webhook"]
+	if webhook == "" {
+		webhook = labels["name"]
+	}
+	if name := labels["webhookconfiguration"]; name != "" {
+		cfg, err := api.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get ValidatingWebhookConfiguration %s: %v", name, err)
+		}
+		var names []string
+		for _, wh := range cfg.Webhooks {
+			if webhook == "" || wh.Name == webhook {
+				names = append(names, wh.Name)
+			}
+		}
+		if len(names) == 0 {
+			return nil, nil, fmt.Errorf("ValidatingWebhookConfiguration %s has no webhook %s", name, webhook)
+		}
+		return cfg, names, nil
+	}
+	if webhook == "" {
+		return nil, nil, fmt.Errorf("no webhook, name or webhookconfiguration label in alert for %s", labels["recovery_action"])
+	}
+	list, err := api.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list ValidatingWebhookConfigurations: %v", err)
+	}
+	for i := range list.Items {
+		for _, wh := range list.Items[i].Webhooks {
+			if wh.Name == webhook {
+				return &list.Items[i], []string{webhook}, nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("no ValidatingWebhookConfiguration has a webhook %s", webhook)
+}
+
+// bypassWebhook fails the alert's webhooks open, or disables them, until their Service recovers
+func bypassWebhook(ctx context.Context, c *Clients, action *RecoveryAction) error {
+	// webhook configurations are cluster-scoped, so this uses the operator's own client
+	cfg, names, err := webhookTargetFor(ctx, c.Kube, action.Labels)
+	if err != nil {
+		return err
+	}
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[name] = true
+	}
+	saved := savedWebhooks(cfg)
+	var changed, skipped []string
+	for i := range cfg.Webhooks {
+		wh := &cfg.Webhooks[i]
+		if !wanted[wh.Name] {
+			continue
+		}
+		if _, ok := saved[wh.Name]; ok {
+			skipped = append(skipped, wh.Name+" (already bypassed)")
+			continue
+		}
+		if wh.ClientConfig.Service == nil {
+			skipped = append(skipped, wh.Name+" (calls a URL, so its recovery can't be told)")
+			continue
+		}
+		if action.Action == "webhook_fail_open" && wh.FailurePolicy != nil && *wh.FailurePolicy == admissionv1.Ignore {
+			skipped = append(skipped, wh.Name+" (already fails open)")
+			continue
+		}
+		saved[wh.Name] = savedWebhook{
+			Action:            action.Action,
+			FailurePolicy:     wh.FailurePolicy,
+			NamespaceSelector: wh.NamespaceSelector,
+			ObjectSelector:    wh.ObjectSelector,
+			Since:             time.Now().UTC(),
+		}
+		switch action.Action {
+		case "webhook_fail_open":
+			ignore := admissionv1.Ignore
+			wh.FailurePolicy = &ignore
+		case "disable_webhook":
+			never := &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: webhookDisabledLabel, Operator: metav1.LabelSelectorOpExists},
+			}}
+			wh.NamespaceSelector, wh.ObjectSelector = never, never.DeepCopy()
+		}
+		changed = append(changed, wh.Name)
+	}
+	if len(changed) == 0 {
+		return fmt.Errorf("nothing to change in ValidatingWebhookConfiguration %s: %s", cfg.Name, strings.Join(skipped, ", "))
+	}
+
+	setSavedWebhooks(cfg, saved)
+	if _, err := c.Kube.AdmissionregistrationV1().ValidatingWebhookConfigurations().Update(ctx, cfg, metav1.UpdateOptions{}); err != nil {
+		countWebhookChange(action.Action, "failed")
+		return fmt.Errorf("failed to update ValidatingWebhookConfiguration %s: %v", cfg.Name, err)
+	}
+	countWebhookChange(action.Action, "applied")
+	log.Printf("Bypassed webhook(s) %s of ValidatingWebhookConfiguration %s with '%s' — restoring once the Service is ready for %s",
+		strings.Join(changed, ", "), cfg.Name, action.Action, webhookRestoreAfter)
+	action.Explain.add("webhooks", traceInfo, action.Action+" on "+strings.Join(changed, ", "))
+	startWebhookLoop(c.Kube)
+	return nil
+}
+
+// serviceReadyEndpoints counts the ready addresses of a Service
+func serviceReadyEndpoints(ctx context.Context, kube kubernetes.Interface, namespace, name string) (int, error) {
+	slices, err := kube.DiscoveryV1().EndpointSlices(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: discoveryv1.LabelServiceName + "=" + name,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list endpoints of %s/%s: %v", namespace, name, err)
+	}
+	ready := 0
+	for _, s := range slices.Items {
+		for _, ep := range s.Endpoints {
+			if ep.Conditions.Ready == nil || *ep.Conditions.Ready {
+				ready += len(ep.Addresses)
+			}
+		}
+	}
+	return ready, nil
+}
+
+// startWatchAdmissionWebhooks starts the check loop at startup when it has something to do
+func startWatchAdmissionWebhooks(kube kubernetes.Interface) {
+	if webhookWatch {
+		log.Printf("Watching ValidatingWebhookConfigurations for webhooks whose Service is down")
+		startWebhookLoop(kube)
+		return
+	}
+	// a bypass from before a restart still has to be restored
+	ctx, cancel := context.WithTimeout(operatorCtx, 30*time.Second)
+	defer cancel()
+	list, err := kube.AdmissionregistrationV1().ValidatingWebhookConfigurations().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return
+	}
+	for _, cfg := range list.Items {
+		if cfg.Annotations[webhookOriginalAnno] != "" {
+			startWebhookLoop(kube)
+			return
+		}
+	}
+}
+
+// startWebhookLoop checks webhooks every WEBHOOK_CHECK_INTERVAL, once started
+func startWebhookLoop(kube kubernetes.Interface) {
+	webhookLoop.Do(func() {
+		go func() {
+			ticker := time.NewTicker(webhookCheckInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-operatorCtx.Done():
+					return
+				case <-ticker.C:
+				}
+				ctx, cancel := context.WithTimeout(operatorCtx, 30*time.Second)
+				if err := checkWebhooks(ctx, kube); err != nil {
+					log.Printf("Failed to check admission webhooks: %v", err)
+				}
+				cancel()
+			}
+		}()
+	})
+}
+
+// checkWebhooks restores bypassed webhooks whose Service recovered and, with
+// WEBHOOK_WATCH, reports webhooks whose Service is down
+func checkWebhooks(ctx context.Context, kube kubernetes.Interface) error {
+	api := kube.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	list, err := api.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list ValidatingWebhookConfigurations: %v", err)
+	}
+	ready := map[string]int{} // namespace/service, looked up once per check
+	readyCount := func(svc *admissionv1.ServiceReference) (int, error) {
+		key := svc.Namespace + "/" + svc.Name
+		if n, ok := ready[key]; ok {
+			return n, nil
+		}
+		n, err := serviceReadyEndpoints(ctx, kube, svc.Namespace, svc.Name)
+		if err == nil {
+			ready[key] = n
+		}
+		return n, err
+	}
+
+	bypassed := 0
+	var down []Alert
+	for i := range list.Items {
+		cfg := &list.Items[i]
+		saved := savedWebhooks(cfg)
+		restored := false
+		for j := range cfg.Webhooks {
+			wh := &cfg.Webhooks[j]
+			key := cfg.Name + "/" + wh.Name
+			svc := wh.ClientConfig.Service
+			if svc == nil {
+				continue
+			}
+			if shardingEnabled {
+				if owner, _ := shards.owner(svc.Namespace); owner != shards.self {
+					continue
+				}
+			}
+			n, err := readyCount(svc)
+			if err != nil {
+				log.Printf("Skipping webhook %s — %v", key, err)
+				continue
+			}
+
+			orig, isBypassed := saved[wh.Name]
+			if isBypassed {
+				if !webhookRecovered(key, n > 0, time.Now()) {
+					bypassed++
+					continue
+				}
+				wh.FailurePolicy, wh.NamespaceSelector, wh.ObjectSelector = orig.FailurePolicy, orig.NamespaceSelector, orig.ObjectSelector
+				delete(saved, wh.Name)
+				restored = true
+				log.Printf("Restoring webhook %s after '%s' — Service %s/%s has %d ready endpoint(s) again (bypassed since %s)",
+					key, orig.Action, svc.Namespace, svc.Name, n, orig.Since.Format(time.RFC3339))
+				continue
+			}
+
+			failClosed := wh.FailurePolicy == nil || *wh.FailurePolicy == admissionv1.Fail
+			webhookMu.Lock()
+			wasReported := webhookReported[key]
+			if n > 0 || !failClosed {
+				delete(webhookReported, key)
+			} else {
+				webhookReported[key] = true
+			}
+			webhookMu.Unlock()
+			if webhookWatch && failClosed && n == 0 && !wasReported {
+				log.Printf("Webhook %s fails closed and its Service %s/%s has no ready endpoints", key, svc.Namespace, svc.Name)
+				down = append(down, webhookDownAlert(cfg.Name, wh.Name, svc))
+			}
+		}
+		// a bypass whose webhook was removed (e.g. by its chart) has nothing left to restore
+		for name := range saved {
+			if !hasWebhook(cfg, name) {
+				delete(saved, name)
+				restored = true
+			}
+		}
+		if !restored {
+			continue
+		}
+		setSavedWebhooks(cfg, saved)
+		if _, err := api.Update(ctx, cfg, metav1.UpdateOptions{}); err != nil {
+			countWebhookChange("restore", "failed")
+			log.Printf("Failed to restore ValidatingWebhookConfiguration %s: %v", cfg.Name, err)
+			bypassed++
+			continue
+		}
+		countWebhookChange("restore", "applied")
+		notifySlack(renderMessage("webhook-restored", "", map[string]interface{}{"Configuration": cfg.Name}))
+	}
+
+	webhookMu.Lock()
+	webhookBypassed = bypassed
+	webhookMu.Unlock()
+	if len(down) > 0 {
+		go processAlerts(operatorCtx, down)
+	}
+	return nil
+}
+
+// webhookRecovered reports whether a bypassed webhook's Service has been
+// ready for WEBHOOK_RESTORE_AFTER
+func webhookRecovered(key string, ready bool, now time.Time) bool {
+	webhookMu.Lock()
+	defer webhookMu.Unlock()
+	if !ready {
+		delete(webhookReadySince, key)
+		return false
+	}
+	since, ok := webhookReadySince[key]
+	if !ok {
+		webhookReadySince[key] = now
+		return webhookRestoreAfter <= 0
+	}
+	if now.Sub(since) < webhookRestoreAfter {
+		return false
+	}
+	delete(webhookReadySince, key)
+	return true
+}
+
+func hasWebhook(cfg *admissionv1.ValidatingWebhookConfiguration, name string) bool {
+	for _, wh := range cfg.Webhooks {
+		if wh.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func webhookDownAlert(configuration, webhook string, svc *admissionv1.ServiceReference) Alert {
+	return Alert{
+		Labels: map[string]string{
+			"alertname":            webhookDownAlertName,
+			"severity":             "critical",
+			"namespace":            svc.Namespace,
+			"app":                  svc.Name,
+			"service":              svc.Name,
+			"webhookconfiguration": configuration,
+			"webhook":              webhook,
+			"recovery_action":      webhookDownAction,
+		},
+		Annotations: map[string]string{
+			"summary": fmt.Sprintf("Admission webhook %s fails closed and its Service %s/%s is down", webhook, svc.Namespace, svc.Name),
+		},
+		Status:   "firing",
+		StartsAt: time.Now(),
+	}
+}
+
+func countWebhookChange(action, result string) {
+	webhookMu.Lock()
+	webhookChanges[action+"|"+result]++
+	webhookMu.Unlock()
+}
+
+func writeWebhookMetrics(w io.Writer) {
+	webhookMu.Lock()
+	defer webhookMu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_webhook_changes_total Changes to admission webhooks, by action (webhook_fail_open, disable_webhook, restore) and result.")
+	fmt.Fprintln(w, "# TYPE selfhealing_webhook_changes_total counter")
+	for _, key := range sortedKeys(webhookChanges) {
+		action, result, _ := strings.Cut(key, "|")
+		fmt.Fprintf(w, "selfhealing_webhook_changes_total{action=%q,result=%q} %d\n", action, result, webhookChanges[key])
+	}
+	fmt.Fprintln(w, "# HELP selfhealing_webhooks_bypassed Admission webhooks failed open or disabled and waiting for their Service, as of the last check.")
+	fmt.Fprintln(w, "# TYPE selfhealing_webhooks_bypassed gauge")
+	fmt.Fprintf(w, "selfhealing_webhooks_bypassed %d\n", webhookBypassed)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Mixed Linux/Windows clusters. The operator itself runs on Linux, but the
+// workloads it remediates may not:
+//   - actions and runbooks listed in WINDOWS_SKIP_ACTIONS are skipped when
+//     the alert's pod or node runs Windows. The default skips
+//     oom-bump-and-restart: Windows containers aren't OOM-killed, so a higher
+//     limit doesn't fix what the alert saw. Set it to "none" to skip nothing
+//   - draining a Windows node gives each pod at least
+//     WINDOWS_DRAIN_GRACE_PERIOD to stop and waits up to WINDOWS_DRAIN_TIMEOUT
+//     for the node to empty; Windows containers take longer to shut down
+//   - pods the operator creates itself (DNS probes) are pinned to Linux nodes
+//   - the restart feasibility check only considers nodes of the pod's OS
+//   - capture-logs also logs the node's OS and container runtime and each
+//     container's last termination, with Windows exit codes in hex
+//     (0xC000013A rather than -1073741510). With RUNTIME_LOG_LINES > 0 and
+//     containerd as the runtime it also fetches the tail of containerd's own
+//     log through the kubelet log query (NodeLogQuery feature gate)
+
+const osWindows = "windows"
+
+var (
+	windowsSkipActions      = strings.Split(envString("WINDOWS_SKIP_ACTIONS", "oom-bump-and-restart"), ",")
+	windowsDrainGracePeriod = envDuration("WINDOWS_DRAIN_GRACE_PERIOD", time.Minute)
+	windowsDrainTimeout     = envDuration("WINDOWS_DRAIN_TIMEOUT", 10*time.Minute)
+	runtimeLogLines         = envInt("RUNTIME_LOG_LINES", 0)
+)
+
+// nodeOS is the node's operating system: the kubernetes.io/os label, or what the kubelet reports
+func nodeOS(n *corev1.Node) string {
+	if os := n.Labels[corev1.LabelOSStable]; os != "" {
+		return os
+	}
+	return n.Status.NodeInfo.OperatingSystem
+}
+
+// podOS is the OS a pod declares (spec.os or a kubernetes.io/os nodeSelector), or ""
+func podOS(p *corev1.Pod) string {
+	if p.Spec.OS != nil {
+		return string(p.Spec.OS.Name)
+	}
+	return p.Spec.NodeSelector[corev1.LabelOSStable]
+}
+
+// nodeRuntime splits the runtime version the kubelet reports, e.g. "containerd://1.7.2"
+func nodeRuntime(n *corev1.Node) (string, string) {
+	name, version, _ := strings.Cut(n.Status.NodeInfo.ContainerRuntimeVersion, "://")
+	return name, version
+}
+
+// skippedOnWindows reports whether the action (or runbook) is in WINDOWS_SKIP_ACTIONS
+func skippedOnWindows(action *RecoveryAction) bool {
+	for _, name := range windowsSkipActions {
+		name = strings.TrimSpace(name)
+		if name == action.Action || (action.Action == "runbook" && name == action.Runbook) {
+			return true
+		}
+	}
+	return false
+}
+
+// targetOS is the OS of the alert's pod, or of its node; "" if neither can be found
+func targetOS(ctx context.Context, action *RecoveryAction) string {
+	if clients == nil {
+		return ""
+	}
+	node := action.Labels["node"]
+	if action.Pod != "" {
+		pod := cachedPod(action.Namespace, action.Pod)
+		if pod == nil {
+			p, err := clients.Kube.CoreV1().Pods(action.Namespace).Get(ctx, action.Pod, metav1.GetOptions{})
+			if err != nil {
+				return ""
+			}
+			pod = p
+		}
+		if os := podOS(pod); os != "" {
+			return os
+		}
+		node = pod.Spec.NodeName
+	}
+	if node == "" {
+		return ""
+	}
+	n, err := clients.Kube.CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{})
+	if err != nil {
+		return ""
+	}
+	return nodeOS(n)
+}
+
+// windowsRefusal explains why the action doesn't apply to its target, or returns ""
+func windowsRefusal(ctx context.Context, action *RecoveryAction) string {
+	if !skippedOnWindows(action) || targetOS(ctx, action) != osWindows {
+		return ""
+	}
+	name := action.Action
+	if action.Action == "runbook" {
+		name = "runbook " + action.Runbook
+	}
+	return name + " doesn't apply to Windows workloads (WINDOWS_SKIP_ACTIONS)"
+}
+
+// drainGracePeriod is the eviction grace period for a pod on the node being
+// drained: nil (the pod's own) on Linux, at least WINDOWS_DRAIN_GRACE_PERIOD on Windows
+func drainGracePeriod(rc *runbookContext, p *corev1.Pod) *int64 {
+	if rc.nodeOS != osWindows {
+		return nil
+	}
+	grace := int64(windowsDrainGracePeriod.Seconds())
+	if own := p.Spec.TerminationGracePeriodSeconds; own != nil && *own > grace {
+		grace = *own
+	}
+	return &grace
+}
+
+func drainTimeout(rc *runbookContext) time.Duration {
+	if rc.nodeOS == osWindows {
+		return windowsDrainTimeout
+	}
+	return runbookVerifyTimeout
+}
+
+// formatExitCode shows Windows exit codes (NTSTATUS and HRESULT values) in hex
+func formatExitCode(code int32, os string) string {
+	if os == osWindows && code < 0 {
+		return fmt.Sprintf("0x%08X", uint32(code))
+	}
+	return strconv.Itoa(int(code))
+}
+
+// captureRuntimeDiagnostics logs where the pod runs and how its containers last
+// terminated; like log capture it never fails the runbook
+func captureRuntimeDiagnostics(ctx context.Context, rc *runbookContext) {
+	pod, err := rc.cs.CoreV1().Pods(rc.action.Namespace).Get(ctx, rc.action.Pod, metav1.GetOptions{})
+	if err != nil || pod.Spec.NodeName == "" {
+		return
+	}
+	os, runtime, version := podOS(pod), "unknown", ""
+	if n, err := rc.clients.Kube.CoreV1().Nodes().Get(ctx, pod.Spec.NodeName, metav1.GetOptions{}); err == nil {
+		os = nodeOS(n)
+		runtime, version = nodeRuntime(n)
+	}
+	log.Printf("Pod %s/%s runs on node %s (%s, %s %s)", pod.Namespace, pod.Name, pod.Spec.NodeName, os, runtime, version)
+
+	for _, s := range pod.Status.ContainerStatuses {
+		t := s.LastTerminationState.Terminated
+		if t == nil {
+			t = s.State.Terminated
+		}
+		if t == nil {
+			continue
+		}
+		log.Printf("Container %s (%s, %d restart(s)) last terminated: %s, exit code %s, finished %s %s",
+			s.Name, s.ContainerID, s.RestartCount, t.Reason, formatExitCode(t.ExitCode, os),
+			t.FinishedAt.Format(time.RFC3339), t.Message)
+	}
+
+	if runtime != "containerd" || runtimeLogLines <= 0 {
+		return
+	}
+	raw, err := rc.clients.Kube.CoreV1().RESTClient().Get().
+		AbsPath("/api/v1/nodes", pod.Spec.NodeName, "proxy", "logs").
+		Param("query", "containerd").
+		Param("tailLines", strconv.Itoa(runtimeLogLines)).
+		DoRaw(ctx)
+	if err != nil {
+		log.Printf("Could not fetch containerd logs from node %s: %v", pod.Spec.NodeName, err)
+		return
+	}
+	log.Printf("Last %d containerd log lines on node %s:\n%s", runtimeLogLines, pod.Spec.NodeName, raw)
+}
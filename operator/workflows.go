@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Workflows are user-declared multi-step remediations, loaded from
+// WORKFLOWS_FILE (a mounted ConfigMap). An alert selects one with
+// `recovery_action: workflow` + `workflow: <name>`.
+//
+// Steps form a DAG:
+//   - a step without dependsOn runs after the step declared before it;
+//     `dependsOn: []` makes it a root
+//   - a step whose `when` condition is false is skipped, and its dependents
+//     still run; a failed step cancels everything that depends on it.
+//     Conditions test the alert's labels and its enrichment (see enrich.go)
+//   - onFailure names a step to run when this one fails (a failure branch);
+//     steps used as failure branches never run in the normal flow
+//   - every step gets its own timeout (default WORKFLOW_STEP_TIMEOUT)
+//
+// Example:
+//
+//	workflows:
+//	- name: crashloop-escalating
+//	  steps:
+//	  - {name: logs, action: capture_logs}
+//	  - {name: scale, action: scale, when: "severity=critical"}
+//	  - {name: restart, action: restart, onFailure: page}
+//	  - {name: verify, action: verify_ready, timeout: 5m, onFailure: page}
+//	  - {name: page, action: notify, params: {message: "restart did not recover the app"}}
+
+// WorkflowStep is one node of a workflow
+type WorkflowStep struct {
+	Name      string            `json:"name"`
+	Action    string            `json:"action"`
+	DependsOn []string          `json:"dependsOn,omitempty"`
+	When      string            `json:"when,omitempty"` // "label=value" or "label!=value"
+	Timeout   string            `json:"timeout,omitempty"`
+	OnFailure string            `json:"onFailure,omitempty"`
+	Params    map[string]string `json:"params,omitempty"`
+
+	timeout time.Duration
+}
+
+// Workflow is a named DAG of steps
+type Workflow struct {
+	Name  string         `json:"name"`
+	Steps []WorkflowStep `json:"steps"`
+
+	order    []int           // main-flow steps in topological order
+	branches map[string]bool // steps only reachable through onFailure
+	index    map[string]int
+}
+
+type workflowFile struct {
+	Workflows []Workflow `json:"workflows"`
+}
+
+// step statuses
+const (
+	stepSucceeded = "succeeded"
+	stepFailed    = "failed"
+	stepSkipped   = "skipped"   // when condition was false
+	stepCancelled = "cancelled" // a dependency failed
+)
+
+type stepFunc func(ctx context.Context, rc *runbookContext, params map[string]string) error
+
+// workflowActions are the primitives a workflow step can use
+var workflowActions = map[string]stepFunc{
+	"capture_logs":   adaptStep(stepCaptureLogs),
+	"restart":        adaptStep(stepRestartPod),
+	"verify_ready":   adaptStep(verifyReadyReplacement),
+	"redeploy":       adaptStep(stepRollingRestart),
+	"verify_rollout": adaptStep(verifyRollout),
+	"rollback":       adaptStep(stepRollback),
+	"scale": func(ctx context.Context, rc *runbookContext, _ map[string]string) error {
+		return scaleDeployment(ctx, rc.clients, rc.action, rc.alert)
+	},
+	"reschedule_elsewhere": func(ctx context.Context, rc *runbookContext, _ map[string]string) error {
+		return rescheduleElsewhere(ctx, rc.clients, rc.action)
+	},
+	"bump_memory":              adaptStep(stepBumpMemory),
+	"apply_vpa_recommendation": adaptStep(stepApplyVPA),
+	"cordon_node":              adaptStep(stepCordonNode),
+	"drain_node": func(ctx context.Context, rc *runbookContext, _ map[string]string) error {
+		if err := stepEvictPods(ctx, rc); err != nil {
+			return err
+		}
+		return verifyNodeDrained(ctx, rc)
+	},
+	"expand_pvc": func(ctx context.Context, rc *runbookContext, _ map[string]string) error {
+		if err := stepExpandPVC(ctx, rc); err != nil {
+			return err
+		}
+		return verifyPVCResized(ctx, rc)
+	},
+	"notify": func(ctx context.Context, rc *runbookContext, params map[string]string) error {
+		msg := params["message"]
+		if msg == "" {
+			msg = "workflow requested escalation"
+		}
+		escalate(rc.action, rc.alert, msg)
+		return nil
+	},
+	"patch_resource": func(ctx context.Context, rc *runbookContext, params map[string]string) error {
+		return patchResource(ctx, rc.clients, rc.action, params)
+	},
+	"delete_resource": func(ctx context.Context, rc *runbookContext, params map[string]string) error {
+		return deleteResource(ctx, rc.clients, rc.action, params)
+	},
+	"wait": func(ctx context.Context, rc *runbookContext, params map[string]string) error {
+		d, err := time.ParseDuration(params["duration"])
+		if err != nil {
+			return fmt.Errorf("wait step needs a duration param: %v", err)
+		}
+		select {
+		case <-time.After(d):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	},
+}
+
+func adaptStep(fn func(ctx context.Context, rc *runbookContext) error) stepFunc {
+	return func(ctx context.Context, rc *runbookContext, _ map[string]string) error {
+		return fn(ctx, rc)
+	}
+}
+
+var (
+	workflowsMu         sync.RWMutex
+	workflows           = map[string]*Workflow{}
+	workflowStepTimeout = envDuration("WORKFLOW_STEP_TIMEOUT", 5*time.Minute)
+)
+
+// loadWorkflows reads and validates WORKFLOWS_FILE. A missing file just means
+// no workflows are defined.
+func loadWorkflows(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var f workflowFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	loaded := map[string]*Workflow{}
+	for i := range f.Workflows {
+		wf := &f.Workflows[i]
+		if err := wf.compile(); err != nil {
+			return fmt.Errorf("workflow %q: %v", wf.Name, err)
+		}
+		if _, dup := loaded[wf.Name]; dup {
+			return fmt.Errorf("workflow %q defined twice", wf.Name)
+		}
+		loaded[wf.Name] = wf
+	}
+
+	workflowsMu.Lock()
+	workflows = loaded
+	workflowsMu.Unlock()
+	log.Printf("Loaded %d workflow(s) from %s", len(loaded), path)
+	return nil
+}
+
+// compile validates the workflow and computes its execution order
+func (wf *Workflow) compile() error {
+	if wf.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	wf.index = map[string]int{}
+	wf.branches = map[string]bool{}
+	for i := range wf.Steps {
+		s := &wf.Steps[i]
+		if s.Name == "" {
+			return fmt.Errorf("step %d has no name", i+1)
+		}
+		if _, dup := wf.index[s.Name]; dup {
+			return fmt.Errorf("step %q defined twice", s.Name)
+		}
+		if _, ok := workflowActions[s.Action]; !ok {
+			return fmt.Errorf("step %q: unknown action %q", s.Name, s.Action)
+		}
+		if _, _, err := parseCondition(s.When); err != nil {
+			return fmt.Errorf("step %q: %v", s.Name, err)
+		}
+		if s.Action == "patch_resource" || s.Action == "delete_resource" {
+			if err := validateResourceParams(s.Action, s.Params); err != nil {
+				return fmt.Errorf("step %q: %v", s.Name, err)
+			}
+		}
+		s.timeout = workflowStepTimeout
+		if s.Timeout != "" {
+			d, err := time.ParseDuration(s.Timeout)
+			if err != nil {
+				return fmt.Errorf("step %q: invalid timeout: %v", s.Name, err)
+			}
+			s.timeout = d
+		}
+		wf.index[s.Name] = i
+	}
+	for _, s := range wf.Steps {
+		if s.OnFailure == "" {
+			continue
+		}
+		if _, ok := wf.index[s.OnFailure]; !ok {
+			return fmt.Errorf("step %q: onFailure step %q not found", s.Name, s.OnFailure)
+		}
+		wf.branches[s.OnFailure] = true
+	}
+
+	// Resolve implicit dependencies on the previous main-flow step
+	prev := ""
+	for i := range wf.Steps {
+		s := &wf.Steps[i]
+		if wf.branches[s.Name] {
+			continue
+		}
+		if s.DependsOn == nil && prev != "" {
+			s.DependsOn = []string{prev}
+		}
+		for _, d := range s.DependsOn {
+			if _, ok := wf.index[d]; !ok {
+				return fmt.Errorf("step %q depends on unknown step %q", s.Name, d)
+			}
+			if wf.branches[d] {
+				return fmt.Errorf("step %q depends on failure branch %q", s.Name, d)
+			}
+		}
+		prev = s.Name
+	}
+
+	// Kahn's algorithm, keeping declaration order among ready steps
+	indegree := map[string]int{}
+	for _, s := range wf.Steps {
+		if !wf.branches[s.Name] {
+			indegree[s.Name] = len(s.DependsOn)
+		}
+	}
+	wf.order = nil
+	done := map[string]bool{}
+	for len(wf.order) < len(indegree) {
+		progressed := false
+		for i, s := range wf.Steps {
+			if wf.branches[s.Name] || done[s.Name] || indegree[s.Name] > 0 {
+				continue
+			}
+			done[s.Name] = true
+			wf.order = append(wf.order, i)
+			progressed = true
+			for _, other := range wf.Steps {
+				for _, d := range other.DependsOn {
+					if d == s.Name {
+						indegree[other.Name]--
+					}
+				}
+			}
+		}
+		if !progressed {
+			return fmt.Errorf("dependency cycle between steps")
+		}
+	}
+	return nil
+}
+
+// parseCondition splits "label=value" / "label!=value"; empty means always
+func parseCondition(cond string) (label string, match func(labels map[string]string) bool, err error) {
+	if cond == "" {
+		return "", func(map[string]string) bool { return true }, nil
+	}
+	if l, v, ok := strings.Cut(cond, "!="); ok {
+		l, v = strings.TrimSpace(l), strings.TrimSpace(v)
+		return l, func(labels map[string]string) bool { return labels[l] != v }, nil
+	}
+	if l, v, ok := strings.Cut(cond, "="); ok {
+		l, v = strings.TrimSpace(l), strings.TrimSpace(v)
+		return l, func(labels map[string]string) bool { return labels[l] == v }, nil
+	}
+	return "", nil, fmt.Errorf("invalid when condition %q (want label=value or label!=value)", cond)
+}
+
+// startWorkflow looks up the alert's workflow and runs it in the background
+func startWorkflow(ctx context.Context, c *Clients, action *RecoveryAction, alert Alert) error {
+	workflowsMu.RLock()
+	wf, ok := workflows[action.Workflow]
+	workflowsMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown workflow %q", action.Workflow)
+	}
+
+	cs, err := c.For(ctx, action.Namespace)
+	if err != nil {
+		return err
+	}
+	go func() {
+		unlock, err := workloadLocks.lock(operatorCtx, workloadKey(action))
+		if err != nil {
+			log.Printf("Workflow %s not started — %v", wf.Name, err)
+			return
+		}
+		defer unlock()
+		runWorkflow(operatorCtx, wf, &runbookContext{cs: cs, clients: c, action: action, alert: alert})
+	}()
+	return nil
+}
+
+func runWorkflow(ctx context.Context, wf *Workflow, rc *runbookContext) {
+	target := rc.action.Namespace + "/" + rc.action.App
+	rec := newActionRecord(rc.action, wf.Name, time.Now())
+	log.Printf("Workflow %s started for %s", wf.Name, target)
+
+	status := map[string]string{}
+	var firstErr error
+	for _, i := range wf.order {
+		step := wf.Steps[i]
+
+		cancelled := false
+		for _, d := range step.DependsOn {
+			if status[d] == stepFailed || status[d] == stepCancelled {
+				cancelled = true
+			}
+		}
+		_, when, _ := parseCondition(step.When)
+		switch {
+		case cancelled:
+			status[step.Name] = stepCancelled
+			rec.Steps = append(rec.Steps, StepRecord{Name: step.Name, Action: step.Action, Status: stepCancelled, StartedAt: time.Now()})
+			continue
+		case !when(conditionLabels(rc.action)):
+			status[step.Name] = stepSkipped
+			rec.Steps = append(rec.Steps, StepRecord{Name: step.Name, Action: step.Action, Status: stepSkipped, StartedAt: time.Now()})
+			continue
+		}
+
+		err := runWorkflowStep(ctx, wf, step, rc, &rec)
+		if err == nil {
+			status[step.Name] = stepSucceeded
+			continue
+		}
+		status[step.Name] = stepFailed
+		if firstErr == nil {
+			firstErr = fmt.Errorf("step %s: %v", step.Name, err)
+		}
+
+		// Follow the failure branch chain
+		seen := map[string]bool{}
+		for next := step.OnFailure; next != "" && !seen[next]; {
+			seen[next] = true
+			branch := wf.Steps[wf.index[next]]
+			if runWorkflowStep(ctx, wf, branch, rc, &rec) == nil {
+				break
+			}
+			next = branch.OnFailure
+		}
+	}
+
+	rec.Changes = rc.action.Changes
+	recordHistory(rec, firstErr)
+	if firstErr != nil {
+		log.Printf("Workflow %s failed for %s: %v", wf.Name, target, firstErr)
+		return
+	}
+	log.Printf("Workflow %s succeeded for %s", wf.Name, target)
+}
+
+func runWorkflowStep(ctx context.Context, wf *Workflow, step WorkflowStep, rc *runbookContext, rec *ActionRecord) error {
+	log.Printf("Workflow %s step %s (%s)", wf.Name, step.Name, step.Action)
+	start := time.Now()
+	var err error
+	if actionTurnedOff(step.Action) {
+		err = fmt.Errorf("%s is turned off by feature flag", step.Action)
+	} else {
+		stepCtx, cancel := context.WithTimeout(ctx, step.timeout)
+		err = workflowActions[step.Action](stepCtx, rc, step.Params)
+		cancel()
+	}
+
+	sr := StepRecord{
+		Name:      step.Name,
+		Action:    step.Action,
+		Status:    stepSucceeded,
+		StartedAt: start,
+		Duration:  time.Since(start).Round(time.Millisecond).String(),
+	}
+	if err != nil {
+		sr.Status = stepFailed
+		sr.Error = err.Error()
+		log.Printf("Workflow %s step %s failed: %v", wf.Name, step.Name, err)
+	}
+	rec.Steps = append(rec.Steps, sr)
+	return err
+}
+
+// stepRollingRestart bumps the restartedAt annotation and remembers the
+// generation so verify_rollout can follow it.
+func stepRollingRestart(ctx context.Context, rc *runbookContext) error {
+	dep, err := findDeployment(ctx, rc.cs, rc.action.Namespace, rc.action.App)
+	if err != nil {
+		return err
+	}
+	if err := verifyPodImages(ctx, dep.Spec.Template.Spec); err != nil {
+		return fmt.Errorf("refusing to restart %s/%s: %v", dep.Namespace, dep.Name, err)
+	}
+	restartedAt := time.Now().Format(time.RFC3339)
+	before, updated, err := updateDeployment(ctx, rc.cs, dep, func(dep *appsv1.Deployment) error {
+		if dep.Spec.Template.Annotations == nil {
+			dep.Spec.Template.Annotations = map[string]string{}
+		}
+		dep.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] = restartedAt
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	recordChange(rc.action, "Deployment", dep.Namespace, dep.Name, before, updated)
+	rc.deployment, rc.generation = updated.Name, updated.Generation
+	log.Printf("Rolling restart triggered for deployment %s/%s", dep.Namespace, dep.Name)
+	emitDeployMarker(rc.action, updated.Name, updated.Generation)
+	return nil
+}
+
+// stepRollback puts the previous revision's pod template back, like
+// `kubectl rollout undo`; DeploymentConfigs use their rollback subresource
+func stepRollback(ctx context.Context, rc *runbookContext) error {
+	dep, err := findDeployment(ctx, rc.cs, rc.action.Namespace, rc.action.App)
+	if err != nil {
+		if dc := findDeploymentConfig(ctx, rc.cs, rc.action.Namespace, rc.action.App); dc != nil {
+			return rollbackDeploymentConfig(ctx, rc.cs, rc.action, dc)
+		}
+		return err
+	}
+	owned, err := ownedReplicaSets(ctx, rc.cs, dep)
+	if err != nil {
+		return err
+	}
+	current, _ := strconv.Atoi(dep.Annotations["deployment.kubernetes.io/revision"])
+	var previous *appsv1.ReplicaSet
+	for i := range owned {
+		if rsRevision(owned[i]) < current {
+			previous = &owned[i]
+			break
+		}
+	}
+	if previous == nil {
+		return fmt.Errorf("deployment %s/%s has no earlier revision to roll back to", dep.Namespace, dep.Name)
+	}
+
+	template := rollbackTemplate(previous)
+	if err := verifyPodImages(ctx, template.Spec); err != nil {
+		return fmt.Errorf("refusing to roll back %s/%s: %v", dep.Namespace, dep.Name, err)
+	}
+	before, updated, err := updateDeployment(ctx, rc.cs, dep, func(dep *appsv1.Deployment) error {
+		dep.Spec.Template = *template.DeepCopy()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	recordChange(rc.action, "Deployment", dep.Namespace, dep.Name, before, updated)
+	rc.deployment, rc.generation = updated.Name, updated.Generation
+	log.Printf("Deployment %s/%s rolled back to revision %d", dep.Namespace, dep.Name, rsRevision(*previous))
+	emitDeployMarker(rc.action, updated.Name, updated.Generation)
+	return nil
+}
+
+// rollbackTemplate is a replica set's pod template as a Deployment template
+func rollbackTemplate(rs *appsv1.ReplicaSet) *corev1.PodTemplateSpec {
+	template := rs.Spec.Template.DeepCopy()
+	delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+	return template
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Workload annotations let application teams tune the operator from their own
+// Deployment manifests:
+//
+//	selfhealing.io/enabled: "false"        never act on this workload
+//	selfhealing.io/actions: restart,scale  only these actions are allowed
+//	selfhealing.io/cooldown: 10m           cooldown instead of the global one ("0" for none)
+//	selfhealing.io/max-replicas: "8"       the scale action stops here
+//	selfhealing.io/paused-until: "2026-03-01T14:30:00Z"
+//	                                       no actions until then
+//
+// With WORKLOAD_OPT_IN=true only Deployments annotated
+// selfhealing.io/enabled: "true" are acted on. Invalid values are logged and
+// ignored. The Deployment is found the same way actions find it (label
+// app=<app>, from the informer cache when enabled).
+//
+// The same annotations on a Namespace are the defaults of its workloads, so
+// policy is layered, each setting taken from the first that sets it:
+//   - the workload's annotations
+//   - its namespace's annotations (when the alert names a namespace)
+//   - the cluster-wide configuration (the global cooldown from the tuning
+//     API, WORKLOAD_OPT_IN, DISABLED_ACTIONS/ACTION_FLAGS_FILE)
+//
+// so selfhealing.io/enabled: "true" on a namespace opts all of its workloads
+// in under WORKLOAD_OPT_IN, and a workload can opt back out. Turning an action
+// off cluster-wide can't be overridden below. GET /api/v1/effective-policy
+// shows the result for a workload and where each setting comes from.
+//
+// paused-until is for deploy pipelines: set it on the workloads they're
+// rolling (or on the namespace, which pauses every workload in it) so the
+// healer stays out of the way, and optionally say who in
+// selfhealing.io/paused-by. notify actions still run. A pause further away
+// than PAUSE_MAX (24h) is ignored as a mistake, so a forgotten or mistyped
+// annotation can't turn healing off for good. The pause is checked again
+// right before an action runs, in case it was set while the action waited.
+
+const (
+	annoEnabled     = "selfhealing.io/enabled"
+	annoActions     = "selfhealing.io/actions"
+	annoCooldown    = "selfhealing.io/cooldown"
+	annoMaxReplicas = "selfhealing.io/max-replicas"
+	annoPausedUntil = "selfhealing.io/paused-until"
+	annoPausedBy    = "selfhealing.io/paused-by"
+)
+
+var (
+	workloadOptIn = envBool("WORKLOAD_OPT_IN", false)
+	pauseMax      = envDuration("PAUSE_MAX", 24*time.Hour)
+)
+
+// workloadOverrides are the settings read from a workload's annotations
+type workloadOverrides struct {
+	found       bool // a Deployment was found for the workload
+	enabled     *bool
+	actions     []string
+	cooldown    *time.Duration // nil when not set; 0 turns the cooldown off
+	maxReplicas int32
+	pausedUntil time.Time
+	pausedBy    string
+	sources     map[string]string // annotation -> the object it was read from
+}
+
+func parseWorkloadAnnotations(name string, ann map[string]string) workloadOverrides {
+	ov := parseAnnotations(name, ann)
+	ov.found = true
+	return ov
+}
+
+// parseAnnotations reads the overrides set on a workload or namespace
+func parseAnnotations(name string, ann map[string]string) workloadOverrides {
+	ov := workloadOverrides{sources: map[string]string{}}
+	invalid := func(key string, err error) {
+		log.Printf("Ignoring annotation %s on %s — %v", key, name, err)
+	}
+	if v, ok := ann[annoEnabled]; ok {
+		if b, err := strconv.ParseBool(v); err != nil {
+			invalid(annoEnabled, err)
+		} else {
+			ov.enabled = &b
+			ov.sources[annoEnabled] = name
+		}
+	}
+	if v := ann[annoActions]; v != "" {
+		for _, a := range strings.Split(v, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				ov.actions = append(ov.actions, a)
+			}
+		}
+		ov.sources[annoActions] = name
+	}
+	if v := ann[annoCooldown]; v != "" {
+		if d, err := time.ParseDuration(v); err != nil || d < 0 {
+			invalid(annoCooldown, fmt.Errorf("invalid duration %q", v))
+		} else {
+			ov.cooldown = &d
+			ov.sources[annoCooldown] = name
+		}
+	}
+	if v := ann[annoMaxReplicas]; v != "" {
+		if n, err := strconv.ParseInt(v, 10, 32); err != nil || n < 1 {
+			invalid(annoMaxReplicas, fmt.Errorf("invalid replica count %q", v))
+		} else {
+			ov.maxReplicas = int32(n)
+			ov.sources[annoMaxReplicas] = name
+		}
+	}
+	ov.inheritPause(name, ann)
+	return ov
+}
+
+// inheritPause takes the object's paused-until if it's later than the one set
+func (ov *workloadOverrides) inheritPause(name string, ann map[string]string) {
+	v := ann[annoPausedUntil]
+	if v == "" {
+		return
+	}
+	until, err := time.Parse(time.RFC3339, v)
+	switch {
+	case err != nil:
+		log.Printf("Ignoring annotation %s on %s — %v", annoPausedUntil, name, err)
+	case time.Until(until) > pauseMax:
+		log.Printf("Ignoring annotation %s on %s — %s is more than PAUSE_MAX (%s) away", annoPausedUntil, name, v, pauseMax)
+	case until.After(ov.pausedUntil):
+		ov.pausedUntil, ov.pausedBy = until, ann[annoPausedBy]
+		ov.setSource(annoPausedUntil, name)
+	}
+}
+
+func (ov *workloadOverrides) setSource(annotation, name string) {
+	if ov.sources == nil {
+		ov.sources = map[string]string{}
+	}
+	ov.sources[annotation] = name
+}
+
+// inherit fills in the settings the workload doesn't set from its
+// namespace's; of two pauses the later one wins
+func (ov workloadOverrides) inherit(ns workloadOverrides) workloadOverrides {
+	if ov.enabled == nil && ns.enabled != nil {
+		ov.enabled = ns.enabled
+		ov.setSource(annoEnabled, ns.sources[annoEnabled])
+	}
+	if len(ov.actions) == 0 && len(ns.actions) > 0 {
+		ov.actions = ns.actions
+		ov.setSource(annoActions, ns.sources[annoActions])
+	}
+	if ov.cooldown == nil && ns.cooldown != nil {
+		ov.cooldown = ns.cooldown
+		ov.setSource(annoCooldown, ns.sources[annoCooldown])
+	}
+	if ov.maxReplicas == 0 && ns.maxReplicas > 0 {
+		ov.maxReplicas = ns.maxReplicas
+		ov.setSource(annoMaxReplicas, ns.sources[annoMaxReplicas])
+	}
+	if ns.pausedUntil.After(ov.pausedUntil) {
+		ov.pausedUntil, ov.pausedBy = ns.pausedUntil, ns.pausedBy
+		ov.setSource(annoPausedUntil, ns.sources[annoPausedUntil])
+	}
+	return ov
+}
+
+// namespaceOverrides reads the annotations of the action's namespace, if the
+// alert names one
+func namespaceOverrides(ctx context.Context, action *RecoveryAction) workloadOverrides {
+	if clients.Kube == nil || action.Labels["namespace"] == "" {
+		return workloadOverrides{}
+	}
+	ns, err := getNamespace(ctx, clients.Kube, action.Namespace)
+	if err != nil {
+		return workloadOverrides{}
+	}
+	return parseAnnotations("namespace "+ns.Name, ns.Annotations)
+}
+
+// workloadOverridesFor reads the annotations of the action's Deployment and
+// namespace; node-level actions and workloads without a Deployment have only
+// the namespace's
+func workloadOverridesFor(ctx context.Context, action *RecoveryAction) workloadOverrides {
+	if clients.Kube == nil {
+		return workloadOverrides{}
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	var ov workloadOverrides
+	if action.App != "" {
+		if dep, err := findDeployment(ctx, clients.Kube, action.Namespace, action.App); err == nil {
+			ov = parseWorkloadAnnotations(action.Namespace+"/"+dep.Name, dep.Annotations)
+		} else if dc := findDeploymentConfig(ctx, clients.Kube, action.Namespace, action.App); dc != nil {
+			ov = parseWorkloadAnnotations(action.Namespace+"/"+dc.GetName(), dc.GetAnnotations())
+		}
+	}
+	return ov.inherit(namespaceOverrides(ctx, action))
+}
+
+// refusal returns why the annotations rule out the action, or ""
+func (ov workloadOverrides) refusal(action *RecoveryAction) string {
+	if reason := ov.pauseRefusal(action); reason != "" {
+		return reason
+	}
+	if ov.enabled != nil && !*ov.enabled {
+		return annoEnabled + " is false on " + ov.sources[annoEnabled]
+	}
+	if workloadOptIn && (ov.enabled == nil || !*ov.enabled) {
+		return "WORKLOAD_OPT_IN is set and the workload isn't annotated " + annoEnabled + ": \"true\""
+	}
+	if len(ov.actions) > 0 && !containsString(ov.actions, action.Action) {
+		return fmt.Sprintf("'%s' isn't in %s (%s) on %s", action.Action, annoActions, strings.Join(ov.actions, ","), ov.sources[annoActions])
+	}
+	return ""
+}
+
+// pauseRefusal returns why a paused-until annotation holds the action back, or ""
+func (ov workloadOverrides) pauseRefusal(action *RecoveryAction) string {
+	if action.Action == "notify" || !time.Now().Before(ov.pausedUntil) {
+		return ""
+	}
+	reason := fmt.Sprintf("paused until %s by %s on %s", ov.pausedUntil.Format(time.RFC3339), annoPausedUntil, ov.sources[annoPausedUntil])
+	if ov.pausedBy != "" {
+		reason += " (" + ov.pausedBy + ")"
+	}
+	return reason
+}
+
+// cooldownFor returns the workload's cooldown
+func (ov workloadOverrides) cooldownFor() time.Duration {
+	if ov.cooldown != nil {
+		return *ov.cooldown
+	}
+	return currentCooldown()
+}
+
+func (ov workloadOverrides) String() string {
+	var parts []string
+	if ov.enabled != nil {
+		parts = append(parts, "enabled="+strconv.FormatBool(*ov.enabled))
+	}
+	if len(ov.actions) > 0 {
+		parts = append(parts, "actions="+strings.Join(ov.actions, ","))
+	}
+	if ov.cooldown != nil {
+		parts = append(parts, "cooldown="+ov.cooldown.String())
+	}
+	if ov.maxReplicas > 0 {
+		parts = append(parts, "max-replicas="+strconv.Itoa(int(ov.maxReplicas)))
+	}
+	if !ov.pausedUntil.IsZero() {
+		parts = append(parts, "paused-until="+ov.pausedUntil.Format(time.RFC3339))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "no overrides")
+	}
+	if !ov.found {
+		parts = append([]string{"no deployment found,"}, parts...)
+	}
+	return strings.Join(parts, " ")
+}
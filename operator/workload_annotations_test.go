@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestCooldownAnnotation(t *testing.T) {
+	global := currentCooldown()
+	tests := []struct {
+		name      string
+		workload  map[string]string
+		namespace map[string]string
+		want      time.Duration
+	}{
+		{"unset", nil, nil, global},
+		{"workload", map[string]string{annoCooldown: "10m"}, nil, 10 * time.Minute},
+		{"workload zero", map[string]string{annoCooldown: "0"}, nil, 0},
+		{"namespace", nil, map[string]string{annoCooldown: "10m"}, 10 * time.Minute},
+		{"namespace zero", nil, map[string]string{annoCooldown: "0s"}, 0},
+		{"workload zero over namespace", map[string]string{annoCooldown: "0"}, map[string]string{annoCooldown: "10m"}, 0},
+		{"invalid is ignored", map[string]string{annoCooldown: "soon"}, nil, global},
+		{"negative is ignored", map[string]string{annoCooldown: "-1m"}, map[string]string{annoCooldown: "5m"}, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		ov := parseWorkloadAnnotations("shop/cart", tt.workload).inherit(parseAnnotations("namespace shop", tt.namespace))
+		if got := ov.cooldownFor(); got != tt.want {
+			t.Errorf("%s: cooldown = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNamespaceOverridesFromCache(t *testing.T) {
+	kube := fake.NewSimpleClientset()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "shop",
+		Annotations: map[string]string{annoMaxReplicas: "4"},
+	}})
+	savedClients, savedLister := clients, namespaceLister
+	clients, namespaceLister = &Clients{Kube: kube}, corelisters.NewNamespaceLister(indexer)
+	defer func() { clients, namespaceLister = savedClients, savedLister }()
+
+	action := &RecoveryAction{Namespace: "shop", App: "cart", Labels: map[string]string{"namespace": "shop"}}
+	for i := 0; i < 3; i++ {
+		if ov := namespaceOverrides(context.Background(), action); ov.maxReplicas != 4 {
+			t.Fatalf("max replicas = %d, want 4 from the namespace", ov.maxReplicas)
+		}
+	}
+	if n := len(kube.Actions()); n != 0 {
+		t.Errorf("%d API calls, want the namespace read from the cache", n)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// Per-workload serialization: every action on a workload (namespace/app)
+// holds that workload's lock, so a restart and a scale of the same
+// Deployment never run at the same time and conflict on resourceVersion.
+// Different workloads don't share a lock and run in parallel. Runbooks and
+// workflows hold the lock for their whole run.
+//
+// An action that can't get the lock within WORKLOAD_LOCK_TIMEOUT is skipped
+// rather than queued forever — the alert will be re-sent if still firing.
+
+var errWorkloadBusy = errors.New("another action is still running on this workload")
+
+type keyedMutex struct {
+	timeout time.Duration
+
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+	busy  int // lock attempts that timed out
+}
+
+type keyedLock struct {
+	ch   chan struct{} // holds one token while locked
+	refs int           // holders + waiters; the entry is removed at zero
+}
+
+var workloadLocks = &keyedMutex{
+	timeout: envDuration("WORKLOAD_LOCK_TIMEOUT", 30*time.Second),
+	locks:   map[string]*keyedLock{},
+}
+
+func init() {
+	registerMetrics(workloadLocks.writeMetrics)
+}
+
+func workloadKey(action *RecoveryAction) string {
+	return action.Namespace + "/" + action.App
+}
+
+// lock waits up to the timeout (or until ctx ends) for the key and returns
+// its unlock function
+func (k *keyedMutex) lock(ctx context.Context, key string) (func(), error) {
+	k.mu.Lock()
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{ch: make(chan struct{}, 1)}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	unlock := func() {
+		<-l.ch
+		k.release(key, l)
+	}
+	// a free lock is taken even if the wait is already over
+	select {
+	case l.ch <- struct{}{}:
+		return sync.OnceFunc(unlock), nil
+	default:
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, k.waitTimeout())
+	defer cancel()
+	select {
+	case l.ch <- struct{}{}:
+		return sync.OnceFunc(unlock), nil
+	case <-ctx.Done():
+		k.release(key, l)
+		if ctx.Err() == context.Canceled {
+			return nil, fmt.Errorf("%s: %v", key, ctx.Err())
+		}
+		k.mu.Lock()
+		k.busy++
+		k.mu.Unlock()
+		return nil, fmt.Errorf("%s: %w", key, errWorkloadBusy)
+	}
+}
+
+func (k *keyedMutex) release(key string, l *keyedLock) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	l.refs--
+	if l.refs == 0 {
+		delete(k.locks, key)
+	}
+}
+
+// waitTimeout is how long lock waits, which the tuning API can change
+func (k *keyedMutex) waitTimeout() time.Duration {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.timeout
+}
+
+// held returns the keys currently locked or waited on
+func (k *keyedMutex) held() []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return sortedKeys(k.locks)
+}
+
+func (k *keyedMutex) writeMetrics(w io.Writer) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	fmt.Fprintln(w, "# HELP selfhealing_workload_locks Workloads with an action running or waiting.")
+	fmt.Fprintln(w, "# TYPE selfhealing_workload_locks gauge")
+	fmt.Fprintf(w, "selfhealing_workload_locks %d\n", len(k.locks))
+	fmt.Fprintln(w, "# HELP selfhealing_workload_lock_timeouts_total Actions skipped because their workload stayed busy.")
+	fmt.Fprintln(w, "# TYPE selfhealing_workload_lock_timeouts_total counter")
+	fmt.Fprintf(w, "selfhealing_workload_lock_timeouts_total %d\n", k.busy)
+}
+
+type workloadJob struct {
+	action *RecoveryAction
+	alert  Alert
+}
+
+// runPerWorkload runs each workload's actions in order and different
+// workloads in parallel, lowest pod priority first (priority.go), returning
+// when all are done. Workloads whose policy is being spread out (spread.go)
+// run later in the background.
+func runPerWorkload(ctx context.Context, jobs map[string][]workloadJob) {
+	for _, list := range jobs {
+		for _, j := range list {
+			pipeline.enqueue(j.action)
+		}
+	}
+	for _, tier := range priorityTiers(jobs) {
+		var wg sync.WaitGroup
+		for _, key := range tier {
+			list := jobs[key]
+			if delay := spreadDelay(list[0].action, list[0].alert); delay > 0 {
+				for _, j := range list {
+					j.action.Explain.add("spread", traceInfo, "part of a mass remediation; delayed by "+delay.String())
+				}
+				runSpread(key, list, delay)
+				continue
+			}
+			wg.Add(1)
+			go func(key string, list []workloadJob) {
+				defer wg.Done()
+				runWorkloadJobs(ctx, key, list)
+			}(key, list)
+		}
+		wg.Wait()
+	}
+}
+
+// runWorkloadJobs runs one workload's actions in order. A later action is
+// skipped if an earlier one in the batch started a cooldown.
+func runWorkloadJobs(ctx context.Context, key string, list []workloadJob) {
+	for i, j := range list {
+		if i > 0 && isCoolingDown(key, currentCooldown()) {
+			log.Printf("Skipping '%s' for %s — cooldown started by an earlier alert in this batch",
+				j.action.Action, key)
+			pipeline.dequeue(j.action)
+			continue
+		}
+		if safeMode.hold(key, j) {
+			pipeline.dequeue(j.action)
+			continue
+		}
+		j.action.Explain.add("safe-mode", tracePass, "")
+		if ctx.Err() != nil {
+			log.Printf("Skipping '%s' for %s — %v", j.action.Action, key, ctx.Err())
+			pipeline.dequeue(j.action)
+			continue
+		}
+		performAction(ctx, j.action, j.alert)
+	}
+}
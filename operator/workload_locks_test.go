@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	tests := []struct {
+		name    string
+		held    string // key locked before the attempt
+		key     string
+		cancel  bool
+		wantErr error
+	}{
+		{"free key", "", "shop/cart", false, nil},
+		{"other workload runs in parallel", "shop/cart", "shop/checkout", false, nil},
+		{"same workload times out", "shop/cart", "shop/cart", false, errWorkloadBusy},
+		{"cancelled wait isn't a timeout", "shop/cart", "shop/cart", true, context.Canceled},
+	}
+	for _, tt := range tests {
+		k := &keyedMutex{timeout: 20 * time.Millisecond, locks: map[string]*keyedLock{}}
+		if tt.held != "" {
+			unlock, err := k.lock(context.Background(), tt.held)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			defer unlock()
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		if tt.cancel {
+			cancel()
+		}
+		unlock, err := k.lock(ctx, tt.key)
+		cancel()
+		switch {
+		case tt.wantErr == nil && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.wantErr == errWorkloadBusy && !errors.Is(err, errWorkloadBusy):
+			t.Errorf("%s: err = %v, want busy", tt.name, err)
+		case tt.wantErr == context.Canceled && (err == nil || errors.Is(err, errWorkloadBusy)):
+			t.Errorf("%s: err = %v, want cancelled", tt.name, err)
+		}
+		if unlock != nil {
+			unlock()
+			unlock() // a second unlock is a no-op
+		}
+		wantBusy := 0
+		if tt.wantErr == errWorkloadBusy {
+			wantBusy = 1
+		}
+		if k.busy != wantBusy {
+			t.Errorf("%s: %d timeouts counted, want %d", tt.name, k.busy, wantBusy)
+		}
+	}
+}
+
+func TestKeyedMutexSerializesAndCleansUp(t *testing.T) {
+	k := &keyedMutex{timeout: time.Second, locks: map[string]*keyedLock{}}
+	unlock, err := k.lock(context.Background(), "shop/cart")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan func())
+	go func() {
+		next, err := k.lock(context.Background(), "shop/cart")
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- next
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("second action got the lock while the first still held it")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if got := k.held(); !reflect.DeepEqual(got, []string{"shop/cart"}) {
+		t.Errorf("held = %v, want [shop/cart]", got)
+	}
+
+	unlock()
+	next := <-acquired
+	next()
+	if got := k.held(); len(got) != 0 {
+		t.Errorf("held = %v after both unlocked, want none", got)
+	}
+}
+
+func TestPriorityTiers(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for name, priority := range map[string]int32{"low-1": 0, "mid-1": 100, "mid-2": 100, "high-1": 1000} {
+		p := testPod("shop", name, name)
+		priority := priority
+		p.Spec.Priority = &priority
+		indexer.Add(p)
+	}
+	savedLister, savedOrder := podLister, restartPriorityOrder
+	podLister = corelisters.NewPodLister(indexer)
+	defer func() { podLister, restartPriorityOrder = savedLister, savedOrder }()
+
+	job := func(pods ...string) []workloadJob {
+		var list []workloadJob
+		for _, pod := range pods {
+			list = append(list, workloadJob{action: &RecoveryAction{Namespace: "shop", App: pod, Pod: pod}})
+		}
+		return list
+	}
+	tests := []struct {
+		name  string
+		order bool
+		jobs  map[string][]workloadJob
+		want  [][]string
+	}{
+		{"single workload", true, map[string][]workloadJob{"shop/high": job("high-1")}, [][]string{{"shop/high"}}},
+		{"ordering off", false,
+			map[string][]workloadJob{"shop/high": job("high-1"), "shop/low": job("low-1")},
+			[][]string{{"shop/high", "shop/low"}}},
+		{"lowest first", true,
+			map[string][]workloadJob{"shop/high": job("high-1"), "shop/mid-a": job("mid-1"), "shop/mid-b": job("mid-2"), "shop/low": job("low-1")},
+			[][]string{{"shop/low"}, {"shop/mid-a", "shop/mid-b"}, {"shop/high"}}},
+		{"workload takes its lowest pod", true,
+			map[string][]workloadJob{"shop/mixed": job("high-1", "low-1"), "shop/mid": job("mid-1")},
+			[][]string{{"shop/mixed"}, {"shop/mid"}}},
+		{"unknown pod counts as zero", true,
+			map[string][]workloadJob{"shop/gone": job("gone-1"), "shop/mid": job("mid-1")},
+			[][]string{{"shop/gone"}, {"shop/mid"}}},
+	}
+	for _, tt := range tests {
+		restartPriorityOrder = tt.order
+		if got := priorityTiers(tt.jobs); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: tiers = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -34,7 +34,12 @@ echo "[INFO] Deploying Node.js application..."
 kubectl apply -f manifests/apps/nodejs-app/deployment.yaml
 
 echo "[INFO] Deploying self-healing operator..."
+kubectl apply -f manifests/operator/crd-selfhealingstate.yaml
 kubectl apply -f manifests/operator/rbac.yaml
+kubectl apply -f manifests/operator/capacity-priorityclass.yaml
+kubectl apply -f manifests/operator/workflows-config.yaml
+kubectl apply -f manifests/operator/notifications-config.yaml
+kubectl apply -f manifests/operator/action-flags-config.yaml
 kubectl apply -f manifests/operator/deployment.yaml
 
 echo "[INFO] Waiting for pods to be ready (timeout: 5 minutes)..."
@@ -0,0 +1,80 @@
+#!/bin/bash
+
+# Self-Healing Infrastructure - Remediation Suppressions
+# Usage: ./scripts/suppress.sh [add|list|remove] ...
+#
+# Talks to the operator API. Port-forward it first:
+#   kubectl port-forward svc/self-healing-operator 8080:8080
+
+OPERATOR_URL="${OPERATOR_URL:-http://localhost:8080}"
+
+for tool in curl jq; do
+  if ! command -v "$tool" &> /dev/null; then
+    echo "[ERROR] $tool not found."
+    exit 1
+  fi
+done
+
+add_suppression() {
+  # add <alertname|-> <app|-> <duration> [namespace] [reason...]
+  ALERT="$1"; APP="$2"; DURATION="$3"; NAMESPACE="${4:-default}"
+  shift $(( $# < 4 ? $# : 4 ))
+  REASON="$*"
+  if [ -z "$DURATION" ]; then
+    echo "[ERROR] Usage: $0 add <alertname|-> <app|-> <duration> [namespace] [reason]"
+    exit 1
+  fi
+  [ "$ALERT" = "-" ] && ALERT=""
+  [ "$APP" = "-" ] && APP=""
+  BODY=$(jq -n --arg alertname "$ALERT" --arg app "$APP" --arg namespace "$NAMESPACE" \
+    --arg duration "$DURATION" --arg reason "$REASON" \
+    '{alertname: $alertname, app: $app, namespace: $namespace, duration: $duration, reason: $reason}')
+  if ! curl -fsS -X POST "$OPERATOR_URL/api/v1/suppressions" \
+    -H 'Content-Type: application/json' -d "$BODY"; then
+    echo "[ERROR] Could not add the suppression."
+    exit 1
+  fi
+  echo ""
+}
+
+list_suppressions() {
+  curl -fsS "$OPERATOR_URL/api/v1/suppressions" || exit 1
+  echo ""
+}
+
+remove_suppression() {
+  if [ -z "$1" ]; then
+    echo "[ERROR] Usage: $0 remove <id>"
+    exit 1
+  fi
+  if ! curl -fsS -o /dev/null -X DELETE "$OPERATOR_URL/api/v1/suppressions?id=$1"; then
+    echo "[ERROR] Suppression #$1 was not removed."
+    exit 1
+  fi
+  echo "[INFO] Suppression #$1 removed."
+}
+
+show_help() {
+  echo "Self-Healing Infrastructure - Remediation Suppressions"
+  echo ""
+  echo "Usage: $0 [COMMAND]"
+  echo ""
+  echo "Commands:"
+  echo "  add <alertname|-> <app|-> <duration> [namespace] [reason]"
+  echo "           Ignore an alert/app for a while, e.g. add PodCrashLooping payments-api 2h"
+  echo "  list     Show active suppressions"
+  echo "  remove <id>"
+  echo "           Remove a suppression before it expires"
+}
+
+case "${1:-help}" in
+  add)     shift; add_suppression "$@" ;;
+  list)    list_suppressions ;;
+  remove)  remove_suppression "$2" ;;
+  help|--help|-h) show_help ;;
+  *)
+    echo "[ERROR] Unknown command: $1"
+    show_help
+    exit 1
+    ;;
+esac